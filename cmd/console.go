@@ -20,13 +20,13 @@ import (
 
 // ConsoleConfiguration represents the configuration file for the console command.
 type ConsoleConfiguration struct {
-	Reporting  reporter.Configuration
-	HTTP       http.Configuration
-	Console    console.Configuration `mapstructure:",squash" yaml:",inline"`
-	ClickHouse clickhousedb.Configuration
-	Auth       authentication.Configuration
-	Database   database.Configuration
-	Schema     schema.Configuration
+	Reporting  reporter.Configuration       `doc:"Logging and metrics configuration"`
+	HTTP       http.Configuration           `doc:"HTTP server configuration"`
+	Console    console.Configuration        `mapstructure:",squash" yaml:",inline"`
+	ClickHouse clickhousedb.Configuration   `doc:"ClickHouse database configuration (overridden by the orchestrator)"`
+	Auth       authentication.Configuration `doc:"Authentication configuration"`
+	Database   database.Configuration       `doc:"Database to store user data"`
+	Schema     schema.Configuration         `doc:"Schema configuration (overridden by the orchestrator)"`
 }
 
 // Reset resets the console configuration to its default value.
@@ -19,12 +19,12 @@ import (
 
 // DemoExporterConfiguration represents the configuration file for the demo exporter command.
 type DemoExporterConfiguration struct {
-	Reporting    reporter.Configuration
-	HTTP         http.Configuration
+	Reporting    reporter.Configuration     `doc:"Logging and metrics configuration"`
+	HTTP         http.Configuration         `doc:"HTTP server configuration"`
 	DemoExporter demoexporter.Configuration `mapstructure:",squash" yaml:",inline"`
-	SNMP         snmp.Configuration
-	BMP          bmp.Configuration
-	Flows        flows.Configuration
+	SNMP         snmp.Configuration         `doc:"SNMP agent configuration"`
+	BMP          bmp.Configuration          `doc:"BMP client configuration"`
+	Flows        flows.Configuration        `doc:"Flows to generate"`
 }
 
 // Reset sets the default configuration for the demo exporter command.
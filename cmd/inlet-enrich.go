@@ -0,0 +1,154 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"akvorado/common/daemon"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/bmp"
+	"akvorado/inlet/core"
+	"akvorado/inlet/geoip"
+	"akvorado/inlet/snmp"
+)
+
+type inletEnrichOptions struct {
+	ConfigRelatedOptions
+	Timeout time.Duration
+}
+
+// InletEnrichOptions stores the command-line option values for the
+// inlet-enrich command.
+var InletEnrichOptions inletEnrichOptions
+
+var inletEnrichCmd = &cobra.Command{
+	Use:   "inlet-enrich",
+	Short: "Enrich a flow read from standard input",
+	Long: `Read a flow encoded as JSON from standard input, enrich it using the
+classification rules, the GeoIP databases and the SNMP pollers of the
+provided inlet configuration and display the result. The flow is not
+sent to Kafka.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		config := InletConfiguration{}
+		InletEnrichOptions.Path = args[0]
+		if err := InletEnrichOptions.Parse(cmd.ErrOrStderr(), "inlet", &config); err != nil {
+			return err
+		}
+		var flow schema.FlowMessage
+		if err := json.NewDecoder(cmd.InOrStdin()).Decode(&flow); err != nil {
+			return fmt.Errorf("unable to decode flow: %w", err)
+		}
+		if !flow.ExporterAddress.IsValid() {
+			return errors.New("flow has no exporter address")
+		}
+		if flow.TimeReceived == 0 {
+			flow.TimeReceived = uint64(time.Now().UTC().Unix())
+		}
+
+		r, err := reporter.New(config.Reporting)
+		if err != nil {
+			return fmt.Errorf("unable to initialize reporter: %w", err)
+		}
+		daemonComponent, err := daemon.New(r)
+		if err != nil {
+			return fmt.Errorf("unable to initialize daemon component: %w", err)
+		}
+		// The HTTP component is never started.
+		httpComponent, err := http.New(r, config.HTTP, http.Dependencies{
+			Daemon: daemonComponent,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to initialize http component: %w", err)
+		}
+		schemaComponent, err := schema.New(config.Schema)
+		if err != nil {
+			return fmt.Errorf("unable to initialize schema component: %w", err)
+		}
+		snmpComponent, err := snmp.New(r, config.SNMP, snmp.Dependencies{
+			Daemon: daemonComponent,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to initialize SNMP component: %w", err)
+		}
+		// The BMP component is not started either: there is no routing
+		// information to use.
+		bmpComponent, err := bmp.New(r, config.BMP, bmp.Dependencies{
+			Daemon: daemonComponent,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to initialize BMP component: %w", err)
+		}
+		geoipComponent, err := geoip.New(r, config.GeoIP, geoip.Dependencies{
+			Daemon: daemonComponent,
+			HTTP:   httpComponent,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to initialize GeoIP component: %w", err)
+		}
+		coreComponent, err := core.New(r, config.Core, core.Dependencies{
+			Daemon: daemonComponent,
+			SNMP:   snmpComponent,
+			BMP:    bmpComponent,
+			GeoIP:  geoipComponent,
+			HTTP:   httpComponent,
+			Schema: schemaComponent,
+		})
+		if err != nil {
+			return fmt.Errorf("unable to initialize core component: %w", err)
+		}
+
+		if err := snmpComponent.Start(); err != nil {
+			return fmt.Errorf("unable to start SNMP component: %w", err)
+		}
+		defer snmpComponent.Stop()
+		if err := geoipComponent.Start(); err != nil {
+			return fmt.Errorf("unable to start GeoIP component: %w", err)
+		}
+		defer geoipComponent.Stop()
+
+		ctx, cancel := context.WithTimeout(context.Background(), InletEnrichOptions.Timeout)
+		defer cancel()
+		skipped, err := coreComponent.DryRun(ctx, &flow)
+		if err != nil {
+			return fmt.Errorf("unable to enrich flow: %w", err)
+		}
+		if skipped {
+			return errors.New("flow would be dropped")
+		}
+
+		values, err := schemaComponent.ProtobufUnmarshal(schemaComponent.ProtobufMarshal(&flow))
+		if err != nil {
+			return fmt.Errorf("unable to decode enriched flow: %w", err)
+		}
+		output := map[string]interface{}{}
+		for key, value := range values {
+			column, ok := schemaComponent.LookupColumnByKey(key)
+			if !ok {
+				continue
+			}
+			output[column.Name] = value
+		}
+		encoder := json.NewEncoder(cmd.OutOrStdout())
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(output)
+	},
+}
+
+func init() {
+	RootCmd.AddCommand(inletEnrichCmd)
+	inletEnrichCmd.Flags().BoolVarP(&InletEnrichOptions.ConfigRelatedOptions.Dump, "dump", "D", false,
+		"Dump configuration before enriching")
+	inletEnrichCmd.Flags().DurationVarP(&InletEnrichOptions.Timeout, "timeout", "t", 5*time.Second,
+		"Maximum time to wait for SNMP polling")
+}
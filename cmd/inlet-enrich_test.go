@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package cmd_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"akvorado/cmd"
+	"akvorado/common/helpers"
+)
+
+func TestInletEnrich(t *testing.T) {
+	// Interfaces are fetched from a fake NetBox
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ipam/ip-addresses/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"count": 1, "results": [{"address": "192.0.2.142/32", "assigned_object_type": "dcim.interface", "assigned_object": {"id": 100, "device": {"id": 12, "name": "edge1.example.com"}}}]}`)
+	})
+	mux.HandleFunc("/api/dcim/devices/12/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": 12, "name": "edge1.example.com", "tenant": null, "tags": []}`)
+	})
+	mux.HandleFunc("/api/dcim/interfaces/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"count": 1, "next": null, "results": [
+{"name": "Gi0/0/0", "description": "Transit: Cogent", "speed": 10000000, "custom_fields": {"ifindex": 10}}]}`)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	config := filepath.Join(t.TempDir(), "inlet.yaml")
+	if err := os.WriteFile(config, []byte(`---
+http:
+  listen: 127.0.0.1:0
+snmp:
+  netbox:
+    url: `+server.URL+`
+    token: secret
+schema:
+  custom-dimensions:
+    - name: ExporterEnvironment
+    - name: ExporterRack
+core:
+  exporter-tags:
+    environment: production
+    rack: r12
+`), 0o644); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+
+	root := cmd.RootCmd
+	buf := new(bytes.Buffer)
+	root.SetOut(buf)
+	root.SetIn(strings.NewReader(`{"ExporterAddress": "::ffff:192.0.2.142", "InIf": 10, "SamplingRate": 1000, "SrcAddr": "::ffff:203.0.113.1", "DstAddr": "::ffff:203.0.113.2"}`))
+	root.SetArgs([]string{"inlet-enrich", "--timeout", "5s", config})
+	if err := root.Execute(); err != nil {
+		t.Fatalf("`inlet-enrich` error:\n%+v", err)
+	}
+
+	var got map[string]interface{}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal() error:\n%+v", err)
+	}
+	// Only check a subset of the output
+	subset := map[string]interface{}{}
+	for _, name := range []string{"ExporterAddress", "ExporterName", "InIfName", "ExporterEnvironment", "ExporterRack"} {
+		subset[name] = got[name]
+	}
+	expected := map[string]interface{}{
+		"ExporterAddress":     "::ffff:192.0.2.142",
+		"ExporterName":        "edge1.example.com",
+		"InIfName":            "Gi0/0/0",
+		"ExporterEnvironment": "production",
+		"ExporterRack":        "r12",
+	}
+	if diff := helpers.Diff(subset, expected); diff != "" {
+		t.Fatalf("`inlet-enrich` (-got, +want):\n%s", diff)
+	}
+}
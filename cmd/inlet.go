@@ -5,6 +5,8 @@ package cmd
 
 import (
 	"fmt"
+	"net/url"
+	"strings"
 
 	"github.com/spf13/cobra"
 
@@ -22,15 +24,15 @@ import (
 
 // InletConfiguration represents the configuration file for the inlet command.
 type InletConfiguration struct {
-	Reporting reporter.Configuration
-	HTTP      http.Configuration
-	Flow      flow.Configuration
-	SNMP      snmp.Configuration
-	BMP       bmp.Configuration
-	GeoIP     geoip.Configuration
-	Kafka     kafka.Configuration
-	Core      core.Configuration
-	Schema    schema.Configuration
+	Reporting reporter.Configuration `doc:"Logging and metrics configuration"`
+	HTTP      http.Configuration     `doc:"HTTP server configuration"`
+	Flow      flow.Configuration     `doc:"Reception and decoding of flows"`
+	SNMP      snmp.Configuration     `doc:"SNMP poller configuration"`
+	BMP       bmp.Configuration      `doc:"BMP collector configuration"`
+	GeoIP     geoip.Configuration    `doc:"GeoIP databases configuration"`
+	Kafka     kafka.Configuration    `doc:"Kafka producer configuration"`
+	Core      core.Configuration     `doc:"Flow enrichment and classification"`
+	Schema    schema.Configuration   `doc:"Schema configuration (overridden by the orchestrator)"`
 }
 
 // Reset resets the configuration for the inlet command to its default value.
@@ -69,6 +71,13 @@ enrichment and export to Kafka.`,
 		if err := InletOptions.Parse(cmd.OutOrStdout(), "inlet", &config); err != nil {
 			return err
 		}
+		if strings.HasPrefix(InletOptions.Path, "http://") || strings.HasPrefix(InletOptions.Path, "https://") {
+			u, err := url.Parse(InletOptions.Path)
+			if err != nil {
+				return fmt.Errorf("cannot parse configuration URL: %w", err)
+			}
+			config.Core.OrchestratorURL = fmt.Sprintf("%s://%s", u.Scheme, u.Host)
+		}
 
 		r, err := reporter.New(config.Reporting)
 		if err != nil {
@@ -124,6 +133,7 @@ func inletStart(r *reporter.Reporter, config InletConfiguration, checkOnly bool)
 	}
 	geoipComponent, err := geoip.New(r, config.GeoIP, geoip.Dependencies{
 		Daemon: daemonComponent,
+		HTTP:   httpComponent,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to initialize GeoIP component: %w", err)
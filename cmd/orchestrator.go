@@ -20,17 +20,17 @@ import (
 
 // OrchestratorConfiguration represents the configuration file for the orchestrator command.
 type OrchestratorConfiguration struct {
-	Reporting    reporter.Configuration
-	HTTP         http.Configuration
+	Reporting    reporter.Configuration     `doc:"Logging and metrics configuration"`
+	HTTP         http.Configuration         `doc:"HTTP server configuration"`
 	ClickHouseDB clickhousedb.Configuration `yaml:"-"`
-	ClickHouse   clickhouse.Configuration
-	Kafka        kafka.Configuration
+	ClickHouse   clickhouse.Configuration   `doc:"ClickHouse database configuration"`
+	Kafka        kafka.Configuration        `doc:"Kafka topic configuration"`
 	Orchestrator orchestrator.Configuration `mapstructure:",squash" yaml:",inline"`
-	Schema       schema.Configuration
+	Schema       schema.Configuration       `doc:"Schema configuration, shared with the other services"`
 	// Other service configurations
-	Inlet        []InletConfiguration        `validate:"dive"`
-	Console      []ConsoleConfiguration      `validate:"dive"`
-	DemoExporter []DemoExporterConfiguration `validate:"dive"`
+	Inlet        []InletConfiguration        `validate:"dive" doc:"Configuration of the inlet services"`
+	Console      []ConsoleConfiguration      `validate:"dive" doc:"Configuration of the console services"`
+	DemoExporter []DemoExporterConfiguration `validate:"dive" doc:"Configuration of the demo exporters"`
 }
 
 // Reset resets the configuration of the orchestrator command to its default value.
@@ -110,6 +110,9 @@ func orchestratorStart(r *reporter.Reporter, config OrchestratorConfiguration, c
 	if err != nil {
 		return fmt.Errorf("unable to initialize daemon component: %w", err)
 	}
+	defaultConfiguration := OrchestratorConfiguration{}
+	defaultConfiguration.Reset()
+	daemonComponent.RegisterConfiguration("orchestrator", defaultConfiguration)
 	httpComponent, err := http.New(r, config.HTTP, http.Dependencies{
 		Daemon: daemonComponent,
 	})
@@ -140,14 +143,18 @@ func orchestratorStart(r *reporter.Reporter, config OrchestratorConfiguration, c
 		return fmt.Errorf("unable to initialize clickhouse component: %w", err)
 	}
 	orchestratorComponent, err := orchestrator.New(r, config.Orchestrator, orchestrator.Dependencies{
-		HTTP: httpComponent,
+		Daemon: daemonComponent,
+		HTTP:   httpComponent,
 	})
 	if err != nil {
 		return fmt.Errorf("unable to initialize orchestrator component: %w", err)
 	}
 	for idx := range config.Inlet {
 		orchestratorComponent.RegisterConfiguration(orchestrator.InletService, config.Inlet[idx])
+		clickhouseComponent.RegisterChangelogSubject(clickhouse.ChangelogKindRules,
+			fmt.Sprintf("inlet/%d", idx), config.Inlet[idx].Core.RuleSet())
 	}
+	clickhouseComponent.RegisterChangelogSubject(clickhouse.ChangelogKindSchema, "schema", config.Schema)
 	for idx := range config.Console {
 		orchestratorComponent.RegisterConfiguration(orchestrator.ConsoleService, config.Console[idx])
 	}
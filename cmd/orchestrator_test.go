@@ -14,6 +14,7 @@ import (
 
 	"akvorado/common/helpers"
 	"akvorado/common/reporter"
+	"akvorado/orchestrator"
 
 	"akvorado/common/helpers/yaml"
 )
@@ -98,3 +99,13 @@ func TestOrchestratorConfig(t *testing.T) {
 		})
 	}
 }
+
+func TestOrchestratorConfigurationDocumentation(t *testing.T) {
+	config := OrchestratorConfiguration{}
+	config.Reset()
+	for _, key := range orchestrator.DescribeConfiguration(config) {
+		if key.Doc == "" {
+			t.Errorf("configuration key %q is not documented", key.Key)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhousedb
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChangelogTable is the table storing the changes affecting the semantics of
+// the data (classification rules, schema, migrations). It is created and
+// filled by the orchestrator and read by the consoles.
+const ChangelogTable = "changelog"
+
+// ChangelogEntry is one change recorded in the changelog.
+type ChangelogEntry struct {
+	Time        time.Time `ch:"time" json:"time"`
+	Kind        string    `ch:"kind" json:"kind"`
+	Subject     string    `ch:"subject" json:"subject"`
+	Author      string    `ch:"author" json:"author"`
+	Hash        string    `ch:"hash" json:"hash"`
+	Description string    `ch:"description" json:"description,omitempty"`
+	Diff        string    `ch:"diff" json:"diff,omitempty"`
+	// Content is the complete new value. It is only used to compute the
+	// diff with the next change.
+	Content string `ch:"content" json:"-"`
+}
+
+// ChangelogQuery selects entries from the changelog. Zero values are
+// ignored.
+type ChangelogQuery struct {
+	Start   time.Time
+	End     time.Time
+	Kinds   []string
+	Subject string
+	Limit   int
+	// WithContent also retrieves the content of each change
+	WithContent bool
+}
+
+// Changelog returns the entries of the changelog matching the provided query,
+// most recent first.
+func (c *Component) Changelog(ctx context.Context, query ChangelogQuery) ([]ChangelogEntry, error) {
+	columns := "time, kind, subject, author, hash, description, diff"
+	if query.WithContent {
+		columns += ", content"
+	}
+	conditions := []string{}
+	args := []interface{}{}
+	if !query.Start.IsZero() {
+		args = append(args, query.Start)
+		conditions = append(conditions, fmt.Sprintf("time >= $%d", len(args)))
+	}
+	if !query.End.IsZero() {
+		args = append(args, query.End)
+		conditions = append(conditions, fmt.Sprintf("time <= $%d", len(args)))
+	}
+	if len(query.Kinds) > 0 {
+		args = append(args, query.Kinds)
+		conditions = append(conditions, fmt.Sprintf("has($%d, kind)", len(args)))
+	}
+	if query.Subject != "" {
+		args = append(args, query.Subject)
+		conditions = append(conditions, fmt.Sprintf("subject = $%d", len(args)))
+	}
+	where := ""
+	if len(conditions) > 0 {
+		where = fmt.Sprintf("\nWHERE %s", strings.Join(conditions, " AND "))
+	}
+	limit := ""
+	if query.Limit > 0 {
+		limit = fmt.Sprintf("\nLIMIT %d", query.Limit)
+	}
+
+	results := []ChangelogEntry{}
+	if err := c.Select(ctx, &results, fmt.Sprintf(`
+SELECT %s
+FROM %s%s
+ORDER BY time DESC%s`, columns, ChangelogTable, where, limit), args...); err != nil {
+		return nil, fmt.Errorf("cannot get changelog: %w", err)
+	}
+	return results, nil
+}
+
+// AddChangelogEntry records a new entry in the changelog.
+func (c *Component) AddChangelogEntry(ctx context.Context, entry ChangelogEntry) error {
+	if err := c.Exec(ctx, fmt.Sprintf(`
+INSERT INTO %s (time, kind, subject, author, hash, description, diff, content)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`, ChangelogTable),
+		entry.Time, entry.Kind, entry.Subject, entry.Author, entry.Hash,
+		entry.Description, entry.Diff, entry.Content); err != nil {
+		return fmt.Errorf("cannot add changelog entry: %w", err)
+	}
+	return nil
+}
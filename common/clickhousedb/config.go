@@ -9,27 +9,32 @@ import (
 
 // Configuration defines how we connect to a ClickHouse database
 type Configuration struct {
-	// Servers define the list of clickhouse servers to connect to (with ports)
-	Servers []string `validate:"min=1,dive,listen"`
+	// Servers define the list of clickhouse servers to connect to (with
+	// ports). They can be prefixed with "dns+" or "dnssrv+" to be resolved
+	// periodically.
+	Servers []string `validate:"min=1,dive,endpoint" doc:"ClickHouse servers to connect to (with ports, dns+ or dnssrv+ prefixes for discovery)"`
+	// ResolveInterval tells how often to resolve servers using DNS.
+	ResolveInterval time.Duration `validate:"min=0" doc:"Interval between DNS resolutions of dns+ and dnssrv+ servers (0 to resolve only at startup)"`
 	// Database defines the database to use
-	Database string `validate:"required"`
+	Database string `validate:"required" doc:"Database to use"`
 	// Username defines the username to use for authentication
-	Username string `validate:"required"`
+	Username string `validate:"required" doc:"Username for authentication"`
 	// Password defines the password to use for authentication
-	Password string
+	Password string `doc:"Password for authentication"`
 	// MaxOpenConns tells how many parallel connections to ClickHouse we want
-	MaxOpenConns int `validate:"min=1"`
+	MaxOpenConns int `validate:"min=1" doc:"Maximum number of parallel connections to ClickHouse"`
 	// DialTimeout tells how much time to wait when connecting to ClickHouse
-	DialTimeout time.Duration `validate:"min=100ms"`
+	DialTimeout time.Duration `validate:"min=100ms" doc:"Timeout when connecting to ClickHouse"`
 }
 
 // DefaultConfiguration represents the default configuration for connecting to ClickHouse
 func DefaultConfiguration() Configuration {
 	return Configuration{
-		Servers:      []string{"127.0.0.1:9000"},
-		Database:     "default",
-		Username:     "default",
-		MaxOpenConns: 10,
-		DialTimeout:  5 * time.Second,
+		Servers:         []string{"127.0.0.1:9000"},
+		Database:        "default",
+		Username:        "default",
+		MaxOpenConns:    10,
+		DialTimeout:     5 * time.Second,
+		ResolveInterval: time.Minute,
 	}
 }
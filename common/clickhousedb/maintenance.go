@@ -0,0 +1,53 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhousedb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// MaintenanceTable is the table storing the maintenance status. It is created
+// by the orchestrator and read by the consoles.
+const MaintenanceTable = "maintenance"
+
+// MaintenanceStatus describes the maintenance status of the database. Each
+// update is a new row, the last one being the current status.
+type MaintenanceStatus struct {
+	Active      bool       `ch:"active" json:"active"`
+	Reason      string     `ch:"reason" json:"reason,omitempty"`
+	Since       time.Time  `ch:"since" json:"since"`
+	ExpectedEnd *time.Time `ch:"expected_end" json:"expected-end,omitempty"`
+	StepsDone   uint32     `ch:"steps_done" json:"steps-done,omitempty"`
+	StepsTotal  uint32     `ch:"steps_total" json:"steps-total,omitempty"`
+}
+
+// Maintenance returns the current maintenance status of the database.
+func (c *Component) Maintenance(ctx context.Context) (MaintenanceStatus, error) {
+	var results []MaintenanceStatus
+	if err := c.Select(ctx, &results, fmt.Sprintf(`
+SELECT active, reason, since, expected_end, steps_done, steps_total
+FROM %s
+ORDER BY updated DESC
+LIMIT 1`, MaintenanceTable)); err != nil {
+		return MaintenanceStatus{}, fmt.Errorf("cannot get maintenance status: %w", err)
+	}
+	if len(results) == 0 {
+		return MaintenanceStatus{}, nil
+	}
+	return results[0], nil
+}
+
+// SetMaintenance records a new maintenance status for the database.
+func (c *Component) SetMaintenance(ctx context.Context, status MaintenanceStatus) error {
+	if err := c.Exec(ctx, fmt.Sprintf(`
+INSERT INTO %s (active, reason, since, expected_end, steps_done, steps_total)
+VALUES ($1, $2, $3, $4, $5, $6)`, MaintenanceTable),
+		status.Active, status.Reason, status.Since, status.ExpectedEnd,
+		status.StepsDone, status.StepsTotal); err != nil {
+		return fmt.Errorf("cannot set maintenance status: %w", err)
+	}
+	return nil
+}
@@ -6,12 +6,14 @@ package clickhousedb
 
 import (
 	"context"
+	"net"
 	"time"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/daemon"
+	"akvorado/common/discovery"
 	"akvorado/common/reporter"
 )
 
@@ -25,7 +27,8 @@ type Component struct {
 	d      *Dependencies
 	config Configuration
 
-	healthy chan reporter.ChannelHealthcheckFunc
+	healthy   chan reporter.ChannelHealthcheckFunc
+	discovery *discovery.Resolver
 	clickhouse.Conn
 }
 
@@ -36,8 +39,13 @@ type Dependencies struct {
 
 // New creates a new ClickHouse wrapper
 func New(r *reporter.Reporter, config Configuration, dependencies Dependencies) (*Component, error) {
-	conn, err := clickhouse.Open(&clickhouse.Options{
-		Addr: config.Servers,
+	resolver, err := discovery.New(r, config.Servers, config.ResolveInterval)
+	if err != nil {
+		return nil, err
+	}
+	resolver.Dialer.Timeout = config.DialTimeout
+	options := clickhouse.Options{
+		Addr: resolver.Addresses(),
 		Auth: clickhouse.Auth{
 			Database: config.Database,
 			Username: config.Username,
@@ -56,7 +64,13 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 				{Name: "akvorado", Version: AkvoradoVersion},
 			},
 		},
-	})
+	}
+	if resolver.Dynamic() {
+		options.DialContext = func(ctx context.Context, addr string) (net.Conn, error) {
+			return resolver.DialContext(ctx, "tcp", addr)
+		}
+	}
+	conn, err := clickhouse.Open(&options)
 	if err != nil {
 		return nil, err
 	}
@@ -66,8 +80,9 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 		d:      &dependencies,
 		config: config,
 
-		healthy: make(chan reporter.ChannelHealthcheckFunc),
-		Conn:    conn,
+		healthy:   make(chan reporter.ChannelHealthcheckFunc),
+		discovery: resolver,
+		Conn:      conn,
 	}
 	c.d.Daemon.Track(&c.t, "common/clickhousedb")
 	return &c, nil
@@ -76,6 +91,9 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 // Start initializes the connection to ClickHouse
 func (c *Component) Start() error {
 	c.r.Info().Msg("starting ClickHouse component")
+	if err := c.discovery.Start(); err != nil {
+		return err
+	}
 
 	c.r.RegisterHealthcheck("clickhousedb", c.channelHealthcheck())
 	c.t.Go(func() error {
@@ -105,6 +123,7 @@ func (c *Component) Stop() error {
 	c.r.Info().Msg("stopping ClickHouse component")
 	defer func() {
 		c.Close()
+		c.discovery.Stop()
 		c.r.Info().Msg("ClickHouse component stopped")
 	}()
 	c.t.Kill(nil)
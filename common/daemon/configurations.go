@@ -0,0 +1,37 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package daemon
+
+import (
+	"sync"
+)
+
+// configurationsComponent is the part of a component keeping track of the
+// registered configurations.
+type configurationsComponent struct {
+	configurationsLock sync.Mutex
+	configurations     map[string]interface{}
+}
+
+// RegisterConfiguration registers the default configuration of a service.
+// This is used to document the available configuration keys.
+func (c *configurationsComponent) RegisterConfiguration(name string, defaultConfiguration interface{}) {
+	c.configurationsLock.Lock()
+	defer c.configurationsLock.Unlock()
+	if c.configurations == nil {
+		c.configurations = map[string]interface{}{}
+	}
+	c.configurations[name] = defaultConfiguration
+}
+
+// Configurations returns the registered default configurations.
+func (c *configurationsComponent) Configurations() map[string]interface{} {
+	c.configurationsLock.Lock()
+	defer c.configurationsLock.Unlock()
+	result := make(map[string]interface{}, len(c.configurations))
+	for name, configuration := range c.configurations {
+		result[name] = configuration
+	}
+	return result
+}
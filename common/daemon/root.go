@@ -25,6 +25,10 @@ type Component interface {
 	// Lifecycle
 	Terminated() <-chan struct{}
 	Terminate()
+
+	// Configurations
+	RegisterConfiguration(name string, defaultConfiguration interface{})
+	Configurations() map[string]interface{}
 }
 
 // realComponent is a non-mock implementation of the Component
@@ -34,6 +38,7 @@ type realComponent struct {
 	tombs []tombWithOrigin
 
 	lifecycleComponent
+	configurationsComponent
 }
 
 // tombWithOrigin stores a reference to a tomb and its origin
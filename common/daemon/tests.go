@@ -15,10 +15,11 @@ import (
 // need to be started to work.
 type MockComponent struct {
 	lifecycleComponent
+	configurationsComponent
 }
 
 // NewMock will create a daemon component that does nothing.
-func NewMock(t *testing.T) Component {
+func NewMock(t testing.TB) Component {
 	t.Helper()
 	return &MockComponent{
 		lifecycleComponent: lifecycleComponent{
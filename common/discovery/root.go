@@ -0,0 +1,452 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package discovery keeps the addresses of remote endpoints up-to-date using
+// DNS. Two kinds of dynamic endpoints are supported:
+//
+//   - "dns+host:port" resolves host with A/AAAA records,
+//   - "dnssrv+_service._proto.name" resolves name with SRV records.
+//
+// Other endpoints are used as is. Clients are given a stable address for
+// each endpoint and should use the provided dialer to connect to one of the
+// current addresses.
+package discovery
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	"github.com/go-playground/validator/v10"
+	"golang.org/x/exp/slices"
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+const (
+	dnsPrefix    = "dns+"
+	dnssrvPrefix = "dnssrv+"
+)
+
+// Resolver resolves a set of endpoints and provides a dialer to connect to
+// them.
+type Resolver struct {
+	r         *reporter.Reporter
+	t         tomb.Tomb
+	interval  time.Duration
+	endpoints []endpoint
+	lookup    lookuper
+	// retryInterval is the initial interval between two attempts to
+	// resolve endpoints never resolved when not refreshing periodically.
+	retryInterval time.Duration
+
+	// Dialer is used to establish connections.
+	Dialer net.Dialer
+
+	lock       sync.Mutex
+	candidates map[string][]string
+	next       map[string]int
+	conns      map[*trackedConn]struct{}
+
+	metrics struct {
+		addresses *reporter.GaugeVec
+		refreshes *reporter.CounterVec
+		errors    *reporter.CounterVec
+		closed    *reporter.CounterVec
+	}
+}
+
+// endpoint is a parsed endpoint.
+type endpoint struct {
+	// original is the endpoint as configured
+	original string
+	// address is the address given to clients
+	address string
+	// name is the name to resolve (empty for static endpoints)
+	name string
+	// port is the port to use with A/AAAA records
+	port string
+	srv  bool
+}
+
+// lookuper resolves names. It is implemented by net.Resolver.
+type lookuper interface {
+	LookupHost(ctx context.Context, host string) ([]string, error)
+	LookupSRV(ctx context.Context, service, proto, name string) (string, []*net.SRV, error)
+}
+
+// parseEndpoint parses an endpoint.
+func parseEndpoint(original string) (endpoint, error) {
+	switch {
+	case strings.HasPrefix(original, dnsPrefix):
+		host, port, err := net.SplitHostPort(strings.TrimPrefix(original, dnsPrefix))
+		if err != nil {
+			return endpoint{}, fmt.Errorf("invalid endpoint %q: %w", original, err)
+		}
+		if host == "" {
+			return endpoint{}, fmt.Errorf("invalid endpoint %q: missing host", original)
+		}
+		return endpoint{
+			original: original,
+			address:  net.JoinHostPort(host, port),
+			name:     host,
+			port:     port,
+		}, nil
+	case strings.HasPrefix(original, dnssrvPrefix):
+		name := strings.TrimPrefix(original, dnssrvPrefix)
+		labels := strings.SplitN(name, ".", 3)
+		if len(labels) != 3 || !strings.HasPrefix(labels[0], "_") || !strings.HasPrefix(labels[1], "_") {
+			return endpoint{}, fmt.Errorf("invalid endpoint %q: expected _service._proto.name", original)
+		}
+		// The address given to clients is the domain, which is also the
+		// name expected for TLS.
+		return endpoint{
+			original: original,
+			address:  net.JoinHostPort(labels[2], "0"),
+			name:     name,
+			srv:      true,
+		}, nil
+	}
+	return endpoint{original: original, address: original}, nil
+}
+
+// isEndpoint validates an endpoint (a <dns>:<port> combination, optionally
+// prefixed by "dns+", or a "dnssrv+" name).
+func isEndpoint(fl validator.FieldLevel) bool {
+	val := fl.Field().String()
+	if strings.HasPrefix(val, dnssrvPrefix) {
+		_, err := parseEndpoint(val)
+		return err == nil
+	}
+	return helpers.Validate.Var(strings.TrimPrefix(val, dnsPrefix), "listen") == nil
+}
+
+func init() {
+	helpers.Validate.RegisterValidation("endpoint", isEndpoint)
+}
+
+// Expand returns a static list of addresses for the provided endpoints:
+// "dns+" endpoints lose their prefix and "dnssrv+" endpoints are replaced by
+// their current targets. This is useful for third-party clients doing their
+// own resolution.
+func Expand(ctx context.Context, endpoints []string) ([]string, error) {
+	return expand(ctx, net.DefaultResolver, endpoints)
+}
+
+func expand(ctx context.Context, lookup lookuper, endpoints []string) ([]string, error) {
+	r := Resolver{lookup: lookup}
+	result := []string{}
+	for _, original := range endpoints {
+		e, err := parseEndpoint(original)
+		if err != nil {
+			return nil, err
+		}
+		if !e.srv {
+			result = append(result, e.address)
+			continue
+		}
+		addresses, err := r.resolve(ctx, e)
+		if err != nil {
+			return nil, fmt.Errorf("cannot resolve %s: %w", original, err)
+		}
+		result = append(result, addresses...)
+	}
+	return result, nil
+}
+
+// New creates a new resolver for the provided endpoints. Dynamic endpoints
+// are resolved again at the provided interval (0 means they are only resolved
+// when starting).
+func New(r *reporter.Reporter, endpoints []string, interval time.Duration) (*Resolver, error) {
+	resolver := Resolver{
+		r:             r,
+		interval:      interval,
+		lookup:        net.DefaultResolver,
+		retryInterval: time.Second,
+		candidates:    map[string][]string{},
+		next:          map[string]int{},
+		conns:         map[*trackedConn]struct{}{},
+	}
+	for _, original := range endpoints {
+		e, err := parseEndpoint(original)
+		if err != nil {
+			return nil, err
+		}
+		resolver.endpoints = append(resolver.endpoints, e)
+		if e.name != "" {
+			resolver.candidates[e.address] = []string{}
+		}
+	}
+
+	resolver.metrics.addresses = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "addresses",
+			Help: "Number of addresses for a dynamic endpoint.",
+		}, []string{"endpoint"})
+	resolver.metrics.refreshes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "refreshes_total",
+			Help: "Number of successful resolutions for a dynamic endpoint.",
+		}, []string{"endpoint"})
+	resolver.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors_total",
+			Help: "Number of failed resolutions for a dynamic endpoint.",
+		}, []string{"endpoint"})
+	resolver.metrics.closed = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "closed_connections_total",
+			Help: "Number of connections closed because their address disappeared.",
+		}, []string{"endpoint"})
+	return &resolver, nil
+}
+
+// Addresses returns the addresses to provide to clients, one for each
+// endpoint.
+func (r *Resolver) Addresses() []string {
+	addresses := make([]string, len(r.endpoints))
+	for i, e := range r.endpoints {
+		addresses[i] = e.address
+	}
+	return addresses
+}
+
+// Dynamic tells if some endpoints need to be resolved.
+func (r *Resolver) Dynamic() bool {
+	return len(r.candidates) > 0
+}
+
+// String returns a description of the resolver.
+func (r *Resolver) String() string {
+	endpoints := make([]string, len(r.endpoints))
+	for i, e := range r.endpoints {
+		endpoints[i] = e.original
+	}
+	return fmt.Sprintf("discovery(%s)", strings.Join(endpoints, ","))
+}
+
+// Start resolves the dynamic endpoints and keeps them up-to-date. When they
+// are not refreshed periodically, endpoints whose initial resolution failed
+// are retried with an exponential backoff until they are resolved.
+func (r *Resolver) Start() error {
+	if r.Dynamic() {
+		r.refresh(false)
+	}
+	r.t.Go(func() error {
+		if !r.Dynamic() {
+			<-r.t.Dying()
+			return nil
+		}
+		if r.interval == 0 {
+			customBackoff := backoff.NewExponentialBackOff()
+			customBackoff.MaxElapsedTime = 0
+			customBackoff.InitialInterval = r.retryInterval
+			customBackoff.MaxInterval = time.Minute
+			ticker := backoff.NewTicker(customBackoff)
+			defer ticker.Stop()
+			for r.unresolved() {
+				select {
+				case <-r.t.Dying():
+					return nil
+				case <-ticker.C:
+					r.refresh(true)
+				}
+			}
+			ticker.Stop()
+			<-r.t.Dying()
+			return nil
+		}
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.t.Dying():
+				return nil
+			case <-ticker.C:
+				r.refresh(false)
+			}
+		}
+	})
+	return nil
+}
+
+// Stop stops refreshing the dynamic endpoints.
+func (r *Resolver) Stop() error {
+	r.t.Kill(nil)
+	return r.t.Wait()
+}
+
+// unresolved tells if some dynamic endpoints were never resolved.
+func (r *Resolver) unresolved() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for _, candidates := range r.candidates {
+		if len(candidates) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// refresh resolves the dynamic endpoints, or only the ones never resolved
+// when requested. On error, the previous addresses are kept.
+func (r *Resolver) refresh(onlyUnresolved bool) {
+	for _, e := range r.endpoints {
+		if e.name == "" {
+			continue
+		}
+		if onlyUnresolved {
+			r.lock.Lock()
+			resolved := len(r.candidates[e.address]) > 0
+			r.lock.Unlock()
+			if resolved {
+				continue
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.t.Context(nil), 10*time.Second)
+		addresses, err := r.resolve(ctx, e)
+		cancel()
+		if err != nil {
+			r.r.Err(err).Str("endpoint", e.original).Msg("cannot resolve endpoint, keep previous addresses")
+			r.metrics.errors.WithLabelValues(e.original).Inc()
+			continue
+		}
+		r.metrics.refreshes.WithLabelValues(e.original).Inc()
+		r.metrics.addresses.WithLabelValues(e.original).Set(float64(len(addresses)))
+		r.update(e, addresses)
+	}
+}
+
+// resolve resolves a dynamic endpoint to a sorted list of addresses.
+func (r *Resolver) resolve(ctx context.Context, e endpoint) ([]string, error) {
+	addresses := []string{}
+	if e.srv {
+		_, records, err := r.lookup.LookupSRV(ctx, "", "", e.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, record := range records {
+			addresses = append(addresses,
+				net.JoinHostPort(strings.TrimSuffix(record.Target, "."), fmt.Sprint(record.Port)))
+		}
+	} else {
+		hosts, err := r.lookup.LookupHost(ctx, e.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, host := range hosts {
+			addresses = append(addresses, net.JoinHostPort(host, e.port))
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, errors.New("no address found")
+	}
+	sort.Strings(addresses)
+	return slices.Compact(addresses), nil
+}
+
+// update replaces the addresses for an endpoint and closes the connections
+// to addresses which have disappeared.
+func (r *Resolver) update(e endpoint, addresses []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if slices.Equal(r.candidates[e.address], addresses) {
+		return
+	}
+	r.r.Info().
+		Str("endpoint", e.original).
+		Strs("addresses", addresses).
+		Msg("endpoint addresses updated")
+	r.candidates[e.address] = addresses
+	for conn := range r.conns {
+		if conn.endpoint != e.address || slices.Contains(addresses, conn.candidate) {
+			continue
+		}
+		r.r.Debug().
+			Str("endpoint", e.original).
+			Str("address", conn.candidate).
+			Msg("close connection to vanished address")
+		delete(r.conns, conn)
+		conn.Conn.Close()
+		r.metrics.closed.WithLabelValues(e.original).Inc()
+	}
+}
+
+// DialContext connects to the provided address. When the address is one of
+// the dynamic endpoints, the current addresses are tried in turn, starting
+// from a different one on each call.
+func (r *Resolver) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	r.lock.Lock()
+	candidates, ok := r.candidates[address]
+	start := r.next[address]
+	r.next[address]++
+	r.lock.Unlock()
+	if !ok {
+		return r.Dialer.DialContext(ctx, network, address)
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no address available for %s", address)
+	}
+	var err error
+	for i := range candidates {
+		candidate := candidates[(start+i)%len(candidates)]
+		var conn net.Conn
+		conn, err = r.Dialer.DialContext(ctx, network, candidate)
+		if err == nil {
+			return r.track(address, candidate, conn), nil
+		}
+	}
+	return nil, err
+}
+
+// Dial connects to the provided address. See DialContext.
+func (r *Resolver) Dial(network, address string) (net.Conn, error) {
+	return r.DialContext(context.Background(), network, address)
+}
+
+// trackedConn is a connection to a dynamic endpoint.
+type trackedConn struct {
+	net.Conn
+	r         *Resolver
+	endpoint  string
+	candidate string
+}
+
+func (r *Resolver) track(endpoint, candidate string, conn net.Conn) net.Conn {
+	tc := &trackedConn{
+		Conn:      conn,
+		r:         r,
+		endpoint:  endpoint,
+		candidate: candidate,
+	}
+	r.lock.Lock()
+	r.conns[tc] = struct{}{}
+	r.lock.Unlock()
+	return tc
+}
+
+// Close closes the connection.
+func (tc *trackedConn) Close() error {
+	tc.r.lock.Lock()
+	delete(tc.r.conns, tc)
+	tc.r.lock.Unlock()
+	return tc.Conn.Close()
+}
+
+// SyscallConn returns the raw connection. Some clients use it to check if a
+// connection is still alive.
+func (tc *trackedConn) SyscallConn() (syscall.RawConn, error) {
+	if sc, ok := tc.Conn.(syscall.Conn); ok {
+		return sc.SyscallConn()
+	}
+	return nil, errors.New("raw connection not available")
+}
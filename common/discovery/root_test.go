@@ -0,0 +1,309 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package discovery
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+type fakeLookup struct {
+	lock  sync.Mutex
+	hosts map[string][]string
+	srv   map[string][]*net.SRV
+}
+
+func (fl *fakeLookup) LookupHost(_ context.Context, host string) ([]string, error) {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+	if hosts, ok := fl.hosts[host]; ok {
+		return hosts, nil
+	}
+	return nil, errors.New("no such host")
+}
+
+func (fl *fakeLookup) LookupSRV(_ context.Context, service, proto, name string) (string, []*net.SRV, error) {
+	fl.lock.Lock()
+	defer fl.lock.Unlock()
+	if records, ok := fl.srv[name]; ok {
+		return name, records, nil
+	}
+	return "", nil, errors.New("no such host")
+}
+
+func TestParseEndpoint(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected endpoint
+		Error    bool
+	}{
+		{
+			Input:    "127.0.0.1:9092",
+			Expected: endpoint{original: "127.0.0.1:9092", address: "127.0.0.1:9092"},
+		}, {
+			Input: "dns+kafka:9092",
+			Expected: endpoint{
+				original: "dns+kafka:9092",
+				address:  "kafka:9092",
+				name:     "kafka",
+				port:     "9092",
+			},
+		}, {
+			Input: "dnssrv+_kafka._tcp.kafka.example.com",
+			Expected: endpoint{
+				original: "dnssrv+_kafka._tcp.kafka.example.com",
+				address:  "kafka.example.com:0",
+				name:     "_kafka._tcp.kafka.example.com",
+				srv:      true,
+			},
+		},
+		{Input: "dns+kafka", Error: true},
+		{Input: "dns+:9092", Error: true},
+		{Input: "dnssrv+kafka.example.com", Error: true},
+	}
+	for _, tc := range cases {
+		got, err := parseEndpoint(tc.Input)
+		if err != nil && !tc.Error {
+			t.Errorf("parseEndpoint(%q) error:\n%+v", tc.Input, err)
+			continue
+		} else if err == nil && tc.Error {
+			t.Errorf("parseEndpoint(%q) did not error", tc.Input)
+			continue
+		}
+		if diff := helpers.Diff(got, tc.Expected); !tc.Error && diff != "" {
+			t.Errorf("parseEndpoint(%q) (-got, +want):\n%s", tc.Input, diff)
+		}
+	}
+}
+
+func TestEndpointValidator(t *testing.T) {
+	s := struct {
+		Endpoint string `validate:"endpoint"`
+	}{}
+	cases := []struct {
+		Endpoint string
+		Err      bool
+	}{
+		{"127.0.0.1:9000", false},
+		{"clickhouse:9000", false},
+		{"dns+clickhouse:9000", false},
+		{"dnssrv+_clickhouse._tcp.example.com", false},
+		{"clickhouse", true},
+		{"dns+clickhouse", true},
+		{"dnssrv+clickhouse:9000", true},
+	}
+	for _, tc := range cases {
+		s.Endpoint = tc.Endpoint
+		err := helpers.Validate.Struct(s)
+		if err == nil && tc.Err {
+			t.Errorf("Validate.Struct(%q) expected an error", tc.Endpoint)
+		} else if err != nil && !tc.Err {
+			t.Errorf("Validate.Struct(%q) error:\n%+v", tc.Endpoint, err)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	lookup := &fakeLookup{
+		srv: map[string][]*net.SRV{
+			"_kafka._tcp.kafka.example.com": {
+				{Target: "kafka-1.example.com.", Port: 9092},
+				{Target: "kafka-0.example.com.", Port: 9092},
+			},
+		},
+	}
+	got, err := expand(context.Background(), lookup, []string{
+		"127.0.0.1:9092",
+		"dns+kafka:9092",
+		"dnssrv+_kafka._tcp.kafka.example.com",
+	})
+	if err != nil {
+		t.Fatalf("expand() error:\n%+v", err)
+	}
+	expected := []string{
+		"127.0.0.1:9092",
+		"kafka:9092",
+		"kafka-0.example.com:9092",
+		"kafka-1.example.com:9092",
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("expand() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestResolver(t *testing.T) {
+	// Setup three listeners
+	listeners := []net.Listener{}
+	ports := []string{}
+	accepted := make(chan net.Conn, 10)
+	for i := 0; i < 3; i++ {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("Listen() error:\n%+v", err)
+		}
+		defer l.Close()
+		go func() {
+			for {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				accepted <- conn
+			}
+		}()
+		listeners = append(listeners, l)
+		ports = append(ports, strconv.Itoa(l.Addr().(*net.TCPAddr).Port))
+	}
+
+	r := reporter.NewMock(t)
+	resolver, err := New(r, []string{
+		"127.0.0.1:" + ports[0],
+		"dnssrv+_clickhouse._tcp.clickhouse",
+	}, time.Hour)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	lookup := &fakeLookup{
+		srv: map[string][]*net.SRV{
+			"_clickhouse._tcp.clickhouse": {
+				{Target: "127.0.0.1.", Port: listeners[1].Addr().(*net.TCPAddr).AddrPort().Port()},
+				{Target: "127.0.0.1.", Port: listeners[2].Addr().(*net.TCPAddr).AddrPort().Port()},
+			},
+		},
+	}
+	resolver.lookup = lookup
+	if diff := helpers.Diff(resolver.Addresses(), []string{
+		"127.0.0.1:" + ports[0],
+		"clickhouse:0",
+	}); diff != "" {
+		t.Fatalf("Addresses() (-got, +want):\n%s", diff)
+	}
+	helpers.StartStop(t, resolver)
+
+	// Connect to the dynamic endpoint, we should use both addresses
+	conns := []net.Conn{}
+	remotes := map[string]int{}
+	for i := 0; i < 4; i++ {
+		conn, err := resolver.Dial("tcp", "clickhouse:0")
+		if err != nil {
+			t.Fatalf("Dial() error:\n%+v", err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		remotes[conn.RemoteAddr().String()]++
+	}
+	expectedRemotes := map[string]int{
+		"127.0.0.1:" + ports[1]: 2,
+		"127.0.0.1:" + ports[2]: 2,
+	}
+	if diff := helpers.Diff(remotes, expectedRemotes); diff != "" {
+		t.Fatalf("Dial() remotes (-got, +want):\n%s", diff)
+	}
+	// Static endpoint
+	conn, err := resolver.Dial("tcp", "127.0.0.1:"+ports[0])
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	conn.Close()
+
+	// Failed resolution: keep the previous addresses
+	lookup.lock.Lock()
+	lookup.srv = map[string][]*net.SRV{}
+	lookup.lock.Unlock()
+	resolver.refresh(false)
+	conn, err = resolver.Dial("tcp", "clickhouse:0")
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	conn.Close()
+
+	// One address disappears
+	lookup.lock.Lock()
+	lookup.srv = map[string][]*net.SRV{
+		"_clickhouse._tcp.clickhouse": {
+			{Target: "127.0.0.1.", Port: listeners[1].Addr().(*net.TCPAddr).AddrPort().Port()},
+		},
+	}
+	lookup.lock.Unlock()
+	resolver.refresh(false)
+	closed := 0
+	for _, conn := range conns {
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		if _, err := conn.Read(make([]byte, 1)); errors.Is(err, net.ErrClosed) {
+			closed++
+		}
+	}
+	if closed != 2 {
+		t.Errorf("refresh() closed %d connections, expected 2", closed)
+	}
+	for i := 0; i < 2; i++ {
+		conn, err := resolver.Dial("tcp", "clickhouse:0")
+		if err != nil {
+			t.Fatalf("Dial() error:\n%+v", err)
+		}
+		if got := conn.RemoteAddr().String(); got != "127.0.0.1:"+ports[1] {
+			t.Errorf("Dial() remote %s, expected 127.0.0.1:%s", got, ports[1])
+		}
+		conn.Close()
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_common_discovery_")
+	expectedMetrics := map[string]string{
+		`addresses{endpoint="dnssrv+_clickhouse._tcp.clickhouse"}`:                "1",
+		`closed_connections_total{endpoint="dnssrv+_clickhouse._tcp.clickhouse"}`: "2",
+		`errors_total{endpoint="dnssrv+_clickhouse._tcp.clickhouse"}`:             "1",
+		`refreshes_total{endpoint="dnssrv+_clickhouse._tcp.clickhouse"}`:          "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestResolverRetry(t *testing.T) {
+	r := reporter.NewMock(t)
+	resolver, err := New(r, []string{
+		"dns+clickhouse1:9000",
+		"dns+clickhouse2:9000",
+	}, 0)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	lookup := &fakeLookup{
+		hosts: map[string][]string{
+			"clickhouse1": {"192.0.2.1"},
+		},
+	}
+	resolver.lookup = lookup
+	resolver.retryInterval = 10 * time.Millisecond
+	helpers.StartStop(t, resolver)
+	if !resolver.unresolved() {
+		t.Fatal("unresolved() == false but clickhouse2 cannot be resolved")
+	}
+
+	// The failed endpoint is retried until it is resolved
+	lookup.lock.Lock()
+	lookup.hosts["clickhouse2"] = []string{"192.0.2.2"}
+	lookup.lock.Unlock()
+	time.Sleep(200 * time.Millisecond)
+	if resolver.unresolved() {
+		t.Fatal("unresolved() == true after retries")
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_common_discovery_", "refreshes_total")
+	expectedMetrics := map[string]string{
+		`refreshes_total{endpoint="dns+clickhouse1:9000"}`: "1",
+		`refreshes_total{endpoint="dns+clickhouse2:9000"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -112,6 +112,21 @@ func (c *Cache[K, V]) DeleteLastAccessedBefore(before time.Time) int {
 	return count
 }
 
+// DeleteFunc removes the items whose key matches the provided predicate. It
+// returns the number of removed items.
+func (c *Cache[K, V]) DeleteFunc(match func(K) bool) int {
+	count := 0
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for k := range c.items {
+		if match(k) {
+			delete(c.items, k)
+			count++
+		}
+	}
+	return count
+}
+
 // Size returns the size of the cache
 func (c *Cache[K, V]) Size() int {
 	c.mu.RLock()
@@ -0,0 +1,64 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package systemd retrieves the sockets passed by systemd with socket
+// activation. See sd_listen_fds(3) for the protocol.
+package systemd
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// Prefix is the prefix used in listening addresses to designate a socket
+// passed by systemd. It is followed by the name of the socket.
+const Prefix = "systemd:"
+
+// listenFDsStart is the first file descriptor passed by systemd.
+const listenFDsStart = 3
+
+var (
+	files     map[string][]*os.File
+	filesOnce sync.Once
+)
+
+// Files returns the sockets passed by systemd with the provided name, as set
+// by FileDescriptorName= in the socket unit (the default name is the name of
+// the socket unit). The returned files should not be closed, but they can be
+// duplicated, for example with net.FilePacketConn(). Sockets stay open in
+// systemd when the process stops: pending packets are not lost on restart.
+func Files(name string) []*os.File {
+	filesOnce.Do(func() {
+		files = load(listenFDsStart)
+	})
+	return files[name]
+}
+
+// load parses the environment variables set by systemd and returns the
+// passed sockets, indexed by name. The first socket is expected to be the
+// provided file descriptor.
+func load(start int) map[string][]*os.File {
+	result := map[string][]*os.File{}
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return result
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return result
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for idx := 0; idx < count; idx++ {
+		fd := start + idx
+		syscall.CloseOnExec(fd)
+		name := "unknown"
+		if idx < len(names) && names[idx] != "" {
+			name = names[idx]
+		}
+		result[name] = append(result[name], os.NewFile(uintptr(fd), name))
+	}
+	return result
+}
@@ -0,0 +1,68 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"testing"
+)
+
+func TestLoad(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("ListenUDP() error:\n%+v", err)
+	}
+	defer conn.Close()
+	file, err := conn.File()
+	if err != nil {
+		t.Fatalf("File() error:\n%+v", err)
+	}
+	defer file.Close()
+	start := int(file.Fd())
+
+	t.Run("not for us", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getppid()))
+		t.Setenv("LISTEN_FDS", "1")
+		if got := load(start); len(got) != 0 {
+			t.Fatalf("load() == %v, expected nothing", got)
+		}
+	})
+
+	t.Run("not set", func(t *testing.T) {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		if got := load(start); len(got) != 0 {
+			t.Fatalf("load() == %v, expected nothing", got)
+		}
+	})
+
+	t.Run("named", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", "1")
+		t.Setenv("LISTEN_FDNAMES", "netflow")
+		got := load(start)
+		if len(got["netflow"]) != 1 {
+			t.Fatalf("load() == %v, expected one netflow socket", got)
+		}
+		pconn, err := net.FilePacketConn(got["netflow"][0])
+		if err != nil {
+			t.Fatalf("FilePacketConn() error:\n%+v", err)
+		}
+		defer pconn.Close()
+		if pconn.LocalAddr().String() != conn.LocalAddr().String() {
+			t.Fatalf("LocalAddr() == %s, expected %s", pconn.LocalAddr(), conn.LocalAddr())
+		}
+	})
+
+	t.Run("unnamed", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", "1")
+		t.Setenv("LISTEN_FDNAMES", "")
+		if got := load(start); len(got["unknown"]) != 1 {
+			t.Fatalf("load() == %v, expected one unknown socket", got)
+		}
+	})
+}
@@ -0,0 +1,24 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build !release
+
+package systemd
+
+import (
+	"os"
+	"testing"
+)
+
+// MockFiles registers sockets as if they were passed by systemd with the
+// provided name. They are unregistered at the end of the test.
+func MockFiles(t testing.TB, name string, mocked ...*os.File) {
+	t.Helper()
+	filesOnce.Do(func() {
+		files = map[string][]*os.File{}
+	})
+	files[name] = mocked
+	t.Cleanup(func() {
+		delete(files, name)
+	})
+}
@@ -13,8 +13,6 @@ import (
 	"net"
 	"net/http"
 	"testing"
-
-	"github.com/gin-gonic/gin"
 )
 
 // HTTPEndpointCases describes case for TestHTTPEndpoints
@@ -110,7 +108,7 @@ func TestHTTPEndpoints(t *testing.T, serverAddr net.Addr, cases HTTPEndpointCase
 				}
 			} else {
 				decoder := json.NewDecoder(resp.Body)
-				var got gin.H
+				var got interface{}
 				if err := decoder.Decode(&got); err != nil {
 					t.Fatalf("%s %s:\n%+v", tc.Method, tc.URL, err)
 				}
@@ -18,18 +18,18 @@ import (
 // Configuration describes the configuration for the HTTP server.
 type Configuration struct {
 	// Listen defines the listening string to listen to.
-	Listen string `validate:"required,listen"`
+	Listen string `validate:"required,listen" doc:"Address to listen to"`
 	// Profiler enables Go profiler as /debug
-	Profiler bool
+	Profiler bool `doc:"Enable Go profiler on /debug"`
 	// Cache configuration
-	Cache CacheConfiguration
+	Cache CacheConfiguration `doc:"Internal HTTP cache configuration"`
 }
 
 // CacheConfiguration describes the configuration of the internal HTTP cache.
 // Currently, it delegates everything to the implemented backends.
 type CacheConfiguration struct {
 	// Config is the backend-specific configuration for the cache
-	Config CacheBackendConfiguration
+	Config CacheBackendConfiguration `doc:"Backend-specific configuration for the cache (memory or redis)"`
 }
 
 // CacheBackendConfiguration represents the configuration of a cache backend.
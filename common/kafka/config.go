@@ -13,8 +13,11 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"time"
 
+	"akvorado/common/discovery"
 	"akvorado/common/helpers/bimap"
+	"akvorado/common/reporter"
 
 	"github.com/Shopify/sarama"
 )
@@ -22,42 +25,46 @@ import (
 // Configuration defines how we connect to a Kafka cluster.
 type Configuration struct {
 	// Topic defines the topic to write flows to.
-	Topic string `validate:"required"`
-	// Brokers is the list of brokers to connect to.
-	Brokers []string `min=1,dive,validate:"listen"`
+	Topic string `validate:"required" doc:"Topic to write flows to"`
+	// Brokers is the list of brokers to connect to. They can be prefixed
+	// with "dns+" or "dnssrv+" to be resolved periodically.
+	Brokers []string `validate:"min=1,dive,endpoint" doc:"Brokers to connect to (dns+ or dnssrv+ prefixes for discovery)"`
+	// ResolveInterval tells how often to resolve brokers using DNS.
+	ResolveInterval time.Duration `validate:"min=0" doc:"Interval between DNS resolutions of dns+ and dnssrv+ brokers (0 to resolve only at startup)"`
 	// Version is the version of Kafka we assume to work
-	Version Version
+	Version Version `doc:"Kafka version to assume"`
 	// TLS defines TLS configuration
-	TLS TLSConfiguration
+	TLS TLSConfiguration `doc:"TLS and SASL configuration"`
 }
 
 // TLSConfiguration defines TLS configuration.
 type TLSConfiguration struct {
 	// Enable says if TLS should be used to connect to brokers
-	Enable bool `validate:"required_with=CAFile CertFile KeyFile Username Password SASLAlgorithm"`
+	Enable bool `validate:"required_with=CAFile CertFile KeyFile Username Password SASLAlgorithm" doc:"Use TLS to connect to brokers"`
 	// Verify says if we need to check remote certificates
-	Verify bool
+	Verify bool `doc:"Check remote certificates"`
 	// CAFile tells the location of the CA certificate to check broker
 	// certificate. If empty, the system CA certificates are used instead.
-	CAFile string // no validation as the orchestrator may not have the file
+	CAFile string `doc:"CA certificate to check brokers (system CA when empty)"` // no validation as the orchestrator may not have the file
 	// CertFile tells the location of the user certificate if any.
-	CertFile string `validate:"required_with=KeyFile"`
+	CertFile string `validate:"required_with=KeyFile" doc:"User certificate, if any"`
 	// KeyFile tells the location of the user key if any.
-	KeyFile string
+	KeyFile string `doc:"User key, if any"`
 	// SASLUsername tells the SASL username
-	SASLUsername string `validate:"required_with=SASLAlgorithm"`
+	SASLUsername string `validate:"required_with=SASLAlgorithm" doc:"SASL username"`
 	// SASLPassword tells the SASL password
-	SASLPassword string `validate:"required_with=SASLAlgorithm SASLUsername"`
+	SASLPassword string `validate:"required_with=SASLAlgorithm SASLUsername" doc:"SASL password"`
 	// SASLMechanism tells the SASL algorithm
-	SASLMechanism SASLMechanism `validate:"required_with=SASLUsername"`
+	SASLMechanism SASLMechanism `validate:"required_with=SASLUsername" doc:"SASL mechanism (plain, scram-sha256, scram-sha512)"`
 }
 
 // DefaultConfiguration represents the default configuration for connecting to Kafka.
 func DefaultConfiguration() Configuration {
 	return Configuration{
-		Topic:   "flows",
-		Brokers: []string{"127.0.0.1:9092"},
-		Version: Version(sarama.V2_8_1_0),
+		Topic:           "flows",
+		Brokers:         []string{"127.0.0.1:9092"},
+		ResolveInterval: time.Minute,
+		Version:         Version(sarama.V2_8_1_0),
 		TLS: TLSConfiguration{
 			Enable: false,
 			Verify: true,
@@ -190,3 +197,20 @@ func NewConfig(config Configuration) (*sarama.Config, error) {
 	}
 	return kafkaConfig, nil
 }
+
+// NewResolver returns a resolver for the configured brokers and configures
+// Sarama to use it when needed. Clients should use the addresses from the
+// resolver. It should be started before use.
+func NewResolver(r *reporter.Reporter, config Configuration, kafkaConfig *sarama.Config) (*discovery.Resolver, error) {
+	resolver, err := discovery.New(r, config.Brokers, config.ResolveInterval)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse Kafka brokers: %w", err)
+	}
+	if resolver.Dynamic() {
+		resolver.Dialer.Timeout = kafkaConfig.Net.DialTimeout
+		resolver.Dialer.KeepAlive = kafkaConfig.Net.KeepAlive
+		kafkaConfig.Net.Proxy.Enable = true
+		kafkaConfig.Net.Proxy.Dialer = resolver
+	}
+	return resolver, nil
+}
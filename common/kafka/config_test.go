@@ -5,8 +5,10 @@ package kafka
 
 import (
 	"testing"
+	"time"
 
 	"akvorado/common/helpers"
+	"akvorado/common/reporter"
 
 	"github.com/Shopify/sarama"
 	"github.com/gin-gonic/gin"
@@ -89,9 +91,10 @@ func TestTLSConfiguration(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				Topic:   "flows",
-				Brokers: []string{"127.0.0.1:9092"},
-				Version: Version(sarama.V2_8_1_0),
+				Topic:           "flows",
+				Brokers:         []string{"127.0.0.1:9092"},
+				ResolveInterval: time.Minute,
+				Version:         Version(sarama.V2_8_1_0),
 				TLS: TLSConfiguration{
 					Enable: true,
 					Verify: true,
@@ -112,9 +115,10 @@ func TestTLSConfiguration(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				Topic:   "flows",
-				Brokers: []string{"127.0.0.1:9092"},
-				Version: Version(sarama.V2_8_1_0),
+				Topic:           "flows",
+				Brokers:         []string{"127.0.0.1:9092"},
+				ResolveInterval: time.Minute,
+				Version:         Version(sarama.V2_8_1_0),
 				TLS: TLSConfiguration{
 					Enable:        true,
 					Verify:        false,
@@ -137,9 +141,10 @@ func TestTLSConfiguration(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				Topic:   "flows",
-				Brokers: []string{"127.0.0.1:9092"},
-				Version: Version(sarama.V2_8_1_0),
+				Topic:           "flows",
+				Brokers:         []string{"127.0.0.1:9092"},
+				ResolveInterval: time.Minute,
+				Version:         Version(sarama.V2_8_1_0),
 				TLS: TLSConfiguration{
 					Enable:        true,
 					Verify:        true,
@@ -151,3 +156,49 @@ func TestTLSConfiguration(t *testing.T) {
 		},
 	})
 }
+
+func TestBrokersValidation(t *testing.T) {
+	config := DefaultConfiguration()
+	config.Brokers = []string{"dns+kafka:9092", "dnssrv+_kafka._tcp.kafka.example.com"}
+	if err := helpers.Validate.Struct(config); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+	config.Brokers = []string{"dnssrv+kafka.example.com"}
+	if err := helpers.Validate.Struct(config); err == nil {
+		t.Fatal("validate.Struct() did not error")
+	}
+	config.Brokers = []string{}
+	if err := helpers.Validate.Struct(config); err == nil {
+		t.Fatal("validate.Struct() did not error")
+	}
+}
+
+func TestNewResolver(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	kafkaConfig, err := NewConfig(config)
+	if err != nil {
+		t.Fatalf("NewConfig() error:\n%+v", err)
+	}
+	if _, err := NewResolver(r, config, kafkaConfig); err != nil {
+		t.Fatalf("NewResolver() error:\n%+v", err)
+	}
+	if kafkaConfig.Net.Proxy.Enable {
+		t.Error("NewResolver() enabled custom dialer for static brokers")
+	}
+
+	config.Brokers = []string{"dns+kafka:9092", "dnssrv+_kafka._tcp.kafka.example.com"}
+	resolver, err := NewResolver(r, config, kafkaConfig)
+	if err != nil {
+		t.Fatalf("NewResolver() error:\n%+v", err)
+	}
+	if !kafkaConfig.Net.Proxy.Enable || kafkaConfig.Net.Proxy.Dialer != resolver {
+		t.Error("NewResolver() did not set custom dialer for dynamic brokers")
+	}
+	if diff := helpers.Diff(resolver.Addresses(), []string{"kafka:9092", "kafka.example.com:0"}); diff != "" {
+		t.Errorf("Addresses() (-got, +want):\n%s", diff)
+	}
+	if err := kafkaConfig.Validate(); err != nil {
+		t.Errorf("Validate() error:\n%+v", err)
+	}
+}
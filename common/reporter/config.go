@@ -10,8 +10,8 @@ import (
 
 // Configuration contains the reporter configuration.
 type Configuration struct {
-	Logging logger.Configuration
-	Metrics metrics.Configuration
+	Logging logger.Configuration  `doc:"Logging configuration"`
+	Metrics metrics.Configuration `doc:"Metrics configuration"`
 }
 
 // DefaultConfiguration is the default reporter configuration.
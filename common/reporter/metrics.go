@@ -45,6 +45,8 @@ type (
 	Histogram = prometheus.Histogram
 	// HistogramVec defines histogram vectors
 	HistogramVec = prometheus.HistogramVec
+	// Observer defines observers (histograms or summaries with their labels set)
+	Observer = prometheus.Observer
 	// Summary defines summarys
 	Summary = prometheus.Summary
 	// SummaryVec defines summary vectors
@@ -3,20 +3,76 @@
 
 package schema
 
-import "errors"
+import (
+	"errors"
+
+	"akvorado/common/helpers/bimap"
+)
 
 // Configuration describes the configuration for the schema component.
 type Configuration struct {
 	// Disabled lists the columns disabled (in addition to the ones disabled by default).
-	Disabled []ColumnKey
+	Disabled []ColumnKey `doc:"Columns to disable in addition to the ones disabled by default"`
 	// Enabled lists the columns enabled (in addition to the ones enabled by default).
-	Enabled []ColumnKey `validate:"ninterfield=Disabled"`
+	Enabled []ColumnKey `validate:"ninterfield=Disabled" doc:"Columns to enable in addition to the ones enabled by default"`
 	// MainTableOnly lists columns to be moved to the main table only
-	MainTableOnly []ColumnKey
+	MainTableOnly []ColumnKey `doc:"Columns to only keep in the main table"`
 	// NotMainTableOnly lists columns to be moved out of the main table only
-	NotMainTableOnly []ColumnKey `validate:"ninterfield=MainTableOnly"`
+	NotMainTableOnly []ColumnKey `validate:"ninterfield=MainTableOnly" doc:"Columns to also keep in the aggregated tables"`
 	// Materialize lists columns that shall be materialized at ingest instead of computed at query time
-	Materialize []ColumnKey
+	Materialize []ColumnKey `doc:"Columns to materialize at ingest instead of computing them at query time"`
+	// CustomDimensions lists additional columns. They are populated by the inlet.
+	CustomDimensions []CustomDimension `validate:"dive" doc:"Additional columns populated by the inlet"`
+}
+
+// CustomDimension describes an additional column.
+type CustomDimension struct {
+	// Name is the name of the column.
+	Name string `validate:"required,alphanum" doc:"Name of the column"`
+	// Type is the type of the column.
+	Type CustomDimensionType `doc:"Type of the column (string or uint)"`
+	// Description is a description of the column.
+	Description string `doc:"Description of the column"`
+}
+
+// CustomDimensionType is the type of a custom dimension.
+type CustomDimensionType int
+
+const (
+	// CustomDimensionTypeString is a string dimension.
+	CustomDimensionTypeString CustomDimensionType = iota
+	// CustomDimensionTypeUint is an unsigned integer dimension.
+	CustomDimensionTypeUint
+)
+
+var customDimensionTypeMap = bimap.New(map[CustomDimensionType]string{
+	CustomDimensionTypeString: "string",
+	CustomDimensionTypeUint:   "uint",
+})
+
+// MarshalText turns a custom dimension type to text.
+func (cdt CustomDimensionType) MarshalText() ([]byte, error) {
+	got, ok := customDimensionTypeMap.LoadValue(cdt)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown custom dimension type")
+}
+
+// String turns a custom dimension type to string.
+func (cdt CustomDimensionType) String() string {
+	got, _ := customDimensionTypeMap.LoadValue(cdt)
+	return got
+}
+
+// UnmarshalText provides a custom dimension type from a string.
+func (cdt *CustomDimensionType) UnmarshalText(input []byte) error {
+	got, ok := customDimensionTypeMap.LoadKey(string(input))
+	if ok {
+		*cdt = got
+		return nil
+	}
+	return errors.New("unknown custom dimension type")
 }
 
 // DefaultConfiguration returns the default configuration for the schema component.
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"strings"
 
+	"akvorado/common/helpers/bimap"
+
 	"github.com/bits-and-blooms/bitset"
 	"golang.org/x/exp/slices"
 	"google.golang.org/protobuf/encoding/protowire"
@@ -82,6 +84,47 @@ const (
 	ColumnDstPortNAT
 	ColumnSrcMAC
 	ColumnDstMAC
+	ColumnDstTrafficClass
+	ColumnFlowExportDirection
+	ColumnZeroVolume
+	ColumnMPLSLabel1
+	ColumnMPLSLabel2
+	ColumnMPLSLabel3
+	ColumnIPTos
+	ColumnIPv6FlowLabel
+	ColumnFirewallEvent
+	ColumnICMPType
+	ColumnICMPCode
+	ColumnTCPFlags
+	ColumnTunnelType
+	ColumnSrcAddrInner
+	ColumnDstAddrInner
+	ColumnProtoInner
+	ColumnTunnelVNI
+	ColumnDropReason
+	ColumnSrcCity
+	ColumnDstCity
+	ColumnSrcRegion
+	ColumnDstRegion
+	ColumnSrcLatitude
+	ColumnDstLatitude
+	ColumnSrcLongitude
+	ColumnDstLongitude
+	ColumnNextHop
+	ColumnSrc1stAS
+	ColumnDstASPathLength
+	ColumnThreatList
+	ColumnIPDSCP
+	ColumnIPECN
+	ColumnSrcApplication
+	ColumnDstApplication
+	ColumnInIfBillingClass
+	ColumnOutIfBillingClass
+	ColumnSrcVRF
+	ColumnDstVRF
+	ColumnSRv6ActiveSegment
+	ColumnSRv6SegmentsLeft
+	ColumnSRv6SegmentListLength
 
 	ColumnLast
 )
@@ -89,10 +132,53 @@ const (
 const (
 	ColumnGroupL2 ColumnGroup = iota + 1
 	ColumnGroupNAT
+	ColumnGroupMPLS
+	ColumnGroupL3L4
+	ColumnGroupTunnel
+	ColumnGroupSRv6
+	ColumnGroupTrafficClass
 
 	ColumnGroupLast
 )
 
+const (
+	ColumnSourceFlow ColumnSource = iota + 1
+	ColumnSourceSNMP
+	ColumnSourceGeoIP
+	ColumnSourceBMP
+	ColumnSourceClassifier
+	ColumnSourceNetworks
+	ColumnSourceComputed
+	ColumnSourceThreatLists
+)
+
+const (
+	ColumnFilterIP ColumnFilter = iota + 1
+	ColumnFilterPrefix
+	ColumnFilterMAC
+	ColumnFilterString
+	ColumnFilterBoundary
+	ColumnFilterUint
+	ColumnFilterBool
+	ColumnFilterAS
+	ColumnFilterASPath
+	ColumnFilterCommunities
+	ColumnFilterEType
+	ColumnFilterProto
+	ColumnFilterTCPFlags
+)
+
+var columnSourceMap = bimap.New(map[ColumnSource]string{
+	ColumnSourceFlow:        "flow",
+	ColumnSourceSNMP:        "snmp",
+	ColumnSourceGeoIP:       "geoip",
+	ColumnSourceBMP:         "bmp",
+	ColumnSourceClassifier:  "classifier",
+	ColumnSourceNetworks:    "networks",
+	ColumnSourceComputed:    "computed",
+	ColumnSourceThreatLists: "threat-lists",
+})
+
 // revive:enable
 
 // Flows is the data schema for flows tables. Any column starting with Src/InIf
@@ -115,35 +201,99 @@ func flows() Schema {
 		columns: []Column{
 			{
 				Key:                 ColumnTimeReceived,
+				Description:         "Time the flow was received by the inlet",
+				Sources:             []ColumnSource{ColumnSourceFlow},
 				NoDisable:           true,
 				ClickHouseType:      "DateTime",
 				ClickHouseCodec:     "DoubleDelta, LZ4",
 				ConsoleNotDimension: true,
 				ProtobufType:        protoreflect.Uint64Kind,
 			},
-			{Key: ColumnSamplingRate, NoDisable: true, ClickHouseType: "UInt64", ConsoleNotDimension: true},
-			{Key: ColumnExporterAddress, ClickHouseType: "LowCardinality(IPv6)"},
-			{Key: ColumnExporterName, ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnExporterGroup, ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnExporterRole, ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnExporterSite, ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnExporterRegion, ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnExporterTenant, ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
+			{
+				Key:                 ColumnSamplingRate,
+				Description:         "Sampling rate of the flow",
+				Sources:             []ColumnSource{ColumnSourceFlow},
+				NoDisable:           true,
+				ClickHouseType:      "UInt64",
+				ConsoleNotDimension: true,
+			},
+			{
+				Key:            ColumnExporterAddress,
+				Description:    "IP address of the exporter",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				ClickHouseType: "LowCardinality(IPv6)",
+				ConsoleFilter:  ColumnFilterIP,
+			},
+			{
+				Key:                     ColumnExporterName,
+				Description:             "Name of the exporter",
+				Sources:                 []ColumnSource{ColumnSourceSNMP},
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnExporterGroup,
+				Description:             "Group of the exporter",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnExporterRole,
+				Description:             "Role of the exporter",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnExporterSite,
+				Description:             "Site of the exporter",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnExporterRegion,
+				Description:             "Region of the exporter",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnExporterTenant,
+				Description:             "Tenant of the exporter",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
 			{
 				Key:                ColumnSrcAddr,
+				Description:        "Source IP address",
+				Sources:            []ColumnSource{ColumnSourceFlow},
 				ClickHouseMainOnly: true,
 				ClickHouseType:     "IPv6",
 				ClickHouseCodec:    "ZSTD(1)",
 				ConsoleTruncateIP:  true,
+				ConsoleFilter:      ColumnFilterIP,
 			},
 			{
 				Key:                 ColumnSrcNetMask,
+				Description:         "Prefix length of the source network",
+				Sources:             []ColumnSource{ColumnSourceFlow, ColumnSourceBMP},
 				ClickHouseMainOnly:  true,
 				ClickHouseType:      "UInt8",
 				ConsoleNotDimension: true,
 			},
 			{
 				Key:                ColumnSrcNetPrefix,
+				Description:        "Network prefix of the source IP address",
+				Sources:            []ColumnSource{ColumnSourceComputed},
 				ClickHouseMainOnly: true,
 				ClickHouseType:     "String",
 				ClickHouseAlias: `CASE
@@ -151,90 +301,158 @@ func flows() Schema {
  WHEN EType = 0x86dd THEN concat(IPv6CIDRToRange(SrcAddr, SrcNetMask).1::String, '/', SrcNetMask::String)
  ELSE ''
 END`,
+				ConsoleFilter: ColumnFilterPrefix,
+			},
+			{
+				Key:            ColumnSrcAS,
+				Description:    "AS number of the source IP address",
+				Sources:        []ColumnSource{ColumnSourceFlow, ColumnSourceBMP, ColumnSourceGeoIP},
+				ClickHouseType: "UInt32",
+				ConsoleFilter:  ColumnFilterAS,
 			},
-			{Key: ColumnSrcAS, ClickHouseType: "UInt32"},
 			{
 				Key:                    ColumnSrcNetName,
+				Description:            "Name of the source network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'name', SrcAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
 			},
 			{
 				Key:                    ColumnDstNetName,
+				Description:            "Name of the destination network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'name', DstAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
 			},
 			{
 				Key:                    ColumnSrcNetRole,
+				Description:            "Role of the source network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'role', SrcAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
 			},
 			{
 				Key:                    ColumnDstNetRole,
+				Description:            "Role of the destination network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'role', DstAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
 			},
 			{
 				Key:                    ColumnSrcNetSite,
+				Description:            "Site of the source network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'site', SrcAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
 			},
 			{
 				Key:                    ColumnDstNetSite,
+				Description:            "Site of the destination network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'site', DstAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
 			},
 			{
 				Key:                    ColumnSrcNetRegion,
+				Description:            "Region of the source network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'region', SrcAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
 			},
 			{
 				Key:                    ColumnDstNetRegion,
+				Description:            "Region of the destination network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'region', DstAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
 			},
 			{
 				Key:                    ColumnSrcNetTenant,
+				Description:            "Tenant of the source network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'tenant', SrcAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
 			},
 			{
 				Key:                    ColumnDstNetTenant,
+				Description:            "Tenant of the destination network",
+				Sources:                []ColumnSource{ColumnSourceNetworks},
 				ClickHouseType:         "LowCardinality(String)",
 				ClickHouseGenerateFrom: "dictGetOrDefault('networks', 'tenant', DstAddr, '')",
+				ConsoleFilter:          ColumnFilterString,
+			},
+			{
+				Key:            ColumnSrcVlan,
+				Description:    "Source VLAN",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				ClickHouseType: "UInt16",
+				Disabled:       true,
+				Group:          ColumnGroupL2,
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:            ColumnSrcCountry,
+				Description:    "Country of the source IP address",
+				Sources:        []ColumnSource{ColumnSourceGeoIP},
+				ClickHouseType: "FixedString(2)",
+				ConsoleFilter:  ColumnFilterString,
 			},
-			{Key: ColumnSrcVlan, ClickHouseType: "UInt16", Disabled: true, Group: ColumnGroupL2},
-			{Key: ColumnSrcCountry, ClickHouseType: "FixedString(2)"},
 			{
 				Key:                ColumnDstASPath,
+				Description:        "AS path of the route to the destination IP address",
+				Sources:            []ColumnSource{ColumnSourceBMP},
 				ClickHouseMainOnly: true,
 				ClickHouseType:     "Array(UInt32)",
+				ConsoleFilter:      ColumnFilterASPath,
 			},
 			{
 				Key:                    ColumnDst1stAS,
+				Description:            "First AS of the AS path to the destination",
+				Sources:                []ColumnSource{ColumnSourceComputed},
 				Depends:                []ColumnKey{ColumnDstASPath},
 				ClickHouseType:         "UInt32",
 				ClickHouseGenerateFrom: "c_DstASPath[1]",
+				ConsoleFilter:          ColumnFilterAS,
 			},
 			{
 				Key:                    ColumnDst2ndAS,
+				Description:            "Second AS of the AS path to the destination",
+				Sources:                []ColumnSource{ColumnSourceComputed},
 				Depends:                []ColumnKey{ColumnDstASPath},
 				ClickHouseType:         "UInt32",
 				ClickHouseGenerateFrom: "c_DstASPath[2]",
+				ConsoleFilter:          ColumnFilterAS,
 			},
 			{
 				Key:                    ColumnDst3rdAS,
+				Description:            "Third AS of the AS path to the destination",
+				Sources:                []ColumnSource{ColumnSourceComputed},
 				Depends:                []ColumnKey{ColumnDstASPath},
 				ClickHouseType:         "UInt32",
 				ClickHouseGenerateFrom: "c_DstASPath[3]",
+				ConsoleFilter:          ColumnFilterAS,
 			},
 			{
 				Key:                ColumnDstCommunities,
+				Description:        "BGP communities of the route to the destination IP address",
+				Sources:            []ColumnSource{ColumnSourceBMP},
 				ClickHouseMainOnly: true,
 				ClickHouseType:     "Array(UInt32)",
+				ConsoleFilter:      ColumnFilterCommunities,
 			},
 			{
 				Key:                ColumnDstLargeCommunities,
+				Description:        "BGP large communities of the route to the destination IP address",
+				Sources:            []ColumnSource{ColumnSourceBMP},
 				ClickHouseMainOnly: true,
 				ClickHouseType:     "Array(UInt128)",
 				ClickHouseTransformFrom: []Column{
@@ -254,15 +472,52 @@ END`,
 				ClickHouseTransformTo: "arrayMap((asn, l1, l2) -> ((bitShiftLeft(CAST(asn, 'UInt128'), 64) + bitShiftLeft(CAST(l1, 'UInt128'), 32)) + CAST(l2, 'UInt128')), DstLargeCommunitiesASN, DstLargeCommunitiesLocalData1, DstLargeCommunitiesLocalData2)",
 				ConsoleNotDimension:   true,
 			},
-			{Key: ColumnInIfName, ClickHouseType: "LowCardinality(String)"},
-			{Key: ColumnInIfDescription, ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnInIfSpeed, ClickHouseType: "UInt32", ClickHouseNotSortingKey: true},
-			{Key: ColumnInIfConnectivity, ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
-			{Key: ColumnInIfProvider, ClickHouseType: "LowCardinality(String)", ClickHouseNotSortingKey: true},
+			{
+				Key:            ColumnInIfName,
+				Description:    "Name of the input interface",
+				Sources:        []ColumnSource{ColumnSourceSNMP, ColumnSourceClassifier},
+				ClickHouseType: "LowCardinality(String)",
+				ConsoleFilter:  ColumnFilterString,
+			},
+			{
+				Key:                     ColumnInIfDescription,
+				Description:             "Description of the input interface",
+				Sources:                 []ColumnSource{ColumnSourceSNMP, ColumnSourceClassifier},
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnInIfSpeed,
+				Description:             "Speed of the input interface in Mbps",
+				Sources:                 []ColumnSource{ColumnSourceSNMP},
+				ClickHouseType:          "UInt32",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterUint,
+			},
+			{
+				Key:                     ColumnInIfConnectivity,
+				Description:             "Connectivity type of the input interface (transit, PNI, IX)",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnInIfProvider,
+				Description:             "Provider of the input interface",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
 			{
 				Key:                     ColumnInIfBoundary,
+				Description:             "Boundary of the input interface (internal or external)",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
 				ClickHouseType:          "Enum8('undefined' = 0, 'external' = 1, 'internal' = 2)",
 				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterBoundary,
 				ProtobufType:            protoreflect.EnumKind,
 				ProtobufEnumName:        "Boundary",
 				ProtobufEnum: map[int]string{
@@ -271,11 +526,32 @@ END`,
 					2: "INTERNAL",
 				},
 			},
-			{Key: ColumnEType, ClickHouseType: "UInt32"}, // TODO: UInt16 but hard to change, primary key
-			{Key: ColumnProto, ClickHouseType: "UInt32"}, // TODO: UInt8 but hard to change, primary key
-			{Key: ColumnSrcPort, ClickHouseType: "UInt16", ClickHouseMainOnly: true},
+			{
+				Key:            ColumnEType,
+				Description:    "Ethernet type (IPv4 or IPv6)",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				ClickHouseType: "UInt32", // TODO: UInt16 but hard to change, primary key
+				ConsoleFilter:  ColumnFilterEType,
+			},
+			{
+				Key:            ColumnProto,
+				Description:    "IP protocol",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				ClickHouseType: "UInt32", // TODO: UInt8 but hard to change, primary key
+				ConsoleFilter:  ColumnFilterProto,
+			},
+			{
+				Key:                ColumnSrcPort,
+				Description:        "Source port",
+				Sources:            []ColumnSource{ColumnSourceFlow},
+				ClickHouseType:     "UInt16",
+				ClickHouseMainOnly: true,
+				ConsoleFilter:      ColumnFilterUint,
+			},
 			{
 				Key:                     ColumnBytes,
+				Description:             "Number of bytes, to be multiplied by the sampling rate",
+				Sources:                 []ColumnSource{ColumnSourceFlow},
 				NoDisable:               true,
 				ClickHouseType:          "UInt64",
 				ClickHouseCodec:         "T64, LZ4",
@@ -284,6 +560,8 @@ END`,
 			},
 			{
 				Key:                     ColumnPackets,
+				Description:             "Number of packets, to be multiplied by the sampling rate",
+				Sources:                 []ColumnSource{ColumnSourceFlow},
 				NoDisable:               true,
 				ClickHouseType:          "UInt64",
 				ClickHouseCodec:         "T64, LZ4",
@@ -292,13 +570,18 @@ END`,
 			},
 			{
 				Key:                 ColumnPacketSize,
+				Description:         "Average packet size",
+				Sources:             []ColumnSource{ColumnSourceComputed},
 				Depends:             []ColumnKey{ColumnBytes, ColumnPackets},
 				ClickHouseType:      "UInt64",
 				ClickHouseAlias:     "intDiv(Bytes, Packets)",
 				ConsoleNotDimension: true,
+				ConsoleFilter:       ColumnFilterUint,
 			},
 			{
 				Key:            ColumnPacketSizeBucket,
+				Description:    "Range of the average packet size",
+				Sources:        []ColumnSource{ColumnSourceComputed},
 				Depends:        []ColumnKey{ColumnPacketSize},
 				ClickHouseType: "LowCardinality(String)",
 				ClickHouseAlias: func() string {
@@ -316,24 +599,361 @@ END`,
 					conditions = append(conditions, fmt.Sprintf("'%d-Inf'", last))
 					return fmt.Sprintf("multiIf(%s)", strings.Join(conditions, ", "))
 				}(),
+				ConsoleFilter: ColumnFilterString,
+			},
+			{
+				Key:            ColumnForwardingStatus,
+				Description:    "Forwarding status of the flow",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				ClickHouseType: "UInt32", // TODO: UInt8 but hard to change, primary key
+				ConsoleFilter:  ColumnFilterUint,
 			},
-			{Key: ColumnForwardingStatus, ClickHouseType: "UInt32"}, // TODO: UInt8 but hard to change, primary key
 			{
 				Key:                ColumnSrcAddrNAT,
+				Description:        "Source IP address after NAT",
+				Sources:            []ColumnSource{ColumnSourceFlow},
 				Disabled:           true,
 				Group:              ColumnGroupNAT,
 				ClickHouseType:     "IPv6",
 				ClickHouseMainOnly: true,
 				ConsoleTruncateIP:  true,
+				ConsoleFilter:      ColumnFilterIP,
 			},
 			{
 				Key:                ColumnSrcPortNAT,
+				Description:        "Source port after NAT",
+				Sources:            []ColumnSource{ColumnSourceFlow},
 				Disabled:           true,
 				Group:              ColumnGroupNAT,
 				ClickHouseType:     "UInt16",
 				ClickHouseMainOnly: true,
+				ConsoleFilter:      ColumnFilterUint,
+			},
+			{
+				Key:            ColumnSrcMAC,
+				Description:    "Source MAC address",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupL2,
+				ClickHouseType: "UInt64",
+				ConsoleFilter:  ColumnFilterMAC,
+			},
+			{
+				Key:                     ColumnDstTrafficClass,
+				Description:             "Traffic class of the route to the destination, from its BGP communities",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
+				Group:                   ColumnGroupTrafficClass,
+				Disabled:                true,
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnFlowExportDirection,
+				Description:             "Direction the flow was observed by the exporter (ingress or egress)",
+				Sources:                 []ColumnSource{ColumnSourceFlow},
+				Disabled:                true,
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnZeroVolume,
+				Description:             "Whether the flow was received without bytes or without packets",
+				Sources:                 []ColumnSource{ColumnSourceFlow},
+				Disabled:                true,
+				ClickHouseType:          "Bool",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterBool,
+				ProtobufType:            protoreflect.BoolKind,
+			},
+			{
+				Key:            ColumnMPLSLabel1,
+				Description:    "First MPLS label of the label stack",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupMPLS,
+				ClickHouseType: "UInt32",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:            ColumnMPLSLabel2,
+				Description:    "Second MPLS label of the label stack",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupMPLS,
+				ClickHouseType: "UInt32",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:            ColumnMPLSLabel3,
+				Description:    "Third MPLS label of the label stack",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupMPLS,
+				ClickHouseType: "UInt32",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:            ColumnIPTos,
+				Description:    "IPv4 type of service or IPv6 traffic class (DSCP and ECN)",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupL3L4,
+				ClickHouseType: "UInt8",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:            ColumnIPv6FlowLabel,
+				Description:    "IPv6 flow label",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupL3L4,
+				ClickHouseType: "UInt32",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:                     ColumnFirewallEvent,
+				Description:             "Firewall event (1: created, 2: deleted, 3: denied, 4: alert, 5: update)",
+				Sources:                 []ColumnSource{ColumnSourceFlow},
+				Disabled:                true,
+				ClickHouseType:          "UInt8",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterUint,
+			},
+			{
+				Key:            ColumnICMPType,
+				Description:    "ICMP or ICMPv6 type",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupL3L4,
+				ClickHouseType: "UInt8",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:            ColumnICMPCode,
+				Description:    "ICMP or ICMPv6 code",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupL3L4,
+				ClickHouseType: "UInt8",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:            ColumnTCPFlags,
+				Description:    "Union of the TCP flags seen in the flow, as a bitmask (FIN is bit 0, SYN bit 1, ...)",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupL3L4,
+				ClickHouseType: "UInt16",
+				ConsoleFilter:  ColumnFilterTCPFlags,
+			},
+			{
+				Key:                     ColumnTunnelType,
+				Description:             "Encapsulation of tunneled packets (gre, ipip, gtp, vxlan, or geneve)",
+				Sources:                 []ColumnSource{ColumnSourceFlow},
+				Disabled:                true,
+				Group:                   ColumnGroupTunnel,
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                ColumnSrcAddrInner,
+				Description:        "Source IP address of the encapsulated packet",
+				Sources:            []ColumnSource{ColumnSourceFlow},
+				Disabled:           true,
+				Group:              ColumnGroupTunnel,
+				ClickHouseType:     "IPv6",
+				ClickHouseMainOnly: true,
+				ConsoleTruncateIP:  true,
+				ConsoleFilter:      ColumnFilterIP,
+			},
+			{
+				Key:            ColumnProtoInner,
+				Description:    "IP protocol of the encapsulated packet",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupTunnel,
+				ClickHouseType: "UInt8",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:            ColumnTunnelVNI,
+				Description:    "VXLAN or Geneve network identifier of tunneled packets",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupTunnel,
+				ClickHouseType: "UInt32",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:                     ColumnDropReason,
+				Description:             "Reason the packet was discarded, from sFlow drop notifications",
+				Sources:                 []ColumnSource{ColumnSourceFlow},
+				Disabled:                true,
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnSrcCity,
+				Description:             "City of the source IP address",
+				Sources:                 []ColumnSource{ColumnSourceGeoIP},
+				Disabled:                true,
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnSrcRegion,
+				Description:             "Region (first subdivision) of the source IP address",
+				Sources:                 []ColumnSource{ColumnSourceGeoIP},
+				Disabled:                true,
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnSrcLatitude,
+				Description:             "Approximate latitude of the source IP address",
+				Sources:                 []ColumnSource{ColumnSourceGeoIP},
+				Disabled:                true,
+				ClickHouseType:          "Float32",
+				ClickHouseNotSortingKey: true,
+			},
+			{
+				Key:                     ColumnSrcLongitude,
+				Description:             "Approximate longitude of the source IP address",
+				Sources:                 []ColumnSource{ColumnSourceGeoIP},
+				Disabled:                true,
+				ClickHouseType:          "Float32",
+				ClickHouseNotSortingKey: true,
+			},
+			{
+				Key:                ColumnNextHop,
+				Description:        "Next hop of the destination IP address",
+				Sources:            []ColumnSource{ColumnSourceFlow, ColumnSourceBMP},
+				Disabled:           true,
+				ClickHouseType:     "IPv6",
+				ClickHouseMainOnly: true,
+				ConsoleFilter:      ColumnFilterIP,
+			},
+			{
+				Key:            ColumnSrc1stAS,
+				Description:    "First AS of the AS path to the source IP address",
+				Sources:        []ColumnSource{ColumnSourceBMP},
+				Disabled:       true,
+				ClickHouseType: "UInt32",
+				ConsoleFilter:  ColumnFilterAS,
+			},
+			{
+				Key:                    ColumnDstASPathLength,
+				Description:            "Length of the AS path to the destination",
+				Sources:                []ColumnSource{ColumnSourceComputed},
+				Depends:                []ColumnKey{ColumnDstASPath},
+				Disabled:               true,
+				ClickHouseType:         "UInt8",
+				ClickHouseGenerateFrom: "length(c_DstASPath)",
+				ConsoleFilter:          ColumnFilterUint,
+			},
+			{
+				Key:                     ColumnThreatList,
+				Description:             "Name of the threat list matching the source or the destination address",
+				Sources:                 []ColumnSource{ColumnSourceThreatLists},
+				Disabled:                true,
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:             ColumnIPDSCP,
+				Description:     "DSCP value from the IPv4 type of service or IPv6 traffic class",
+				Sources:         []ColumnSource{ColumnSourceComputed},
+				Depends:         []ColumnKey{ColumnIPTos},
+				Disabled:        true,
+				Group:           ColumnGroupL3L4,
+				ClickHouseType:  "UInt8",
+				ClickHouseAlias: "bitShiftRight(IPTos, 2)",
+				ConsoleFilter:   ColumnFilterUint,
+			},
+			{
+				Key:             ColumnIPECN,
+				Description:     "ECN value from the IPv4 type of service or IPv6 traffic class",
+				Sources:         []ColumnSource{ColumnSourceComputed},
+				Depends:         []ColumnKey{ColumnIPTos},
+				Disabled:        true,
+				Group:           ColumnGroupL3L4,
+				ClickHouseType:  "UInt8",
+				ClickHouseAlias: "bitAnd(IPTos, 3)",
+				ConsoleFilter:   ColumnFilterUint,
+			},
+			{
+				Key:                    ColumnSrcApplication,
+				Description:            "Application name of the source port",
+				Sources:                []ColumnSource{ColumnSourceComputed},
+				Depends:                []ColumnKey{ColumnProto, ColumnSrcPort},
+				Disabled:               true,
+				ClickHouseType:         "LowCardinality(String)",
+				ClickHouseGenerateFrom: "dictGetOrDefault('applications', 'name', (toUInt8(Proto), SrcPort), '')",
+				ConsoleFilter:          ColumnFilterString,
+			},
+			{
+				Key:                    ColumnDstApplication,
+				Description:            "Application name of the destination port",
+				Sources:                []ColumnSource{ColumnSourceComputed},
+				Depends:                []ColumnKey{ColumnProto, ColumnDstPort},
+				Disabled:               true,
+				ClickHouseType:         "LowCardinality(String)",
+				ClickHouseGenerateFrom: "dictGetOrDefault('applications', 'name', (toUInt8(Proto), DstPort), '')",
+				ConsoleFilter:          ColumnFilterString,
+			},
+			{
+				Key:                     ColumnInIfBillingClass,
+				Description:             "Billing class of the input interface (transit, paid-peering, settlement-free)",
+				Sources:                 []ColumnSource{ColumnSourceClassifier},
+				Disabled:                true,
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                     ColumnSrcVRF,
+				Description:             "Name of the VRF of the source",
+				Sources:                 []ColumnSource{ColumnSourceFlow},
+				Disabled:                true,
+				ClickHouseType:          "LowCardinality(String)",
+				ClickHouseNotSortingKey: true,
+				ConsoleFilter:           ColumnFilterString,
+			},
+			{
+				Key:                ColumnSRv6ActiveSegment,
+				Description:        "Active segment of the SRv6 segment routing header",
+				Sources:            []ColumnSource{ColumnSourceFlow},
+				Disabled:           true,
+				Group:              ColumnGroupSRv6,
+				ClickHouseType:     "IPv6",
+				ClickHouseMainOnly: true,
+				ConsoleFilter:      ColumnFilterIP,
+			},
+			{
+				Key:            ColumnSRv6SegmentsLeft,
+				Description:    "Number of segments left in the SRv6 segment routing header",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupSRv6,
+				ClickHouseType: "UInt8",
+				ConsoleFilter:  ColumnFilterUint,
+			},
+			{
+				Key:            ColumnSRv6SegmentListLength,
+				Description:    "Number of segments in the SRv6 segment routing header",
+				Sources:        []ColumnSource{ColumnSourceFlow},
+				Disabled:       true,
+				Group:          ColumnGroupSRv6,
+				ClickHouseType: "UInt8",
+				ConsoleFilter:  ColumnFilterUint,
 			},
-			{Key: ColumnSrcMAC, Disabled: true, Group: ColumnGroupL2, ClickHouseType: "UInt64"},
 		},
 	}.finalize()
 }
@@ -341,6 +961,12 @@ END`,
 func (schema Schema) finalize() Schema {
 	ncolumns := []Column{}
 	for _, column := range schema.columns {
+		if column.Key >= ColumnLast {
+			// Custom dimensions are already complete
+			ncolumns = append(ncolumns, column)
+			continue
+		}
+
 		// Add true name
 		name, ok := columnNameMap.LoadValue(column.Key)
 		if !ok {
@@ -397,6 +1023,9 @@ func (schema Schema) finalize() Schema {
 					panic(fmt.Sprintf("missing name mapping for %q", column.Name))
 				}
 				column.ClickHouseAlias = strings.ReplaceAll(column.ClickHouseAlias, "Src", "Dst")
+				column.Description = strings.NewReplacer(
+					"source", "destination",
+					"Source", "Destination").Replace(column.Description)
 				column.ClickHouseTransformFrom = slices.Clone(column.ClickHouseTransformFrom)
 				ncolumns = append(ncolumns, column)
 			}
@@ -408,6 +1037,7 @@ func (schema Schema) finalize() Schema {
 					panic(fmt.Sprintf("missing name mapping for %q", column.Name))
 				}
 				column.ClickHouseAlias = strings.ReplaceAll(column.ClickHouseAlias, "InIf", "OutIf")
+				column.Description = strings.ReplaceAll(column.Description, "input", "output")
 				column.ClickHouseTransformFrom = slices.Clone(column.ClickHouseTransformFrom)
 				ncolumns = append(ncolumns, column)
 			}
@@ -415,8 +1045,16 @@ func (schema Schema) finalize() Schema {
 	}
 	schema.columns = ncolumns
 
-	// Set Protobuf index and type
+	// Set Protobuf index and type. When finalizing twice, new columns are
+	// numbered after the existing ones.
 	protobufIndex := 1
+	for _, column := range schema.columns {
+		for _, column := range append([]Column{column}, column.ClickHouseTransformFrom...) {
+			if int(column.ProtobufIndex) >= protobufIndex {
+				protobufIndex = int(column.ProtobufIndex) + 1
+			}
+		}
+	}
 	ncolumns = []Column{}
 	for _, column := range schema.columns {
 		pcolumns := []*Column{&column}
@@ -447,6 +1085,8 @@ func (schema Schema) finalize() Schema {
 					column.ProtobufType = protoreflect.Uint64Kind
 				case "UInt32", "UInt16", "UInt8":
 					column.ProtobufType = protoreflect.Uint32Kind
+				case "Float32":
+					column.ProtobufType = protoreflect.FloatKind
 				case "IPv6", "LowCardinality(IPv6)":
 					column.ProtobufType = protoreflect.BytesKind
 				case "Array(UInt32)":
@@ -460,7 +1100,13 @@ func (schema Schema) finalize() Schema {
 	schema.columns = ncolumns
 
 	// Build column index
-	schema.columnIndex = make([]*Column, ColumnLast)
+	lastKey := ColumnLast
+	for _, column := range schema.columns {
+		if column.Key >= lastKey {
+			lastKey = column.Key + 1
+		}
+	}
+	schema.columnIndex = make([]*Column, lastKey)
 	for i, column := range schema.columns {
 		schema.columnIndex[column.Key] = &schema.columns[i]
 		for j, column := range column.ClickHouseTransformFrom {
@@ -468,6 +1114,39 @@ func (schema Schema) finalize() Schema {
 		}
 	}
 
+	// Build the mapping to swap directions and the set of IP columns
+	schema.protobufSwap = map[protowire.Number]ColumnKey{}
+	schema.protobufIPs = map[protowire.Number]ColumnKey{}
+	for _, column := range schema.columns {
+		for _, column := range append([]Column{column}, column.ClickHouseTransformFrom...) {
+			if column.ProtobufIndex <= 0 {
+				continue
+			}
+			if column.ProtobufType == protoreflect.BytesKind && column.Key != ColumnExporterAddress {
+				schema.protobufIPs[column.ProtobufIndex] = column.Key
+			}
+			var other string
+			switch {
+			case strings.HasPrefix(column.Name, "Src"):
+				other = fmt.Sprintf("Dst%s", column.Name[3:])
+			case strings.HasPrefix(column.Name, "Dst"):
+				other = fmt.Sprintf("Src%s", column.Name[3:])
+			case strings.HasPrefix(column.Name, "InIf"):
+				other = fmt.Sprintf("OutIf%s", column.Name[4:])
+			case strings.HasPrefix(column.Name, "OutIf"):
+				other = fmt.Sprintf("InIf%s", column.Name[5:])
+			case column.Key == ColumnNextHop:
+				continue
+			default:
+				schema.protobufSwap[column.ProtobufIndex] = column.Key
+				continue
+			}
+			if ocolumn, ok := schema.LookupColumnByName(other); ok && ocolumn.ProtobufIndex > 0 {
+				schema.protobufSwap[column.ProtobufIndex] = ocolumn.Key
+			}
+		}
+	}
+
 	// Update disabledGroups
 	schema.disabledGroups = *bitset.New(uint(ColumnGroupLast))
 	for group := ColumnGroup(0); group < ColumnGroupLast; group++ {
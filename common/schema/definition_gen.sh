@@ -17,6 +17,7 @@ $(grep -oEw 'Column[a-zA-Z0-9]+' common/schema/definition.go \
     | grep -vFx Last \
     | grep -vFx Key \
     | grep -v '^Group' \
+    | grep -v '^Source' \
     | sort | uniq \
     | awk '{ print "Column"$1": \""$1"\","}')
 })
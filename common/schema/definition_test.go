@@ -4,6 +4,7 @@
 package schema
 
 import (
+	"strings"
 	"testing"
 
 	"akvorado/common/helpers"
@@ -33,6 +34,54 @@ func TestFlowsProtobuf(t *testing.T) {
 	}
 }
 
+func TestFlowsDocumentation(t *testing.T) {
+	c := NewMock(t)
+	for _, column := range c.Columns() {
+		if column.Description == "" {
+			t.Errorf("column %s has no description", column.Name)
+		}
+		if len(column.Sources) == 0 {
+			t.Errorf("column %s has no source", column.Name)
+		}
+	}
+	for key, expected := range map[ColumnKey]string{
+		ColumnSrcAS:         "AS number of the source IP address",
+		ColumnDstAS:         "AS number of the destination IP address",
+		ColumnDstPort:       "Destination port",
+		ColumnOutIfName:     "Name of the output interface",
+		ColumnOutIfBoundary: "Boundary of the output interface (internal or external)",
+	} {
+		column, _ := c.LookupColumnByKey(key)
+		if column.Description != expected {
+			t.Errorf("column %s has description %q, expected %q", key, column.Description, expected)
+		}
+	}
+}
+
+func TestFilterableColumns(t *testing.T) {
+	c := NewMock(t).EnableAllColumns()
+	for _, column := range c.Columns() {
+		if column.ConsoleFilter == ColumnFilterString && !strings.Contains(column.ClickHouseType, "String") {
+			t.Errorf("column %s is filtered as a string but has type %s", column.Name, column.ClickHouseType)
+		}
+		other, _ := c.LookupColumnByKey(c.ReverseColumnDirection(column.Key))
+		if other.ConsoleFilter != column.ConsoleFilter {
+			t.Errorf("column %s and %s are not filtered the same way", column.Name, other.Name)
+		}
+	}
+	for key, expected := range map[ColumnKey]bool{
+		ColumnSrcAS:         true,
+		ColumnOutIfBoundary: true,
+		ColumnBytes:         false,
+		ColumnTimeReceived:  false,
+	} {
+		column, _ := c.LookupColumnByKey(key)
+		if column.Filterable() != expected {
+			t.Errorf("column %s filterable is %v, expected %v", key, column.Filterable(), expected)
+		}
+	}
+}
+
 func TestColumnIndex(t *testing.T) {
 	c := NewMock(t)
 	for i := ColumnTimeReceived; i < ColumnLast; i++ {
@@ -9,6 +9,15 @@ import "strings"
 func (schema *Schema) LookupColumnByName(name string) (*Column, bool) {
 	key, ok := columnNameMap.LoadKey(name)
 	if !ok {
+		// Custom dimensions are not in the name map
+		for idx := len(schema.columns) - 1; idx >= 0; idx-- {
+			if schema.columns[idx].Key < ColumnLast {
+				break
+			}
+			if schema.columns[idx].Name == name {
+				return &schema.columns[idx], true
+			}
+		}
 		return &Column{}, false
 	}
 	return schema.LookupColumnByKey(key)
@@ -16,6 +25,9 @@ func (schema *Schema) LookupColumnByName(name string) (*Column, bool) {
 
 // LookupColumnByKey can lookup a column by its key.
 func (schema *Schema) LookupColumnByKey(key ColumnKey) (*Column, bool) {
+	if int(key) >= len(schema.columnIndex) {
+		return &Column{}, false
+	}
 	column := schema.columnIndex[key]
 	if column == nil {
 		return &Column{}, false
@@ -58,6 +70,11 @@ func (schema *Schema) Columns() []Column {
 	return columns
 }
 
+// Filterable tells if the column can be used in filters.
+func (column Column) Filterable() bool {
+	return column.ConsoleFilter != 0
+}
+
 // IsDisabled tells if a column group is disabled.
 func (schema *Schema) IsDisabled(group ColumnGroup) bool {
 	return schema.disabledGroups.Test(uint(group))
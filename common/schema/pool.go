@@ -0,0 +1,55 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package schema
+
+import "sync"
+
+// protobufBufferSize is the initial capacity of a protobuf buffer. Smaller
+// buffers are not recycled.
+const protobufBufferSize = 500
+
+var (
+	// flowMessagePool recycles flow messages. Their protobuf buffer is not
+	// recycled with them as it is owned by Kafka once serialized.
+	flowMessagePool = sync.Pool{
+		New: func() interface{} { return &FlowMessage{} },
+	}
+	// protobufPool recycles protobuf buffers once sent to Kafka.
+	protobufPool sync.Pool
+)
+
+// NewFlowMessage returns an empty flow message, possibly recycled. It should
+// be released with ReleaseFlowMessage() when not needed anymore.
+func NewFlowMessage() *FlowMessage {
+	return flowMessagePool.Get().(*FlowMessage)
+}
+
+// ReleaseFlowMessage recycles a flow message. It should not be used
+// afterwards. Its protobuf buffer is not recycled: once serialized, it
+// should be released with ReleaseProtobuf().
+func ReleaseFlowMessage(bf *FlowMessage) {
+	set := bf.protobufSet
+	set.ClearAll()
+	*bf = FlowMessage{protobufSet: set}
+	flowMessagePool.Put(bf)
+}
+
+// ReleaseProtobuf recycles the buffer of a flow serialized with
+// ProtobufMarshal(). It should not be used afterwards.
+func ReleaseProtobuf(buf []byte) {
+	if cap(buf) < protobufBufferSize/2 {
+		return
+	}
+	buf = buf[:0]
+	protobufPool.Put(&buf)
+}
+
+// newProtobufBuffer returns an empty protobuf buffer, with room for the
+// length prefix.
+func newProtobufBuffer() []byte {
+	if buf, ok := protobufPool.Get().(*[]byte); ok {
+		return (*buf)[:maxSizeVarint]
+	}
+	return make([]byte, maxSizeVarint, protobufBufferSize)
+}
@@ -5,8 +5,10 @@ package schema
 
 import (
 	"encoding/base32"
+	"errors"
 	"fmt"
 	"hash/fnv"
+	"math"
 	"net/netip"
 	"strings"
 
@@ -95,7 +97,8 @@ message FlowMessagev%s {
 }
 
 // ProtobufMarshal transforms a basic flow into protobuf bytes. The provided flow should
-// not be reused afterwards.
+// not be reused afterwards. The returned buffer can be recycled with
+// ReleaseProtobuf() once not needed anymore.
 func (schema *Schema) ProtobufMarshal(bf *FlowMessage) []byte {
 	schema.ProtobufAppendVarint(bf, ColumnTimeReceived, bf.TimeReceived)
 	schema.ProtobufAppendVarint(bf, ColumnSamplingRate, uint64(bf.SamplingRate))
@@ -104,6 +107,7 @@ func (schema *Schema) ProtobufMarshal(bf *FlowMessage) []byte {
 	schema.ProtobufAppendVarint(bf, ColumnDstAS, uint64(bf.DstAS))
 	schema.ProtobufAppendIP(bf, ColumnSrcAddr, bf.SrcAddr)
 	schema.ProtobufAppendIP(bf, ColumnDstAddr, bf.DstAddr)
+	schema.ProtobufAppendBytes(bf, ColumnFlowExportDirection, []byte(bf.ExportDirection.String()))
 	if !schema.IsDisabled(ColumnGroupL2) {
 		schema.ProtobufAppendVarint(bf, ColumnSrcVlan, uint64(bf.SrcVlan))
 		schema.ProtobufAppendVarint(bf, ColumnDstVlan, uint64(bf.DstVlan))
@@ -120,6 +124,80 @@ func (schema *Schema) ProtobufMarshal(bf *FlowMessage) []byte {
 	return result
 }
 
+// ProtobufUnmarshal decodes a flow encoded with ProtobufMarshal() into the
+// values of its columns. IP addresses are returned as netip.Addr, enums as
+// lowercase strings and repeated columns as slices.
+func (schema *Schema) ProtobufUnmarshal(input []byte) (map[ColumnKey]interface{}, error) {
+	columns := map[protowire.Number]*Column{}
+	for _, column := range schema.columnIndex {
+		if column != nil && column.ProtobufIndex > 0 {
+			columns[column.ProtobufIndex] = column
+		}
+	}
+	size, n := protowire.ConsumeVarint(input)
+	if n < 0 || len(input)-n != int(size) {
+		return nil, errors.New("bad length for protobuf message")
+	}
+	b := input[n:]
+	result := map[ColumnKey]interface{}{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return nil, protowire.ParseError(n)
+		}
+		b = b[n:]
+		column, ok := columns[num]
+		if !ok {
+			return nil, fmt.Errorf("unknown protobuf field %d", num)
+		}
+		var value interface{}
+		switch typ {
+		case protowire.VarintType:
+			v, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			switch column.ProtobufType {
+			case protoreflect.EnumKind:
+				value = strings.ToLower(column.ProtobufEnum[int(v)])
+			case protoreflect.BoolKind:
+				value = v != 0
+			default:
+				value = v
+			}
+		case protowire.BytesType:
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			if column.ProtobufType == protoreflect.BytesKind {
+				ip, _ := netip.AddrFromSlice(v)
+				value = ip
+			} else {
+				value = string(v)
+			}
+		case protowire.Fixed32Type:
+			v, n := protowire.ConsumeFixed32(b)
+			if n < 0 {
+				return nil, protowire.ParseError(n)
+			}
+			b = b[n:]
+			value = math.Float32frombits(v)
+		default:
+			return nil, fmt.Errorf("unexpected wire type %d for %s", typ, column.Name)
+		}
+		if column.ProtobufRepeated {
+			current, _ := result[column.Key].([]interface{})
+			result[column.Key] = append(current, value)
+		} else {
+			result[column.Key] = value
+		}
+	}
+	return result, nil
+}
+
 // ProtobufAppendVarint append a varint to the protobuf representation of a flow.
 func (schema *Schema) ProtobufAppendVarint(bf *FlowMessage, columnKey ColumnKey, value uint64) {
 	// Check if value is 0 to avoid a lookup.
@@ -148,12 +226,157 @@ func (column *Column) ProtobufAppendVarintForce(bf *FlowMessage, value uint64) {
 		bf.protobuf = protowire.AppendTag(bf.protobuf, column.ProtobufIndex, protowire.VarintType)
 		bf.protobuf = protowire.AppendVarint(bf.protobuf, value)
 		bf.protobufSet.Set(uint(column.ProtobufIndex))
+		switch column.Key {
+		case ColumnBytes:
+			bf.counters.Bytes = value
+		case ColumnPackets:
+			bf.counters.Packets = value
+		case ColumnInIfBoundary:
+			bf.counters.InIfBoundary = uint8(value)
+		case ColumnOutIfBoundary:
+			bf.counters.OutIfBoundary = uint8(value)
+		}
 		if debug {
 			column.appendDebug(bf, value)
 		}
 	}
 }
 
+// ProtobufLookupVarint returns the first varint stored for the provided
+// column in the protobuf representation of a flow.
+func (schema *Schema) ProtobufLookupVarint(bf *FlowMessage, columnKey ColumnKey) (uint64, bool) {
+	column, ok := schema.LookupColumnByKey(columnKey)
+	if !ok || column.ProtobufIndex <= 0 || bf.protobuf == nil ||
+		!bf.protobufSet.Test(uint(column.ProtobufIndex)) {
+		return 0, false
+	}
+	b := bf.protobuf[maxSizeVarint:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+		if num == column.ProtobufIndex && typ == protowire.VarintType {
+			value, n := protowire.ConsumeVarint(b)
+			if n < 0 {
+				return 0, false
+			}
+			return value, true
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return 0, false
+		}
+		b = b[n:]
+	}
+	return 0, false
+}
+
+// ProtobufSwapDirections swaps the source and the destination of a flow, as
+// well as the input and the output interfaces. Columns describing only one
+// direction (like the AS path of the destination) are removed. Fields of
+// the flow are swapped too, except the next hop and the routing information
+// which are cleared.
+func (schema *Schema) ProtobufSwapDirections(bf *FlowMessage) {
+	bf.SrcAddr, bf.DstAddr = bf.DstAddr, bf.SrcAddr
+	bf.SrcAS, bf.DstAS = bf.DstAS, bf.SrcAS
+	bf.SrcVlan, bf.DstVlan = bf.DstVlan, bf.SrcVlan
+	bf.SrcVRFID, bf.DstVRFID = bf.DstVRFID, bf.SrcVRFID
+	bf.InIf, bf.OutIf = bf.OutIf, bf.InIf
+	bf.NextHop = netip.Addr{}
+	bf.GotASPath = false
+	bf.DstASPath = nil
+	bf.DstCommunities = nil
+	bf.counters.InIfBoundary, bf.counters.OutIfBoundary = bf.counters.OutIfBoundary, bf.counters.InIfBoundary
+	if bf.protobuf == nil {
+		return
+	}
+
+	old := bf.protobuf
+	bf.protobuf = newProtobufBuffer()
+	bf.protobufSet.ClearAll()
+	b := old[maxSizeVarint:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			break
+		}
+		b = b[n:]
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			break
+		}
+		value := b[:n]
+		b = b[n:]
+		key, ok := schema.protobufSwap[num]
+		if !ok {
+			continue
+		}
+		column, _ := schema.LookupColumnByKey(key)
+		bf.protobuf = protowire.AppendTag(bf.protobuf, column.ProtobufIndex, typ)
+		bf.protobuf = append(bf.protobuf, value...)
+		bf.protobufSet.Set(uint(column.ProtobufIndex))
+	}
+	ReleaseProtobuf(old)
+
+	if debug && bf.ProtobufDebug != nil {
+		swapped := make(map[ColumnKey]interface{}, len(bf.ProtobufDebug))
+		for key, value := range bf.ProtobufDebug {
+			column, ok := schema.LookupColumnByKey(key)
+			if !ok || column.ProtobufIndex <= 0 {
+				continue
+			}
+			if okey, ok := schema.protobufSwap[column.ProtobufIndex]; ok {
+				swapped[okey] = value
+			}
+		}
+		bf.ProtobufDebug = swapped
+	}
+}
+
+// ProtobufRewriteIPs rewrites the IP addresses stored in the protobuf
+// representation of a flow with the provided function, except the exporter
+// address. Addresses are rewritten in place.
+func (schema *Schema) ProtobufRewriteIPs(bf *FlowMessage, rewrite func(netip.Addr) netip.Addr) {
+	if bf.protobuf == nil {
+		return
+	}
+	b := bf.protobuf[maxSizeVarint:]
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+		key, ok := schema.protobufIPs[num]
+		if !ok || typ != protowire.BytesType {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return
+			}
+			b = b[n:]
+			continue
+		}
+		value, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return
+		}
+		b = b[n:]
+		if len(value) != 16 {
+			continue
+		}
+		addr := rewrite(netip.AddrFrom16(*(*[16]byte)(value)))
+		v := addr.As16()
+		copy(value, v[:])
+		if debug && bf.ProtobufDebug != nil {
+			if _, ok := bf.ProtobufDebug[key]; ok {
+				bf.ProtobufDebug[key] = addr
+			}
+		}
+	}
+}
+
 func (column Column) protobufCanAppend(bf *FlowMessage) bool {
 	return column.ProtobufIndex > 0 &&
 		!column.Disabled &&
@@ -191,6 +414,31 @@ func (column *Column) ProtobufAppendBytesForce(bf *FlowMessage, value []byte) {
 		bf.protobuf = protowire.AppendTag(bf.protobuf, column.ProtobufIndex, protowire.BytesType)
 		bf.protobuf = protowire.AppendBytes(bf.protobuf, value)
 		bf.protobufSet.Set(uint(column.ProtobufIndex))
+		if debug {
+			// The value may be backed by a buffer reused by the caller
+			column.appendDebug(bf, append([]byte{}, value...))
+		}
+	}
+}
+
+// ProtobufAppendFloat append a float to the protobuf representation of a
+// flow.
+func (schema *Schema) ProtobufAppendFloat(bf *FlowMessage, columnKey ColumnKey, value float32) {
+	// Check if value is 0 to avoid a lookup.
+	if value != 0 {
+		column, _ := schema.LookupColumnByKey(columnKey)
+		column.ProtobufAppendFloatForce(bf, value)
+	}
+}
+
+// ProtobufAppendFloatForce append a float to the protobuf representation of
+// a flow, even when 0.
+func (column *Column) ProtobufAppendFloatForce(bf *FlowMessage, value float32) {
+	bf.init()
+	if column.protobufCanAppend(bf) {
+		bf.protobuf = protowire.AppendTag(bf.protobuf, column.ProtobufIndex, protowire.Fixed32Type)
+		bf.protobuf = protowire.AppendFixed32(bf.protobuf, math.Float32bits(value))
+		bf.protobufSet.Set(uint(column.ProtobufIndex))
 		if debug {
 			column.appendDebug(bf, value)
 		}
@@ -229,6 +477,34 @@ func (column *Column) ProtobufAppendIPForce(bf *FlowMessage, value netip.Addr) {
 	}
 }
 
+// ProtobufIsSet tells if a column is present in the protobuf representation
+// of a flow.
+func (schema *Schema) ProtobufIsSet(bf *FlowMessage, columnKey ColumnKey) bool {
+	column, _ := schema.LookupColumnByKey(columnKey)
+	return column.ProtobufIndex > 0 && bf.protobufSet.Test(uint(column.ProtobufIndex))
+}
+
+// Copy returns a deep copy of a flow, including the columns already in its
+// protobuf representation. The copy does not share anything with the
+// original flow and should not be released to the pool.
+func (bf *FlowMessage) Copy() *FlowMessage {
+	flowCopy := *bf
+	flowCopy.DstASPath = append([]uint32(nil), bf.DstASPath...)
+	flowCopy.DstCommunities = append([]uint32(nil), bf.DstCommunities...)
+	if bf.protobuf != nil {
+		flowCopy.protobuf = make([]byte, len(bf.protobuf), cap(bf.protobuf))
+		copy(flowCopy.protobuf, bf.protobuf)
+	}
+	flowCopy.protobufSet = *bf.protobufSet.Clone()
+	if bf.ProtobufDebug != nil {
+		flowCopy.ProtobufDebug = make(map[ColumnKey]interface{}, len(bf.ProtobufDebug))
+		for key, value := range bf.ProtobufDebug {
+			flowCopy.ProtobufDebug[key] = value
+		}
+	}
+	return &flowCopy
+}
+
 func (column *Column) appendDebug(bf *FlowMessage, value interface{}) {
 	if bf.ProtobufDebug == nil {
 		bf.ProtobufDebug = make(map[ColumnKey]interface{})
@@ -246,7 +522,9 @@ func (column *Column) appendDebug(bf *FlowMessage, value interface{}) {
 
 func (bf *FlowMessage) init() {
 	if bf.protobuf == nil {
-		bf.protobuf = make([]byte, maxSizeVarint, 500)
-		bf.protobufSet = *bitset.New(uint(ColumnLast))
+		bf.protobuf = newProtobufBuffer()
+		if bf.protobufSet.Len() == 0 {
+			bf.protobufSet = *bitset.New(uint(ColumnLast))
+		}
 	}
 }
@@ -74,6 +74,7 @@ func TestProtobufDefinition(t *testing.T) {
 				},
 			},
 			{Key: ColumnBytes, ClickHouseType: "UInt64"},
+			{Key: ColumnSrcLatitude, ClickHouseType: "Float32"},
 		},
 	}.finalize()
 
@@ -81,7 +82,7 @@ func TestProtobufDefinition(t *testing.T) {
 	expected := `
 syntax = "proto3";
 
-message FlowMessagevLH2TTFF7P352DSYYCJYWFCXHAM {
+message FlowMessagevLNWGVXOLC6XM4F5WX3X2DL6SE4 {
  enum Boundary { UNDEFINED = 0; EXTERNAL = 1; INTERNAL = 2; }
 
  uint64 TimeReceived = 1;
@@ -105,6 +106,8 @@ message FlowMessagevLH2TTFF7P352DSYYCJYWFCXHAM {
  Boundary InIfBoundary = 19;
  Boundary OutIfBoundary = 20;
  uint64 Bytes = 21;
+ float SrcLatitude = 22;
+ float DstLatitude = 23;
 }
 `
 	if diff := helpers.Diff(strings.Split(got, "\n"), strings.Split(expected, "\n")); diff != "" {
@@ -172,6 +175,212 @@ func TestProtobufMarshal(t *testing.T) {
 			t.Fatalf("ProtobufDecode() (-got, +want):\n%s", diff)
 		}
 	})
+
+	t.Run("counters", func(t *testing.T) {
+		expected := FlowCounters{Bytes: 200, Packets: 300}
+		if diff := helpers.Diff(bf.Counters(), expected); diff != "" {
+			t.Fatalf("Counters() (-got, +want):\n%s", diff)
+		}
+	})
+}
+
+func TestProtobufAppendFloat(t *testing.T) {
+	c := NewMock(t).EnableAllColumns()
+	bf := &FlowMessage{}
+	c.ProtobufAppendFloat(bf, ColumnSrcLatitude, 51.5142)
+	c.ProtobufAppendFloat(bf, ColumnSrcLongitude, -0.0931)
+	c.ProtobufAppendFloat(bf, ColumnDstLatitude, 0) // ignored
+
+	got := c.ProtobufDecode(t, c.ProtobufMarshal(bf))
+	expected := map[ColumnKey]interface{}{
+		ColumnSrcLatitude:  float32(51.5142),
+		ColumnSrcLongitude: float32(-0.0931),
+	}
+	if diff := helpers.Diff(got.ProtobufDebug, expected); diff != "" {
+		t.Fatalf("ProtobufDecode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestProtobufAppendBytesReusedBuffer(t *testing.T) {
+	c := NewMock(t)
+	bf := &FlowMessage{}
+	buffer := []byte("Gi0/0/1")
+	c.ProtobufAppendBytes(bf, ColumnInIfName, buffer)
+	copy(buffer, "Te0/0/2")
+
+	got := c.ProtobufDecode(t, c.ProtobufMarshal(bf))
+	expected := map[ColumnKey]interface{}{
+		ColumnInIfName: "Gi0/0/1",
+	}
+	if diff := helpers.Diff(got.ProtobufDebug, expected); diff != "" {
+		t.Errorf("ProtobufDecode() (-got, +want):\n%s", diff)
+	}
+	if diff := helpers.Diff(bf.ProtobufDebug, map[ColumnKey]interface{}{
+		ColumnInIfName: []byte("Gi0/0/1"),
+	}); diff != "" {
+		t.Errorf("ProtobufDebug (-got, +want):\n%s", diff)
+	}
+}
+
+func TestProtobufUnmarshal(t *testing.T) {
+	c := NewMock(t).EnableAllColumns()
+	bf := &FlowMessage{}
+	bf.TimeReceived = 1000
+	bf.ExporterAddress = netip.MustParseAddr("::ffff:203.0.113.14")
+	c.ProtobufAppendVarint(bf, ColumnBytes, 200)
+	c.ProtobufAppendBytes(bf, ColumnDstCountry, []byte("FR"))
+	c.ProtobufAppendVarint(bf, ColumnInIfBoundary, 1)
+	c.ProtobufAppendFloat(bf, ColumnSrcLatitude, 51.5142)
+	c.ProtobufAppendVarint(bf, ColumnDstASPath, 65000)
+	c.ProtobufAppendVarint(bf, ColumnDstASPath, 65001)
+
+	got, err := c.ProtobufUnmarshal(c.ProtobufMarshal(bf))
+	if err != nil {
+		t.Fatalf("ProtobufUnmarshal() error:\n%+v", err)
+	}
+	expected := map[ColumnKey]interface{}{
+		ColumnTimeReceived:    uint64(1000),
+		ColumnExporterAddress: netip.MustParseAddr("::ffff:203.0.113.14"),
+		ColumnBytes:           uint64(200),
+		ColumnDstCountry:      "FR",
+		ColumnInIfBoundary:    "external",
+		ColumnSrcLatitude:     float32(51.5142),
+		ColumnDstASPath:       []interface{}{uint64(65000), uint64(65001)},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("ProtobufUnmarshal() (-got, +want):\n%s", diff)
+	}
+
+	if _, err := c.ProtobufUnmarshal([]byte{10, 1}); err == nil {
+		t.Fatal("ProtobufUnmarshal() did not error on a truncated message")
+	}
+}
+
+func TestProtobufLookupVarint(t *testing.T) {
+	c := NewMock(t)
+	bf := &FlowMessage{}
+	if _, ok := c.ProtobufLookupVarint(bf, ColumnBytes); ok {
+		t.Fatal("ProtobufLookupVarint() found Bytes in an empty flow")
+	}
+	c.ProtobufAppendBytes(bf, ColumnDstCountry, []byte("FR"))
+	c.ProtobufAppendVarint(bf, ColumnProto, 6)
+	c.ProtobufAppendVarint(bf, ColumnBytes, 200)
+	c.ProtobufAppendVarint(bf, ColumnBytes, 300) // duplicate!
+	if got, ok := c.ProtobufLookupVarint(bf, ColumnBytes); !ok || got != 200 {
+		t.Fatalf("ProtobufLookupVarint(Bytes) == %d, %v", got, ok)
+	}
+	if got, ok := c.ProtobufLookupVarint(bf, ColumnProto); !ok || got != 6 {
+		t.Fatalf("ProtobufLookupVarint(Proto) == %d, %v", got, ok)
+	}
+	if _, ok := c.ProtobufLookupVarint(bf, ColumnPackets); ok {
+		t.Fatal("ProtobufLookupVarint() found Packets")
+	}
+}
+
+func TestProtobufRecycling(t *testing.T) {
+	c := NewMock(t)
+	for i := 0; i < 3; i++ {
+		bf := NewFlowMessage()
+		if _, ok := c.ProtobufLookupVarint(bf, ColumnBytes); ok {
+			t.Fatalf("ProtobufLookupVarint() found Bytes in a recycled flow (round %d)", i)
+		}
+		bf.TimeReceived = 1000
+		c.ProtobufAppendVarint(bf, ColumnBytes, uint64(200+i))
+		c.ProtobufAppendVarint(bf, ColumnPackets, 300)
+		buf := c.ProtobufMarshal(bf)
+		got := c.ProtobufDecode(t, buf)
+		expected := FlowMessage{
+			TimeReceived: 1000,
+			ProtobufDebug: map[ColumnKey]interface{}{
+				ColumnBytes:   200 + i,
+				ColumnPackets: 300,
+			},
+		}
+		if diff := helpers.Diff(got, expected); diff != "" {
+			t.Fatalf("ProtobufDecode() (round %d, -got, +want):\n%s", i, diff)
+		}
+		ReleaseFlowMessage(bf)
+		ReleaseProtobuf(buf)
+	}
+}
+
+func TestFlowMessageCopy(t *testing.T) {
+	c := NewMock(t)
+	bf := &FlowMessage{TimeReceived: 1000, DstASPath: []uint32{65000}}
+	c.ProtobufAppendVarint(bf, ColumnBytes, 200)
+	flowCopy := bf.Copy()
+
+	// Modifying the original flow does not alter the copy
+	bf.DstASPath[0] = 65001
+	c.ProtobufAppendVarint(bf, ColumnPackets, 300)
+	c.ProtobufAppendVarint(flowCopy, ColumnPackets, 400)
+
+	got := c.ProtobufDecode(t, c.ProtobufMarshal(flowCopy))
+	expected := FlowMessage{
+		TimeReceived: 1000,
+		ProtobufDebug: map[ColumnKey]interface{}{
+			ColumnBytes:   200,
+			ColumnPackets: 400,
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Errorf("Copy() (-got, +want):\n%s", diff)
+	}
+	if diff := helpers.Diff(flowCopy.DstASPath, []uint32{65000}); diff != "" {
+		t.Errorf("Copy() AS path (-got, +want):\n%s", diff)
+	}
+}
+
+func TestProtobufSwapDirections(t *testing.T) {
+	c := NewMock(t).EnableAllColumns()
+	bf := &FlowMessage{
+		TimeReceived: 1000,
+		SrcAddr:      netip.MustParseAddr("::ffff:192.0.2.1"),
+		DstAddr:      netip.MustParseAddr("::ffff:198.51.100.1"),
+		NextHop:      netip.MustParseAddr("::ffff:203.0.113.1"),
+		SrcAS:        65001,
+		DstAS:        65002,
+		InIf:         10,
+		OutIf:        20,
+	}
+	c.ProtobufAppendVarint(bf, ColumnBytes, 200)
+	c.ProtobufAppendBytes(bf, ColumnInIfName, []byte("eth0"))
+	c.ProtobufAppendBytes(bf, ColumnOutIfName, []byte("eth1"))
+	c.ProtobufAppendVarint(bf, ColumnInIfBoundary, 1)
+	c.ProtobufAppendVarint(bf, ColumnOutIfBoundary, 2)
+	c.ProtobufAppendBytes(bf, ColumnSrcCountry, []byte("FR"))
+	c.ProtobufAppendVarint(bf, ColumnDstASPath, 65002)
+	c.ProtobufAppendVarint(bf, ColumnDstASPath, 65003)
+
+	c.ProtobufSwapDirections(bf)
+	c.ProtobufAppendBytes(bf, ColumnSrcCountry, []byte("US"))
+	c.ProtobufAppendBytes(bf, ColumnDstCountry, []byte("DE")) // duplicate!
+
+	expectedCounters := FlowCounters{Bytes: 200, InIfBoundary: 2, OutIfBoundary: 1}
+	if diff := helpers.Diff(bf.Counters(), expectedCounters); diff != "" {
+		t.Fatalf("Counters() (-got, +want):\n%s", diff)
+	}
+
+	got := c.ProtobufDecode(t, c.ProtobufMarshal(bf))
+	expected := FlowMessage{
+		TimeReceived: 1000,
+		SrcAddr:      netip.MustParseAddr("::ffff:198.51.100.1"),
+		DstAddr:      netip.MustParseAddr("::ffff:192.0.2.1"),
+		SrcAS:        65002,
+		DstAS:        65001,
+		ProtobufDebug: map[ColumnKey]interface{}{
+			ColumnBytes:         200,
+			ColumnOutIfName:     "eth0",
+			ColumnInIfName:      "eth1",
+			ColumnOutIfBoundary: 1,
+			ColumnInIfBoundary:  2,
+			ColumnSrcCountry:    "US",
+			ColumnDstCountry:    "FR",
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("ProtobufDecode() (-got, +want):\n%s", diff)
+	}
 }
 
 func BenchmarkProtobufMarshal(b *testing.B) {
@@ -193,3 +402,24 @@ func BenchmarkProtobufMarshal(b *testing.B) {
 		c.ProtobufMarshal(bf)
 	}
 }
+
+func BenchmarkProtobufMarshalRecycled(b *testing.B) {
+	c := NewMock(b)
+	exporterAddress := netip.MustParseAddr("::ffff:203.0.113.14")
+	DisableDebug(b)
+	for i := 0; i < b.N; i++ {
+		bf := NewFlowMessage()
+		bf.TimeReceived = 1000
+		bf.SamplingRate = 20000
+		bf.ExporterAddress = exporterAddress
+		c.ProtobufAppendVarint(bf, ColumnDstAS, 65000)
+		c.ProtobufAppendVarint(bf, ColumnBytes, 200)
+		c.ProtobufAppendVarint(bf, ColumnPackets, 300)
+		c.ProtobufAppendVarint(bf, ColumnBytes, 300)    // duplicate!
+		c.ProtobufAppendVarint(bf, ColumnSrcVlan, 1600) // disabled!
+		c.ProtobufAppendBytes(bf, ColumnDstCountry, []byte("FR"))
+		buf := c.ProtobufMarshal(bf)
+		ReleaseFlowMessage(bf)
+		ReleaseProtobuf(buf)
+	}
+}
@@ -8,6 +8,7 @@ package schema
 
 import (
 	"fmt"
+	"strings"
 
 	"golang.org/x/exp/slices"
 )
@@ -74,6 +75,31 @@ func New(config Configuration) (*Component, error) {
 			column.ClickHouseMainOnly = true
 		}
 	}
+	for idx, cd := range config.CustomDimensions {
+		if _, ok := schema.LookupColumnByName(cd.Name); ok {
+			return nil, fmt.Errorf("custom dimension %q already exists", cd.Name)
+		}
+		for _, other := range config.CustomDimensions[:idx] {
+			if strings.EqualFold(other.Name, cd.Name) {
+				return nil, fmt.Errorf("custom dimension %q defined twice", cd.Name)
+			}
+		}
+		column := Column{
+			Key:         ColumnLast + ColumnKey(idx),
+			Name:        cd.Name,
+			Description: cd.Description,
+			Sources:     []ColumnSource{ColumnSourceFlow},
+		}
+		switch cd.Type {
+		case CustomDimensionTypeString:
+			column.ClickHouseType = "LowCardinality(String)"
+			column.ConsoleFilter = ColumnFilterString
+		case CustomDimensionTypeUint:
+			column.ClickHouseType = "UInt64"
+			column.ConsoleFilter = ColumnFilterUint
+		}
+		schema.columns = append(schema.columns, column)
+	}
 	return &Component{
 		c:      config,
 		Schema: schema.finalize(),
@@ -61,3 +61,62 @@ func TestDisableForbiddenColumns(t *testing.T) {
 		t.Fatalf("New() error:\n%+v", err)
 	}
 }
+
+func TestCustomDimensions(t *testing.T) {
+	config := schema.DefaultConfiguration()
+	config.CustomDimensions = []schema.CustomDimension{
+		{Name: "AppID", Type: schema.CustomDimensionTypeString},
+		{Name: "UserID", Type: schema.CustomDimensionTypeUint},
+	}
+	c, err := schema.New(config)
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	appID, ok := c.LookupColumnByName("AppID")
+	if !ok {
+		t.Fatal("AppID not found")
+	}
+	if appID.ClickHouseType != "LowCardinality(String)" {
+		t.Fatalf("AppID type is %q", appID.ClickHouseType)
+	}
+	userID, ok := c.LookupColumnByName("UserID")
+	if !ok {
+		t.Fatal("UserID not found")
+	}
+	if userID.ClickHouseType != "UInt64" {
+		t.Fatalf("UserID type is %q", userID.ClickHouseType)
+	}
+	if column, ok := c.LookupColumnByKey(userID.Key); !ok || column.Name != "UserID" {
+		t.Fatal("UserID not found by key")
+	}
+
+	// Protobuf indexes should be unique
+	indexes := map[int32]string{}
+	for _, column := range c.Columns() {
+		if column.ProtobufIndex <= 0 {
+			continue
+		}
+		if other, ok := indexes[int32(column.ProtobufIndex)]; ok {
+			t.Fatalf("%s and %s share the same Protobuf index", other, column.Name)
+		}
+		indexes[int32(column.ProtobufIndex)] = column.Name
+	}
+	if _, ok := indexes[int32(appID.ProtobufIndex)]; !ok {
+		t.Fatal("AppID has no Protobuf index")
+	}
+}
+
+func TestInvalidCustomDimensions(t *testing.T) {
+	config := schema.DefaultConfiguration()
+	config.CustomDimensions = []schema.CustomDimension{{Name: "SrcAS"}}
+	if _, err := schema.New(config); err == nil {
+		t.Fatal("New() did not error")
+	}
+
+	config = schema.DefaultConfiguration()
+	config.CustomDimensions = []schema.CustomDimension{{Name: "AppID"}, {Name: "appid"}}
+	if _, err := schema.New(config); err == nil {
+		t.Fatal("New() did not error")
+	}
+}
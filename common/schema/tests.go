@@ -18,6 +18,7 @@ import (
 	"github.com/jhump/protoreflect/desc/protoparse"
 	"github.com/jhump/protoreflect/dynamic"
 	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 var debug = true
@@ -110,6 +111,9 @@ func (schema *Schema) ProtobufDecode(t *testing.T, input []byte) *FlowMessage {
 			if reflect.ValueOf(value).IsZero() {
 				break
 			}
+			if column.ProtobufType == protoreflect.BytesKind {
+				value, _ = netip.AddrFromSlice(value.([]byte))
+			}
 			flow.ProtobufDebug[key] = value
 		}
 	}
@@ -127,5 +131,12 @@ func (schema *Component) EnableAllColumns() *Component {
 }
 
 func init() {
-	helpers.AddPrettyFormatter(reflect.TypeOf(ColumnBytes), fmt.Sprint)
+	// Custom dimensions have no global name. Display them by index to keep
+	// them distinct in diffs.
+	helpers.AddPrettyFormatter(reflect.TypeOf(ColumnBytes), func(key ColumnKey) string {
+		if key >= ColumnLast {
+			return fmt.Sprintf("CustomDimension%d", key-ColumnLast)
+		}
+		return key.String()
+	})
 }
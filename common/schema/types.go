@@ -4,6 +4,7 @@
 package schema
 
 import (
+	"errors"
 	"net/netip"
 
 	"github.com/bits-and-blooms/bitset"
@@ -17,6 +18,14 @@ type Schema struct {
 	columnIndex    []*Column     // Columns indexed by ColumnKey
 	disabledGroups bitset.BitSet // Disabled column groups
 
+	// For protobuf. This maps the index of a column to the key of the
+	// column for the other direction (Src ↔ Dst, InIf ↔ OutIf). Columns
+	// describing only one direction are absent.
+	protobufSwap map[protowire.Number]ColumnKey
+	// This maps the index of a column storing an IP address to its key. The
+	// exporter address is absent.
+	protobufIPs map[protowire.Number]ColumnKey
+
 	// For ClickHouse. This is the set of primary keys (order is important and
 	// may not follow column order) for the aggregated tables.
 	clickHousePrimaryKeys []ColumnKey
@@ -47,9 +56,12 @@ type Column struct {
 	ClickHouseMainOnly      bool
 
 	// For the console. `ClickHouseTruncateIP' makes the specified column
-	// truncatable when used as a dimension.
+	// truncatable when used as a dimension. `ConsoleFilter' is the kind of
+	// conditions accepted by the column in filters. The column cannot be
+	// used in filters when it is not set.
 	ConsoleNotDimension bool
 	ConsoleTruncateIP   bool
+	ConsoleFilter       ColumnFilter
 
 	// For protobuf. The index is automatically derived from the position,
 	// unless specified. Use -1 to not include the column into the protobuf
@@ -59,6 +71,12 @@ type Column struct {
 	ProtobufEnum     map[int]string
 	ProtobufEnumName string
 	ProtobufRepeated bool
+
+	// For documentation. `Sources' are the components populating the
+	// column. For columns duplicated during init, "source" and "input" in
+	// `Description' are replaced by "destination" and "output".
+	Description string
+	Sources     []ColumnSource
 }
 
 // ColumnKey is the name of a column
@@ -67,6 +85,27 @@ type ColumnKey int
 // ColumnGroup represents a group of columns
 type ColumnGroup uint
 
+// ColumnSource is a component populating a column
+type ColumnSource uint
+
+// ColumnFilter is the kind of conditions accepted by a column in filters
+type ColumnFilter uint
+
+// MarshalText turns a column source to text.
+func (cs ColumnSource) MarshalText() ([]byte, error) {
+	got, ok := columnSourceMap.LoadValue(cs)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown column source")
+}
+
+// String turns a column source to string.
+func (cs ColumnSource) String() string {
+	got, _ := columnSourceMap.LoadValue(cs)
+	return got
+}
+
 // FlowMessage is the abstract representation of a flow through various subsystems.
 type FlowMessage struct {
 	TimeReceived uint64
@@ -81,6 +120,10 @@ type FlowMessage struct {
 	SrcVlan uint16
 	DstVlan uint16
 
+	// For VRF naming
+	SrcVRFID uint32
+	DstVRFID uint32
+
 	// For geolocation or BMP
 	SrcAddr netip.Addr
 	DstAddr netip.Addr
@@ -89,12 +132,59 @@ type FlowMessage struct {
 	// Core component may override them
 	SrcAS     uint32
 	DstAS     uint32
-	GotASPath bool
+	GotASPath bool // AS path and communities provided by the flow
+
+	// Routing information for the destination, written by the core
+	// component when it has precedence over BMP.
+	DstASPath      []uint32
+	DstCommunities []uint32
+
+	// For export direction policy
+	ExportDirection FlowExportDirection
 
 	// protobuf is the protobuf representation for the information not contained above.
 	protobuf      []byte
 	protobufSet   bitset.BitSet
+	counters      FlowCounters
 	ProtobufDebug map[ColumnKey]interface{} `json:"-"` // for testing purpose
 }
 
+// FlowCounters are the values of a flow kept aside from its protobuf
+// representation to maintain in-memory throughput counters.
+type FlowCounters struct {
+	Bytes         uint64
+	Packets       uint64
+	InIfBoundary  uint8
+	OutIfBoundary uint8
+}
+
+// Counters returns the counters for the flow.
+func (bf *FlowMessage) Counters() FlowCounters {
+	return bf.counters
+}
+
 const maxSizeVarint = 10 // protowire.SizeVarint(^uint64(0))
+
+// FlowExportDirection tells if a flow was observed by the exporter when
+// entering (ingress) or when leaving (egress) it.
+type FlowExportDirection uint8
+
+const (
+	// FlowExportDirectionUndefined is used when the exporter does not tell.
+	FlowExportDirectionUndefined FlowExportDirection = iota
+	// FlowExportDirectionIngress is for flows observed when entering the exporter.
+	FlowExportDirectionIngress
+	// FlowExportDirectionEgress is for flows observed when leaving the exporter.
+	FlowExportDirectionEgress
+)
+
+// String turns a flow export direction into a string.
+func (d FlowExportDirection) String() string {
+	switch d {
+	case FlowExportDirectionIngress:
+		return "ingress"
+	case FlowExportDirectionEgress:
+		return "egress"
+	}
+	return ""
+}
@@ -0,0 +1,62 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/helpers"
+)
+
+// annotationKinds are the kinds of changelog entries displayed as
+// annotations. Rule sets picked up by inlets are not displayed as they
+// follow the configuration changes.
+var annotationKinds = []string{"rules", "schema", "migration"}
+
+type annotationsHandlerInput struct {
+	Start time.Time `form:"start" binding:"required"`
+	End   time.Time `form:"end" binding:"required,gtfield=Start"`
+}
+
+type annotation struct {
+	Time        time.Time `json:"time"`
+	Kind        string    `json:"kind"`
+	Subject     string    `json:"subject"`
+	Description string    `json:"description"`
+}
+
+// annotationsHandlerFunc returns the changes affecting the semantics of the
+// data during the provided period.
+func (c *Component) annotationsHandlerFunc(gc *gin.Context) {
+	var input annotationsHandlerInput
+	if err := gc.ShouldBindQuery(&input); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	entries, err := c.d.ClickHouseDB.Changelog(c.t.Context(gc.Request.Context()), clickhousedb.ChangelogQuery{
+		Start: input.Start,
+		End:   input.End,
+		Kinds: annotationKinds,
+		Limit: 100,
+	})
+	if err != nil {
+		c.r.Err(err).Msg("unable to query database")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+		return
+	}
+	annotations := make([]annotation, 0, len(entries))
+	for _, entry := range entries {
+		annotations = append(annotations, annotation{
+			Time:        entry.Time.UTC(),
+			Kind:        entry.Kind,
+			Subject:     entry.Subject,
+			Description: entry.Description,
+		})
+	}
+	gc.JSON(http.StatusOK, gin.H{"annotations": annotations})
+}
@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/helpers"
+)
+
+func TestAnnotationsHandler(t *testing.T) {
+	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+	start := time.Date(2023, time.May, 10, 0, 0, 0, 0, time.UTC)
+	end := time.Date(2023, time.May, 11, 0, 0, 0, 0, time.UTC)
+
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any(),
+			start, end, []string{"rules", "schema", "migration"}).
+		SetArg(1, []clickhousedb.ChangelogEntry{
+			{
+				Time:        time.Date(2023, time.May, 10, 12, 0, 0, 0, time.UTC),
+				Kind:        "rules",
+				Subject:     "inlet/0",
+				Author:      "orchestrator@orchestrator1",
+				Hash:        "1234",
+				Description: "rules changed",
+				Diff:        "-default-sampling-rate: 1000\n+default-sampling-rate: 100\n",
+			}, {
+				Time:        time.Date(2023, time.May, 10, 8, 0, 0, 0, time.UTC),
+				Kind:        "migration",
+				Subject:     "default",
+				Author:      "orchestrator@orchestrator1",
+				Hash:        "5678",
+				Description: "3 migration steps applied",
+			},
+		}).
+		Return(nil)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "annotations",
+			URL:         "/api/v0/console/annotations?start=2023-05-10T00:00:00Z&end=2023-05-11T00:00:00Z",
+			JSONOutput: gin.H{
+				"annotations": []gin.H{
+					{
+						"time":        "2023-05-10T12:00:00Z",
+						"kind":        "rules",
+						"subject":     "inlet/0",
+						"description": "rules changed",
+					}, {
+						"time":        "2023-05-10T08:00:00Z",
+						"kind":        "migration",
+						"subject":     "default",
+						"description": "3 migration steps applied",
+					},
+				},
+			},
+		}, {
+			Description: "annotations with reversed period",
+			URL:         "/api/v0/console/annotations?start=2023-05-11T00:00:00Z&end=2023-05-10T00:00:00Z",
+			StatusCode:  400,
+			JSONOutput: gin.H{
+				"message": "Key: 'annotationsHandlerInput.End' Error:Field validation for 'End' failed on the 'gtfield' tag",
+			},
+		},
+	})
+}
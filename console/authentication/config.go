@@ -6,19 +6,21 @@ package authentication
 // Configuration describes the configuration for the authentication component.
 type Configuration struct {
 	// Headers define authentication headers
-	Headers ConfigurationHeaders
+	Headers ConfigurationHeaders `doc:"Headers providing user information"`
 	// DefaultUser define the default user when no authentication
 	// headers are present. Leave `User' empty to not allow access
 	// without authentication.
-	DefaultUser UserInformation
+	DefaultUser UserInformation `doc:"Default user when authentication headers are absent (empty login to deny access)"`
+	// Admins is the list of logins with administrative privileges.
+	Admins []string `doc:"Logins of users with administrative privileges"`
 }
 
 // ConfigurationHeaders define headers used for authentication
 type ConfigurationHeaders struct {
-	Login     string
-	Name      string
-	Email     string
-	LogoutURL string
+	Login     string `doc:"Header with the user login"`
+	Name      string `doc:"Header with the user name"`
+	Email     string `doc:"Header with the user email"`
+	LogoutURL string `doc:"Header with the logout URL"`
 }
 
 // DefaultConfiguration represents the default configuration for the console component.
@@ -104,6 +104,39 @@ func TestUserHandler(t *testing.T) {
 		})
 	})
 
+	t.Run("administrator", func(t *testing.T) {
+		c.config.Admins = []string{"alfred"}
+		helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+			{
+				Description: "user info, administrator logged in",
+				URL:         "/api/v0/console/user/info",
+				Header: func() netHTTP.Header {
+					headers := make(netHTTP.Header)
+					headers.Add("Remote-User", "alfred")
+					return headers
+				}(),
+				StatusCode: 200,
+				JSONOutput: gin.H{
+					"login": "alfred",
+					"admin": true,
+				},
+			}, {
+				Description: "user info, regular user logged in",
+				URL:         "/api/v0/console/user/info",
+				Header: func() netHTTP.Header {
+					headers := make(netHTTP.Header)
+					headers.Add("Remote-User", "bruce")
+					return headers
+				}(),
+				StatusCode: 200,
+				JSONOutput: gin.H{
+					"login": "bruce",
+				},
+			},
+		})
+		c.config.Admins = nil
+	})
+
 	t.Run("no default user", func(t *testing.T) {
 		c.config.DefaultUser.Login = ""
 		helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
@@ -9,14 +9,18 @@ import (
 
 	"github.com/gin-gonic/gin"
 	"github.com/gin-gonic/gin/binding"
+	"golang.org/x/exp/slices"
 )
 
 // UserInformation contains information about the current user.
 type UserInformation struct {
-	Login     string `json:"login" header:"LOGIN" binding:"required"`
-	Name      string `json:"name,omitempty" header:"NAME"`
-	Email     string `json:"email,omitempty" header:"EMAIL" binding:"omitempty,email"`
-	LogoutURL string `json:"logout-url,omitempty" header:"LOGOUT" binding:"omitempty,uri"`
+	Login     string `json:"login" header:"LOGIN" binding:"required" doc:"User login"`
+	Name      string `json:"name,omitempty" header:"NAME" doc:"User name"`
+	Email     string `json:"email,omitempty" header:"EMAIL" binding:"omitempty,email" doc:"User email"`
+	LogoutURL string `json:"logout-url,omitempty" header:"LOGOUT" binding:"omitempty,uri" doc:"Logout URL"`
+	// Admin tells if the user is an administrator. It is derived from the
+	// configured list of administrators.
+	Admin bool `json:"admin,omitempty" yaml:"-" mapstructure:"-"`
 }
 
 // UserAuthentication is a middleware to fill information about the
@@ -33,6 +37,7 @@ func (c *Component) UserAuthentication() gin.HandlerFunc {
 			}
 			info = c.config.DefaultUser
 		}
+		info.Admin = slices.Contains(c.config.Admins, info.Login)
 		gc.Set("user", info)
 		gc.Next()
 	}
@@ -0,0 +1,159 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// queryCache is a LRU cache for the results of graph queries. The key is the
+// finalized SQL query. It is deterministic as it embeds the selected table,
+// the start and end times normalized with normalizeTimeRange(), the
+// normalized filter, the dimensions and the units. Dimensions are not sorted
+// as their order is significant in the result.
+type queryCache struct {
+	lock  sync.Mutex
+	size  int
+	ttl   time.Duration // maximum TTL
+	items map[[sha256.Size]byte]*list.Element
+	lru   *list.List // most recently used first
+}
+
+// queryCacheItem is an item stored in the query cache.
+type queryCacheItem struct {
+	key     [sha256.Size]byte
+	value   interface{}
+	expires time.Time
+}
+
+// newQueryCache creates a new query cache with the provided size and TTL.
+func newQueryCache(size int, ttl time.Duration) *queryCache {
+	return &queryCache{
+		size:  size,
+		ttl:   ttl,
+		items: make(map[[sha256.Size]byte]*list.Element),
+		lru:   list.New(),
+	}
+}
+
+// get retrieves the result for the provided query from the cache.
+func (qc *queryCache) get(now time.Time, query string) (interface{}, bool) {
+	key := sha256.Sum256([]byte(query))
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	element, ok := qc.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := element.Value.(*queryCacheItem)
+	if !now.Before(item.expires) {
+		qc.lru.Remove(element)
+		delete(qc.items, key)
+		return nil, false
+	}
+	qc.lru.MoveToFront(element)
+	return item.value, true
+}
+
+// put stores the result for the provided query in the cache for the provided
+// duration, capped to the TTL of the cache. The least recently used items are
+// evicted when the cache is full.
+func (qc *queryCache) put(now time.Time, query string, value interface{}, ttl time.Duration) {
+	if qc.size == 0 || ttl <= 0 {
+		return
+	}
+	if ttl > qc.ttl {
+		ttl = qc.ttl
+	}
+	key := sha256.Sum256([]byte(query))
+	qc.lock.Lock()
+	defer qc.lock.Unlock()
+	if element, ok := qc.items[key]; ok {
+		item := element.Value.(*queryCacheItem)
+		item.value = value
+		item.expires = now.Add(ttl)
+		qc.lru.MoveToFront(element)
+		return
+	}
+	for qc.lru.Len() >= qc.size {
+		oldest := qc.lru.Back()
+		qc.lru.Remove(oldest)
+		delete(qc.items, oldest.Value.(*queryCacheItem).key)
+	}
+	qc.items[key] = qc.lru.PushFront(&queryCacheItem{
+		key:     key,
+		value:   value,
+		expires: now.Add(ttl),
+	})
+}
+
+// queryCacheStatus tracks the cache status of the queries needed by a
+// request.
+type queryCacheStatus struct {
+	hits   int
+	misses int
+}
+
+// String returns the value for the Cache-Status header.
+func (qcs queryCacheStatus) String() string {
+	switch {
+	case qcs.misses == 0:
+		return "hit"
+	case qcs.hits == 0:
+		return "miss"
+	default:
+		return "partial"
+	}
+}
+
+// normalizeTimeRange shifts the provided time range to align its end on the
+// resolution of the graph with the provided number of points. The duration of
+// the range is kept. This way, requests for the last hours issued a few
+// seconds apart share the same SQL query. The resolution is returned.
+func (c *Component) normalizeTimeRange(start, end *time.Time, points uint) time.Duration {
+	targetInterval := end.Sub(*start) / time.Duration(points)
+	_, resolution := c.getBestTable(*start, targetInterval)
+	if targetInterval > resolution {
+		resolution = targetInterval.Truncate(resolution)
+	}
+	shift := end.Sub(end.Truncate(resolution))
+	*start = start.Add(-shift)
+	*end = end.Add(-shift)
+	return resolution
+}
+
+// cachedSelect executes the provided query, unless its result is already in
+// cache. As data may still be arriving, results of requests ending too
+// recently are only kept until the next point. When the cache is bypassed,
+// the result is still stored.
+func cachedSelect[T any](c *Component, gc *gin.Context, input graphCommonHandlerInput, status *queryCacheStatus, dest *[]T, query string) error {
+	now := c.d.Clock.Now()
+	ttl := c.config.CacheTTL
+	if !input.End.Before(now.Add(-c.config.CacheStableDelay)) && input.resolution < ttl {
+		ttl = input.resolution
+	}
+	if !input.BypassCache {
+		if result, ok := c.queryCache.get(now, query); ok {
+			*dest = append([]T{}, result.([]T)...)
+			status.hits++
+			c.metrics.queryCacheHits.Inc()
+			gc.Header("Cache-Status", status.String())
+			return nil
+		}
+	}
+	status.misses++
+	c.metrics.queryCacheMisses.Inc()
+	gc.Header("Cache-Status", status.String())
+	ctx := c.t.Context(gc.Request.Context())
+	if err := c.d.ClickHouseDB.Conn.Select(ctx, dest, query); err != nil {
+		return err
+	}
+	c.queryCache.put(now, query, append([]T{}, *dest...), ttl)
+	return nil
+}
@@ -0,0 +1,185 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	netHTTP "net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/helpers"
+)
+
+func TestQueryCache(t *testing.T) {
+	qc := newQueryCache(2, time.Minute)
+	now := time.Date(2023, 3, 10, 10, 0, 0, 0, time.UTC)
+	expectCacheGet := func(now time.Time, query string, expected interface{}) {
+		t.Helper()
+		got, ok := qc.get(now, query)
+		if expected == nil && ok {
+			t.Errorf("get(%q) == %v but expected a miss", query, got)
+		} else if expected != nil && !ok {
+			t.Errorf("get(%q) is a miss but expected %v", query, expected)
+		} else if diff := helpers.Diff(got, expected); diff != "" {
+			t.Errorf("get(%q) (-got, +want):\n%s", query, diff)
+		}
+	}
+
+	qc.put(now, "SELECT 1", 1, time.Minute)
+	qc.put(now, "SELECT 2", 2, time.Minute)
+	expectCacheGet(now, "SELECT 1", 1)
+	expectCacheGet(now, "SELECT 2", 2)
+	expectCacheGet(now, "SELECT 3", nil)
+
+	// SELECT 1 is the least recently used
+	qc.put(now, "SELECT 3", 3, time.Minute)
+	expectCacheGet(now, "SELECT 1", nil)
+	expectCacheGet(now, "SELECT 2", 2)
+	expectCacheGet(now, "SELECT 3", 3)
+
+	// Update an existing item
+	qc.put(now.Add(30*time.Second), "SELECT 2", 4, time.Minute)
+	expectCacheGet(now.Add(59*time.Second), "SELECT 2", 4)
+	expectCacheGet(now.Add(59*time.Second), "SELECT 3", 3)
+
+	// Expiration
+	expectCacheGet(now.Add(time.Minute), "SELECT 3", nil)
+	expectCacheGet(now.Add(time.Minute), "SELECT 2", 4)
+	expectCacheGet(now.Add(90*time.Second), "SELECT 2", nil)
+	if qc.lru.Len() != 0 || len(qc.items) != 0 {
+		t.Errorf("cache not empty after expiration (%d, %d)", qc.lru.Len(), len(qc.items))
+	}
+
+	// Custom TTL, capped to the TTL of the cache
+	qc.put(now, "SELECT 5", 5, 10*time.Second)
+	qc.put(now, "SELECT 6", 6, time.Hour)
+	expectCacheGet(now.Add(9*time.Second), "SELECT 5", 5)
+	expectCacheGet(now.Add(10*time.Second), "SELECT 5", nil)
+	expectCacheGet(now.Add(59*time.Second), "SELECT 6", 6)
+	expectCacheGet(now.Add(time.Minute), "SELECT 6", nil)
+
+	// Disabled cache
+	qc = newQueryCache(0, time.Minute)
+	qc.put(now, "SELECT 1", 1, time.Minute)
+	expectCacheGet(now, "SELECT 1", nil)
+}
+
+func TestQueryCacheStatus(t *testing.T) {
+	cases := []struct {
+		Status   queryCacheStatus
+		Expected string
+	}{
+		{queryCacheStatus{hits: 1}, "hit"},
+		{queryCacheStatus{misses: 1}, "miss"},
+		{queryCacheStatus{hits: 1, misses: 1}, "partial"},
+	}
+	for _, tc := range cases {
+		if got := tc.Status.String(); got != tc.Expected {
+			t.Errorf("%+v.String() == %q but expected %q", tc.Status, got, tc.Expected)
+		}
+	}
+}
+
+func TestGraphHandlerCache(t *testing.T) {
+	c, h, mockConn, mockClock := NewMock(t, DefaultConfiguration())
+	mockClock.Set(time.Date(2022, 4, 11, 16, 0, 0, 0, time.UTC))
+
+	expectedSQL := []struct {
+		Xps           float64  `ch:"xps"`
+		Dimensions    []string `ch:"dimensions"`
+		AvgPacketSize float64  `ch:"avgPacketSize"`
+		MinPacketSize float64  `ch:"minPacketSize"`
+		MaxPacketSize float64  `ch:"maxPacketSize"`
+		Pps           float64  `ch:"pps"`
+	}{
+		{Xps: 9677, Dimensions: []string{"AS100"}},
+		{Xps: 9472, Dimensions: []string{"AS300"}},
+	}
+	input := gin.H{
+		"start":      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+		"end":        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+		"dimensions": []string{"SrcAS"},
+		"limit":      10,
+		"filter":     "DstCountry = 'FR'",
+		"units":      "l3bps",
+	}
+	request := func(input gin.H) string {
+		t.Helper()
+		payload := new(bytes.Buffer)
+		json.NewEncoder(payload).Encode(input)
+		resp, err := netHTTP.Post(fmt.Sprintf("http://%s/api/v0/console/graph/sankey", h.LocalAddr()),
+			"application/json", payload)
+		if err != nil {
+			t.Fatalf("POST /api/v0/console/graph/sankey:\n%+v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("POST /api/v0/console/graph/sankey: got status code %d, not 200", resp.StatusCode)
+		}
+		return resp.Header.Get("Cache-Status")
+	}
+
+	// First request is a miss, second one is a hit
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedSQL).
+		Return(nil)
+	if got := request(input); got != "miss" {
+		t.Errorf("First request: Cache-Status == %q but expected %q", got, "miss")
+	}
+	if got := request(input); got != "hit" {
+		t.Errorf("Second request: Cache-Status == %q but expected %q", got, "hit")
+	}
+
+	// A request a few seconds later uses the same normalized query
+	input["start"] = time.Date(2022, 4, 10, 15, 45, 20, 0, time.UTC)
+	input["end"] = time.Date(2022, 4, 11, 15, 45, 20, 0, time.UTC)
+	if got := request(input); got != "hit" {
+		t.Errorf("Later request: Cache-Status == %q but expected %q", got, "hit")
+	}
+
+	// Bypassing the cache
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedSQL).
+		Return(nil)
+	input["bypass-cache"] = true
+	if got := request(input); got != "miss" {
+		t.Errorf("Bypass request: Cache-Status == %q but expected %q", got, "miss")
+	}
+	delete(input, "bypass-cache")
+
+	// Recent data is only cached until the next point (3 minutes)
+	input["start"] = time.Date(2022, 4, 11, 15, 0, 0, 0, time.UTC)
+	input["end"] = time.Date(2022, 4, 11, 16, 0, 0, 0, time.UTC)
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedSQL).
+		Return(nil)
+	if got := request(input); got != "miss" {
+		t.Errorf("Recent request: Cache-Status == %q but expected %q", got, "miss")
+	}
+	if got := request(input); got != "hit" {
+		t.Errorf("Recent request: Cache-Status == %q but expected %q", got, "hit")
+	}
+	expires := c.queryCache.lru.Front().Value.(*queryCacheItem).expires
+	if diff := helpers.Diff(expires, mockClock.Now().Add(3*time.Minute)); diff != "" {
+		t.Errorf("Recent request expiration (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := c.r.GetMetrics("akvorado_console_query_cache_")
+	expectedMetrics := map[string]string{
+		"hits_total":   "3",
+		"misses_total": "3",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
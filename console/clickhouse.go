@@ -103,6 +103,7 @@ type inputContext struct {
 	End               time.Time  `json:"end"`
 	StartForInterval  *time.Time `json:"start-for-interval,omitempty"`
 	MainTableRequired bool       `json:"main-table-required,omitempty"`
+	FullResolution    bool       `json:"full-resolution,omitempty"`
 	Points            uint       `json:"points"`
 	Units             string     `json:"units,omitempty"`
 }
@@ -114,6 +115,7 @@ type context struct {
 	TimefilterEnd     string
 	Units             string
 	Interval          uint64
+	Slots             uint64
 	ToStartOfInterval func(string) string
 }
 
@@ -151,9 +153,10 @@ func (c *Component) contextFunc(inputStr string) context {
 		targetInterval = time.Second
 	}
 
-	// Select table
+	// Select table. When full resolution is requested, we select the table
+	// with the best resolution and we keep its interval.
 	targetIntervalForTableSelection := targetInterval
-	if input.MainTableRequired {
+	if input.MainTableRequired || input.FullResolution {
 		targetIntervalForTableSelection = time.Second
 	}
 	table, computedInterval := c.getBestTable(input.Start, targetIntervalForTableSelection)
@@ -165,7 +168,7 @@ func (c *Component) contextFunc(inputStr string) context {
 	start := input.Start.Truncate(computedInterval)
 	end := input.End.Truncate(computedInterval)
 	// Adapt the computed interval to match the target one more closely
-	if targetInterval > computedInterval {
+	if targetInterval > computedInterval && !input.FullResolution {
 		computedInterval = targetInterval.Truncate(computedInterval)
 	}
 	// Adapt end to ensure we get a full interval
@@ -213,6 +216,7 @@ func (c *Component) contextFunc(inputStr string) context {
 		TimefilterEnd:   timefilterEnd,
 		Units:           units,
 		Interval:        uint64(computedInterval.Seconds()),
+		Slots:           uint64(end.Sub(start)/computedInterval) + 1,
 		ToStartOfInterval: func(field string) string {
 			return fmt.Sprintf(
 				`toStartOfInterval(%s + INTERVAL %d second, INTERVAL %d second) - INTERVAL %d second`,
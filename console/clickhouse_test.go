@@ -252,6 +252,21 @@ func TestFinalizeQuery(t *testing.T) {
 				Points: 720, // 2-minute resolution,
 			},
 			Expected: "SELECT 1 FROM flows_1m0s WHERE TimeReceived BETWEEN toDateTime('2022-04-10 15:46:00', 'UTC') AND toDateTime('2022-04-11 15:46:00', 'UTC')",
+		}, {
+			Description: "select table with best resolution for full resolution",
+			Tables: []flowsTable{
+				{"flows", 0, time.Date(2022, 4, 10, 22, 45, 10, 0, time.UTC)},
+				{"flows_5m0s", 5 * time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC)},
+				{"flows_1m0s", time.Minute, time.Date(2022, 4, 2, 22, 45, 10, 0, time.UTC)},
+			},
+			Query: "SELECT 1 FROM {{ .Table }} WHERE {{ .Timefilter }} // {{ .Interval }} {{ .Slots }}",
+			Context: inputContext{
+				Start:          time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				End:            time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				FullResolution: true,
+				Points:         100,
+			},
+			Expected: "SELECT 1 FROM flows_1m0s WHERE TimeReceived BETWEEN toDateTime('2022-04-10 15:45:00', 'UTC') AND toDateTime('2022-04-11 15:45:00', 'UTC') // 60 1441",
 		}, {
 			Description: "query with escaped template",
 			Query:       `SELECT TimeReceived, SrcPort WHERE InIfDescription = '{{"{{"}} hello }}'`,
@@ -19,29 +19,90 @@ type Configuration struct {
 	// Version is the version to display to the user.
 	Version string `yaml:"-"`
 	// DefaultVisualizeOptions define some defaults for the "visualize" tab.
-	DefaultVisualizeOptions VisualizeOptionsConfiguration `validate:"dive"`
+	DefaultVisualizeOptions VisualizeOptionsConfiguration `validate:"dive" doc:"Default options for the visualize tab"`
 	// HomepageTopWidgets defines the list of widgets to display on the home page.
-	HomepageTopWidgets []string `validate:"dive,oneof=src-as dst-as src-country dst-country exporter protocol etype src-port dst-port"`
+	HomepageTopWidgets []string `validate:"dive,oneof=src-as dst-as src-country dst-country exporter protocol etype src-port dst-port packet-size" doc:"Widgets to display on the home page"`
 	// DimensionsLimit put an upper limit to the number of dimensions to return.
-	DimensionsLimit int `validate:"min=10"`
+	DimensionsLimit int `validate:"min=10" doc:"Upper limit of the number of returned dimensions"`
 	// CacheTTL tells how long to keep the most costly requests in cache.
-	CacheTTL time.Duration `validate:"min=5s"`
+	CacheTTL time.Duration `validate:"min=5s" doc:"How long to keep costly requests in cache"`
+	// CacheSize is the maximum number of query results to keep in cache.
+	CacheSize int `validate:"min=0" doc:"Maximum number of query results kept in cache (0 to disable)"`
+	// CacheStableDelay is the delay after which data is considered stable.
+	// Results for requests ending after now minus this delay are only
+	// cached until the next point.
+	CacheStableDelay time.Duration `validate:"min=0" doc:"Delay after which data is considered stable and can be cached"`
+	// BaseFilter is a filter applied to all queries, combined with the
+	// filter provided by the user.
+	BaseFilter query.Filter `doc:"Filter applied to all queries, in addition to the user filter"`
+	// ResolverTimeout is the maximum time to wait for reverse DNS lookups.
+	ResolverTimeout time.Duration `validate:"min=1ms" doc:"Maximum time to wait for reverse DNS lookups"`
+	// ResolverCacheDuration tells how long to keep reverse DNS results.
+	ResolverCacheDuration time.Duration `validate:"min=1s" doc:"How long to keep reverse DNS results"`
+	// Grafana configures the Grafana JSON datasource endpoints.
+	Grafana GrafanaConfiguration `doc:"Grafana JSON datasource endpoints"`
+	// Fallback configures the degraded mode when ClickHouse is unavailable.
+	Fallback FallbackConfiguration `doc:"Degraded mode when ClickHouse is unavailable"`
+	// Current configures the endpoint returning the current traffic.
+	Current CurrentConfiguration `doc:"Endpoint returning the current traffic for external status pages"`
+}
+
+// CurrentConfiguration defines the filters exposed by the endpoint returning
+// the current traffic. As this endpoint is not authenticated, only
+// pre-registered filters are accepted.
+type CurrentConfiguration struct {
+	// Filters are the accepted filters, indexed by name.
+	Filters map[string]query.Filter `doc:"Accepted filters, indexed by name"`
+	// RefreshInterval is the interval between two refreshes of the values.
+	RefreshInterval time.Duration `validate:"min=1s" doc:"Interval between two refreshes of the values"`
+}
+
+// FallbackConfiguration defines how to get throughput counters from the
+// inlets when ClickHouse is unavailable.
+type FallbackConfiguration struct {
+	// Inlets are the base URLs of the inlets to query. When empty, there is
+	// no fallback.
+	Inlets []string `validate:"dive,url" doc:"Base URLs of inlets to query when ClickHouse is unavailable"`
+	// Timeout is the maximum time to wait for an inlet.
+	Timeout time.Duration `validate:"min=1ms" doc:"Maximum time to wait for an inlet"`
+}
+
+// GrafanaConfiguration defines the Grafana JSON datasource endpoints.
+type GrafanaConfiguration struct {
+	// Token is a static token accepted as a bearer token instead of the
+	// authentication headers. When empty, only authentication headers are
+	// used.
+	Token string `doc:"Static bearer token accepted instead of authentication headers"`
+	// Queries are the saved queries exposed as targets, indexed by name.
+	Queries map[string]GrafanaQueryConfiguration `validate:"dive" doc:"Saved queries exposed as targets, indexed by name"`
+}
+
+// GrafanaQueryConfiguration defines a saved query exposed to Grafana.
+type GrafanaQueryConfiguration struct {
+	// Dimensions is the array of dimensions to use
+	Dimensions []query.Column `doc:"Dimensions to use"`
+	// Filter is the filter to apply
+	Filter query.Filter `doc:"Filter expression"`
+	// Limit is the maximum number of dimension tuples (10 when not set)
+	Limit int `validate:"min=0" doc:"Maximum number of dimensions to return"`
+	// Units is the unit to use (l3bps when not set)
+	Units string `validate:"omitempty,oneof=pps l3bps l2bps inl2% outl2%" doc:"Units (pps, l3bps, l2bps, inl2%, or outl2%)"`
 }
 
 // VisualizeOptionsConfiguration defines options for the "visualize" tab.
 type VisualizeOptionsConfiguration struct {
 	// GraphType tells the type of the graph we request
-	GraphType string `json:"graphType" validate:"oneof=stacked stacked100 lines grid sankey"`
+	GraphType string `json:"graphType" validate:"oneof=stacked stacked100 lines grid sankey" doc:"Graph type (stacked, stacked100, lines, grid, or sankey)"`
 	// Start is the start time (as a string)
-	Start string `json:"start" validate:"required"`
+	Start string `json:"start" validate:"required" doc:"Start time"`
 	// End is the end time (as string)
-	End string `json:"end" validate:"required"`
+	End string `json:"end" validate:"required" doc:"End time"`
 	// Filter  is the the filter string
-	Filter string `json:"filter"`
+	Filter string `json:"filter" doc:"Filter expression"`
 	// Dimensions is the array of dimensions to use
-	Dimensions []query.Column `json:"dimensions"`
+	Dimensions []query.Column `json:"dimensions" doc:"Dimensions to use"`
 	// Limit is the default limit to use
-	Limit int `json:"limit" validate:"min=5"`
+	Limit int `json:"limit" validate:"min=5" doc:"Maximum number of dimensions to display"`
 }
 
 // DefaultConfiguration represents the default configuration for the console component.
@@ -55,9 +116,19 @@ func DefaultConfiguration() Configuration {
 			Dimensions: []query.Column{query.NewColumn("SrcAS")},
 			Limit:      10,
 		},
-		HomepageTopWidgets: []string{"src-as", "src-port", "protocol", "src-country", "etype"},
-		DimensionsLimit:    50,
-		CacheTTL:           30 * time.Minute,
+		HomepageTopWidgets:    []string{"src-as", "src-port", "protocol", "src-country", "etype"},
+		DimensionsLimit:       50,
+		CacheTTL:              30 * time.Minute,
+		CacheSize:             500,
+		CacheStableDelay:      2 * time.Minute,
+		ResolverTimeout:       time.Second,
+		ResolverCacheDuration: time.Hour,
+		Fallback: FallbackConfiguration{
+			Timeout: 2 * time.Second,
+		},
+		Current: CurrentConfiguration{
+			RefreshInterval: time.Minute,
+		},
 	}
 }
 
@@ -80,5 +151,6 @@ func (c *Component) configHandlerFunc(gc *gin.Context) {
 		"dimensions":              dimensions,
 		"truncatable":             truncatable,
 		"homepageTopWidgets":      c.config.HomepageTopWidgets,
+		"baseFilter":              c.baseFilter,
 	})
 }
@@ -9,11 +9,13 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"akvorado/common/helpers"
+	"akvorado/console/query"
 )
 
 func TestConfigHandler(t *testing.T) {
 	config := DefaultConfiguration()
 	config.Version = "1.2.3"
+	config.BaseFilter = query.NewFilter("ExporterTenant = 'customer1'")
 	_, h, _, _ := NewMock(t, config)
 	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
 		{
@@ -30,6 +32,7 @@ func TestConfigHandler(t *testing.T) {
 				},
 				"homepageTopWidgets": []string{"src-as", "src-port", "protocol", "src-country", "etype"},
 				"dimensionsLimit":    50,
+				"baseFilter":         "ExporterTenant = 'customer1'",
 				"dimensions": []string{
 					"ExporterAddress",
 					"ExporterName",
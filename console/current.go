@@ -0,0 +1,160 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/console/query"
+)
+
+const (
+	// currentPeriod is the period covered by the sparkline.
+	currentPeriod = 24 * time.Hour
+	// currentPoints is the number of points requested to the database. The
+	// latest value is the last complete one.
+	currentPoints = 288
+	// currentSparklinePoints is the number of points of the sparkline.
+	currentSparklinePoints = 48
+)
+
+// currentValue is the cached result for one filter of the /current endpoint.
+type currentValue struct {
+	Bps       float64
+	Time      time.Time
+	Interval  time.Duration
+	Updated   time.Time
+	Sparkline []float64
+}
+
+// currentHandlerOutput describes the output of the /current endpoint.
+type currentHandlerOutput struct {
+	Filter string  `json:"filter"`
+	Bps    float64 `json:"bps"`
+	// Time is the start of the latest complete slot
+	Time time.Time `json:"time"`
+	// Interval is the width of a slot, in seconds
+	Interval int `json:"interval"`
+	// Age is the number of seconds since the end of the latest slot
+	Age int `json:"age"`
+	// Updated is the time of the last refresh
+	Updated   time.Time `json:"updated"`
+	Sparkline []float64 `json:"sparkline"`
+}
+
+// currentQuery builds the SQL query to get the traffic for the last day
+// matching the provided filter.
+func (c *Component) currentQuery(now time.Time, filter query.Filter) string {
+	return strings.TrimSpace(fmt.Sprintf(`
+{{ with %s }}
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ SUM(Bytes*SamplingRate*8/{{ .Interval }}) AS bps
+FROM {{ .Table }}
+WHERE %s
+GROUP BY time
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }}
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second
+ STEP {{ .Interval }}
+{{ end }}`,
+		templateContext(inputContext{
+			Start:             now.Add(-currentPeriod),
+			End:               now,
+			MainTableRequired: requireMainTable(c.d.Schema, []query.Column{}, filter),
+			Points:            currentPoints,
+		}),
+		templateWhere(filter)))
+}
+
+// refreshCurrent refreshes the cached values for all the filters of the
+// /current endpoint.
+func (c *Component) refreshCurrent() {
+	ctx := c.t.Context(nil)
+	for name, filter := range c.config.Current.Filters {
+		now := c.d.Clock.Now()
+		sqlQuery := c.finalizeQuery(c.currentQuery(now, filter))
+		results := []struct {
+			Time time.Time `ch:"time"`
+			Bps  float64   `ch:"bps"`
+		}{}
+		if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery); err != nil {
+			c.r.Err(err).Str("filter", name).Msg("cannot refresh current traffic")
+			c.metrics.currentRefreshErrors.Inc()
+			continue
+		}
+		if len(results) < 2 {
+			continue
+		}
+
+		// Only keep complete slots
+		interval := results[1].Time.Sub(results[0].Time)
+		for len(results) > 0 && results[len(results)-1].Time.Add(interval).After(now) {
+			results = results[:len(results)-1]
+		}
+		if len(results) == 0 {
+			continue
+		}
+		value := currentValue{
+			Bps:       results[len(results)-1].Bps,
+			Time:      results[len(results)-1].Time,
+			Interval:  interval,
+			Updated:   now,
+			Sparkline: []float64{},
+		}
+
+		// Downsample the sparkline by averaging consecutive points. The
+		// first group may be smaller than the other ones.
+		step := (len(results) + currentSparklinePoints - 1) / currentSparklinePoints
+		for end := len(results); end > 0; end -= step {
+			start := end - step
+			if start < 0 {
+				start = 0
+			}
+			sum := 0.
+			for _, result := range results[start:end] {
+				sum += result.Bps
+			}
+			value.Sparkline = append(value.Sparkline, sum/float64(end-start))
+		}
+		for i, j := 0, len(value.Sparkline)-1; i < j; i, j = i+1, j-1 {
+			value.Sparkline[i], value.Sparkline[j] = value.Sparkline[j], value.Sparkline[i]
+		}
+
+		c.currentLock.Lock()
+		c.current[name] = value
+		c.currentLock.Unlock()
+	}
+}
+
+// currentHandlerFunc returns the latest traffic for a configured filter. The
+// value is served from memory and does not require authentication.
+func (c *Component) currentHandlerFunc(gc *gin.Context) {
+	name := gc.Query("filter")
+	if _, ok := c.config.Current.Filters[name]; !ok {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "Unknown filter."})
+		return
+	}
+	c.currentLock.RLock()
+	value, ok := c.current[name]
+	c.currentLock.RUnlock()
+	if !ok {
+		gc.JSON(http.StatusServiceUnavailable, gin.H{"message": "No data available yet."})
+		return
+	}
+	gc.JSON(http.StatusOK, currentHandlerOutput{
+		Filter:    name,
+		Bps:       value.Bps,
+		Time:      value.Time,
+		Interval:  int(value.Interval.Seconds()),
+		Age:       int(c.d.Clock.Since(value.Time.Add(value.Interval)).Seconds()),
+		Updated:   value.Updated,
+		Sparkline: value.Sparkline,
+	})
+}
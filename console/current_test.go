@@ -0,0 +1,102 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/helpers"
+	"akvorado/console/query"
+)
+
+func TestCurrentQuerySQL(t *testing.T) {
+	c, _, _, _ := NewMock(t, DefaultConfiguration())
+	filter := query.NewFilter("InIfBoundary = external")
+	if err := filter.Validate(c.d.Schema); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	expected := strings.ReplaceAll(`
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":288}@@ }}
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ SUM(Bytes*SamplingRate*8/{{ .Interval }}) AS bps
+FROM {{ .Table }}
+WHERE {{ .Timefilter }} AND (InIfBoundary = 'external')
+GROUP BY time
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }}
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second
+ STEP {{ .Interval }}
+{{ end }}`, "@@", "`")
+	got := c.currentQuery(time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC), filter)
+	if diff := helpers.Diff(strings.Split(got, "\n"),
+		strings.Split(strings.TrimSpace(expected), "\n")); diff != "" {
+		t.Errorf("currentQuery() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestCurrentHandler(t *testing.T) {
+	config := DefaultConfiguration()
+	config.Current.Filters = map[string]query.Filter{
+		"external": query.NewFilter("InIfBoundary = external"),
+	}
+	c, h, mockConn, mockClock := NewMock(t, config)
+	now := mockClock.Now()
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "unknown filter",
+			URL:         "/api/v0/console/current?filter=internal",
+			StatusCode:  404,
+			JSONOutput:  gin.H{"message": "Unknown filter."},
+		}, {
+			Description: "not refreshed yet",
+			URL:         "/api/v0/console/current?filter=external",
+			StatusCode:  503,
+			JSONOutput:  gin.H{"message": "No data available yet."},
+		},
+	})
+
+	// One slot every 5 minutes during the last day, the last one being
+	// incomplete.
+	results := []struct {
+		Time time.Time `ch:"time"`
+		Bps  float64   `ch:"bps"`
+	}{}
+	for i := 0; i <= 288; i++ {
+		results = append(results, struct {
+			Time time.Time `ch:"time"`
+			Bps  float64   `ch:"bps"`
+		}{now.Add(-24 * time.Hour).Add(time.Duration(i) * 5 * time.Minute), float64(i)})
+	}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, results).
+		Return(nil)
+	c.refreshCurrent()
+
+	sparkline := []float64{}
+	for i := 0; i < 48; i++ {
+		sparkline = append(sparkline, float64(6*i)+2.5)
+	}
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/console/current?filter=external",
+			JSONOutput: gin.H{
+				"filter":    "external",
+				"bps":       287,
+				"time":      now.Add(-5 * time.Minute).Format(time.RFC3339),
+				"interval":  300,
+				"age":       0,
+				"updated":   now.Format(time.RFC3339),
+				"sparkline": sparkline,
+			},
+		},
+	})
+}
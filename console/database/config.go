@@ -6,11 +6,11 @@ package database
 // Configuration describes the configuration for the authentication component.
 type Configuration struct {
 	// Driver defines the driver for the database
-	Driver string `validate:"required"`
+	Driver string `validate:"required" doc:"Database driver (sqlite, mysql or postgresql)"`
 	// DSN defines the DSN to connect to the database
-	DSN string `validate:"required"`
+	DSN string `validate:"required" doc:"DSN to connect to the database"`
 	// SavedFilters is a list of saved filters to include for all users
-	SavedFilters []BuiltinSavedFilter `validate:"dive"`
+	SavedFilters []BuiltinSavedFilter `validate:"dive" doc:"Saved filters available to all users"`
 }
 
 // DefaultConfiguration represents the default configuration for the console component.
@@ -23,6 +23,6 @@ func DefaultConfiguration() Configuration {
 
 // BuiltinSavedFilter is a saved filter
 type BuiltinSavedFilter struct {
-	Description string `validate:"required"`
-	Content     string `validate:"required"`
+	Description string `validate:"required" doc:"Description of the filter"`
+	Content     string `validate:"required" doc:"Filter expression"`
 }
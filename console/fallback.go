@@ -0,0 +1,305 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/schema"
+	"akvorado/console/filter"
+)
+
+// errFallbackUnsupported is returned when a request cannot be served from
+// the throughput counters of the inlets.
+var errFallbackUnsupported = errors.New("request not supported in degraded mode")
+
+// fallbackWidth is the resolution of the throughput counters of the inlets.
+const fallbackWidth = time.Minute
+
+// fallbackMessage is the message attached to results served from the
+// throughput counters of the inlets.
+const fallbackMessage = "Database unavailable, using the last hour of throughput counters from inlets at a one-minute resolution."
+
+// fallbackExceptionCodes are the codes of the exceptions from ClickHouse
+// telling it is unable to answer, whatever the query.
+var fallbackExceptionCodes = map[int32]bool{
+	202: true, // TOO_MANY_SIMULTANEOUS_QUERIES
+	210: true, // NETWORK_ERROR
+	999: true, // KEEPER_EXCEPTION
+}
+
+// clickhouseUnavailable tells if an error means ClickHouse is unable to
+// answer, by opposition to an error due to the query. Timeouts are
+// considered as query errors: the query may just be too slow.
+func clickhouseUnavailable(err error) bool {
+	if errors.Is(err, stdcontext.Canceled) || errors.Is(err, stdcontext.DeadlineExceeded) {
+		return false
+	}
+	var exception *clickhouse.Exception
+	if errors.As(err, &exception) {
+		return fallbackExceptionCodes[exception.Code]
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return !netErr.Timeout()
+	}
+	return errors.Is(err, clickhouse.ErrAcquireConnTimeout) ||
+		errors.Is(err, io.EOF) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ECONNRESET)
+}
+
+// fallbackSerie is a serie of throughput counters from an inlet.
+type fallbackSerie struct {
+	ExporterAddress string   `json:"exporter-address"`
+	InIfBoundary    string   `json:"in-if-boundary"`
+	OutIfBoundary   string   `json:"out-if-boundary"`
+	Bytes           []uint64 `json:"bytes"`
+	Packets         []uint64 `json:"packets"`
+}
+
+// fallbackThroughput are the throughput counters from an inlet.
+type fallbackThroughput struct {
+	Time   []time.Time     `json:"time"`
+	Series []fallbackSerie `json:"series"`
+}
+
+// fetchThroughput retrieves the throughput counters from all inlets. Inlets
+// not answering are ignored, unless none of them answers.
+func (c *Component) fetchThroughput(ctx stdcontext.Context) ([]fallbackThroughput, error) {
+	results := []fallbackThroughput{}
+	var lastErr error
+	for _, inlet := range c.config.Fallback.Inlets {
+		result, err := func() (fallbackThroughput, error) {
+			var result fallbackThroughput
+			ctx, cancel := stdcontext.WithTimeout(ctx, c.config.Fallback.Timeout)
+			defer cancel()
+			url := fmt.Sprintf("%s/api/v0/inlet/throughput", strings.TrimRight(inlet, "/"))
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+			if err != nil {
+				return result, err
+			}
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				return result, err
+			}
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return result, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				return result, err
+			}
+			return result, nil
+		}()
+		if err != nil {
+			c.r.Err(err).Str("inlet", inlet).Msg("cannot fetch throughput counters")
+			lastErr = err
+			continue
+		}
+		results = append(results, result)
+	}
+	if len(results) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return results, nil
+}
+
+// fallbackConditions turns the syntax tree of a filter into a list of
+// conditions. Only conjunctions of equalities on exporter addresses and
+// boundaries are supported. Flows without bytes or packets do not contribute
+// to the throughput counters, so excluding them is also supported.
+func fallbackConditions(node filter.Node) ([]filter.Condition, error) {
+	switch node := node.(type) {
+	case nil:
+		return nil, nil
+	case filter.And:
+		conditions := []filter.Condition{}
+		for _, node := range node {
+			more, err := fallbackConditions(node)
+			if err != nil {
+				return nil, err
+			}
+			conditions = append(conditions, more...)
+		}
+		return conditions, nil
+	case filter.Not:
+		if condition, ok := node.Node.(filter.Condition); ok {
+			condition.Equal = !condition.Equal
+			return fallbackConditions(condition)
+		}
+	case filter.Condition:
+		switch node.Column {
+		case "ExporterAddress", "InIfBoundary", "OutIfBoundary":
+			return []filter.Condition{node}, nil
+		case "ZeroVolume":
+			if node.Equal == (node.Value == "false") {
+				return nil, nil
+			}
+		}
+	}
+	return nil, errFallbackUnsupported
+}
+
+// value returns the value of a column for the serie.
+func (fs fallbackSerie) value(column string) string {
+	switch column {
+	case "ExporterAddress":
+		return fs.ExporterAddress
+	case "InIfBoundary":
+		return fs.InIfBoundary
+	case "OutIfBoundary":
+		return fs.OutIfBoundary
+	}
+	return ""
+}
+
+// graphLineFallback builds the output for the /graph/line endpoint from the
+// throughput counters of the inlets. Only a subset of the requests can be
+// served: exporter address and boundaries as dimensions and filter, and no
+// reverse direction or previous period.
+func (c *Component) graphLineFallback(gc *gin.Context, input graphLineHandlerInput) (graphLineHandlerOutput, error) {
+	if len(c.config.Fallback.Inlets) == 0 || input.Bidirectional || input.PreviousPeriod {
+		return graphLineHandlerOutput{}, errFallbackUnsupported
+	}
+	dimensions := make([]string, len(input.Dimensions))
+	for idx, column := range input.Dimensions {
+		switch column.Key() {
+		case schema.ColumnExporterAddress, schema.ColumnInIfBoundary, schema.ColumnOutIfBoundary:
+			dimensions[idx] = column.String()
+		default:
+			return graphLineHandlerOutput{}, errFallbackUnsupported
+		}
+	}
+	conditions, err := fallbackConditions(input.Filter.Tree())
+	if err != nil {
+		return graphLineHandlerOutput{}, err
+	}
+	var xps func(bytes, packets uint64) float64
+	switch input.Units {
+	case "pps":
+		xps = func(_, packets uint64) float64 { return float64(packets) }
+	case "l3bps":
+		xps = func(bytes, _ uint64) float64 { return float64(bytes * 8) }
+	case "l2bps":
+		// See the ClickHouse query for the overhead
+		xps = func(bytes, packets uint64) float64 { return float64((bytes + 38*packets) * 8) }
+	default:
+		return graphLineHandlerOutput{}, errFallbackUnsupported
+	}
+
+	throughputs, err := c.fetchThroughput(c.t.Context(gc.Request.Context()))
+	if err != nil {
+		return graphLineHandlerOutput{}, err
+	}
+
+	// Sum values for each row and each time. The current bucket is
+	// incomplete and ignored.
+	now := c.d.Clock.Now()
+	values := map[string]map[time.Time]float64{}
+	rows := map[string][]string{}
+	sums := map[string]float64{}
+	times := map[time.Time]struct{}{}
+	for _, throughput := range throughputs {
+	series:
+		for _, serie := range throughput.Series {
+			for _, condition := range conditions {
+				if (serie.value(condition.Column) == condition.Value) != condition.Equal {
+					continue series
+				}
+			}
+			row := make([]string, len(dimensions))
+			for idx, dimension := range dimensions {
+				row[idx] = serie.value(dimension)
+			}
+			rowKey := fmt.Sprintf("%s", row)
+			for idx, t := range throughput.Time {
+				if t.Before(input.Start) || !t.Before(input.End) || t.Add(fallbackWidth).After(now) {
+					continue
+				}
+				if idx >= len(serie.Bytes) || idx >= len(serie.Packets) {
+					break
+				}
+				if _, ok := values[rowKey]; !ok {
+					values[rowKey] = map[time.Time]float64{}
+					rows[rowKey] = row
+				}
+				v := xps(serie.Bytes[idx], serie.Packets[idx]) / fallbackWidth.Seconds()
+				values[rowKey][t] += v
+				sums[rowKey] += v
+				times[t] = struct{}{}
+			}
+		}
+	}
+	if len(times) == 0 {
+		return graphLineHandlerOutput{}, errFallbackUnsupported
+	}
+
+	// Keep the top rows, fold the remaining ones into "Other"
+	rowKeys := make([]string, 0, len(rows))
+	for rowKey := range rows {
+		rowKeys = append(rowKeys, rowKey)
+	}
+	sort.Slice(rowKeys, func(i, j int) bool {
+		return sums[rowKeys[i]] > sums[rowKeys[j]]
+	})
+	other := map[time.Time]float64{}
+	if len(rowKeys) > input.Limit {
+		for _, rowKey := range rowKeys[input.Limit:] {
+			for t, v := range values[rowKey] {
+				other[t] += v
+			}
+		}
+		rowKeys = rowKeys[:input.Limit]
+	}
+	otherRow := make([]string, len(dimensions))
+	for idx := range otherRow {
+		otherRow[idx] = "Other"
+	}
+
+	sortedTimes := make([]time.Time, 0, len(times))
+	for t := range times {
+		sortedTimes = append(sortedTimes, t)
+	}
+	sort.Slice(sortedTimes, func(i, j int) bool {
+		return sortedTimes[i].Before(sortedTimes[j])
+	})
+	results := []graphLineResult{}
+	for _, t := range sortedTimes {
+		for _, rowKey := range rowKeys {
+			results = append(results, graphLineResult{
+				Axis:       1,
+				Time:       t,
+				Xps:        values[rowKey][t],
+				Dimensions: rows[rowKey],
+			})
+		}
+		if len(other) > 0 {
+			results = append(results, graphLineResult{
+				Axis:       1,
+				Time:       t,
+				Xps:        other[t],
+				Dimensions: otherRow,
+			})
+		}
+	}
+
+	output := buildGraphLineOutput(input, results)
+	output.Degraded = fallbackMessage
+	return output, nil
+}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	netHTTP "net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ClickHouse/clickhouse-go/v2"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+	"akvorado/console/filter"
+	"akvorado/console/query"
+)
+
+func TestClickHouseUnavailable(t *testing.T) {
+	cases := []struct {
+		Error    error
+		Expected bool
+	}{
+		{errors.New("something bad happened"), false},
+		{stdcontext.Canceled, false},
+		{&clickhouse.Exception{Code: 47, Name: "UNKNOWN_IDENTIFIER"}, false},
+		{fmt.Errorf("query: %w", &clickhouse.Exception{Code: 210, Name: "NETWORK_ERROR"}), true},
+		{clickhouse.ErrAcquireConnTimeout, true},
+		{io.EOF, true},
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true},
+		{stdcontext.DeadlineExceeded, false},
+		{fmt.Errorf("read: %w", os.ErrDeadlineExceeded), false},
+		{&net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}, false},
+	}
+	for _, tc := range cases {
+		if got := clickhouseUnavailable(tc.Error); got != tc.Expected {
+			t.Errorf("clickhouseUnavailable(%v) == %v, expected %v", tc.Error, got, tc.Expected)
+		}
+	}
+}
+
+func TestFallbackConditions(t *testing.T) {
+	cases := []struct {
+		Input      string
+		ZeroVolume bool // exclude flows without bytes or packets
+		Expected   []filter.Condition
+		Error      bool
+	}{
+		{Input: "", Expected: nil},
+		{
+			Input:    "InIfBoundary = external",
+			Expected: []filter.Condition{{Column: "InIfBoundary", Equal: true, Value: "external"}},
+		}, {
+			Input: "ExporterAddress = ::ffff:192.0.2.1 AND OutIfBoundary != internal",
+			Expected: []filter.Condition{
+				{Column: "ExporterAddress", Equal: true, Value: "192.0.2.1"},
+				{Column: "OutIfBoundary", Equal: false, Value: "internal"},
+			},
+		}, {
+			Input:    "NOT InIfBoundary = external",
+			Expected: []filter.Condition{{Column: "InIfBoundary", Equal: false, Value: "external"}},
+		},
+		{Input: "", ZeroVolume: true, Expected: nil},
+		{Input: "ZeroVolume != true", Expected: nil},
+		{
+			Input:      "InIfBoundary = external",
+			ZeroVolume: true,
+			Expected:   []filter.Condition{{Column: "InIfBoundary", Equal: true, Value: "external"}},
+		}, {
+			Input:      "(ExporterAddress = 192.0.2.1) AND (InIfBoundary = external AND OutIfBoundary = internal)",
+			ZeroVolume: true,
+			Expected: []filter.Condition{
+				{Column: "ExporterAddress", Equal: true, Value: "192.0.2.1"},
+				{Column: "InIfBoundary", Equal: true, Value: "external"},
+				{Column: "OutIfBoundary", Equal: true, Value: "internal"},
+			},
+		},
+		{Input: "InIfBoundary = external OR OutIfBoundary = external", Error: true},
+		{Input: "ExporterAddress = 192.0.2.1 AND (InIfBoundary = external OR OutIfBoundary = external)", Error: true},
+		{Input: "(InIfBoundary = external OR OutIfBoundary = external) AND ExporterAddress = 192.0.2.1", Error: true},
+		{Input: "InIfBoundary = external AND OutIfBoundary = external OR ExporterAddress = 192.0.2.1", Error: true},
+		{Input: "NOT (InIfBoundary = external AND OutIfBoundary = external)", Error: true},
+		{Input: "InIfBoundary = external OR OutIfBoundary = external", ZeroVolume: true, Error: true},
+		{Input: "ZeroVolume = true", Error: true},
+		{Input: "SrcAS = 65000", Error: true},
+		{Input: "SrcAddr = 192.0.2.1", Error: true},
+	}
+	sch := schema.NewMock(t).EnableAllColumns()
+	for _, tc := range cases {
+		input := graphCommonHandlerInput{
+			schema: sch,
+			Filter: query.NewFilter(tc.Input),
+		}
+		if err := input.Filter.Validate(sch); err != nil {
+			t.Fatalf("Validate(%q) error:\n%+v", tc.Input, err)
+		}
+		if tc.ZeroVolume {
+			input.excludeZeroVolume()
+		}
+		got, err := fallbackConditions(input.Filter.Tree())
+		if err != nil && !tc.Error {
+			t.Errorf("fallbackConditions(%q) error:\n%+v", tc.Input, err)
+		} else if err == nil && tc.Error {
+			t.Errorf("fallbackConditions(%q) did not error", tc.Input)
+		} else if diff := helpers.Diff(got, tc.Expected); diff != "" {
+			t.Errorf("fallbackConditions(%q) (-got, +want):\n%s", tc.Input, diff)
+		}
+	}
+}
+
+func TestGraphLineFallback(t *testing.T) {
+	// The mock clock is at epoch. The last bucket is incomplete.
+	base := time.Unix(0, 0).UTC().Add(-2 * time.Minute)
+	inlet := httptest.NewServer(netHTTP.HandlerFunc(func(w netHTTP.ResponseWriter, r *netHTTP.Request) {
+		if r.URL.Path != "/api/v0/inlet/throughput" {
+			netHTTP.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"time": [%q, %q, %q], "series": [
+{"exporter-address": "192.0.2.1", "in-if-boundary": "external", "out-if-boundary": "internal",
+ "bytes": [600000, 1200000, 60], "packets": [600, 1200, 1]},
+{"exporter-address": "192.0.2.2", "in-if-boundary": "external", "out-if-boundary": "internal",
+ "bytes": [60000, 60000, 60], "packets": [60, 60, 1]},
+{"exporter-address": "192.0.2.3", "in-if-boundary": "external", "out-if-boundary": "internal",
+ "bytes": [30000, 0, 60], "packets": [30, 0, 1]},
+{"exporter-address": "192.0.2.1", "in-if-boundary": "internal", "out-if-boundary": "external",
+ "bytes": [6000000, 6000000, 60], "packets": [6000, 6000, 1]}]}`,
+			base.Format(time.RFC3339),
+			base.Add(time.Minute).Format(time.RFC3339),
+			base.Add(2*time.Minute).Format(time.RFC3339))
+	}))
+	defer inlet.Close()
+	config := DefaultConfiguration()
+	config.Fallback.Inlets = []string{inlet.URL, "http://127.0.0.1:1"}
+	_, h, mockConn, _ := NewMock(t, config)
+
+	unavailable := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(unavailable),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(unavailable),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			Return(&clickhouse.Exception{Code: 47, Name: "UNKNOWN_IDENTIFIER"}),
+	)
+
+	input := gin.H{
+		"start":      base.Add(-time.Hour),
+		"end":        base.Add(time.Hour),
+		"points":     100,
+		"limit":      1,
+		"dimensions": []string{"ExporterAddress"},
+		"filter":     "InIfBoundary = external",
+		"units":      "l3bps",
+	}
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "fallback to inlets",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput:   input,
+			JSONOutput: gin.H{
+				"t": []string{
+					base.Format(time.RFC3339),
+					base.Add(time.Minute).Format(time.RFC3339),
+				},
+				"rows":       [][]string{{"192.0.2.1"}, {"Other"}},
+				"points":     [][]int{{80000, 160000}, {12000, 8000}},
+				"axis":       []int{1, 1},
+				"axis-names": gin.H{"1": "Direct"},
+				"average":    []int{120000, 10000},
+				"min":        []int{80000, 8000},
+				"max":        []int{160000, 12000},
+				"95th":       []int{120000, 10000},
+				"degraded":   fallbackMessage,
+			},
+		}, {
+			Description: "fallback to inlets with unsupported dimension",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":      base.Add(-time.Hour),
+				"end":        base.Add(time.Hour),
+				"points":     100,
+				"limit":      1,
+				"dimensions": []string{"SrcAS"},
+				"units":      "l3bps",
+			},
+			StatusCode: 500,
+			JSONOutput: gin.H{"message": "Unable to query database."},
+		}, {
+			Description: "no fallback on query error",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput:   input,
+			StatusCode:  500,
+			JSONOutput:  gin.H{"message": "Unable to query database."},
+		},
+	})
+}
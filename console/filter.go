@@ -84,21 +84,11 @@ func (c *Component) filterCompleteHandlerFunc(gc *gin.Context) {
 	completions := []filterCompletion{}
 	switch input.What {
 	case "column":
-		_, err := filter.Parse("", []byte{},
-			filter.Entrypoint("ConditionExpr"), filter.GlobalStore("meta", &filter.Meta{Schema: c.d.Schema}))
-		if err != nil {
-			for _, candidate := range filter.Expected(err) {
-				if !strings.HasSuffix(candidate, `"i`) {
-					continue
-				}
-				candidate = candidate[1 : len(candidate)-2]
-				if column, ok := c.d.Schema.LookupColumnByName(candidate); ok && !column.Disabled {
-					completions = append(completions, filterCompletion{
-						Label:  candidate,
-						Detail: "column name",
-					})
-				}
-			}
+		for _, column := range filter.Columns(c.d.Schema) {
+			completions = append(completions, filterCompletion{
+				Label:  column,
+				Detail: "column name",
+			})
 		}
 	case "operator":
 		_, err := filter.Parse("",
@@ -165,6 +155,13 @@ func (c *Component) filterCompleteHandlerFunc(gc *gin.Context) {
 				filterCompletion{"PIM", "protocol", true},
 				filterCompletion{"IPv4", "protocol", true},
 				filterCompletion{"IPv6", "protocol", true})
+		case "tcpflags":
+			for _, flag := range []string{"FIN", "SYN", "RST", "PSH", "ACK", "URG", "ECE", "CWR", "NS"} {
+				completions = append(completions, filterCompletion{
+					Label:  flag,
+					Detail: "TCP flag",
+				})
+			}
 		case "srcmac", "dstmac":
 			results := []struct {
 				Label string `ch:"label"`
@@ -41,9 +41,9 @@ OR`), GlobalStore("meta", &Meta{Schema: schema.NewMock(t)}))
 }
 
 func TestExpected(t *testing.T) {
-	_, err := Parse("", []byte{}, Entrypoint("ConditionBoundaryExpr"),
+	_, err := Parse("", []byte("InIfBoundary = "), Entrypoint("ConditionBoundaryExpr"),
 		GlobalStore("meta", &Meta{Schema: schema.NewMock(t)}))
-	expected := []string{`"InIfBoundary"i`, `"OutIfBoundary"i`}
+	expected := []string{`"--"`, `"/*"`, `"external"i`, `"internal"i`, `"undefined"i`, `[ \n\r\t]`}
 	if diff := helpers.Diff(Expected(err), expected); diff != "" {
 		t.Errorf("AllErrors() (-got, +want):\n%s", diff)
 	}
@@ -7,6 +7,7 @@ package filter
 import (
 	"fmt"
 	"net/netip"
+	"sort"
 	"strings"
 
 	"akvorado/common/schema"
@@ -20,6 +21,10 @@ type Meta struct {
 	ReverseDirection bool
 	// MainTableRequired tells if the main table is required to execute the expression (used as output)
 	MainTableRequired bool
+	// Columns are the names of the columns referenced by the expression (used as output)
+	Columns []string
+	// Tree is the syntax tree of the expression (used as output)
+	Tree Node
 }
 
 // reverseColumnDirection reverts the direction of a provided column name.
@@ -43,30 +48,48 @@ func reverseColumnDirection(schema *schema.Component, name string) string {
 	return name
 }
 
+// lookupColumn returns the enabled column matching the provided name, ignoring
+// case.
+func lookupColumn(sch *schema.Component, name string) (schema.Column, bool) {
+	for _, column := range sch.Columns() {
+		if strings.EqualFold(name, column.Name) {
+			return column, true
+		}
+	}
+	return schema.Column{}, false
+}
+
+// filterColumn tells if the provided name is an enabled column accepting the
+// provided kind of conditions. It should be used in predicate code blocks.
+func (c *current) filterColumn(name interface{}, kind schema.ColumnFilter) (bool, error) {
+	column, ok := lookupColumn(c.globalStore["meta"].(*Meta).Schema, toString(name))
+	return ok && column.ConsoleFilter == kind, nil
+}
+
 // acceptColumn normalizes and returns the matched column name. It should be used
-// in predicate code blocks.
+// in action code blocks.
 func (c *current) acceptColumn() (string, error) {
 	name := string(c.text)
 	schema := c.globalStore["meta"].(*Meta).Schema
-	for _, column := range schema.Columns() {
-		if strings.EqualFold(name, column.Name) {
-			if c.globalStore["meta"].(*Meta).ReverseDirection {
-				return reverseColumnDirection(schema, column.Name), nil
-			}
-			return column.Name, nil
+	if column, ok := lookupColumn(schema, name); ok {
+		if c.globalStore["meta"].(*Meta).ReverseDirection {
+			return reverseColumnDirection(schema, column.Name), nil
 		}
+		return column.Name, nil
 	}
 	return "", fmt.Errorf("unknown column %q", name)
 }
 
 // metaColumn remembers the matched column name in meta data. It should be used
-// in state change blocks. Unfortunately, it cannot extract matched text, so it
-// should be provided.
-func (c *current) metaColumn(name string) error {
-	if column, ok := c.globalStore["meta"].(*Meta).Schema.LookupColumnByName(name); ok {
-		if column.ClickHouseMainOnly {
-			c.state["main-table-only"] = true
-		}
+// in state change blocks.
+func (c *current) metaColumn(name interface{}) error {
+	column, ok := lookupColumn(c.globalStore["meta"].(*Meta).Schema, toString(name))
+	if !ok {
+		return fmt.Errorf("unknown column %q", toString(name))
+	}
+	c.state[fmt.Sprintf("column-%s", column.Name)] = true
+	if column.ClickHouseMainOnly {
+		c.state["main-table-only"] = true
 	}
 	return nil
 }
@@ -112,3 +135,16 @@ func toString(v interface{}) string {
 		panic("not a string")
 	}
 }
+
+// Columns returns the sorted names of the enabled columns accepted in
+// filters.
+func Columns(sch *schema.Component) []string {
+	columns := []string{}
+	for _, column := range sch.Columns() {
+		if column.Filterable() {
+			columns = append(columns, column.Name)
+		}
+	}
+	sort.Strings(columns)
+	return columns
+}
@@ -6,6 +6,8 @@ package filter
 import (
 	"testing"
 
+	"golang.org/x/exp/slices"
+
 	"akvorado/common/helpers"
 	"akvorado/common/schema"
 )
@@ -112,6 +114,13 @@ func TestValidFilter(t *testing.T) {
 		{Input: `DstNetName="alpha"`, Output: `DstNetName = 'alpha'`},
 		{Input: `DstNetRole="stuff"`, Output: `DstNetRole = 'stuff'`},
 		{Input: `SrcNetTenant="mobile"`, Output: `SrcNetTenant = 'mobile'`},
+		{Input: `DstApplication = "https"`, Output: `DstApplication = 'https'`},
+		{
+			Input:   `SrcApplication IN ("quic", "https")`,
+			Output:  `DstApplication IN ('quic', 'https')`,
+			MetaIn:  Meta{ReverseDirection: true},
+			MetaOut: Meta{ReverseDirection: true},
+		},
 		{Input: `SrcAS=12322`, Output: `SrcAS = 12322`},
 		{Input: `SrcAS=AS12322`, Output: `SrcAS = 12322`},
 		{
@@ -186,6 +195,17 @@ func TestValidFilter(t *testing.T) {
 			Input: `OutIfProvider = 'telia'`, Output: `InIfProvider = 'telia'`,
 			MetaIn: Meta{ReverseDirection: true}, MetaOut: Meta{ReverseDirection: true},
 		},
+		{Input: `InIfBillingClass = 'transit'`, Output: `InIfBillingClass = 'transit'`},
+		{
+			Input: `OutIfBillingClass IN ('transit', 'paid-peering')`, Output: `InIfBillingClass IN ('transit', 'paid-peering')`,
+			MetaIn: Meta{ReverseDirection: true}, MetaOut: Meta{ReverseDirection: true},
+		},
+		{Input: `PacketSizeBucket = '1280-1500'`, Output: `PacketSizeBucket = '1280-1500'`},
+		{Input: `SrcVRF = 'customer-a'`, Output: `SrcVRF = 'customer-a'`},
+		{
+			Input: `SrcVRF = 'customer-a'`, Output: `DstVRF = 'customer-a'`,
+			MetaIn: Meta{ReverseDirection: true}, MetaOut: Meta{ReverseDirection: true},
+		},
 		{Input: `InIfBoundary = external`, Output: `InIfBoundary = 'external'`},
 		{
 			Input: `InIfBoundary = external`, Output: `OutIfBoundary = 'external'`,
@@ -285,10 +305,14 @@ output provider */ = 'telia'`,
 		},
 		{Input: `DstASPath = 65000`, Output: `has(DstASPath, 65000)`, MetaOut: Meta{MainTableRequired: true}},
 		{Input: `DstASPath != 65000`, Output: `NOT has(DstASPath, 65000)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstASPath has AS65000`, Output: `has(DstASPath, 65000)`, MetaOut: Meta{MainTableRequired: true}},
 		{Input: `DstCommunities = 65000:100`, Output: `has(DstCommunities, 4259840100)`, MetaOut: Meta{MainTableRequired: true}},
 		{Input: `DstCommunities != 65000:100`, Output: `NOT has(DstCommunities, 4259840100)`, MetaOut: Meta{MainTableRequired: true}},
 		{Input: `DstCommunities = 65000:100:200`, Output: `has(DstLargeCommunities, bitShiftLeft(65000::UInt128, 64) + bitShiftLeft(100::UInt128, 32) + 200::UInt128)`, MetaOut: Meta{MainTableRequired: true}},
 		{Input: `DstCommunities != 65000:100:200`, Output: `NOT has(DstLargeCommunities, bitShiftLeft(65000::UInt128, 64) + bitShiftLeft(100::UInt128, 32) + 200::UInt128)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstCommunities has 65000:100`, Output: `has(DstCommunities, 4259840100)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `DstCommunities HAS 65000:100:200`, Output: `has(DstLargeCommunities, bitShiftLeft(65000::UInt128, 64) + bitShiftLeft(100::UInt128, 32) + 200::UInt128)`, MetaOut: Meta{MainTableRequired: true}},
+		{Input: `NOT DstCommunities has 65000:100`, Output: `NOT has(DstCommunities, 4259840100)`, MetaOut: Meta{MainTableRequired: true}},
 		{Input: `SrcVlan = 1000`, Output: `SrcVlan = 1000`},
 		{Input: `DstVlan = 1000`, Output: `DstVlan = 1000`},
 		{
@@ -309,8 +333,65 @@ output provider */ = 'telia'`,
 		},
 		{Input: `SrcMAC = 00:11:22:33:44:55`, Output: `SrcMAC = MACStringToNum('00:11:22:33:44:55')`},
 		{Input: `DstMAC = 00:11:22:33:44:55`, Output: `DstMAC = MACStringToNum('00:11:22:33:44:55')`},
+		{Input: `MPLSLabel1 = 16004`, Output: `MPLSLabel1 = 16004`},
+		{Input: `mplslabel2 >= 24000`, Output: `MPLSLabel2 >= 24000`},
+		{Input: `IPTos = 184`, Output: `IPTos = 184`},
+		{Input: `IPDSCP = 46`, Output: `IPDSCP = 46`},
+		{Input: `IPECN != 0`, Output: `IPECN != 0`},
+		{Input: `ipv6flowlabel != 0`, Output: `IPv6FlowLabel != 0`},
+		{Input: `FirewallEvent = 3`, Output: `FirewallEvent = 3`},
+		{Input: `ICMPType = 3 AND icmpcode = 4`, Output: `ICMPType = 3 AND ICMPCode = 4`},
+		{Input: `TCPFlags has SYN`, Output: `bitTest(TCPFlags, 1)`},
+		{Input: `tcpflags HAS ack AND NOT TCPFlags has FIN`, Output: `bitTest(TCPFlags, 4) AND NOT bitTest(TCPFlags, 0)`},
+		{Input: `TCPFlags = 2`, Output: `TCPFlags = 2`},
+		{Input: `TunnelType = 'gtp'`, Output: `TunnelType = 'gtp'`},
+		{Input: `DropReason = 'acl'`, Output: `DropReason = 'acl'`},
+		{Input: `SrcCity = 'Paris'`, Output: `SrcCity = 'Paris'`},
+		{Input: `dstregion LIKE 'Île%'`, Output: `DstRegion LIKE 'Île%'`},
+		{
+			Input: `SrcAddrInner << 10.0.0.0/8`, Output: `SrcAddrInner BETWEEN toIPv6('::ffff:10.0.0.0') AND toIPv6('::ffff:10.255.255.255')`,
+			MetaOut: Meta{MainTableRequired: true},
+		},
+		{
+			Input: `DstAddrInner = 10.0.0.1`, Output: `DstAddrInner = toIPv6('10.0.0.1')`,
+			MetaOut: Meta{MainTableRequired: true},
+		},
+		{
+			Input: `NextHop = 2001:db8::1`, Output: `NextHop = toIPv6('2001:db8::1')`,
+			MetaOut: Meta{MainTableRequired: true},
+		},
+		{Input: `ProtoInner = 6`, Output: `ProtoInner = 6`},
+		{Input: `TunnelVNI = 10000`, Output: `TunnelVNI = 10000`},
+		{
+			Input: `SRv6ActiveSegment = 2001:db8:200::1`, Output: `SRv6ActiveSegment = toIPv6('2001:db8:200::1')`,
+			MetaOut: Meta{MainTableRequired: true},
+		},
+		{Input: `SRv6SegmentsLeft > 0`, Output: `SRv6SegmentsLeft > 0`},
+		{Input: `SRv6SegmentListLength >= 3`, Output: `SRv6SegmentListLength >= 3`},
+		{Input: `DstASPathLength > 3`, Output: `DstASPathLength > 3`},
+		{Input: `Src1stAS = AS1299`, Output: `Src1stAS = 1299`},
 		{Input: `SrcMAC != 00:0c:fF:33:44:55`, Output: `SrcMAC != MACStringToNum('00:0c:ff:33:44:55')`},
 		{Input: `SrcMAC = 0000.5e00.5301`, Output: `SrcMAC = MACStringToNum('00:00:5e:00:53:01')`},
+		{Input: `DstTrafficClass = 'backbone'`, Output: `DstTrafficClass = 'backbone'`},
+		{
+			Input: `DstTrafficClass IN ('backbone', 'cache-fill')`, Output: `DstTrafficClass IN ('backbone', 'cache-fill')`,
+			MetaIn: Meta{ReverseDirection: true}, MetaOut: Meta{ReverseDirection: true},
+		},
+		{Input: `FlowExportDirection = 'ingress'`, Output: `FlowExportDirection = 'ingress'`},
+		{Input: `ThreatList = 'spamhaus-drop'`, Output: `ThreatList = 'spamhaus-drop'`},
+		{Input: `ZeroVolume = true`, Output: `ZeroVolume = true`},
+		{Input: `ZeroVolume != FALSE`, Output: `ZeroVolume != false`},
+		{
+			Input:   `ZeroVolume = false AND (InIfBoundary = external OR SrcAS = 65000)`,
+			Output:  `ZeroVolume = false AND (InIfBoundary = 'external' OR SrcAS = 65000)`,
+			MetaOut: Meta{Columns: []string{"InIfBoundary", "SrcAS", "ZeroVolume"}},
+		},
+		{
+			Input:   `InIfBoundary = external`,
+			Output:  `OutIfBoundary = 'external'`,
+			MetaIn:  Meta{ReverseDirection: true},
+			MetaOut: Meta{ReverseDirection: true, Columns: []string{"InIfBoundary"}},
+		},
 	}
 	for _, tc := range cases {
 		tc.MetaIn.Schema = schema.NewMock(t).EnableAllColumns()
@@ -323,6 +404,14 @@ output provider */ = 'telia'`,
 		if diff := helpers.Diff(got.(string), tc.Output); diff != "" {
 			t.Errorf("Parse(%q) (-got, +want):\n%s", tc.Input, diff)
 		}
+		if tc.MetaOut.Columns == nil {
+			// Only checked when provided
+			tc.MetaOut.Columns = tc.MetaIn.Columns
+		}
+		if tc.MetaOut.Tree == nil {
+			// Only checked when provided
+			tc.MetaOut.Tree = tc.MetaIn.Tree
+		}
 		if diff := helpers.Diff(tc.MetaIn, tc.MetaOut); diff != "" {
 			t.Errorf("Parse(%q) meta (-got, +want):\n%s", tc.Input, diff)
 		}
@@ -358,6 +447,12 @@ func TestInvalidFilter(t *testing.T) {
 		{Input: `SrcVlan = 1000`},
 		{Input: `DstVlan = 1000`},
 		{Input: `SrcMAC = 00:11:22:33:44:55:66`, EnableAll: true},
+		{Input: `ZeroVolume = true`},
+		{Input: `ZeroVolume = 1`, EnableAll: true},
+		{Input: `ZeroVolume = trueish`, EnableAll: true},
+		{Input: `TCPFlags has SYN`},
+		{Input: `TCPFlags has SYNACK`, EnableAll: true},
+		{Input: `TCPFlags > 2`, EnableAll: true},
 	}
 	for _, tc := range cases {
 		sch := schema.NewMock(t)
@@ -370,3 +465,57 @@ func TestInvalidFilter(t *testing.T) {
 		}
 	}
 }
+
+func TestColumns(t *testing.T) {
+	sch := schema.NewMock(t)
+	columns := Columns(sch)
+	for _, name := range []string{"ExporterName", "InIfBoundary", "SrcAS", "DstNetPrefix"} {
+		if !slices.Contains(columns, name) {
+			t.Errorf("Columns() does not contain %q", name)
+		}
+	}
+	for _, name := range []string{"SrcVlan", "ZeroVolume"} {
+		if slices.Contains(columns, name) {
+			t.Errorf("Columns() contains disabled %q", name)
+		}
+	}
+	sch.EnableAllColumns()
+	if !slices.Contains(Columns(sch), "SrcVlan") {
+		t.Error("Columns() does not contain SrcVlan once enabled")
+	}
+}
+
+func TestCustomDimensionFilter(t *testing.T) {
+	sch, err := schema.New(schema.Configuration{
+		CustomDimensions: []schema.CustomDimension{
+			{Name: "ExporterRack"},
+			{Name: "ExporterUnit", Type: schema.CustomDimensionTypeUint},
+		},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{`exporterrack = "r12"`, `ExporterRack = 'r12'`},
+		{`ExporterUnit >= 4`, `ExporterUnit >= 4`},
+	}
+	for _, tc := range cases {
+		got, err := Parse("", []byte(tc.Input), GlobalStore("meta", &Meta{Schema: sch}))
+		if err != nil {
+			t.Errorf("Parse(%q) error:\n%+v", tc.Input, err)
+			continue
+		}
+		if diff := helpers.Diff(got, tc.Expected); diff != "" {
+			t.Errorf("Parse(%q) (-got, +want):\n%s", tc.Input, diff)
+		}
+	}
+	if _, err := Parse("", []byte(`ExporterRack = 4`), GlobalStore("meta", &Meta{Schema: sch})); err == nil {
+		t.Error("Parse(`ExporterRack = 4`) didn't throw an error")
+	}
+	if !slices.Contains(Columns(sch), "ExporterRack") {
+		t.Error("Columns() does not contain ExporterRack")
+	}
+}
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package filter
+
+// Node is a node of the syntax tree of a filter. The tree follows the
+// semantics of the generated SQL expression: NOT binds tighter than AND, which
+// binds tighter than OR. Only equality conditions on IP addresses, boundaries
+// and booleans are detailed. Other conditions are opaque.
+type Node interface {
+	isNode()
+}
+
+// And is a conjunction of nodes.
+type And []Node
+
+// Or is a disjunction of nodes.
+type Or []Node
+
+// Not is the negation of a node.
+type Not struct {
+	Node Node
+}
+
+// Condition is an equality condition on a column.
+type Condition struct {
+	Column string
+	Equal  bool   // false for "!="
+	Value  string // unmapped address, lowercase boundary or boolean
+}
+
+// Opaque is a condition which is not detailed.
+type Opaque struct {
+	SQL string
+}
+
+func (And) isNode()       {}
+func (Or) isNode()        {}
+func (Not) isNode()       {}
+func (Condition) isNode() {}
+func (Opaque) isNode()    {}
+
+// NewAnd builds the conjunction of the provided nodes. Nil nodes are ignored
+// and nested conjunctions are flattened.
+func NewAnd(nodes ...Node) Node {
+	result := And{}
+	for _, node := range nodes {
+		switch node := node.(type) {
+		case nil:
+		case And:
+			result = append(result, node...)
+		default:
+			result = append(result, node)
+		}
+	}
+	switch len(result) {
+	case 0:
+		return nil
+	case 1:
+		return result[0]
+	}
+	return result
+}
+
+// expression is the result of the parsing of an expression: the SQL
+// expression and the tokens to build the syntax tree. Tokens are either nodes
+// or one of the "AND", "OR" and "NOT" operators.
+type expression struct {
+	sql    string
+	tokens []interface{}
+}
+
+// toExpression turns the result of a rule into an expression. Conditions
+// without details are turned into opaque nodes.
+func toExpression(v interface{}) expression {
+	switch v := v.(type) {
+	case expression:
+		return v
+	case sqlCondition:
+		return expression{sql: v.sql, tokens: []interface{}{v.node}}
+	}
+	sql := toString(v)
+	return expression{sql: sql, tokens: []interface{}{Opaque{SQL: sql}}}
+}
+
+// sqlCondition is a detailed condition with its SQL expression.
+type sqlCondition struct {
+	sql  string
+	node Condition
+}
+
+// tree builds the syntax tree of an expression.
+func (e expression) tree() Node {
+	tokens := e.tokens
+	var unary func() Node
+	unary = func() Node {
+		token := tokens[0]
+		tokens = tokens[1:]
+		if token == "NOT" {
+			return Not{Node: unary()}
+		}
+		return token.(Node)
+	}
+	or := Or{}
+	for {
+		and := []Node{unary()}
+		for len(tokens) > 0 && tokens[0] == "AND" {
+			tokens = tokens[1:]
+			and = append(and, unary())
+		}
+		or = append(or, NewAnd(and...))
+		if len(tokens) == 0 {
+			break
+		}
+		tokens = tokens[1:] // OR
+	}
+	if len(or) == 1 {
+		return or[0]
+	}
+	return or
+}
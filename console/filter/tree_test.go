@@ -0,0 +1,89 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package filter
+
+import (
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+)
+
+func TestTree(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Reverse  bool
+		Expected Node
+	}{
+		{
+			Input:    `ExporterAddress = ::ffff:192.0.2.1`,
+			Expected: Condition{Column: "ExporterAddress", Equal: true, Value: "192.0.2.1"},
+		}, {
+			Input:    `InIfBoundary != External`,
+			Expected: Condition{Column: "InIfBoundary", Value: "external"},
+		}, {
+			Input:    `InIfBoundary = external`,
+			Reverse:  true,
+			Expected: Condition{Column: "OutIfBoundary", Equal: true, Value: "external"},
+		}, {
+			Input:    `SrcAS = 65000`,
+			Expected: Opaque{SQL: "SrcAS = 65000"},
+		}, {
+			Input: `ZeroVolume = false AND (InIfBoundary = external AND OutIfBoundary = internal)`,
+			Expected: And{
+				Condition{Column: "ZeroVolume", Equal: true, Value: "false"},
+				Condition{Column: "InIfBoundary", Equal: true, Value: "external"},
+				Condition{Column: "OutIfBoundary", Equal: true, Value: "internal"},
+			},
+		}, {
+			// AND binds tighter than OR
+			Input: `InIfBoundary = external AND SrcAS = 65000 OR OutIfBoundary = external`,
+			Expected: Or{
+				And{
+					Condition{Column: "InIfBoundary", Equal: true, Value: "external"},
+					Opaque{SQL: "SrcAS = 65000"},
+				},
+				Condition{Column: "OutIfBoundary", Equal: true, Value: "external"},
+			},
+		}, {
+			// NOT binds tighter than AND
+			Input: `NOT InIfBoundary = external AND OutIfBoundary = external`,
+			Expected: And{
+				Not{Node: Condition{Column: "InIfBoundary", Equal: true, Value: "external"}},
+				Condition{Column: "OutIfBoundary", Equal: true, Value: "external"},
+			},
+		}, {
+			Input: `NOT (InIfBoundary = external OR OutIfBoundary = external)`,
+			Expected: Not{Node: Or{
+				Condition{Column: "InIfBoundary", Equal: true, Value: "external"},
+				Condition{Column: "OutIfBoundary", Equal: true, Value: "external"},
+			}},
+		},
+	}
+	sch := schema.NewMock(t).EnableAllColumns()
+	for _, tc := range cases {
+		meta := &Meta{Schema: sch, ReverseDirection: tc.Reverse}
+		if _, err := Parse("", []byte(tc.Input), GlobalStore("meta", meta)); err != nil {
+			t.Errorf("Parse(%q) error:\n%+v", tc.Input, err)
+			continue
+		}
+		if diff := helpers.Diff(meta.Tree, tc.Expected); diff != "" {
+			t.Errorf("Parse(%q) tree (-got, +want):\n%s", tc.Input, diff)
+		}
+	}
+}
+
+func TestNewAnd(t *testing.T) {
+	a := Condition{Column: "InIfBoundary", Equal: true, Value: "external"}
+	b := Opaque{SQL: "SrcAS = 65000"}
+	if got := NewAnd(); got != nil {
+		t.Errorf("NewAnd() = %v, expected nil", got)
+	}
+	if diff := helpers.Diff(NewAnd(nil, a), a); diff != "" {
+		t.Errorf("NewAnd(nil, a) (-got, +want):\n%s", diff)
+	}
+	if diff := helpers.Diff(NewAnd(And{a, b}, b), And{a, b, b}); diff != "" {
+		t.Errorf("NewAnd(And{a, b}, b) (-got, +want):\n%s", diff)
+	}
+}
@@ -211,6 +211,14 @@ LIMIT 20`, "6540").
 				{"label": "IPv6", "detail": "ethernet type", "quoted": false},
 			}},
 		},
+		{
+			URL:        "/api/v0/console/filter/complete",
+			StatusCode: 200,
+			JSONInput:  gin.H{"what": "value", "column": "tcpflags", "prefix": "S"},
+			JSONOutput: gin.H{"completions": []gin.H{
+				{"label": "SYN", "detail": "TCP flag", "quoted": false},
+			}},
+		},
 		{
 			URL:        "/api/v0/console/filter/complete",
 			StatusCode: 200,
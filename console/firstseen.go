@@ -0,0 +1,187 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+	"akvorado/console/query"
+)
+
+// firstSeenHandlerInput describes the input for the /first-seen endpoint.
+type firstSeenHandlerInput struct {
+	schema           *schema.Component
+	Dimensions       []query.Column `json:"dimensions" binding:"required,min=1"`
+	Filter           query.Filter   `json:"filter"`
+	Limit            int            `json:"limit" binding:"required,min=1"`
+	IgnoreBaseFilter bool           `json:"ignore-base-filter"`
+}
+
+// firstSeenHandlerOutput describes the output for the /first-seen endpoint.
+// Rows are sorted by decreasing first seen time.
+type firstSeenHandlerOutput struct {
+	Table string         `json:"table"`
+	Rows  []firstSeenRow `json:"rows"`
+}
+
+type firstSeenRow struct {
+	Dimensions []string  `json:"dimensions" ch:"dimensions"`
+	FirstSeen  time.Time `json:"first-seen" ch:"first_seen"`
+	LastSeen   time.Time `json:"last-seen" ch:"last_seen"`
+}
+
+// firstSeenTable describes a table tracking the first and last seen times
+// of a dimension tuple. These tables are managed by the orchestrator.
+type firstSeenTable struct {
+	Name    string   `ch:"table"`
+	Columns []string `ch:"columns"`
+}
+
+// requiredColumns returns the columns a first seen table should contain to
+// answer the query.
+func (input firstSeenHandlerInput) requiredColumns() []string {
+	columns := []string{}
+	for _, column := range input.Dimensions {
+		columns = append(columns, column.String())
+	}
+	for _, column := range input.Filter.Columns() {
+		if !slices.Contains(columns, column) {
+			columns = append(columns, column)
+		}
+	}
+	return columns
+}
+
+// selectFirstSeenTable returns the smallest table containing all the
+// provided columns.
+func selectFirstSeenTable(tables []firstSeenTable, columns []string) (string, bool) {
+	sort.SliceStable(tables, func(i, j int) bool {
+		if len(tables[i].Columns) != len(tables[j].Columns) {
+			return len(tables[i].Columns) < len(tables[j].Columns)
+		}
+		return tables[i].Name < tables[j].Name
+	})
+outer:
+	for _, table := range tables {
+		for _, column := range columns {
+			if !slices.Contains(table.Columns, column) {
+				continue outer
+			}
+		}
+		return table.Name, true
+	}
+	return "", false
+}
+
+// toSQLSelect returns the expression to select a dimension from a first seen
+// table. Some expressions used for the other tables reference additional
+// columns (Proto and ICMP columns for DstPort, DstLargeCommunities for
+// DstCommunities). First seen tables only contain the tracked columns, so
+// these dimensions are only displayed from their own column.
+func (input firstSeenHandlerInput) toSQLSelect(column query.Column) string {
+	switch column.Key() {
+	case schema.ColumnDstPort:
+		return `toString(DstPort)`
+	case schema.ColumnDstCommunities:
+		return `arrayStringConcat(arrayMap(c -> concat(toString(bitShiftRight(c, 16)), ':', toString(bitAnd(c, 0xffff))), DstCommunities), ' ')`
+	default:
+		return column.ToSQLSelect(input.schema)
+	}
+}
+
+// toSQL converts a first seen query to an SQL request using the provided
+// table.
+func (input firstSeenHandlerInput) toSQL(table string) string {
+	fields := []string{}
+	groupBy := []string{}
+	for _, column := range input.Dimensions {
+		fields = append(fields, input.toSQLSelect(column))
+		groupBy = append(groupBy, column.String())
+	}
+	where := input.Filter.Direct()
+	if where == "" {
+		where = "1"
+	}
+	return strings.TrimSpace(fmt.Sprintf(`
+SELECT
+ [%s] AS dimensions,
+ min(FirstSeen) AS first_seen,
+ max(LastSeen) AS last_seen
+FROM %s
+WHERE %s
+GROUP BY %s
+ORDER BY first_seen DESC
+LIMIT %d`,
+		strings.Join(fields, ",\n  "), table, where, strings.Join(groupBy, ", "), input.Limit))
+}
+
+func (c *Component) firstSeenHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	input := firstSeenHandlerInput{schema: c.d.Schema}
+	if err := gc.ShouldBindJSON(&input); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if err := query.Columns(input.Dimensions).Validate(input.schema); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if err := input.Filter.Validate(input.schema); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if err := c.applyBaseFilter(gc, &input.Filter, input.IgnoreBaseFilter); err != nil {
+		gc.JSON(http.StatusForbidden, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if input.Limit > c.config.DimensionsLimit {
+		gc.JSON(http.StatusBadRequest,
+			gin.H{"message": fmt.Sprintf("Limit is set beyond maximum value (%d)",
+				c.config.DimensionsLimit)})
+		return
+	}
+
+	// Find a table tracking the requested columns
+	tables := []firstSeenTable{}
+	if err := c.d.ClickHouseDB.Conn.Select(ctx, &tables, `
+SELECT table, groupArray(name) AS columns
+FROM system.columns
+WHERE database = currentDatabase()
+AND startsWith(table, 'first_seen_')
+AND NOT endsWith(table, '_consumer')
+AND name NOT IN ('FirstSeen', 'LastSeen')
+GROUP BY table`); err != nil {
+		c.r.Err(err).Msg("unable to query database")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+		return
+	}
+	table, ok := selectFirstSeenTable(tables, input.requiredColumns())
+	if !ok {
+		gc.JSON(http.StatusNotFound, gin.H{"message": "No first seen table tracks the requested columns."})
+		return
+	}
+
+	sqlQuery := input.toSQL(table)
+	gc.Header("X-SQL-Query", strings.ReplaceAll(sqlQuery, "\n", "  "))
+	rows := []firstSeenRow{}
+	c.metrics.clickhouseQueries.WithLabelValues(table).Inc()
+	if err := c.d.ClickHouseDB.Conn.Select(ctx, &rows, sqlQuery); err != nil {
+		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+		return
+	}
+	gc.JSON(http.StatusOK, firstSeenHandlerOutput{
+		Table: strings.TrimPrefix(table, "first_seen_"),
+		Rows:  rows,
+	})
+}
@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+	"akvorado/console/query"
+)
+
+func TestFirstSeenQuerySQL(t *testing.T) {
+	cases := []struct {
+		Description string
+		Input       firstSeenHandlerInput
+		Expected    string
+	}{
+		{
+			Description: "no filter",
+			Input: firstSeenHandlerInput{
+				Dimensions: []query.Column{query.NewColumn("SrcAS")},
+				Filter:     query.NewFilter(""),
+				Limit:      10,
+			},
+			Expected: `
+SELECT
+ [concat(toString(SrcAS), ': ', dictGetOrDefault('asns', 'name', SrcAS, '???'))] AS dimensions,
+ min(FirstSeen) AS first_seen,
+ max(LastSeen) AS last_seen
+FROM first_seen_as_addr
+WHERE 1
+GROUP BY SrcAS
+ORDER BY first_seen DESC
+LIMIT 10`,
+		}, {
+			Description: "filter",
+			Input: firstSeenHandlerInput{
+				Dimensions: []query.Column{query.NewColumn("SrcAS"), query.NewColumn("DstAddr")},
+				Filter:     query.NewFilter("DstAddr = 203.0.113.4"),
+				Limit:      5,
+			},
+			Expected: `
+SELECT
+ [concat(toString(SrcAS), ': ', dictGetOrDefault('asns', 'name', SrcAS, '???')),
+  replaceRegexpOne(IPv6NumToString(DstAddr), '^::ffff:', '')] AS dimensions,
+ min(FirstSeen) AS first_seen,
+ max(LastSeen) AS last_seen
+FROM first_seen_as_addr
+WHERE DstAddr = toIPv6('203.0.113.4')
+GROUP BY SrcAS, DstAddr
+ORDER BY first_seen DESC
+LIMIT 5`,
+		}, {
+			Description: "columns displayed with other columns",
+			Input: firstSeenHandlerInput{
+				Dimensions: []query.Column{query.NewColumn("DstPort"), query.NewColumn("DstCommunities")},
+				Filter:     query.NewFilter(""),
+				Limit:      10,
+			},
+			Expected: `
+SELECT
+ [toString(DstPort),
+  arrayStringConcat(arrayMap(c -> concat(toString(bitShiftRight(c, 16)), ':', toString(bitAnd(c, 0xffff))), DstCommunities), ' ')] AS dimensions,
+ min(FirstSeen) AS first_seen,
+ max(LastSeen) AS last_seen
+FROM first_seen_as_addr
+WHERE 1
+GROUP BY DstPort, DstCommunities
+ORDER BY first_seen DESC
+LIMIT 10`,
+		},
+	}
+	for _, tc := range cases {
+		tc.Input.schema = schema.NewMock(t).EnableAllColumns()
+		if err := query.Columns(tc.Input.Dimensions).Validate(tc.Input.schema); err != nil {
+			t.Fatalf("Validate() error:\n%+v", err)
+		}
+		if err := tc.Input.Filter.Validate(tc.Input.schema); err != nil {
+			t.Fatalf("Validate() error:\n%+v", err)
+		}
+		t.Run(tc.Description, func(t *testing.T) {
+			got := tc.Input.toSQL("first_seen_as_addr")
+			if diff := helpers.Diff(strings.Split(got, "\n"),
+				strings.Split(strings.TrimSpace(tc.Expected), "\n")); diff != "" {
+				t.Errorf("toSQL (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestSelectFirstSeenTable(t *testing.T) {
+	tables := []firstSeenTable{
+		{"first_seen_as_addr_port", []string{"SrcAS", "DstAddr", "DstPort"}},
+		{"first_seen_country", []string{"SrcCountry"}},
+		{"first_seen_as_addr", []string{"SrcAS", "DstAddr"}},
+	}
+	cases := []struct {
+		Columns  []string
+		Expected string
+	}{
+		{[]string{"SrcAS"}, "first_seen_as_addr"},
+		{[]string{"DstAddr", "SrcAS"}, "first_seen_as_addr"},
+		{[]string{"DstPort", "SrcAS"}, "first_seen_as_addr_port"},
+		{[]string{"SrcCountry"}, "first_seen_country"},
+		{[]string{"SrcCountry", "SrcAS"}, ""},
+	}
+	for _, tc := range cases {
+		got, ok := selectFirstSeenTable(tables, tc.Columns)
+		if ok != (tc.Expected != "") || got != tc.Expected {
+			t.Errorf("selectFirstSeenTable(%v) == %q, expected %q", tc.Columns, got, tc.Expected)
+		}
+	}
+}
+
+func TestFirstSeenHandler(t *testing.T) {
+	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+	first := time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC)
+	last := time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC)
+
+	tables := []firstSeenTable{
+		{"first_seen_as_addr", []string{"SrcAS", "DstAddr"}},
+	}
+	var sqlQuery string
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, tables).
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []firstSeenRow{
+				{[]string{"15169: Google", "203.0.113.4"}, first, last},
+			}).
+			Do(func(_, _ interface{}, query string, _ ...interface{}) {
+				sqlQuery = query
+			}).
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, tables).
+			Return(nil),
+	)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/console/first-seen",
+			JSONInput: gin.H{
+				"dimensions": []string{"SrcAS", "DstAddr"},
+				"filter":     "DstAddr = 203.0.113.4",
+				"limit":      10,
+			},
+			JSONOutput: gin.H{
+				"table": "as_addr",
+				"rows": []gin.H{
+					{
+						"dimensions": []string{"15169: Google", "203.0.113.4"},
+						"first-seen": first.Format(time.RFC3339),
+						"last-seen":  last.Format(time.RFC3339),
+					},
+				},
+			},
+		}, {
+			Description: "no table",
+			URL:         "/api/v0/console/first-seen",
+			JSONInput: gin.H{
+				"dimensions": []string{"SrcCountry"},
+				"limit":      10,
+			},
+			StatusCode: 404,
+			JSONOutput: gin.H{"message": "No first seen table tracks the requested columns."},
+		}, {
+			Description: "invalid limit",
+			URL:         "/api/v0/console/first-seen",
+			JSONInput: gin.H{
+				"dimensions": []string{"SrcAS"},
+				"limit":      100,
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Limit is set beyond maximum value (50)"},
+		},
+	})
+
+	if !strings.Contains(sqlQuery, "FROM first_seen_as_addr\nWHERE DstAddr = toIPv6('203.0.113.4')\n") {
+		t.Errorf("unexpected SQL query:\n%s", sqlQuery)
+	}
+}
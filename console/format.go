@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"fmt"
+	"net/netip"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
+)
+
+// validateFormats checks formats are only requested for address dimensions.
+func (input graphCommonHandlerInput) validateFormats() error {
+outer:
+	for name := range input.Formats {
+		for _, column := range input.Dimensions {
+			if !strings.EqualFold(name, column.String()) {
+				continue
+			}
+			if col, ok := input.schema.LookupColumnByKey(column.Key()); !ok ||
+				(col.ClickHouseType != "IPv6" && col.ClickHouseType != "LowCardinality(IPv6)") {
+				return fmt.Errorf("cannot format non-address dimension %q", name)
+			}
+			continue outer
+		}
+		return fmt.Errorf("cannot format missing dimension %q", name)
+	}
+	return nil
+}
+
+// formatRows returns a copy of the provided rows with address dimensions
+// rendered using the requested format. Grouping is still done on the raw
+// addresses and the original rows should be used to build filters. Addresses
+// which cannot be resolved are kept raw. When no formatting is requested, nil
+// is returned.
+func (c *Component) formatRows(gc *gin.Context, input graphCommonHandlerInput, rows [][]string) [][]string {
+	formats := make([]string, len(input.Dimensions))
+	requested := false
+	for idx, column := range input.Dimensions {
+		for name, format := range input.Formats {
+			if strings.EqualFold(name, column.String()) && format != "raw" {
+				formats[idx] = format
+				requested = true
+			}
+		}
+	}
+	if !requested {
+		return nil
+	}
+
+	// Collect addresses to resolve for each format
+	addresses := map[string]map[netip.Addr]struct{}{}
+	for _, row := range rows {
+		for idx, value := range row {
+			if idx >= len(formats) || formats[idx] == "" {
+				continue
+			}
+			if addr, err := netip.ParseAddr(value); err == nil {
+				if addresses[formats[idx]] == nil {
+					addresses[formats[idx]] = map[netip.Addr]struct{}{}
+				}
+				addresses[formats[idx]][addr] = struct{}{}
+			}
+		}
+	}
+	labels := map[string]map[netip.Addr]string{}
+	for format, set := range addresses {
+		list := make([]netip.Addr, 0, len(set))
+		for addr := range set {
+			list = append(list, addr)
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Less(list[j]) })
+		switch format {
+		case "ptr":
+			names := c.resolver.Lookup(c.t.Context(gc.Request.Context()), c.d.Clock.Now(), list)
+			labels[format] = map[netip.Addr]string{}
+			for addr, name := range names {
+				labels[format][addr] = fmt.Sprintf("%s (%s)", addr, name)
+			}
+		case "prefix":
+			labels[format] = c.lookupPrefixes(gc, list)
+		}
+	}
+
+	formatted := make([][]string, len(rows))
+	for i, row := range rows {
+		formatted[i] = slices.Clone(row)
+		for idx, value := range row {
+			if idx >= len(formats) || formats[idx] == "" {
+				continue
+			}
+			if addr, err := netip.ParseAddr(value); err == nil {
+				if label, ok := labels[formats[idx]][addr]; ok {
+					formatted[i][idx] = label
+				}
+			}
+		}
+	}
+	return formatted
+}
+
+// lookupPrefixes returns the most specific network from the networks
+// dictionary covering each of the provided addresses. On error, an empty
+// result is returned.
+func (c *Component) lookupPrefixes(gc *gin.Context, addresses []netip.Addr) map[netip.Addr]string {
+	quoted := make([]string, len(addresses))
+	for idx, addr := range addresses {
+		quoted[idx] = fmt.Sprintf("'%s'", netip.AddrFrom16(addr.As16()))
+	}
+	sqlQuery := fmt.Sprintf(`
+SELECT address, network
+FROM (SELECT arrayJoin([%s]) AS address)
+CROSS JOIN (SELECT network FROM networks)
+WHERE isIPAddressInRange(address, network)
+ORDER BY toUInt8(splitByChar('/', network)[2]) DESC
+LIMIT 1 BY address`, strings.Join(quoted, ", "))
+	results := []struct {
+		Address string `ch:"address"`
+		Network string `ch:"network"`
+	}{}
+	result := map[netip.Addr]string{}
+	ctx := c.t.Context(gc.Request.Context())
+	if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, strings.TrimSpace(sqlQuery)); err != nil {
+		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+		return result
+	}
+	for _, r := range results {
+		addr, err := netip.ParseAddr(r.Address)
+		if err != nil {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(r.Network)
+		if err != nil {
+			continue
+		}
+		if prefix.Addr().Is4In6() && prefix.Bits() >= 96 {
+			prefix = netip.PrefixFrom(prefix.Addr().Unmap(), prefix.Bits()-96)
+		}
+		result[addr.Unmap()] = prefix.String()
+	}
+	return result
+}
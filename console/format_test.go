@@ -0,0 +1,221 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	stdcontext "context"
+	"errors"
+	"net"
+	netHTTP "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+	"akvorado/console/query"
+	"akvorado/console/resolver"
+)
+
+func TestValidateFormats(t *testing.T) {
+	cases := []struct {
+		Description string
+		Dimensions  []string
+		Formats     map[string]string
+		Error       bool
+	}{
+		{
+			Description: "no format",
+			Dimensions:  []string{"SrcAddr", "ExporterName"},
+		}, {
+			Description: "address dimension",
+			Dimensions:  []string{"SrcAddr", "ExporterName"},
+			Formats:     map[string]string{"SrcAddr": "ptr"},
+		}, {
+			Description: "address dimension, different case",
+			Dimensions:  []string{"SrcAddr", "ExporterAddress"},
+			Formats:     map[string]string{"srcaddr": "prefix", "exporteraddress": "raw"},
+		}, {
+			Description: "non-address dimension",
+			Dimensions:  []string{"SrcAddr", "ExporterName"},
+			Formats:     map[string]string{"ExporterName": "ptr"},
+			Error:       true,
+		}, {
+			Description: "missing dimension",
+			Dimensions:  []string{"SrcAddr", "ExporterName"},
+			Formats:     map[string]string{"DstAddr": "ptr"},
+			Error:       true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			input := graphCommonHandlerInput{
+				schema:  schema.NewMock(t),
+				Formats: tc.Formats,
+			}
+			for _, dimension := range tc.Dimensions {
+				input.Dimensions = append(input.Dimensions, query.NewColumn(dimension))
+			}
+			if err := query.Columns(input.Dimensions).Validate(input.schema); err != nil {
+				t.Fatalf("Validate() error:\n%+v", err)
+			}
+			err := input.validateFormats()
+			if err != nil && !tc.Error {
+				t.Fatalf("validateFormats() error:\n%+v", err)
+			}
+			if err == nil && tc.Error {
+				t.Fatal("validateFormats() did not error")
+			}
+		})
+	}
+}
+
+func TestFormatRows(t *testing.T) {
+	c, _, mockConn, _ := NewMock(t, DefaultConfiguration())
+	c.resolver = resolver.New(50*time.Millisecond, time.Hour,
+		func(ctx stdcontext.Context, addr string) ([]string, error) {
+			switch addr {
+			case "192.0.2.1":
+				return []string{"one.example.com."}, nil
+			case "2001:db8::1":
+				return []string{"two.example.com."}, nil
+			case "192.0.2.3":
+				// Slow resolution
+				<-ctx.Done()
+				return nil, ctx.Err()
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+		})
+	rows := [][]string{
+		{"192.0.2.1", "router1"},
+		{"2001:db8::1", "router1"},
+		{"192.0.2.3", "router2"},
+		{"192.0.2.4", "router2"},
+		{"Other", "Other"},
+	}
+
+	cases := []struct {
+		Description string
+		Formats     map[string]string
+		Setup       func()
+		Expected    [][]string
+	}{
+		{
+			Description: "no format",
+			Expected:    nil,
+		}, {
+			Description: "raw format",
+			Formats:     map[string]string{"SrcAddr": "raw"},
+			Expected:    nil,
+		}, {
+			Description: "ptr format",
+			Formats:     map[string]string{"SrcAddr": "ptr"},
+			Expected: [][]string{
+				{"192.0.2.1 (one.example.com)", "router1"},
+				{"2001:db8::1 (two.example.com)", "router1"},
+				{"192.0.2.3", "router2"},
+				{"192.0.2.4", "router2"},
+				{"Other", "Other"},
+			},
+		}, {
+			Description: "prefix format",
+			Formats:     map[string]string{"SrcAddr": "prefix"},
+			Setup: func() {
+				mockConn.EXPECT().
+					Select(gomock.Any(), gomock.Any(), gomock.Any()).
+					SetArg(1, []struct {
+						Address string `ch:"address"`
+						Network string `ch:"network"`
+					}{
+						{"::ffff:192.0.2.1", "::ffff:192.0.2.0/120"},
+						{"2001:db8::1", "2001:db8::/64"},
+						{"::ffff:192.0.2.4", "::ffff:192.0.2.0/126"},
+					}).
+					Return(nil)
+			},
+			Expected: [][]string{
+				{"192.0.2.0/24", "router1"},
+				{"2001:db8::/64", "router1"},
+				{"192.0.2.3", "router2"},
+				{"192.0.2.0/30", "router2"},
+				{"Other", "Other"},
+			},
+		}, {
+			Description: "prefix format with database error",
+			Formats:     map[string]string{"SrcAddr": "prefix"},
+			Setup: func() {
+				mockConn.EXPECT().
+					Select(gomock.Any(), gomock.Any(), gomock.Any()).
+					Return(errors.New("database error"))
+			},
+			Expected: rows,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			if tc.Setup != nil {
+				tc.Setup()
+			}
+			gc, _ := gin.CreateTestContext(httptest.NewRecorder())
+			gc.Request = httptest.NewRequest("POST", "/api/v0/console/graph/sankey", nil)
+			input := graphCommonHandlerInput{
+				schema: c.d.Schema,
+				Dimensions: []query.Column{
+					query.NewColumn("SrcAddr"),
+					query.NewColumn("ExporterName"),
+				},
+				Formats: tc.Formats,
+			}
+			if err := query.Columns(input.Dimensions).Validate(input.schema); err != nil {
+				t.Fatalf("Validate() error:\n%+v", err)
+			}
+			got := c.formatRows(gc, input, rows)
+			if diff := helpers.Diff(got, tc.Expected); diff != "" {
+				t.Fatalf("formatRows() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGraphHandlerFormats(t *testing.T) {
+	_, h, _, _ := NewMock(t, DefaultConfiguration())
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "unknown format",
+			URL:         "/api/v0/console/graph/sankey",
+			JSONInput: gin.H{
+				"start":      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"dimensions": []string{"SrcAddr", "ExporterName"},
+				"limit":      10,
+				"units":      "l3bps",
+				"formats":    gin.H{"SrcAddr": "unknown"},
+			},
+			StatusCode: netHTTP.StatusBadRequest,
+			JSONOutput: gin.H{
+				"message": "Key: 'graphSankeyHandlerInput.graphCommonHandlerInput.Formats[SrcAddr]' Error:Field validation for 'Formats[SrcAddr]' failed on the 'oneof' tag",
+			},
+		}, {
+			Description: "non-address dimension",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"points":     100,
+				"dimensions": []string{"SrcAddr", "ExporterName"},
+				"limit":      10,
+				"units":      "l3bps",
+				"formats":    gin.H{"ExporterName": "ptr"},
+			},
+			StatusCode: netHTTP.StatusBadRequest,
+			JSONOutput: gin.H{
+				"message": `Cannot format non-address dimension "ExporterName"`,
+			},
+		},
+	})
+}
@@ -37,6 +37,7 @@ export type GraphLineHandlerOutput = {
   min: number[];
   max: number[];
   "95th": number[];
+  degraded?: string;
 };
 export type GraphSankeyHandlerResult = GraphSankeyHandlerOutput & {
   graphType: Extract<GraphType, "sankey">;
@@ -0,0 +1,188 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+)
+
+// This implements the contract of the Grafana JSON datasource: saved queries
+// from the configuration are exposed as targets and executed with the line
+// graph machinery.
+
+// grafanaQueryHandlerInput describes the input for the /grafana/query
+// endpoint.
+type grafanaQueryHandlerInput struct {
+	Range struct {
+		From time.Time `json:"from" binding:"required"`
+		To   time.Time `json:"to" binding:"required,gtfield=From"`
+	} `json:"range"`
+	MaxDataPoints uint `json:"maxDataPoints"`
+	Targets       []struct {
+		Target string `json:"target" binding:"required"`
+		RefID  string `json:"refId"`
+		// Type is "timeserie" (the default) or "table"
+		Type string `json:"type" binding:"omitempty,oneof=timeserie timeseries table"`
+	} `json:"targets" binding:"dive"`
+}
+
+// grafanaTimeSeries is a time series for Grafana. Each datapoint is a value
+// and a timestamp in milliseconds.
+type grafanaTimeSeries struct {
+	Target     string     `json:"target"`
+	RefID      string     `json:"refId,omitempty"`
+	Datapoints [][2]int64 `json:"datapoints"`
+}
+
+// grafanaTable is a table for Grafana.
+type grafanaTable struct {
+	Type    string               `json:"type"`
+	RefID   string               `json:"refId,omitempty"`
+	Columns []grafanaTableColumn `json:"columns"`
+	Rows    [][]interface{}      `json:"rows"`
+}
+
+type grafanaTableColumn struct {
+	Text string `json:"text"`
+	Type string `json:"type"`
+}
+
+// grafanaAuthentication accepts the configured static token as a bearer
+// token. Otherwise, it falls back to the authentication headers.
+func (c *Component) grafanaAuthentication() gin.HandlerFunc {
+	userAuthentication := c.d.Auth.UserAuthentication()
+	return func(gc *gin.Context) {
+		if c.config.Grafana.Token != "" {
+			expected := []byte("Bearer " + c.config.Grafana.Token)
+			if subtle.ConstantTimeCompare([]byte(gc.GetHeader("Authorization")), expected) == 1 {
+				gc.Next()
+				return
+			}
+		}
+		userAuthentication(gc)
+	}
+}
+
+// grafanaTestHandlerFunc is used by Grafana to test the datasource.
+func (c *Component) grafanaTestHandlerFunc(gc *gin.Context) {
+	gc.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
+
+// grafanaMetricsHandlerFunc lists the saved queries as targets.
+func (c *Component) grafanaMetricsHandlerFunc(gc *gin.Context) {
+	names := make([]string, 0, len(c.config.Grafana.Queries))
+	for name := range c.config.Grafana.Queries {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	metrics := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, gin.H{"label": name, "value": name})
+	}
+	gc.JSON(http.StatusOK, metrics)
+}
+
+// grafanaQueryHandlerFunc executes the requested saved queries.
+func (c *Component) grafanaQueryHandlerFunc(gc *gin.Context) {
+	var input grafanaQueryHandlerInput
+	if err := gc.ShouldBindJSON(&input); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	points := input.MaxDataPoints
+	if points < 5 {
+		points = 5
+	} else if points > 2000 {
+		points = 2000
+	}
+
+	results := []interface{}{}
+	for _, target := range input.Targets {
+		q, ok := c.config.Grafana.Queries[target.Target]
+		if !ok {
+			gc.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf("Unknown target %q.", target.Target)})
+			return
+		}
+		lineInput := graphLineHandlerInput{
+			graphCommonHandlerInput: graphCommonHandlerInput{
+				schema:     c.d.Schema,
+				Start:      input.Range.From,
+				End:        input.Range.To,
+				Dimensions: q.Dimensions,
+				Limit:      q.Limit,
+				Filter:     q.Filter,
+				Units:      q.Units,
+			},
+			Points: points,
+		}
+		lineInput.excludeZeroVolume()
+		if lineInput.Limit == 0 {
+			lineInput.Limit = 10
+		}
+		if lineInput.Units == "" {
+			lineInput.Units = "l3bps"
+		}
+		output, err := c.graphLine(gc, lineInput)
+		if err != nil {
+			gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+			return
+		}
+
+		if target.Type == "table" {
+			table := grafanaTable{
+				Type:  "table",
+				RefID: target.RefID,
+				Rows:  [][]interface{}{},
+			}
+			for _, column := range q.Dimensions {
+				table.Columns = append(table.Columns, grafanaTableColumn{Text: column.String(), Type: "string"})
+			}
+			for _, column := range []string{"Average", "Min", "Max", "95th"} {
+				table.Columns = append(table.Columns, grafanaTableColumn{Text: column, Type: "number"})
+			}
+			for i, row := range output.Rows {
+				tableRow := make([]interface{}, 0, len(row)+4)
+				for _, value := range row {
+					tableRow = append(tableRow, value)
+				}
+				tableRow = append(tableRow,
+					output.Average[i], output.Min[i], output.Max[i], output.NinetyFivePercentile[i])
+				table.Rows = append(table.Rows, tableRow)
+			}
+			results = append(results, table)
+			continue
+		}
+
+		for i, row := range output.Rows {
+			series := grafanaTimeSeries{
+				Target:     grafanaSeriesName(target.Target, row),
+				RefID:      target.RefID,
+				Datapoints: make([][2]int64, len(output.Time)),
+			}
+			for j, t := range output.Time {
+				series.Datapoints[j] = [2]int64{int64(output.Points[i][j]), t.UnixMilli()}
+			}
+			results = append(results, series)
+		}
+	}
+	gc.JSON(http.StatusOK, results)
+}
+
+// grafanaSeriesName returns the name of a series, using the same convention
+// as the console.
+func grafanaSeriesName(target string, row []string) string {
+	if len(row) == 0 {
+		return target
+	}
+	return strings.Join(row, " — ")
+}
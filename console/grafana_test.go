@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	netHTTP "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/console/authentication"
+	"akvorado/console/query"
+)
+
+func TestGrafanaHandlers(t *testing.T) {
+	config := DefaultConfiguration()
+	config.Grafana.Queries = map[string]GrafanaQueryConfiguration{
+		"top-exporters": {
+			Dimensions: []query.Column{query.NewColumn("ExporterName")},
+			Filter:     query.NewFilter("InIfBoundary = external"),
+			Limit:      5,
+		},
+		"total": {
+			Units: "pps",
+		},
+	}
+	_, h, mockConn, _ := NewMock(t, config)
+	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	results := []graphLineResult{
+		{1, base, 1000, []string{"router1"}},
+		{1, base, 500, []string{"router2"}},
+		{1, base, 100, []string{"Other"}},
+		{1, base.Add(time.Minute), 2000, []string{"router1"}},
+		{1, base.Add(time.Minute), 300, []string{"router2"}},
+		{1, base.Add(time.Minute), 100, []string{"Other"}},
+	}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, results).
+		Return(nil)
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, results).
+		Return(nil)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "test datasource",
+			URL:         "/api/v0/console/grafana/",
+			JSONOutput:  gin.H{"message": "ok"},
+		}, {
+			Description: "list targets",
+			URL:         "/api/v0/console/grafana/metrics",
+			JSONInput:   gin.H{},
+			JSONOutput: []gin.H{
+				{"label": "top-exporters", "value": "top-exporters"},
+				{"label": "total", "value": "total"},
+			},
+		}, {
+			Description: "time series",
+			URL:         "/api/v0/console/grafana/query",
+			JSONInput: gin.H{
+				"range": gin.H{
+					"from": base,
+					"to":   base.Add(2 * time.Minute),
+				},
+				"maxDataPoints": 100,
+				"targets": []gin.H{
+					{"target": "top-exporters", "refId": "A"},
+				},
+			},
+			JSONOutput: []gin.H{
+				{
+					"target": "router1",
+					"refId":  "A",
+					"datapoints": [][]float64{
+						{1000, float64(base.UnixMilli())},
+						{2000, float64(base.Add(time.Minute).UnixMilli())},
+					},
+				}, {
+					"target": "router2",
+					"refId":  "A",
+					"datapoints": [][]float64{
+						{500, float64(base.UnixMilli())},
+						{300, float64(base.Add(time.Minute).UnixMilli())},
+					},
+				}, {
+					"target": "Other",
+					"refId":  "A",
+					"datapoints": [][]float64{
+						{100, float64(base.UnixMilli())},
+						{100, float64(base.Add(time.Minute).UnixMilli())},
+					},
+				},
+			},
+		}, {
+			Description: "table",
+			URL:         "/api/v0/console/grafana/query",
+			JSONInput: gin.H{
+				"range": gin.H{
+					"from": base,
+					"to":   base.Add(2 * time.Minute),
+				},
+				"maxDataPoints": 100,
+				"targets": []gin.H{
+					{"target": "top-exporters", "refId": "A", "type": "table"},
+				},
+			},
+			JSONOutput: []gin.H{
+				{
+					"type":  "table",
+					"refId": "A",
+					"columns": []gin.H{
+						{"text": "ExporterName", "type": "string"},
+						{"text": "Average", "type": "number"},
+						{"text": "Min", "type": "number"},
+						{"text": "Max", "type": "number"},
+						{"text": "95th", "type": "number"},
+					},
+					"rows": [][]interface{}{
+						{"router1", 1500, 1000, 2000, 1500},
+						{"router2", 400, 300, 500, 400},
+						{"Other", 100, 100, 100, 100},
+					},
+				},
+			},
+		}, {
+			Description: "unknown target",
+			URL:         "/api/v0/console/grafana/query",
+			JSONInput: gin.H{
+				"range": gin.H{
+					"from": base,
+					"to":   base.Add(2 * time.Minute),
+				},
+				"targets": []gin.H{
+					{"target": "unknown"},
+				},
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": `Unknown target "unknown".`},
+		}, {
+			Description: "invalid range",
+			URL:         "/api/v0/console/grafana/query",
+			JSONInput: gin.H{
+				"range": gin.H{
+					"from": base,
+					"to":   base.Add(-2 * time.Minute),
+				},
+				"targets": []gin.H{
+					{"target": "total"},
+				},
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Key: 'grafanaQueryHandlerInput.Range.To' Error:Field validation for 'To' failed on the 'gtfield' tag"},
+		},
+	})
+}
+
+func TestGrafanaInvalidQuery(t *testing.T) {
+	config := DefaultConfiguration()
+	config.Grafana.Queries = map[string]GrafanaQueryConfiguration{
+		"invalid": {
+			Filter: query.NewFilter("InIfBoundary ="),
+		},
+	}
+	r := reporter.NewMock(t)
+	if _, err := New(r, config, Dependencies{Schema: schema.NewMock(t)}); err == nil {
+		t.Fatal("New() did not error")
+	}
+}
+
+func TestGrafanaAuthentication(t *testing.T) {
+	r := reporter.NewMock(t)
+	authConfig := authentication.DefaultConfiguration()
+	authConfig.DefaultUser = authentication.UserInformation{}
+	auth, err := authentication.New(r, authConfig)
+	if err != nil {
+		t.Fatalf("authentication.New() error:\n%+v", err)
+	}
+	c := Component{d: &Dependencies{Auth: auth}}
+	c.config.Grafana.Token = "secret"
+	router := gin.New()
+	router.GET("/", c.grafanaAuthentication(), c.grafanaTestHandlerFunc)
+
+	cases := []struct {
+		Description string
+		Header      netHTTP.Header
+		StatusCode  int
+	}{
+		{"no authentication", netHTTP.Header{}, netHTTP.StatusUnauthorized},
+		{"valid token", netHTTP.Header{"Authorization": []string{"Bearer secret"}}, netHTTP.StatusOK},
+		{"invalid token", netHTTP.Header{"Authorization": []string{"Bearer public"}}, netHTTP.StatusUnauthorized},
+		{"authentication headers", netHTTP.Header{"Remote-User": []string{"alfred"}}, netHTTP.StatusOK},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header = tc.Header
+			router.ServeHTTP(w, req)
+			if w.Code != tc.StatusCode {
+				t.Errorf("GET / status code %d, expected %d", w.Code, tc.StatusCode)
+			}
+		})
+	}
+}
@@ -4,26 +4,105 @@
 package console
 
 import (
+	"errors"
 	"fmt"
 	"strings"
 	"time"
 
+	"github.com/gin-gonic/gin"
+
 	"akvorado/common/schema"
+	"akvorado/console/authentication"
 	"akvorado/console/query"
 )
 
 // graphCommonHandlerInput is for bits common to graphLineHandlerInput and
 // graphSankeyHandlerInput.
 type graphCommonHandlerInput struct {
-	schema         *schema.Component
-	Start          time.Time      `json:"start" binding:"required"`
-	End            time.Time      `json:"end" binding:"required,gtfield=Start"`
-	Dimensions     []query.Column `json:"dimensions"`                          // group by ...
-	Limit          int            `json:"limit" binding:"min=1"`               // limit product of dimensions
-	Filter         query.Filter   `json:"filter"`                              // where ...
-	TruncateAddrV4 int            `json:"truncate-v4" binding:"min=0,max=32"`  // 0 or 32 = no truncation
-	TruncateAddrV6 int            `json:"truncate-v6" binding:"min=0,max=128"` // 0 or 128 = no truncation
-	Units          string         `json:"units" binding:"required,oneof=pps l3bps l2bps inl2% outl2%"`
+	schema     *schema.Component
+	Start      time.Time      `json:"start" binding:"required"`
+	End        time.Time      `json:"end" binding:"required,gtfield=Start"`
+	Dimensions []query.Column `json:"dimensions"` // group by ...
+	// Limit is the maximum number of dimension tuples while Coverage is the
+	// fraction of traffic the dimension tuples should cover. Only one of them
+	// can be used.
+	Limit          int          `json:"limit" binding:"required_without=Coverage,excluded_with=Coverage,omitempty,min=1"`
+	Coverage       float64      `json:"coverage" binding:"omitempty,gt=0,lte=1"`
+	Filter         query.Filter `json:"filter"`                              // where ...
+	TruncateAddrV4 int          `json:"truncate-v4" binding:"min=0,max=32"`  // 0 or 32 = no truncation
+	TruncateAddrV6 int          `json:"truncate-v6" binding:"min=0,max=128"` // 0 or 128 = no truncation
+	Units          string       `json:"units" binding:"required,oneof=pps l3bps l2bps inl2% outl2%"`
+	BypassCache    bool         `json:"bypass-cache"` // do not use cached results
+	// IgnoreBaseFilter skips the configured base filter. This is only
+	// allowed for administrators.
+	IgnoreBaseFilter bool `json:"ignore-base-filter"`
+	// Formats maps address dimensions to their rendering: raw, ptr (address
+	// with its reverse DNS name) or prefix (covering network).
+	Formats map[string]string `json:"formats" binding:"dive,oneof=raw ptr prefix"`
+
+	resolution time.Duration // set by normalizeTimeRange()
+}
+
+// errBaseFilterNotAdmin is returned when a non-administrator asks to ignore
+// the base filter.
+var errBaseFilterNotAdmin = errors.New("only administrators can ignore the base filter")
+
+// applyBaseFilter restricts the provided filter with the configured base
+// filter, unless an administrator asked to ignore it. It should be called
+// before adding default conditions.
+func (c *Component) applyBaseFilter(gc *gin.Context, qf *query.Filter, ignore bool) error {
+	if !ignore {
+		qf.And(c.config.BaseFilter)
+		return nil
+	}
+	if user, ok := gc.Get("user"); ok && user.(authentication.UserInformation).Admin {
+		return nil
+	}
+	return errBaseFilterNotAdmin
+}
+
+// excludeZeroVolume excludes flows without bytes or packets when they are
+// tagged by the inlet, unless the filter explicitly references them.
+func (input *graphCommonHandlerInput) excludeZeroVolume() {
+	if column, ok := input.schema.LookupColumnByKey(schema.ColumnZeroVolume); ok && !column.Disabled {
+		condition := query.NewFilter(zeroVolumeCondition)
+		if err := condition.Validate(input.schema); err != nil {
+			panic(err)
+		}
+		input.Filter.AddDefaultCondition(condition)
+	}
+}
+
+// zeroVolumeCondition is the filter added to exclude flows without bytes or
+// packets.
+const zeroVolumeCondition = "ZeroVolume = false"
+
+// rowsWith builds the "rows" table for the WITH clause. It contains the top
+// dimensions. When a coverage is requested, dimensions are selected in
+// descending order until they cover the requested fraction of the total
+// traffic. Limit is then used as a hard cap.
+func (input graphCommonHandlerInput) rowsWith(where string) string {
+	dimensions := []string{}
+	for _, column := range input.Dimensions {
+		dimensions = append(dimensions, column.String())
+	}
+	if input.Coverage == 0 {
+		return fmt.Sprintf(
+			"rows AS (SELECT %s FROM source WHERE %s GROUP BY %s ORDER BY SUM(Bytes) DESC LIMIT %d)",
+			strings.Join(dimensions, ", "),
+			where,
+			strings.Join(dimensions, ", "),
+			input.Limit)
+	}
+	return fmt.Sprintf(
+		"rows AS (SELECT %s FROM (SELECT %s, SUM(Bytes) AS rowBytes, SUM(rowBytes) OVER (ORDER BY rowBytes DESC ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING) AS previousBytes FROM source WHERE %s GROUP BY %s ORDER BY rowBytes DESC LIMIT %d) WHERE previousBytes < %g * (SELECT SUM(Bytes) FROM source WHERE %s))",
+		strings.Join(dimensions, ", "),
+		strings.Join(dimensions, ", "),
+		where,
+		strings.Join(dimensions, ", "),
+		input.Limit,
+		input.Coverage,
+		where)
 }
 
 // sourceSelect builds a SELECT query to use as a source for data. Notably, it
@@ -67,3 +67,91 @@ func TestSourceSelect(t *testing.T) {
 		}
 	}
 }
+
+func TestRowsWith(t *testing.T) {
+	sch := schema.NewMock(t)
+	cases := []struct {
+		Description string
+		Input       graphCommonHandlerInput
+		Expected    string
+	}{
+		{
+			Description: "with limit",
+			Input: graphCommonHandlerInput{
+				Dimensions: []query.Column{query.NewColumn("SrcAS"), query.NewColumn("ExporterName")},
+				Limit:      10,
+			},
+			Expected: "rows AS (SELECT SrcAS, ExporterName FROM source WHERE {{ .Timefilter }} GROUP BY SrcAS, ExporterName ORDER BY SUM(Bytes) DESC LIMIT 10)",
+		}, {
+			Description: "with coverage",
+			Input: graphCommonHandlerInput{
+				Dimensions: []query.Column{query.NewColumn("SrcAS"), query.NewColumn("ExporterName")},
+				Limit:      50,
+				Coverage:   0.95,
+			},
+			Expected: "rows AS (SELECT SrcAS, ExporterName FROM (SELECT SrcAS, ExporterName, SUM(Bytes) AS rowBytes, SUM(rowBytes) OVER (ORDER BY rowBytes DESC ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING) AS previousBytes FROM source WHERE {{ .Timefilter }} GROUP BY SrcAS, ExporterName ORDER BY rowBytes DESC LIMIT 50) WHERE previousBytes < 0.95 * (SELECT SUM(Bytes) FROM source WHERE {{ .Timefilter }}))",
+		},
+	}
+	for _, tc := range cases {
+		tc.Input.schema = sch
+		if err := query.Columns(tc.Input.Dimensions).Validate(tc.Input.schema); err != nil {
+			t.Fatalf("Validate() error:\n%+v", err)
+		}
+		got := tc.Input.rowsWith("{{ .Timefilter }}")
+		if diff := helpers.Diff(got, tc.Expected); diff != "" {
+			t.Errorf("rowsWith(%q) (-got, +want): \n%s", tc.Description, diff)
+		}
+	}
+}
+
+func TestExcludeZeroVolume(t *testing.T) {
+	enabled, err := schema.New(schema.Configuration{
+		Enabled: []schema.ColumnKey{schema.ColumnZeroVolume},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	cases := []struct {
+		Description string
+		Schema      *schema.Component
+		Filter      string
+		Expected    string
+	}{
+		{
+			Description: "column disabled",
+			Schema:      schema.NewMock(t),
+			Filter:      "SrcAS = 65000",
+			Expected:    "SrcAS = 65000",
+		}, {
+			Description: "no filter",
+			Schema:      enabled,
+			Filter:      "",
+			Expected:    "ZeroVolume = false",
+		}, {
+			Description: "with filter",
+			Schema:      enabled,
+			Filter:      "SrcAS = 65000",
+			Expected:    "ZeroVolume = false AND (SrcAS = 65000)",
+		}, {
+			Description: "explicit filter",
+			Schema:      enabled,
+			Filter:      "ZeroVolume = true",
+			Expected:    "ZeroVolume = true",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			input := graphCommonHandlerInput{
+				schema: tc.Schema,
+				Filter: query.NewFilter(tc.Filter),
+			}
+			if err := input.Filter.Validate(tc.Schema); err != nil {
+				t.Fatalf("Validate() error:\n%+v", err)
+			}
+			input.excludeZeroVolume()
+			if diff := helpers.Diff(input.Filter.Direct(), tc.Expected); diff != "" {
+				t.Fatalf("excludeZeroVolume() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
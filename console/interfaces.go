@@ -0,0 +1,275 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+	"akvorado/console/query"
+)
+
+// interfacesPoints is the number of points used to select the table and the
+// resolution of the interfaces graph.
+const interfacesPoints = 20
+
+// graphInterfacesHandlerInput describes the input for the /graph/interfaces
+// endpoint.
+type graphInterfacesHandlerInput struct {
+	schema  *schema.Component
+	columns []query.Column
+	Start   time.Time    `json:"start" binding:"required"`
+	End     time.Time    `json:"end" binding:"required,gtfield=Start"`
+	Filter  query.Filter `json:"filter"`
+	Units   string       `json:"units" binding:"required,oneof=pps l3bps l2bps"`
+	// Limit is the maximum number of interface pairs for each exporter.
+	// Remaining pairs are folded into "other".
+	Limit int `json:"limit" binding:"required,min=1"`
+	// ExporterLimit is the maximum number of exporters.
+	ExporterLimit    int  `json:"exporter-limit" binding:"required,min=1"`
+	BypassCache      bool `json:"bypass-cache"`
+	IgnoreBaseFilter bool `json:"ignore-base-filter"`
+}
+
+// graphInterfacesHandlerOutput describes the output for the /graph/interfaces
+// endpoint. Exporters are sorted by decreasing traffic.
+type graphInterfacesHandlerOutput struct {
+	Exporters []interfacesExporter `json:"exporters"`
+}
+
+// interfacesExporter is the traffic between pairs of interfaces of an
+// exporter. Pairs are sorted by decreasing traffic.
+type interfacesExporter struct {
+	Name  string           `json:"name"`
+	Xps   int              `json:"xps"`
+	Pairs []interfacesPair `json:"pairs"`
+	// Other is the traffic of the pairs beyond the limit.
+	Other int `json:"other"`
+}
+
+type interfacesPair struct {
+	In  interfaceDetails `json:"in"`
+	Out interfaceDetails `json:"out"`
+	Xps int              `json:"xps"`
+}
+
+type interfaceDetails struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Speed       uint32 `json:"speed,omitempty"`
+}
+
+// validateColumns validates the columns used to group flows. They may have
+// been disabled in the schema.
+func (input *graphInterfacesHandlerInput) validateColumns() error {
+	input.columns = []query.Column{
+		query.NewColumn("ExporterName"),
+		query.NewColumn("InIfName"),
+		query.NewColumn("OutIfName"),
+	}
+	return query.Columns(input.columns).Validate(input.schema)
+}
+
+// common returns the common input for graphs, to use with the helpers
+// expecting it.
+func (input graphInterfacesHandlerInput) common() graphCommonHandlerInput {
+	return graphCommonHandlerInput{
+		schema:      input.schema,
+		Start:       input.Start,
+		End:         input.End,
+		Dimensions:  input.columns,
+		Filter:      input.Filter,
+		Units:       input.Units,
+		BypassCache: input.BypassCache,
+	}
+}
+
+// toSQL converts an interfaces query to an SQL request. Pairs of interfaces
+// are ranked inside each exporter and pairs beyond the limit are folded
+// together.
+func (input graphInterfacesHandlerInput) toSQL() string {
+	where := templateWhere(input.Filter)
+	with := []string{
+		fmt.Sprintf("source AS (%s)", input.common().sourceSelect()),
+		fmt.Sprintf(`(SELECT MAX(TimeReceived) - MIN(TimeReceived) FROM source WHERE %s) AS range`, where),
+		fmt.Sprintf(
+			"exporters AS (SELECT ExporterName FROM source WHERE %s GROUP BY ExporterName ORDER BY SUM(Bytes) DESC LIMIT %d)",
+			where, input.ExporterLimit),
+	}
+	sqlQuery := fmt.Sprintf(`
+{{ with %s }}
+WITH
+ %s
+SELECT
+ ExporterName AS exporter,
+ rank > %d AS other,
+ if(other, '', InIfName) AS inIfName,
+ if(other, '', OutIfName) AS outIfName,
+ SUM(pairXps) AS xps
+FROM (
+ SELECT
+  ExporterName,
+  InIfName,
+  OutIfName,
+  {{ .Units }}/range AS pairXps,
+  row_number() OVER (PARTITION BY ExporterName ORDER BY pairXps DESC) AS rank
+ FROM source
+ WHERE %s AND ExporterName IN (SELECT ExporterName FROM exporters)
+ GROUP BY ExporterName, InIfName, OutIfName
+)
+GROUP BY exporter, other, inIfName, outIfName
+ORDER BY xps DESC
+{{ end }}`,
+		templateContext(inputContext{
+			Start:             input.Start,
+			End:               input.End,
+			MainTableRequired: requireMainTable(input.schema, input.columns, input.Filter),
+			Points:            interfacesPoints,
+			Units:             input.Units,
+		}),
+		strings.Join(with, ",\n "), input.Limit, where)
+	return strings.TrimSpace(sqlQuery)
+}
+
+// interfacesMetadataQuery retrieves the last known description and speed of
+// the interfaces of the provided exporters.
+const interfacesMetadataQuery = `
+SELECT
+ ExporterName AS exporter,
+ IfName AS name,
+ argMax(IfDescription, TimeReceived) AS description,
+ argMax(IfSpeed, TimeReceived) AS speed
+FROM exporters
+WHERE has($1, ExporterName)
+GROUP BY ExporterName, IfName`
+
+func (c *Component) graphInterfacesHandlerFunc(gc *gin.Context) {
+	input := graphInterfacesHandlerInput{schema: c.d.Schema}
+	if err := gc.ShouldBindJSON(&input); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if err := input.validateColumns(); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if err := input.Filter.Validate(input.schema); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if err := c.applyBaseFilter(gc, &input.Filter, input.IgnoreBaseFilter); err != nil {
+		gc.JSON(http.StatusForbidden, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	resolution := c.normalizeTimeRange(&input.Start, &input.End, interfacesPoints)
+	common := input.common()
+	common.resolution = resolution
+	common.excludeZeroVolume()
+	input.Filter = common.Filter
+	if input.Limit > c.config.DimensionsLimit || input.ExporterLimit > c.config.DimensionsLimit {
+		gc.JSON(http.StatusBadRequest,
+			gin.H{"message": fmt.Sprintf("Limit is set beyond maximum value (%d)",
+				c.config.DimensionsLimit)})
+		return
+	}
+
+	// Prepare and execute query
+	sqlQuery := c.finalizeQuery(input.toSQL())
+	gc.Header("X-SQL-Query", strings.ReplaceAll(sqlQuery, "\n", "  "))
+	results := []struct {
+		Exporter  string  `ch:"exporter"`
+		Other     bool    `ch:"other"`
+		InIfName  string  `ch:"inIfName"`
+		OutIfName string  `ch:"outIfName"`
+		Xps       float64 `ch:"xps"`
+	}{}
+	var cacheStatus queryCacheStatus
+	if err := cachedSelect(c, gc, common, &cacheStatus, &results, sqlQuery); err != nil {
+		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+		return
+	}
+
+	// Build the nested output. Results are already sorted by traffic.
+	exporters := map[string]*interfacesExporter{}
+	for _, result := range results {
+		exporter, ok := exporters[result.Exporter]
+		if !ok {
+			exporter = &interfacesExporter{
+				Name:  result.Exporter,
+				Pairs: []interfacesPair{},
+			}
+			exporters[result.Exporter] = exporter
+		}
+		exporter.Xps += int(result.Xps)
+		if result.Other {
+			exporter.Other += int(result.Xps)
+			continue
+		}
+		exporter.Pairs = append(exporter.Pairs, interfacesPair{
+			In:  interfaceDetails{Name: result.InIfName},
+			Out: interfaceDetails{Name: result.OutIfName},
+			Xps: int(result.Xps),
+		})
+	}
+	output := graphInterfacesHandlerOutput{
+		Exporters: make([]interfacesExporter, 0, len(exporters)),
+	}
+	names := make([]string, 0, len(exporters))
+	for name := range exporters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Attach interface metadata. This is best effort.
+	if len(names) > 0 {
+		metadata := []struct {
+			Exporter    string `ch:"exporter"`
+			Name        string `ch:"name"`
+			Description string `ch:"description"`
+			Speed       uint32 `ch:"speed"`
+		}{}
+		ctx := c.t.Context(gc.Request.Context())
+		if err := c.d.ClickHouseDB.Conn.Select(ctx, &metadata, strings.TrimSpace(interfacesMetadataQuery), names); err != nil {
+			c.r.Err(err).Msg("unable to query interface metadata")
+		}
+		details := map[[2]string]interfaceDetails{}
+		for _, m := range metadata {
+			details[[2]string{m.Exporter, m.Name}] = interfaceDetails{
+				Name:        m.Name,
+				Description: m.Description,
+				Speed:       m.Speed,
+			}
+		}
+		for _, exporter := range exporters {
+			for idx, pair := range exporter.Pairs {
+				if d, ok := details[[2]string{exporter.Name, pair.In.Name}]; ok {
+					exporter.Pairs[idx].In = d
+				}
+				if d, ok := details[[2]string{exporter.Name, pair.Out.Name}]; ok {
+					exporter.Pairs[idx].Out = d
+				}
+			}
+		}
+	}
+
+	for _, name := range names {
+		output.Exporters = append(output.Exporters, *exporters[name])
+	}
+	sort.Slice(output.Exporters, func(i, j int) bool {
+		if output.Exporters[i].Xps == output.Exporters[j].Xps {
+			return output.Exporters[i].Name < output.Exporters[j].Name
+		}
+		return output.Exporters[i].Xps > output.Exporters[j].Xps
+	})
+
+	gc.JSON(http.StatusOK, output)
+}
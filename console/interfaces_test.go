@@ -0,0 +1,172 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+	"akvorado/console/query"
+)
+
+func TestInterfacesQuerySQL(t *testing.T) {
+	input := graphInterfacesHandlerInput{
+		schema:        schema.NewMock(t),
+		Start:         time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+		End:           time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+		Filter:        query.NewFilter("InIfBoundary = external"),
+		Units:         "l3bps",
+		Limit:         5,
+		ExporterLimit: 10,
+	}
+	if err := input.validateColumns(); err != nil {
+		t.Fatalf("validateColumns() error:\n%+v", err)
+	}
+	if err := input.Filter.Validate(input.schema); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	expected := strings.ReplaceAll(`
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":20,"units":"l3bps"}@@ }}
+WITH
+ source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
+ (SELECT MAX(TimeReceived) - MIN(TimeReceived) FROM source WHERE {{ .Timefilter }} AND (InIfBoundary = 'external')) AS range,
+ exporters AS (SELECT ExporterName FROM source WHERE {{ .Timefilter }} AND (InIfBoundary = 'external') GROUP BY ExporterName ORDER BY SUM(Bytes) DESC LIMIT 10)
+SELECT
+ ExporterName AS exporter,
+ rank > 5 AS other,
+ if(other, '', InIfName) AS inIfName,
+ if(other, '', OutIfName) AS outIfName,
+ SUM(pairXps) AS xps
+FROM (
+ SELECT
+  ExporterName,
+  InIfName,
+  OutIfName,
+  {{ .Units }}/range AS pairXps,
+  row_number() OVER (PARTITION BY ExporterName ORDER BY pairXps DESC) AS rank
+ FROM source
+ WHERE {{ .Timefilter }} AND (InIfBoundary = 'external') AND ExporterName IN (SELECT ExporterName FROM exporters)
+ GROUP BY ExporterName, InIfName, OutIfName
+)
+GROUP BY exporter, other, inIfName, outIfName
+ORDER BY xps DESC
+{{ end }}`, "@@", "`")
+	got := input.toSQL()
+	if diff := helpers.Diff(strings.Split(got, "\n"),
+		strings.Split(strings.TrimSpace(expected), "\n")); diff != "" {
+		t.Errorf("toSQL (-got, +want):\n%s", diff)
+	}
+}
+
+func TestInterfacesHandler(t *testing.T) {
+	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+
+	expectedSQL := []struct {
+		Exporter  string  `ch:"exporter"`
+		Other     bool    `ch:"other"`
+		InIfName  string  `ch:"inIfName"`
+		OutIfName string  `ch:"outIfName"`
+		Xps       float64 `ch:"xps"`
+	}{
+		{"router1", false, "Gi0/0/0", "Gi0/0/1", 9000},
+		{"router2", false, "Gi0/0/0", "Gi0/0/2", 7000},
+		{"router1", false, "Gi0/0/1", "Gi0/0/0", 5000},
+		{"router1", true, "", "", 3000},
+		{"router2", true, "", "", 1000},
+	}
+	expectedMetadata := []struct {
+		Exporter    string `ch:"exporter"`
+		Name        string `ch:"name"`
+		Description string `ch:"description"`
+		Speed       uint32 `ch:"speed"`
+	}{
+		{"router1", "Gi0/0/0", "Transit: Cogent", 10000},
+		{"router1", "Gi0/0/1", "Core: router2", 100000},
+		{"router2", "Gi0/0/0", "Core: router1", 100000},
+	}
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, expectedSQL).
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, expectedMetadata).
+			Return(nil),
+	)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/console/graph/interfaces",
+			JSONInput: gin.H{
+				"start":          time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":            time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"limit":          2,
+				"exporter-limit": 10,
+				"units":          "l3bps",
+			},
+			JSONOutput: gin.H{
+				"exporters": []gin.H{
+					{
+						"name": "router1",
+						"xps":  17000,
+						"pairs": []gin.H{
+							{
+								"in":  gin.H{"name": "Gi0/0/0", "description": "Transit: Cogent", "speed": 10000},
+								"out": gin.H{"name": "Gi0/0/1", "description": "Core: router2", "speed": 100000},
+								"xps": 9000,
+							}, {
+								"in":  gin.H{"name": "Gi0/0/1", "description": "Core: router2", "speed": 100000},
+								"out": gin.H{"name": "Gi0/0/0", "description": "Transit: Cogent", "speed": 10000},
+								"xps": 5000,
+							},
+						},
+						"other": 3000,
+					}, {
+						"name": "router2",
+						"xps":  8000,
+						"pairs": []gin.H{
+							{
+								"in":  gin.H{"name": "Gi0/0/0", "description": "Core: router1", "speed": 100000},
+								"out": gin.H{"name": "Gi0/0/2"},
+								"xps": 7000,
+							},
+						},
+						"other": 1000,
+					},
+				},
+			},
+		}, {
+			Description: "limit too high",
+			URL:         "/api/v0/console/graph/interfaces",
+			JSONInput: gin.H{
+				"start":          time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":            time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"limit":          2,
+				"exporter-limit": 1000,
+				"units":          "l3bps",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Limit is set beyond maximum value (50)"},
+		}, {
+			Description: "unsupported units",
+			URL:         "/api/v0/console/graph/interfaces",
+			JSONInput: gin.H{
+				"start":          time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":            time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"limit":          2,
+				"exporter-limit": 10,
+				"units":          "inl2%",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{"message": "Key: 'graphInterfacesHandlerInput.Units' Error:Field validation for 'Units' failed on the 'oneof' tag"},
+		},
+	})
+}
@@ -4,6 +4,7 @@
 package console
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"sort"
@@ -23,6 +24,17 @@ type graphLineHandlerInput struct {
 	Points         uint `json:"points" binding:"required,min=5,max=2000"` // minimum number of points
 	Bidirectional  bool `json:"bidirectional"`
 	PreviousPeriod bool `json:"previous-period"`
+	// Aggregate tells how to compute min, max, average and 95th percentile.
+	// With "points" (the default), they are computed from the returned
+	// points. With "full-resolution", they are computed by ClickHouse using
+	// the best resolution available for the requested period.
+	Aggregate string `json:"aggregate" binding:"omitempty,oneof=points full-resolution"`
+	// OtherDetails splits "Other" between the tuples ranked beyond the limit
+	// ("Other (ranked)") and the tuples absent from the ranking ("Other",
+	// for the reverse direction and the previous period). It also returns
+	// the estimated number of distinct tuples folded into "Other" for each
+	// point. This is more expensive.
+	OtherDetails bool `json:"other-details"`
 }
 
 // graphLineHandlerOutput describes the output for the /graph/line endpoint. A
@@ -39,6 +51,27 @@ type graphLineHandlerOutput struct {
 	Min                  []int          `json:"min"`     // row → min xps
 	Max                  []int          `json:"max"`     // row → max xps
 	NinetyFivePercentile []int          `json:"95th"`    // row → 95th xps
+	// CoverageRows is the number of rows (excluding "Other") needed to
+	// achieve the requested coverage.
+	CoverageRows int `json:"coverage-rows,omitempty"`
+	// OtherTuples is the estimated number of distinct tuples folded into
+	// "Other" for the direct direction (t → tuples). It is only present when
+	// details about "Other" are requested.
+	OtherTuples []int `json:"other-tuples,omitempty"`
+	// FormattedRows are the rows with addresses formatted as requested.
+	FormattedRows [][]string `json:"formatted-rows,omitempty"`
+	// Degraded explains the limitations of the result when it does not come
+	// from ClickHouse.
+	Degraded string `json:"degraded,omitempty"`
+}
+
+// otherRanked is the dimension value for tuples ranked beyond the limit when
+// "Other" is split.
+const otherRanked = "Other (ranked)"
+
+// isOtherRow tells if the provided row is one of the "Other" rows.
+func isOtherRow(row []string) bool {
+	return len(row) > 0 && (row[0] == "Other" || row[0] == otherRanked)
 }
 
 // reverseDirection reverts the direction of a provided input. It does not
@@ -96,12 +129,13 @@ type toSQL1Options struct {
 	skipWithClause   bool
 	reverseDirection bool
 	offsetedStart    time.Time
+	statistics       bool
 }
 
 func (input graphLineHandlerInput) toSQL1(axis int, options toSQL1Options) string {
 	var startForInterval *time.Time
 	var offsetShift string
-	if !options.offsetedStart.IsZero() {
+	if !options.offsetedStart.IsZero() && !options.statistics {
 		startForInterval = &options.offsetedStart
 		offsetShift = fmt.Sprintf(" + INTERVAL %d second",
 			int64(options.offsetedStart.Sub(input.Start).Seconds()))
@@ -117,17 +151,26 @@ func (input graphLineHandlerInput) toSQL1(axis int, options toSQL1Options) strin
 	dimensions := []string{}
 	dimensionsInterpolate := ""
 	others := []string{}
+	othersRanked := []string{}
 	for _, column := range input.Dimensions {
 		field := column.ToSQLSelect(input.schema)
 		selectFields = append(selectFields, field)
 		dimensions = append(dimensions, column.String())
 		others = append(others, "'Other'")
+		othersRanked = append(othersRanked, fmt.Sprintf("'%s'", otherRanked))
 	}
 	if len(dimensions) > 0 {
-		fields = append(fields, fmt.Sprintf(`if((%s) IN rows, [%s], [%s]) AS dimensions`,
+		otherFields := fmt.Sprintf("[%s]", strings.Join(others, ", "))
+		if input.OtherDetails {
+			otherFields = fmt.Sprintf("if((%s) IN ranked, [%s], [%s])",
+				strings.Join(dimensions, ", "),
+				strings.Join(othersRanked, ", "),
+				strings.Join(others, ", "))
+		}
+		fields = append(fields, fmt.Sprintf(`if((%s) IN rows, [%s], %s) AS dimensions`,
 			strings.Join(dimensions, ", "),
 			strings.Join(selectFields, ", "),
-			strings.Join(others, ", ")))
+			otherFields))
 		dimensionsInterpolate = fmt.Sprintf("[%s]", strings.Join(others, ", "))
 	} else {
 		fields = append(fields, "emptyArrayString() AS dimensions")
@@ -139,12 +182,10 @@ func (input graphLineHandlerInput) toSQL1(axis int, options toSQL1Options) strin
 	if !options.skipWithClause {
 		with := []string{fmt.Sprintf("source AS (%s)", input.sourceSelect())}
 		if len(dimensions) > 0 {
-			with = append(with, fmt.Sprintf(
-				"rows AS (SELECT %s FROM source WHERE %s GROUP BY %s ORDER BY SUM(Bytes) DESC LIMIT %d)",
-				strings.Join(dimensions, ", "),
-				where,
-				strings.Join(dimensions, ", "),
-				input.Limit))
+			with = append(with, input.rowsWith(where))
+			if input.OtherDetails {
+				with = append(with, input.rankedWith(where))
+			}
 		}
 		if len(with) > 0 {
 			withStr = fmt.Sprintf("\nWITH\n %s", strings.Join(with, ",\n "))
@@ -162,6 +203,39 @@ func (input graphLineHandlerInput) toSQL1(axis int, options toSQL1Options) strin
 		}
 	}
 
+	queryContext := templateContext(inputContext{
+		Start:             input.Start,
+		End:               input.End,
+		StartForInterval:  startForInterval,
+		MainTableRequired: requireMainTable(input.schema, input.Dimensions, input.Filter),
+		FullResolution:    options.statistics,
+		Points:            input.Points,
+		Units:             units,
+	})
+	if options.statistics {
+		// Missing slots are zero. Min ignores them, like in the
+		// handler. Other aggregations take them into account.
+		sqlQuery := fmt.Sprintf(`
+{{ with %s }}%s
+SELECT
+ %d AS axis,
+ dimensions,
+ minIf(xps, xps > 0) AS min,
+ max(xps) AS max,
+ sum(xps)/{{ .Slots }} AS average,
+ arrayReduce('quantile(0.95)', arrayResize(groupArray(xps), {{ .Slots }}, toFloat64(0))) AS p95
+FROM (
+SELECT
+ %s
+FROM source
+WHERE %s
+GROUP BY time, dimensions)
+GROUP BY dimensions
+{{ end }}`,
+			queryContext, withStr, axis, strings.Join(fields, ",\n "), where)
+		return strings.TrimSpace(sqlQuery)
+	}
+
 	sqlQuery := fmt.Sprintf(`
 {{ with %s }}%s
 SELECT %d AS axis, * FROM (
@@ -176,35 +250,92 @@ ORDER BY time WITH FILL
  STEP {{ .Interval }}
  INTERPOLATE (dimensions AS %s))
 {{ end }}`,
-		templateContext(inputContext{
-			Start:             input.Start,
-			End:               input.End,
-			StartForInterval:  startForInterval,
-			MainTableRequired: requireMainTable(input.schema, input.Dimensions, input.Filter),
-			Points:            input.Points,
-			Units:             units,
-		}),
-		withStr, axis, strings.Join(fields, ",\n "), where, offsetShift, offsetShift,
+		queryContext, withStr, axis, strings.Join(fields, ",\n "), where, offsetShift, offsetShift,
 		dimensionsInterpolate,
 	)
 	return strings.TrimSpace(sqlQuery)
 }
 
+// rankedWith builds the "ranked" table for the WITH clause. It contains all
+// the dimensions ranked for the direct direction, including the ones beyond
+// the limit.
+func (input graphLineHandlerInput) rankedWith(where string) string {
+	dimensions := []string{}
+	for _, column := range input.Dimensions {
+		dimensions = append(dimensions, column.String())
+	}
+	return fmt.Sprintf("ranked AS (SELECT %s FROM source WHERE %s GROUP BY %s)",
+		strings.Join(dimensions, ", "),
+		where,
+		strings.Join(dimensions, ", "))
+}
+
+// toSQLOtherTuples converts a graph input to an SQL request returning, for
+// each point of the direct direction, the estimated number of distinct
+// tuples folded into "Other".
+func (input graphLineHandlerInput) toSQLOtherTuples() string {
+	where := templateWhere(input.Filter)
+	dimensions := []string{}
+	for _, column := range input.Dimensions {
+		dimensions = append(dimensions, column.String())
+	}
+	queryContext := templateContext(inputContext{
+		Start:             input.Start,
+		End:               input.End,
+		MainTableRequired: requireMainTable(input.schema, input.Dimensions, input.Filter),
+		Points:            input.Points,
+		Units:             input.Units,
+	})
+	sqlQuery := fmt.Sprintf(`
+{{ with %s }}
+WITH
+ source AS (%s),
+ %s
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ uniq(%s) AS tuples
+FROM source
+WHERE %s AND (%s) NOT IN rows
+GROUP BY time
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }}
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second
+ STEP {{ .Interval }}
+{{ end }}`,
+		queryContext, input.sourceSelect(), input.rowsWith(where),
+		strings.Join(dimensions, ", "),
+		where, strings.Join(dimensions, ", "))
+	return strings.TrimSpace(sqlQuery)
+}
+
 // toSQL converts a graph input to an SQL request
 func (input graphLineHandlerInput) toSQL() string {
-	parts := []string{input.toSQL1(1, toSQL1Options{})}
+	return input.toSQLForAllAxes(false)
+}
+
+// toSQLStatistics converts a graph input to an SQL request returning min, max,
+// average and 95th percentile for each row, using the best available
+// resolution instead of the requested number of points.
+func (input graphLineHandlerInput) toSQLStatistics() string {
+	return input.toSQLForAllAxes(true)
+}
+
+func (input graphLineHandlerInput) toSQLForAllAxes(statistics bool) string {
+	parts := []string{input.toSQL1(1, toSQL1Options{statistics: statistics})}
 	// Handle specific options. We have to align time periods in
 	// case the previous period does not use the same offsets.
 	if input.Bidirectional {
 		parts = append(parts, input.reverseDirection().toSQL1(2, toSQL1Options{
 			skipWithClause:   true,
 			reverseDirection: true,
+			statistics:       statistics,
 		}))
 	}
 	if input.PreviousPeriod {
 		parts = append(parts, input.previousPeriod().toSQL1(3, toSQL1Options{
 			skipWithClause: true,
 			offsetedStart:  input.Start,
+			statistics:     statistics,
 		}))
 	}
 	if input.Bidirectional && input.PreviousPeriod {
@@ -212,13 +343,13 @@ func (input graphLineHandlerInput) toSQL() string {
 			skipWithClause:   true,
 			reverseDirection: true,
 			offsetedStart:    input.Start,
+			statistics:       statistics,
 		}))
 	}
 	return strings.Join(parts, "\nUNION ALL\n")
 }
 
 func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
-	ctx := c.t.Context(gc.Request.Context())
 	input := graphLineHandlerInput{graphCommonHandlerInput: graphCommonHandlerInput{schema: c.d.Schema}}
 	if err := gc.ShouldBindJSON(&input); err != nil {
 		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
@@ -232,29 +363,130 @@ func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
 		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
 		return
 	}
+	if err := c.applyBaseFilter(gc, &input.Filter, input.IgnoreBaseFilter); err != nil {
+		gc.JSON(http.StatusForbidden, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	input.excludeZeroVolume()
+	if err := input.validateFormats(); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
 	if input.Limit > c.config.DimensionsLimit {
 		gc.JSON(http.StatusBadRequest,
 			gin.H{"message": fmt.Sprintf("Limit is set beyond maximum value (%d)",
 				c.config.DimensionsLimit)})
 		return
 	}
+	if input.Coverage > 0 {
+		input.Limit = c.config.DimensionsLimit
+	}
+	input.resolution = c.normalizeTimeRange(&input.Start, &input.End, input.Points)
+
+	output, err := c.graphLine(gc, input)
+	if err != nil && clickhouseUnavailable(err) {
+		var fallbackErr error
+		output, fallbackErr = c.graphLineFallback(gc, input)
+		if fallbackErr == nil {
+			c.metrics.fallbackQueries.Inc()
+			err = nil
+		} else if !errors.Is(fallbackErr, errFallbackUnsupported) {
+			c.r.Err(fallbackErr).Msg("unable to use throughput counters from inlets")
+		}
+	}
+	if err != nil {
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+		return
+	}
+	gc.JSON(http.StatusOK, output)
+}
 
+// graphLine executes the queries for the provided validated input and
+// builds the output for the /graph/line endpoint.
+func (c *Component) graphLine(gc *gin.Context, input graphLineHandlerInput) (graphLineHandlerOutput, error) {
 	sqlQuery := input.toSQL()
 	sqlQuery = c.finalizeQuery(sqlQuery)
 	gc.Header("X-SQL-Query", strings.ReplaceAll(sqlQuery, "\n", "  "))
 
-	results := []struct {
-		Axis       uint8     `ch:"axis"`
-		Time       time.Time `ch:"time"`
-		Xps        float64   `ch:"xps"`
-		Dimensions []string  `ch:"dimensions"`
-	}{}
-	if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery); err != nil {
+	results := []graphLineResult{}
+	var cacheStatus queryCacheStatus
+	if err := cachedSelect(c, gc, input.graphCommonHandlerInput, &cacheStatus, &results, sqlQuery); err != nil {
 		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
-		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
-		return
+		return graphLineHandlerOutput{}, err
+	}
+
+	output := buildGraphLineOutput(input, results)
+
+	// Replace statistics with the ones computed at full resolution
+	if input.Aggregate == "full-resolution" {
+		sqlQuery := c.finalizeQuery(input.toSQLStatistics())
+		gc.Header("X-SQL-Query-Statistics", strings.ReplaceAll(sqlQuery, "\n", "  "))
+		statistics := []struct {
+			Axis                 uint8    `ch:"axis"`
+			Dimensions           []string `ch:"dimensions"`
+			Min                  float64  `ch:"min"`
+			Max                  float64  `ch:"max"`
+			Average              float64  `ch:"average"`
+			NinetyFivePercentile float64  `ch:"p95"`
+		}{}
+		if err := cachedSelect(c, gc, input.graphCommonHandlerInput, &cacheStatus, &statistics, sqlQuery); err != nil {
+			c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+			return graphLineHandlerOutput{}, err
+		}
+		rowIndexes := map[string]int{}
+		for i := range output.Rows {
+			rowIndexes[fmt.Sprintf("%d-%s", output.Axis[i], output.Rows[i])] = i
+		}
+		for _, result := range statistics {
+			i, ok := rowIndexes[fmt.Sprintf("%d-%s", result.Axis, result.Dimensions)]
+			if !ok {
+				continue
+			}
+			output.Min[i] = int(result.Min)
+			output.Max[i] = int(result.Max)
+			output.Average[i] = int(result.Average)
+			output.NinetyFivePercentile[i] = int(result.NinetyFivePercentile)
+		}
+	}
+
+	// Retrieve the number of distinct tuples in "Other"
+	if input.OtherDetails && len(input.Dimensions) > 0 {
+		sqlQuery := c.finalizeQuery(input.toSQLOtherTuples())
+		gc.Header("X-SQL-Query-Other-Tuples", strings.ReplaceAll(sqlQuery, "\n", "  "))
+		tuples := []struct {
+			Time   time.Time `ch:"time"`
+			Tuples uint64    `ch:"tuples"`
+		}{}
+		if err := cachedSelect(c, gc, input.graphCommonHandlerInput, &cacheStatus, &tuples, sqlQuery); err != nil {
+			c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
+			return graphLineHandlerOutput{}, err
+		}
+		tuplesByTime := make(map[time.Time]int, len(tuples))
+		for _, result := range tuples {
+			tuplesByTime[result.Time] = int(result.Tuples)
+		}
+		output.OtherTuples = make([]int, len(output.Time))
+		for i, t := range output.Time {
+			output.OtherTuples[i] = tuplesByTime[t]
+		}
 	}
 
+	output.FormattedRows = c.formatRows(gc, input.graphCommonHandlerInput, output.Rows)
+
+	return output, nil
+}
+
+// graphLineResult is a row returned by the query for the /graph/line endpoint.
+type graphLineResult struct {
+	Axis       uint8     `ch:"axis"`
+	Time       time.Time `ch:"time"`
+	Xps        float64   `ch:"xps"`
+	Dimensions []string  `ch:"dimensions"`
+}
+
+// buildGraphLineOutput builds the output for the /graph/line endpoint from
+// the rows sorted by axis and time.
+func buildGraphLineOutput(input graphLineHandlerInput, results []graphLineResult) graphLineHandlerOutput {
 	// When filling 0 value, we may get an empty dimensions.
 	// From ClickHouse 22.4, it is possible to do interpolation database-side
 	// (INTERPOLATE (['Other', 'Other'] AS Dimensions))
@@ -333,10 +565,15 @@ func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
 		sort.Slice(sortedRowKeys[axis], func(i, j int) bool {
 			iKey := sortedRowKeys[axis][i]
 			jKey := sortedRowKeys[axis][j]
-			if rows[axis][iKey][0] == "Other" {
+			iOther, jOther := isOtherRow(rows[axis][iKey]), isOtherRow(rows[axis][jKey])
+			if iOther && jOther {
+				// "Other (ranked)" before "Other"
+				return rows[axis][iKey][0] == otherRanked && rows[axis][jKey][0] == "Other"
+			}
+			if iOther {
 				return false
 			}
-			if rows[axis][jKey][0] == "Other" {
+			if jOther {
 				return true
 			}
 			return sums[axis][iKey] > sums[axis][jKey]
@@ -408,6 +645,13 @@ func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
 		}
 	}
 
+	if input.Coverage > 0 {
+		for i := range output.Rows {
+			if output.Axis[i] == 1 && len(output.Rows[i]) > 0 && !isOtherRow(output.Rows[i]) {
+				output.CoverageRows++
+			}
+		}
+	}
 	for _, axis := range output.Axis {
 		switch axis {
 		case 1:
@@ -420,5 +664,5 @@ func (c *Component) graphLineHandlerFunc(gc *gin.Context) {
 			output.AxisNames[axis] = fmt.Sprintf("Previous %s", name)
 		}
 	}
-	gc.JSON(http.StatusOK, output)
+	return output
 }
@@ -511,6 +511,60 @@ SELECT
 FROM source
 WHERE {{ .Timefilter }}
 GROUP BY time, dimensions
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }}
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second
+ STEP {{ .Interval }}
+ INTERPOLATE (dimensions AS ['Other', 'Other']))
+{{ end }}`,
+		}, {
+			Description: "no filters, reverse, other details",
+			Input: graphLineHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
+					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+					Limit: 20,
+					Dimensions: []query.Column{
+						query.NewColumn("ExporterName"),
+						query.NewColumn("InIfProvider"),
+					},
+					Filter: query.Filter{},
+					Units:  "l3bps",
+				},
+				Points:        100,
+				Bidirectional: true,
+				OtherDetails:  true,
+			},
+			Expected: `
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+WITH
+ source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
+ rows AS (SELECT ExporterName, InIfProvider FROM source WHERE {{ .Timefilter }} GROUP BY ExporterName, InIfProvider ORDER BY SUM(Bytes) DESC LIMIT 20),
+ ranked AS (SELECT ExporterName, InIfProvider FROM source WHERE {{ .Timefilter }} GROUP BY ExporterName, InIfProvider)
+SELECT 1 AS axis, * FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ if((ExporterName, InIfProvider) IN rows, [ExporterName, InIfProvider], if((ExporterName, InIfProvider) IN ranked, ['Other (ranked)', 'Other (ranked)'], ['Other', 'Other'])) AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }}
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second
+ STEP {{ .Interval }}
+ INTERPOLATE (dimensions AS ['Other', 'Other']))
+{{ end }}
+UNION ALL
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+SELECT 2 AS axis, * FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ if((ExporterName, OutIfProvider) IN rows, [ExporterName, OutIfProvider], if((ExporterName, OutIfProvider) IN ranked, ['Other (ranked)', 'Other (ranked)'], ['Other', 'Other'])) AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions
 ORDER BY time WITH FILL
  FROM {{ .TimefilterStart }}
  TO {{ .TimefilterEnd }} + INTERVAL 1 second
@@ -590,17 +644,296 @@ ORDER BY time WITH FILL
 	}
 }
 
-func TestGraphLineHandler(t *testing.T) {
+func TestGraphQueryStatisticsSQL(t *testing.T) {
+	cases := []struct {
+		Description string
+		Input       graphLineHandlerInput
+		Expected    string
+	}{
+		{
+			Description: "no dimensions, no filters, bps",
+			Input: graphLineHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
+					Start:      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+					End:        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+					Dimensions: []query.Column{},
+					Filter:     query.Filter{},
+					Units:      "l3bps",
+				},
+				Points:    100,
+				Aggregate: "full-resolution",
+			},
+			Expected: `
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","full-resolution":true,"points":100,"units":"l3bps"}@@ }}
+WITH
+ source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1)
+SELECT
+ 1 AS axis,
+ dimensions,
+ minIf(xps, xps > 0) AS min,
+ max(xps) AS max,
+ sum(xps)/{{ .Slots }} AS average,
+ arrayReduce('quantile(0.95)', arrayResize(groupArray(xps), {{ .Slots }}, toFloat64(0))) AS p95
+FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ emptyArrayString() AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions)
+GROUP BY dimensions
+{{ end }}`,
+		}, {
+			Description: "no filters, bidirectional, previous period",
+			Input: graphLineHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
+					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+					Limit: 20,
+					Dimensions: []query.Column{
+						query.NewColumn("ExporterName"),
+						query.NewColumn("InIfProvider"),
+					},
+					Filter: query.Filter{},
+					Units:  "l3bps",
+				},
+				Points:         100,
+				Bidirectional:  true,
+				PreviousPeriod: true,
+				Aggregate:      "full-resolution",
+			},
+			Expected: `
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","full-resolution":true,"points":100,"units":"l3bps"}@@ }}
+WITH
+ source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
+ rows AS (SELECT ExporterName, InIfProvider FROM source WHERE {{ .Timefilter }} GROUP BY ExporterName, InIfProvider ORDER BY SUM(Bytes) DESC LIMIT 20)
+SELECT
+ 1 AS axis,
+ dimensions,
+ minIf(xps, xps > 0) AS min,
+ max(xps) AS max,
+ sum(xps)/{{ .Slots }} AS average,
+ arrayReduce('quantile(0.95)', arrayResize(groupArray(xps), {{ .Slots }}, toFloat64(0))) AS p95
+FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ if((ExporterName, InIfProvider) IN rows, [ExporterName, InIfProvider], ['Other', 'Other']) AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions)
+GROUP BY dimensions
+{{ end }}
+UNION ALL
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","full-resolution":true,"points":100,"units":"l3bps"}@@ }}
+SELECT
+ 2 AS axis,
+ dimensions,
+ minIf(xps, xps > 0) AS min,
+ max(xps) AS max,
+ sum(xps)/{{ .Slots }} AS average,
+ arrayReduce('quantile(0.95)', arrayResize(groupArray(xps), {{ .Slots }}, toFloat64(0))) AS p95
+FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ if((ExporterName, OutIfProvider) IN rows, [ExporterName, OutIfProvider], ['Other', 'Other']) AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions)
+GROUP BY dimensions
+{{ end }}
+UNION ALL
+{{ with context @@{"start":"2022-04-09T15:45:10Z","end":"2022-04-10T15:45:10Z","full-resolution":true,"points":100,"units":"l3bps"}@@ }}
+SELECT
+ 3 AS axis,
+ dimensions,
+ minIf(xps, xps > 0) AS min,
+ max(xps) AS max,
+ sum(xps)/{{ .Slots }} AS average,
+ arrayReduce('quantile(0.95)', arrayResize(groupArray(xps), {{ .Slots }}, toFloat64(0))) AS p95
+FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ emptyArrayString() AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions)
+GROUP BY dimensions
+{{ end }}
+UNION ALL
+{{ with context @@{"start":"2022-04-09T15:45:10Z","end":"2022-04-10T15:45:10Z","full-resolution":true,"points":100,"units":"l3bps"}@@ }}
+SELECT
+ 4 AS axis,
+ dimensions,
+ minIf(xps, xps > 0) AS min,
+ max(xps) AS max,
+ sum(xps)/{{ .Slots }} AS average,
+ arrayReduce('quantile(0.95)', arrayResize(groupArray(xps), {{ .Slots }}, toFloat64(0))) AS p95
+FROM (
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ {{ .Units }}/{{ .Interval }} AS xps,
+ emptyArrayString() AS dimensions
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY time, dimensions)
+GROUP BY dimensions
+{{ end }}`,
+		},
+	}
+	for _, tc := range cases {
+		tc.Input.schema = schema.NewMock(t)
+		if err := query.Columns(tc.Input.Dimensions).Validate(tc.Input.schema); err != nil {
+			t.Fatalf("Validate() error:\n%+v", err)
+		}
+		if err := tc.Input.Filter.Validate(tc.Input.schema); err != nil {
+			t.Fatalf("Validate() error:\n%+v", err)
+		}
+		tc.Expected = strings.ReplaceAll(tc.Expected, "@@", "`")
+		t.Run(tc.Description, func(t *testing.T) {
+			got := tc.Input.toSQLStatistics()
+			if diff := helpers.Diff(strings.Split(strings.TrimSpace(got), "\n"),
+				strings.Split(strings.TrimSpace(tc.Expected), "\n")); diff != "" {
+				t.Errorf("toSQLStatistics (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestGraphQueryOtherTuplesSQL(t *testing.T) {
+	input := graphLineHandlerInput{
+		graphCommonHandlerInput: graphCommonHandlerInput{
+			schema: schema.NewMock(t),
+			Start:  time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+			End:    time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+			Limit:  20,
+			Dimensions: []query.Column{
+				query.NewColumn("ExporterName"),
+				query.NewColumn("InIfProvider"),
+			},
+			Filter: query.NewFilter("DstCountry = 'FR'"),
+			Units:  "l3bps",
+		},
+		Points:       100,
+		OtherDetails: true,
+	}
+	if err := query.Columns(input.Dimensions).Validate(input.schema); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	if err := input.Filter.Validate(input.schema); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	expected := strings.ReplaceAll(`
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":100,"units":"l3bps"}@@ }}
+WITH
+ source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
+ rows AS (SELECT ExporterName, InIfProvider FROM source WHERE {{ .Timefilter }} AND (DstCountry = 'FR') GROUP BY ExporterName, InIfProvider ORDER BY SUM(Bytes) DESC LIMIT 20)
+SELECT
+ {{ call .ToStartOfInterval "TimeReceived" }} AS time,
+ uniq(ExporterName, InIfProvider) AS tuples
+FROM source
+WHERE {{ .Timefilter }} AND (DstCountry = 'FR') AND (ExporterName, InIfProvider) NOT IN rows
+GROUP BY time
+ORDER BY time WITH FILL
+ FROM {{ .TimefilterStart }}
+ TO {{ .TimefilterEnd }} + INTERVAL 1 second
+ STEP {{ .Interval }}
+{{ end }}`, "@@", "`")
+	got := input.toSQLOtherTuples()
+	if diff := helpers.Diff(strings.Split(strings.TrimSpace(got), "\n"),
+		strings.Split(strings.TrimSpace(expected), "\n")); diff != "" {
+		t.Errorf("toSQLOtherTuples (-got, +want):\n%s", diff)
+	}
+}
+
+func TestGraphLineHandlerOtherDetails(t *testing.T) {
 	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
 	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
 
-	// Single direction
-	expectedSQL := []struct {
-		Axis       uint8     `ch:"axis"`
-		Time       time.Time `ch:"time"`
-		Xps        float64   `ch:"xps"`
-		Dimensions []string  `ch:"dimensions"`
+	expectedSQL := []graphLineResult{
+		{1, base, 1000, []string{"router1", "provider1"}},
+		{1, base, 300, []string{"Other (ranked)", "Other (ranked)"}},
+		{1, base.Add(time.Minute), 2000, []string{"router1", "provider1"}},
+		{1, base.Add(time.Minute), 100, []string{"Other (ranked)", "Other (ranked)"}},
+		{2, base, 500, []string{"router1", "provider1"}},
+		{2, base, 200, []string{"Other", "Other"}},
+		{2, base, 100, []string{"Other (ranked)", "Other (ranked)"}},
+		{2, base.Add(time.Minute), 400, []string{"router1", "provider1"}},
+		{2, base.Add(time.Minute), 300, []string{"Other", "Other"}},
+		{2, base.Add(time.Minute), 100, []string{"Other (ranked)", "Other (ranked)"}},
+	}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedSQL).
+		Return(nil)
+	expectedTuplesSQL := []struct {
+		Time   time.Time `ch:"time"`
+		Tuples uint64    `ch:"tuples"`
 	}{
+		{base, 12},
+		{base.Add(time.Minute), 4},
+	}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedTuplesSQL).
+		Return(nil)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":         time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":           time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"points":        100,
+				"limit":         1,
+				"dimensions":    []string{"ExporterName", "InIfProvider"},
+				"units":         "l3bps",
+				"bidirectional": true,
+				"other-details": true,
+			},
+			JSONOutput: gin.H{
+				"rows": [][]string{
+					{"router1", "provider1"},
+					{"Other (ranked)", "Other (ranked)"},
+					{"router1", "provider1"},
+					{"Other (ranked)", "Other (ranked)"},
+					{"Other", "Other"},
+				},
+				"t": []string{
+					"2009-11-10T23:00:00Z",
+					"2009-11-10T23:01:00Z",
+				},
+				"points": [][]int{
+					{1000, 2000},
+					{300, 100},
+					{500, 400},
+					{100, 100},
+					{200, 300},
+				},
+				"min":          []int{1000, 100, 400, 100, 200},
+				"max":          []int{2000, 300, 500, 100, 300},
+				"average":      []int{1500, 200, 450, 100, 250},
+				"95th":         []int{1500, 200, 450, 100, 250},
+				"axis":         []int{1, 1, 2, 2, 2},
+				"axis-names":   map[int]string{1: "Direct", 2: "Reverse"},
+				"other-tuples": []int{12, 4},
+			},
+		},
+	})
+}
+
+func TestGraphLineHandler(t *testing.T) {
+	// Some cases issue the same queries with different results
+	config := DefaultConfiguration()
+	config.CacheSize = 0
+	_, h, mockConn, _ := NewMock(t, config)
+	base := time.Date(2009, time.November, 10, 23, 0, 0, 0, time.UTC)
+
+	// Single direction
+	expectedSQL := []graphLineResult{
 		{1, base, 1000, []string{"router1", "provider1"}},
 		{1, base, 2000, []string{"router1", "provider2"}},
 		{1, base, 1200, []string{"router2", "provider2"}},
@@ -621,12 +954,7 @@ func TestGraphLineHandler(t *testing.T) {
 		Return(nil)
 
 	// Bidirectional
-	expectedSQL = []struct {
-		Axis       uint8     `ch:"axis"`
-		Time       time.Time `ch:"time"`
-		Xps        float64   `ch:"xps"`
-		Dimensions []string  `ch:"dimensions"`
-	}{
+	expectedSQL = []graphLineResult{
 		{1, base, 1000, []string{"router1", "provider1"}},
 		{1, base, 2000, []string{"router1", "provider2"}},
 		{1, base, 1200, []string{"router2", "provider2"}},
@@ -668,12 +996,7 @@ func TestGraphLineHandler(t *testing.T) {
 		Return(nil)
 
 	// Previous period
-	expectedSQL = []struct {
-		Axis       uint8     `ch:"axis"`
-		Time       time.Time `ch:"time"`
-		Xps        float64   `ch:"xps"`
-		Dimensions []string  `ch:"dimensions"`
-	}{
+	expectedSQL = []graphLineResult{
 		{1, base, 1000, []string{"router1", "provider1"}},
 		{1, base, 2000, []string{"router1", "provider2"}},
 		{1, base, 1200, []string{"router2", "provider2"}},
@@ -697,6 +1020,44 @@ func TestGraphLineHandler(t *testing.T) {
 		SetArg(1, expectedSQL).
 		Return(nil)
 
+	// Full resolution statistics
+	expectedSQL = []graphLineResult{
+		{1, base, 1000, []string{"router1", "provider1"}},
+		{1, base, 1900, []string{"Other", "Other"}},
+		{1, base.Add(time.Minute), 500, []string{"router1", "provider1"}},
+		{1, base.Add(time.Minute), 100, []string{"Other", "Other"}},
+	}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedSQL).
+		Return(nil)
+	expectedStatisticsSQL := []struct {
+		Axis                 uint8    `ch:"axis"`
+		Dimensions           []string `ch:"dimensions"`
+		Min                  float64  `ch:"min"`
+		Max                  float64  `ch:"max"`
+		Average              float64  `ch:"average"`
+		NinetyFivePercentile float64  `ch:"p95"`
+	}{
+		{1, []string{"router1", "provider1"}, 10, 1500, 700, 1400},
+		{1, []string{"Other", "Other"}, 5, 2500, 1000, 2000},
+	}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedStatisticsSQL).
+		Return(nil)
+
+	// Coverage
+	expectedSQL = []graphLineResult{
+		{1, base, 1000, []string{"router1", "provider1"}},
+		{1, base, 1200, []string{"router2", "provider2"}},
+		{1, base, 100, []string{"Other", "Other"}},
+	}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedSQL).
+		Return(nil)
+
 	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
 		{
 			Description: "single direction",
@@ -976,6 +1337,170 @@ func TestGraphLineHandler(t *testing.T) {
 					3: "Previous day",
 				},
 			},
+		}, {
+			Description: "full resolution",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"points":     100,
+				"limit":      20,
+				"dimensions": []string{"ExporterName", "InIfProvider"},
+				"filter":     "DstCountry = 'FR' AND SrcCountry = 'US'",
+				"units":      "l3bps",
+				"aggregate":  "full-resolution",
+			},
+			JSONOutput: gin.H{
+				"rows": [][]string{
+					{"router1", "provider1"},
+					{"Other", "Other"},
+				},
+				"t": []string{
+					"2009-11-10T23:00:00Z",
+					"2009-11-10T23:01:00Z",
+				},
+				"points": [][]int{
+					{1000, 500},
+					{1900, 100},
+				},
+				"min":     []int{10, 5},
+				"max":     []int{1500, 2500},
+				"average": []int{700, 1000},
+				"95th":    []int{1400, 2000},
+				"axis":    []int{1, 1},
+				"axis-names": map[int]string{
+					1: "Direct",
+				},
+			},
+		}, {
+			Description: "coverage",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"points":     100,
+				"coverage":   0.9,
+				"dimensions": []string{"ExporterName", "InIfProvider"},
+				"units":      "l3bps",
+			},
+			JSONOutput: gin.H{
+				"rows": [][]string{
+					{"router2", "provider2"},
+					{"router1", "provider1"},
+					{"Other", "Other"},
+				},
+				"t":             []string{"2009-11-10T23:00:00Z"},
+				"points":        [][]int{{1200}, {1000}, {100}},
+				"min":           []int{1200, 1000, 100},
+				"max":           []int{1200, 1000, 100},
+				"average":       []int{1200, 1000, 100},
+				"95th":          []int{1200, 1000, 100},
+				"axis":          []int{1, 1, 1},
+				"axis-names":    map[int]string{1: "Direct"},
+				"coverage-rows": 2,
+			},
+		}, {
+			Description: "coverage and limit",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"points":     100,
+				"limit":      10,
+				"coverage":   0.9,
+				"dimensions": []string{"ExporterName", "InIfProvider"},
+				"units":      "l3bps",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{
+				"message": "Key: 'graphLineHandlerInput.graphCommonHandlerInput.Limit' Error:Field validation for 'Limit' failed on the 'excluded_with' tag",
+			},
+		}, {
+			Description: "neither coverage nor limit",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput: gin.H{
+				"start":      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"points":     100,
+				"dimensions": []string{"ExporterName", "InIfProvider"},
+				"units":      "l3bps",
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{
+				"message": "Key: 'graphLineHandlerInput.graphCommonHandlerInput.Limit' Error:Field validation for 'Limit' failed on the 'required_without' tag",
+			},
 		},
 	})
 }
+
+func TestGraphLineHandlerBaseFilter(t *testing.T) {
+	config := DefaultConfiguration()
+	config.BaseFilter = query.NewFilter("ExporterTenant = 'customer1'")
+	_, h, mockConn, _ := NewMock(t, config)
+
+	queries := []string{}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, []graphLineResult{}).
+		Do(func(_, _ interface{}, query string, _ ...interface{}) {
+			queries = append(queries, query)
+		}).
+		Return(nil).
+		Times(2)
+
+	input := func(filter string, ignore bool) gin.H {
+		return gin.H{
+			"start":              time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+			"end":                time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+			"points":             100,
+			"limit":              10,
+			"dimensions":         []string{},
+			"filter":             filter,
+			"units":              "l3bps",
+			"ignore-base-filter": ignore,
+		}
+	}
+	empty := gin.H{
+		"t":          []string{},
+		"rows":       [][]string{},
+		"points":     [][]int{},
+		"min":        []int{},
+		"max":        []int{},
+		"average":    []int{},
+		"95th":       []int{},
+		"axis":       []int{},
+		"axis-names": gin.H{},
+	}
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "with user filter",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput:   input("SrcAS = 12322", false),
+			JSONOutput:  empty,
+		}, {
+			Description: "without user filter",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput:   input("", false),
+			JSONOutput:  empty,
+		}, {
+			Description: "ignore base filter as regular user",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput:   input("SrcAS = 12322", true),
+			StatusCode:  403,
+			JSONOutput:  gin.H{"message": "Only administrators can ignore the base filter"},
+		},
+	})
+
+	expected := []string{
+		"AND ((ExporterTenant = 'customer1') AND (SrcAS = 12322))",
+		"AND (ExporterTenant = 'customer1')\n",
+	}
+	if len(queries) != len(expected) {
+		t.Fatalf("got %d queries, expected %d", len(queries), len(expected))
+	}
+	for idx := range expected {
+		if !strings.Contains(queries[idx], expected[idx]) {
+			t.Errorf("query %d does not contain %q:\n%s", idx, expected[idx], queries[idx])
+		}
+	}
+}
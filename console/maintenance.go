@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/clickhousedb"
+)
+
+// refreshMaintenance fetches the maintenance status from ClickHouse. It is
+// set by the orchestrator, notably during database migrations.
+func (c *Component) refreshMaintenance() error {
+	status, err := c.d.ClickHouseDB.Maintenance(c.t.Context(nil))
+	if err != nil {
+		return err
+	}
+	c.maintenanceLock.Lock()
+	defer c.maintenanceLock.Unlock()
+	if status.Active != c.maintenance.Active {
+		if status.Active {
+			c.r.Warn().Str("reason", status.Reason).Msg("database is in maintenance mode")
+		} else {
+			c.r.Info().Msg("database is not in maintenance mode anymore")
+		}
+	}
+	c.maintenance = status
+	return nil
+}
+
+// maintenanceMessage returns a human-readable message for an active
+// maintenance.
+func maintenanceMessage(status clickhousedb.MaintenanceStatus) string {
+	details := []string{}
+	if status.Reason != "" {
+		details = append(details, status.Reason)
+	}
+	if status.StepsTotal > 0 {
+		details = append(details, fmt.Sprintf("step %d/%d", status.StepsDone+1, status.StepsTotal))
+	}
+	if status.ExpectedEnd != nil {
+		details = append(details,
+			fmt.Sprintf("expected end at %s", status.ExpectedEnd.UTC().Format(time.RFC3339)))
+	}
+	if len(details) == 0 {
+		return "Database maintenance in progress."
+	}
+	return fmt.Sprintf("Database maintenance in progress (%s).", strings.Join(details, ", "))
+}
+
+// maintenanceMiddleware rejects requests querying the database when it is in
+// maintenance mode.
+func (c *Component) maintenanceMiddleware() gin.HandlerFunc {
+	return func(gc *gin.Context) {
+		c.maintenanceLock.RLock()
+		status := c.maintenance
+		c.maintenanceLock.RUnlock()
+		if status.Active {
+			gc.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+				"message":     maintenanceMessage(status),
+				"maintenance": status,
+			})
+			return
+		}
+		gc.Next()
+	}
+}
+
+// maintenanceHandlerFunc returns the maintenance status.
+func (c *Component) maintenanceHandlerFunc(gc *gin.Context) {
+	c.maintenanceLock.RLock()
+	defer c.maintenanceLock.RUnlock()
+	gc.JSON(http.StatusOK, c.maintenance)
+}
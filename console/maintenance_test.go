@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/helpers"
+)
+
+func TestMaintenance(t *testing.T) {
+	c, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+	since := time.Date(2023, time.April, 10, 10, 0, 0, 0, time.UTC)
+	expectedEnd := time.Date(2023, time.April, 10, 12, 0, 0, 0, time.UTC)
+
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, []clickhousedb.MaintenanceStatus{{
+			Active:      true,
+			Reason:      "updating table flows",
+			Since:       since,
+			ExpectedEnd: &expectedEnd,
+			StepsDone:   4,
+			StepsTotal:  14,
+		}}).
+		Return(nil)
+	if err := c.refreshMaintenance(); err != nil {
+		t.Fatalf("refreshMaintenance() error:\n%+v", err)
+	}
+
+	status := gin.H{
+		"active":       true,
+		"reason":       "updating table flows",
+		"since":        "2023-04-10T10:00:00Z",
+		"expected-end": "2023-04-10T12:00:00Z",
+		"steps-done":   4,
+		"steps-total":  14,
+	}
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "maintenance status",
+			URL:         "/api/v0/console/maintenance",
+			JSONOutput:  status,
+		}, {
+			Description: "graph during maintenance",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput:   gin.H{},
+			StatusCode:  503,
+			JSONOutput: gin.H{
+				"message":     "Database maintenance in progress (updating table flows, step 5/14, expected end at 2023-04-10T12:00:00Z).",
+				"maintenance": status,
+			},
+		}, {
+			Description: "widget during maintenance",
+			URL:         "/api/v0/console/widget/flow-rate",
+			StatusCode:  503,
+			JSONOutput: gin.H{
+				"message":     "Database maintenance in progress (updating table flows, step 5/14, expected end at 2023-04-10T12:00:00Z).",
+				"maintenance": status,
+			},
+		}, {
+			Description: "validate filter during maintenance",
+			URL:         "/api/v0/console/filter/validate",
+			JSONInput:   gin.H{"filter": `InIfName = "Gi0/0/0/1"`},
+			JSONOutput: gin.H{
+				"message": "ok",
+				"parsed":  `InIfName = 'Gi0/0/0/1'`,
+			},
+		},
+	})
+
+	// End of maintenance
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, []clickhousedb.MaintenanceStatus{{Since: expectedEnd}}).
+		Return(nil)
+	if err := c.refreshMaintenance(); err != nil {
+		t.Fatalf("refreshMaintenance() error:\n%+v", err)
+	}
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "maintenance status",
+			URL:         "/api/v0/console/maintenance",
+			JSONOutput: gin.H{
+				"active": false,
+				"since":  "2023-04-10T12:00:00Z",
+			},
+		}, {
+			Description: "graph after maintenance",
+			URL:         "/api/v0/console/graph/line",
+			JSONInput:   gin.H{},
+			StatusCode:  400,
+			JSONOutput: gin.H{
+				"message": "Key: 'graphLineHandlerInput.graphCommonHandlerInput.Start' Error:Field validation for 'Start' failed on the 'required' tag\nKey: 'graphLineHandlerInput.graphCommonHandlerInput.End' Error:Field validation for 'End' failed on the 'required' tag\nKey: 'graphLineHandlerInput.graphCommonHandlerInput.Limit' Error:Field validation for 'Limit' failed on the 'required_without' tag\nKey: 'graphLineHandlerInput.graphCommonHandlerInput.Units' Error:Field validation for 'Units' failed on the 'required' tag\nKey: 'graphLineHandlerInput.Points' Error:Field validation for 'Points' failed on the 'required' tag",
+			},
+		},
+	})
+}
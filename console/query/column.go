@@ -101,7 +101,7 @@ func (qc Column) ToSQLSelect(sch *schema.Component) string {
 	key := qc.Key()
 	switch key {
 	// Special cases
-	case schema.ColumnSrcAS, schema.ColumnDstAS, schema.ColumnDst1stAS, schema.ColumnDst2ndAS, schema.ColumnDst3rdAS:
+	case schema.ColumnSrcAS, schema.ColumnDstAS, schema.ColumnSrc1stAS, schema.ColumnDst1stAS, schema.ColumnDst2ndAS, schema.ColumnDst3rdAS:
 		strValue = fmt.Sprintf(`concat(toString(%s), ': ', dictGetOrDefault('asns', 'name', %s, '???'))`,
 			qc, qc)
 	case schema.ColumnEType:
@@ -115,12 +115,26 @@ func (qc Column) ToSQLSelect(sch *schema.Component) string {
 		strValue = `arrayStringConcat(arrayConcat(arrayMap(c -> concat(toString(bitShiftRight(c, 16)), ':', toString(bitAnd(c, 0xffff))), DstCommunities), arrayMap(c -> concat(toString(bitAnd(bitShiftRight(c, 64), 0xffffffff)), ':', toString(bitAnd(bitShiftRight(c, 32), 0xffffffff)), ':', toString(bitAnd(c, 0xffffffff))), DstLargeCommunities)), ' ')`
 	case schema.ColumnSrcMAC, schema.ColumnDstMAC:
 		strValue = fmt.Sprintf("MACNumToString(%s)", qc)
+	case schema.ColumnTCPFlags:
+		strValue = `arrayStringConcat(arrayFilter((f, i) -> bitTest(TCPFlags, i), ['FIN', 'SYN', 'RST', 'PSH', 'ACK', 'URG', 'ECE', 'CWR', 'NS'], range(9)), '|')`
+	case schema.ColumnIPDSCP:
+		strValue = `transform(IPDSCP, [0, 1, 8, 10, 12, 14, 16, 18, 20, 22, 24, 26, 28, 30, 32, 34, 36, 38, 40, 44, 46, 48, 56], ['CS0', 'LE', 'CS1', 'AF11', 'AF12', 'AF13', 'CS2', 'AF21', 'AF22', 'AF23', 'CS3', 'AF31', 'AF32', 'AF33', 'CS4', 'AF41', 'AF42', 'AF43', 'CS5', 'VOICE-ADMIT', 'EF', 'CS6', 'CS7'], toString(IPDSCP))`
+	case schema.ColumnIPECN:
+		strValue = `['Not-ECT', 'ECT(1)', 'ECT(0)', 'CE'][IPECN + 1]`
+	case schema.ColumnDstPort:
+		// For ICMP, display type and code instead of the port
+		strValue = `toString(DstPort)`
+		if col, ok := sch.LookupColumnByKey(schema.ColumnICMPType); ok && !col.Disabled {
+			strValue = `if(Proto IN (1, 58), concat(toString(ICMPType), '/', toString(ICMPCode)), toString(DstPort))`
+		}
 
 	// Generic cases
 	default:
 		strValue = qc.String()
 		if col, ok := sch.LookupColumnByKey(key); ok {
-			if strings.HasPrefix(col.ClickHouseType, "UInt") {
+			if strings.HasPrefix(col.ClickHouseType, "UInt") ||
+				strings.HasPrefix(col.ClickHouseType, "Float") ||
+				col.ClickHouseType == "Bool" {
 				strValue = fmt.Sprintf(`toString(%s)`, qc)
 			} else if col.ClickHouseType == "IPv6" || col.ClickHouseType == "LowCardinality(IPv6)" {
 				strValue = fmt.Sprintf("replaceRegexpOne(IPv6NumToString(%s), '^::ffff:', '')", qc)
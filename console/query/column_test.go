@@ -92,6 +92,9 @@ func TestQueryColumnSQLSelect(t *testing.T) {
 		}, {
 			Input:    schema.ColumnDstMAC,
 			Expected: `MACNumToString(DstMAC)`,
+		}, {
+			Input:    schema.ColumnDstPort,
+			Expected: `toString(DstPort)`,
 		},
 	}
 	for _, tc := range cases {
@@ -108,6 +111,60 @@ func TestQueryColumnSQLSelect(t *testing.T) {
 	}
 }
 
+func TestQueryColumnSQLSelectICMP(t *testing.T) {
+	sch := schema.NewMock(t).EnableAllColumns()
+	column := query.NewColumn("DstPort")
+	if err := column.Validate(sch); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	got := column.ToSQLSelect(sch)
+	expected := `if(Proto IN (1, 58), concat(toString(ICMPType), '/', toString(ICMPCode)), toString(DstPort))`
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Errorf("ToSQLSelect() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestQueryColumnSQLSelectTCPFlags(t *testing.T) {
+	sch := schema.NewMock(t).EnableAllColumns()
+	column := query.NewColumn("TCPFlags")
+	if err := column.Validate(sch); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	got := column.ToSQLSelect(sch)
+	expected := `arrayStringConcat(arrayFilter((f, i) -> bitTest(TCPFlags, i), ['FIN', 'SYN', 'RST', 'PSH', 'ACK', 'URG', 'ECE', 'CWR', 'NS'], range(9)), '|')`
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Errorf("ToSQLSelect() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestQueryColumnSQLSelectQoS(t *testing.T) {
+	sch := schema.NewMock(t).EnableAllColumns()
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{
+			Input:    "IPDSCP",
+			Expected: `transform(IPDSCP, [0, 1, 8, 10, 12, 14, 16, 18, 20, 22, 24, 26, 28, 30, 32, 34, 36, 38, 40, 44, 46, 48, 56], ['CS0', 'LE', 'CS1', 'AF11', 'AF12', 'AF13', 'CS2', 'AF21', 'AF22', 'AF23', 'CS3', 'AF31', 'AF32', 'AF33', 'CS4', 'AF41', 'AF42', 'AF43', 'CS5', 'VOICE-ADMIT', 'EF', 'CS6', 'CS7'], toString(IPDSCP))`,
+		}, {
+			Input:    "IPECN",
+			Expected: `['Not-ECT', 'ECT(1)', 'ECT(0)', 'CE'][IPECN + 1]`,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Input, func(t *testing.T) {
+			column := query.NewColumn(tc.Input)
+			if err := column.Validate(sch); err != nil {
+				t.Fatalf("Validate() error:\n%+v", err)
+			}
+			got := column.ToSQLSelect(sch)
+			if diff := helpers.Diff(got, tc.Expected); diff != "" {
+				t.Errorf("ToSQLSelect() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestReverseDirection(t *testing.T) {
 	columns := query.Columns{
 		query.NewColumn("SrcAS"),
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"strings"
 
+	"golang.org/x/exp/slices"
+
 	"akvorado/common/schema"
 	"akvorado/console/filter"
 )
@@ -17,6 +19,9 @@ type Filter struct {
 	filter            string
 	reverseFilter     string
 	mainTableRequired bool
+	columns           []string
+	tree              filter.Node
+	reverseTree       filter.Node
 }
 
 // NewFilter creates a new filter. It should be validated with Validate() before use.
@@ -59,6 +64,8 @@ func (qf *Filter) Validate(sch *schema.Component) error {
 	if err != nil {
 		return fmt.Errorf("cannot parse filter: %s", filter.HumanError(err))
 	}
+	columns := meta.Columns
+	tree := meta.Tree
 	meta = &filter.Meta{Schema: sch, ReverseDirection: true}
 	reverse, err := filter.Parse("", input, filter.GlobalStore("meta", meta))
 	if err != nil {
@@ -67,6 +74,9 @@ func (qf *Filter) Validate(sch *schema.Component) error {
 	qf.filter = direct.(string)
 	qf.reverseFilter = reverse.(string)
 	qf.mainTableRequired = meta.MainTableRequired
+	qf.columns = columns
+	qf.tree = tree
+	qf.reverseTree = meta.Tree
 	qf.validated = true
 	return nil
 }
@@ -89,7 +99,64 @@ func (qf Filter) Direct() string {
 	return qf.filter
 }
 
+// Columns returns the columns referenced by the filter.
+func (qf Filter) Columns() []string {
+	qf.check()
+	return qf.columns
+}
+
+// Tree provides the syntax tree of the filter. It is nil for an empty filter.
+func (qf Filter) Tree() filter.Node {
+	qf.check()
+	return qf.tree
+}
+
+// AddDefaultCondition adds a condition to the filter, unless the filter
+// already references one of the columns of the condition. The condition
+// should be validated.
+func (qf *Filter) AddDefaultCondition(condition Filter) {
+	qf.check()
+	condition.check()
+	for _, column := range condition.columns {
+		if slices.Contains(qf.columns, column) {
+			return
+		}
+	}
+	qf.tree = filter.NewAnd(condition.tree, qf.tree)
+	qf.reverseTree = filter.NewAnd(condition.reverseTree, qf.reverseTree)
+	qf.mainTableRequired = qf.mainTableRequired || condition.mainTableRequired
+	if qf.filter == "" {
+		qf.filter = condition.filter
+		qf.reverseFilter = condition.reverseFilter
+		return
+	}
+	qf.filter = fmt.Sprintf("%s AND (%s)", condition.filter, qf.filter)
+	qf.reverseFilter = fmt.Sprintf("%s AND (%s)", condition.reverseFilter, qf.reverseFilter)
+}
+
+// And restricts the filter to flows also matching the provided filter. Both
+// filters should be validated.
+func (qf *Filter) And(other Filter) {
+	qf.check()
+	other.check()
+	if other.filter == "" {
+		return
+	}
+	if qf.filter == "" {
+		qf.filter = other.filter
+		qf.reverseFilter = other.reverseFilter
+	} else {
+		qf.filter = fmt.Sprintf("(%s) AND (%s)", other.filter, qf.filter)
+		qf.reverseFilter = fmt.Sprintf("(%s) AND (%s)", other.reverseFilter, qf.reverseFilter)
+	}
+	qf.mainTableRequired = qf.mainTableRequired || other.mainTableRequired
+	qf.columns = append(append([]string{}, qf.columns...), other.columns...)
+	qf.tree = filter.NewAnd(other.tree, qf.tree)
+	qf.reverseTree = filter.NewAnd(other.reverseTree, qf.reverseTree)
+}
+
 // Swap swap direct and reverse filter.
 func (qf *Filter) Swap() {
 	qf.filter, qf.reverseFilter = qf.reverseFilter, qf.filter
+	qf.tree, qf.reverseTree = qf.reverseTree, qf.tree
 }
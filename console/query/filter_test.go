@@ -59,3 +59,86 @@ func TestFilterSwap(t *testing.T) {
 		t.Fatalf("Swap() (-got, +want):\n%s", diff)
 	}
 }
+
+func TestFilterAddDefaultCondition(t *testing.T) {
+	sch, err := schema.New(schema.Configuration{
+		Enabled: []schema.ColumnKey{schema.ColumnZeroVolume},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	cases := []struct {
+		Input           string
+		ExpectedDirect  string
+		ExpectedReverse string
+	}{
+		{"", "ZeroVolume = false", "ZeroVolume = false"},
+		{"SrcAS = 12322", "ZeroVolume = false AND (SrcAS = 12322)", "ZeroVolume = false AND (DstAS = 12322)"},
+		{"ZeroVolume = true", "ZeroVolume = true", "ZeroVolume = true"},
+		{"SrcAS = 12322 OR ZeroVolume = false", "SrcAS = 12322 OR ZeroVolume = false", "DstAS = 12322 OR ZeroVolume = false"},
+	}
+	condition := query.NewFilter("ZeroVolume = false")
+	if err := condition.Validate(sch); err != nil {
+		t.Fatalf("Validate() error:\n%+v", err)
+	}
+	for _, tc := range cases {
+		filter := query.NewFilter(tc.Input)
+		if err := filter.Validate(sch); err != nil {
+			t.Fatalf("Validate(%q) error:\n%+v", tc.Input, err)
+		}
+		filter.AddDefaultCondition(condition)
+		if diff := helpers.Diff(filter.Direct(), tc.ExpectedDirect); diff != "" {
+			t.Errorf("AddDefaultCondition(%q) direct (-got, +want):\n%s", tc.Input, diff)
+		}
+		if diff := helpers.Diff(filter.Reverse(), tc.ExpectedReverse); diff != "" {
+			t.Errorf("AddDefaultCondition(%q) reverse (-got, +want):\n%s", tc.Input, diff)
+		}
+	}
+}
+
+func TestFilterAnd(t *testing.T) {
+	sch := schema.NewMock(t)
+	cases := []struct {
+		Base                      string
+		Input                     string
+		ExpectedDirect            string
+		ExpectedReverse           string
+		ExpectedMainTableRequired bool
+	}{
+		{"", "", "", "", false},
+		{"", "SrcAS = 12322", "SrcAS = 12322", "DstAS = 12322", false},
+		{"InIfBoundary = external", "", "InIfBoundary = 'external'", "OutIfBoundary = 'external'", false},
+		{
+			"InIfBoundary = external", "SrcAS = 12322 OR SrcAS = 12323",
+			"(InIfBoundary = 'external') AND (SrcAS = 12322 OR SrcAS = 12323)",
+			"(OutIfBoundary = 'external') AND (DstAS = 12322 OR DstAS = 12323)",
+			false,
+		}, {
+			"SrcPort = 443", "SrcAS = 12322",
+			"(SrcPort = 443) AND (SrcAS = 12322)",
+			"(DstPort = 443) AND (DstAS = 12322)",
+			true,
+		},
+	}
+	for _, tc := range cases {
+		base := query.NewFilter(tc.Base)
+		if err := base.Validate(sch); err != nil {
+			t.Fatalf("Validate(%q) error:\n%+v", tc.Base, err)
+		}
+		filter := query.NewFilter(tc.Input)
+		if err := filter.Validate(sch); err != nil {
+			t.Fatalf("Validate(%q) error:\n%+v", tc.Input, err)
+		}
+		filter.And(base)
+		if diff := helpers.Diff(filter.Direct(), tc.ExpectedDirect); diff != "" {
+			t.Errorf("And(%q, %q) direct (-got, +want):\n%s", tc.Input, tc.Base, diff)
+		}
+		if diff := helpers.Diff(filter.Reverse(), tc.ExpectedReverse); diff != "" {
+			t.Errorf("And(%q, %q) reverse (-got, +want):\n%s", tc.Input, tc.Base, diff)
+		}
+		if filter.MainTableRequired() != tc.ExpectedMainTableRequired {
+			t.Errorf("And(%q, %q) main table required == %v, expected %v",
+				tc.Input, tc.Base, filter.MainTableRequired(), tc.ExpectedMainTableRequired)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package resolver resolves IP addresses to names using reverse DNS. Results
+// are cached and lookups are bounded by a timeout.
+package resolver
+
+import (
+	"context"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"akvorado/common/helpers/cache"
+)
+
+// lateLookupTimeout is the maximum duration of a lookup. Lookups not
+// completed before the timeout of the request continue in the background to
+// populate the cache.
+const lateLookupTimeout = 10 * time.Second
+
+// LookupAddrFunc is a function performing a reverse lookup.
+type LookupAddrFunc func(ctx context.Context, addr string) ([]string, error)
+
+// Resolver resolves IP addresses to names.
+type Resolver struct {
+	timeout    time.Duration
+	ttl        time.Duration
+	lookupAddr LookupAddrFunc
+	cache      *cache.Cache[netip.Addr, entry]
+}
+
+// entry is a cached result. An empty name means the address has no name.
+type entry struct {
+	Name     string
+	Resolved time.Time
+}
+
+// New creates a new resolver. Lookups taking more than the provided timeout
+// are abandoned. Results are kept for the provided TTL. When lookupAddr is
+// nil, the default resolver is used.
+func New(timeout, ttl time.Duration, lookupAddr LookupAddrFunc) *Resolver {
+	if lookupAddr == nil {
+		lookupAddr = net.DefaultResolver.LookupAddr
+	}
+	return &Resolver{
+		timeout:    timeout,
+		ttl:        ttl,
+		lookupAddr: lookupAddr,
+		cache:      cache.New[netip.Addr, entry](),
+	}
+}
+
+// Lookup resolves the provided addresses concurrently. Addresses without a
+// name or whose resolution did not complete before the timeout are absent
+// from the result.
+func (r *Resolver) Lookup(ctx context.Context, now time.Time, addresses []netip.Addr) map[netip.Addr]string {
+	r.cache.DeleteLastAccessedBefore(now.Add(-r.ttl))
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	result := map[netip.Addr]string{}
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	for _, address := range addresses {
+		if cached, ok := r.cache.Get(now, address); ok && now.Sub(cached.Resolved) < r.ttl {
+			if cached.Name != "" {
+				lock.Lock()
+				result[address] = cached.Name
+				lock.Unlock()
+			}
+			continue
+		}
+		wg.Add(1)
+		go func(address netip.Addr) {
+			defer wg.Done()
+			// Not tied to the request to let late lookups complete
+			ctx, cancel := context.WithTimeout(context.Background(), lateLookupTimeout)
+			defer cancel()
+			names, err := r.lookupAddr(ctx, address.String())
+			if err != nil {
+				if dnsErr, ok := err.(*net.DNSError); !ok || !dnsErr.IsNotFound {
+					// Do not cache transient errors
+					return
+				}
+			}
+			var name string
+			if len(names) > 0 {
+				name = strings.TrimSuffix(names[0], ".")
+			}
+			r.cache.Put(now, address, entry{Name: name, Resolved: now})
+			lock.Lock()
+			defer lock.Unlock()
+			if name != "" && result != nil {
+				result[address] = name
+			}
+		}(address)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+
+	// Late lookups are only cached
+	lock.Lock()
+	defer lock.Unlock()
+	final := result
+	result = nil
+	return final
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package resolver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"akvorado/common/helpers"
+)
+
+func TestLookup(t *testing.T) {
+	var lookups int32
+	lookupAddr := func(ctx context.Context, addr string) ([]string, error) {
+		atomic.AddInt32(&lookups, 1)
+		switch addr {
+		case "192.0.2.1":
+			return []string{"host1.example.com."}, nil
+		case "2001:db8::1":
+			return []string{"host2.example.com.", "alias.example.com."}, nil
+		case "192.0.2.2":
+			return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+		case "192.0.2.3":
+			return nil, errors.New("network unreachable")
+		case "192.0.2.4":
+			// Slow resolver
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(time.Second):
+				return []string{"slow.example.com."}, nil
+			}
+		}
+		return nil, errors.New("unexpected address")
+	}
+	r := New(50*time.Millisecond, time.Hour, lookupAddr)
+	now := time.Date(2023, 3, 10, 10, 0, 0, 0, time.UTC)
+	addresses := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("2001:db8::1"),
+		netip.MustParseAddr("192.0.2.2"),
+		netip.MustParseAddr("192.0.2.3"),
+		netip.MustParseAddr("192.0.2.4"),
+	}
+	expected := map[netip.Addr]string{
+		netip.MustParseAddr("192.0.2.1"):   "host1.example.com",
+		netip.MustParseAddr("2001:db8::1"): "host2.example.com",
+	}
+
+	start := time.Now()
+	got := r.Lookup(context.Background(), now, addresses)
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Lookup() took %s, timeout not enforced", elapsed)
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Errorf("Lookup() (-got, +want):\n%s", diff)
+	}
+	if got := atomic.LoadInt32(&lookups); got != 5 {
+		t.Errorf("Lookup() did %d lookups, expected 5", got)
+	}
+
+	// Names and missing names are cached, not errors
+	got = r.Lookup(context.Background(), now.Add(time.Minute), addresses)
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Errorf("Lookup() (-got, +want):\n%s", diff)
+	}
+	if got := atomic.LoadInt32(&lookups); got != 7 {
+		t.Errorf("Lookup() did %d lookups, expected 7", got)
+	}
+
+	// Late lookups are cached once completed
+	time.Sleep(1500 * time.Millisecond)
+	got = r.Lookup(context.Background(), now.Add(time.Minute), addresses[4:])
+	if diff := helpers.Diff(got, map[netip.Addr]string{
+		netip.MustParseAddr("192.0.2.4"): "slow.example.com",
+	}); diff != "" {
+		t.Errorf("Lookup() (-got, +want):\n%s", diff)
+	}
+	if got := atomic.LoadInt32(&lookups); got != 7 {
+		t.Errorf("Lookup() did %d lookups, expected 7", got)
+	}
+
+	// Expiration
+	got = r.Lookup(context.Background(), now.Add(2*time.Hour), addresses[:1])
+	if diff := helpers.Diff(got, map[netip.Addr]string{
+		netip.MustParseAddr("192.0.2.1"): "host1.example.com",
+	}); diff != "" {
+		t.Errorf("Lookup() (-got, +want):\n%s", diff)
+	}
+	if got := atomic.LoadInt32(&lookups); got != 8 {
+		t.Errorf("Lookup() did %d lookups, expected 8", got)
+	}
+}
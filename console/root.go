@@ -5,6 +5,7 @@
 package console
 
 import (
+	"fmt"
 	"io/fs"
 	netHTTP "net/http"
 	"os"
@@ -25,6 +26,7 @@ import (
 	"akvorado/console/authentication"
 	"akvorado/console/database"
 	"akvorado/console/query"
+	"akvorado/console/resolver"
 )
 
 // Component represents the console component.
@@ -34,11 +36,23 @@ type Component struct {
 	t      tomb.Tomb
 	config Configuration
 
+	baseFilter      string // as written in the configuration
 	flowsTables     []flowsTable
 	flowsTablesLock sync.RWMutex
+	queryCache      *queryCache
+	resolver        *resolver.Resolver
+	maintenance     clickhousedb.MaintenanceStatus
+	maintenanceLock sync.RWMutex
+	current         map[string]currentValue
+	currentLock     sync.RWMutex
 
 	metrics struct {
 		clickhouseQueries *reporter.CounterVec
+		queryCacheHits    reporter.Counter
+		queryCacheMisses  reporter.Counter
+		fallbackQueries   reporter.Counter
+
+		currentRefreshErrors reporter.Counter
 	}
 }
 
@@ -61,11 +75,44 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 	if err := query.Columns(config.DefaultVisualizeOptions.Dimensions).Validate(dependencies.Schema); err != nil {
 		return nil, err
 	}
+	baseFilter := config.BaseFilter.String()
+	if err := config.BaseFilter.Validate(dependencies.Schema); err != nil {
+		return nil, fmt.Errorf("base filter: %w", err)
+	}
+	grafanaQueries := make(map[string]GrafanaQueryConfiguration, len(config.Grafana.Queries))
+	for name, q := range config.Grafana.Queries {
+		if err := query.Columns(q.Dimensions).Validate(dependencies.Schema); err != nil {
+			return nil, fmt.Errorf("grafana query %q: %w", name, err)
+		}
+		if err := q.Filter.Validate(dependencies.Schema); err != nil {
+			return nil, fmt.Errorf("grafana query %q: %w", name, err)
+		}
+		q.Filter.And(config.BaseFilter)
+		if q.Limit > config.DimensionsLimit {
+			return nil, fmt.Errorf("grafana query %q: limit is set beyond maximum value (%d)",
+				name, config.DimensionsLimit)
+		}
+		grafanaQueries[name] = q
+	}
+	config.Grafana.Queries = grafanaQueries
+	currentFilters := make(map[string]query.Filter, len(config.Current.Filters))
+	for name, filter := range config.Current.Filters {
+		if err := filter.Validate(dependencies.Schema); err != nil {
+			return nil, fmt.Errorf("current filter %q: %w", name, err)
+		}
+		filter.And(config.BaseFilter)
+		currentFilters[name] = filter
+	}
+	config.Current.Filters = currentFilters
 	c := Component{
 		r:           r,
 		d:           &dependencies,
 		config:      config,
+		baseFilter:  baseFilter,
 		flowsTables: []flowsTable{{"flows", 0, time.Time{}}},
+		queryCache:  newQueryCache(config.CacheSize, config.CacheTTL),
+		resolver:    resolver.New(config.ResolverTimeout, config.ResolverCacheDuration, nil),
+		current:     map[string]currentValue{},
 	}
 
 	c.d.Daemon.Track(&c.t, "console")
@@ -76,6 +123,30 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 			Help: "Number of requests to ClickHouse.",
 		}, []string{"table"},
 	)
+	c.metrics.queryCacheHits = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "query_cache_hits_total",
+			Help: "Number of graph queries served from the cache.",
+		},
+	)
+	c.metrics.queryCacheMisses = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "query_cache_misses_total",
+			Help: "Number of graph queries not found in the cache.",
+		},
+	)
+	c.metrics.fallbackQueries = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "fallback_queries_total",
+			Help: "Number of graph queries served from inlet throughput counters.",
+		},
+	)
+	c.metrics.currentRefreshErrors = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "current_refresh_errors_total",
+			Help: "Number of failed refreshes of the current traffic.",
+		},
+	)
 	return &c, nil
 }
 
@@ -87,20 +158,32 @@ func (c *Component) Start() error {
 	endpoint := c.d.HTTP.GinRouter.Group("/api/v0/console", c.d.Auth.UserAuthentication())
 	endpoint.GET("/configuration", c.configHandlerFunc)
 	endpoint.GET("/docs/:name", c.docsHandlerFunc)
-	endpoint.GET("/widget/flow-last", c.d.HTTP.CacheByRequestPath(5*time.Second), c.widgetFlowLastHandlerFunc)
-	endpoint.GET("/widget/flow-rate", c.d.HTTP.CacheByRequestPath(5*time.Second), c.widgetFlowRateHandlerFunc)
-	endpoint.GET("/widget/exporters", c.d.HTTP.CacheByRequestPath(30*time.Second), c.widgetExportersHandlerFunc)
-	endpoint.GET("/widget/top/:name", c.d.HTTP.CacheByRequestPath(30*time.Second), c.widgetTopHandlerFunc)
-	endpoint.GET("/widget/graph", c.d.HTTP.CacheByRequestPath(5*time.Minute), c.widgetGraphHandlerFunc)
-	endpoint.POST("/graph/line", c.d.HTTP.CacheByRequestBody(c.config.CacheTTL), c.graphLineHandlerFunc)
-	endpoint.POST("/graph/sankey", c.d.HTTP.CacheByRequestBody(c.config.CacheTTL), c.graphSankeyHandlerFunc)
+	data := endpoint.Group("", c.maintenanceMiddleware())
+	data.GET("/widget/flow-last", c.d.HTTP.CacheByRequestPath(5*time.Second), c.widgetFlowLastHandlerFunc)
+	data.GET("/widget/flow-rate", c.d.HTTP.CacheByRequestPath(5*time.Second), c.widgetFlowRateHandlerFunc)
+	data.GET("/widget/exporters", c.d.HTTP.CacheByRequestPath(30*time.Second), c.widgetExportersHandlerFunc)
+	data.GET("/widget/top/:name", c.d.HTTP.CacheByRequestPath(30*time.Second), c.widgetTopHandlerFunc)
+	data.GET("/widget/graph", c.d.HTTP.CacheByRequestPath(5*time.Minute), c.widgetGraphHandlerFunc)
+	data.GET("/widget/upstreams", c.d.HTTP.CacheByRequestPath(5*time.Minute), c.widgetUpstreamsHandlerFunc)
+	data.GET("/schema", c.d.HTTP.CacheByRequestPath(10*time.Minute), c.schemaHandlerFunc)
+	data.POST("/graph/line", c.graphLineHandlerFunc)
+	data.POST("/graph/sankey", c.graphSankeyHandlerFunc)
+	data.POST("/graph/interfaces", c.graphInterfacesHandlerFunc)
+	data.POST("/first-seen", c.firstSeenHandlerFunc)
+	data.GET("/annotations", c.annotationsHandlerFunc)
 	endpoint.POST("/filter/validate", c.filterValidateHandlerFunc)
-	endpoint.POST("/filter/complete", c.d.HTTP.CacheByRequestBody(time.Minute), c.filterCompleteHandlerFunc)
+	data.POST("/filter/complete", c.d.HTTP.CacheByRequestBody(time.Minute), c.filterCompleteHandlerFunc)
 	endpoint.GET("/filter/saved", c.filterSavedListHandlerFunc)
 	endpoint.DELETE("/filter/saved/:id", c.filterSavedDeleteHandlerFunc)
 	endpoint.POST("/filter/saved", c.filterSavedAddHandlerFunc)
+	endpoint.GET("/maintenance", c.maintenanceHandlerFunc)
 	endpoint.GET("/user/info", c.d.Auth.UserInfoHandlerFunc)
 	endpoint.GET("/user/avatar", c.d.Auth.UserAvatarHandlerFunc)
+	c.d.HTTP.GinRouter.GET("/api/v0/console/current", c.currentHandlerFunc)
+	grafana := c.d.HTTP.GinRouter.Group("/api/v0/console/grafana", c.grafanaAuthentication())
+	grafana.GET("/", c.grafanaTestHandlerFunc)
+	grafana.POST("/metrics", c.grafanaMetricsHandlerFunc)
+	grafana.POST("/query", c.maintenanceMiddleware(), c.grafanaQueryHandlerFunc)
 
 	c.t.Go(func() error {
 		ticker := time.NewTicker(10 * time.Second)
@@ -119,6 +202,35 @@ func (c *Component) Start() error {
 			}
 		}
 	})
+	c.t.Go(func() error {
+		ticker := c.d.Clock.Ticker(10 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := c.refreshMaintenance(); err != nil {
+					c.r.Err(err).Msg("cannot refresh maintenance status")
+				}
+			case <-c.t.Dying():
+				return nil
+			}
+		}
+	})
+	if len(c.config.Current.Filters) > 0 {
+		c.t.Go(func() error {
+			timer := c.d.Clock.Timer(0)
+			defer timer.Stop()
+			for {
+				select {
+				case <-timer.C:
+					c.refreshCurrent()
+					timer.Reset(c.config.Current.RefreshInterval)
+				case <-c.t.Dying():
+					return nil
+				}
+			}
+		})
+	}
 	return nil
 }
 
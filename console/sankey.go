@@ -10,6 +10,7 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
+	"golang.org/x/exp/slices"
 
 	"akvorado/common/helpers"
 	"akvorado/console/query"
@@ -18,6 +19,41 @@ import (
 // graphSankeyHandlerInput describes the input for the /graph/sankey endpoint.
 type graphSankeyHandlerInput struct {
 	graphCommonHandlerInput
+	// Aggregates are extra aggregates to compute for each row.
+	Aggregates []string `json:"aggregates" binding:"dive,oneof=avg-packet-size min-packet-size max-packet-size pps"`
+	// OrderBy is the value used to sort rows (xps by default). When it is
+	// an aggregate, it is also returned.
+	OrderBy string `json:"order-by" binding:"omitempty,oneof=xps avg-packet-size min-packet-size max-packet-size pps"`
+}
+
+// sankeyPoints is the number of points used to select the table and the
+// resolution of sankey graphs.
+const sankeyPoints = 20
+
+// sankeyAggregates are the extra aggregates which can be computed for each
+// row, in the order they are selected. The packet size is computed from L3
+// bytes and flows without packets are ignored.
+var sankeyAggregates = []struct {
+	Name  string
+	Alias string
+	SQL   string
+}{
+	{"avg-packet-size", "avgPacketSize", "if(SUM(Packets) = 0, 0, SUM(Bytes) / SUM(Packets))"},
+	{"min-packet-size", "minPacketSize", "minIf(Bytes / Packets, Packets > 0)"},
+	{"max-packet-size", "maxPacketSize", "maxIf(Bytes / Packets, Packets > 0)"},
+	{"pps", "pps", "SUM(Packets*SamplingRate)/range"},
+}
+
+// aggregates returns the list of aggregates to compute, including the one
+// used for ordering.
+func (input graphSankeyHandlerInput) aggregates() []string {
+	aggregates := []string{}
+	for _, aggregate := range sankeyAggregates {
+		if slices.Contains(input.Aggregates, aggregate.Name) || input.OrderBy == aggregate.Name {
+			aggregates = append(aggregates, aggregate.Name)
+		}
+	}
+	return aggregates
 }
 
 // graphSankeyHandlerOutput describes the output for the /graph/sankey endpoint.
@@ -25,6 +61,10 @@ type graphSankeyHandlerOutput struct {
 	// Unprocessed data for table view
 	Rows [][]string `json:"rows"`
 	Xps  []int      `json:"xps"` // row → xps
+	// Aggregates are the requested extra aggregates (aggregate → row → value)
+	Aggregates map[string][]float64 `json:"aggregates,omitempty"`
+	// Rows with addresses formatted as requested
+	FormattedRows [][]string `json:"formatted-rows,omitempty"`
 	// Processed data for sankey graph
 	Nodes []string     `json:"nodes"`
 	Links []sankeyLink `json:"links"`
@@ -41,29 +81,32 @@ func (input graphSankeyHandlerInput) toSQL() (string, error) {
 
 	// Select
 	arrayFields := []string{}
-	dimensions := []string{}
 	for _, column := range input.Dimensions {
 		arrayFields = append(arrayFields, fmt.Sprintf(`if(%s IN (SELECT %s FROM rows), %s, 'Other')`,
 			column.String(),
 			column.String(),
 			column.ToSQLSelect(input.schema)))
-		dimensions = append(dimensions, column.String())
 	}
 	fields := []string{
 		`{{ .Units }}/range AS xps`,
 		fmt.Sprintf("[%s] AS dimensions", strings.Join(arrayFields, ",\n  ")),
 	}
+	orderBy := "xps"
+	aggregates := input.aggregates()
+	for _, aggregate := range sankeyAggregates {
+		if slices.Contains(aggregates, aggregate.Name) {
+			fields = append(fields, fmt.Sprintf("%s AS %s", aggregate.SQL, aggregate.Alias))
+			if input.OrderBy == aggregate.Name {
+				orderBy = aggregate.Alias
+			}
+		}
+	}
 
 	// With
 	with := []string{
 		fmt.Sprintf("source AS (%s)", input.sourceSelect()),
 		fmt.Sprintf(`(SELECT MAX(TimeReceived) - MIN(TimeReceived) FROM source WHERE %s) AS range`, where),
-		fmt.Sprintf(
-			"rows AS (SELECT %s FROM source WHERE %s GROUP BY %s ORDER BY SUM(Bytes) DESC LIMIT %d)",
-			strings.Join(dimensions, ", "),
-			where,
-			strings.Join(dimensions, ", "),
-			input.Limit),
+		input.rowsWith(where),
 	}
 
 	sqlQuery := fmt.Sprintf(`
@@ -75,21 +118,20 @@ SELECT
 FROM source
 WHERE %s
 GROUP BY dimensions
-ORDER BY xps DESC
+ORDER BY %s DESC
 {{ end }}`,
 		templateContext(inputContext{
 			Start:             input.Start,
 			End:               input.End,
 			MainTableRequired: requireMainTable(input.schema, input.Dimensions, input.Filter),
-			Points:            20,
+			Points:            sankeyPoints,
 			Units:             input.Units,
 		}),
-		strings.Join(with, ",\n "), strings.Join(fields, ",\n "), where)
+		strings.Join(with, ",\n "), strings.Join(fields, ",\n "), where, orderBy)
 	return strings.TrimSpace(sqlQuery), nil
 }
 
 func (c *Component) graphSankeyHandlerFunc(gc *gin.Context) {
-	ctx := c.t.Context(gc.Request.Context())
 	input := graphSankeyHandlerInput{graphCommonHandlerInput: graphCommonHandlerInput{schema: c.d.Schema}}
 	if err := gc.ShouldBindJSON(&input); err != nil {
 		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
@@ -103,12 +145,25 @@ func (c *Component) graphSankeyHandlerFunc(gc *gin.Context) {
 		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
 		return
 	}
+	if err := c.applyBaseFilter(gc, &input.Filter, input.IgnoreBaseFilter); err != nil {
+		gc.JSON(http.StatusForbidden, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	input.excludeZeroVolume()
+	if err := input.validateFormats(); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
 	if input.Limit > c.config.DimensionsLimit {
 		gc.JSON(http.StatusBadRequest,
 			gin.H{"message": fmt.Sprintf("Limit is set beyond maximum value (%d)",
 				c.config.DimensionsLimit)})
 		return
 	}
+	if input.Coverage > 0 {
+		input.Limit = c.config.DimensionsLimit
+	}
+	input.resolution = c.normalizeTimeRange(&input.Start, &input.End, sankeyPoints)
 
 	sqlQuery, err := input.toSQL()
 	if err != nil {
@@ -120,10 +175,15 @@ func (c *Component) graphSankeyHandlerFunc(gc *gin.Context) {
 	sqlQuery = c.finalizeQuery(sqlQuery)
 	gc.Header("X-SQL-Query", strings.ReplaceAll(sqlQuery, "\n", "  "))
 	results := []struct {
-		Xps        float64  `ch:"xps"`
-		Dimensions []string `ch:"dimensions"`
+		Xps           float64  `ch:"xps"`
+		Dimensions    []string `ch:"dimensions"`
+		AvgPacketSize float64  `ch:"avgPacketSize"`
+		MinPacketSize float64  `ch:"minPacketSize"`
+		MaxPacketSize float64  `ch:"maxPacketSize"`
+		Pps           float64  `ch:"pps"`
 	}{}
-	if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery); err != nil {
+	var cacheStatus queryCacheStatus
+	if err := cachedSelect(c, gc, input.graphCommonHandlerInput, &cacheStatus, &results, sqlQuery); err != nil {
 		c.r.Err(err).Str("query", sqlQuery).Msg("unable to query database")
 		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
 		return
@@ -155,9 +215,30 @@ func (c *Component) graphSankeyHandlerFunc(gc *gin.Context) {
 		}
 		output.Links = append(output.Links, sankeyLink{source, target, xps})
 	}
+	aggregates := input.aggregates()
+	if len(aggregates) > 0 {
+		output.Aggregates = make(map[string][]float64, len(aggregates))
+		for _, aggregate := range aggregates {
+			output.Aggregates[aggregate] = make([]float64, 0, len(results))
+		}
+	}
 	for _, result := range results {
 		output.Rows = append(output.Rows, result.Dimensions)
 		output.Xps = append(output.Xps, int(result.Xps))
+		for _, aggregate := range aggregates {
+			var value float64
+			switch aggregate {
+			case "avg-packet-size":
+				value = result.AvgPacketSize
+			case "min-packet-size":
+				value = result.MinPacketSize
+			case "max-packet-size":
+				value = result.MaxPacketSize
+			case "pps":
+				value = result.Pps
+			}
+			output.Aggregates[aggregate] = append(output.Aggregates[aggregate], value)
+		}
 		// Consider each pair of successive dimensions
 		for i := 0; i < len(input.Dimensions)-1; i++ {
 			dimension1 := completeName(result.Dimensions[i], i)
@@ -167,6 +248,7 @@ func (c *Component) graphSankeyHandlerFunc(gc *gin.Context) {
 			addLink(dimension1, dimension2, int(result.Xps))
 		}
 	}
+	output.FormattedRows = c.formatRows(gc, input.graphCommonHandlerInput, output.Rows)
 	sort.Slice(output.Links, func(i, j int) bool {
 		if output.Links[i].Xps == output.Links[j].Xps {
 			return output.Links[i].Source < output.Links[j].Source
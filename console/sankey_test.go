@@ -25,7 +25,7 @@ func TestSankeyQuerySQL(t *testing.T) {
 		{
 			Description: "two dimensions, no filters, l3 bps",
 			Input: graphSankeyHandlerInput{
-				graphCommonHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
 					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
 					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
 					Dimensions: []query.Column{
@@ -55,7 +55,7 @@ ORDER BY xps DESC
 		}, {
 			Description: "two dimensions, no filters, l2 bps",
 			Input: graphSankeyHandlerInput{
-				graphCommonHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
 					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
 					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
 					Dimensions: []query.Column{
@@ -86,7 +86,7 @@ ORDER BY xps DESC
 		}, {
 			Description: "two dimensions, no filters, pps",
 			Input: graphSankeyHandlerInput{
-				graphCommonHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
 					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
 					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
 					Dimensions: []query.Column{
@@ -116,7 +116,7 @@ ORDER BY xps DESC
 		}, {
 			Description: "two dimensions, with filter",
 			Input: graphSankeyHandlerInput{
-				graphCommonHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
 					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
 					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
 					Dimensions: []query.Column{
@@ -142,6 +142,73 @@ FROM source
 WHERE {{ .Timefilter }} AND (DstCountry = 'FR')
 GROUP BY dimensions
 ORDER BY xps DESC
+{{ end }}`,
+		}, {
+			Description: "two dimensions, with aggregates",
+			Input: graphSankeyHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
+					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+					Dimensions: []query.Column{
+						query.NewColumn("SrcAS"),
+						query.NewColumn("DstPort"),
+					},
+					Limit:  10,
+					Filter: query.Filter{},
+					Units:  "l3bps",
+				},
+				Aggregates: []string{"pps", "avg-packet-size"},
+			},
+			Expected: `
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","main-table-required":true,"points":20,"units":"l3bps"}@@ }}
+WITH
+ source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
+ (SELECT MAX(TimeReceived) - MIN(TimeReceived) FROM source WHERE {{ .Timefilter }}) AS range,
+ rows AS (SELECT SrcAS, DstPort FROM source WHERE {{ .Timefilter }} GROUP BY SrcAS, DstPort ORDER BY SUM(Bytes) DESC LIMIT 10)
+SELECT
+ {{ .Units }}/range AS xps,
+ [if(SrcAS IN (SELECT SrcAS FROM rows), concat(toString(SrcAS), ': ', dictGetOrDefault('asns', 'name', SrcAS, '???')), 'Other'),
+  if(DstPort IN (SELECT DstPort FROM rows), toString(DstPort), 'Other')] AS dimensions,
+ if(SUM(Packets) = 0, 0, SUM(Bytes) / SUM(Packets)) AS avgPacketSize,
+ SUM(Packets*SamplingRate)/range AS pps
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY dimensions
+ORDER BY xps DESC
+{{ end }}`,
+		}, {
+			Description: "two dimensions, ordered by an aggregate",
+			Input: graphSankeyHandlerInput{
+				graphCommonHandlerInput: graphCommonHandlerInput{
+					Start: time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+					End:   time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+					Dimensions: []query.Column{
+						query.NewColumn("SrcAS"),
+						query.NewColumn("ExporterName"),
+					},
+					Limit:  10,
+					Filter: query.Filter{},
+					Units:  "l3bps",
+				},
+				Aggregates: []string{"min-packet-size"},
+				OrderBy:    "max-packet-size",
+			},
+			Expected: `
+{{ with context @@{"start":"2022-04-10T15:45:10Z","end":"2022-04-11T15:45:10Z","points":20,"units":"l3bps"}@@ }}
+WITH
+ source AS (SELECT * FROM {{ .Table }} SETTINGS asterisk_include_alias_columns = 1),
+ (SELECT MAX(TimeReceived) - MIN(TimeReceived) FROM source WHERE {{ .Timefilter }}) AS range,
+ rows AS (SELECT SrcAS, ExporterName FROM source WHERE {{ .Timefilter }} GROUP BY SrcAS, ExporterName ORDER BY SUM(Bytes) DESC LIMIT 10)
+SELECT
+ {{ .Units }}/range AS xps,
+ [if(SrcAS IN (SELECT SrcAS FROM rows), concat(toString(SrcAS), ': ', dictGetOrDefault('asns', 'name', SrcAS, '???')), 'Other'),
+  if(ExporterName IN (SELECT ExporterName FROM rows), ExporterName, 'Other')] AS dimensions,
+ minIf(Bytes / Packets, Packets > 0) AS minPacketSize,
+ maxIf(Bytes / Packets, Packets > 0) AS maxPacketSize
+FROM source
+WHERE {{ .Timefilter }}
+GROUP BY dimensions
+ORDER BY maxPacketSize DESC
 {{ end }}`,
 		},
 	}
@@ -168,34 +235,38 @@ func TestSankeyHandler(t *testing.T) {
 	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
 
 	expectedSQL := []struct {
-		Xps        float64  `ch:"xps"`
-		Dimensions []string `ch:"dimensions"`
+		Xps           float64  `ch:"xps"`
+		Dimensions    []string `ch:"dimensions"`
+		AvgPacketSize float64  `ch:"avgPacketSize"`
+		MinPacketSize float64  `ch:"minPacketSize"`
+		MaxPacketSize float64  `ch:"maxPacketSize"`
+		Pps           float64  `ch:"pps"`
 	}{
 		// [(random.randrange(100, 10000), x)
 		//  for x in set([(random.choice(asn),
 		//                 random.choice(providers),
 		//                 random.choice(routers)) for x in range(30)])]
-		{9677, []string{"AS100", "Other", "router1"}},
-		{9472, []string{"AS300", "provider1", "Other"}},
-		{7593, []string{"AS300", "provider2", "router1"}},
-		{7234, []string{"AS200", "provider1", "Other"}},
-		{6006, []string{"AS100", "provider1", "Other"}},
-		{5988, []string{"Other", "provider1", "Other"}},
-		{4675, []string{"AS200", "provider3", "Other"}},
-		{4348, []string{"AS200", "Other", "router2"}},
-		{3999, []string{"AS100", "provider3", "Other"}},
-		{3978, []string{"AS100", "provider3", "router2"}},
-		{3623, []string{"Other", "Other", "router1"}},
-		{3080, []string{"AS300", "provider3", "router2"}},
-		{2915, []string{"AS300", "Other", "router1"}},
-		{2623, []string{"AS100", "provider1", "router1"}},
-		{2482, []string{"AS200", "provider2", "router2"}},
-		{2234, []string{"AS100", "provider2", "Other"}},
-		{1360, []string{"AS200", "Other", "router1"}},
-		{975, []string{"AS300", "Other", "Other"}},
-		{717, []string{"AS200", "provider3", "router2"}},
-		{621, []string{"Other", "Other", "Other"}},
-		{159, []string{"Other", "provider1", "router1"}},
+		{Xps: 9677, Dimensions: []string{"AS100", "Other", "router1"}},
+		{Xps: 9472, Dimensions: []string{"AS300", "provider1", "Other"}},
+		{Xps: 7593, Dimensions: []string{"AS300", "provider2", "router1"}},
+		{Xps: 7234, Dimensions: []string{"AS200", "provider1", "Other"}},
+		{Xps: 6006, Dimensions: []string{"AS100", "provider1", "Other"}},
+		{Xps: 5988, Dimensions: []string{"Other", "provider1", "Other"}},
+		{Xps: 4675, Dimensions: []string{"AS200", "provider3", "Other"}},
+		{Xps: 4348, Dimensions: []string{"AS200", "Other", "router2"}},
+		{Xps: 3999, Dimensions: []string{"AS100", "provider3", "Other"}},
+		{Xps: 3978, Dimensions: []string{"AS100", "provider3", "router2"}},
+		{Xps: 3623, Dimensions: []string{"Other", "Other", "router1"}},
+		{Xps: 3080, Dimensions: []string{"AS300", "provider3", "router2"}},
+		{Xps: 2915, Dimensions: []string{"AS300", "Other", "router1"}},
+		{Xps: 2623, Dimensions: []string{"AS100", "provider1", "router1"}},
+		{Xps: 2482, Dimensions: []string{"AS200", "provider2", "router2"}},
+		{Xps: 2234, Dimensions: []string{"AS100", "provider2", "Other"}},
+		{Xps: 1360, Dimensions: []string{"AS200", "Other", "router1"}},
+		{Xps: 975, Dimensions: []string{"AS300", "Other", "Other"}},
+		{Xps: 717, Dimensions: []string{"AS200", "provider3", "router2"}},
+		{Xps: 621, Dimensions: []string{"Other", "Other", "Other"}},
+		{Xps: 159, Dimensions: []string{"Other", "provider1", "router1"}},
 	}
 	mockConn.EXPECT().
 		Select(gomock.Any(), gomock.Any(), gomock.Any()).
@@ -377,3 +448,83 @@ func TestSankeyHandler(t *testing.T) {
 		},
 	})
 }
+
+func TestSankeyHandlerAggregates(t *testing.T) {
+	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+
+	expectedSQL := []struct {
+		Xps           float64  `ch:"xps"`
+		Dimensions    []string `ch:"dimensions"`
+		AvgPacketSize float64  `ch:"avgPacketSize"`
+		MinPacketSize float64  `ch:"minPacketSize"`
+		MaxPacketSize float64  `ch:"maxPacketSize"`
+		Pps           float64  `ch:"pps"`
+	}{
+		{Xps: 9677, Dimensions: []string{"AS100", "443"}, AvgPacketSize: 1400, Pps: 864},
+		{Xps: 7593, Dimensions: []string{"AS200", "53"}, AvgPacketSize: 3000.5, Pps: 316},
+		{Xps: 4348, Dimensions: []string{"AS300", "123"}, AvgPacketSize: 0, Pps: 0},
+		{Xps: 621, Dimensions: []string{"Other", "Other"}, AvgPacketSize: 512, Pps: 15},
+	}
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedSQL).
+		Return(nil)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/console/graph/sankey",
+			JSONInput: gin.H{
+				"start":      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"dimensions": []string{"SrcAS", "DstPort"},
+				"limit":      10,
+				"units":      "l3bps",
+				"aggregates": []string{"avg-packet-size", "pps"},
+			},
+			JSONOutput: gin.H{
+				"rows": [][]string{
+					{"AS100", "443"},
+					{"AS200", "53"},
+					{"AS300", "123"},
+					{"Other", "Other"},
+				},
+				"xps": []int{9677, 7593, 4348, 621},
+				"aggregates": gin.H{
+					"avg-packet-size": []float64{1400, 3000.5, 0, 512},
+					"pps":             []float64{864, 316, 0, 15},
+				},
+				"nodes": []string{
+					"SrcAS: AS100",
+					"DstPort: 443",
+					"SrcAS: AS200",
+					"DstPort: 53",
+					"SrcAS: AS300",
+					"DstPort: 123",
+					"SrcAS: Other",
+					"DstPort: Other",
+				},
+				"links": []gin.H{
+					{"source": "SrcAS: AS100", "target": "DstPort: 443", "xps": 9677},
+					{"source": "SrcAS: AS200", "target": "DstPort: 53", "xps": 7593},
+					{"source": "SrcAS: AS300", "target": "DstPort: 123", "xps": 4348},
+					{"source": "SrcAS: Other", "target": "DstPort: Other", "xps": 621},
+				},
+			},
+		}, {
+			Description: "unknown aggregate",
+			URL:         "/api/v0/console/graph/sankey",
+			JSONInput: gin.H{
+				"start":      time.Date(2022, 4, 10, 15, 45, 10, 0, time.UTC),
+				"end":        time.Date(2022, 4, 11, 15, 45, 10, 0, time.UTC),
+				"dimensions": []string{"SrcAS", "DstPort"},
+				"limit":      10,
+				"units":      "l3bps",
+				"aggregates": []string{"median-packet-size"},
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{
+				"message": "Key: 'graphSankeyHandlerInput.Aggregates[0]' Error:Field validation for 'Aggregates[0]' failed on the 'oneof' tag",
+			},
+		},
+	})
+}
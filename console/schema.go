@@ -0,0 +1,93 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/schema"
+	"akvorado/console/query"
+)
+
+// schemaHandlerOutput describes the output of the /schema endpoint.
+type schemaHandlerOutput struct {
+	Columns []schemaColumn `json:"columns"`
+}
+
+type schemaColumn struct {
+	Name        string                `json:"name"`
+	Type        string                `json:"type"`
+	Description string                `json:"description"`
+	Sources     []schema.ColumnSource `json:"sources"`
+	Dimension   bool                  `json:"dimension"`
+	Filterable  bool                  `json:"filterable"`
+	Examples    []string              `json:"examples"`
+}
+
+// schemaExamplesQuery returns a few values seen during the last minute for
+// each provided column.
+const schemaExamplesQuery = `
+SELECT
+ tupleElement(pair, 1) AS name,
+ groupUniqArray(5)(tupleElement(pair, 2)) AS examples
+FROM flows
+ARRAY JOIN [%s] AS pair
+WHERE TimeReceived > date_sub(minute, 1, now())
+AND tupleElement(pair, 2) != ''
+GROUP BY name`
+
+func (c *Component) schemaHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	output := schemaHandlerOutput{Columns: []schemaColumn{}}
+	pairs := []string{}
+	for _, column := range c.d.Schema.Columns() {
+		if column.Disabled {
+			continue
+		}
+		output.Columns = append(output.Columns, schemaColumn{
+			Name:        column.Name,
+			Type:        column.ClickHouseType,
+			Description: column.Description,
+			Sources:     column.Sources,
+			Dimension:   !column.ConsoleNotDimension,
+			Filterable:  column.Filterable(),
+			Examples:    []string{},
+		})
+		expr := fmt.Sprintf("toString(%s)", column.Name)
+		if !column.ConsoleNotDimension {
+			qc := query.NewColumn(column.Name)
+			if err := qc.Validate(c.d.Schema); err == nil {
+				expr = fmt.Sprintf("toString(%s)", qc.ToSQLSelect(c.d.Schema))
+			}
+		}
+		pairs = append(pairs, fmt.Sprintf("('%s', %s)", column.Name, expr))
+	}
+
+	// Sample some values. This is best effort.
+	results := []struct {
+		Name     string   `ch:"name"`
+		Examples []string `ch:"examples"`
+	}{}
+	sqlQuery := strings.TrimSpace(fmt.Sprintf(schemaExamplesQuery, strings.Join(pairs, ", ")))
+	if err := c.d.ClickHouseDB.Conn.Select(ctx, &results, sqlQuery); err != nil {
+		c.r.Err(err).Msg("unable to query column examples")
+	}
+	examples := map[string][]string{}
+	for _, result := range results {
+		sort.Strings(result.Examples)
+		examples[result.Name] = result.Examples
+	}
+	for idx, column := range output.Columns {
+		if e, ok := examples[column.Name]; ok {
+			output.Columns[idx].Examples = e
+		}
+	}
+
+	gc.JSON(http.StatusOK, output)
+}
@@ -0,0 +1,103 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package console
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/helpers"
+)
+
+func TestSchemaHandler(t *testing.T) {
+	_, h, mockConn, _ := NewMock(t, DefaultConfiguration())
+
+	expectedSQL := []struct {
+		Name     string   `ch:"name"`
+		Examples []string `ch:"examples"`
+	}{
+		{"SrcAS", []string{"65201: Private use", "15169: Google"}},
+		{"ExporterName", []string{"router1"}},
+	}
+	var sqlQuery string
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any()).
+		SetArg(1, expectedSQL).
+		Do(func(_, _ interface{}, query string, _ ...interface{}) {
+			sqlQuery = query
+		}).
+		Return(nil)
+
+	resp, err := http.Get(fmt.Sprintf("http://%s/api/v0/console/schema", h.LocalAddr()))
+	if err != nil {
+		t.Fatalf("GET /api/v0/console/schema:\n%+v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("GET /api/v0/console/schema: got status code %d", resp.StatusCode)
+	}
+	// Sources are decoded as strings
+	type column struct {
+		Name        string   `json:"name"`
+		Type        string   `json:"type"`
+		Description string   `json:"description"`
+		Sources     []string `json:"sources"`
+		Dimension   bool     `json:"dimension"`
+		Filterable  bool     `json:"filterable"`
+		Examples    []string `json:"examples"`
+	}
+	var got struct {
+		Columns []column `json:"columns"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatalf("Decode() error:\n%+v", err)
+	}
+
+	for _, expected := range []string{
+		`('SrcAS', toString(concat(toString(SrcAS), ': ', dictGetOrDefault('asns', 'name', SrcAS, '???'))))`,
+		`('Bytes', toString(Bytes))`,
+	} {
+		if !strings.Contains(sqlQuery, expected) {
+			t.Errorf("SQL query does not contain %q:\n%s", expected, sqlQuery)
+		}
+	}
+
+	columns := map[string]column{}
+	for _, column := range got.Columns {
+		columns[column.Name] = column
+	}
+	if _, ok := columns["SrcVlan"]; ok {
+		t.Error("disabled column SrcVlan is present")
+	}
+	expected := map[string]column{
+		"SrcAS": {
+			Name:        "SrcAS",
+			Type:        "UInt32",
+			Description: "AS number of the source IP address",
+			Sources:     []string{"flow", "bmp", "geoip"},
+			Dimension:   true,
+			Filterable:  true,
+			Examples:    []string{"15169: Google", "65201: Private use"},
+		},
+		"Bytes": {
+			Name:        "Bytes",
+			Type:        "UInt64",
+			Description: "Number of bytes, to be multiplied by the sampling rate",
+			Sources:     []string{"flow"},
+			Dimension:   false,
+			Filterable:  false,
+			Examples:    []string{},
+		},
+	}
+	for name, column := range expected {
+		if diff := helpers.Diff(columns[name], column); diff != "" {
+			t.Errorf("column %s (-got, +want):\n%s", name, diff)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"net/http"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -15,6 +16,15 @@ import (
 	"akvorado/common/schema"
 )
 
+// baseFilterCondition returns the base filter as a condition prefixed by the
+// provided keyword, or an empty string when there is no base filter.
+func (c *Component) baseFilterCondition(keyword string) string {
+	if c.config.BaseFilter.Direct() == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s (%s)", keyword, c.config.BaseFilter.Direct())
+}
+
 func (c *Component) widgetFlowLastHandlerFunc(gc *gin.Context) {
 	ctx := c.t.Context(gc.Request.Context())
 	replace := []struct {
@@ -43,8 +53,9 @@ func (c *Component) widgetFlowLastHandlerFunc(gc *gin.Context) {
 	query := fmt.Sprintf(`
 %s
 FROM flows
-WHERE TimeReceived=(SELECT MAX(TimeReceived) FROM flows)
-LIMIT 1`, strings.Join(selectClause, ",\n "))
+WHERE TimeReceived=(SELECT MAX(TimeReceived) FROM flows%s)%s
+LIMIT 1`, strings.Join(selectClause, ",\n "),
+		c.baseFilterCondition(" WHERE"), c.baseFilterCondition("\nAND"))
 	gc.Header("X-SQL-Query", query)
 	// Do not increase counter for this one.
 	rows, err := c.d.ClickHouseDB.Conn.Query(ctx, query)
@@ -173,10 +184,16 @@ func (c *Component) widgetTopHandlerFunc(gc *gin.Context) {
 		selector = `concat(dictGetOrDefault('protocols', 'name', Proto, '???'), '/', toString(DstPort))`
 		groupby = `Proto, DstPort`
 		mainTableRequired = true
+	case "packet-size":
+		// The average packet size is only meaningful for individual flows
+		selector = `PacketSizeBucket`
+		mainTableRequired = true
 	}
 	if groupby == "" {
 		groupby = selector
 	}
+	filter += templateEscape(c.baseFilterCondition(" AND"))
+	mainTableRequired = mainTableRequired || c.config.BaseFilter.MainTableRequired()
 
 	now := c.d.Clock.Now()
 	query := c.finalizeQuery(fmt.Sprintf(`
@@ -222,7 +239,7 @@ SELECT
  SUM(Bytes*SamplingRate*8/{{ .Interval }})/1000/1000/1000 AS Gbps
 FROM {{ .Table }}
 WHERE {{ .Timefilter }}
-AND InIfBoundary = 'external'
+AND InIfBoundary = 'external'%s
 GROUP BY Time
 ORDER BY Time WITH FILL
  FROM {{ .TimefilterStart }}
@@ -232,9 +249,10 @@ ORDER BY Time WITH FILL
 		templateContext(inputContext{
 			Start:             now.Add(-24 * time.Hour),
 			End:               now,
-			MainTableRequired: false,
+			MainTableRequired: c.config.BaseFilter.MainTableRequired(),
 			Points:            200,
-		})))
+		}),
+		templateEscape(c.baseFilterCondition("\nAND"))))
 	gc.Header("X-SQL-Query", query)
 
 	results := []struct {
@@ -250,3 +268,107 @@ ORDER BY Time WITH FILL
 
 	gc.JSON(http.StatusOK, gin.H{"data": results})
 }
+
+// upstreamsOtherThreshold is the share (in percent) under which an upstream
+// is folded into "Other" for both periods.
+const upstreamsOtherThreshold = 2
+
+type upstreamResult struct {
+	ASN   uint32
+	Name  string
+	Bytes float64
+}
+
+type upstreamShare struct {
+	Name            string  `json:"name"`
+	Percent         float64 `json:"percent"`
+	PreviousPercent float64 `json:"previous-percent"`
+	Delta           float64 `json:"delta"`
+}
+
+// widgetUpstreamsHandlerFunc returns the distribution of egress external
+// traffic across the first AS of the path (the upstream) during the last day
+// and how it shifted compared to the previous day.
+func (c *Component) widgetUpstreamsHandlerFunc(gc *gin.Context) {
+	ctx := c.t.Context(gc.Request.Context())
+	now := c.d.Clock.Now()
+	periods := []struct {
+		Start time.Time
+		End   time.Time
+	}{
+		{now.Add(-24 * time.Hour), now},
+		{now.Add(-48 * time.Hour), now.Add(-24 * time.Hour)},
+	}
+	shares := make([]map[uint32]float64, len(periods))
+	names := map[uint32]string{}
+	queries := make([]string, len(periods))
+	for idx, period := range periods {
+		query := c.finalizeQuery(fmt.Sprintf(`
+{{ with %s }}
+SELECT
+ Dst1stAS AS ASN,
+ if(Dst1stAS = 0, 'Unknown', concat(toString(Dst1stAS), ': ', dictGetOrDefault('asns', 'name', Dst1stAS, '???'))) AS Name,
+ SUM(Bytes*SamplingRate) AS Bytes
+FROM {{ .Table }}
+WHERE {{ .Timefilter }}
+AND OutIfBoundary = 'external'%s
+GROUP BY Dst1stAS
+{{ end }}`,
+			templateContext(inputContext{
+				Start:             period.Start,
+				End:               period.End,
+				MainTableRequired: c.config.BaseFilter.MainTableRequired(),
+				Points:            24,
+			}),
+			templateEscape(c.baseFilterCondition("\nAND"))))
+		queries[idx] = strings.TrimSpace(query)
+
+		results := []upstreamResult{}
+		err := c.d.ClickHouseDB.Conn.Select(ctx, &results, queries[idx])
+		if err != nil {
+			c.r.Err(err).Msg("unable to query database")
+			gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to query database."})
+			return
+		}
+		total := 0.
+		for _, result := range results {
+			total += result.Bytes
+		}
+		shares[idx] = map[uint32]float64{}
+		for _, result := range results {
+			if total > 0 {
+				shares[idx][result.ASN] = result.Bytes / total * 100
+			}
+			names[result.ASN] = result.Name
+		}
+	}
+	gc.Header("X-SQL-Query", strings.Join(queries, "\n"))
+
+	upstreams := []upstreamShare{}
+	other := upstreamShare{Name: "Other"}
+	for asn, name := range names {
+		current, previous := shares[0][asn], shares[1][asn]
+		if current < upstreamsOtherThreshold && previous < upstreamsOtherThreshold {
+			other.Percent += current
+			other.PreviousPercent += previous
+			continue
+		}
+		upstreams = append(upstreams, upstreamShare{
+			Name:            name,
+			Percent:         current,
+			PreviousPercent: previous,
+			Delta:           current - previous,
+		})
+	}
+	sort.Slice(upstreams, func(i, j int) bool {
+		if upstreams[i].Percent != upstreams[j].Percent {
+			return upstreams[i].Percent > upstreams[j].Percent
+		}
+		return upstreams[i].Name < upstreams[j].Name
+	})
+	if other.Percent > 0 || other.PreviousPercent > 0 {
+		other.Delta = other.Percent - other.PreviousPercent
+		upstreams = append(upstreams, other)
+	}
+	gc.JSON(http.StatusOK, gin.H{"upstreams": upstreams})
+}
@@ -186,6 +186,13 @@ func TestWidgetTop(t *testing.T) {
 				{"36040: Youtube", float64(10)},
 				{"20940: Akamai", float64(9)},
 			}),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).Return(nil).
+			SetArg(1, []topResult{
+				{"1280-1500", float64(62)},
+				{"0-63", float64(21)},
+				{"64-127", float64(8)},
+			}),
 	)
 
 	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
@@ -225,6 +232,15 @@ func TestWidgetTop(t *testing.T) {
 					{"name": "20940: Akamai", "percent": 9},
 				},
 			},
+		}, {
+			URL: "/api/v0/console/widget/top/packet-size",
+			JSONOutput: gin.H{
+				"top": []gin.H{
+					{"name": "1280-1500", "percent": 62},
+					{"name": "0-63", "percent": 21},
+					{"name": "64-127", "percent": 8},
+				},
+			},
 		},
 	})
 }
@@ -277,3 +293,60 @@ ORDER BY Time WITH FILL
 		},
 	})
 }
+
+func TestWidgetUpstreams(t *testing.T) {
+	_, h, mockConn, mockClock := NewMock(t, DefaultConfiguration())
+	mockClock.Set(time.Date(2009, 11, 10, 23, 0, 0, 0, time.UTC))
+
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), strings.TrimSpace(`
+SELECT
+ Dst1stAS AS ASN,
+ if(Dst1stAS = 0, 'Unknown', concat(toString(Dst1stAS), ': ', dictGetOrDefault('asns', 'name', Dst1stAS, '???'))) AS Name,
+ SUM(Bytes*SamplingRate) AS Bytes
+FROM flows
+WHERE TimeReceived BETWEEN toDateTime('2009-11-09 23:00:00', 'UTC') AND toDateTime('2009-11-10 23:00:00', 'UTC')
+AND OutIfBoundary = 'external'
+GROUP BY Dst1stAS`)).
+			SetArg(1, []upstreamResult{
+				{174, "174: Cogent", 600},
+				{1299, "1299: Arelion", 300},
+				{3356, "3356: Lumen", 90},
+				{6939, "6939: Hurricane Electric", 10},
+			}).
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), strings.TrimSpace(`
+SELECT
+ Dst1stAS AS ASN,
+ if(Dst1stAS = 0, 'Unknown', concat(toString(Dst1stAS), ': ', dictGetOrDefault('asns', 'name', Dst1stAS, '???'))) AS Name,
+ SUM(Bytes*SamplingRate) AS Bytes
+FROM flows
+WHERE TimeReceived BETWEEN toDateTime('2009-11-08 23:00:00', 'UTC') AND toDateTime('2009-11-09 23:00:00', 'UTC')
+AND OutIfBoundary = 'external'
+GROUP BY Dst1stAS`)).
+			SetArg(1, []upstreamResult{
+				{174, "174: Cogent", 1000},
+				{1299, "1299: Arelion", 800},
+				{6939, "6939: Hurricane Electric", 20},
+				{0, "Unknown", 180},
+			}).
+			Return(nil),
+	)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/console/widget/upstreams",
+			JSONOutput: gin.H{
+				"upstreams": []gin.H{
+					{"name": "174: Cogent", "percent": 60, "previous-percent": 50, "delta": 10},
+					{"name": "1299: Arelion", "percent": 30, "previous-percent": 40, "delta": -10},
+					{"name": "3356: Lumen", "percent": 9, "previous-percent": 0, "delta": 9},
+					{"name": "Unknown", "percent": 0, "previous-percent": 9, "delta": -9},
+					{"name": "Other", "percent": 1, "previous-percent": 1, "delta": 0},
+				},
+			},
+		},
+	})
+}
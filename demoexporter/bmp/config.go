@@ -17,33 +17,33 @@ import (
 // Configuration describes the configuration for the BMP component. Only one peer is emulated.
 type Configuration struct {
 	// Target specify the IP address and port to generate BMP routes to. Empty if this component is disabled.
-	Target string `validate:"isdefault|hostname_port"`
+	Target string `validate:"isdefault|hostname_port" doc:"Address and port of the BMP collector (empty to disable)"`
 	// Routes is the set of routes to announce to the collector using BMP.
-	Routes []RouteConfiguration `validate:"dive"`
+	Routes []RouteConfiguration `validate:"dive" doc:"Routes to announce to the collector"`
 	// LocalASN is the local AS number
-	LocalASN uint16 `validate:"required,min=1"`
+	LocalASN uint16 `validate:"required,min=1" doc:"Local AS number"`
 	// PeerASN is the peer AS number
-	PeerASN uint16 `validate:"required,min=1"`
+	PeerASN uint16 `validate:"required,min=1" doc:"Peer AS number"`
 	// LocalIP is the local IP address.
-	LocalIP netip.Addr `validate:"required"`
+	LocalIP netip.Addr `validate:"required" doc:"Local IP address"`
 	// PeerIP is the peer IP address.
-	PeerIP netip.Addr `validate:"required"`
+	PeerIP netip.Addr `validate:"required" doc:"Peer IP address"`
 	// RetryAfter tells how much time to wait before retrying
-	RetryAfter time.Duration `validate:"min=0s"`
+	RetryAfter time.Duration `validate:"min=0s" doc:"Delay before reconnecting"`
 	// StatsDelay tells how much time to wait between two BMP stats message (to check connection liveness)
-	StatsDelay time.Duration `validate:"min=0s"`
+	StatsDelay time.Duration `validate:"min=0s" doc:"Delay between two BMP statistics messages"`
 }
 
 // RouteConfiguration describes a route to be generated with BMP.
 type RouteConfiguration struct {
 	// Prefix is the set of prefixes to announce.
-	Prefixes []netip.Prefix `validate:"min=1"`
+	Prefixes []netip.Prefix `validate:"min=1" doc:"Prefixes to announce"`
 	// ASPath is the AS path to associate with the prefixes.
-	ASPath []uint32 `validate:"min=1"`
+	ASPath []uint32 `validate:"min=1" doc:"AS path for the prefixes"`
 	// Communities are the set of standard communities to associate with the prefixes.
-	Communities []Community
+	Communities []Community `doc:"Standard communities for the prefixes"`
 	// LargeCommunities are the set of large communities to associate with the prefixes.
-	LargeCommunities []LargeCommunity
+	LargeCommunities []LargeCommunity `doc:"Large communities for the prefixes"`
 }
 
 // DefaultConfiguration represents the default configuration for the BMP component.
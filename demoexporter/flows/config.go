@@ -11,49 +11,49 @@ import (
 // Configuration describes the configuration for the flows component.
 type Configuration struct {
 	// SamplingRate defines the sampling rate for this device.
-	SamplingRate int `validate:"min=1"`
+	SamplingRate int `validate:"min=1" doc:"Sampling rate of the exporter"`
 	// Flows describe the flows we want to generate.
-	Flows []FlowConfiguration `validate:"min=1,dive"`
+	Flows []FlowConfiguration `validate:"min=1,dive" doc:"Flows to generate"`
 	// Target specify the IP address and port to generate flows to.
-	Target string `validate:"required,hostname_port"`
+	Target string `validate:"required,hostname_port" doc:"Address and port to send flows to"`
 	// Seed defines a seed to add to the random generator. Without
 	// one, all exporters will produce the same data if provided
 	// the same flows.
-	Seed int64
+	Seed int64 `doc:"Seed for the random generator"`
 }
 
 // FlowConfiguration describes the configuration for a flow.
 type FlowConfiguration struct {
 	// PerSecond defines how many of those flows should be created per second
-	PerSecond float64 `validate:"required,gt=0"`
+	PerSecond float64 `validate:"required,gt=0" doc:"Number of flows per second"`
 	// InIfIndex defines the source interface
-	InIfIndex []int `validate:"min=1,dive,min=1"`
+	InIfIndex []int `validate:"min=1,dive,min=1" doc:"Input interface indexes"`
 	// OutIfIndex defines the output interface
-	OutIfIndex []int `validate:"min=1,dive,min=1"`
+	OutIfIndex []int `validate:"min=1,dive,min=1" doc:"Output interface indexes"`
 	// PeakHour defines the peak hour
-	PeakHour time.Duration `validate:"required,min=0,max=24h"`
+	PeakHour time.Duration `validate:"required,min=0,max=24h" doc:"Peak hour"`
 	// PeakMultiplier defines how to multiply the `PerSecond` when near the peak hour
-	Multiplier float64 `validate:"required,gt=0"`
+	Multiplier float64 `validate:"required,gt=0" doc:"Multiplier for the number of flows near the peak hour"`
 	// SrcNet defines the source network to use
-	SrcNet netip.Prefix `validate:"required"`
+	SrcNet netip.Prefix `validate:"required" doc:"Source network"`
 	// DstNet defines the destination network to use
-	DstNet netip.Prefix `validate:"required"`
+	DstNet netip.Prefix `validate:"required" doc:"Destination network"`
 	// SrcAS defines the source AS number to use
-	SrcAS []uint32 `validate:"min=1"`
+	SrcAS []uint32 `validate:"min=1" doc:"Source AS numbers"`
 	// DstAS defines the destination AS number to use
-	DstAS []uint32 `validate:"min=1"`
+	DstAS []uint32 `validate:"min=1" doc:"Destination AS numbers"`
 	// SrcPort defines the source port to use
-	SrcPort []uint16
+	SrcPort []uint16 `doc:"Source ports"`
 	// DstPort defines the destination port to use
-	DstPort []uint16
+	DstPort []uint16 `doc:"Destination ports"`
 	// Proto defines the IP protocol to use
-	Protocol []string `validate:"min=1,dive,oneof=tcp udp icmp"`
+	Protocol []string `validate:"min=1,dive,oneof=tcp udp icmp" doc:"IP protocols (tcp, udp or icmp)"`
 	// Size defines the packet size to use
-	Size uint `validate:"isdefault|min=64,isdefault|max=9000"`
+	Size uint `validate:"isdefault|min=64,isdefault|max=9000" doc:"Packet size"`
 	// ReverseDirectionRatio generate a second flow for each flow
 	// generated in the opposite direction, by applying the
 	// provided ratio for the Size.
-	ReverseDirectionRatio float32 `validate:"min=0"`
+	ReverseDirectionRatio float32 `validate:"min=0" doc:"Ratio to apply to the size to generate a flow in the opposite direction"`
 }
 
 // DefaultConfiguration represents the default configuration for the flows component.
@@ -6,13 +6,13 @@ package snmp
 // Configuration describes the configuration for the SNMP component.
 type Configuration struct {
 	// Name defines the system name for the exporter (for SNMP)
-	Name string `validate:"required"`
+	Name string `validate:"required" doc:"System name of the exporter"`
 	// Interfaces describe the interfaces attached to the
 	// exporter. This is a mapping from ifIndex to their
 	// description.
-	Interfaces map[int]string `validate:"min=1,dive,keys,min=1,endkeys,min=3"`
+	Interfaces map[int]string `validate:"min=1,dive,keys,min=1,endkeys,min=3" doc:"Mapping from interface indexes to their descriptions"`
 	// Listen specify the IP address the SNMP server should be bound to.
-	Listen string `validate:"required,listen"`
+	Listen string `validate:"required,listen" doc:"Address to listen to"`
 }
 
 // DefaultConfiguration represents the default configuration for the SNMP component.
@@ -8,28 +8,28 @@ import "time"
 // Configuration describes the configuration for the BMP server.
 type Configuration struct {
 	// Listen tells on which port the BMP server should listen to.
-	Listen string `validate:"listen"`
+	Listen string `validate:"listen" doc:"Address to listen to for BMP connections"`
 	// RDs list the RDs to keep. If none are specified, all
 	// received routes are processed. 0 match an absence of RD.
-	RDs []RD
+	RDs []RD `doc:"Route distinguishers to keep (all when empty, 0 matches an absence of RD)"`
 	// CollectASNs is true when we want to collect origin AS numbers
-	CollectASNs bool
+	CollectASNs bool `doc:"Collect origin AS numbers"`
 	// CollectASPaths is true when we want to collect AS paths
-	CollectASPaths bool
+	CollectASPaths bool `doc:"Collect AS paths"`
 	// CollectCommunities is true when we want to collect communities
-	CollectCommunities bool
+	CollectCommunities bool `doc:"Collect BGP communities"`
 	// Keep tells how long to keep routes from a BMP client when it goes down
-	Keep time.Duration `validate:"min=1s"`
+	Keep time.Duration `validate:"min=1s" doc:"How long to keep routes from a BMP client when it goes down"`
 	// RIBPeerRemovalMaxTime tells the maximum time the removal worker should run to remove a peer
-	RIBPeerRemovalMaxTime time.Duration `validate:"min=10ms"`
+	RIBPeerRemovalMaxTime time.Duration `validate:"min=10ms" doc:"Maximum time the removal worker runs to remove a peer"`
 	// RIBPeerRemovalSleepInterval tells how much time to sleep between two runs of the removal worker
-	RIBPeerRemovalSleepInterval time.Duration `validate:"min=10ms"`
+	RIBPeerRemovalSleepInterval time.Duration `validate:"min=10ms" doc:"Time to sleep between two runs of the removal worker"`
 	// RIBPeerRemovalMaxQueue tells how many pending removal requests to keep
-	RIBPeerRemovalMaxQueue int `validate:"min=1"`
+	RIBPeerRemovalMaxQueue int `validate:"min=1" doc:"Maximum number of pending removal requests"`
 	// RIBPeerRemovalBatchRoutes tells how many routes to remove before checking
 	// if we have a higher priority request. This is only if RIB is in memory
 	// mode.
-	RIBPeerRemovalBatchRoutes int `validate:"min=1"`
+	RIBPeerRemovalBatchRoutes int `validate:"min=1" doc:"Number of routes to remove before checking for higher priority requests"`
 }
 
 // DefaultConfiguration represents the default configuration for the BMP server
@@ -16,6 +16,8 @@ type LookupResult struct {
 	ASPath           []uint32
 	Communities      []uint32
 	LargeCommunities []bgp.LargeCommunity
+	NextHop          netip.Addr
+	NetMask          uint8
 }
 
 // Lookup lookups a route for the provided IP address. It favors the
@@ -54,11 +56,18 @@ func (c *Component) Lookup(ip netip.Addr, nh netip.Addr) LookupResult {
 	if len(routes) == 0 {
 		return LookupResult{}
 	}
-	attributes := c.rib.rtas.Get(routes[len(routes)-1].attributes)
+	route := routes[len(routes)-1]
+	attributes := c.rib.rtas.Get(route.attributes)
+	netMask := route.prefixLen
+	if ip.Is4In6() && netMask >= 96 {
+		netMask -= 96
+	}
 	return LookupResult{
 		ASN:              attributes.asn,
 		ASPath:           attributes.asPath,
 		Communities:      attributes.communities,
 		LargeCommunities: attributes.largeCommunities,
+		NextHop:          netip.Addr(c.rib.nextHops.Get(route.nextHop)),
+		NetMask:          netMask,
 	}
 }
@@ -26,13 +26,14 @@ type rib struct {
 }
 
 // route contains the peer (external opaque value), the NLRI, the next
-// hop and route attributes. The primary key is prefix (implied), peer
-// and nlri.
+// hop, route attributes and the prefix length. The primary key is
+// prefix (implied), peer and nlri.
 type route struct {
 	peer       uint32
 	nlri       intern.Reference[nlri]
 	nextHop    intern.Reference[nextHop]
 	attributes intern.Reference[routeAttributes]
+	prefixLen  uint8
 }
 
 // nlri is the NLRI for the route (when combined with prefix). The
@@ -136,6 +137,7 @@ func (rta routeAttributes) Equal(orta routeAttributes) bool {
 // addPrefix add a new route to the RIB. It returns the number of routes really added.
 func (r *rib) addPrefix(ip netip.Addr, bits int, new route) int {
 	v6 := patricia.NewIPv6Address(ip.AsSlice(), uint(bits))
+	new.prefixLen = uint8(bits)
 	added, _ := r.tree.AddOrUpdate(v6, new,
 		func(r1, r2 route) bool {
 			return r1.peer == r2.peer && r1.nlri == r2.nlri
@@ -1111,6 +1111,13 @@ func TestBMP(t *testing.T) {
 		if lookup.ASN != 174 {
 			t.Errorf("Lookup() == %d, expected 174", lookup.ASN)
 		}
+		lookup = c.Lookup(netip.MustParseAddr("::ffff:192.0.2.2"), netip.MustParseAddr("::ffff:198.51.100.8"))
+		if lookup.NextHop != netip.MustParseAddr("::ffff:198.51.100.8") {
+			t.Errorf("Lookup() next hop == %s, expected ::ffff:198.51.100.8", lookup.NextHop)
+		}
+		if lookup.NetMask != 27 {
+			t.Errorf("Lookup() net mask == %d, expected 27", lookup.NetMask)
+		}
 		lookup = c.Lookup(netip.MustParseAddr("::ffff:192.0.2.254"), netip.MustParseAddr("::ffff:198.51.100.200"))
 		if lookup.ASN != 0 {
 			t.Errorf("Lookup() == %d, expected 0", lookup.ASN)
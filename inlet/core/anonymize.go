@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"container/list"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"net/netip"
+	"sync"
+
+	"akvorado/common/helpers/bimap"
+)
+
+// AnonymizationConfiguration defines how source and destination addresses
+// are anonymized before being sent to Kafka.
+type AnonymizationConfiguration struct {
+	// IPv4 is the anonymization for IPv4 addresses.
+	IPv4 AnonymizationFamilyConfiguration `doc:"Anonymization of IPv4 addresses"`
+	// IPv6 is the anonymization for IPv6 addresses.
+	IPv6 AnonymizationFamilyConfiguration `doc:"Anonymization of IPv6 addresses"`
+	// Key is the secret used by Crypto-PAn. It can be of any length.
+	Key string `doc:"Secret key for Crypto-PAn anonymization"`
+	// Exempt is a list of prefixes whose addresses are not anonymized.
+	Exempt []netip.Prefix `doc:"Prefixes whose addresses are not anonymized"`
+	// CacheSize is the number of addresses anonymized with Crypto-PAn to
+	// keep in cache. Each uncached address needs 32 (IPv4) or 128 (IPv6)
+	// AES encryptions.
+	CacheSize int `validate:"min=0" doc:"Number of Crypto-PAn anonymized addresses to keep in cache"`
+}
+
+// AnonymizationFamilyConfiguration defines the anonymization for an address
+// family.
+type AnonymizationFamilyConfiguration struct {
+	// Mode is the anonymization mode (none, truncate, or cryptopan).
+	Mode AnonymizationMode `doc:"Anonymization mode (none, truncate, cryptopan)"`
+	// PrefixLength is the number of bits to keep when truncating.
+	PrefixLength int `validate:"min=0,max=128" doc:"Number of bits to keep when truncating"`
+}
+
+// AnonymizationMode describes how addresses are anonymized.
+type AnonymizationMode int
+
+const (
+	// AnonymizationModeNone keeps addresses as is.
+	AnonymizationModeNone AnonymizationMode = iota
+	// AnonymizationModeTruncate zeroes the host part of addresses.
+	AnonymizationModeTruncate
+	// AnonymizationModeCryptoPAn encrypts addresses in a prefix-preserving way.
+	AnonymizationModeCryptoPAn
+)
+
+var anonymizationModeMap = bimap.New(map[AnonymizationMode]string{
+	AnonymizationModeNone:      "none",
+	AnonymizationModeTruncate:  "truncate",
+	AnonymizationModeCryptoPAn: "cryptopan",
+})
+
+// MarshalText turns an anonymization mode to text.
+func (am AnonymizationMode) MarshalText() ([]byte, error) {
+	got, ok := anonymizationModeMap.LoadValue(am)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown anonymization mode")
+}
+
+// String turns an anonymization mode to string.
+func (am AnonymizationMode) String() string {
+	got, _ := anonymizationModeMap.LoadValue(am)
+	return got
+}
+
+// UnmarshalText provides an anonymization mode from a string.
+func (am *AnonymizationMode) UnmarshalText(input []byte) error {
+	got, ok := anonymizationModeMap.LoadKey(string(input))
+	if ok {
+		*am = got
+		return nil
+	}
+	return errors.New("unknown anonymization mode")
+}
+
+// anonymizer anonymizes IP addresses.
+type anonymizer struct {
+	config AnonymizationConfiguration
+	cipher cipher.Block // nil when Crypto-PAn is not used
+	pad    [16]byte
+
+	// LRU cache for Crypto-PAn results
+	cacheLock  sync.Mutex
+	cacheItems map[netip.Addr]*list.Element
+	cacheLRU   *list.List // most recently used first
+}
+
+// anonymizerCacheItem is an item stored in the Crypto-PAn cache.
+type anonymizerCacheItem struct {
+	original   netip.Addr
+	anonymized netip.Addr
+}
+
+// newAnonymizer creates a new anonymizer. It returns nil when no
+// anonymization is configured.
+func newAnonymizer(config AnonymizationConfiguration) (*anonymizer, error) {
+	if config.IPv4.Mode == AnonymizationModeNone && config.IPv6.Mode == AnonymizationModeNone {
+		return nil, nil
+	}
+	if config.IPv4.Mode == AnonymizationModeTruncate && config.IPv4.PrefixLength > 32 {
+		return nil, errors.New("IPv4 prefix length should be at most 32")
+	}
+	a := &anonymizer{config: config}
+	if config.IPv4.Mode == AnonymizationModeCryptoPAn || config.IPv6.Mode == AnonymizationModeCryptoPAn {
+		if config.Key == "" {
+			return nil, errors.New("a key is needed for Crypto-PAn anonymization")
+		}
+		key := sha256.Sum256([]byte(config.Key))
+		a.initCryptoPAn(key)
+	}
+	return a, nil
+}
+
+// initCryptoPAn initializes the Crypto-PAn cipher from a 32-byte key. The
+// first half is the AES key, the second half is encrypted to get the pad.
+func (a *anonymizer) initCryptoPAn(key [32]byte) {
+	block, err := aes.NewCipher(key[:16])
+	if err != nil {
+		panic(err)
+	}
+	a.cipher = block
+	a.cipher.Encrypt(a.pad[:], key[16:])
+}
+
+// Anonymize returns the anonymized version of the provided address. IPv4
+// addresses may be provided as IPv4-mapped IPv6 addresses. The returned
+// address is in the same form.
+func (a *anonymizer) Anonymize(addr netip.Addr) netip.Addr {
+	if !addr.IsValid() {
+		return addr
+	}
+	unmapped := addr.Unmap()
+	for _, prefix := range a.config.Exempt {
+		if prefix.Contains(unmapped) {
+			return addr
+		}
+	}
+	family := a.config.IPv6
+	if unmapped.Is4() {
+		family = a.config.IPv4
+	}
+	var result netip.Addr
+	switch family.Mode {
+	case AnonymizationModeTruncate:
+		prefix, _ := unmapped.Prefix(family.PrefixLength)
+		result = prefix.Addr()
+	case AnonymizationModeCryptoPAn:
+		result = a.cachedCryptoPAn(unmapped)
+	default:
+		return addr
+	}
+	if addr.Is4In6() {
+		return netip.AddrFrom16(result.As16())
+	}
+	return result
+}
+
+// cachedCryptoPAn anonymizes an address with Crypto-PAn, using the cache
+// when possible.
+func (a *anonymizer) cachedCryptoPAn(addr netip.Addr) netip.Addr {
+	if a.config.CacheSize == 0 {
+		return a.cryptoPAn(addr)
+	}
+	a.cacheLock.Lock()
+	if element, ok := a.cacheItems[addr]; ok {
+		a.cacheLRU.MoveToFront(element)
+		result := element.Value.(*anonymizerCacheItem).anonymized
+		a.cacheLock.Unlock()
+		return result
+	}
+	a.cacheLock.Unlock()
+
+	result := a.cryptoPAn(addr)
+
+	a.cacheLock.Lock()
+	defer a.cacheLock.Unlock()
+	if a.cacheItems == nil {
+		a.cacheItems = make(map[netip.Addr]*list.Element)
+		a.cacheLRU = list.New()
+	}
+	if _, ok := a.cacheItems[addr]; ok {
+		return result
+	}
+	for a.cacheLRU.Len() >= a.config.CacheSize {
+		oldest := a.cacheLRU.Back()
+		a.cacheLRU.Remove(oldest)
+		delete(a.cacheItems, oldest.Value.(*anonymizerCacheItem).original)
+	}
+	a.cacheItems[addr] = a.cacheLRU.PushFront(&anonymizerCacheItem{
+		original:   addr,
+		anonymized: result,
+	})
+	return result
+}
+
+// cryptoPAn anonymizes an address with Crypto-PAn. Two addresses sharing a
+// k-bit prefix are mapped to addresses sharing a k-bit prefix.
+func (a *anonymizer) cryptoPAn(addr netip.Addr) netip.Addr {
+	original := addr.AsSlice()
+	bits := len(original) * 8
+	var input, output, otp [16]byte
+	for pos := 0; pos < bits; pos++ {
+		// The input is made of the first pos bits of the address,
+		// completed with the pad.
+		input = a.pad
+		for i := 0; i < pos/8; i++ {
+			input[i] = original[i]
+		}
+		if rem := pos % 8; rem > 0 {
+			mask := byte(0xff << (8 - rem))
+			input[pos/8] = original[pos/8]&mask | a.pad[pos/8]&^mask
+		}
+		a.cipher.Encrypt(output[:], input[:])
+		otp[pos/8] |= (output[0] >> 7) << (7 - pos%8)
+	}
+	for i := range original {
+		original[i] ^= otp[i]
+	}
+	result, _ := netip.AddrFromSlice(original)
+	return result
+}
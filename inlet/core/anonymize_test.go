@@ -0,0 +1,267 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/bmp"
+	"akvorado/inlet/flow"
+	"akvorado/inlet/geoip"
+	"akvorado/inlet/kafka"
+	"akvorado/inlet/snmp"
+)
+
+func TestCryptoPAn(t *testing.T) {
+	// Test vectors from the reference implementation
+	a := &anonymizer{config: AnonymizationConfiguration{
+		IPv4: AnonymizationFamilyConfiguration{Mode: AnonymizationModeCryptoPAn},
+	}}
+	a.initCryptoPAn([32]byte{
+		21, 34, 23, 141, 51, 164, 207, 128, 19, 10, 91, 22, 73, 144, 125, 16,
+		216, 152, 143, 131, 121, 121, 101, 39, 98, 87, 76, 45, 42, 132, 34, 2,
+	})
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"128.11.68.132", "135.242.180.132"},
+		{"129.118.74.4", "134.136.186.123"},
+		{"130.132.252.244", "133.68.164.234"},
+		{"141.223.7.43", "141.167.8.160"},
+		{"::ffff:141.223.7.43", "::ffff:141.167.8.160"},
+	}
+	for _, tc := range cases {
+		got := a.Anonymize(netip.MustParseAddr(tc.Input))
+		if got.String() != tc.Expected {
+			t.Errorf("Anonymize(%q) = %q, expected %q", tc.Input, got, tc.Expected)
+		}
+	}
+}
+
+func TestAnonymize(t *testing.T) {
+	a, err := newAnonymizer(AnonymizationConfiguration{
+		IPv4: AnonymizationFamilyConfiguration{Mode: AnonymizationModeTruncate, PrefixLength: 24},
+		IPv6: AnonymizationFamilyConfiguration{Mode: AnonymizationModeCryptoPAn},
+		Key:  "secret",
+		Exempt: []netip.Prefix{
+			netip.MustParsePrefix("192.0.2.0/24"),
+			netip.MustParsePrefix("2001:db8:1::/48"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("newAnonymizer() error:\n%+v", err)
+	}
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"::ffff:198.51.100.14", "::ffff:198.51.100.0"},
+		{"203.0.113.200", "203.0.113.0"},
+		{"::ffff:192.0.2.14", "::ffff:192.0.2.14"},
+		{"2001:db8:1::1", "2001:db8:1::1"},
+	}
+	for _, tc := range cases {
+		got := a.Anonymize(netip.MustParseAddr(tc.Input))
+		if got.String() != tc.Expected {
+			t.Errorf("Anonymize(%q) = %q, expected %q", tc.Input, got, tc.Expected)
+		}
+	}
+
+	// IPv6 addresses are encrypted while keeping common prefixes
+	addr1 := a.Anonymize(netip.MustParseAddr("2001:db8:2::1"))
+	addr2 := a.Anonymize(netip.MustParseAddr("2001:db8:2::2"))
+	if addr1 == netip.MustParseAddr("2001:db8:2::1") {
+		t.Errorf("Anonymize() did not modify IPv6 address")
+	}
+	prefix1, _ := addr1.Prefix(126)
+	prefix2, _ := addr2.Prefix(126)
+	if prefix1 != prefix2 {
+		t.Errorf("Anonymize() did not preserve prefix: %s vs %s", addr1, addr2)
+	}
+	if addr1 == addr2 {
+		t.Errorf("Anonymize() returned the same address for two addresses")
+	}
+
+	// No anonymization
+	if a, err := newAnonymizer(AnonymizationConfiguration{}); err != nil || a != nil {
+		t.Errorf("newAnonymizer() = %v, %v, expected nil, nil", a, err)
+	}
+	// Missing key
+	if _, err := newAnonymizer(AnonymizationConfiguration{
+		IPv4: AnonymizationFamilyConfiguration{Mode: AnonymizationModeCryptoPAn},
+	}); err == nil {
+		t.Error("newAnonymizer() did not error without a key")
+	}
+}
+
+func TestCryptoPAnCache(t *testing.T) {
+	a, err := newAnonymizer(AnonymizationConfiguration{
+		IPv4:      AnonymizationFamilyConfiguration{Mode: AnonymizationModeCryptoPAn},
+		Key:       "secret",
+		CacheSize: 2,
+	})
+	if err != nil {
+		t.Fatalf("newAnonymizer() error:\n%+v", err)
+	}
+	addrs := []netip.Addr{
+		netip.MustParseAddr("192.0.2.1"),
+		netip.MustParseAddr("192.0.2.2"),
+		netip.MustParseAddr("192.0.2.3"),
+	}
+	for _, addr := range addrs {
+		got := a.Anonymize(addr)
+		if expected := a.cryptoPAn(addr); got != expected {
+			t.Errorf("Anonymize(%q) = %q, expected %q", addr, got, expected)
+		}
+		// Second time, from cache
+		if got2 := a.Anonymize(addr); got2 != got {
+			t.Errorf("Anonymize(%q) = %q, then %q", addr, got, got2)
+		}
+	}
+	if len(a.cacheItems) != 2 || a.cacheLRU.Len() != 2 {
+		t.Errorf("cache size = %d, expected 2", len(a.cacheItems))
+	}
+	if _, ok := a.cacheItems[addrs[0]]; ok {
+		t.Errorf("cache still contains %s", addrs[0])
+	}
+}
+
+func TestAnonymizeAllAddresses(t *testing.T) {
+	r := reporter.NewMock(t)
+	daemonComponent := daemon.NewMock(t)
+	snmpComponent := snmp.NewMock(t, r, snmp.DefaultConfiguration(),
+		snmp.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	kafkaComponent, kafkaProducer := kafka.NewMock(t, r, kafka.DefaultConfiguration())
+	bmpComponent, _ := bmp.NewMock(t, r, bmp.DefaultConfiguration())
+	schemaComponent, err := schema.New(schema.Configuration{
+		Enabled: []schema.ColumnKey{
+			schema.ColumnNextHop,
+			schema.ColumnSrcAddrNAT, schema.ColumnDstAddrNAT,
+			schema.ColumnSrcAddrInner, schema.ColumnDstAddrInner,
+		},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+
+	configuration := DefaultConfiguration()
+	configuration.Anonymization.IPv4 = AnonymizationFamilyConfiguration{
+		Mode:         AnonymizationModeTruncate,
+		PrefixLength: 24,
+	}
+	c, err := New(r, configuration, Dependencies{
+		Daemon: daemonComponent,
+		Flow:   flowComponent,
+		SNMP:   snmpComponent,
+		GeoIP:  geoip.NewMock(t, r),
+		Kafka:  kafkaComponent,
+		HTTP:   http.NewMock(t, r),
+		BMP:    bmpComponent,
+		Schema: schemaComponent,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	inputFlow := func() *schema.FlowMessage {
+		bf := &schema.FlowMessage{
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+			InIf:            100,
+			OutIf:           200,
+			SrcAddr:         netip.MustParseAddr("::ffff:198.51.100.14"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.15"),
+			NextHop:         netip.MustParseAddr("::ffff:198.51.100.254"),
+		}
+		schemaComponent.ProtobufAppendIP(bf, schema.ColumnSrcAddrNAT,
+			netip.MustParseAddr("::ffff:198.51.100.16"))
+		schemaComponent.ProtobufAppendIP(bf, schema.ColumnDstAddrNAT,
+			netip.MustParseAddr("::ffff:203.0.113.17"))
+		schemaComponent.ProtobufAppendIP(bf, schema.ColumnSrcAddrInner,
+			netip.MustParseAddr("::ffff:198.51.100.18"))
+		schemaComponent.ProtobufAppendIP(bf, schema.ColumnDstAddrInner,
+			netip.MustParseAddr("::ffff:203.0.113.19"))
+		return bf
+	}
+
+	received := make(chan bool)
+	kafkaProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(
+		func(msg *sarama.ProducerMessage) error {
+			defer close(received)
+			b, err := msg.Value.Encode()
+			if err != nil {
+				t.Fatalf("Kafka message encoding error:\n%+v", err)
+			}
+			got, err := c.d.Schema.ProtobufUnmarshal(b)
+			if err != nil {
+				t.Fatalf("ProtobufUnmarshal() error:\n%+v", err)
+			}
+			expected := map[schema.ColumnKey]string{
+				schema.ColumnExporterAddress: "::ffff:192.0.2.142",
+				schema.ColumnSrcAddr:         "::ffff:198.51.100.0",
+				schema.ColumnDstAddr:         "::ffff:203.0.113.0",
+				schema.ColumnNextHop:         "::ffff:198.51.100.0",
+				schema.ColumnSrcAddrNAT:      "::ffff:198.51.100.0",
+				schema.ColumnDstAddrNAT:      "::ffff:203.0.113.0",
+				schema.ColumnSrcAddrInner:    "::ffff:198.51.100.0",
+				schema.ColumnDstAddrInner:    "::ffff:203.0.113.0",
+			}
+			gotAddresses := map[schema.ColumnKey]string{}
+			for key := range expected {
+				if addr, ok := got[key].(netip.Addr); ok {
+					gotAddresses[key] = addr.String()
+				}
+			}
+			if diff := helpers.Diff(gotAddresses, expected); diff != "" {
+				t.Errorf("Anonymized addresses (-got, +want):\n%s", diff)
+			}
+			return nil
+		})
+	// Inject twice since otherwise, we get a cache miss
+	flowComponent.Inject(inputFlow())
+	time.Sleep(50 * time.Millisecond)
+	flowComponent.Inject(inputFlow())
+	select {
+	case <-received:
+	case <-time.After(time.Second):
+		t.Fatal("Kafka message not received")
+	}
+}
+
+func TestAnonymizeWithMACAddresses(t *testing.T) {
+	r := reporter.NewMock(t)
+	schemaComponent, err := schema.New(schema.Configuration{
+		Enabled: []schema.ColumnKey{schema.ColumnSrcMAC, schema.ColumnDstMAC},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	configuration := DefaultConfiguration()
+	configuration.Anonymization.IPv4.Mode = AnonymizationModeTruncate
+	if _, err := New(r, configuration, Dependencies{
+		Daemon: daemon.NewMock(t),
+		Schema: schemaComponent,
+	}); err == nil {
+		t.Fatal("New() did not error with MAC address columns enabled")
+	}
+	configuration.Anonymization.IPv4.Mode = AnonymizationModeNone
+	if _, err := New(r, configuration, Dependencies{
+		Daemon: daemon.NewMock(t),
+		Schema: schemaComponent,
+	}); err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+}
@@ -4,14 +4,19 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"net/netip"
 	"regexp"
+	"sort"
 	"strings"
 	"sync"
 
 	"github.com/antonmedv/expr"
 	"github.com/antonmedv/expr/ast"
 	"github.com/antonmedv/expr/vm"
+
+	"akvorado/common/helpers/bimap"
 )
 
 // Global cache for regular expressions. No boundary.
@@ -27,8 +32,10 @@ type ExporterClassifierRule struct {
 
 // exporterInfo contains the information we want to expose about a exporter.
 type exporterInfo struct {
-	IP   string
-	Name string
+	IP     string
+	Name   string
+	Tenant string
+	Tags   string // comma-separated, see joinTags()
 }
 
 // exporterClassification contains the information about an exporter classification
@@ -44,11 +51,14 @@ type exporterClassification struct {
 type (
 	classifyStringFunc      = func(string) bool
 	classifyStringRegexFunc = func(string, string, string) (bool, error)
+	classifyNamedRegexFunc  = func(string, string) (bool, error)
 )
 
 // exporterClassifierEnvironment defines the environment used by the exporter classifier
 type exporterClassifierEnvironment struct {
 	Format              func(string, ...any) string
+	InSubnet            func(string, string) (bool, error)
+	HasTag              func(string, string) bool
 	Exporter            exporterInfo
 	Classify            classifyStringFunc
 	ClassifyRegex       classifyStringRegexFunc
@@ -62,6 +72,7 @@ type exporterClassifierEnvironment struct {
 	ClassifyRegionRegex classifyStringRegexFunc
 	ClassifyTenant      classifyStringFunc
 	ClassifyTenantRegex classifyStringRegexFunc
+	ClassifyNamedRegex  classifyNamedRegexFunc
 	Reject              func() bool
 }
 
@@ -78,6 +89,8 @@ func (scr *ExporterClassifierRule) exec(si exporterInfo, ec *exporterClassificat
 	classifyTenant := classifyString(&ec.Tenant)
 	env := exporterClassifierEnvironment{
 		Format:              format,
+		InSubnet:            inSubnet,
+		HasTag:              hasTag,
 		Exporter:            si,
 		Classify:            classifyGroup,
 		ClassifyRegex:       withRegex(classifyGroup),
@@ -91,6 +104,21 @@ func (scr *ExporterClassifierRule) exec(si exporterInfo, ec *exporterClassificat
 		ClassifyRegionRegex: withRegex(classifyRegion),
 		ClassifyTenant:      classifyTenant,
 		ClassifyTenantRegex: withRegex(classifyTenant),
+		ClassifyNamedRegex: withNamedRegex(func(name string) func(string) bool {
+			switch name {
+			case "group":
+				return classifyGroup
+			case "role":
+				return classifyRole
+			case "site":
+				return classifySite
+			case "region":
+				return classifyRegion
+			case "tenant":
+				return classifyTenant
+			}
+			return nil
+		}),
 		Reject: func() bool {
 			ec.Reject = true
 			return false
@@ -105,16 +133,21 @@ func (scr *ExporterClassifierRule) exec(si exporterInfo, ec *exporterClassificat
 // UnmarshalText compiles a classification rule for a exporter.
 func (scr *ExporterClassifierRule) UnmarshalText(text []byte) error {
 	regexValidator := regexValidator{}
+	subnetValidator := subnetValidator{}
 	program, err := expr.Compile(string(text),
 		expr.Env(exporterClassifierEnvironment{}),
 		expr.AsBool(),
-		expr.Patch(&regexValidator))
+		expr.Patch(&regexValidator),
+		expr.Patch(&subnetValidator))
 	if err != nil {
 		return fmt.Errorf("cannot compile exporter classifier rule %q: %w", string(text), err)
 	}
 	if len(regexValidator.invalidRegexes) > 0 {
 		return fmt.Errorf("invalid regular expression %q", regexValidator.invalidRegexes[0])
 	}
+	if len(subnetValidator.invalidSubnets) > 0 {
+		return fmt.Errorf("invalid subnet %q", subnetValidator.invalidSubnets[0])
+	}
 	scr.program = program
 	return nil
 }
@@ -141,6 +174,7 @@ type interfaceInfo struct {
 	Description string
 	Speed       uint32
 	VLAN        uint16
+	Tags        string // comma-separated, see joinTags()
 }
 
 // interfaceBoundary tells if an interface is internal or external
@@ -152,29 +186,74 @@ const (
 	internalBoundary
 )
 
+var interfaceBoundaryMap = bimap.New(map[interfaceBoundary]string{
+	undefinedBoundary: "undefined",
+	externalBoundary:  "external",
+	internalBoundary:  "internal",
+})
+
+// MarshalText turns an interface boundary to text.
+func (ib interfaceBoundary) MarshalText() ([]byte, error) {
+	got, ok := interfaceBoundaryMap.LoadValue(ib)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown boundary")
+}
+
+// String turns an interface boundary to string.
+func (ib interfaceBoundary) String() string {
+	got, _ := interfaceBoundaryMap.LoadValue(ib)
+	return got
+}
+
+// UnmarshalText provides an interface boundary from a string. An empty
+// string is accepted as an undefined boundary.
+func (ib *interfaceBoundary) UnmarshalText(input []byte) error {
+	if len(input) == 0 {
+		*ib = undefinedBoundary
+		return nil
+	}
+	got, ok := interfaceBoundaryMap.LoadKey(string(input))
+	if ok {
+		*ib = got
+		return nil
+	}
+	return errors.New("unknown boundary")
+}
+
 // interfaceClassification contains the information about an interface classification
 type interfaceClassification struct {
 	Connectivity string
 	Provider     string
+	BillingClass string
 	Boundary     interfaceBoundary
 	Reject       bool
 	Name         string
 	Description  string
+	Attributes   map[string]string
 }
 
 // interfaceClassifierEnvironment defines the environment used by the interface classifier
 type interfaceClassifierEnvironment struct {
 	Format                    func(string, ...any) string
+	InSubnet                  func(string, string) (bool, error)
+	HasTag                    func(string, string) bool
 	Exporter                  exporterInfo
 	Interface                 interfaceInfo
 	ClassifyConnectivity      classifyStringFunc
 	ClassifyConnectivityRegex classifyStringRegexFunc
 	ClassifyProvider          classifyStringFunc
 	ClassifyProviderRegex     classifyStringRegexFunc
+	ClassifyBillingClass      classifyStringFunc
+	ClassifyBillingClassRegex classifyStringRegexFunc
 	ClassifyExternal          func() bool
 	ClassifyInternal          func() bool
 	SetName                   func(string) bool
 	SetDescription            func(string) bool
+	ClassifyAttribute         func(string, string) bool
+	ClassifyAttributeRegex    func(string, string, string, string) (bool, error)
+	ClassifyNamedRegex        classifyNamedRegexFunc
 	Reject                    func() bool
 }
 
@@ -182,6 +261,7 @@ type interfaceClassifierEnvironment struct {
 func (scr *InterfaceClassifierRule) exec(si exporterInfo, ii interfaceInfo, ic *interfaceClassification) error {
 	classifyConnectivity := classifyString(&ic.Connectivity)
 	classifyProvider := classifyString(&ic.Provider)
+	classifyBillingClass := classifyString(&ic.BillingClass)
 	classifyExternal := func() bool {
 		if ic.Boundary == undefinedBoundary {
 			ic.Boundary = externalBoundary
@@ -206,8 +286,22 @@ func (scr *InterfaceClassifierRule) exec(si exporterInfo, ii interfaceInfo, ic *
 		}
 		return true
 	}
+	classifyAttribute := func(name string) func(string) bool {
+		name = strings.ToLower(name)
+		return func(value string) bool {
+			if ic.Attributes[name] == "" {
+				if ic.Attributes == nil {
+					ic.Attributes = map[string]string{}
+				}
+				ic.Attributes[name] = normalize(value)
+			}
+			return true
+		}
+	}
 	env := interfaceClassifierEnvironment{
 		Format:                    format,
+		InSubnet:                  inSubnet,
+		HasTag:                    hasTag,
 		Exporter:                  si,
 		Interface:                 ii,
 		ClassifyConnectivity:      classifyConnectivity,
@@ -216,8 +310,27 @@ func (scr *InterfaceClassifierRule) exec(si exporterInfo, ii interfaceInfo, ic *
 		ClassifyInternal:          classifyInternal,
 		ClassifyConnectivityRegex: withRegex(classifyConnectivity),
 		ClassifyProviderRegex:     withRegex(classifyProvider),
+		ClassifyBillingClass:      classifyBillingClass,
+		ClassifyBillingClassRegex: withRegex(classifyBillingClass),
 		SetName:                   setName,
 		SetDescription:            setDescription,
+		ClassifyAttribute: func(name string, value string) bool {
+			return classifyAttribute(name)(value)
+		},
+		ClassifyAttributeRegex: func(name string, str string, regex string, template string) (bool, error) {
+			return withRegex(classifyAttribute(name))(str, regex, template)
+		},
+		ClassifyNamedRegex: withNamedRegex(func(name string) func(string) bool {
+			switch name {
+			case "connectivity":
+				return classifyConnectivity
+			case "provider":
+				return classifyProvider
+			case "billingclass":
+				return classifyBillingClass
+			}
+			return classifyAttribute(name)
+		}),
 		Reject: func() bool {
 			ic.Reject = true
 			return false
@@ -232,16 +345,21 @@ func (scr *InterfaceClassifierRule) exec(si exporterInfo, ii interfaceInfo, ic *
 // UnmarshalText compiles a classification rule for an interface.
 func (scr *InterfaceClassifierRule) UnmarshalText(text []byte) error {
 	regexValidator := regexValidator{}
+	subnetValidator := subnetValidator{}
 	program, err := expr.Compile(string(text),
 		expr.Env(interfaceClassifierEnvironment{}),
 		expr.AsBool(),
-		expr.Patch(&regexValidator))
+		expr.Patch(&regexValidator),
+		expr.Patch(&subnetValidator))
 	if err != nil {
 		return fmt.Errorf("cannot compile interface classifier rule %q: %w", string(text), err)
 	}
 	if len(regexValidator.invalidRegexes) > 0 {
 		return fmt.Errorf("invalid regular expression %q", regexValidator.invalidRegexes[0])
 	}
+	if len(subnetValidator.invalidSubnets) > 0 {
+		return fmt.Errorf("invalid subnet %q", subnetValidator.invalidSubnets[0])
+	}
 	scr.program = program
 	return nil
 }
@@ -261,20 +379,9 @@ func (scr InterfaceClassifierRule) MarshalText() ([]byte, error) {
 // with the result of the regex.
 func withRegex(fn func(string) bool) func(string, string, string) (bool, error) {
 	return func(str string, regex string, template string) (bool, error) {
-		// We may have several readers trying to compile the
-		// regex the first time. It's not really important.
-		regexCacheLock.RLock()
-		compiledRegex, ok := regexCache[regex]
-		regexCacheLock.RUnlock()
-		if !ok {
-			var err error
-			compiledRegex, err = regexp.Compile(regex)
-			if err != nil {
-				return false, fmt.Errorf("cannot compile regex %q: %w", regex, err)
-			}
-			regexCacheLock.Lock()
-			regexCache[regex] = compiledRegex
-			regexCacheLock.Unlock()
+		compiledRegex, err := compileRegex(regex)
+		if err != nil {
+			return false, err
 		}
 
 		result := []byte{}
@@ -287,6 +394,54 @@ func withRegex(fn func(string) bool) func(string, string, string) (bool, error)
 	}
 }
 
+// withNamedRegex turns a lookup function returning a classification function
+// for a name into a function taking a string to match a regex with and the
+// regex. Each named group of the regex is classified with the function
+// returned for its name (lowercased). Groups without a function or without a
+// match are ignored. The function returns true when the regex matches.
+func withNamedRegex(lookup func(string) func(string) bool) func(string, string) (bool, error) {
+	return func(str string, regex string) (bool, error) {
+		compiledRegex, err := compileRegex(regex)
+		if err != nil {
+			return false, err
+		}
+
+		matches := compiledRegex.FindStringSubmatch(str)
+		if matches == nil {
+			return false, nil
+		}
+		for i, name := range compiledRegex.SubexpNames() {
+			if name == "" || matches[i] == "" {
+				continue
+			}
+			if fn := lookup(strings.ToLower(name)); fn != nil {
+				fn(matches[i])
+			}
+		}
+		return true, nil
+	}
+}
+
+// compileRegex compiles a regex, using the global cache.
+func compileRegex(regex string) (*regexp.Regexp, error) {
+	// We may have several readers trying to compile the
+	// regex the first time. It's not really important.
+	regexCacheLock.RLock()
+	compiledRegex, ok := regexCache[regex]
+	regexCacheLock.RUnlock()
+	if ok {
+		return compiledRegex, nil
+	}
+	compiledRegex, err := regexp.Compile(regex)
+	if err != nil {
+		return nil, fmt.Errorf("cannot compile regex %q: %w", regex, err)
+	}
+	regexCacheLock.Lock()
+	regexCache[regex] = compiledRegex
+	regexCacheLock.Unlock()
+	return compiledRegex, nil
+}
+
 var normalizeRegex = regexp.MustCompile("[^a-z0-9.+-]+")
 
 // Normalize a string by putting it lowercase and only keeping safe characters
@@ -294,6 +449,42 @@ func normalize(str string) string {
 	return normalizeRegex.ReplaceAllString(strings.ToLower(str), "")
 }
 
+// inSubnet tells if the provided IP address is part of the provided
+// subnet. IPv4-mapped IPv6 addresses are handled as IPv4 addresses.
+func inSubnet(ip string, subnet string) (bool, error) {
+	prefix, err := netip.ParsePrefix(subnet)
+	if err != nil {
+		return false, fmt.Errorf("cannot parse subnet %q: %w", subnet, err)
+	}
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false, nil
+	}
+	return prefix.Contains(addr.Unmap()), nil
+}
+
+// joinTags turns a list of tags into a sorted, comma-separated string. This
+// keeps exporter and interface information comparable, as they are used as
+// cache keys.
+func joinTags(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	tags = append([]string{}, tags...)
+	sort.Strings(tags)
+	return strings.Join(tags, ",")
+}
+
+// hasTag tells if the provided tag is part of the comma-separated tags.
+func hasTag(tags string, tag string) bool {
+	for _, t := range strings.Split(tags, ",") {
+		if t != "" && t == tag {
+			return true
+		}
+	}
+	return false
+}
+
 // classifyString is an helper to classify from string to string
 func classifyString(output *string) func(string) bool {
 	return func(input string) bool {
@@ -317,10 +508,16 @@ func (r *regexValidator) Visit(node *ast.Node) {
 	if !ok {
 		return
 	}
-	if !strings.HasSuffix(identifier.Value, "Regex") || len(n.Arguments) != 3 {
+	if !strings.HasSuffix(identifier.Value, "Regex") || len(n.Arguments) < 2 {
 		return
 	}
-	str, ok := n.Arguments[1].(*ast.StringNode)
+	// The regex is the argument before the template, except for
+	// ClassifyNamedRegex() where there is no template
+	position := len(n.Arguments) - 2
+	if identifier.Value == "ClassifyNamedRegex" {
+		position = len(n.Arguments) - 1
+	}
+	str, ok := n.Arguments[position].(*ast.StringNode)
 	if !ok {
 		return
 	}
@@ -328,3 +525,28 @@ func (r *regexValidator) Visit(node *ast.Node) {
 		r.invalidRegexes = append(r.invalidRegexes, str.Value)
 	}
 }
+
+type subnetValidator struct {
+	invalidSubnets []string
+}
+
+func (r *subnetValidator) Visit(node *ast.Node) {
+	n, ok := (*node).(*ast.CallNode)
+	if !ok {
+		return
+	}
+	identifier, ok := n.Callee.(*ast.IdentifierNode)
+	if !ok {
+		return
+	}
+	if identifier.Value != "InSubnet" || len(n.Arguments) != 2 {
+		return
+	}
+	str, ok := n.Arguments[1].(*ast.StringNode)
+	if !ok {
+		return
+	}
+	if _, err := netip.ParsePrefix(str.Value); err != nil {
+		r.invalidSubnets = append(r.invalidSubnets, str.Value)
+	}
+}
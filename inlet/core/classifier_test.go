@@ -47,48 +47,95 @@ func TestExporterClassifier(t *testing.T) {
 		}, {
 			Description:            "access to exporter name",
 			Program:                `Exporter.Name startsWith "expo" && Classify("europe")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe"},
 		}, {
 			Description:            "matches",
 			Program:                `Exporter.Name matches "^e.p.r" && Classify("europe")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe"},
 		}, {
 			Description: "multiline",
 			Program: `Exporter.Name matches "^e.p.r" &&
 Classify("europe")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe"},
 		}, {
 			Description:            "regex",
 			Program:                `ClassifyRegex(Exporter.Name, "^(e.p+).r", "europe-$1")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe-exp"},
 		}, {
 			Description:            "regex with class",
 			Program:                `ClassifyRegex(Exporter.Name, "^(\\w+).r", "europe-$1")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: "europe-export"},
 		}, {
 			Description:            "non-matching regex",
 			Program:                `ClassifyRegex(Exporter.Name, "^(ebp+).r", "europe-$1")`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Group: ""},
+		}, {
+			Description:  "regex with named groups",
+			Program:      `ClassifyNamedRegex(Exporter.Name, "^(?P<role>[a-z]+)[0-9]+\\.(?P<site>[a-z]+)\\.(?P<unknown>.*)")`,
+			ExporterInfo: exporterInfo{IP: "127.0.0.1", Name: "edge1.par.example.com"},
+			ExpectedClassification: exporterClassification{
+				Role: "edge",
+				Site: "par",
+			},
 		}, {
 			Description:            "reject",
 			Program:                `ClassifyTenant("mobile") && Reject()`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{Tenant: "mobile", Reject: true},
 		}, {
 			Description:            "selective reject",
 			Program:                `Exporter.Name startsWith "nothing" && Reject()`,
-			ExporterInfo:           exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
+			ExpectedClassification: exporterClassification{},
+		}, {
+			Description:            "match exporter subnet",
+			Program:                `InSubnet(Exporter.IP, "127.0.0.0/8") && ClassifySite("local")`,
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
+			ExpectedClassification: exporterClassification{Site: "local"},
+		}, {
+			Description:            "do not match exporter subnet",
+			Program:                `InSubnet(Exporter.IP, "192.0.2.0/24") && ClassifySite("local")`,
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedClassification: exporterClassification{},
+		}, {
+			Description:            "match exporter IPv6 subnet",
+			Program:                `InSubnet(Exporter.IP, "2001:db8::/32") && ClassifyRegion("europe")`,
+			ExporterInfo:           exporterInfo{IP: "2001:db8::1", Name: "exporter"},
+			ExpectedClassification: exporterClassification{Region: "europe"},
+		}, {
+			Description:            "match exporter subnet with IPv4-mapped address",
+			Program:                `InSubnet(Exporter.IP, "192.0.2.0/24") && ClassifyTenant("team-a")`,
+			ExporterInfo:           exporterInfo{IP: "::ffff:192.0.2.10", Name: "exporter"},
+			ExpectedClassification: exporterClassification{Tenant: "team-a"},
+		}, {
+			Description:            "classify tenant from NetBox",
+			Program:                `Exporter.Tenant != "" && ClassifyTenant(Exporter.Tenant)`,
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter", Tenant: "Team B"},
+			ExpectedClassification: exporterClassification{Tenant: "teamb"},
+		}, {
+			Description:            "classify role from tags",
+			Program:                `HasTag(Exporter.Tags, "edge") && ClassifyRole("edge")`,
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter", Tags: "edge,paris"},
+			ExpectedClassification: exporterClassification{Role: "edge"},
+		}, {
+			Description:            "do not match tag prefix",
+			Program:                `HasTag(Exporter.Tags, "par") && ClassifySite("paris")`,
+			ExporterInfo:           exporterInfo{IP: "127.0.0.1", Name: "exporter", Tags: "edge,paris"},
+			ExpectedClassification: exporterClassification{},
+		}, {
+			Description: "faulty subnet",
+			Program:     `InSubnet(Exporter.IP, "192.0.2.0/33") && ClassifySite("local")`,
+			ExpectedErr: true,
 		}, {
 			Description:  "faulty regex",
 			Program:      `ClassifyRegex(Exporter.Name, "^(ebp+.r", "europe-$1")`,
-			ExporterInfo: exporterInfo{"127.0.0.1", "exporter"},
+			ExporterInfo: exporterInfo{IP: "127.0.0.1", Name: "exporter"},
 			ExpectedErr:  true,
 		}, {
 			Description: "syntax error",
@@ -153,6 +200,16 @@ func TestInterfaceClassifier(t *testing.T) {
 			Description:            "constant classifier for provider",
 			Program:                `ClassifyProvider("Telia")`,
 			ExpectedClassification: interfaceClassification{Provider: "telia"},
+		}, {
+			Description: "billing class from connectivity",
+			Program: `
+(Interface.Description startsWith "PNI:" && ClassifyBillingClass("settlement-free")) ||
+ClassifyBillingClassRegex(Interface.Description, "^(Transit|Paid):", "$1")
+`,
+			InterfaceInfo: interfaceInfo{Description: "Paid: Netflix"},
+			ExpectedClassification: interfaceClassification{
+				BillingClass: "paid",
+			},
 		}, {
 			Description:            "constant classifier for boundary external",
 			Program:                `ClassifyExternal()`,
@@ -205,6 +262,45 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")
 				Provider:     "telia",
 				Boundary:     externalBoundary,
 			},
+		}, {
+			Description: "classify attributes",
+			Program:     `ClassifyAttribute("Role", "Peering") && ClassifyAttribute("role", "transit") && ClassifyAttribute("tier", "1")`,
+			ExpectedClassification: interfaceClassification{
+				Attributes: map[string]string{
+					"role": "peering",
+					"tier": "1",
+				},
+			},
+		}, {
+			Description:   "classify attribute with regex",
+			Program:       `ClassifyAttributeRegex("pop", Interface.Name, "^([a-z]+)[0-9]+-", "$1")`,
+			InterfaceInfo: interfaceInfo{Name: "par1-et-0/0/1"},
+			ExpectedClassification: interfaceClassification{
+				Attributes: map[string]string{"pop": "par"},
+			},
+		}, {
+			Description: "classify attribute with an invalid regex",
+			Program:     `ClassifyAttributeRegex("pop", Interface.Name, "^(ebp+.*", "$1")`,
+			ExpectedErr: true,
+		}, {
+			Description: "classify with named groups",
+			Program: `ClassifyNamedRegex(Interface.Description,
+  "^(?P<connectivity>[A-Za-z]+): (?P<provider>[^ ]+) \\[(?P<Circuit>[^]]+)\\](?: (?P<pop>[a-z]+))?")`,
+			InterfaceInfo: interfaceInfo{Description: "Transit: Telia [CID-1234]"},
+			ExpectedClassification: interfaceClassification{
+				Connectivity: "transit",
+				Provider:     "telia",
+				Attributes:   map[string]string{"circuit": "cid-1234"},
+			},
+		}, {
+			Description:            "classify with named groups without a match",
+			Program:                `ClassifyNamedRegex(Interface.Description, "^(?P<provider>[^ ]+):") || ClassifyInternal()`,
+			InterfaceInfo:          interfaceInfo{Description: "Core link"},
+			ExpectedClassification: interfaceClassification{Boundary: internalBoundary},
+		}, {
+			Description: "classify with named groups and an invalid regex",
+			Program:     `ClassifyNamedRegex(Interface.Description, "^(?P<provider>[^ ]+")`,
+			ExpectedErr: true,
 		}, {
 			Description: "classify with VLANs",
 			Program:     `Interface.VLAN == 100 && ClassifyExternal()`,
@@ -229,6 +325,19 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")
 			ExpectedClassification: interfaceClassification{
 				Boundary: undefinedBoundary,
 			},
+		}, {
+			Description: "classify with tags",
+			Program:     `HasTag(Interface.Tags, "transit") && ClassifyConnectivity("transit") && ClassifyExternal()`,
+			InterfaceInfo: interfaceInfo{
+				Name:        "Gi0/0/0",
+				Description: "Telia",
+				Speed:       1000,
+				Tags:        "customer-facing,transit",
+			},
+			ExpectedClassification: interfaceClassification{
+				Connectivity: "transit",
+				Boundary:     externalBoundary,
+			},
 		},
 	}
 	for _, tc := range cases {
@@ -265,6 +374,8 @@ func TestRegexValidation(t *testing.T) {
 		{`ClassifyRegex("something", "^(ebp+.r", "europe-$1")`, true},
 		// When non-constant string is used, we cannot detect the error
 		{`ClassifyRegex("something", Exporter.Name + "^(ebp+.r", "europe-$1")`, false},
+		{`ClassifyNamedRegex("something", "^(?P<site>ebp+).r")`, false},
+		{`ClassifyNamedRegex("something", "^(?P<site>ebp+.r")`, true},
 	}
 	for _, tc := range cases {
 		var scr ExporterClassifierRule
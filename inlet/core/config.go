@@ -18,19 +18,60 @@ import (
 // Configuration describes the configuration for the core component.
 type Configuration struct {
 	// Number of workers for the core component
-	Workers int `validate:"min=1"`
+	Workers int `validate:"min=1" doc:"Number of workers"`
 	// ExporterClassifiers defines rules for exporter classification
-	ExporterClassifiers []ExporterClassifierRule
+	ExporterClassifiers []ExporterClassifierRule `doc:"Rules to classify exporters"`
 	// InterfaceClassifiers defines rules for interface classification
-	InterfaceClassifiers []InterfaceClassifierRule
+	InterfaceClassifiers []InterfaceClassifierRule `doc:"Rules to classify interfaces"`
 	// ClassifierCacheDuration defines the default TTL for classifier cache
-	ClassifierCacheDuration time.Duration `validate:"min=1s"`
+	ClassifierCacheDuration time.Duration `validate:"min=1s" doc:"How long to keep classification results"`
 	// DefaultSamplingRate defines the default sampling rate to use when the information is missing
-	DefaultSamplingRate helpers.SubnetMap[uint]
+	DefaultSamplingRate helpers.SubnetMap[uint] `doc:"Sampling rate when missing, as a value or a mapping from subnets"`
 	// OverrideSamplingRate defines a sampling rate to use instead of the received on
-	OverrideSamplingRate helpers.SubnetMap[uint]
+	OverrideSamplingRate helpers.SubnetMap[uint] `doc:"Sampling rate to use instead of the received one, as a value or a mapping from subnets"`
 	// ASNProviders defines the source used to get AS numbers
-	ASNProviders []ASNProvider `validate:"dive"`
+	ASNProviders []ASNProvider `validate:"dive" doc:"Sources for AS numbers (flow, flow-except-private, bmp, bmp-except-private, geoip)"`
+	// RoutingProviders defines the source used to get AS paths and
+	// communities. The first source with routing information is used.
+	RoutingProviders []RoutingProvider `validate:"dive" doc:"Sources for AS paths and communities (flow, bmp)"`
+	// TrafficClasses maps BGP communities of the destination route to a traffic class
+	TrafficClasses []TrafficClassRule `validate:"dive" doc:"Rules mapping BGP communities of the destination route to a traffic class"`
+	// DefaultTrafficClass is the traffic class when no community matches
+	DefaultTrafficClass string `doc:"Traffic class when no rule matches"`
+	// ExportDirectionPolicy tells which flows to keep depending on the
+	// direction they were observed by the exporter
+	ExportDirectionPolicy helpers.SubnetMap[ExportDirectionPolicy] `doc:"Flows to keep depending on their export direction (both, ingress, egress), as a value or a mapping from subnets"`
+	// DirectionNormalization tells which side of a flow crossing the
+	// network boundary should be the source
+	DirectionNormalization helpers.SubnetMap[DirectionNormalization] `doc:"Side to use as the source for flows crossing the boundary (none, external-as-source, internal-as-source), as a value or a mapping from subnets"`
+	// VRFNames maps VRF IDs to their names, globally or for each
+	// exporter subnet.
+	VRFNames helpers.SubnetMap[map[uint32]string] `doc:"Mapping from VRF IDs to names, as a value or a mapping from subnets"`
+	// ExporterTags attaches static tags to flows, globally or for each
+	// exporter subnet. Each tag is stored in the custom dimension named
+	// after it, prefixed with Exporter.
+	ExporterTags helpers.SubnetMap[map[string]string] `doc:"Static tags to attach to flows, as a value or a mapping from exporter subnets"`
+	// ThroughputSeriesLimit is the maximum number of exporter and
+	// boundaries combinations tracked by the in-memory throughput counters
+	ThroughputSeriesLimit int `validate:"min=0" doc:"Maximum number of series for in-memory throughput counters (0 to disable)"`
+	// StaticInterfaceMetadataFile is the file where static interface
+	// metadata imported through the API is persisted. It is reloaded when
+	// modified.
+	StaticInterfaceMetadataFile string `doc:"File to persist static interface metadata imported through the API"`
+	// ThreatLists maps names of threat lists to their source. Flows whose
+	// source or destination address is in a list are tagged with its name.
+	ThreatLists map[string]ThreatListSource `validate:"dive" doc:"Lists of IP addresses and subnets to tag flows with, indexed by name"`
+	// Anonymization defines how source and destination addresses are
+	// anonymized before being sent to Kafka.
+	Anonymization AnonymizationConfiguration `doc:"Anonymization of source and destination addresses"`
+	// APIToken is the bearer token needed to use the administrative
+	// endpoints of the API, like flushing caches. When empty, they are
+	// disabled.
+	APIToken string `doc:"Bearer token for administrative API endpoints (disabled when empty)"`
+	// OrchestratorURL is the base URL of the orchestrator. When not empty,
+	// the hash of the active rule set is reported to it. It is set when
+	// the configuration is fetched from the orchestrator.
+	OrchestratorURL string `yaml:"-" mapstructure:"-"`
 
 	// Old configuration settings
 	classifierCacheSize uint
@@ -44,6 +85,14 @@ func DefaultConfiguration() Configuration {
 		InterfaceClassifiers:    []InterfaceClassifierRule{},
 		ClassifierCacheDuration: 5 * time.Minute,
 		ASNProviders:            []ASNProvider{ASNProviderFlow, ASNProviderBMP, ASNProviderGeoIP},
+		RoutingProviders:        []RoutingProvider{RoutingProviderFlow, RoutingProviderBMP},
+		TrafficClasses:          []TrafficClassRule{},
+		ThroughputSeriesLimit:   1000,
+		Anonymization: AnonymizationConfiguration{
+			IPv4:      AnonymizationFamilyConfiguration{PrefixLength: 24},
+			IPv6:      AnonymizationFamilyConfiguration{PrefixLength: 48},
+			CacheSize: 100000,
+		},
 	}
 }
 
@@ -96,6 +145,139 @@ func (ap *ASNProvider) UnmarshalText(input []byte) error {
 	return errors.New("unknown provider")
 }
 
+// RoutingProvider describes one provider for AS paths and communities.
+type RoutingProvider int
+
+const (
+	// RoutingProviderFlow uses the AS path and communities embedded in
+	// flows (sFlow extended gateway records).
+	RoutingProviderFlow RoutingProvider = iota
+	// RoutingProviderBMP uses the AS path and communities from BMP.
+	RoutingProviderBMP
+)
+
+var routingProviderMap = bimap.New(map[RoutingProvider]string{
+	RoutingProviderFlow: "flow",
+	RoutingProviderBMP:  "bmp",
+})
+
+// MarshalText turns a routing provider to text.
+func (rp RoutingProvider) MarshalText() ([]byte, error) {
+	got, ok := routingProviderMap.LoadValue(rp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown field")
+}
+
+// String turns a routing provider to string.
+func (rp RoutingProvider) String() string {
+	got, _ := routingProviderMap.LoadValue(rp)
+	return got
+}
+
+// UnmarshalText provides a routing provider from a string.
+func (rp *RoutingProvider) UnmarshalText(input []byte) error {
+	got, ok := routingProviderMap.LoadKey(string(input))
+	if ok {
+		*rp = got
+		return nil
+	}
+	return errors.New("unknown provider")
+}
+
+// ExportDirectionPolicy tells which flows to keep depending on the direction
+// they were observed by the exporter. Flows without direction are always
+// kept.
+type ExportDirectionPolicy int
+
+const (
+	// ExportDirectionPolicyBoth keeps flows in both directions.
+	ExportDirectionPolicyBoth ExportDirectionPolicy = iota
+	// ExportDirectionPolicyIngress only keeps flows observed on ingress.
+	ExportDirectionPolicyIngress
+	// ExportDirectionPolicyEgress only keeps flows observed on egress.
+	ExportDirectionPolicyEgress
+)
+
+var exportDirectionPolicyMap = bimap.New(map[ExportDirectionPolicy]string{
+	ExportDirectionPolicyBoth:    "both",
+	ExportDirectionPolicyIngress: "ingress",
+	ExportDirectionPolicyEgress:  "egress",
+})
+
+// MarshalText turns an export direction policy to text.
+func (edp ExportDirectionPolicy) MarshalText() ([]byte, error) {
+	got, ok := exportDirectionPolicyMap.LoadValue(edp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown export direction policy")
+}
+
+// String turns an export direction policy to string.
+func (edp ExportDirectionPolicy) String() string {
+	got, _ := exportDirectionPolicyMap.LoadValue(edp)
+	return got
+}
+
+// UnmarshalText provides an export direction policy from a string.
+func (edp *ExportDirectionPolicy) UnmarshalText(input []byte) error {
+	got, ok := exportDirectionPolicyMap.LoadKey(string(input))
+	if ok {
+		*edp = got
+		return nil
+	}
+	return errors.New("unknown export direction policy")
+}
+
+// DirectionNormalization tells which side of a flow crossing the network
+// boundary should be the source. Flows going in the other direction have
+// their source and destination swapped.
+type DirectionNormalization int
+
+const (
+	// DirectionNormalizationNone keeps flows as is.
+	DirectionNormalizationNone DirectionNormalization = iota
+	// DirectionNormalizationExternalAsSource puts the external side as the
+	// source.
+	DirectionNormalizationExternalAsSource
+	// DirectionNormalizationInternalAsSource puts the internal side as the
+	// source.
+	DirectionNormalizationInternalAsSource
+)
+
+var directionNormalizationMap = bimap.New(map[DirectionNormalization]string{
+	DirectionNormalizationNone:             "none",
+	DirectionNormalizationExternalAsSource: "external-as-source",
+	DirectionNormalizationInternalAsSource: "internal-as-source",
+})
+
+// MarshalText turns a direction normalization to text.
+func (dn DirectionNormalization) MarshalText() ([]byte, error) {
+	got, ok := directionNormalizationMap.LoadValue(dn)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown direction normalization")
+}
+
+// String turns a direction normalization to string.
+func (dn DirectionNormalization) String() string {
+	got, _ := directionNormalizationMap.LoadValue(dn)
+	return got
+}
+
+// UnmarshalText provides a direction normalization from a string.
+func (dn *DirectionNormalization) UnmarshalText(input []byte) error {
+	got, ok := directionNormalizationMap.LoadKey(string(input))
+	if ok {
+		*dn = got
+		return nil
+	}
+	return errors.New("unknown direction normalization")
+}
+
 // ConfigurationUnmarshallerHook normalize core configuration:
 //   - replace ignore-asn-from-flow by asn-providers
 func ConfigurationUnmarshallerHook() mapstructure.DecodeHookFunc {
@@ -137,4 +319,8 @@ func ConfigurationUnmarshallerHook() mapstructure.DecodeHookFunc {
 func init() {
 	helpers.RegisterMapstructureUnmarshallerHook(ConfigurationUnmarshallerHook())
 	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[uint]())
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[ExportDirectionPolicy]())
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[DirectionNormalization]())
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[map[uint32]string]())
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[map[string]string]())
 }
@@ -4,6 +4,8 @@
 package core
 
 import (
+	"fmt"
+	"reflect"
 	"testing"
 
 	"akvorado/common/helpers"
@@ -70,6 +72,77 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 			Expected: Configuration{
 				ASNProviders: []ASNProvider{ASNProviderFlowExceptPrivate, ASNProviderGeoIP, ASNProviderFlow},
 			},
+		}, {
+			Description: "traffic classes",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"traffic-classes": []gin.H{
+						{"community": "65000:100", "class": "backbone"},
+						{"community": "65000:1:*", "class": "cache-fill"},
+					},
+					"default-traffic-class": "other",
+				}
+			},
+			Expected: Configuration{
+				TrafficClasses: []TrafficClassRule{
+					{Community: mustParseCommunityPattern("65000:100"), Class: "backbone"},
+					{Community: mustParseCommunityPattern("65000:1:*"), Class: "cache-fill"},
+				},
+				DefaultTrafficClass: "other",
+			},
+		}, {
+			Description: "invalid traffic class community",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"traffic-classes": []gin.H{
+						{"community": "65000:100:1:1", "class": "backbone"},
+					},
+				}
+			},
+			Error: true,
+		}, {
+			Description: "export direction policy as a value",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"export-direction-policy": "egress",
+				}
+			},
+			Expected: Configuration{
+				ExportDirectionPolicy: *helpers.MustNewSubnetMap(map[string]ExportDirectionPolicy{
+					"::/0": ExportDirectionPolicyEgress,
+				}),
+			},
+		}, {
+			Description: "export direction policy as a map",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"export-direction-policy": gin.H{
+						"192.0.2.0/24":    "ingress",
+						"2001:db8::/64":   "egress",
+						"198.51.100.0/24": "both",
+					},
+				}
+			},
+			Expected: Configuration{
+				ExportDirectionPolicy: *helpers.MustNewSubnetMap(map[string]ExportDirectionPolicy{
+					"::ffff:192.0.2.0/120":    ExportDirectionPolicyIngress,
+					"2001:db8::/64":           ExportDirectionPolicyEgress,
+					"::ffff:198.51.100.0/120": ExportDirectionPolicyBoth,
+				}),
+			},
+		}, {
+			Description: "invalid export direction policy",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"export-direction-policy": "sideways",
+				}
+			},
+			Error: true,
 		},
-	})
+	}, helpers.DiffFormatter(reflect.TypeOf(CommunityPattern{}), fmt.Sprint))
 }
@@ -0,0 +1,42 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"context"
+	"time"
+
+	"akvorado/common/schema"
+)
+
+// DryRun enriches a single flow like a worker would do, without sending it to
+// Kafka. The component does not need to be started, but the SNMP component
+// should be. Interfaces missing from the SNMP cache are polled until they
+// are found or the context is done. It returns true when the flow would have
+// been skipped.
+func (c *Component) DryRun(ctx context.Context, flow *schema.FlowMessage) (bool, error) {
+	if c.config.StaticInterfaceMetadataFile != "" {
+		if err := c.loadStaticMetadata(); err != nil {
+			return false, err
+		}
+	}
+	for _, ifIndex := range []uint32{flow.InIf, flow.OutIf} {
+		if ifIndex == 0 {
+			continue
+		}
+	poll:
+		for {
+			if _, _, ok := c.d.SNMP.Lookup(time.Now(), flow.ExporterAddress, uint(ifIndex)); ok {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				break poll
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+	}
+	timer := c.startStages(false)
+	return c.enrichFlow(flow.ExporterAddress, flow.ExporterAddress.Unmap().String(), flow, &timer), nil
+}
@@ -5,10 +5,13 @@ package core
 
 import (
 	"net/netip"
+	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"akvorado/common/schema"
+	"akvorado/inlet/bmp"
 )
 
 // exporterAndInterfaceInfo aggregates both exporter info and interface info
@@ -17,15 +20,41 @@ type exporterAndInterfaceInfo struct {
 	Interface interfaceInfo
 }
 
-// enrichFlow adds more data to a flow.
-func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *schema.FlowMessage) (skip bool) {
-	var flowExporterName string
+// geoColumns are the columns to fill with the location of an address.
+type geoColumns struct {
+	country, city, region, latitude, longitude schema.ColumnKey
+}
+
+var (
+	srcGeoColumns = geoColumns{
+		country:   schema.ColumnSrcCountry,
+		city:      schema.ColumnSrcCity,
+		region:    schema.ColumnSrcRegion,
+		latitude:  schema.ColumnSrcLatitude,
+		longitude: schema.ColumnSrcLongitude,
+	}
+	dstGeoColumns = geoColumns{
+		country:   schema.ColumnDstCountry,
+		city:      schema.ColumnDstCity,
+		region:    schema.ColumnDstRegion,
+		latitude:  schema.ColumnDstLatitude,
+		longitude: schema.ColumnDstLongitude,
+	}
+)
+
+// enrichFlow adds more data to a flow. The provided timer measures the time
+// spent in each stage.
+func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *schema.FlowMessage, timer *stageTimer) (skip bool) {
+	var flowExporterName, flowExporterTenant, flowExporterTags string
 	var flowInIfName, flowInIfDescription, flowOutIfName, flowOutIfDescription string
+	var flowInIfTags, flowOutIfTags string
 	var flowInIfSpeed, flowOutIfSpeed, flowInIfIndex, flowOutIfIndex uint32
 	var flowInIfVlan, flowOutIfVlan uint16
 
 	t := time.Now() // only call it once
 
+	// Stage: metadata
+
 	if flow.InIf != 0 {
 		exporterName, iface, ok := c.d.SNMP.Lookup(t, exporterIP, uint(flow.InIf))
 		if !ok {
@@ -33,11 +62,14 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 			skip = true
 		} else {
 			flowExporterName = exporterName
+			flowExporterTenant = iface.ExporterTenant
+			flowExporterTags = joinTags(iface.ExporterTags)
 			flowInIfIndex = flow.InIf
 			flowInIfName = iface.Name
 			flowInIfDescription = iface.Description
 			flowInIfSpeed = uint32(iface.Speed)
 			flowInIfVlan = flow.SrcVlan
+			flowInIfTags = joinTags(iface.Tags)
 		}
 	}
 
@@ -52,25 +84,35 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 			}
 		} else {
 			flowExporterName = exporterName
+			flowExporterTenant = iface.ExporterTenant
+			flowExporterTags = joinTags(iface.ExporterTags)
 			flowOutIfIndex = flow.OutIf
 			flowOutIfName = iface.Name
 			flowOutIfDescription = iface.Description
 			flowOutIfSpeed = uint32(iface.Speed)
 			flowOutIfVlan = flow.DstVlan
+			flowOutIfTags = joinTags(iface.Tags)
 		}
 	}
 
-	// We need at least one of them.
-	if flow.OutIf == 0 && flow.InIf == 0 {
+	// We need at least one of them, unless the flow was already enriched by
+	// another inlet.
+	if flow.OutIf == 0 && flow.InIf == 0 &&
+		!c.d.Schema.ProtobufIsSet(flow, schema.ColumnInIfName) &&
+		!c.d.Schema.ProtobufIsSet(flow, schema.ColumnOutIfName) {
 		c.metrics.flowsErrors.WithLabelValues(exporterStr, "input and output interfaces missing").Inc()
 		skip = true
 	}
 
 	if samplingRate, ok := c.config.OverrideSamplingRate.Lookup(exporterIP); ok && samplingRate > 0 {
+		if flow.SamplingRate != uint32(samplingRate) {
+			c.metrics.samplingRateAdjustments.WithLabelValues(exporterStr, "override").Inc()
+		}
 		flow.SamplingRate = uint32(samplingRate)
 	}
 	if flow.SamplingRate == 0 {
 		if samplingRate, ok := c.config.DefaultSamplingRate.Lookup(exporterIP); ok && samplingRate > 0 {
+			c.metrics.samplingRateAdjustments.WithLabelValues(exporterStr, "default").Inc()
 			flow.SamplingRate = uint32(samplingRate)
 		} else {
 			c.metrics.flowsErrors.WithLabelValues(exporterStr, "sampling rate missing").Inc()
@@ -78,37 +120,92 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 		}
 	}
 
+	// Flows observed in the unwanted direction are dropped
+	switch c.config.ExportDirectionPolicy.LookupOrDefault(exporterIP, ExportDirectionPolicyBoth) {
+	case ExportDirectionPolicyIngress:
+		if flow.ExportDirection == schema.FlowExportDirectionEgress {
+			skip = true
+		}
+	case ExportDirectionPolicyEgress:
+		if flow.ExportDirection == schema.FlowExportDirectionIngress {
+			skip = true
+		}
+	}
+
 	if skip {
+		timer.drop(stageMetadata)
 		return
 	}
+	timer.done(stageMetadata)
 
-	// Classification
-	if !c.classifyExporter(t, exporterStr, flowExporterName, flow) ||
-		!c.classifyInterface(t, exporterStr, flowExporterName, flow,
-			flowOutIfIndex, flowOutIfName, flowOutIfDescription, flowOutIfSpeed, flowOutIfVlan,
-			false) ||
-		!c.classifyInterface(t, exporterStr, flowExporterName, flow,
-			flowInIfIndex, flowInIfName, flowInIfDescription, flowInIfSpeed, flowInIfVlan,
-			true) {
+	// Stage: classification
+	si := exporterInfo{
+		IP:     exporterStr,
+		Name:   flowExporterName,
+		Tenant: flowExporterTenant,
+		Tags:   flowExporterTags,
+	}
+	if !c.classifyExporter(t, si, flow) ||
+		!c.classifyInterface(t, si, flow, interfaceInfo{
+			Index:       flowOutIfIndex,
+			Name:        flowOutIfName,
+			Description: flowOutIfDescription,
+			Speed:       flowOutIfSpeed,
+			VLAN:        flowOutIfVlan,
+			Tags:        flowOutIfTags,
+		}, false) ||
+		!c.classifyInterface(t, si, flow, interfaceInfo{
+			Index:       flowInIfIndex,
+			Name:        flowInIfName,
+			Description: flowInIfDescription,
+			Speed:       flowInIfSpeed,
+			VLAN:        flowInIfVlan,
+			Tags:        flowInIfTags,
+		}, true) {
 		// Flow is rejected
+		timer.drop(stageClassification)
 		return true
 	}
 
+	// Direction normalization happens before enrichment using addresses
+	if c.normalizeDirection(exporterIP, exporterStr, flow) {
+		flowInIfSpeed, flowOutIfSpeed = flowOutIfSpeed, flowInIfSpeed
+	}
+	c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnExporterName, []byte(flowExporterName))
+	c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnInIfSpeed, uint64(flowInIfSpeed))
+	c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnOutIfSpeed, uint64(flowOutIfSpeed))
+	if c.vrfNames {
+		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnSrcVRF, []byte(c.vrfName(exporterIP, flow.SrcVRFID)))
+		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnDstVRF, []byte(c.vrfName(exporterIP, flow.DstVRFID)))
+	}
+	if tags, ok := c.config.ExporterTags.Lookup(exporterIP); ok {
+		for name, value := range tags {
+			if column, ok := c.exporterTags[strings.ToLower(name)]; ok {
+				column.ProtobufAppendBytes(flow, []byte(value))
+			}
+		}
+	}
+	timer.done(stageClassification)
+
+	// Stage: routing
 	sourceBMP := c.d.BMP.Lookup(flow.SrcAddr, netip.Addr{})
 	destBMP := c.d.BMP.Lookup(flow.DstAddr, flow.NextHop)
 	flow.SrcAS = c.getASNumber(flow.SrcAddr, flow.SrcAS, sourceBMP.ASN)
 	flow.DstAS = c.getASNumber(flow.DstAddr, flow.DstAS, destBMP.ASN)
-	c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnSrcCountry, []byte(c.d.GeoIP.LookupCountry(flow.SrcAddr)))
-	c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnDstCountry, []byte(c.d.GeoIP.LookupCountry(flow.DstAddr)))
-	for _, comm := range destBMP.Communities {
+	routing := c.getRouting(flow, destBMP)
+	// Prefix lengths from the flow have precedence over the ones from BMP
+	c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnSrcNetMask, uint64(sourceBMP.NetMask))
+	c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDstNetMask, uint64(destBMP.NetMask))
+	if len(sourceBMP.ASPath) > 0 {
+		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnSrc1stAS, uint64(sourceBMP.ASPath[0]))
+	}
+	for _, comm := range routing.Communities {
 		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDstCommunities, uint64(comm))
 	}
-	if !flow.GotASPath {
-		for _, asn := range destBMP.ASPath {
-			c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDstASPath, uint64(asn))
-		}
+	for _, asn := range routing.ASPath {
+		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnDstASPath, uint64(asn))
 	}
-	for _, comm := range destBMP.LargeCommunities {
+	for _, comm := range routing.LargeCommunities {
 		c.d.Schema.ProtobufAppendVarintForce(flow,
 			schema.ColumnDstLargeCommunitiesASN, uint64(comm.ASN))
 		c.d.Schema.ProtobufAppendVarintForce(flow,
@@ -116,14 +213,73 @@ func (c *Component) enrichFlow(exporterIP netip.Addr, exporterStr string, flow *
 		c.d.Schema.ProtobufAppendVarintForce(flow,
 			schema.ColumnDstLargeCommunitiesLocalData2, uint64(comm.LocalData2))
 	}
+	if !c.d.Schema.IsDisabled(schema.ColumnGroupTrafficClass) {
+		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnDstTrafficClass,
+			[]byte(c.classifyTraffic(routing.Communities, routing.LargeCommunities)))
+	}
+	nextHop := flow.NextHop
+	if !nextHop.IsValid() || nextHop.Unmap().IsUnspecified() {
+		// Use the next hop of the route when the flow does not carry one
+		nextHop = destBMP.NextHop
+	}
+	c.d.Schema.ProtobufAppendIP(flow, schema.ColumnNextHop, nextHop)
+	timer.done(stageRouting)
 
-	c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnExporterName, []byte(flowExporterName))
-	c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnInIfSpeed, uint64(flowInIfSpeed))
-	c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnOutIfSpeed, uint64(flowOutIfSpeed))
+	// Stage: geoip
+	c.enrichGeoIP(flow, flow.SrcAddr, srcGeoColumns)
+	c.enrichGeoIP(flow, flow.DstAddr, dstGeoColumns)
+	c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnThreatList,
+		[]byte(c.lookupThreatList(flow.SrcAddr, flow.DstAddr)))
+
+	// Anonymization happens last as enrichment needs the real addresses
+	if c.anonymizer != nil {
+		flow.SrcAddr = c.anonymizer.Anonymize(flow.SrcAddr)
+		flow.DstAddr = c.anonymizer.Anonymize(flow.DstAddr)
+		c.d.Schema.ProtobufRewriteIPs(flow, c.anonymizer.Anonymize)
+	}
+	timer.done(stageGeoIP)
 
 	return
 }
 
+// normalizeDirection swaps the source and the destination of a flow crossing
+// the network boundary when it goes in the opposite direction of the
+// configured one. It returns true when the flow was swapped.
+func (c *Component) normalizeDirection(exporterIP netip.Addr, exporterStr string, flow *schema.FlowMessage) bool {
+	normalization := c.config.DirectionNormalization.LookupOrDefault(exporterIP, DirectionNormalizationNone)
+	if normalization == DirectionNormalizationNone {
+		return false
+	}
+	counters := flow.Counters()
+	in := interfaceBoundary(counters.InIfBoundary)
+	out := interfaceBoundary(counters.OutIfBoundary)
+	switch {
+	case normalization == DirectionNormalizationExternalAsSource &&
+		in == internalBoundary && out == externalBoundary:
+	case normalization == DirectionNormalizationInternalAsSource &&
+		in == externalBoundary && out == internalBoundary:
+	default:
+		return false
+	}
+	c.d.Schema.ProtobufSwapDirections(flow)
+	c.metrics.flowsSwapped.WithLabelValues(exporterStr).Inc()
+	return true
+}
+
+// vrfName returns the name of a VRF from its ID. Without a mapping, the ID
+// is used as the name. The default VRF (0) has no name.
+func (c *Component) vrfName(exporterIP netip.Addr, id uint32) string {
+	if id == 0 {
+		return ""
+	}
+	if names, ok := c.config.VRFNames.Lookup(exporterIP); ok {
+		if name, ok := names[id]; ok {
+			return name
+		}
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
 // getASNumber retrieves the AS number for a flow, depending on user preferences.
 func (c *Component) getASNumber(flowAddr netip.Addr, flowAS, bmpAS uint32) (asn uint32) {
 	for _, provider := range c.config.ASNProviders {
@@ -152,6 +308,28 @@ func (c *Component) getASNumber(flowAddr netip.Addr, flowAS, bmpAS uint32) (asn
 	return asn
 }
 
+// getRouting retrieves the AS path and the communities of the destination
+// route for a flow, depending on user preferences.
+func (c *Component) getRouting(flow *schema.FlowMessage, bmpResult bmp.LookupResult) bmp.LookupResult {
+	for _, provider := range c.config.RoutingProviders {
+		switch provider {
+		case RoutingProviderFlow:
+			if flow.GotASPath {
+				return bmp.LookupResult{
+					ASPath:      flow.DstASPath,
+					Communities: flow.DstCommunities,
+				}
+			}
+		case RoutingProviderBMP:
+			if bmpResult.ASN != 0 || len(bmpResult.ASPath) > 0 ||
+				len(bmpResult.Communities) > 0 || len(bmpResult.LargeCommunities) > 0 {
+				return bmpResult
+			}
+		}
+	}
+	return bmp.LookupResult{}
+}
+
 func (c *Component) writeExporter(flow *schema.FlowMessage, classification exporterClassification) bool {
 	if classification.Reject {
 		return false
@@ -164,11 +342,10 @@ func (c *Component) writeExporter(flow *schema.FlowMessage, classification expor
 	return true
 }
 
-func (c *Component) classifyExporter(t time.Time, ip string, name string, flow *schema.FlowMessage) bool {
+func (c *Component) classifyExporter(t time.Time, si exporterInfo, flow *schema.FlowMessage) bool {
 	if len(c.config.ExporterClassifiers) == 0 {
 		return true
 	}
-	si := exporterInfo{IP: ip, Name: name}
 	if classification, ok := c.classifierExporterCache.Get(t, si); ok {
 		return c.writeExporter(flow, classification)
 	}
@@ -179,7 +356,7 @@ func (c *Component) classifyExporter(t time.Time, ip string, name string, flow *
 			c.classifierErrLogger.Err(err).
 				Str("type", "exporter").
 				Int("index", idx).
-				Str("exporter", name).
+				Str("exporter", si.Name).
 				Msg("error executing classifier")
 			c.metrics.classifierErrors.WithLabelValues("exporter", strconv.Itoa(idx)).Inc()
 			break
@@ -202,39 +379,127 @@ func (c *Component) writeInterface(flow *schema.FlowMessage, classification inte
 		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnInIfDescription, []byte(classification.Description))
 		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnInIfConnectivity, []byte(classification.Connectivity))
 		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnInIfProvider, []byte(classification.Provider))
+		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnInIfBillingClass, []byte(classification.BillingClass))
 		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnInIfBoundary, uint64(classification.Boundary))
 	} else {
 		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnOutIfName, []byte(classification.Name))
 		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnOutIfDescription, []byte(classification.Description))
 		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnOutIfConnectivity, []byte(classification.Connectivity))
 		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnOutIfProvider, []byte(classification.Provider))
+		c.d.Schema.ProtobufAppendBytes(flow, schema.ColumnOutIfBillingClass, []byte(classification.BillingClass))
 		c.d.Schema.ProtobufAppendVarint(flow, schema.ColumnOutIfBoundary, uint64(classification.Boundary))
 	}
+	for _, attribute := range c.interfaceAttributes {
+		column := attribute.out
+		if directionIn {
+			column = attribute.in
+		}
+		if column != nil {
+			column.ProtobufAppendBytes(flow, []byte(classification.Attributes[attribute.name]))
+		}
+	}
 	return true
 }
 
-func (c *Component) classifyInterface(t time.Time, ip string, exporterName string, fl *schema.FlowMessage, ifIndex uint32, ifName, ifDescription string, ifSpeed uint32, ifVlan uint16, directionIn bool) bool {
+// interfaceAttributeColumns are the custom dimensions receiving an attribute
+// from interface classifiers.
+type interfaceAttributeColumns struct {
+	name string
+	in   *schema.Column
+	out  *schema.Column
+}
+
+// interfaceAttributesFromSchema maps the attributes set by interface
+// classifiers to the string custom dimensions whose name starts with InIf or
+// OutIf. The attribute name is the remaining part of the column name, in
+// lowercase. Attributes are sorted by name.
+func interfaceAttributesFromSchema(sch *schema.Component) []interfaceAttributeColumns {
+	attributes := map[string]interfaceAttributeColumns{}
+	for _, column := range sch.Columns() {
+		if column.Key < schema.ColumnLast || column.Disabled || !strings.HasSuffix(column.ClickHouseType, "String)") {
+			continue
+		}
+		var name string
+		directionIn := false
+		switch {
+		case strings.HasPrefix(column.Name, "InIf"):
+			name = column.Name[4:]
+			directionIn = true
+		case strings.HasPrefix(column.Name, "OutIf"):
+			name = column.Name[5:]
+		}
+		if name == "" {
+			continue
+		}
+		name = strings.ToLower(name)
+		columns := attributes[name]
+		columns.name = name
+		if directionIn {
+			columns.in, _ = sch.LookupColumnByKey(column.Key)
+		} else {
+			columns.out, _ = sch.LookupColumnByKey(column.Key)
+		}
+		attributes[name] = columns
+	}
+	result := make([]interfaceAttributeColumns, 0, len(attributes))
+	for _, columns := range attributes {
+		result = append(result, columns)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].name < result[j].name
+	})
+	return result
+}
+
+// exporterTagsFromSchema maps the static exporter tags to the string custom
+// dimensions whose name starts with Exporter. The tag name is the remaining
+// part of the column name, in lowercase.
+func exporterTagsFromSchema(sch *schema.Component) map[string]*schema.Column {
+	result := map[string]*schema.Column{}
+	for _, column := range sch.Columns() {
+		if column.Key < schema.ColumnLast || column.Disabled || !strings.HasSuffix(column.ClickHouseType, "String)") {
+			continue
+		}
+		if !strings.HasPrefix(column.Name, "Exporter") || len(column.Name) == len("Exporter") {
+			continue
+		}
+		result[strings.ToLower(column.Name[len("Exporter"):])], _ = sch.LookupColumnByKey(column.Key)
+	}
+	return result
+}
+
+func (c *Component) classifyInterface(t time.Time, si exporterInfo, fl *schema.FlowMessage, ii interfaceInfo, directionIn bool) bool {
+	classification := c.interfaceClassification(t, si, ii)
+	return c.writeInterface(fl, classification, directionIn)
+}
+
+// interfaceClassification classifies an interface using the classifier rules
+// and the static interface metadata, which takes precedence.
+func (c *Component) interfaceClassification(t time.Time, si exporterInfo, ii interfaceInfo) interfaceClassification {
+	var classification interfaceClassification
 	if len(c.config.InterfaceClassifiers) == 0 {
-		c.writeInterface(fl, interfaceClassification{
-			Name:        ifName,
-			Description: ifDescription,
-		}, directionIn)
-		return true
+		classification = interfaceClassification{
+			Name:        ii.Name,
+			Description: ii.Description,
+		}
+	} else {
+		classification = c.classifyInterfaceWithRules(t, si, ii)
 	}
-	si := exporterInfo{IP: ip, Name: exporterName}
-	ii := interfaceInfo{
-		Index:       ifIndex,
-		Name:        ifName,
-		Description: ifDescription,
-		Speed:       ifSpeed,
-		VLAN:        ifVlan,
+	if metadata, ok := c.staticMetadata.lookup(si.IP, ii.Index, ii.Name); ok {
+		metadata.apply(&classification)
 	}
+	return classification
+}
+
+// classifyInterfaceWithRules classifies an interface using the classifier
+// rules. The result is cached.
+func (c *Component) classifyInterfaceWithRules(t time.Time, si exporterInfo, ii interfaceInfo) interfaceClassification {
 	key := exporterAndInterfaceInfo{
 		Exporter:  si,
 		Interface: ii,
 	}
 	if classification, ok := c.classifierInterfaceCache.Get(t, key); ok {
-		return c.writeInterface(fl, classification, directionIn)
+		return classification
 	}
 
 	var classification interfaceClassification
@@ -244,8 +509,8 @@ func (c *Component) classifyInterface(t time.Time, ip string, exporterName strin
 			c.classifierErrLogger.Err(err).
 				Str("type", "interface").
 				Int("index", idx).
-				Str("exporter", exporterName).
-				Str("interface", ifName).
+				Str("exporter", si.Name).
+				Str("interface", ii.Name).
 				Msg("error executing classifier")
 			c.metrics.classifierErrors.WithLabelValues("interface", strconv.Itoa(idx)).Inc()
 			break
@@ -256,16 +521,33 @@ func (c *Component) classifyInterface(t time.Time, ip string, exporterName strin
 		if classification.Boundary == undefinedBoundary {
 			continue
 		}
+		if c.billingClass && classification.BillingClass == "" {
+			continue
+		}
+		if !c.interfaceAttributesComplete(classification) {
+			continue
+		}
 		break
 	}
 	if classification.Name == "" {
-		classification.Name = ifName
+		classification.Name = ii.Name
 	}
 	if classification.Description == "" {
-		classification.Description = ifDescription
+		classification.Description = ii.Description
 	}
 	c.classifierInterfaceCache.Put(t, key, classification)
-	return c.writeInterface(fl, classification, directionIn)
+	return classification
+}
+
+// interfaceAttributesComplete tells if all the attributes with a matching
+// custom dimension are set.
+func (c *Component) interfaceAttributesComplete(classification interfaceClassification) bool {
+	for _, attribute := range c.interfaceAttributes {
+		if classification.Attributes[attribute.name] == "" {
+			return false
+		}
+	}
+	return true
 }
 
 func isPrivateAS(as uint32) bool {
@@ -278,3 +560,21 @@ func isPrivateAS(as uint32) bool {
 	}
 	return false
 }
+
+// enrichGeoIP adds the location of an address to a flow. The country, the
+// city, the region and the coordinates are only looked up when one of their
+// columns is enabled.
+func (c *Component) enrichGeoIP(flow *schema.FlowMessage, addr netip.Addr, columns geoColumns) {
+	if !c.geoLocation {
+		if c.geoCountry {
+			c.d.Schema.ProtobufAppendBytes(flow, columns.country, []byte(c.d.GeoIP.LookupCountry(addr)))
+		}
+		return
+	}
+	location := c.d.GeoIP.LookupLocation(addr)
+	c.d.Schema.ProtobufAppendBytes(flow, columns.country, []byte(location.Country))
+	c.d.Schema.ProtobufAppendBytes(flow, columns.city, []byte(location.City))
+	c.d.Schema.ProtobufAppendBytes(flow, columns.region, []byte(location.Region))
+	c.d.Schema.ProtobufAppendFloat(flow, columns.latitude, location.Latitude)
+	c.d.Schema.ProtobufAppendFloat(flow, columns.longitude, location.Longitude)
+}
@@ -29,8 +29,11 @@ func TestEnrich(t *testing.T) {
 	cases := []struct {
 		Name          string
 		Configuration gin.H
+		Schema        schema.Configuration
 		InputFlow     func() *schema.FlowMessage
 		OutputFlow    *schema.FlowMessage
+		// SamplingRateMetrics are the expected sampling rate adjustment metrics
+		SamplingRateMetrics map[string]string
 	}{
 		{
 			Name:          "no rule",
@@ -84,6 +87,9 @@ func TestEnrich(t *testing.T) {
 					schema.ColumnOutIfSpeed:       1000,
 				},
 			},
+			SamplingRateMetrics: map[string]string{
+				`sampling_rate_adjustments{exporter="192.0.2.142",reason="override"}`: "2",
+			},
 		}, {
 			Name:          "no rule, no sampling rate, default is one value",
 			Configuration: gin.H{"defaultsamplingrate": 500},
@@ -107,6 +113,9 @@ func TestEnrich(t *testing.T) {
 					schema.ColumnOutIfSpeed:       1000,
 				},
 			},
+			SamplingRateMetrics: map[string]string{
+				`sampling_rate_adjustments{exporter="192.0.2.142",reason="default"}`: "2",
+			},
 		}, {
 			Name: "no rule, no sampling rate, default is map",
 			Configuration: gin.H{"defaultsamplingrate": gin.H{
@@ -134,6 +143,9 @@ func TestEnrich(t *testing.T) {
 					schema.ColumnOutIfSpeed:       1000,
 				},
 			},
+			SamplingRateMetrics: map[string]string{
+				`sampling_rate_adjustments{exporter="192.0.2.142",reason="default"}`: "2",
+			},
 		}, {
 			Name: "exporter rule",
 			Configuration: gin.H{
@@ -229,6 +241,83 @@ func TestEnrich(t *testing.T) {
 				}
 			},
 			OutputFlow: nil,
+		}, {
+			Name: "export direction policy drops egress flows",
+			Configuration: gin.H{
+				"exportdirectionpolicy": "ingress",
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					ExportDirection: schema.FlowExportDirectionEgress,
+				}
+			},
+			OutputFlow: nil,
+		}, {
+			Name: "export direction policy keeps ingress flows",
+			Configuration: gin.H{
+				"exportdirectionpolicy": gin.H{
+					"192.0.2.0/24": "ingress",
+				},
+			},
+			Schema: schema.Configuration{
+				Enabled: []schema.ColumnKey{schema.ColumnFlowExportDirection},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					ExportDirection: schema.FlowExportDirectionIngress,
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:        "192_0_2_142",
+					schema.ColumnInIfName:            "Gi0/0/100",
+					schema.ColumnOutIfName:           "Gi0/0/200",
+					schema.ColumnInIfDescription:     "Interface 100",
+					schema.ColumnOutIfDescription:    "Interface 200",
+					schema.ColumnInIfSpeed:           1000,
+					schema.ColumnOutIfSpeed:          1000,
+					schema.ColumnFlowExportDirection: "ingress",
+				},
+			},
+		}, {
+			Name: "export direction policy on another exporter",
+			Configuration: gin.H{
+				"exportdirectionpolicy": gin.H{
+					"198.51.100.0/24": "egress",
+				},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					ExportDirection: schema.FlowExportDirectionIngress,
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:     "192_0_2_142",
+					schema.ColumnInIfName:         "Gi0/0/100",
+					schema.ColumnOutIfName:        "Gi0/0/200",
+					schema.ColumnInIfDescription:  "Interface 100",
+					schema.ColumnOutIfDescription: "Interface 200",
+					schema.ColumnInIfSpeed:        1000,
+					schema.ColumnOutIfSpeed:       1000,
+				},
+			},
 		}, {
 			Name: "interface rule with index",
 			Configuration: gin.H{
@@ -354,6 +443,43 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 					schema.ColumnOutIfBoundary:    internalBoundary,
 				},
 			},
+		}, {
+			Name: "interface rule with attributes",
+			Configuration: gin.H{
+				"interfaceclassifiers": []string{
+					`ClassifyAttribute("pop", Format("pop%d", Interface.Index))`,
+				},
+			},
+			Schema: schema.Configuration{
+				CustomDimensions: []schema.CustomDimension{
+					{Name: "InIfPop"},
+					{Name: "OutIfPop"},
+				},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:     "192_0_2_142",
+					schema.ColumnInIfName:         "Gi0/0/100",
+					schema.ColumnOutIfName:        "Gi0/0/200",
+					schema.ColumnInIfDescription:  "Interface 100",
+					schema.ColumnOutIfDescription: "Interface 200",
+					schema.ColumnInIfSpeed:        1000,
+					schema.ColumnOutIfSpeed:       1000,
+					// Custom dimensions are keyed from ColumnLast, in order
+					schema.ColumnLast:     "pop100", // InIfPop
+					schema.ColumnLast + 1: "pop200", // OutIfPop
+				},
+			},
 		}, {
 			Name: "configure twice boundary",
 			Configuration: gin.H{
@@ -452,6 +578,114 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 					schema.ColumnOutIfBoundary:     2, // internal
 				},
 			},
+		}, {
+			Name: "normalize direction",
+			Configuration: gin.H{
+				"interfaceclassifiers": []string{
+					`ClassifyProvider("Othello")`,
+					`ClassifyConnectivityRegex(Interface.Description, " (1\\d+)$", "P$1") && ClassifyExternal()`,
+					`ClassifyInternal() && ClassifyConnectivity("core")`,
+				},
+				"directionnormalization": "internal-as-source",
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:      "192_0_2_142",
+					schema.ColumnInIfName:          "Gi0/0/200",
+					schema.ColumnOutIfName:         "Gi0/0/100",
+					schema.ColumnInIfDescription:   "Interface 200",
+					schema.ColumnOutIfDescription:  "Interface 100",
+					schema.ColumnInIfSpeed:         1000,
+					schema.ColumnOutIfSpeed:        1000,
+					schema.ColumnInIfConnectivity:  "core",
+					schema.ColumnOutIfConnectivity: "p100",
+					schema.ColumnInIfProvider:      "othello",
+					schema.ColumnOutIfProvider:     "othello",
+					schema.ColumnInIfBoundary:      2, // internal
+					schema.ColumnOutIfBoundary:     1, // external
+				},
+			},
+		}, {
+			Name: "VRF names",
+			Configuration: gin.H{
+				"vrfnames": gin.H{
+					"192.0.2.0/24": gin.H{"1": "customer-a"},
+				},
+			},
+			Schema: schema.Configuration{
+				Enabled: []schema.ColumnKey{schema.ColumnSrcVRF, schema.ColumnDstVRF},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcVRFID:        1,
+					DstVRFID:        2,
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:     "192_0_2_142",
+					schema.ColumnInIfName:         "Gi0/0/100",
+					schema.ColumnOutIfName:        "Gi0/0/200",
+					schema.ColumnInIfDescription:  "Interface 100",
+					schema.ColumnOutIfDescription: "Interface 200",
+					schema.ColumnInIfSpeed:        1000,
+					schema.ColumnOutIfSpeed:       1000,
+					schema.ColumnSrcVRF:           "customer-a",
+					schema.ColumnDstVRF:           "2",
+				},
+			},
+		}, {
+			Name: "exporter tags",
+			Configuration: gin.H{
+				"exportertags": gin.H{
+					"192.0.2.0/24":   gin.H{"environment": "production", "Owner": "noc"},
+					"192.0.2.128/25": gin.H{"environment": "lab"},
+				},
+			},
+			Schema: schema.Configuration{
+				CustomDimensions: []schema.CustomDimension{
+					{Name: "ExporterEnvironment"},
+					{Name: "ExporterOwner"},
+				},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:     "192_0_2_142",
+					schema.ColumnInIfName:         "Gi0/0/100",
+					schema.ColumnOutIfName:        "Gi0/0/200",
+					schema.ColumnInIfDescription:  "Interface 100",
+					schema.ColumnOutIfDescription: "Interface 200",
+					schema.ColumnInIfSpeed:        1000,
+					schema.ColumnOutIfSpeed:       1000,
+					schema.ColumnLast:             "lab",
+				},
+			},
 		}, {
 			Name:          "use data from BMP",
 			Configuration: gin.H{},
@@ -474,6 +708,220 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 				DstAS:           174,
 				ProtobufDebug: map[schema.ColumnKey]interface{}{
 					schema.ColumnExporterName:                  "192_0_2_142",
+					schema.ColumnSrcNetMask:                    27,
+					schema.ColumnDstNetMask:                    27,
+					schema.ColumnInIfName:                      "Gi0/0/100",
+					schema.ColumnOutIfName:                     "Gi0/0/200",
+					schema.ColumnInIfDescription:               "Interface 100",
+					schema.ColumnOutIfDescription:              "Interface 200",
+					schema.ColumnInIfSpeed:                     1000,
+					schema.ColumnOutIfSpeed:                    1000,
+					schema.ColumnDstASPath:                     []uint32{64200, 1299, 174},
+					schema.ColumnDstCommunities:                []uint32{100, 200, 400},
+					schema.ColumnDstLargeCommunitiesASN:        []int32{64200},
+					schema.ColumnDstLargeCommunitiesLocalData1: []int32{2},
+					schema.ColumnDstLargeCommunitiesLocalData2: []int32{3},
+				},
+			},
+		}, {
+			Name:          "use routing data from flow",
+			Configuration: gin.H{},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+					SrcAS:           64476,
+					DstAS:           65401,
+					GotASPath:       true,
+					DstASPath:       []uint32{64476, 65400, 65401},
+					DstCommunities:  []uint32{300},
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				SrcAS:           64476,
+				DstAS:           65401,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:     "192_0_2_142",
+					schema.ColumnSrcNetMask:       27,
+					schema.ColumnDstNetMask:       27,
+					schema.ColumnInIfName:         "Gi0/0/100",
+					schema.ColumnOutIfName:        "Gi0/0/200",
+					schema.ColumnInIfDescription:  "Interface 100",
+					schema.ColumnOutIfDescription: "Interface 200",
+					schema.ColumnInIfSpeed:        1000,
+					schema.ColumnOutIfSpeed:       1000,
+					schema.ColumnDstASPath:        []uint32{64476, 65400, 65401},
+					schema.ColumnDstCommunities:   []uint32{300},
+				},
+			},
+		}, {
+			Name: "prefer routing data from BMP",
+			Configuration: gin.H{
+				"routingproviders": []string{"bmp", "flow"},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+					SrcAS:           64476,
+					DstAS:           65401,
+					GotASPath:       true,
+					DstASPath:       []uint32{64476, 65400, 65401},
+					DstCommunities:  []uint32{300},
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				SrcAS:           64476,
+				DstAS:           65401,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:                  "192_0_2_142",
+					schema.ColumnSrcNetMask:                    27,
+					schema.ColumnDstNetMask:                    27,
+					schema.ColumnInIfName:                      "Gi0/0/100",
+					schema.ColumnOutIfName:                     "Gi0/0/200",
+					schema.ColumnInIfDescription:               "Interface 100",
+					schema.ColumnOutIfDescription:              "Interface 200",
+					schema.ColumnInIfSpeed:                     1000,
+					schema.ColumnOutIfSpeed:                    1000,
+					schema.ColumnDstASPath:                     []uint32{64200, 1299, 174},
+					schema.ColumnDstCommunities:                []uint32{100, 200, 400},
+					schema.ColumnDstLargeCommunitiesASN:        []int32{64200},
+					schema.ColumnDstLargeCommunitiesLocalData1: []int32{2},
+					schema.ColumnDstLargeCommunitiesLocalData2: []int32{3},
+				},
+			},
+		}, {
+			Name: "traffic class from BGP communities",
+			Configuration: gin.H{
+				"trafficclasses": []gin.H{
+					{"community": "65000:*", "class": "backbone"},
+					{"community": "64200:2:*", "class": "cache-fill"},
+					{"community": "0:100", "class": "transit"},
+				},
+				"defaulttrafficclass": "other",
+			},
+			Schema: schema.Configuration{
+				Enabled: []schema.ColumnKey{schema.ColumnDstTrafficClass},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				SrcAS:           1299,
+				DstAS:           174,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:                  "192_0_2_142",
+					schema.ColumnSrcNetMask:                    27,
+					schema.ColumnDstNetMask:                    27,
+					schema.ColumnInIfName:                      "Gi0/0/100",
+					schema.ColumnOutIfName:                     "Gi0/0/200",
+					schema.ColumnInIfDescription:               "Interface 100",
+					schema.ColumnOutIfDescription:              "Interface 200",
+					schema.ColumnInIfSpeed:                     1000,
+					schema.ColumnOutIfSpeed:                    1000,
+					schema.ColumnDstASPath:                     []uint32{64200, 1299, 174},
+					schema.ColumnDstCommunities:                []uint32{100, 200, 400},
+					schema.ColumnDstLargeCommunitiesASN:        []int32{64200},
+					schema.ColumnDstLargeCommunitiesLocalData1: []int32{2},
+					schema.ColumnDstLargeCommunitiesLocalData2: []int32{3},
+					schema.ColumnDstTrafficClass:               "cache-fill",
+				},
+			},
+		}, {
+			Name:          "next hop from BMP",
+			Configuration: gin.H{},
+			Schema: schema.Configuration{
+				Enabled: []schema.ColumnKey{schema.ColumnNextHop},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				SrcAS:           1299,
+				DstAS:           174,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:                  "192_0_2_142",
+					schema.ColumnSrcNetMask:                    27,
+					schema.ColumnDstNetMask:                    27,
+					schema.ColumnInIfName:                      "Gi0/0/100",
+					schema.ColumnOutIfName:                     "Gi0/0/200",
+					schema.ColumnInIfDescription:               "Interface 100",
+					schema.ColumnOutIfDescription:              "Interface 200",
+					schema.ColumnInIfSpeed:                     1000,
+					schema.ColumnOutIfSpeed:                    1000,
+					schema.ColumnDstASPath:                     []uint32{64200, 1299, 174},
+					schema.ColumnDstCommunities:                []uint32{100, 200, 400},
+					schema.ColumnDstLargeCommunitiesASN:        []int32{64200},
+					schema.ColumnDstLargeCommunitiesLocalData1: []int32{2},
+					schema.ColumnDstLargeCommunitiesLocalData2: []int32{3},
+					schema.ColumnNextHop:                       netip.MustParseAddr("::ffff:198.51.100.4"),
+				},
+			},
+		}, {
+			Name:          "first AS of the source from BMP",
+			Configuration: gin.H{},
+			Schema: schema.Configuration{
+				Enabled: []schema.ColumnKey{schema.ColumnSrc1stAS},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				SrcAS:           1299,
+				DstAS:           174,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:                  "192_0_2_142",
+					schema.ColumnSrcNetMask:                    27,
+					schema.ColumnDstNetMask:                    27,
 					schema.ColumnInIfName:                      "Gi0/0/100",
 					schema.ColumnOutIfName:                     "Gi0/0/200",
 					schema.ColumnInIfDescription:               "Interface 100",
@@ -485,6 +933,102 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 					schema.ColumnDstLargeCommunitiesASN:        []int32{64200},
 					schema.ColumnDstLargeCommunitiesLocalData1: []int32{2},
 					schema.ColumnDstLargeCommunitiesLocalData2: []int32{3},
+					schema.ColumnSrc1stAS:                      64200,
+				},
+			},
+		}, {
+			Name:          "country from GeoIP",
+			Configuration: gin.H{"asnproviders": []string{"flow"}},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					DstAddr:         netip.MustParseAddr("::ffff:81.2.69.142"),
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:81.2.69.142"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:     "192_0_2_142",
+					schema.ColumnInIfName:         "Gi0/0/100",
+					schema.ColumnOutIfName:        "Gi0/0/200",
+					schema.ColumnInIfDescription:  "Interface 100",
+					schema.ColumnOutIfDescription: "Interface 200",
+					schema.ColumnInIfSpeed:        1000,
+					schema.ColumnOutIfSpeed:       1000,
+					schema.ColumnDstCountry:       "GB",
+				},
+			},
+		}, {
+			Name:          "country disabled",
+			Configuration: gin.H{"asnproviders": []string{"flow"}},
+			Schema: schema.Configuration{
+				Disabled: []schema.ColumnKey{schema.ColumnSrcCountry, schema.ColumnDstCountry},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					DstAddr:         netip.MustParseAddr("::ffff:81.2.69.142"),
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:81.2.69.142"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:     "192_0_2_142",
+					schema.ColumnInIfName:         "Gi0/0/100",
+					schema.ColumnOutIfName:        "Gi0/0/200",
+					schema.ColumnInIfDescription:  "Interface 100",
+					schema.ColumnOutIfDescription: "Interface 200",
+					schema.ColumnInIfSpeed:        1000,
+					schema.ColumnOutIfSpeed:       1000,
+				},
+			},
+		}, {
+			Name:          "next hop from flow",
+			Configuration: gin.H{},
+			Schema: schema.Configuration{
+				Enabled: []schema.ColumnKey{schema.ColumnNextHop},
+			},
+			InputFlow: func() *schema.FlowMessage {
+				return &schema.FlowMessage{
+					SamplingRate:    1000,
+					ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+					InIf:            100,
+					OutIf:           200,
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+					DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+					NextHop:         netip.MustParseAddr("::ffff:198.51.100.8"),
+				}
+			},
+			OutputFlow: &schema.FlowMessage{
+				SamplingRate:    1000,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.142"),
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.142"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.10"),
+				SrcAS:           1299,
+				DstAS:           174,
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnExporterName:     "192_0_2_142",
+					schema.ColumnSrcNetMask:       27,
+					schema.ColumnDstNetMask:       27,
+					schema.ColumnInIfName:         "Gi0/0/100",
+					schema.ColumnOutIfName:        "Gi0/0/200",
+					schema.ColumnInIfDescription:  "Interface 100",
+					schema.ColumnOutIfDescription: "Interface 200",
+					schema.ColumnInIfSpeed:        1000,
+					schema.ColumnOutIfSpeed:       1000,
+					schema.ColumnDstASPath:        []uint32{64200, 174, 174, 174},
+					schema.ColumnDstCommunities:   []uint32{100},
+					schema.ColumnNextHop:          netip.MustParseAddr("::ffff:198.51.100.8"),
 				},
 			},
 		},
@@ -514,6 +1058,11 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 				t.Fatalf("Decode() error:\n%+v", err)
 			}
 
+			schemaComponent, err := schema.New(tc.Schema)
+			if err != nil {
+				t.Fatalf("schema.New() error:\n%+v", err)
+			}
+
 			// Instantiate and start core
 			c, err := New(r, configuration, Dependencies{
 				Daemon: daemonComponent,
@@ -523,7 +1072,7 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 				Kafka:  kafkaComponent,
 				HTTP:   httpComponent,
 				BMP:    bmpComponent,
-				Schema: schema.NewMock(t),
+				Schema: schemaComponent,
 			})
 			if err != nil {
 				t.Fatalf("New() error:\n%+v", err)
@@ -561,7 +1110,7 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 			} else {
 				time.Sleep(100 * time.Millisecond)
 			}
-			gotMetrics := r.GetMetrics("akvorado_inlet_core_flows_", "-processing_")
+			gotMetrics := r.GetMetrics("akvorado_inlet_core_flows_", "-processing_", "-swapped")
 			expectedMetrics := map[string]string{
 				`errors{error="SNMP cache miss",exporter="192.0.2.142"}`: "1",
 				`http_clients`:                     "0",
@@ -573,6 +1122,14 @@ ClassifyProviderRegex(Interface.Description, "^Transit: ([^ ]+)", "$1")`,
 			if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
 				t.Fatalf("Metrics (-got, +want):\n%s", diff)
 			}
+			gotMetrics = r.GetMetrics("akvorado_inlet_core_", "sampling_rate_")
+			expectedMetrics = tc.SamplingRateMetrics
+			if expectedMetrics == nil {
+				expectedMetrics = map[string]string{}
+			}
+			if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+				t.Fatalf("Sampling rate metrics (-got, +want):\n%s", diff)
+			}
 		})
 	}
 }
@@ -4,7 +4,9 @@
 package core
 
 import (
+	"crypto/subtle"
 	"net/http"
+	"net/netip"
 	"sync/atomic"
 	"time"
 
@@ -64,3 +66,59 @@ func (c *Component) FlowsHTTPHandler(gc *gin.Context) {
 		}
 	}
 }
+
+// adminAuthentication checks the bearer token for administrative endpoints.
+// They are disabled when no token is configured.
+func (c *Component) adminAuthentication(gc *gin.Context) {
+	if c.config.APIToken == "" {
+		gc.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "No API token configured."})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(gc.GetHeader("Authorization")), []byte("Bearer "+c.config.APIToken)) != 1 {
+		gc.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "Invalid API token."})
+		return
+	}
+	gc.Next()
+}
+
+type flushCachesParameters struct {
+	Exporter string `form:"exporter"`
+}
+
+// FlushCachesHTTPHandler flushes the classification caches and the cached
+// interfaces, either for a single exporter or for all of them.
+func (c *Component) FlushCachesHTTPHandler(gc *gin.Context) {
+	var params flushCachesParameters
+	if err := gc.ShouldBindQuery(&params); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	var exporterIP netip.Addr
+	if params.Exporter != "" {
+		ip, err := netip.ParseAddr(params.Exporter)
+		if err != nil {
+			gc.JSON(http.StatusBadRequest, gin.H{"message": "Invalid exporter address."})
+			return
+		}
+		exporterIP = netip.AddrFrom16(ip.As16())
+	}
+	exporterStr := exporterIP.Unmap().String()
+	exporters := c.classifierExporterCache.DeleteFunc(func(si exporterInfo) bool {
+		return !exporterIP.IsValid() || si.IP == exporterStr
+	})
+	interfaces := c.classifierInterfaceCache.DeleteFunc(func(key exporterAndInterfaceInfo) bool {
+		return !exporterIP.IsValid() || key.Exporter.IP == exporterStr
+	})
+	polled := c.d.SNMP.Flush(exporterIP)
+	c.r.Info().
+		Str("exporter", params.Exporter).
+		Int("exporter-classifications", exporters).
+		Int("interface-classifications", interfaces).
+		Int("interfaces", polled).
+		Msg("caches flushed")
+	gc.JSON(http.StatusOK, gin.H{
+		"exporter-classifications":  exporters,
+		"interface-classifications": interfaces,
+		"interfaces":                polled,
+	})
+}
@@ -0,0 +1,111 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	netHTTP "net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/bmp"
+	"akvorado/inlet/flow"
+	"akvorado/inlet/geoip"
+	"akvorado/inlet/kafka"
+	"akvorado/inlet/snmp"
+)
+
+func TestFlushCaches(t *testing.T) {
+	r := reporter.NewMock(t)
+	daemonComponent := daemon.NewMock(t)
+	snmpComponent := snmp.NewMock(t, r, snmp.DefaultConfiguration(),
+		snmp.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	geoipComponent := geoip.NewMock(t, r)
+	kafkaComponent, _ := kafka.NewMock(t, r, kafka.DefaultConfiguration())
+	httpComponent := http.NewMock(t, r)
+	bmpComponent, _ := bmp.NewMock(t, r, bmp.DefaultConfiguration())
+
+	configuration := DefaultConfiguration()
+	configuration.APIToken = "secret"
+	c, err := New(r, configuration, Dependencies{
+		Daemon: daemonComponent,
+		Flow:   flowComponent,
+		SNMP:   snmpComponent,
+		GeoIP:  geoipComponent,
+		Kafka:  kafkaComponent,
+		HTTP:   httpComponent,
+		BMP:    bmpComponent,
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	// Populate caches
+	now := time.Now()
+	for _, exporter := range []string{"192.0.2.142", "192.0.2.143"} {
+		snmpComponent.Lookup(now, netip.MustParseAddr("::ffff:"+exporter), 100)
+		si := exporterInfo{IP: exporter, Name: exporter}
+		c.classifierExporterCache.Put(now, si, exporterClassification{Group: "paris"})
+		c.classifierInterfaceCache.Put(now, exporterAndInterfaceInfo{
+			Exporter:  si,
+			Interface: interfaceInfo{Index: 100},
+		}, interfaceClassification{Provider: "cogent"})
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	authorization := netHTTP.Header{"Authorization": []string{"Bearer secret"}}
+	helpers.TestHTTPEndpoints(t, httpComponent.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "missing token",
+			Method:      "POST",
+			URL:         "/api/v0/inlet/caches/flush",
+			StatusCode:  401,
+			JSONOutput:  gin.H{"message": "Invalid API token."},
+		}, {
+			Description: "invalid token",
+			Method:      "POST",
+			URL:         "/api/v0/inlet/caches/flush",
+			Header:      netHTTP.Header{"Authorization": []string{"Bearer public"}},
+			StatusCode:  401,
+			JSONOutput:  gin.H{"message": "Invalid API token."},
+		}, {
+			Description: "invalid exporter",
+			Method:      "POST",
+			URL:         "/api/v0/inlet/caches/flush?exporter=nowhere",
+			Header:      authorization,
+			StatusCode:  400,
+			JSONOutput:  gin.H{"message": "Invalid exporter address."},
+		}, {
+			Description: "flush one exporter",
+			Method:      "POST",
+			URL:         "/api/v0/inlet/caches/flush?exporter=192.0.2.142",
+			Header:      authorization,
+			JSONOutput: gin.H{
+				"exporter-classifications":  1,
+				"interface-classifications": 1,
+				"interfaces":                1,
+			},
+		}, {
+			Description: "flush remaining exporters",
+			Method:      "POST",
+			URL:         "/api/v0/inlet/caches/flush",
+			Header:      authorization,
+			JSONOutput: gin.H{
+				"exporter-classifications":  1,
+				"interface-classifications": 1,
+				"interfaces":                1,
+			},
+		},
+	})
+}
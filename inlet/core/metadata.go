@@ -0,0 +1,528 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+// staticMetadataEntry is static metadata for an interface. It takes
+// precedence over the information from SNMP and the interface classifiers.
+// The interface is matched using its index when provided, otherwise using its
+// name.
+type staticMetadataEntry struct {
+	ExporterIP   string            `json:"exporter-ip"`
+	IfIndex      uint32            `json:"ifindex,omitempty"`
+	IfName       string            `json:"ifname,omitempty"`
+	Description  string            `json:"description,omitempty"`
+	Connectivity string            `json:"connectivity,omitempty"`
+	Provider     string            `json:"provider,omitempty"`
+	Boundary     interfaceBoundary `json:"boundary,omitempty"`
+}
+
+// staticMetadataCSVHeader is the header of the CSV representation of static
+// metadata entries.
+var staticMetadataCSVHeader = []string{
+	"exporter-ip", "ifindex", "ifname",
+	"description", "connectivity", "provider", "boundary",
+}
+
+// validate checks and normalizes a static metadata entry.
+func (e *staticMetadataEntry) validate() error {
+	ip, err := netip.ParseAddr(e.ExporterIP)
+	if err != nil {
+		return fmt.Errorf("invalid exporter IP %q", e.ExporterIP)
+	}
+	e.ExporterIP = ip.Unmap().String()
+	if e.IfIndex == 0 && e.IfName == "" {
+		return errors.New("missing interface index or name")
+	}
+	return nil
+}
+
+// apply overrides an interface classification with the static metadata.
+func (e staticMetadataEntry) apply(ic *interfaceClassification) {
+	if e.Description != "" {
+		ic.Description = e.Description
+	}
+	if e.Connectivity != "" {
+		ic.Connectivity = e.Connectivity
+	}
+	if e.Provider != "" {
+		ic.Provider = e.Provider
+	}
+	if e.Boundary != undefinedBoundary {
+		ic.Boundary = e.Boundary
+	}
+}
+
+// key returns the key used to match an interface with this entry.
+func (e staticMetadataEntry) key() staticMetadataKey {
+	if e.IfIndex != 0 {
+		return staticMetadataKey{exporter: e.ExporterIP, ifIndex: e.IfIndex}
+	}
+	return staticMetadataKey{exporter: e.ExporterIP, ifName: e.IfName}
+}
+
+type staticMetadataKey struct {
+	exporter string
+	ifIndex  uint32
+	ifName   string
+}
+
+// staticMetadata is the set of static interface metadata.
+type staticMetadata struct {
+	lock    sync.RWMutex
+	entries []staticMetadataEntry
+	index   map[staticMetadataKey]int
+}
+
+func newStaticMetadata() *staticMetadata {
+	return &staticMetadata{
+		entries: []staticMetadataEntry{},
+		index:   map[staticMetadataKey]int{},
+	}
+}
+
+// set replaces the static metadata with the provided entries. When several
+// entries match the same interface, the last one wins.
+func (sm *staticMetadata) set(entries []staticMetadataEntry) {
+	newEntries := make([]staticMetadataEntry, 0, len(entries))
+	newIndex := make(map[staticMetadataKey]int, len(entries))
+	for _, entry := range entries {
+		key := entry.key()
+		if idx, ok := newIndex[key]; ok {
+			newEntries[idx] = entry
+			continue
+		}
+		newIndex[key] = len(newEntries)
+		newEntries = append(newEntries, entry)
+	}
+	sm.lock.Lock()
+	sm.entries = newEntries
+	sm.index = newIndex
+	sm.lock.Unlock()
+}
+
+// list returns the static metadata entries.
+func (sm *staticMetadata) list() []staticMetadataEntry {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	return sm.entries
+}
+
+// size returns the number of static metadata entries.
+func (sm *staticMetadata) size() int {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	return len(sm.entries)
+}
+
+// lookup returns the static metadata for the provided interface, matching
+// first by index, then by name.
+func (sm *staticMetadata) lookup(exporter string, ifIndex uint32, ifName string) (staticMetadataEntry, bool) {
+	sm.lock.RLock()
+	defer sm.lock.RUnlock()
+	if len(sm.index) == 0 {
+		return staticMetadataEntry{}, false
+	}
+	if ifIndex != 0 {
+		if idx, ok := sm.index[staticMetadataKey{exporter: exporter, ifIndex: ifIndex}]; ok {
+			return sm.entries[idx], true
+		}
+	}
+	if ifName != "" {
+		if idx, ok := sm.index[staticMetadataKey{exporter: exporter, ifName: ifName}]; ok {
+			return sm.entries[idx], true
+		}
+	}
+	return staticMetadataEntry{}, false
+}
+
+// loadStaticMetadata loads the static metadata from the configured file. A
+// missing file is not an error.
+func (c *Component) loadStaticMetadata() error {
+	content, err := os.ReadFile(c.config.StaticInterfaceMetadataFile)
+	if errors.Is(err, os.ErrNotExist) {
+		// Keep the current set: an editor may remove the file before
+		// creating it again.
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read static interface metadata: %w", err)
+	}
+	entries := []staticMetadataEntry{}
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return fmt.Errorf("unable to decode static interface metadata: %w", err)
+	}
+	for idx := range entries {
+		if err := entries[idx].validate(); err != nil {
+			return fmt.Errorf("invalid static interface metadata entry %d: %w", idx+1, err)
+		}
+	}
+	c.staticMetadata.set(entries)
+	c.r.Info().Int("entries", len(entries)).Msg("static interface metadata loaded")
+	return nil
+}
+
+// saveStaticMetadata persists the provided static metadata to the configured
+// file.
+func (c *Component) saveStaticMetadata(entries []staticMetadataEntry) error {
+	file := c.config.StaticInterfaceMetadataFile
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode static interface metadata: %w", err)
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(file), fmt.Sprintf("%s-*", filepath.Base(file)))
+	if err != nil {
+		return fmt.Errorf("unable to create static interface metadata file %q: %w", file, err)
+	}
+	defer func() {
+		tmpFile.Close()           // ignore errors
+		os.Remove(tmpFile.Name()) // ignore errors
+	}()
+	if _, err := tmpFile.Write(content); err != nil {
+		return fmt.Errorf("unable to write static interface metadata: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), file); err != nil {
+		return fmt.Errorf("unable to write static interface metadata file %q: %w", file, err)
+	}
+	return nil
+}
+
+// watchStaticMetadata reloads static metadata when the configured file is
+// modified.
+func (c *Component) watchStaticMetadata() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		c.r.Err(err).Msg("cannot setup watcher for static interface metadata")
+		return fmt.Errorf("cannot setup watcher: %w", err)
+	}
+	file := filepath.Clean(c.config.StaticInterfaceMetadataFile)
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		c.r.Err(err).Msg("cannot watch static interface metadata directory")
+		return fmt.Errorf("cannot watch static interface metadata directory: %w", err)
+	}
+	c.t.Go(func() error {
+		errLogger := c.r.Sample(reporter.BurstSampler(10*time.Second, 1))
+		defer watcher.Close()
+
+		for {
+			select {
+			case <-c.t.Dying():
+				return nil
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return errors.New("file watcher died")
+				}
+				errLogger.Err(err).Msg("error from watcher")
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return errors.New("file watcher died")
+				}
+				if filepath.Clean(event.Name) != file {
+					continue
+				}
+				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
+					continue
+				}
+				c.staticMetadataLock.Lock()
+				if err := c.loadStaticMetadata(); err != nil {
+					c.r.Err(err).Msg("cannot reload static interface metadata, keeping the current one")
+					c.metrics.staticMetadataReloadErrors.Inc()
+				}
+				c.staticMetadataLock.Unlock()
+			}
+		}
+	})
+	return nil
+}
+
+// interfaceMetadataRow is the effective metadata for an interface.
+type interfaceMetadataRow struct {
+	ExporterIP   string            `json:"exporter-ip"`
+	ExporterName string            `json:"exporter-name"`
+	IfIndex      uint              `json:"ifindex"`
+	IfName       string            `json:"ifname"`
+	Description  string            `json:"description"`
+	Speed        uint              `json:"speed"`
+	Connectivity string            `json:"connectivity"`
+	Provider     string            `json:"provider"`
+	Boundary     interfaceBoundary `json:"boundary"`
+	Static       bool              `json:"static"`
+}
+
+// InterfacesHTTPHandler returns the effective metadata for the interfaces
+// known by the SNMP component, as JSON or CSV.
+func (c *Component) InterfacesHTTPHandler(gc *gin.Context) {
+	t := time.Now()
+	rows := []interfaceMetadataRow{}
+	for _, iface := range c.d.SNMP.Interfaces() {
+		si := exporterInfo{
+			IP:     iface.ExporterIP.Unmap().String(),
+			Name:   iface.ExporterName,
+			Tenant: iface.ExporterTenant,
+			Tags:   joinTags(iface.ExporterTags),
+		}
+		classification := c.interfaceClassification(t, si, interfaceInfo{
+			Index:       uint32(iface.Index),
+			Name:        iface.Name,
+			Description: iface.Description,
+			Speed:       uint32(iface.Speed),
+			Tags:        joinTags(iface.Tags),
+		})
+		_, static := c.staticMetadata.lookup(si.IP, uint32(iface.Index), iface.Name)
+		rows = append(rows, interfaceMetadataRow{
+			ExporterIP:   si.IP,
+			ExporterName: si.Name,
+			IfIndex:      iface.Index,
+			IfName:       iface.Name,
+			Description:  classification.Description,
+			Speed:        iface.Speed,
+			Connectivity: classification.Connectivity,
+			Provider:     classification.Provider,
+			Boundary:     classification.Boundary,
+			Static:       static,
+		})
+	}
+
+	switch gc.NegotiateFormat("application/json", "text/csv") {
+	case "text/csv":
+		gc.Header("Content-Type", "text/csv; charset=utf-8")
+		gc.Status(http.StatusOK)
+		wr := csv.NewWriter(gc.Writer)
+		wr.Write([]string{
+			"exporter-ip", "exporter-name", "ifindex", "ifname", "description",
+			"speed", "connectivity", "provider", "boundary", "static",
+		})
+		for _, row := range rows {
+			wr.Write([]string{
+				row.ExporterIP, row.ExporterName,
+				strconv.FormatUint(uint64(row.IfIndex), 10), row.IfName, row.Description,
+				strconv.FormatUint(uint64(row.Speed), 10), row.Connectivity, row.Provider,
+				row.Boundary.String(), strconv.FormatBool(row.Static),
+			})
+		}
+		wr.Flush()
+	default:
+		gc.JSON(http.StatusOK, gin.H{"interfaces": rows})
+	}
+}
+
+// StaticMetadataHTTPHandler returns the static interface metadata, as JSON or
+// CSV. The output can be imported back.
+func (c *Component) StaticMetadataHTTPHandler(gc *gin.Context) {
+	entries := c.staticMetadata.list()
+	switch gc.NegotiateFormat("application/json", "text/csv") {
+	case "text/csv":
+		gc.Header("Content-Type", "text/csv; charset=utf-8")
+		gc.Status(http.StatusOK)
+		wr := csv.NewWriter(gc.Writer)
+		wr.Write(staticMetadataCSVHeader)
+		for _, entry := range entries {
+			var ifIndex, boundary string
+			if entry.IfIndex != 0 {
+				ifIndex = strconv.FormatUint(uint64(entry.IfIndex), 10)
+			}
+			if entry.Boundary != undefinedBoundary {
+				boundary = entry.Boundary.String()
+			}
+			wr.Write([]string{
+				entry.ExporterIP, ifIndex, entry.IfName,
+				entry.Description, entry.Connectivity, entry.Provider, boundary,
+			})
+		}
+		wr.Flush()
+	default:
+		gc.JSON(http.StatusOK, gin.H{"interfaces": entries})
+	}
+}
+
+// staticMetadataImportError is an error for one imported row. Rows are
+// numbered from 1, not counting the CSV header.
+type staticMetadataImportError struct {
+	Row     int    `json:"row"`
+	Message string `json:"message"`
+}
+
+// StaticMetadataImportHTTPHandler replaces the static interface metadata
+// with the provided set, as JSON or CSV. When a row is invalid or when the
+// set is empty, the import is refused and the current set is kept. Rows
+// referencing exporters unknown to the SNMP cache are imported, but
+// reported as warnings. Importing the same set twice leads to the same
+// state.
+func (c *Component) StaticMetadataImportHTTPHandler(gc *gin.Context) {
+	var entries []staticMetadataEntry
+	var rowErrors []staticMetadataImportError
+	var err error
+	switch gc.ContentType() {
+	case "application/json":
+		entries, rowErrors, err = decodeStaticMetadataJSON(gc.Request.Body)
+	case "text/csv":
+		entries, rowErrors, err = decodeStaticMetadataCSV(gc.Request.Body)
+	default:
+		gc.JSON(http.StatusUnsupportedMediaType, gin.H{"message": "Unsupported content type."})
+		return
+	}
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	sort.SliceStable(rowErrors, func(i, j int) bool {
+		return rowErrors[i].Row < rowErrors[j].Row
+	})
+	if len(rowErrors) > 0 {
+		gc.JSON(http.StatusBadRequest, gin.H{
+			"message": "Invalid rows, static interface metadata not imported.",
+			"errors":  rowErrors,
+		})
+		return
+	}
+	if len(entries) == 0 {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": "No static interface metadata to import."})
+		return
+	}
+
+	// Warn about rows referencing exporters not seen yet
+	knownExporters := map[string]bool{}
+	for _, iface := range c.d.SNMP.Interfaces() {
+		knownExporters[iface.ExporterIP.Unmap().String()] = true
+	}
+	rowWarnings := []staticMetadataImportError{}
+	for idx, entry := range entries {
+		if !knownExporters[entry.ExporterIP] {
+			rowWarnings = append(rowWarnings, staticMetadataImportError{
+				Row:     idx + 1,
+				Message: fmt.Sprintf("unknown exporter %q", entry.ExporterIP),
+			})
+		}
+	}
+
+	c.staticMetadataLock.Lock()
+	defer c.staticMetadataLock.Unlock()
+	if c.config.StaticInterfaceMetadataFile != "" {
+		if err := c.saveStaticMetadata(entries); err != nil {
+			c.r.Err(err).Msg("unable to persist static interface metadata")
+			gc.JSON(http.StatusInternalServerError,
+				gin.H{"message": "Unable to persist static interface metadata."})
+			return
+		}
+	}
+	c.staticMetadata.set(entries)
+	gc.JSON(http.StatusOK, gin.H{
+		"imported": c.staticMetadata.size(),
+		"warnings": rowWarnings,
+	})
+}
+
+// decodeStaticMetadataJSON decodes static metadata entries from JSON. Invalid
+// rows are returned with an empty exporter IP.
+func decodeStaticMetadataJSON(r io.Reader) ([]staticMetadataEntry, []staticMetadataImportError, error) {
+	var input struct {
+		Interfaces []json.RawMessage `json:"interfaces"`
+	}
+	if err := json.NewDecoder(r).Decode(&input); err != nil {
+		return nil, nil, fmt.Errorf("unable to decode JSON: %w", err)
+	}
+	entries := make([]staticMetadataEntry, len(input.Interfaces))
+	rowErrors := []staticMetadataImportError{}
+	for idx, raw := range input.Interfaces {
+		var entry staticMetadataEntry
+		err := json.Unmarshal(raw, &entry)
+		if err == nil {
+			err = entry.validate()
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, staticMetadataImportError{Row: idx + 1, Message: err.Error()})
+			continue
+		}
+		entries[idx] = entry
+	}
+	return entries, rowErrors, nil
+}
+
+// decodeStaticMetadataCSV decodes static metadata entries from CSV. The first
+// line is a header. Unknown columns are ignored. Invalid rows are returned
+// with an empty exporter IP.
+func decodeStaticMetadataCSV(r io.Reader) ([]staticMetadataEntry, []staticMetadataImportError, error) {
+	rd := csv.NewReader(r)
+	rd.FieldsPerRecord = -1
+	header, err := rd.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read CSV header: %w", err)
+	}
+	columns := map[string]int{}
+	for idx, name := range header {
+		columns[name] = idx
+	}
+	if _, ok := columns["exporter-ip"]; !ok {
+		return nil, nil, errors.New("missing exporter-ip column in CSV header")
+	}
+	entries := []staticMetadataEntry{}
+	rowErrors := []staticMetadataImportError{}
+	for row := 1; ; row++ {
+		record, err := rd.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, fmt.Errorf("unable to read CSV: %w", err)
+		}
+		get := func(name string) string {
+			if idx, ok := columns[name]; ok && idx < len(record) {
+				return record[idx]
+			}
+			return ""
+		}
+		entry := staticMetadataEntry{
+			ExporterIP:   get("exporter-ip"),
+			IfName:       get("ifname"),
+			Description:  get("description"),
+			Connectivity: get("connectivity"),
+			Provider:     get("provider"),
+		}
+		err = nil
+		if ifIndex := get("ifindex"); ifIndex != "" {
+			var value uint64
+			value, err = strconv.ParseUint(ifIndex, 10, 32)
+			if err != nil {
+				err = fmt.Errorf("invalid interface index %q", ifIndex)
+			}
+			entry.IfIndex = uint32(value)
+		}
+		if err == nil {
+			if err = entry.Boundary.UnmarshalText([]byte(get("boundary"))); err != nil {
+				err = fmt.Errorf("invalid boundary %q", get("boundary"))
+			}
+		}
+		if err == nil {
+			err = entry.validate()
+		}
+		if err != nil {
+			rowErrors = append(rowErrors, staticMetadataImportError{Row: row, Message: err.Error()})
+			entries = append(entries, staticMetadataEntry{})
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rowErrors, nil
+}
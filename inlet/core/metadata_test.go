@@ -0,0 +1,324 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	netHTTP "net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/bmp"
+	"akvorado/inlet/flow"
+	"akvorado/inlet/geoip"
+	"akvorado/inlet/kafka"
+	"akvorado/inlet/snmp"
+)
+
+func TestStaticMetadata(t *testing.T) {
+	r := reporter.NewMock(t)
+	daemonComponent := daemon.NewMock(t)
+	snmpComponent := snmp.NewMock(t, r, snmp.DefaultConfiguration(),
+		snmp.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	geoipComponent := geoip.NewMock(t, r)
+	kafkaComponent, _ := kafka.NewMock(t, r, kafka.DefaultConfiguration())
+	httpComponent := http.NewMock(t, r)
+	bmpComponent, _ := bmp.NewMock(t, r, bmp.DefaultConfiguration())
+
+	metadataFile := filepath.Join(t.TempDir(), "interfaces.json")
+	configuration := DefaultConfiguration()
+	configuration.StaticInterfaceMetadataFile = metadataFile
+	configuration.APIToken = "secret"
+	c, err := New(r, configuration, Dependencies{
+		Daemon: daemonComponent,
+		Flow:   flowComponent,
+		SNMP:   snmpComponent,
+		GeoIP:  geoipComponent,
+		Kafka:  kafkaComponent,
+		HTTP:   httpComponent,
+		BMP:    bmpComponent,
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	// Populate SNMP cache
+	exporter := netip.MustParseAddr("::ffff:192.0.2.142")
+	snmpComponent.Lookup(time.Now(), exporter, 100)
+	snmpComponent.Lookup(time.Now(), exporter, 200)
+	time.Sleep(50 * time.Millisecond)
+
+	// Import static metadata as CSV
+	putCSV := func(token string, body string) (int, string) {
+		t.Helper()
+		req, _ := netHTTP.NewRequest("PUT",
+			fmt.Sprintf("http://%s/api/v0/inlet/interfaces/static", httpComponent.LocalAddr()),
+			strings.NewReader(body))
+		req.Header.Set("Content-Type", "text/csv")
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+		resp, err := netHTTP.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("PUT /api/v0/inlet/interfaces/static:\n%+v", err)
+		}
+		defer resp.Body.Close()
+		got, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(got)
+	}
+	checkJSON := func(body string, expected gin.H) {
+		t.Helper()
+		var got interface{}
+		if err := json.Unmarshal([]byte(body), &got); err != nil {
+			t.Fatalf("Unmarshal() error:\n%+v", err)
+		}
+		var expectedJSON interface{}
+		b, _ := json.Marshal(expected)
+		json.Unmarshal(b, &expectedJSON)
+		if diff := helpers.Diff(got, expectedJSON); diff != "" {
+			t.Fatalf("PUT /api/v0/inlet/interfaces/static (-got, +want):\n%s", diff)
+		}
+	}
+
+	// Invalid rows make the whole import fail
+	status, body := putCSV("secret", `exporter-ip,ifindex,ifname,provider,connectivity,boundary,comment
+192.0.2.142,100,,cogent,transit,external,first
+192.0.2.142,300,,zayo,transit,outside,second
+192.0.2.142,,,zayo,transit,external,third
+`)
+	if status != 400 {
+		t.Fatalf("PUT /api/v0/inlet/interfaces/static with invalid rows: got status code %d (%s)", status, body)
+	}
+	checkJSON(body, gin.H{
+		"message": "Invalid rows, static interface metadata not imported.",
+		"errors": []gin.H{
+			{"row": 2, "message": `invalid boundary "outside"`},
+			{"row": 3, "message": "missing interface index or name"},
+		},
+	})
+	if _, err := os.Stat(metadataFile); err == nil {
+		t.Fatal("static interface metadata persisted after a failed import")
+	}
+
+	// Unknown exporters are imported with a warning
+	csvInput := `exporter-ip,ifindex,ifname,provider,connectivity,boundary,comment
+192.0.2.142,100,,cogent,transit,external,first
+192.0.2.142,,Gi0/0/200,,core,internal,second
+192.0.2.143,10,,level3,transit,external,third
+`
+	for i := 0; i < 2; i++ {
+		status, body := putCSV("secret", csvInput)
+		if status != 200 {
+			t.Fatalf("PUT /api/v0/inlet/interfaces/static: got status code %d (%s)", status, body)
+		}
+		checkJSON(body, gin.H{
+			"imported": 3,
+			"warnings": []gin.H{
+				{"row": 3, "message": `unknown exporter "192.0.2.143"`},
+			},
+		})
+	}
+	if status, _ := putCSV("secret", "exporter,ifindex\n"); status != 400 {
+		t.Errorf("PUT /api/v0/inlet/interfaces/static without exporter-ip: got status code %d", status)
+	}
+	if status, _ := putCSV("secret", "exporter-ip,ifindex\n"); status != 400 {
+		t.Errorf("PUT /api/v0/inlet/interfaces/static without rows: got status code %d", status)
+	}
+	if status, _ := putCSV("public", csvInput); status != 401 {
+		t.Errorf("PUT /api/v0/inlet/interfaces/static with a bad token: got status code %d", status)
+	}
+
+	// Check persisted file
+	content, err := os.ReadFile(metadataFile)
+	if err != nil {
+		t.Fatalf("ReadFile() error:\n%+v", err)
+	}
+	var persisted []staticMetadataEntry
+	if err := json.Unmarshal(content, &persisted); err != nil {
+		t.Fatalf("Unmarshal() error:\n%+v", err)
+	}
+	expectedEntries := []staticMetadataEntry{
+		{
+			ExporterIP:   "192.0.2.142",
+			IfIndex:      100,
+			Connectivity: "transit",
+			Provider:     "cogent",
+			Boundary:     externalBoundary,
+		}, {
+			ExporterIP:   "192.0.2.142",
+			IfName:       "Gi0/0/200",
+			Connectivity: "core",
+			Boundary:     internalBoundary,
+		}, {
+			ExporterIP:   "192.0.2.143",
+			IfIndex:      10,
+			Connectivity: "transit",
+			Provider:     "level3",
+			Boundary:     externalBoundary,
+		},
+	}
+	if diff := helpers.Diff(persisted, expectedEntries); diff != "" {
+		t.Fatalf("persisted static metadata (-got, +want):\n%s", diff)
+	}
+
+	authorization := netHTTP.Header{"Authorization": []string{"Bearer secret"}}
+	helpers.TestHTTPEndpoints(t, httpComponent.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/inlet/interfaces",
+			JSONOutput: gin.H{
+				"interfaces": []gin.H{
+					{
+						"exporter-ip":   "192.0.2.142",
+						"exporter-name": "192_0_2_142",
+						"ifindex":       100,
+						"ifname":        "Gi0/0/100",
+						"description":   "Interface 100",
+						"speed":         1000,
+						"connectivity":  "transit",
+						"provider":      "cogent",
+						"boundary":      "external",
+						"static":        true,
+					}, {
+						"exporter-ip":   "192.0.2.142",
+						"exporter-name": "192_0_2_142",
+						"ifindex":       200,
+						"ifname":        "Gi0/0/200",
+						"description":   "Interface 200",
+						"speed":         1000,
+						"connectivity":  "core",
+						"provider":      "",
+						"boundary":      "internal",
+						"static":        true,
+					},
+				},
+			},
+		}, {
+			URL:         "/api/v0/inlet/interfaces",
+			Header:      netHTTP.Header{"Accept": []string{"text/csv"}},
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				"exporter-ip,exporter-name,ifindex,ifname,description,speed,connectivity,provider,boundary,static",
+				"192.0.2.142,192_0_2_142,100,Gi0/0/100,Interface 100,1000,transit,cogent,external,true",
+				"192.0.2.142,192_0_2_142,200,Gi0/0/200,Interface 200,1000,core,,internal,true",
+			},
+		}, {
+			URL:         "/api/v0/inlet/interfaces/static",
+			Header:      netHTTP.Header{"Accept": []string{"text/csv"}},
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				"exporter-ip,ifindex,ifname,description,connectivity,provider,boundary",
+				"192.0.2.142,100,,,transit,cogent,external",
+				"192.0.2.142,,Gi0/0/200,,core,,internal",
+				"192.0.2.143,10,,,transit,level3,external",
+			},
+		}, {
+			Description: "import invalid JSON",
+			Method:      "PUT",
+			URL:         "/api/v0/inlet/interfaces/static",
+			Header:      authorization,
+			JSONInput: gin.H{
+				"interfaces": []gin.H{
+					{"exporter-ip": "192.0.2.142", "ifindex": 200, "description": "Transit: Zayo"},
+					{"exporter-ip": "192.0.2.142", "ifindex": 100, "boundary": "nowhere"},
+				},
+			},
+			StatusCode: 400,
+			JSONOutput: gin.H{
+				"message": "Invalid rows, static interface metadata not imported.",
+				"errors": []gin.H{
+					{"row": 2, "message": "unknown boundary"},
+				},
+			},
+		}, {
+			Description: "import as JSON",
+			Method:      "PUT",
+			URL:         "/api/v0/inlet/interfaces/static",
+			Header:      authorization,
+			JSONInput: gin.H{
+				"interfaces": []gin.H{
+					{"exporter-ip": "192.0.2.142", "ifindex": 200, "description": "Transit: Zayo"},
+				},
+			},
+			JSONOutput: gin.H{
+				"imported": 1,
+				"warnings": []gin.H{},
+			},
+		}, {
+			URL: "/api/v0/inlet/interfaces/static",
+			JSONOutput: gin.H{
+				"interfaces": []gin.H{
+					{"exporter-ip": "192.0.2.142", "ifindex": 200, "description": "Transit: Zayo"},
+				},
+			},
+		},
+	})
+
+	// Hot reload. Files are replaced atomically to avoid reading partial
+	// content.
+	writeFile := func(content string) {
+		t.Helper()
+		tmpFile := fmt.Sprintf("%s.tmp", metadataFile)
+		if err := os.WriteFile(tmpFile, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile() error:\n%+v", err)
+		}
+		if err := os.Rename(tmpFile, metadataFile); err != nil {
+			t.Fatalf("Rename() error:\n%+v", err)
+		}
+	}
+	writeFile(`[{"exporter-ip": "192.0.2.142", "ifindex": 100, "provider": "telia"}]`)
+	time.Sleep(50 * time.Millisecond)
+	got := c.interfaceClassification(time.Now(),
+		exporterInfo{IP: "192.0.2.142", Name: "192_0_2_142"},
+		interfaceInfo{Index: 100, Name: "Gi0/0/100", Description: "Interface 100"})
+	expected := interfaceClassification{
+		Name:        "Gi0/0/100",
+		Description: "Interface 100",
+		Provider:    "telia",
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("interfaceClassification() (-got, +want):\n%s", diff)
+	}
+
+	// Removed file is ignored
+	if err := os.Remove(metadataFile); err != nil {
+		t.Fatalf("Remove() error:\n%+v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if diff := helpers.Diff(c.staticMetadata.list(), []staticMetadataEntry{
+		{ExporterIP: "192.0.2.142", IfIndex: 100, Provider: "telia"},
+	}); diff != "" {
+		t.Fatalf("staticMetadata.list() after removal (-got, +want):\n%s", diff)
+	}
+
+	// Invalid file is ignored
+	writeFile(`[{"ifindex": 100}]`)
+	time.Sleep(50 * time.Millisecond)
+	if diff := helpers.Diff(c.staticMetadata.list(), []staticMetadataEntry{
+		{ExporterIP: "192.0.2.142", IfIndex: 100, Provider: "telia"},
+	}); diff != "" {
+		t.Fatalf("staticMetadata.list() (-got, +want):\n%s", diff)
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_static_metadata_")
+	expectedMetrics := map[string]string{
+		`entries`:             "1",
+		`reload_errors_total`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -15,9 +15,29 @@ type metrics struct {
 	flowsErrors      *reporter.CounterVec
 	flowsHTTPClients reporter.GaugeFunc
 
+	samplingRateAdjustments *reporter.CounterVec
+	flowsSwapped            *reporter.CounterVec
+
+	flowsProcessingTime    [stageLast]reporter.Observer
+	flowsProcessingDropped [stageLast]reporter.Counter
+
 	classifierExporterCacheSize  reporter.CounterFunc
 	classifierInterfaceCacheSize reporter.CounterFunc
 	classifierErrors             *reporter.CounterVec
+
+	throughputDropped reporter.Counter
+
+	staticMetadataEntries      reporter.GaugeFunc
+	staticMetadataReloadErrors reporter.Counter
+
+	threatListUpdates *reporter.CounterVec
+	threatListErrors  *reporter.CounterVec
+	threatListCount   *reporter.GaugeVec
+
+	ruleSetReportErrors reporter.Counter
+
+	schemaVersionReportErrors reporter.Counter
+	schemaVersionProvisioned  reporter.Gauge
 }
 
 func (c *Component) initMetrics() {
@@ -42,6 +62,42 @@ func (c *Component) initMetrics() {
 		},
 		[]string{"exporter", "error"},
 	)
+	c.metrics.samplingRateAdjustments = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "sampling_rate_adjustments",
+			Help: "Number of flows whose sampling rate was overridden or set to the default value.",
+		},
+		[]string{"exporter", "reason"},
+	)
+	c.metrics.flowsSwapped = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "flows_swapped",
+			Help: "Number of flows whose source and destination were swapped to normalize their direction.",
+		},
+		[]string{"exporter"},
+	)
+	processingTime := c.r.HistogramVec(
+		reporter.HistogramOpts{
+			Name: "flows_processing_seconds",
+			Help: "Time spent by flows in each processing stage.",
+			Buckets: []float64{
+				0.000_001, 0.000_005, 0.000_010, 0.000_050,
+				0.000_100, 0.000_500, 0.001, 0.005, 0.010, 0.050,
+			},
+		},
+		[]string{"stage"},
+	)
+	processingDropped := c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "flows_processing_dropped",
+			Help: "Number of flows dropped by each processing stage.",
+		},
+		[]string{"stage"},
+	)
+	for stage := processingStage(0); stage < stageLast; stage++ {
+		c.metrics.flowsProcessingTime[stage] = processingTime.WithLabelValues(stage.String())
+		c.metrics.flowsProcessingDropped[stage] = processingDropped.WithLabelValues(stage.String())
+	}
 	c.metrics.flowsHTTPClients = c.r.GaugeFunc(
 		reporter.GaugeOpts{
 			Name: "flows_http_clients",
@@ -76,4 +132,66 @@ func (c *Component) initMetrics() {
 			Help: "Number of errors when evaluating a classifer",
 		},
 		[]string{"type", "index"})
+
+	c.metrics.throughputDropped = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "throughput_dropped",
+			Help: "Number of flows not accounted in throughput counters due to the series limit.",
+		},
+	)
+
+	c.metrics.staticMetadataEntries = c.r.GaugeFunc(
+		reporter.GaugeOpts{
+			Name: "static_metadata_entries",
+			Help: "Number of static interface metadata entries.",
+		},
+		func() float64 {
+			return float64(c.staticMetadata.size())
+		},
+	)
+	c.metrics.staticMetadataReloadErrors = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "static_metadata_reload_errors_total",
+			Help: "Number of failed reloads of the static interface metadata.",
+		},
+	)
+	c.metrics.threatListUpdates = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "threat_list_updates_total",
+			Help: "Number of successful updates for a threat list.",
+		},
+		[]string{"name"},
+	)
+	c.metrics.threatListErrors = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "threat_list_errors_total",
+			Help: "Number of failed updates for a threat list.",
+		},
+		[]string{"name"},
+	)
+	c.metrics.threatListCount = c.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "threat_list_subnets",
+			Help: "Number of subnets in a threat list.",
+		},
+		[]string{"name"},
+	)
+	c.metrics.ruleSetReportErrors = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "rule_set_report_errors_total",
+			Help: "Number of failed reports of the active rule set to the orchestrator.",
+		},
+	)
+	c.metrics.schemaVersionReportErrors = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "schema_version_report_errors_total",
+			Help: "Number of failed reports of the schema version to the orchestrator.",
+		},
+	)
+	c.metrics.schemaVersionProvisioned = c.r.Gauge(
+		reporter.GaugeOpts{
+			Name: "schema_version_provisioned",
+			Help: "Whether the schema version is provisioned in ClickHouse.",
+		},
+	)
 }
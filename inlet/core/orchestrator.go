@@ -0,0 +1,72 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+)
+
+// errOrchestratorNotFound is returned when the orchestrator does not know
+// about the requested endpoint. This happens when it is not upgraded yet.
+var errOrchestratorNotFound = errors.New("endpoint not found on orchestrator")
+
+// postToOrchestrator sends a JSON payload to the provided endpoint of the
+// orchestrator and decodes the answer into response, unless it is nil. It
+// retries until it succeeds or the component is stopped. In the latter case,
+// no error is returned. notify is called on each failed attempt.
+func (c *Component) postToOrchestrator(path string, payload interface{}, response interface{}, notify func(error)) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("cannot serialize payload: %w", err)
+	}
+	url := fmt.Sprintf("%s%s", c.config.OrchestratorURL, path)
+
+	ctx := c.t.Context(nil)
+	customBackoff := backoff.NewExponentialBackOff()
+	customBackoff.MaxElapsedTime = 0
+	customBackoff.MaxInterval = 5 * time.Minute
+	err = backoff.RetryNotify(func() error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return backoff.Permanent(err)
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		switch resp.StatusCode {
+		case http.StatusOK:
+		case http.StatusNotFound:
+			return backoff.Permanent(errOrchestratorNotFound)
+		default:
+			return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+		}
+		if response == nil {
+			return nil
+		}
+		if err := json.NewDecoder(resp.Body).Decode(response); err != nil {
+			return fmt.Errorf("cannot decode answer: %w", err)
+		}
+		return nil
+	}, backoff.WithContext(customBackoff, ctx), func(err error, _ time.Duration) {
+		notify(err)
+	})
+	if err != nil && ctx.Err() != nil {
+		// Component stopped
+		return nil
+	}
+	return err
+}
@@ -6,12 +6,16 @@ package core
 
 import (
 	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/daemon"
+	"akvorado/common/helpers"
 	"akvorado/common/helpers/cache"
 	"akvorado/common/http"
 	"akvorado/common/reporter"
@@ -40,6 +44,29 @@ type Component struct {
 	classifierExporterCache  *cache.Cache[exporterInfo, exporterClassification]
 	classifierInterfaceCache *cache.Cache[exporterAndInterfaceInfo, interfaceClassification]
 	classifierErrLogger      reporter.Logger
+
+	throughput *throughputStore
+
+	geoCountry   bool // lookup country
+	geoLocation  bool // lookup city, region and coordinates
+	billingClass bool // classify interfaces for billing class
+	vrfNames     bool // resolve VRF names
+
+	// interfaceAttributes maps attributes from interface classifiers to
+	// custom dimensions (for example, "pop" to InIfPop and OutIfPop).
+	interfaceAttributes []interfaceAttributeColumns
+	// exporterTags maps static exporter tags to custom dimensions (for
+	// example, "environment" to ExporterEnvironment).
+	exporterTags map[string]*schema.Column
+
+	staticMetadata     *staticMetadata
+	staticMetadataLock sync.Mutex // serialize updates of static metadata
+
+	threatLists     map[string][]netip.Prefix
+	threatListsLock sync.Mutex // serialize updates of threat lists
+	threatListsTree atomic.Pointer[helpers.SubnetMap[string]]
+
+	anonymizer *anonymizer
 }
 
 // Dependencies define the dependencies of the HTTP component.
@@ -69,6 +96,57 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		classifierExporterCache:  cache.New[exporterInfo, exporterClassification](),
 		classifierInterfaceCache: cache.New[exporterAndInterfaceInfo, interfaceClassification](),
 		classifierErrLogger:      r.Sample(reporter.BurstSampler(10*time.Second, 3)),
+
+		throughput: newThroughputStore(configuration.ThroughputSeriesLimit),
+
+		staticMetadata: newStaticMetadata(),
+		threatLists:    map[string][]netip.Prefix{},
+	}
+	for _, key := range []schema.ColumnKey{schema.ColumnSrcCountry, schema.ColumnDstCountry} {
+		if column, ok := c.d.Schema.LookupColumnByKey(key); ok && !column.Disabled {
+			c.geoCountry = true
+		}
+	}
+	for _, key := range []schema.ColumnKey{
+		schema.ColumnSrcCity, schema.ColumnDstCity,
+		schema.ColumnSrcRegion, schema.ColumnDstRegion,
+		schema.ColumnSrcLatitude, schema.ColumnDstLatitude,
+		schema.ColumnSrcLongitude, schema.ColumnDstLongitude,
+	} {
+		if column, ok := c.d.Schema.LookupColumnByKey(key); ok && !column.Disabled {
+			c.geoLocation = true
+		}
+	}
+	if column, ok := c.d.Schema.LookupColumnByKey(schema.ColumnInIfBillingClass); ok && !column.Disabled {
+		c.billingClass = true
+	}
+	if column, ok := c.d.Schema.LookupColumnByKey(schema.ColumnSrcVRF); ok && !column.Disabled {
+		c.vrfNames = true
+	}
+	c.interfaceAttributes = interfaceAttributesFromSchema(c.d.Schema)
+	c.exporterTags = exporterTagsFromSchema(c.d.Schema)
+	for _, tags := range configuration.ExporterTags.ToMap() {
+		for name := range tags {
+			if _, ok := c.exporterTags[strings.ToLower(name)]; !ok {
+				return nil, fmt.Errorf("no custom dimension for exporter tag %q", name)
+			}
+		}
+	}
+	anonymizer, err := newAnonymizer(configuration.Anonymization)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize anonymization: %w", err)
+	}
+	c.anonymizer = anonymizer
+	if anonymizer != nil {
+		// MAC addresses cannot be anonymized
+		for _, key := range []schema.ColumnKey{schema.ColumnSrcMAC, schema.ColumnDstMAC} {
+			if column, ok := c.d.Schema.LookupColumnByKey(key); ok && !column.Disabled {
+				return nil, fmt.Errorf("cannot anonymize addresses when %s column is enabled", column.Name)
+			}
+		}
+		if c.d.Flow != nil {
+			c.d.Flow.DisableRawAccess()
+		}
 	}
 	c.d.Daemon.Track(&c.t, "inlet/core")
 	c.initMetrics()
@@ -78,6 +156,15 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 // Start starts the core component.
 func (c *Component) Start() error {
 	c.r.Info().Msg("starting core component")
+	if c.config.StaticInterfaceMetadataFile != "" {
+		if err := c.loadStaticMetadata(); err != nil {
+			return err
+		}
+		if err := c.watchStaticMetadata(); err != nil {
+			return err
+		}
+	}
+	c.startThreatLists()
 	for i := 0; i < c.config.Workers; i++ {
 		workerID := i
 		c.t.Go(func() error {
@@ -99,8 +186,44 @@ func (c *Component) Start() error {
 		}
 	})
 
+	// Throughput counters expiration
+	c.t.Go(func() error {
+		ticker := time.NewTicker(throughputBucketWidth)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.t.Dying():
+				return nil
+			case <-ticker.C:
+				c.throughput.expire(time.Now())
+			}
+		}
+	})
+
+	// Report the active rule set to the orchestrator
+	if c.config.OrchestratorURL != "" {
+		c.t.Go(func() error {
+			// Not being able to report is not fatal
+			if err := c.reportRuleSet(); err != nil {
+				c.r.Err(err).Msg("unable to report active rule set")
+			}
+			return nil
+		})
+		c.t.Go(func() error {
+			if err := c.reportSchemaVersion(); err != nil {
+				c.r.Err(err).Msg("unable to report schema version")
+			}
+			return nil
+		})
+	}
+
 	c.r.RegisterHealthcheck("core", c.channelHealthcheck())
 	c.d.HTTP.GinRouter.GET("/api/v0/inlet/flows", c.FlowsHTTPHandler)
+	c.d.HTTP.GinRouter.GET("/api/v0/inlet/throughput", c.ThroughputHTTPHandler)
+	c.d.HTTP.GinRouter.GET("/api/v0/inlet/interfaces", c.InterfacesHTTPHandler)
+	c.d.HTTP.GinRouter.GET("/api/v0/inlet/interfaces/static", c.StaticMetadataHTTPHandler)
+	c.d.HTTP.GinRouter.PUT("/api/v0/inlet/interfaces/static", c.adminAuthentication, c.StaticMetadataImportHTTPHandler)
+	c.d.HTTP.GinRouter.POST("/api/v0/inlet/caches/flush", c.adminAuthentication, c.FlushCachesHTTPHandler)
 	return nil
 }
 
@@ -108,6 +231,7 @@ func (c *Component) Start() error {
 func (c *Component) runWorker(workerID int) error {
 	c.r.Debug().Int("worker", workerID).Msg("starting core worker")
 
+	var processed uint
 	for {
 		select {
 		case <-c.t.Dying():
@@ -127,26 +251,47 @@ func (c *Component) runWorker(workerID int) error {
 			c.metrics.flowsReceived.WithLabelValues(exporter).Inc()
 
 			// Enrichment
+			timer := c.startStages(processed%stageSamplingRate == 0)
+			processed++
 			ip := flow.ExporterAddress
-			if skip := c.enrichFlow(ip, exporter, flow); skip {
+			if skip := c.enrichFlow(ip, exporter, flow, &timer); skip {
+				schema.ReleaseFlowMessage(flow)
 				continue
 			}
 
 			// Serialize flow to Protobuf
 			buf := c.d.Schema.ProtobufMarshal(flow)
+			timer.done(stageSerialization)
 
 			// Forward to Kafka. This could block and buf is now owned by the
 			// Kafka subsystem!
 			c.metrics.flowsForwarded.WithLabelValues(exporter).Inc()
 			c.d.Kafka.Send(exporter, buf)
+			timer.done(stageForwarding)
+
+			// Update in-memory throughput counters
+			if c.config.ThroughputSeriesLimit > 0 {
+				counters := flow.Counters()
+				if !c.throughput.add(time.Now(), throughputKey{
+					exporter:      ip,
+					inIfBoundary:  counters.InIfBoundary,
+					outIfBoundary: counters.OutIfBoundary,
+				}, counters.Bytes*uint64(flow.SamplingRate),
+					counters.Packets*uint64(flow.SamplingRate)) {
+					c.metrics.throughputDropped.Inc()
+				}
+			}
 
-			// If we have HTTP clients, send to them too
+			// If we have HTTP clients, send to them too. Otherwise, the
+			// flow can be recycled.
 			if atomic.LoadUint32(&c.httpFlowClients) > 0 {
 				select {
 				case c.httpFlowChannel <- flow: // OK
+					continue
 				default: // Overflow, best effort and ignore
 				}
 			}
+			schema.ReleaseFlowMessage(flow)
 
 		}
 	}
@@ -0,0 +1,73 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+
+	"akvorado/common/helpers"
+	"akvorado/common/helpers/yaml"
+)
+
+// RuleSet is the part of the configuration changing the semantics of the
+// enriched flows. The orchestrator records its changes in a changelog.
+type RuleSet struct {
+	ExporterClassifiers  []ExporterClassifierRule
+	InterfaceClassifiers []InterfaceClassifierRule
+	DefaultSamplingRate  helpers.SubnetMap[uint]
+	OverrideSamplingRate helpers.SubnetMap[uint]
+	TrafficClasses       []TrafficClassRule
+	DefaultTrafficClass  string
+}
+
+// RuleSet extracts the rule set from the configuration.
+func (c Configuration) RuleSet() RuleSet {
+	return RuleSet{
+		ExporterClassifiers:  c.ExporterClassifiers,
+		InterfaceClassifiers: c.InterfaceClassifiers,
+		DefaultSamplingRate:  c.DefaultSamplingRate,
+		OverrideSamplingRate: c.OverrideSamplingRate,
+		TrafficClasses:       c.TrafficClasses,
+		DefaultTrafficClass:  c.DefaultTrafficClass,
+	}
+}
+
+// Hash returns the SHA-256 hash of the YAML representation of the rule set.
+// The orchestrator uses the same scheme for the changelog.
+func (rs RuleSet) Hash() (string, error) {
+	content, err := yaml.Marshal(rs)
+	if err != nil {
+		return "", fmt.Errorf("cannot serialize rule set: %w", err)
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+type ruleSetReport struct {
+	Instance string `json:"instance"`
+	Hash     string `json:"hash"`
+}
+
+// reportRuleSet sends the hash of the active rule set to the orchestrator.
+// It retries until it succeeds or the component is stopped.
+func (c *Component) reportRuleSet() error {
+	hash, err := c.config.RuleSet().Hash()
+	if err != nil {
+		return err
+	}
+	instance, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("cannot get hostname: %w", err)
+	}
+	c.r.Debug().Str("hash", hash).Msg("report active rule set to orchestrator")
+	return c.postToOrchestrator("/api/v0/orchestrator/changelog/inlet",
+		ruleSetReport{Instance: instance, Hash: hash}, nil,
+		func(err error) {
+			c.metrics.ruleSetReportErrors.Inc()
+			c.r.Err(err).Msg("cannot report active rule set to orchestrator")
+		})
+}
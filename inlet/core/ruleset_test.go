@@ -0,0 +1,132 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"encoding/json"
+	netHTTP "net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/bmp"
+	"akvorado/inlet/flow"
+	"akvorado/inlet/geoip"
+	"akvorado/inlet/kafka"
+	"akvorado/inlet/snmp"
+)
+
+func TestRuleSetHash(t *testing.T) {
+	hash := func(config Configuration) string {
+		t.Helper()
+		h, err := config.RuleSet().Hash()
+		if err != nil {
+			t.Fatalf("Hash() error:\n%+v", err)
+		}
+		return h
+	}
+	classifier := func(rule string) ExporterClassifierRule {
+		t.Helper()
+		var r ExporterClassifierRule
+		if err := r.UnmarshalText([]byte(rule)); err != nil {
+			t.Fatalf("UnmarshalText() error:\n%+v", err)
+		}
+		return r
+	}
+
+	config1 := DefaultConfiguration()
+	config1.ExporterClassifiers = []ExporterClassifierRule{classifier(`ClassifyRegion("europe")`)}
+	config2 := DefaultConfiguration()
+	config2.ExporterClassifiers = []ExporterClassifierRule{classifier(`ClassifyRegion("europe")`)}
+	config2.Workers = 4
+	config3 := DefaultConfiguration()
+	config3.ExporterClassifiers = []ExporterClassifierRule{classifier(`ClassifyRegion("asia")`)}
+
+	if hash(config1) != hash(config2) {
+		t.Error("Hash() should not depend on settings outside of the rule set")
+	}
+	if hash(config1) == hash(config3) {
+		t.Error("Hash() should change when classifier rules change")
+	}
+	if hash(config1) == hash(DefaultConfiguration()) {
+		t.Error("Hash() should change when classifier rules are removed")
+	}
+}
+
+func TestReportRuleSet(t *testing.T) {
+	r := reporter.NewMock(t)
+	daemonComponent := daemon.NewMock(t)
+	snmpComponent := snmp.NewMock(t, r, snmp.DefaultConfiguration(),
+		snmp.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	geoipComponent := geoip.NewMock(t, r)
+	kafkaComponent, _ := kafka.NewMock(t, r, kafka.DefaultConfiguration())
+	httpComponent := http.NewMock(t, r)
+	bmpComponent, _ := bmp.NewMock(t, r, bmp.DefaultConfiguration())
+
+	// Fake orchestrator, failing the first time
+	received := make(chan ruleSetReport, 1)
+	attempts := 0
+	orchestrator := httptest.NewServer(netHTTP.HandlerFunc(func(w netHTTP.ResponseWriter, req *netHTTP.Request) {
+		if req.Method != "POST" || req.URL.Path != "/api/v0/orchestrator/changelog/inlet" {
+			w.WriteHeader(netHTTP.StatusNotFound)
+			return
+		}
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(netHTTP.StatusInternalServerError)
+			return
+		}
+		var report ruleSetReport
+		if err := json.NewDecoder(req.Body).Decode(&report); err != nil {
+			w.WriteHeader(netHTTP.StatusBadRequest)
+			return
+		}
+		received <- report
+		w.WriteHeader(netHTTP.StatusOK)
+	}))
+	defer orchestrator.Close()
+
+	configuration := DefaultConfiguration()
+	configuration.OrchestratorURL = orchestrator.URL
+	configuration.DefaultTrafficClass = "transit"
+	c, err := New(r, configuration, Dependencies{
+		Daemon: daemonComponent,
+		Flow:   flowComponent,
+		SNMP:   snmpComponent,
+		GeoIP:  geoipComponent,
+		Kafka:  kafkaComponent,
+		HTTP:   httpComponent,
+		BMP:    bmpComponent,
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	select {
+	case got := <-received:
+		hostname, _ := os.Hostname()
+		hash, _ := configuration.RuleSet().Hash()
+		expected := ruleSetReport{Instance: hostname, Hash: hash}
+		if diff := helpers.Diff(got, expected); diff != "" {
+			t.Fatalf("reportRuleSet() (-got, +want):\n%s", diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reportRuleSet() did not report to orchestrator")
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_", "rule_set_report_errors_total")
+	expectedMetrics := map[string]string{"rule_set_report_errors_total": "1"}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,60 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"os"
+)
+
+type schemaVersionReport struct {
+	Instance string `json:"instance"`
+	Hash     string `json:"hash"`
+}
+
+type schemaVersionAnswer struct {
+	Current     bool `json:"current"`
+	Provisioned bool `json:"provisioned"`
+}
+
+// reportSchemaVersion asks the orchestrator if the version of the protobuf
+// schema used to encode flows is provisioned in ClickHouse. When it is not,
+// flows are kept in Kafka until the orchestrator is upgraded. It retries until
+// it succeeds or the component is stopped.
+func (c *Component) reportSchemaVersion() error {
+	hash := c.d.Schema.ProtobufMessageHash()
+	instance, err := os.Hostname()
+	if err != nil {
+		return fmt.Errorf("cannot get hostname: %w", err)
+	}
+	c.r.Debug().Str("hash", hash).Msg("report schema version to orchestrator")
+	var answer schemaVersionAnswer
+	err = c.postToOrchestrator("/api/v0/orchestrator/clickhouse/schema-version",
+		schemaVersionReport{Instance: instance, Hash: hash}, &answer,
+		func(err error) {
+			c.metrics.schemaVersionReportErrors.Inc()
+			c.r.Err(err).Msg("cannot report schema version to orchestrator")
+		})
+	if errors.Is(err, errOrchestratorNotFound) {
+		c.r.Warn().Msg("orchestrator does not support schema versions, it should be upgraded first")
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !answer.Provisioned {
+		c.metrics.schemaVersionProvisioned.Set(0)
+		c.r.Warn().
+			Str("hash", hash).
+			Msg("schema version not provisioned in ClickHouse, flows are not consumed until the orchestrator is upgraded")
+		return nil
+	}
+	c.metrics.schemaVersionProvisioned.Set(1)
+	if !answer.Current {
+		c.r.Info().
+			Str("hash", hash).
+			Msg("schema version is outdated, inlet should be upgraded")
+	}
+	return nil
+}
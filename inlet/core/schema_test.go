@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"encoding/json"
+	netHTTP "net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/bmp"
+	"akvorado/inlet/flow"
+	"akvorado/inlet/geoip"
+	"akvorado/inlet/kafka"
+	"akvorado/inlet/snmp"
+)
+
+func TestReportSchemaVersion(t *testing.T) {
+	r := reporter.NewMock(t)
+	daemonComponent := daemon.NewMock(t)
+	snmpComponent := snmp.NewMock(t, r, snmp.DefaultConfiguration(),
+		snmp.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	geoipComponent := geoip.NewMock(t, r)
+	kafkaComponent, _ := kafka.NewMock(t, r, kafka.DefaultConfiguration())
+	httpComponent := http.NewMock(t, r)
+	bmpComponent, _ := bmp.NewMock(t, r, bmp.DefaultConfiguration())
+
+	// Fake orchestrator, still migrating the first time
+	received := make(chan schemaVersionReport, 1)
+	attempts := 0
+	orchestrator := httptest.NewServer(netHTTP.HandlerFunc(func(w netHTTP.ResponseWriter, req *netHTTP.Request) {
+		if req.Method != "POST" || req.URL.Path != "/api/v0/orchestrator/clickhouse/schema-version" {
+			w.WriteHeader(netHTTP.StatusNotFound)
+			return
+		}
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(netHTTP.StatusServiceUnavailable)
+			return
+		}
+		var report schemaVersionReport
+		if err := json.NewDecoder(req.Body).Decode(&report); err != nil {
+			w.WriteHeader(netHTTP.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(netHTTP.StatusOK)
+		w.Write([]byte(`{"current": true, "provisioned": true}`))
+		received <- report
+	}))
+	defer orchestrator.Close()
+
+	configuration := DefaultConfiguration()
+	configuration.OrchestratorURL = orchestrator.URL
+	c, err := New(r, configuration, Dependencies{
+		Daemon: daemonComponent,
+		Flow:   flowComponent,
+		SNMP:   snmpComponent,
+		GeoIP:  geoipComponent,
+		Kafka:  kafkaComponent,
+		HTTP:   httpComponent,
+		BMP:    bmpComponent,
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	select {
+	case got := <-received:
+		hostname, _ := os.Hostname()
+		expected := schemaVersionReport{Instance: hostname, Hash: c.d.Schema.ProtobufMessageHash()}
+		if diff := helpers.Diff(got, expected); diff != "" {
+			t.Fatalf("reportSchemaVersion() (-got, +want):\n%s", diff)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reportSchemaVersion() did not report to orchestrator")
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_", "schema_version_")
+	expectedMetrics := map[string]string{
+		"schema_version_report_errors_total": "1",
+		"schema_version_provisioned":         "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,79 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import "time"
+
+// processingStage is a stage of the processing of a flow. Stages are executed
+// in order by each worker. To find a bottleneck, the time spent in each stage
+// is measured for a sample of the flows, while drops are always counted.
+type processingStage int
+
+const (
+	// stageMetadata retrieves interface metadata and checks the sampling rate.
+	stageMetadata processingStage = iota
+	// stageClassification classifies the exporter and the interfaces.
+	stageClassification
+	// stageRouting retrieves AS numbers and routing information.
+	stageRouting
+	// stageGeoIP retrieves geolocation and checks threat lists.
+	stageGeoIP
+	// stageSerialization serializes the flow to protobuf.
+	stageSerialization
+	// stageForwarding sends the flow to Kafka.
+	stageForwarding
+
+	stageLast
+)
+
+var processingStageNames = [stageLast]string{
+	stageMetadata:       "metadata",
+	stageClassification: "classification",
+	stageRouting:        "routing",
+	stageGeoIP:          "geoip",
+	stageSerialization:  "serialization",
+	stageForwarding:     "forwarding",
+}
+
+// String turns a processing stage to a string.
+func (ps processingStage) String() string {
+	return processingStageNames[ps]
+}
+
+// stageSamplingRate is the number of flows processed by a worker for each flow
+// whose stages are timed. Timing every flow would slow down the hot path.
+const stageSamplingRate = 100
+
+// stageTimer measures the time spent by a flow in each stage.
+type stageTimer struct {
+	c       *Component
+	start   time.Time
+	sampled bool
+}
+
+// startStages returns a timer for the stages of a flow. When the flow is not
+// sampled, the timer only counts drops.
+func (c *Component) startStages(sampled bool) stageTimer {
+	if !sampled {
+		return stageTimer{c: c}
+	}
+	return stageTimer{c: c, start: time.Now(), sampled: true}
+}
+
+// done records the time spent in the provided stage and starts the next one.
+func (st *stageTimer) done(stage processingStage) {
+	if !st.sampled {
+		return
+	}
+	now := time.Now()
+	st.c.metrics.flowsProcessingTime[stage].Observe(now.Sub(st.start).Seconds())
+	st.start = now
+}
+
+// drop records the time spent in the provided stage and the flow as dropped
+// by this stage.
+func (st *stageTimer) drop(stage processingStage) {
+	st.done(stage)
+	st.c.metrics.flowsProcessingDropped[stage].Inc()
+}
@@ -0,0 +1,74 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"testing"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/bmp"
+	"akvorado/inlet/flow"
+	"akvorado/inlet/geoip"
+	"akvorado/inlet/kafka"
+	"akvorado/inlet/snmp"
+)
+
+func TestStageTimer(t *testing.T) {
+	r := reporter.NewMock(t)
+	daemonComponent := daemon.NewMock(t)
+	snmpComponent := snmp.NewMock(t, r, snmp.DefaultConfiguration(),
+		snmp.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	geoipComponent := geoip.NewMock(t, r)
+	kafkaComponent, _ := kafka.NewMock(t, r, kafka.DefaultConfiguration())
+	httpComponent := http.NewMock(t, r)
+	bmpComponent, _ := bmp.NewMock(t, r, bmp.DefaultConfiguration())
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon: daemonComponent,
+		Flow:   flowComponent,
+		SNMP:   snmpComponent,
+		GeoIP:  geoipComponent,
+		Kafka:  kafkaComponent,
+		HTTP:   httpComponent,
+		BMP:    bmpComponent,
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	timer := c.startStages(true)
+	timer.done(stageMetadata)
+	timer.drop(stageClassification)
+	timer = c.startStages(true)
+	timer.drop(stageMetadata)
+	// Flows not sampled are only counted when dropped
+	timer = c.startStages(false)
+	timer.done(stageMetadata)
+	timer.drop(stageClassification)
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_flows_processing_",
+		"dropped", "seconds_count")
+	expectedMetrics := map[string]string{
+		`dropped{stage="classification"}`:       "2",
+		`dropped{stage="forwarding"}`:           "0",
+		`dropped{stage="geoip"}`:                "0",
+		`dropped{stage="metadata"}`:             "1",
+		`dropped{stage="routing"}`:              "0",
+		`dropped{stage="serialization"}`:        "0",
+		`seconds_count{stage="classification"}`: "1",
+		`seconds_count{stage="forwarding"}`:     "0",
+		`seconds_count{stage="geoip"}`:          "0",
+		`seconds_count{stage="metadata"}`:       "2",
+		`seconds_count{stage="routing"}`:        "0",
+		`seconds_count{stage="serialization"}`:  "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,197 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/netip"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"akvorado/common/helpers"
+)
+
+// ThreatListSource defines a source for a threat list. The list contains one
+// IP address or one subnet per line. Comments start with `#` or `;`.
+type ThreatListSource struct {
+	// File is the path to a local file containing the list.
+	File string `validate:"required_without=URL,excluded_with=URL" doc:"File containing the threat list"`
+	// URL is the URL to fetch to get the list.
+	URL string `validate:"omitempty,url" doc:"URL to fetch the threat list from"`
+	// Timeout tells the maximum time the remote request should take
+	Timeout time.Duration `validate:"isdefault|min=1s" doc:"Maximum duration of the request"`
+	// Interval tells how much time to wait before updating the list.
+	Interval time.Duration `validate:"min=1m" doc:"Interval between two updates"`
+}
+
+// parseThreatList parses a threat list. It returns the list of subnets as
+// well as the number of lines which cannot be parsed.
+func parseThreatList(r io.Reader) ([]netip.Prefix, int, error) {
+	prefixes := []netip.Prefix{}
+	invalid := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if idx := strings.IndexAny(line, "#;"); idx >= 0 {
+			line = line[:idx]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if prefix, err := netip.ParsePrefix(line); err == nil {
+			prefixes = append(prefixes, prefix.Masked())
+		} else if addr, err := netip.ParseAddr(line); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+		} else {
+			invalid++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, 0, err
+	}
+	return prefixes, invalid, nil
+}
+
+// updateThreatList updates a threat list from its source. It returns the
+// number of subnets retrieved.
+func (c *Component) updateThreatList(ctx context.Context, name string, source ThreatListSource) (int, error) {
+	l := c.r.With().Str("name", name).Logger()
+	var reader io.Reader
+	if source.File != "" {
+		l = l.With().Str("file", source.File).Logger()
+		f, err := os.Open(source.File)
+		if err != nil {
+			l.Err(err).Msg("unable to open threat list")
+			return 0, fmt.Errorf("unable to open threat list: %w", err)
+		}
+		defer f.Close()
+		reader = f
+	} else {
+		l = l.With().Str("url", source.URL).Logger()
+		client := &http.Client{Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+		}}
+		req, err := http.NewRequestWithContext(ctx, "GET", source.URL, nil)
+		if err != nil {
+			l.Err(err).Msg("unable to build new request")
+			return 0, fmt.Errorf("unable to build new request: %w", err)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			l.Err(err).Msg("unable to fetch threat list")
+			return 0, fmt.Errorf("unable to fetch threat list: %w", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			err := fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, resp.Status)
+			l.Error().Msg(err.Error())
+			return 0, err
+		}
+		reader = resp.Body
+	}
+	prefixes, invalid, err := parseThreatList(reader)
+	if err != nil {
+		l.Err(err).Msg("unable to read threat list")
+		return 0, fmt.Errorf("unable to read threat list: %w", err)
+	}
+	if invalid > 0 {
+		l.Warn().Int("invalid", invalid).Msg("some entries of the threat list were ignored")
+	}
+
+	c.threatListsLock.Lock()
+	defer c.threatListsLock.Unlock()
+	c.threatLists[name] = prefixes
+	if err := c.buildThreatLists(); err != nil {
+		l.Err(err).Msg("unable to build threat lists")
+		return 0, fmt.Errorf("unable to build threat lists: %w", err)
+	}
+	l.Debug().Int("subnets", len(prefixes)).Msg("threat list updated")
+	return len(prefixes), nil
+}
+
+// buildThreatLists builds the lookup tree from the current threat lists. When
+// the same subnet appears in several lists, the first list in alphabetical
+// order wins. The caller should hold the threat lists lock.
+func (c *Component) buildThreatLists() error {
+	names := make([]string, 0, len(c.threatLists))
+	for name := range c.threatLists {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	subnets := map[string]string{}
+	for _, name := range names {
+		for _, prefix := range c.threatLists[name] {
+			if prefix.Addr().Is4() {
+				prefix = netip.PrefixFrom(netip.AddrFrom16(prefix.Addr().As16()), prefix.Bits()+96)
+			}
+			key := prefix.String()
+			if _, ok := subnets[key]; !ok {
+				subnets[key] = name
+			}
+		}
+	}
+	tree, err := helpers.NewSubnetMap(subnets)
+	if err != nil {
+		return err
+	}
+	c.threatListsTree.Store(tree)
+	return nil
+}
+
+// lookupThreatList returns the name of the threat list matching the first
+// provided address found in one of the lists.
+func (c *Component) lookupThreatList(addrs ...netip.Addr) string {
+	tree := c.threatListsTree.Load()
+	if tree == nil {
+		return ""
+	}
+	for _, addr := range addrs {
+		if !addr.IsValid() {
+			continue
+		}
+		if name, ok := tree.Lookup(netip.AddrFrom16(addr.As16())); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// startThreatLists starts to periodically update the threat lists.
+func (c *Component) startThreatLists() {
+	for name, source := range c.config.ThreatLists {
+		if source.Timeout == 0 {
+			source.Timeout = time.Minute
+		}
+		name := name
+		source := source
+		c.t.Go(func() error {
+			ticker := time.NewTicker(source.Interval)
+			defer ticker.Stop()
+			for {
+				ctx, cancel := context.WithTimeout(c.t.Context(nil), source.Timeout)
+				count, err := c.updateThreatList(ctx, name, source)
+				cancel()
+				if err == nil {
+					c.metrics.threatListUpdates.WithLabelValues(name).Inc()
+					c.metrics.threatListCount.WithLabelValues(name).Set(float64(count))
+				} else {
+					// Keep the previous version of the list
+					c.metrics.threatListErrors.WithLabelValues(name).Inc()
+				}
+				select {
+				case <-c.t.Dying():
+					return nil
+				case <-ticker.C:
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"fmt"
+	netHTTP "net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/bmp"
+	"akvorado/inlet/flow"
+	"akvorado/inlet/geoip"
+	"akvorado/inlet/kafka"
+	"akvorado/inlet/snmp"
+)
+
+func TestParseThreatList(t *testing.T) {
+	input := `
+; Spamhaus DROP List
+192.0.2.0/24 ; SBL1
+198.51.100.14 # single host
+2001:db8:1::/48
+192.0.2.129/25
+not an IP
+`
+	got, invalid, err := parseThreatList(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("parseThreatList() error:\n%+v", err)
+	}
+	expected := []netip.Prefix{
+		netip.MustParsePrefix("192.0.2.0/24"),
+		netip.MustParsePrefix("198.51.100.14/32"),
+		netip.MustParsePrefix("2001:db8:1::/48"),
+		netip.MustParsePrefix("192.0.2.128/25"),
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Errorf("parseThreatList() (-got, +want):\n%s", diff)
+	}
+	if invalid != 1 {
+		t.Errorf("parseThreatList() invalid = %d, expected 1", invalid)
+	}
+}
+
+func TestThreatLists(t *testing.T) {
+	r := reporter.NewMock(t)
+	daemonComponent := daemon.NewMock(t)
+	snmpComponent := snmp.NewMock(t, r, snmp.DefaultConfiguration(),
+		snmp.Dependencies{Daemon: daemonComponent})
+	flowComponent := flow.NewMock(t, r, flow.DefaultConfiguration())
+	geoipComponent := geoip.NewMock(t, r)
+	kafkaComponent, _ := kafka.NewMock(t, r, kafka.DefaultConfiguration())
+	httpComponent := http.NewMock(t, r)
+	bmpComponent, _ := bmp.NewMock(t, r, bmp.DefaultConfiguration())
+
+	listFile := filepath.Join(t.TempDir(), "blocklist.txt")
+	if err := os.WriteFile(listFile, []byte("192.0.2.0/24\n2001:db8:1::/48\n"), 0o644); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+	server := httptest.NewServer(netHTTP.HandlerFunc(func(w netHTTP.ResponseWriter, _ *netHTTP.Request) {
+		fmt.Fprint(w, "# remote list\n192.0.2.128/25\n198.51.100.0/24\n")
+	}))
+	defer server.Close()
+
+	configuration := DefaultConfiguration()
+	configuration.ThreatLists = map[string]ThreatListSource{
+		"blocklist": {File: listFile, Interval: time.Hour},
+		"remote":    {URL: server.URL, Interval: time.Hour},
+	}
+	c, err := New(r, configuration, Dependencies{
+		Daemon: daemonComponent,
+		Flow:   flowComponent,
+		SNMP:   snmpComponent,
+		GeoIP:  geoipComponent,
+		Kafka:  kafkaComponent,
+		HTTP:   httpComponent,
+		BMP:    bmpComponent,
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	// Wait for both lists to be loaded
+	for i := 0; ; i++ {
+		gotMetrics := r.GetMetrics("akvorado_inlet_core_", "threat_list_updates_total")
+		if len(gotMetrics) == 2 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("threat lists not loaded:\n%v", gotMetrics)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cases := []struct {
+		Addrs    []string
+		Expected string
+	}{
+		{[]string{"::ffff:192.0.2.10"}, "blocklist"},
+		{[]string{"::ffff:192.0.2.200"}, "remote"}, // most specific wins
+		{[]string{"::ffff:198.51.100.1"}, "remote"},
+		{[]string{"2001:db8:1::1"}, "blocklist"},
+		{[]string{"::ffff:203.0.113.1"}, ""},
+		{[]string{"::ffff:203.0.113.1", "::ffff:198.51.100.1"}, "remote"},
+		{[]string{"::ffff:192.0.2.10", "::ffff:198.51.100.1"}, "blocklist"},
+	}
+	for _, tc := range cases {
+		addrs := []netip.Addr{}
+		for _, addr := range tc.Addrs {
+			addrs = append(addrs, netip.MustParseAddr(addr))
+		}
+		if got := c.lookupThreatList(addrs...); got != tc.Expected {
+			t.Errorf("lookupThreatList(%v) = %q, expected %q", tc.Addrs, got, tc.Expected)
+		}
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_core_", "threat_list_")
+	expectedMetrics := map[string]string{
+		`threat_list_subnets{name="blocklist"}`:       "2",
+		`threat_list_subnets{name="remote"}`:          "2",
+		`threat_list_updates_total{name="blocklist"}`: "1",
+		`threat_list_updates_total{name="remote"}`:    "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// throughputBucketWidth is the duration covered by a bucket.
+	throughputBucketWidth = time.Minute
+	// throughputBuckets is the number of buckets kept for each serie.
+	throughputBuckets = 60
+)
+
+// throughputKey identifies a serie of throughput counters.
+type throughputKey struct {
+	exporter      netip.Addr
+	inIfBoundary  uint8
+	outIfBoundary uint8
+}
+
+// throughputBucket contains the counters for one bucket. The bucket is
+// identified by its start, in number of bucket widths since epoch.
+type throughputBucket struct {
+	index   int64
+	bytes   uint64
+	packets uint64
+}
+
+// throughputSerie is a ring of buckets.
+type throughputSerie [throughputBuckets]throughputBucket
+
+// throughputStore keeps throughput counters for the last hour, for each
+// exporter and each pair of boundaries. It does not track more than the
+// provided number of series.
+type throughputStore struct {
+	lock        sync.Mutex
+	limit       int
+	series      map[throughputKey]*throughputSerie
+	lastExpired int64
+}
+
+// newThroughputStore creates a new store for throughput counters.
+func newThroughputStore(limit int) *throughputStore {
+	return &throughputStore{
+		limit:  limit,
+		series: map[throughputKey]*throughputSerie{},
+	}
+}
+
+// add accounts the provided bytes and packets at the given time. It returns
+// false if the counters were dropped because the store is full.
+func (ts *throughputStore) add(t time.Time, key throughputKey, bytes, packets uint64) bool {
+	index := t.UnixNano() / int64(throughputBucketWidth)
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	serie, ok := ts.series[key]
+	if !ok {
+		if len(ts.series) >= ts.limit {
+			// Expiring is costly, do it at most once per bucket
+			if ts.lastExpired != index {
+				ts.expireLocked(t)
+			}
+			if len(ts.series) >= ts.limit {
+				return false
+			}
+		}
+		serie = &throughputSerie{}
+		ts.series[key] = serie
+	}
+	bucket := &serie[index%throughputBuckets]
+	if bucket.index != index {
+		*bucket = throughputBucket{index: index}
+	}
+	bucket.bytes += bytes
+	bucket.packets += packets
+	return true
+}
+
+// expire removes the series without any recent bucket.
+func (ts *throughputStore) expire(t time.Time) {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	ts.expireLocked(t)
+}
+
+func (ts *throughputStore) expireLocked(t time.Time) {
+	index := t.UnixNano() / int64(throughputBucketWidth)
+	oldest := index - throughputBuckets + 1
+	ts.lastExpired = index
+outer:
+	for key, serie := range ts.series {
+		for _, bucket := range serie {
+			if bucket.index >= oldest {
+				continue outer
+			}
+		}
+		delete(ts.series, key)
+	}
+}
+
+// throughputHandlerOutput is the output of the throughput endpoint. Time is
+// the start of each bucket, from the oldest to the most recent one.
+type throughputHandlerOutput struct {
+	Time   []time.Time             `json:"time"`
+	Series []throughputSerieOutput `json:"series"`
+}
+
+type throughputSerieOutput struct {
+	ExporterAddress string   `json:"exporter-address"`
+	InIfBoundary    string   `json:"in-if-boundary"`
+	OutIfBoundary   string   `json:"out-if-boundary"`
+	Bytes           []uint64 `json:"bytes"`
+	Packets         []uint64 `json:"packets"`
+}
+
+var throughputBoundaryNames = map[uint8]string{
+	uint8(undefinedBoundary): "undefined",
+	uint8(externalBoundary):  "external",
+	uint8(internalBoundary):  "internal",
+}
+
+// snapshot returns the content of the store for the last hour. The current
+// bucket is incomplete.
+func (ts *throughputStore) snapshot(t time.Time) throughputHandlerOutput {
+	last := t.UnixNano() / int64(throughputBucketWidth)
+	first := last - throughputBuckets + 1
+	output := throughputHandlerOutput{
+		Time:   make([]time.Time, throughputBuckets),
+		Series: []throughputSerieOutput{},
+	}
+	for i := range output.Time {
+		output.Time[i] = time.Unix(0, (first+int64(i))*int64(throughputBucketWidth)).UTC()
+	}
+
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	for key, serie := range ts.series {
+		so := throughputSerieOutput{
+			ExporterAddress: key.exporter.Unmap().String(),
+			InIfBoundary:    throughputBoundaryNames[key.inIfBoundary],
+			OutIfBoundary:   throughputBoundaryNames[key.outIfBoundary],
+			Bytes:           make([]uint64, throughputBuckets),
+			Packets:         make([]uint64, throughputBuckets),
+		}
+		empty := true
+		for _, bucket := range serie {
+			if bucket.index < first || bucket.index > last {
+				continue
+			}
+			so.Bytes[bucket.index-first] = bucket.bytes
+			so.Packets[bucket.index-first] = bucket.packets
+			empty = false
+		}
+		if !empty {
+			output.Series = append(output.Series, so)
+		}
+	}
+	sort.Slice(output.Series, func(i, j int) bool {
+		si, sj := output.Series[i], output.Series[j]
+		if si.ExporterAddress != sj.ExporterAddress {
+			return si.ExporterAddress < sj.ExporterAddress
+		}
+		if si.InIfBoundary != sj.InIfBoundary {
+			return si.InIfBoundary < sj.InIfBoundary
+		}
+		return si.OutIfBoundary < sj.OutIfBoundary
+	})
+	return output
+}
+
+// ThroughputHTTPHandler returns the throughput counters for the last hour, with
+// a one-minute resolution, for each exporter and pair of boundaries. Bytes and
+// packets are already multiplied by the sampling rate. The console uses them
+// when ClickHouse is unavailable.
+func (c *Component) ThroughputHTTPHandler(gc *gin.Context) {
+	gc.JSON(http.StatusOK, c.throughput.snapshot(time.Now()))
+}
@@ -0,0 +1,99 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"akvorado/common/helpers"
+)
+
+func TestThroughputStore(t *testing.T) {
+	ts := newThroughputStore(2)
+	base := time.Date(2023, time.April, 10, 10, 0, 0, 0, time.UTC)
+	exporter1 := throughputKey{
+		exporter:      netip.MustParseAddr("::ffff:192.0.2.1"),
+		inIfBoundary:  uint8(externalBoundary),
+		outIfBoundary: uint8(internalBoundary),
+	}
+	exporter2 := throughputKey{
+		exporter:     netip.MustParseAddr("::ffff:192.0.2.2"),
+		inIfBoundary: uint8(internalBoundary),
+	}
+	exporter3 := throughputKey{
+		exporter: netip.MustParseAddr("::ffff:192.0.2.3"),
+	}
+
+	for _, tc := range []struct {
+		t        time.Time
+		key      throughputKey
+		expected bool
+	}{
+		{base, exporter1, true},
+		{base.Add(10 * time.Second), exporter1, true},
+		{base.Add(time.Minute), exporter1, true},
+		{base.Add(time.Minute), exporter2, true},
+		{base.Add(2 * time.Minute), exporter3, false}, // too many series
+		{base.Add(59 * time.Minute), exporter2, true},
+	} {
+		if got := ts.add(tc.t, tc.key, 1000, 10); got != tc.expected {
+			t.Errorf("add(%s, %s) == %v, expected %v", tc.t, tc.key.exporter, got, tc.expected)
+		}
+	}
+
+	got := ts.snapshot(base.Add(59 * time.Minute))
+	if len(got.Time) != throughputBuckets {
+		t.Fatalf("snapshot() returned %d buckets, expected %d", len(got.Time), throughputBuckets)
+	}
+	if got.Time[0] != base || got.Time[throughputBuckets-1] != base.Add(59*time.Minute) {
+		t.Fatalf("snapshot() time range is %s-%s", got.Time[0], got.Time[throughputBuckets-1])
+	}
+	expectedBytes1 := make([]uint64, throughputBuckets)
+	expectedBytes1[0] = 2000
+	expectedBytes1[1] = 1000
+	expectedPackets1 := make([]uint64, throughputBuckets)
+	expectedPackets1[0] = 20
+	expectedPackets1[1] = 10
+	expectedBytes2 := make([]uint64, throughputBuckets)
+	expectedBytes2[1] = 1000
+	expectedBytes2[59] = 1000
+	expectedPackets2 := make([]uint64, throughputBuckets)
+	expectedPackets2[1] = 10
+	expectedPackets2[59] = 10
+	expected := []throughputSerieOutput{
+		{
+			ExporterAddress: "192.0.2.1",
+			InIfBoundary:    "external",
+			OutIfBoundary:   "internal",
+			Bytes:           expectedBytes1,
+			Packets:         expectedPackets1,
+		}, {
+			ExporterAddress: "192.0.2.2",
+			InIfBoundary:    "internal",
+			OutIfBoundary:   "undefined",
+			Bytes:           expectedBytes2,
+			Packets:         expectedPackets2,
+		},
+	}
+	if diff := helpers.Diff(got.Series, expected); diff != "" {
+		t.Fatalf("snapshot() (-got, +want):\n%s", diff)
+	}
+
+	// Two minutes later, the first serie has expired and a new one can
+	// be added.
+	now := base.Add(61 * time.Minute)
+	if !ts.add(now, exporter3, 1000, 10) {
+		t.Fatal("add() dropped counters after expiration")
+	}
+	got = ts.snapshot(now)
+	if len(got.Series) != 2 {
+		t.Fatalf("snapshot() returned %d series, expected 2", len(got.Series))
+	}
+	if got.Series[0].ExporterAddress != "192.0.2.2" || got.Series[1].ExporterAddress != "192.0.2.3" {
+		t.Fatalf("snapshot() returned series for %s and %s",
+			got.Series[0].ExporterAddress, got.Series[1].ExporterAddress)
+	}
+}
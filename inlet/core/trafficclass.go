@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+)
+
+// TrafficClassRule maps a BGP community pattern to a traffic class.
+type TrafficClassRule struct {
+	// Community is a standard (ASN:value) or large (ASN:data1:data2)
+	// community. Any part can be replaced by "*" to match anything.
+	Community CommunityPattern `doc:"Standard or large community, parts can be replaced by *"`
+	// Class is the traffic class for routes with a matching community.
+	Class string `validate:"required" doc:"Traffic class for matching routes"`
+}
+
+// CommunityPattern is a pattern matching a standard or a large community.
+type CommunityPattern struct {
+	large bool
+	// parts are the components of the community. nil means any value.
+	parts [3]*uint32
+}
+
+// UnmarshalText parses a community pattern.
+func (cp *CommunityPattern) UnmarshalText(input []byte) error {
+	parts := strings.Split(string(input), ":")
+	if len(parts) != 2 && len(parts) != 3 {
+		return fmt.Errorf("invalid community pattern %q", string(input))
+	}
+	var result CommunityPattern
+	result.large = len(parts) == 3
+	for idx, part := range parts {
+		if part == "*" {
+			continue
+		}
+		bitSize := 32
+		if !result.large {
+			bitSize = 16
+		}
+		value, err := strconv.ParseUint(part, 10, bitSize)
+		if err != nil {
+			return fmt.Errorf("invalid community pattern %q: %w", string(input), errors.Unwrap(err))
+		}
+		value32 := uint32(value)
+		result.parts[idx] = &value32
+	}
+	*cp = result
+	return nil
+}
+
+// MarshalText turns a community pattern into text.
+func (cp CommunityPattern) MarshalText() ([]byte, error) {
+	return []byte(cp.String()), nil
+}
+
+// String turns a community pattern into a string.
+func (cp CommunityPattern) String() string {
+	count := 2
+	if cp.large {
+		count = 3
+	}
+	parts := make([]string, count)
+	for idx := range parts {
+		if cp.parts[idx] == nil {
+			parts[idx] = "*"
+		} else {
+			parts[idx] = strconv.FormatUint(uint64(*cp.parts[idx]), 10)
+		}
+	}
+	return strings.Join(parts, ":")
+}
+
+func (cp CommunityPattern) matchPart(idx int, value uint32) bool {
+	return cp.parts[idx] == nil || *cp.parts[idx] == value
+}
+
+// matchStandard tells if the pattern matches the provided standard community.
+func (cp CommunityPattern) matchStandard(community uint32) bool {
+	return !cp.large &&
+		cp.matchPart(0, community>>16) &&
+		cp.matchPart(1, community&0xffff)
+}
+
+// matchLarge tells if the pattern matches the provided large community.
+func (cp CommunityPattern) matchLarge(community bgp.LargeCommunity) bool {
+	return cp.large &&
+		cp.matchPart(0, community.ASN) &&
+		cp.matchPart(1, community.LocalData1) &&
+		cp.matchPart(2, community.LocalData2)
+}
+
+// classifyTraffic returns the traffic class for a route with the provided
+// communities. The first matching rule wins.
+func (c *Component) classifyTraffic(communities []uint32, largeCommunities []bgp.LargeCommunity) string {
+	for _, rule := range c.config.TrafficClasses {
+		if rule.Community.large {
+			for _, community := range largeCommunities {
+				if rule.Community.matchLarge(community) {
+					return rule.Class
+				}
+			}
+		} else {
+			for _, community := range communities {
+				if rule.Community.matchStandard(community) {
+					return rule.Class
+				}
+			}
+		}
+	}
+	return c.config.DefaultTrafficClass
+}
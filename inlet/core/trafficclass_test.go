@@ -0,0 +1,108 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package core
+
+import (
+	"testing"
+
+	"github.com/osrg/gobgp/v3/pkg/packet/bgp"
+)
+
+func TestCommunityPattern(t *testing.T) {
+	cases := []struct {
+		Input  string
+		Output string
+		Error  bool
+	}{
+		{Input: "65000:100", Output: "65000:100"},
+		{Input: "65000:*", Output: "65000:*"},
+		{Input: "*:100", Output: "*:100"},
+		{Input: "4200000000:1:2", Output: "4200000000:1:2"},
+		{Input: "4200000000:*:*", Output: "4200000000:*:*"},
+		{Input: "65000", Error: true},
+		{Input: "65000:1:2:3", Error: true},
+		{Input: "70000:100", Error: true},
+		{Input: "65000:foo", Error: true},
+		{Input: "65000:", Error: true},
+	}
+	for _, tc := range cases {
+		var got CommunityPattern
+		err := got.UnmarshalText([]byte(tc.Input))
+		if err != nil && !tc.Error {
+			t.Errorf("UnmarshalText(%q) error:\n%+v", tc.Input, err)
+			continue
+		}
+		if err == nil && tc.Error {
+			t.Errorf("UnmarshalText(%q) did not error", tc.Input)
+			continue
+		}
+		if tc.Error {
+			continue
+		}
+		if got.String() != tc.Output {
+			t.Errorf("UnmarshalText(%q) == %q but expected %q", tc.Input, got.String(), tc.Output)
+		}
+	}
+}
+
+func TestClassifyTraffic(t *testing.T) {
+	c := Component{
+		config: Configuration{
+			TrafficClasses: []TrafficClassRule{
+				{Community: mustParseCommunityPattern("65000:100"), Class: "backbone"},
+				{Community: mustParseCommunityPattern("65000:200"), Class: "cache-fill"},
+				{Community: mustParseCommunityPattern("65001:*"), Class: "peering"},
+				{Community: mustParseCommunityPattern("4200000000:1:*"), Class: "transit"},
+			},
+			DefaultTrafficClass: "other",
+		},
+	}
+	cases := []struct {
+		Description      string
+		Communities      []uint32
+		LargeCommunities []bgp.LargeCommunity
+		Expected         string
+	}{
+		{
+			Description: "no community",
+			Expected:    "other",
+		}, {
+			Description: "exact match",
+			Communities: []uint32{65000<<16 + 200},
+			Expected:    "cache-fill",
+		}, {
+			Description: "first rule wins",
+			Communities: []uint32{65000<<16 + 200, 65000<<16 + 100},
+			Expected:    "backbone",
+		}, {
+			Description: "wildcard match",
+			Communities: []uint32{65000<<16 + 300, 65001<<16 + 300},
+			Expected:    "peering",
+		}, {
+			Description:      "large community",
+			LargeCommunities: []bgp.LargeCommunity{{ASN: 4200000000, LocalData1: 1, LocalData2: 10}},
+			Expected:         "transit",
+		}, {
+			Description:      "large community not matching a standard pattern",
+			LargeCommunities: []bgp.LargeCommunity{{ASN: 65000, LocalData1: 0, LocalData2: 100}},
+			Expected:         "other",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			got := c.classifyTraffic(tc.Communities, tc.LargeCommunities)
+			if got != tc.Expected {
+				t.Errorf("classifyTraffic() == %q but expected %q", got, tc.Expected)
+			}
+		})
+	}
+}
+
+func mustParseCommunityPattern(input string) CommunityPattern {
+	var cp CommunityPattern
+	if err := cp.UnmarshalText([]byte(input)); err != nil {
+		panic(err)
+	}
+	return cp
+}
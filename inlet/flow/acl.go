@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"net/netip"
+
+	"akvorado/common/helpers"
+)
+
+// exporterACL tells if datagrams from an exporter should be accepted.
+type exporterACL struct {
+	rules        *helpers.SubnetMap[bool] // nil when all exporters are accepted
+	defaultAllow bool
+}
+
+// newExporterACL builds an ACL from the allowed and denied prefixes. The
+// most specific prefix wins. When a prefix is both allowed and denied, it
+// is denied. When no prefix matches, the exporter is accepted only if there
+// are no allowed prefixes.
+func newExporterACL(allowed, denied []netip.Prefix) (*exporterACL, error) {
+	acl := &exporterACL{defaultAllow: len(allowed) == 0}
+	if len(allowed) == 0 && len(denied) == 0 {
+		return acl, nil
+	}
+	rules := map[string]bool{}
+	for _, prefix := range allowed {
+		rules[toIPv6Prefix(prefix).String()] = true
+	}
+	for _, prefix := range denied {
+		rules[toIPv6Prefix(prefix).String()] = false
+	}
+	sm, err := helpers.NewSubnetMap(rules)
+	if err != nil {
+		return nil, err
+	}
+	acl.rules = sm
+	return acl, nil
+}
+
+// accept tells if the provided exporter is accepted.
+func (acl *exporterACL) accept(exporter netip.Addr) bool {
+	if acl.rules == nil {
+		return true
+	}
+	return acl.rules.LookupOrDefault(netip.AddrFrom16(exporter.As16()), acl.defaultAllow)
+}
+
+// toIPv6Prefix turns an IPv4 prefix into an IPv4-mapped IPv6 prefix.
+func toIPv6Prefix(prefix netip.Prefix) netip.Prefix {
+	prefix = prefix.Masked()
+	if prefix.Addr().Is4() {
+		return netip.PrefixFrom(netip.AddrFrom16(prefix.Addr().As16()), prefix.Bits()+96)
+	}
+	return prefix
+}
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"akvorado/common/helpers"
+	"akvorado/inlet/flow/decoder"
+)
+
+// captureDefaultPorts are the destination ports used in captures for each
+// decoder. The original ports are not known, but tools like Wireshark use
+// them to select the right dissector.
+var captureDefaultPorts = map[string]uint16{
+	"netflow": 2055,
+	"sflow":   6343,
+}
+
+// capturedDatagram is a raw datagram received from an exporter.
+type capturedDatagram struct {
+	received time.Time
+	source   netip.Addr // unmapped
+	port     uint16
+	payload  []byte
+}
+
+// captureClient is a subscriber to raw datagrams.
+type captureClient struct {
+	exporter  netip.Addr // unmapped
+	datagrams chan capturedDatagram
+}
+
+// capture dispatches a copy of raw datagrams to subscribers. When there is no
+// subscriber, publishing is free as long as the caller checks active() first.
+type capture struct {
+	count   uint32 // number of subscribers, for a lockless check
+	lock    sync.RWMutex
+	clients map[*captureClient]struct{}
+}
+
+// active tells if there is at least one subscriber.
+func (c *capture) active() bool {
+	return atomic.LoadUint32(&c.count) > 0
+}
+
+// subscribe registers a new subscriber for the next datagrams of the
+// provided exporter.
+func (c *capture) subscribe(exporter netip.Addr, count int) *captureClient {
+	client := &captureClient{
+		exporter:  exporter.Unmap(),
+		datagrams: make(chan capturedDatagram, count),
+	}
+	c.lock.Lock()
+	if c.clients == nil {
+		c.clients = map[*captureClient]struct{}{}
+	}
+	c.clients[client] = struct{}{}
+	atomic.AddUint32(&c.count, 1)
+	c.lock.Unlock()
+	return client
+}
+
+// unsubscribe removes a subscriber.
+func (c *capture) unsubscribe(client *captureClient) {
+	c.lock.Lock()
+	if _, ok := c.clients[client]; ok {
+		delete(c.clients, client)
+		atomic.AddUint32(&c.count, ^uint32(0))
+	}
+	c.lock.Unlock()
+}
+
+// publish sends a copy of the provided datagram to each matching subscriber.
+// Subscribers with enough datagrams are skipped.
+func (c *capture) publish(in decoder.RawFlow, port uint16) {
+	source, _ := netip.AddrFromSlice(in.Source.To16())
+	source = source.Unmap()
+	var datagram *capturedDatagram
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	for client := range c.clients {
+		if client.exporter != source {
+			continue
+		}
+		if datagram == nil {
+			// The payload buffer is reused by inputs.
+			datagram = &capturedDatagram{
+				received: in.TimeReceived,
+				source:   source,
+				port:     port,
+				payload:  append([]byte{}, in.Payload...),
+			}
+		}
+		select {
+		case client.datagrams <- *datagram:
+		default:
+		}
+	}
+}
+
+// serialize builds an IP packet from the captured datagram. The destination
+// address is unspecified and the source port is 0 as they are not known.
+func (d capturedDatagram) serialize() ([]byte, error) {
+	var ip gopacket.NetworkLayer
+	udp := &layers.UDP{DstPort: layers.UDPPort(d.port)}
+	if d.source.Is4() {
+		ip = &layers.IPv4{
+			Version:  4,
+			TTL:      64,
+			Protocol: layers.IPProtocolUDP,
+			SrcIP:    d.source.AsSlice(),
+			DstIP:    net.IPv4zero,
+		}
+	} else {
+		ip = &layers.IPv6{
+			Version:    6,
+			HopLimit:   64,
+			NextHeader: layers.IPProtocolUDP,
+			SrcIP:      d.source.AsSlice(),
+			DstIP:      net.IPv6unspecified,
+		}
+	}
+	udp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts,
+		ip.(gopacket.SerializableLayer), udp, gopacket.Payload(d.payload)); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// captureParameters are the query parameters for the capture endpoint.
+type captureParameters struct {
+	Exporter string `form:"exporter" binding:"required"`
+	Count    int    `form:"count"`
+}
+
+// captureHTTPHandler captures the next datagrams received from an exporter
+// and returns them as a pcap file. The capture ends when enough datagrams
+// are received or after a configured duration. This is intended for debug
+// only.
+func (c *Component) captureHTTPHandler(gc *gin.Context) {
+	if !c.rawAccessAllowed(gc) {
+		return
+	}
+	params := captureParameters{Count: 10}
+	if err := gc.ShouldBindQuery(&params); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	exporter, err := netip.ParseAddr(params.Exporter)
+	if err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if params.Count < 1 || params.Count > c.config.CaptureMaxPackets {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": fmt.Sprintf(
+			"Count should be between 1 and %d", c.config.CaptureMaxPackets)})
+		return
+	}
+
+	client := c.capture.subscribe(exporter, params.Count)
+	timer := time.NewTimer(c.config.CaptureMaxDuration)
+	datagrams := make([]capturedDatagram, 0, params.Count)
+out:
+	for len(datagrams) < params.Count {
+		select {
+		case <-c.t.Dying():
+			break out
+		case <-gc.Request.Context().Done():
+			break out
+		case <-timer.C:
+			break out
+		case datagram := <-client.datagrams:
+			datagrams = append(datagrams, datagram)
+		}
+	}
+	timer.Stop()
+	c.capture.unsubscribe(client)
+
+	gc.Header("Content-Type", "application/vnd.tcpdump.pcap")
+	gc.Header("Content-Disposition",
+		fmt.Sprintf(`attachment; filename="capture-%s.pcap"`, exporter.Unmap()))
+	gc.Status(http.StatusOK)
+	w := pcapgo.NewWriter(gc.Writer)
+	if err := w.WriteFileHeader(65536, layers.LinkTypeRaw); err != nil {
+		return
+	}
+	for _, datagram := range datagrams {
+		data, err := datagram.serialize()
+		if err != nil {
+			c.r.Err(err).Msg("cannot serialize captured datagram")
+			continue
+		}
+		if err := w.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     datagram.received,
+			CaptureLength: len(data),
+			Length:        len(data),
+		}, data); err != nil {
+			return
+		}
+	}
+}
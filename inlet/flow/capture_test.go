@@ -0,0 +1,169 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"fmt"
+	"io"
+	"net"
+	netHTTP "net/http"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/inlet/flow/decoder"
+)
+
+func TestCapturePublish(t *testing.T) {
+	var c capture
+	if c.active() {
+		t.Fatal("active() == true without subscribers")
+	}
+	client := c.subscribe(netip.MustParseAddr("::ffff:192.0.2.1"), 2)
+	if !c.active() {
+		t.Fatal("active() == false with subscribers")
+	}
+
+	payload := []byte("hello")
+	c.publish(decoder.RawFlow{Payload: payload, Source: net.ParseIP("192.0.2.2")}, 2055)
+	c.publish(decoder.RawFlow{Payload: payload, Source: net.ParseIP("192.0.2.1")}, 2055)
+	// The payload buffer is reused by inputs
+	copy(payload, "world")
+	c.publish(decoder.RawFlow{Payload: payload, Source: net.ParseIP("192.0.2.1")}, 2055)
+	c.publish(decoder.RawFlow{Payload: payload, Source: net.ParseIP("192.0.2.1")}, 2055)
+
+	got := []string{}
+	for len(client.datagrams) > 0 {
+		got = append(got, string((<-client.datagrams).payload))
+	}
+	if diff := helpers.Diff(got, []string{"hello", "world"}); diff != "" {
+		t.Errorf("publish() (-got, +want):\n%s", diff)
+	}
+
+	c.unsubscribe(client)
+	c.unsubscribe(client)
+	if c.active() {
+		t.Fatal("active() == true after unsubscribing everything")
+	}
+}
+
+func TestCaptureHTTP(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Inputs = nil
+	config.CaptureMaxPackets = 5
+	config.CaptureMaxDuration = time.Second
+	c := NewMock(t, r, config)
+	// Idle connections would delay the shutdown of the HTTP server
+	t.Cleanup(netHTTP.DefaultClient.CloseIdleConnections)
+
+	t.Run("invalid count", func(t *testing.T) {
+		resp, err := netHTTP.Get(fmt.Sprintf("http://%s/api/v0/inlet/flow/capture?exporter=192.0.2.1&count=10",
+			c.d.HTTP.LocalAddr()))
+		if err != nil {
+			t.Fatalf("GET /api/v0/inlet/flow/capture:\n%+v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("GET /api/v0/inlet/flow/capture status code %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("missing exporter", func(t *testing.T) {
+		resp, err := netHTTP.Get(fmt.Sprintf("http://%s/api/v0/inlet/flow/capture",
+			c.d.HTTP.LocalAddr()))
+		if err != nil {
+			t.Fatalf("GET /api/v0/inlet/flow/capture:\n%+v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != 400 {
+			t.Fatalf("GET /api/v0/inlet/flow/capture status code %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("capture", func(t *testing.T) {
+		published := make(chan struct{})
+		defer func() { <-published }()
+		go func() {
+			defer close(published)
+			deadline := time.Now().Add(time.Second)
+			for !c.capture.active() {
+				if time.Now().After(deadline) {
+					return
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+			now := time.Now()
+			for _, source := range []string{"192.0.2.2", "192.0.2.1", "192.0.2.1", "192.0.2.1"} {
+				c.capture.publish(decoder.RawFlow{
+					TimeReceived: now,
+					Payload:      []byte(fmt.Sprintf("hello from %s", source)),
+					Source:       net.ParseIP(source),
+				}, 6343)
+			}
+		}()
+		resp, err := netHTTP.Get(fmt.Sprintf("http://%s/api/v0/inlet/flow/capture?exporter=192.0.2.1&count=2",
+			c.d.HTTP.LocalAddr()))
+		if err != nil {
+			t.Fatalf("GET /api/v0/inlet/flow/capture:\n%+v", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != 200 {
+			t.Fatalf("GET /api/v0/inlet/flow/capture status code %d", resp.StatusCode)
+		}
+		if contentType := resp.Header.Get("Content-Type"); contentType != "application/vnd.tcpdump.pcap" {
+			t.Fatalf("GET /api/v0/inlet/flow/capture content type %q", contentType)
+		}
+
+		reader, err := pcapgo.NewReader(resp.Body)
+		if err != nil {
+			t.Fatalf("NewReader() error:\n%+v", err)
+		}
+		type packet struct {
+			Source  string
+			Port    uint16
+			Payload string
+		}
+		got := []packet{}
+		for {
+			data, _, err := reader.ReadPacketData()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("ReadPacketData() error:\n%+v", err)
+			}
+			decoded := gopacket.NewPacket(data, reader.LinkType(), gopacket.Default)
+			ip, ok := decoded.Layer(layers.LayerTypeIPv4).(*layers.IPv4)
+			if !ok {
+				t.Fatalf("NewPacket() did not decode an IPv4 packet")
+			}
+			udp, ok := decoded.Layer(layers.LayerTypeUDP).(*layers.UDP)
+			if !ok {
+				t.Fatalf("NewPacket() did not decode an UDP packet")
+			}
+			got = append(got, packet{
+				Source:  ip.SrcIP.String(),
+				Port:    uint16(udp.DstPort),
+				Payload: string(udp.Payload),
+			})
+		}
+		expected := []packet{
+			{"192.0.2.1", 6343, "hello from 192.0.2.1"},
+			{"192.0.2.1", 6343, "hello from 192.0.2.1"},
+		}
+		if diff := helpers.Diff(got, expected); diff != "" {
+			t.Fatalf("GET /api/v0/inlet/flow/capture (-got, +want):\n%s", diff)
+		}
+		if c.capture.active() {
+			t.Fatal("active() == true after capture")
+		}
+	})
+}
@@ -4,21 +4,91 @@
 package flow
 
 import (
+	"errors"
+	"net/netip"
+	"time"
+
 	"golang.org/x/time/rate"
 
 	"akvorado/common/helpers"
+	"akvorado/common/helpers/bimap"
+	"akvorado/inlet/flow/decoder"
 	"akvorado/inlet/flow/input"
 	"akvorado/inlet/flow/input/file"
+	"akvorado/inlet/flow/input/grpc"
+	"akvorado/inlet/flow/input/kafka"
+	"akvorado/inlet/flow/input/pcap"
+	"akvorado/inlet/flow/input/tcp"
 	"akvorado/inlet/flow/input/udp"
+	"akvorado/inlet/flow/input/xdp"
 )
 
 // Configuration describes the configuration for the flow component
 type Configuration struct {
 	// Inputs define a list of input modules to enable
-	Inputs []InputConfiguration `validate:"dive"`
+	Inputs []InputConfiguration `validate:"dive" doc:"List of inputs to receive flows from"`
 	// RateLimit defines a rate limit on the number of flows per
 	// second. The limit is per-exporter.
-	RateLimit rate.Limit `validate:"isdefault|min=100"`
+	RateLimit rate.Limit `validate:"isdefault|min=100" doc:"Maximum number of flows per second for each exporter (0 to disable)"`
+	// RateLimits defines per-exporter rate limits. When an exporter
+	// matches, it overrides RateLimit.
+	RateLimits *helpers.SubnetMap[RateLimitConfiguration] `validate:"omitempty,dive" doc:"Rate limits for each exporter, as a value or a mapping from subnets"`
+	// TailRateLimit defines the maximum number of flows per second sent to
+	// each client of the tail endpoint.
+	TailRateLimit rate.Limit `validate:"min=1" doc:"Maximum number of flows per second sent to each client of the tail endpoint"`
+	// TailMaxDuration defines the maximum duration of a session with the tail
+	// endpoint.
+	TailMaxDuration time.Duration `validate:"min=1s" doc:"Maximum duration of a session with the tail endpoint"`
+	// CaptureMaxPackets defines the maximum number of datagrams a client
+	// of the capture endpoint can request.
+	CaptureMaxPackets int `validate:"min=1" doc:"Maximum number of datagrams captured by the capture endpoint"`
+	// CaptureMaxDuration defines the maximum duration of a capture.
+	CaptureMaxDuration time.Duration `validate:"min=1s" doc:"Maximum duration of a capture with the capture endpoint"`
+	// DegradedIngest defines when an exporter is considered degraded
+	// because too many of its datagrams are dropped.
+	DegradedIngest DegradedIngestConfiguration `doc:"Detection of exporters with too many dropped datagrams"`
+	// Decoders is the configuration shared by all decoders.
+	Decoders decoder.Configuration `doc:"Configuration shared by decoders"`
+	// Deduplication defines how to detect flows reported by several
+	// exporters.
+	Deduplication DeduplicationConfiguration `doc:"Detection of flows reported by several exporters"`
+}
+
+// DeduplicationConfiguration describes how flows reported by several
+// exporters are detected.
+type DeduplicationConfiguration struct {
+	// Window is the duration of the time buckets used to detect
+	// duplicate flows. 0 disables deduplication.
+	Window time.Duration `validate:"isdefault|min=1s" doc:"Duration of the time buckets used to detect duplicate flows (0 to disable)"`
+	// Strategy tells what to do with duplicate flows.
+	Strategy DeduplicationStrategy `doc:"What to do with duplicate flows (drop or count)"`
+	// ExporterGroups maps group names to exporter prefixes. Flows are only
+	// compared with flows from exporters of the same group. When empty,
+	// all exporters belong to the same group.
+	ExporterGroups map[string][]netip.Prefix `doc:"Groups of exporters to compare flows with, as a mapping from names to prefixes (all exporters when empty)"`
+	// MaxFlows is the maximum number of flows tracked in each time bucket.
+	// Flows above this limit are not checked for duplicates.
+	MaxFlows int `validate:"min=0" doc:"Maximum number of flows tracked in each time bucket (0 for no limit)"`
+}
+
+// DegradedIngestConfiguration describes how the ingest state of exporters is
+// evaluated.
+type DegradedIngestConfiguration struct {
+	// Interval is the interval between two evaluations.
+	Interval time.Duration `validate:"min=1s" doc:"Interval between two evaluations"`
+	// Window is the duration over which the percentage of dropped
+	// datagrams is computed.
+	Window time.Duration `validate:"gtefield=Interval" doc:"Duration over which the percentage of dropped datagrams is computed"`
+	// Threshold is the percentage of dropped datagrams over which an
+	// exporter is considered degraded.
+	Threshold float64 `validate:"gt=0,lte=100" doc:"Percentage of dropped datagrams over which an exporter is degraded"`
+	// RecoveryThreshold is the percentage of dropped datagrams under which
+	// a degraded exporter is considered recovered.
+	RecoveryThreshold float64 `validate:"gte=0,ltefield=Threshold" doc:"Percentage of dropped datagrams under which an exporter recovers"`
+	// Webhook is an URL to notify of state changes.
+	Webhook string `validate:"omitempty,url" doc:"URL to notify of state changes"`
+	// WebhookTimeout is the timeout when notifying the webhook.
+	WebhookTimeout time.Duration `validate:"min=100ms" doc:"Timeout when notifying the webhook"`
 }
 
 // DefaultConfiguration represents the default configuration for the flow component
@@ -31,18 +101,53 @@ func DefaultConfiguration() Configuration {
 			Decoder: "sflow",
 			Config:  udp.DefaultConfiguration(),
 		}},
+		TailRateLimit:      100,
+		TailMaxDuration:    5 * time.Minute,
+		CaptureMaxPackets:  1000,
+		CaptureMaxDuration: time.Minute,
+		DegradedIngest: DegradedIngestConfiguration{
+			Interval:          10 * time.Second,
+			Window:            time.Minute,
+			Threshold:         5,
+			RecoveryThreshold: 1,
+			WebhookTimeout:    5 * time.Second,
+		},
+		Decoders: decoder.DefaultConfiguration(),
+		Deduplication: DeduplicationConfiguration{
+			MaxFlows: 1000000,
+		},
 	}
 }
 
+// RateLimitConfiguration describes the rate limits of an exporter.
+type RateLimitConfiguration struct {
+	// Flows is the maximum number of flows per second.
+	Flows rate.Limit `validate:"isdefault|min=100" doc:"Maximum number of flows per second (0 to disable)"`
+	// Packets is the maximum number of datagrams per second.
+	Packets rate.Limit `validate:"isdefault|min=1" doc:"Maximum number of datagrams per second (0 to disable)"`
+	// Policy tells what to do with flows over the limits.
+	Policy RateLimitPolicy `doc:"What to do with flows over the limits (sample or drop)"`
+}
+
 // InputConfiguration represents the configuration for an input.
 type InputConfiguration struct {
 	// Decoder is the decoder to associate to the input.
-	Decoder string
+	Decoder string `doc:"Decoder to use for this input (netflow, sflow or protobuf)"`
 	// UseSrcAddrForExporterAddr replaces the exporter address by the transport
 	// source address.
-	UseSrcAddrForExporterAddr bool
+	UseSrcAddrForExporterAddr bool `doc:"Use the source address of datagrams as exporter address"`
+	// ZeroVolumePolicy tells what to do with flows without bytes or
+	// packets. When not set, the default depends on the decoder.
+	ZeroVolumePolicy helpers.SubnetMap[ZeroVolumePolicy] `doc:"What to do with flows without bytes or packets (drop, keep, tag), as a value or a mapping from subnets"`
+	// AllowedExporters is the list of prefixes exporters should belong to.
+	// When empty, all exporters are accepted.
+	AllowedExporters []netip.Prefix `doc:"Prefixes of exporters allowed to send datagrams to this input (all when empty)"`
+	// DeniedExporters is the list of prefixes exporters should not belong
+	// to. It takes precedence over AllowedExporters, unless a more specific
+	// prefix is allowed.
+	DeniedExporters []netip.Prefix `doc:"Prefixes of exporters not allowed to send datagrams to this input"`
 	// Config is the actual configuration of the input.
-	Config input.Configuration
+	Config input.Configuration `doc:"Configuration of the input (udp, tcp, grpc, kafka, pcap or file)"`
 }
 
 // MarshalYAML undoes ConfigurationUnmarshallerHook().
@@ -55,12 +160,146 @@ func (ic InputConfiguration) MarshalJSON() ([]byte, error) {
 	return helpers.ParametrizedConfigurationMarshalJSON(ic, inputs)
 }
 
+// ZeroVolumePolicy tells what to do with flows without bytes or without
+// packets.
+type ZeroVolumePolicy int
+
+const (
+	// ZeroVolumePolicyKeep keeps flows without bytes or packets.
+	ZeroVolumePolicyKeep ZeroVolumePolicy = iota
+	// ZeroVolumePolicyDrop drops flows without bytes or packets.
+	ZeroVolumePolicyDrop
+	// ZeroVolumePolicyTag keeps flows without bytes or packets but sets
+	// the ZeroVolume column.
+	ZeroVolumePolicyTag
+)
+
+var zeroVolumePolicyMap = bimap.New(map[ZeroVolumePolicy]string{
+	ZeroVolumePolicyKeep: "keep",
+	ZeroVolumePolicyDrop: "drop",
+	ZeroVolumePolicyTag:  "tag",
+})
+
+// MarshalText turns a zero volume policy to text.
+func (zvp ZeroVolumePolicy) MarshalText() ([]byte, error) {
+	got, ok := zeroVolumePolicyMap.LoadValue(zvp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown zero volume policy")
+}
+
+// String turns a zero volume policy to string.
+func (zvp ZeroVolumePolicy) String() string {
+	got, _ := zeroVolumePolicyMap.LoadValue(zvp)
+	return got
+}
+
+// UnmarshalText provides a zero volume policy from a string.
+func (zvp *ZeroVolumePolicy) UnmarshalText(input []byte) error {
+	got, ok := zeroVolumePolicyMap.LoadKey(string(input))
+	if ok {
+		*zvp = got
+		return nil
+	}
+	return errors.New("unknown zero volume policy")
+}
+
+// RateLimitPolicy tells what to do with flows over the rate limits.
+type RateLimitPolicy int
+
+const (
+	// RateLimitPolicySample drops flows over the rate limits and adapts
+	// the sampling rate of the remaining ones.
+	RateLimitPolicySample RateLimitPolicy = iota
+	// RateLimitPolicyDrop drops flows over the rate limits.
+	RateLimitPolicyDrop
+)
+
+var rateLimitPolicyMap = bimap.New(map[RateLimitPolicy]string{
+	RateLimitPolicySample: "sample",
+	RateLimitPolicyDrop:   "drop",
+})
+
+// MarshalText turns a rate limit policy to text.
+func (rlp RateLimitPolicy) MarshalText() ([]byte, error) {
+	got, ok := rateLimitPolicyMap.LoadValue(rlp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown rate limit policy")
+}
+
+// String turns a rate limit policy to string.
+func (rlp RateLimitPolicy) String() string {
+	got, _ := rateLimitPolicyMap.LoadValue(rlp)
+	return got
+}
+
+// UnmarshalText provides a rate limit policy from a string.
+func (rlp *RateLimitPolicy) UnmarshalText(input []byte) error {
+	got, ok := rateLimitPolicyMap.LoadKey(string(input))
+	if ok {
+		*rlp = got
+		return nil
+	}
+	return errors.New("unknown rate limit policy")
+}
+
+// DeduplicationStrategy tells what to do with duplicate flows.
+type DeduplicationStrategy int
+
+const (
+	// DeduplicationStrategyDrop drops duplicate flows.
+	DeduplicationStrategyDrop DeduplicationStrategy = iota
+	// DeduplicationStrategyCount keeps duplicate flows but counts them.
+	DeduplicationStrategyCount
+)
+
+var deduplicationStrategyMap = bimap.New(map[DeduplicationStrategy]string{
+	DeduplicationStrategyDrop:  "drop",
+	DeduplicationStrategyCount: "count",
+})
+
+// MarshalText turns a deduplication strategy to text.
+func (ds DeduplicationStrategy) MarshalText() ([]byte, error) {
+	got, ok := deduplicationStrategyMap.LoadValue(ds)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown deduplication strategy")
+}
+
+// String turns a deduplication strategy to string.
+func (ds DeduplicationStrategy) String() string {
+	got, _ := deduplicationStrategyMap.LoadValue(ds)
+	return got
+}
+
+// UnmarshalText provides a deduplication strategy from a string.
+func (ds *DeduplicationStrategy) UnmarshalText(input []byte) error {
+	got, ok := deduplicationStrategyMap.LoadKey(string(input))
+	if ok {
+		*ds = got
+		return nil
+	}
+	return errors.New("unknown deduplication strategy")
+}
+
 var inputs = map[string](func() input.Configuration){
-	"udp":  udp.DefaultConfiguration,
-	"file": file.DefaultConfiguration,
+	"udp":   udp.DefaultConfiguration,
+	"tcp":   tcp.DefaultConfiguration,
+	"grpc":  grpc.DefaultConfiguration,
+	"kafka": kafka.DefaultConfiguration,
+	"pcap":  pcap.DefaultConfiguration,
+	"file":  file.DefaultConfiguration,
+	"xdp":   xdp.DefaultConfiguration,
 }
 
 func init() {
 	helpers.RegisterMapstructureUnmarshallerHook(
 		helpers.ParametrizedConfigurationUnmarshallerHook(InputConfiguration{}, inputs))
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[ZeroVolumePolicy]())
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[RateLimitConfiguration]())
+	helpers.RegisterSubnetMapValidation[RateLimitConfiguration]()
 }
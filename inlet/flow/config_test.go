@@ -4,15 +4,22 @@
 package flow
 
 import (
+	"net/netip"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/gin-gonic/gin"
 
 	"akvorado/common/helpers/yaml"
 
 	"akvorado/common/helpers"
+	kafkaCommon "akvorado/common/kafka"
+	"akvorado/inlet/flow/decoder"
 	"akvorado/inlet/flow/input/file"
+	"akvorado/inlet/flow/input/kafka"
+	"akvorado/inlet/flow/input/pcap"
+	"akvorado/inlet/flow/input/tcp"
 	"akvorado/inlet/flow/input/udp"
 )
 
@@ -43,21 +50,54 @@ func TestDecodeConfiguration(t *testing.T) {
 				Inputs: []InputConfiguration{{
 					Decoder: "netflow",
 					Config: &udp.Configuration{
-						Workers:   3,
-						QueueSize: 100000,
-						Listen:    "192.0.2.1:2055",
+						Workers:     3,
+						BatchSize:   32,
+						Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
+						QueueSize:   100000,
+						Listen:      "192.0.2.1:2055",
 					},
 					UseSrcAddrForExporterAddr: true,
 				}, {
 					Decoder: "sflow",
 					Config: &udp.Configuration{
-						Workers:   3,
-						QueueSize: 100000,
-						Listen:    "192.0.2.1:6343",
+						Workers:     3,
+						BatchSize:   32,
+						Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
+						QueueSize:   100000,
+						Listen:      "192.0.2.1:6343",
 					},
 					UseSrcAddrForExporterAddr: false,
 				}},
 			},
+		}, {
+			Description: "CPU affinity",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"inputs": []gin.H{
+						{
+							"type":         "udp",
+							"decoder":      "netflow",
+							"listen":       "192.0.2.1:2055",
+							"workers":      3,
+							"cpu-affinity": []interface{}{"0-3", 8, "9,11"},
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Inputs: []InputConfiguration{{
+					Decoder: "netflow",
+					Config: &udp.Configuration{
+						Workers:     3,
+						BatchSize:   32,
+						Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
+						QueueSize:   100000,
+						Listen:      "192.0.2.1:2055",
+						CPUAffinity: []udp.CPUSet{{0, 1, 2, 3}, {8}, {9, 11}},
+					},
+				}},
+			},
 		}, {
 			Description: "from existing configuration",
 			Initial: func() interface{} {
@@ -92,16 +132,20 @@ func TestDecodeConfiguration(t *testing.T) {
 				Inputs: []InputConfiguration{{
 					Decoder: "netflow",
 					Config: &udp.Configuration{
-						Workers:   3,
-						QueueSize: 100000,
-						Listen:    "192.0.2.1:2055",
+						Workers:     3,
+						BatchSize:   32,
+						Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
+						QueueSize:   100000,
+						Listen:      "192.0.2.1:2055",
 					},
 				}, {
 					Decoder: "sflow",
 					Config: &udp.Configuration{
-						Workers:   3,
-						QueueSize: 100000,
-						Listen:    "192.0.2.1:6343",
+						Workers:     3,
+						BatchSize:   32,
+						Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
+						QueueSize:   100000,
+						Listen:      "192.0.2.1:6343",
 					},
 				}},
 			},
@@ -166,6 +210,344 @@ func TestDecodeConfiguration(t *testing.T) {
 					},
 				}},
 			},
+		}, {
+			Description: "zero volume policy",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"inputs": []gin.H{
+						{
+							"type":    "udp",
+							"decoder": "netflow",
+							"listen":  "192.0.2.1:2055",
+							"workers": 3,
+							"zero-volume-policy": gin.H{
+								"192.0.2.0/24":  "tag",
+								"2001:db8::/64": "keep",
+							},
+						}, {
+							"type":               "udp",
+							"decoder":            "sflow",
+							"listen":             "192.0.2.1:6343",
+							"workers":            3,
+							"zero-volume-policy": "drop",
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Inputs: []InputConfiguration{{
+					Decoder: "netflow",
+					Config: &udp.Configuration{
+						Workers:     3,
+						BatchSize:   32,
+						Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
+						QueueSize:   100000,
+						Listen:      "192.0.2.1:2055",
+					},
+					ZeroVolumePolicy: *helpers.MustNewSubnetMap(map[string]ZeroVolumePolicy{
+						"::ffff:192.0.2.0/120": ZeroVolumePolicyTag,
+						"2001:db8::/64":        ZeroVolumePolicyKeep,
+					}),
+				}, {
+					Decoder: "sflow",
+					Config: &udp.Configuration{
+						Workers:     3,
+						BatchSize:   32,
+						Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
+						QueueSize:   100000,
+						Listen:      "192.0.2.1:6343",
+					},
+					ZeroVolumePolicy: *helpers.MustNewSubnetMap(map[string]ZeroVolumePolicy{
+						"::/0": ZeroVolumePolicyDrop,
+					}),
+				}},
+			},
+		}, {
+			Description: "exporter prefixes",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"inputs": []gin.H{
+						{
+							"type":              "udp",
+							"decoder":           "netflow",
+							"listen":            "192.0.2.1:2055",
+							"workers":           3,
+							"allowed-exporters": []string{"192.0.2.0/24", "2001:db8::/32"},
+							"denied-exporters":  []string{"192.0.2.128/25"},
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Inputs: []InputConfiguration{{
+					Decoder: "netflow",
+					Config: &udp.Configuration{
+						Workers:     3,
+						BatchSize:   32,
+						Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
+						QueueSize:   100000,
+						Listen:      "192.0.2.1:2055",
+					},
+					AllowedExporters: []netip.Prefix{
+						netip.MustParsePrefix("192.0.2.0/24"),
+						netip.MustParsePrefix("2001:db8::/32"),
+					},
+					DeniedExporters: []netip.Prefix{
+						netip.MustParsePrefix("192.0.2.128/25"),
+					},
+				}},
+			},
+		}, {
+			Description: "tcp input",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"inputs": []gin.H{
+						{
+							"type":            "tcp",
+							"decoder":         "netflow",
+							"listen":          "192.0.2.1:4739",
+							"max-connections": 10,
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Inputs: []InputConfiguration{{
+					Decoder: "netflow",
+					Config: &tcp.Configuration{
+						Listen:         "192.0.2.1:4739",
+						MaxConnections: 10,
+						IdleTimeout:    10 * time.Minute,
+						QueueSize:      100000,
+					},
+				}},
+			},
+		}, {
+			Description: "kafka input",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"inputs": []gin.H{
+						{
+							"type":              "kafka",
+							"decoder":           "netflow",
+							"topic":             "raw-netflow",
+							"brokers":           []string{"192.0.2.1:9092"},
+							"start-from-oldest": true,
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Inputs: []InputConfiguration{{
+					Decoder: "netflow",
+					Config: &kafka.Configuration{
+						Configuration: func() kafkaCommon.Configuration {
+							c := kafkaCommon.DefaultConfiguration()
+							c.Topic = "raw-netflow"
+							c.Brokers = []string{"192.0.2.1:9092"}
+							return c
+						}(),
+						ConsumerGroup:   "akvorado-inlet",
+						StartFromOldest: true,
+						QueueSize:       1000,
+					},
+				}},
+			},
+		}, {
+			Description: "pcap input",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"inputs": []gin.H{
+						{
+							"type":    "pcap",
+							"decoder": "sflow",
+							"path":    "/tmp/sflow.pcap",
+							"speed":   10,
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Inputs: []InputConfiguration{{
+					Decoder: "sflow",
+					Config: &pcap.Configuration{
+						Path:  "/tmp/sflow.pcap",
+						Speed: 10,
+					},
+				}},
+			},
+		}, {
+			Description: "variable-length policies",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"decoders": gin.H{
+						"variable-length-max-size": 1024,
+						"variable-length-policies": map[interface{}]interface{}{
+							82:        "keep",
+							"25461:2": "skip",
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Decoders: decoder.Configuration{
+					VariableLengthMaxSize: 1024,
+					VariableLengthPolicies: map[decoder.InformationElement]decoder.VariableLengthPolicy{
+						{ID: 82}:                   decoder.VariableLengthPolicyKeep,
+						{Enterprise: 25461, ID: 2}: decoder.VariableLengthPolicySkip,
+					},
+				},
+			},
+		}, {
+			Description: "NSEL decoding",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"decoders": gin.H{
+						"nsel": true,
+					},
+				}
+			},
+			Expected: Configuration{
+				Decoders: decoder.Configuration{
+					NSEL: true,
+				},
+			},
+		}, {
+			Description: "structured data policy",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"decoders": gin.H{
+						"structured-data-policy": "first",
+					},
+				}
+			},
+			Expected: Configuration{
+				Decoders: decoder.Configuration{
+					StructuredDataPolicy: decoder.StructuredDataPolicyFirst,
+				},
+			},
+		}, {
+			Description: "information elements",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"decoders": gin.H{
+						"information-elements": gin.H{
+							"25461:56": gin.H{
+								"name":   "applicationId",
+								"column": "AppID",
+							},
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Decoders: decoder.Configuration{
+					InformationElements: map[decoder.InformationElement]decoder.InformationElementConfiguration{
+						{Enterprise: 25461, ID: 56}: {Name: "applicationId", Column: "AppID"},
+					},
+				},
+			},
+		}, {
+			Description: "deduplication",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"deduplication": gin.H{
+						"window":   "10s",
+						"strategy": "count",
+						"exporter-groups": gin.H{
+							"paris": []string{"192.0.2.0/28", "2001:db8::/64"},
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Deduplication: DeduplicationConfiguration{
+					Window:   10 * time.Second,
+					Strategy: DeduplicationStrategyCount,
+					ExporterGroups: map[string][]netip.Prefix{
+						"paris": {
+							netip.MustParsePrefix("192.0.2.0/28"),
+							netip.MustParsePrefix("2001:db8::/64"),
+						},
+					},
+				},
+			},
+		}, {
+			Description: "invalid variable-length policy",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"decoders": gin.H{
+						"variable-length-policies": gin.H{
+							"82": "drop",
+						},
+					},
+				}
+			},
+			Error: true,
+		}, {
+			Description: "rate limits as a single value",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"rate-limits": gin.H{
+						"flows":   1000,
+						"packets": 100,
+						"policy":  "drop",
+					},
+				}
+			},
+			Expected: Configuration{
+				RateLimits: helpers.MustNewSubnetMap(map[string]RateLimitConfiguration{
+					"::/0": {Flows: 1000, Packets: 100, Policy: RateLimitPolicyDrop},
+				}),
+			},
+		}, {
+			Description: "rate limits as a map",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"rate-limit": 10000,
+					"rate-limits": gin.H{
+						"192.0.2.0/24": gin.H{
+							"packets": 100,
+						},
+						"2001:db8::1": gin.H{
+							"flows":  1000,
+							"policy": "sample",
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				RateLimit: 10000,
+				RateLimits: helpers.MustNewSubnetMap(map[string]RateLimitConfiguration{
+					"::ffff:192.0.2.0/120": {Packets: 100},
+					"2001:db8::1/128":      {Flows: 1000},
+				}),
+			},
+		}, {
+			Description: "invalid rate limit policy",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"rate-limits": gin.H{
+						"flows":  1000,
+						"policy": "ignore",
+					},
+				}
+			},
+			Error: true,
 		}, {
 			Description: "incorrect decoder",
 			Initial: func() interface{} {
@@ -193,22 +575,43 @@ func TestDecodeConfiguration(t *testing.T) {
 	})
 }
 
+func TestValidateRateLimits(t *testing.T) {
+	config := DefaultConfiguration()
+	config.RateLimits = helpers.MustNewSubnetMap(map[string]RateLimitConfiguration{
+		"::/0":                 {Flows: 1000},
+		"::ffff:192.0.2.0/120": {Packets: 100, Policy: RateLimitPolicyDrop},
+	})
+	if err := helpers.Validate.Struct(config); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+	config.RateLimits = helpers.MustNewSubnetMap(map[string]RateLimitConfiguration{
+		"::ffff:192.0.2.0/120": {Flows: 10},
+	})
+	if err := helpers.Validate.Struct(config); err == nil {
+		t.Fatal("validate.Struct() did not error")
+	}
+}
+
 func TestMarshalYAML(t *testing.T) {
 	cfg := Configuration{
 		Inputs: []InputConfiguration{
 			{
 				Decoder: "netflow",
 				Config: &udp.Configuration{
-					Listen:    "192.0.2.11:2055",
-					QueueSize: 1000,
-					Workers:   3,
+					Listen:      "192.0.2.11:2055",
+					QueueSize:   1000,
+					Workers:     3,
+					BatchSize:   32,
+					Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
 				},
 			}, {
 				Decoder: "sflow",
 				Config: &udp.Configuration{
-					Listen:    "192.0.2.11:6343",
-					QueueSize: 1000,
-					Workers:   3,
+					Listen:      "192.0.2.11:6343",
+					QueueSize:   1000,
+					Workers:     3,
+					BatchSize:   32,
+					Autoscaling: udp.AutoscalingConfiguration{MinWorkers: 1, QueueSize: 10000, Interval: time.Second},
 				},
 				UseSrcAddrForExporterAddr: true,
 			},
@@ -219,21 +622,94 @@ func TestMarshalYAML(t *testing.T) {
 		t.Fatalf("Marshal() error:\n%+v", err)
 	}
 	expected := `inputs:
-    - decoder: netflow
+    - allowedexporters: []
+      autoscaling:
+        minworkers: 1
+        maxworkers: 0
+        queuesize: 10000
+        interval: 1s
+      batchsize: 32
+      cpuaffinity: []
+      decoder: netflow
+      deniedexporters: []
+      dtls:
+        enable: false
+        certfile: ""
+        keyfile: ""
+        cafile: ""
+      forward: []
       listen: 192.0.2.11:2055
       queuesize: 1000
       receivebuffer: 0
       type: udp
       usesrcaddrforexporteraddr: false
       workers: 3
-    - decoder: sflow
+      zerovolumepolicy: {}
+    - allowedexporters: []
+      autoscaling:
+        minworkers: 1
+        maxworkers: 0
+        queuesize: 10000
+        interval: 1s
+      batchsize: 32
+      cpuaffinity: []
+      decoder: sflow
+      deniedexporters: []
+      dtls:
+        enable: false
+        certfile: ""
+        keyfile: ""
+        cafile: ""
+      forward: []
       listen: 192.0.2.11:6343
       queuesize: 1000
       receivebuffer: 0
       type: udp
       usesrcaddrforexporteraddr: true
       workers: 3
+      zerovolumepolicy: {}
 ratelimit: 0
+ratelimits: null
+tailratelimit: 0
+tailmaxduration: 0s
+capturemaxpackets: 0
+capturemaxduration: 0s
+degradedingest:
+    interval: 0s
+    window: 0s
+    threshold: 0
+    recoverythreshold: 0
+    webhook: ""
+    webhooktimeout: 0s
+decoders:
+    variablelengthmaxsize: 0
+    variablelengthpolicies: {}
+    templatespersistfile: ""
+    nsel: false
+    interfacecounters: false
+    dropnotifications: false
+    structureddatapolicy: skip
+    biflowpolicy: ignore
+    informationelements: {}
+    timestampsource: received
+    timestampmaxskew: 0s
+    spreadinterval: 0s
+    clockskewcorrection: false
+    templatemisspolicy: drop
+    templatemisswindow: 0s
+    sharedtemplates:
+        enabled: false
+        protocol: ""
+        server: ""
+        username: ""
+        password: ""
+        db: 0
+        ttl: 0s
+deduplication:
+    window: 0s
+    strategy: drop
+    exportergroups: {}
+    maxflows: 0
 `
 	if diff := helpers.Diff(strings.Split(string(got), "\n"), strings.Split(expected, "\n")); diff != "" {
 		t.Fatalf("Marshal() (-got, +want):\n%s", diff)
@@ -4,28 +4,44 @@
 package flow
 
 import (
+	"fmt"
 	"net/netip"
 
+	"akvorado/common/helpers"
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
-	"akvorado/inlet/flow/decoder/netflow"
-	"akvorado/inlet/flow/decoder/sflow"
+	// Built-in decoders
+	_ "akvorado/inlet/flow/decoder/netflow"
+	_ "akvorado/inlet/flow/decoder/protobuf"
+	_ "akvorado/inlet/flow/decoder/sflow"
 )
 
 type wrappedDecoder struct {
 	c                         *Component
 	orig                      decoder.Decoder
 	useSrcAddrForExporterAddr bool
+	zeroVolumePolicy          *helpers.SubnetMap[ZeroVolumePolicy]
+	zeroVolumeDefault         ZeroVolumePolicy
+	acl                       *exporterACL
 }
 
 // Decode decodes a flow while keeping some stats.
 func (wd *wrappedDecoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
+	source, _ := netip.AddrFromSlice(in.Source.To16())
 	defer func() {
 		if r := recover(); r != nil {
 			wd.c.metrics.decoderErrors.WithLabelValues(wd.orig.Name()).
 				Inc()
 		}
 	}()
+	if !wd.acl.accept(source) {
+		wd.c.metrics.rejectedDatagrams.WithLabelValues(source.Unmap().String()).
+			Inc()
+		return []*schema.FlowMessage{}
+	}
+	if wd.c.capture.active() {
+		wd.c.capture.publish(in, captureDefaultPorts[wd.orig.Name()])
+	}
 	decoded := wd.orig.Decode(in)
 
 	if decoded == nil {
@@ -35,15 +51,42 @@ func (wd *wrappedDecoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 	}
 
 	if wd.useSrcAddrForExporterAddr {
-		exporterAddress, _ := netip.AddrFromSlice(in.Source.To16())
 		for _, f := range decoded {
-			f.ExporterAddress = exporterAddress
+			f.ExporterAddress = source
 		}
 	}
 
 	wd.c.metrics.decoderStats.WithLabelValues(wd.orig.Name()).
 		Inc()
-	return decoded
+	if len(decoded) > 0 {
+		wd.c.ingest.received(decoded[0].ExporterAddress)
+	}
+	return wd.handleZeroVolume(decoded)
+}
+
+// handleZeroVolume drops or tags flows without bytes or packets, depending
+// on the policy for their exporter.
+func (wd *wrappedDecoder) handleZeroVolume(decoded []*schema.FlowMessage) []*schema.FlowMessage {
+	kept := decoded[:0]
+	for _, f := range decoded {
+		counters := f.Counters()
+		if counters.Bytes != 0 && counters.Packets != 0 {
+			kept = append(kept, f)
+			continue
+		}
+		policy := wd.zeroVolumePolicy.LookupOrDefault(f.ExporterAddress, wd.zeroVolumeDefault)
+		wd.c.metrics.zeroVolumeFlows.WithLabelValues(f.ExporterAddress.Unmap().String(), policy.String()).
+			Inc()
+		switch policy {
+		case ZeroVolumePolicyDrop:
+			schema.ReleaseFlowMessage(f)
+			continue
+		case ZeroVolumePolicyTag:
+			wd.c.d.Schema.ProtobufAppendVarint(f, schema.ColumnZeroVolume, 1)
+		}
+		kept = append(kept, f)
+	}
+	return kept
 }
 
 // Name returns the name of the original decoder.
@@ -52,15 +95,24 @@ func (wd *wrappedDecoder) Name() string {
 }
 
 // wrapDecoder wraps the provided decoders to get statistics from it.
-func (c *Component) wrapDecoder(d decoder.Decoder, useSrcAddrForExporterAddr bool) decoder.Decoder {
+func (c *Component) wrapDecoder(d decoder.Decoder, input InputConfiguration) (decoder.Decoder, error) {
+	acl, err := newExporterACL(input.AllowedExporters, input.DeniedExporters)
+	if err != nil {
+		return nil, fmt.Errorf("invalid exporter prefixes: %w", err)
+	}
 	return &wrappedDecoder{
 		c:                         c,
 		orig:                      d,
-		useSrcAddrForExporterAddr: useSrcAddrForExporterAddr,
-	}
+		useSrcAddrForExporterAddr: input.UseSrcAddrForExporterAddr,
+		zeroVolumePolicy:          &input.ZeroVolumePolicy,
+		zeroVolumeDefault:         zeroVolumeDefaults[input.Decoder],
+		acl:                       acl,
+	}, nil
 }
 
-var decoders = map[string]decoder.NewDecoderFunc{
-	"netflow": netflow.New,
-	"sflow":   sflow.New,
+// zeroVolumeDefaults are the zero volume policies to use for each decoder
+// when not configured. Some NetFlow exporters send flows without bytes or
+// packets. Other decoders default to keep them.
+var zeroVolumeDefaults = map[string]ZeroVolumePolicy{
+	"netflow": ZeroVolumePolicyDrop,
 }
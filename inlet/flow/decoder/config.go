@@ -0,0 +1,401 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package decoder
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+
+	"akvorado/common/helpers"
+	"akvorado/common/helpers/bimap"
+)
+
+// Configuration describes the configuration shared by all decoders.
+type Configuration struct {
+	// VariableLengthMaxSize is the maximum size of a variable-length
+	// field. Records with a larger field are dropped. 0 means no limit.
+	VariableLengthMaxSize uint `validate:"max=65535" doc:"Maximum size of a variable-length IPFIX field (0 for no limit)"`
+	// VariableLengthPolicies tells what to do with each variable-length
+	// information element. When not present, fields are kept.
+	VariableLengthPolicies map[InformationElement]VariableLengthPolicy `doc:"What to do with variable-length IPFIX fields (keep or skip), per information element"`
+	// TemplatesPersistFile defines a file to store templates and sampling
+	// rates to survive restarts.
+	TemplatesPersistFile string `doc:"File to persist NetFlow/IPFIX templates and sampling rates across restarts"`
+	// NSEL enables decoding of NetFlow Security Event Logging records, as
+	// sent by Cisco ASA and FTD firewalls. Volumes are read from the
+	// initiator counters and the firewall event is extracted.
+	NSEL bool `doc:"Decode Cisco ASA/FTD NSEL records (volumes from initiator counters, firewall events)"`
+	// InterfaceCounters enables the export of sFlow interface counters as
+	// metrics.
+	InterfaceCounters bool `doc:"Expose interface counters from sFlow counter samples as metrics"`
+	// DropNotifications enables the decoding of sFlow drop notifications
+	// (discarded packets). They are exported as flows with a dropped
+	// forwarding status and the reason of the drop.
+	DropNotifications bool `doc:"Decode sFlow drop notifications (discarded packets)"`
+	// StructuredDataPolicy tells what to do with IPFIX structured data
+	// (basicList, subTemplateList and subTemplateMultiList).
+	StructuredDataPolicy StructuredDataPolicy `doc:"What to do with IPFIX structured data (skip or first)"`
+	// BiflowPolicy tells what to do with the reverse direction of IPFIX
+	// biflows (RFC 5103).
+	BiflowPolicy BiflowPolicy `doc:"What to do with the reverse direction of IPFIX biflows (ignore or split)"`
+	// InformationElements maps enterprise-specific information elements to
+	// custom dimensions of the schema.
+	InformationElements map[InformationElement]InformationElementConfiguration `validate:"dive" doc:"Custom dimensions to populate from enterprise-specific IPFIX fields, per information element"`
+	// TimestampSource tells which timestamp to use for NetFlow/IPFIX
+	// flows. By default, flows are timestamped when they are received.
+	TimestampSource TimestampSource `doc:"Timestamp to use for NetFlow/IPFIX flows (received, flow-start, or flow-end)"`
+	// TimestampMaxSkew is the maximum difference between the timestamps
+	// of a flow and the time it was received. When exceeded, the time the
+	// flow was received is used. 0 disables this check.
+	TimestampMaxSkew time.Duration `doc:"Maximum difference between flow timestamps and reception time (0 for no limit)"`
+	// SpreadInterval, when not 0, splits flows lasting longer than this
+	// interval into several flows, one for each interval, with their
+	// share of bytes and packets. It requires TimestampSource to not be
+	// "received".
+	SpreadInterval time.Duration `validate:"isdefault|min=1s" doc:"Interval to spread flow volumes over their duration (0 to disable)"`
+	// ClockSkewCorrection shifts the timestamps of NetFlow/IPFIX flows by
+	// the difference between the export time of the packet and the time
+	// it was received. This helps with exporters with a wrong clock. It
+	// has no effect when TimestampSource is "received".
+	ClockSkewCorrection bool `doc:"Correct flow timestamps with the clock skew of the exporter"`
+	// TemplateMissPolicy tells what to do with NetFlow v9/IPFIX packets
+	// containing data sets whose template is unknown.
+	TemplateMissPolicy TemplateMissPolicy `doc:"What to do with data sets whose template is unknown (drop, partial, or buffer)"`
+	// TemplateMissWindow is how long data sets are kept while waiting for
+	// their template when TemplateMissPolicy is "buffer".
+	TemplateMissWindow time.Duration `validate:"min=1s" doc:"How long to buffer data sets waiting for their template"`
+	// SharedTemplates configures a Redis server to share NetFlow v9/IPFIX
+	// templates between several inlets receiving flows from the same
+	// exporters.
+	SharedTemplates SharedTemplatesConfiguration `doc:"Redis server to share NetFlow/IPFIX templates between inlets"`
+}
+
+// SharedTemplatesConfiguration describes the Redis server used to share
+// templates between inlets.
+type SharedTemplatesConfiguration struct {
+	// Enabled tells if templates should be shared.
+	Enabled bool `doc:"Share templates through Redis"`
+	// Protocol to connect with
+	Protocol string `validate:"oneof=tcp unix" doc:"Protocol to connect to Redis (tcp or unix)"`
+	// Server to connect to (with port)
+	Server string `validate:"required,listen" doc:"Redis server to connect to (with port)"`
+	// Optional username
+	Username string `doc:"Username to connect to Redis"`
+	// Optional password
+	Password string `doc:"Password to connect to Redis"`
+	// Database to connect to
+	DB int `doc:"Redis database"`
+	// TTL is how long templates are kept in Redis after they were last
+	// received.
+	TTL time.Duration `validate:"min=1s" doc:"How long templates are kept in Redis"`
+}
+
+// InformationElementConfiguration describes how to decode an
+// enterprise-specific information element. The value is decoded according
+// to the type of the target column: strings are copied as is, unsigned
+// integers are decoded as big-endian numbers.
+type InformationElementConfiguration struct {
+	// Name is a descriptive name for the information element.
+	Name string `doc:"Descriptive name of the information element"`
+	// Column is the name of the custom dimension to populate.
+	Column string `validate:"required" doc:"Custom dimension to populate with the information element"`
+}
+
+// DefaultConfiguration represents the default configuration for decoders.
+func DefaultConfiguration() Configuration {
+	return Configuration{
+		VariableLengthPolicies: map[InformationElement]VariableLengthPolicy{},
+		InformationElements:    map[InformationElement]InformationElementConfiguration{},
+		TimestampMaxSkew:       time.Hour,
+		TemplateMissWindow:     10 * time.Second,
+		SharedTemplates: SharedTemplatesConfiguration{
+			Protocol: "tcp",
+			Server:   "127.0.0.1:6379",
+			TTL:      time.Hour,
+		},
+	}
+}
+
+// InformationElement identifies an IPFIX information element. Enterprise is
+// 0 for IANA-assigned elements.
+type InformationElement struct {
+	Enterprise uint32
+	ID         uint16
+}
+
+// MarshalText turns an information element to text.
+func (ie InformationElement) MarshalText() ([]byte, error) {
+	if ie.Enterprise == 0 {
+		return []byte(strconv.Itoa(int(ie.ID))), nil
+	}
+	return []byte(fmt.Sprintf("%d:%d", ie.Enterprise, ie.ID)), nil
+}
+
+// String turns an information element to a string.
+func (ie InformationElement) String() string {
+	got, _ := ie.MarshalText()
+	return string(got)
+}
+
+// UnmarshalText parses an information element, either as "ID" or as
+// "enterprise:ID".
+func (ie *InformationElement) UnmarshalText(input []byte) error {
+	text := string(input)
+	var enterprise uint64
+	if before, after, found := strings.Cut(text, ":"); found {
+		var err error
+		enterprise, err = strconv.ParseUint(before, 10, 32)
+		if err != nil {
+			return fmt.Errorf("invalid enterprise number %q", before)
+		}
+		text = after
+	}
+	id, err := strconv.ParseUint(text, 10, 16)
+	if err != nil {
+		return fmt.Errorf("invalid information element %q", text)
+	}
+	*ie = InformationElement{Enterprise: uint32(enterprise), ID: uint16(id)}
+	return nil
+}
+
+// VariableLengthPolicy tells what to do with a variable-length field.
+type VariableLengthPolicy int
+
+const (
+	// VariableLengthPolicyKeep keeps the field. The record is dropped if the
+	// field is larger than the maximum size.
+	VariableLengthPolicyKeep VariableLengthPolicy = iota
+	// VariableLengthPolicySkip ignores the field but keeps the record.
+	VariableLengthPolicySkip
+)
+
+var variableLengthPolicyMap = bimap.New(map[VariableLengthPolicy]string{
+	VariableLengthPolicyKeep: "keep",
+	VariableLengthPolicySkip: "skip",
+})
+
+// MarshalText turns a variable-length policy to text.
+func (vlp VariableLengthPolicy) MarshalText() ([]byte, error) {
+	got, ok := variableLengthPolicyMap.LoadValue(vlp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown variable-length policy")
+}
+
+// String turns a variable-length policy to string.
+func (vlp VariableLengthPolicy) String() string {
+	got, _ := variableLengthPolicyMap.LoadValue(vlp)
+	return got
+}
+
+// UnmarshalText provides a variable-length policy from a string.
+func (vlp *VariableLengthPolicy) UnmarshalText(input []byte) error {
+	got, ok := variableLengthPolicyMap.LoadKey(string(input))
+	if ok {
+		*vlp = got
+		return nil
+	}
+	return errors.New("unknown variable-length policy")
+}
+
+// TemplateMissPolicy tells what to do with a NetFlow v9/IPFIX packet
+// containing data sets whose template is unknown.
+type TemplateMissPolicy int
+
+const (
+	// TemplateMissPolicyDrop drops the whole packet.
+	TemplateMissPolicyDrop TemplateMissPolicy = iota
+	// TemplateMissPolicyPartial drops the data sets with an unknown
+	// template and decodes the other ones.
+	TemplateMissPolicyPartial
+	// TemplateMissPolicyBuffer decodes the data sets with a known template
+	// and keeps the other ones until their template is received.
+	TemplateMissPolicyBuffer
+)
+
+var templateMissPolicyMap = bimap.New(map[TemplateMissPolicy]string{
+	TemplateMissPolicyDrop:    "drop",
+	TemplateMissPolicyPartial: "partial",
+	TemplateMissPolicyBuffer:  "buffer",
+})
+
+// MarshalText turns a template miss policy to text.
+func (tmp TemplateMissPolicy) MarshalText() ([]byte, error) {
+	got, ok := templateMissPolicyMap.LoadValue(tmp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown template miss policy")
+}
+
+// String turns a template miss policy to string.
+func (tmp TemplateMissPolicy) String() string {
+	got, _ := templateMissPolicyMap.LoadValue(tmp)
+	return got
+}
+
+// UnmarshalText provides a template miss policy from a string.
+func (tmp *TemplateMissPolicy) UnmarshalText(input []byte) error {
+	got, ok := templateMissPolicyMap.LoadKey(string(input))
+	if ok {
+		*tmp = got
+		return nil
+	}
+	return errors.New("unknown template miss policy")
+}
+
+// StructuredDataPolicy tells what to do with IPFIX structured data.
+type StructuredDataPolicy int
+
+const (
+	// StructuredDataPolicySkip ignores structured data.
+	StructuredDataPolicySkip StructuredDataPolicy = iota
+	// StructuredDataPolicyFirst flattens the first element of each list
+	// into the record, unless the record already contains its fields.
+	StructuredDataPolicyFirst
+)
+
+var structuredDataPolicyMap = bimap.New(map[StructuredDataPolicy]string{
+	StructuredDataPolicySkip:  "skip",
+	StructuredDataPolicyFirst: "first",
+})
+
+// MarshalText turns a structured data policy to text.
+func (sdp StructuredDataPolicy) MarshalText() ([]byte, error) {
+	got, ok := structuredDataPolicyMap.LoadValue(sdp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown structured data policy")
+}
+
+// String turns a structured data policy to string.
+func (sdp StructuredDataPolicy) String() string {
+	got, _ := structuredDataPolicyMap.LoadValue(sdp)
+	return got
+}
+
+// UnmarshalText provides a structured data policy from a string.
+func (sdp *StructuredDataPolicy) UnmarshalText(input []byte) error {
+	got, ok := structuredDataPolicyMap.LoadKey(string(input))
+	if ok {
+		*sdp = got
+		return nil
+	}
+	return errors.New("unknown structured data policy")
+}
+
+// BiflowPolicy tells what to do with the reverse direction of IPFIX
+// biflows.
+type BiflowPolicy int
+
+const (
+	// BiflowPolicyIgnore ignores the reverse information elements.
+	BiflowPolicyIgnore BiflowPolicy = iota
+	// BiflowPolicySplit produces an additional flow for the reverse
+	// direction.
+	BiflowPolicySplit
+)
+
+var biflowPolicyMap = bimap.New(map[BiflowPolicy]string{
+	BiflowPolicyIgnore: "ignore",
+	BiflowPolicySplit:  "split",
+})
+
+// MarshalText turns a biflow policy to text.
+func (bp BiflowPolicy) MarshalText() ([]byte, error) {
+	got, ok := biflowPolicyMap.LoadValue(bp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown biflow policy")
+}
+
+// String turns a biflow policy to string.
+func (bp BiflowPolicy) String() string {
+	got, _ := biflowPolicyMap.LoadValue(bp)
+	return got
+}
+
+// UnmarshalText provides a biflow policy from a string.
+func (bp *BiflowPolicy) UnmarshalText(input []byte) error {
+	got, ok := biflowPolicyMap.LoadKey(string(input))
+	if ok {
+		*bp = got
+		return nil
+	}
+	return errors.New("unknown biflow policy")
+}
+
+// TimestampSource tells which timestamp to use for flows.
+type TimestampSource int
+
+const (
+	// TimestampSourceReceived uses the time the flow was received.
+	TimestampSourceReceived TimestampSource = iota
+	// TimestampSourceFlowStart uses the start of the flow.
+	TimestampSourceFlowStart
+	// TimestampSourceFlowEnd uses the end of the flow.
+	TimestampSourceFlowEnd
+)
+
+var timestampSourceMap = bimap.New(map[TimestampSource]string{
+	TimestampSourceReceived:  "received",
+	TimestampSourceFlowStart: "flow-start",
+	TimestampSourceFlowEnd:   "flow-end",
+})
+
+// MarshalText turns a timestamp source to text.
+func (ts TimestampSource) MarshalText() ([]byte, error) {
+	got, ok := timestampSourceMap.LoadValue(ts)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown timestamp source")
+}
+
+// String turns a timestamp source to string.
+func (ts TimestampSource) String() string {
+	got, _ := timestampSourceMap.LoadValue(ts)
+	return got
+}
+
+// UnmarshalText provides a timestamp source from a string.
+func (ts *TimestampSource) UnmarshalText(input []byte) error {
+	got, ok := timestampSourceMap.LoadKey(string(input))
+	if ok {
+		*ts = got
+		return nil
+	}
+	return errors.New("unknown timestamp source")
+}
+
+// informationElementUnmarshallerHook turns integers into strings for
+// information elements, as YAML keys like 95 are decoded as integers.
+func informationElementUnmarshallerHook() mapstructure.DecodeHookFunc {
+	return func(from, to reflect.Value) (interface{}, error) {
+		if to.Type() != reflect.TypeOf(InformationElement{}) {
+			return from.Interface(), nil
+		}
+		from = helpers.ElemOrIdentity(from)
+		switch from.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.FormatInt(from.Int(), 10), nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return strconv.FormatUint(from.Uint(), 10), nil
+		}
+		return from.Interface(), nil
+	}
+}
+
+func init() {
+	helpers.RegisterMapstructureUnmarshallerHook(informationElementUnmarshallerHook())
+}
@@ -0,0 +1,104 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"github.com/netsampler/goflow2/decoders/netflow"
+)
+
+// reversePEN is the private enterprise number used by RFC 5103 to encode
+// reverse information elements. They use the same ID as the forward ones.
+const reversePEN = 29305
+
+// reverseSwappedFields maps the fields describing one side of the flow to
+// the field describing the other side. In the reverse direction, the source
+// becomes the destination and the input interface becomes the output one.
+var reverseSwappedFields = func() map[uint16]uint16 {
+	pairs := [][2]uint16{
+		{netflow.NFV9_FIELD_IPV4_SRC_ADDR, netflow.NFV9_FIELD_IPV4_DST_ADDR},
+		{netflow.NFV9_FIELD_IPV6_SRC_ADDR, netflow.NFV9_FIELD_IPV6_DST_ADDR},
+		{netflow.NFV9_FIELD_SRC_MASK, netflow.NFV9_FIELD_DST_MASK},
+		{netflow.NFV9_FIELD_IPV6_SRC_MASK, netflow.NFV9_FIELD_IPV6_DST_MASK},
+		{netflow.NFV9_FIELD_L4_SRC_PORT, netflow.NFV9_FIELD_L4_DST_PORT},
+		{netflow.NFV9_FIELD_SRC_AS, netflow.NFV9_FIELD_DST_AS},
+		{netflow.NFV9_FIELD_INPUT_SNMP, netflow.NFV9_FIELD_OUTPUT_SNMP},
+		{netflow.NFV9_FIELD_SRC_VLAN, netflow.NFV9_FIELD_DST_VLAN},
+		{netflow.IPFIX_FIELD_dot1qVlanId, netflow.IPFIX_FIELD_postDot1qVlanId},
+		{netflow.NFV9_FIELD_IN_SRC_MAC, netflow.NFV9_FIELD_IN_DST_MAC},
+		{netflow.NFV9_FIELD_OUT_SRC_MAC, netflow.NFV9_FIELD_OUT_DST_MAC},
+		{netflow.IPFIX_FIELD_postNATSourceIPv4Address, netflow.IPFIX_FIELD_postNATDestinationIPv4Address},
+		{netflow.IPFIX_FIELD_postNATSourceIPv6Address, netflow.IPFIX_FIELD_postNATDestinationIPv6Address},
+		{netflow.IPFIX_FIELD_postNAPTSourceTransportPort, netflow.IPFIX_FIELD_postNAPTDestinationTransportPort},
+	}
+	result := make(map[uint16]uint16, 2*len(pairs))
+	for _, pair := range pairs {
+		result[pair[0]] = pair[1]
+		result[pair[1]] = pair[0]
+	}
+	return result
+}()
+
+// reverseDroppedFields are the forward fields which do not apply to the
+// reverse direction when no reverse field is provided, like counters, next
+// hops, or TCP flags.
+var reverseDroppedFields = map[uint16]bool{
+	netflow.NFV9_FIELD_IN_BYTES:               true,
+	netflow.NFV9_FIELD_OUT_BYTES:              true,
+	netflow.NFV9_FIELD_IN_PKTS:                true,
+	netflow.NFV9_FIELD_OUT_PKTS:               true,
+	netflow.IPFIX_FIELD_initiatorOctets:       true,
+	netflow.IPFIX_FIELD_initiatorPackets:      true,
+	netflow.NFV9_FIELD_IPV4_NEXT_HOP:          true,
+	netflow.NFV9_FIELD_IPV6_NEXT_HOP:          true,
+	netflow.NFV9_FIELD_BGP_IPV4_NEXT_HOP:      true,
+	netflow.NFV9_FIELD_BGP_IPV6_NEXT_HOP:      true,
+	netflow.NFV9_FIELD_TCP_FLAGS:              true,
+	netflow.NFV9_FIELD_FORWARDING_STATUS:      true,
+	netflow.IPFIX_FIELD_ipHeaderPacketSection: true,
+}
+
+// reverseFields builds the fields of the reverse direction of a biflow.
+// Reverse information elements replace the forward ones and the fields
+// describing each side of the flow are swapped. It returns nil if the record
+// has no reverse volume.
+func reverseFields(fields []netflow.DataField) []netflow.DataField {
+	reversed := map[uint16]bool{}
+	hasVolume := false
+	for _, field := range fields {
+		if !field.PenProvided || field.Pen != reversePEN {
+			continue
+		}
+		reversed[field.Type] = true
+		switch field.Type {
+		case netflow.NFV9_FIELD_IN_BYTES, netflow.NFV9_FIELD_IN_PKTS:
+			if v, ok := field.Value.([]byte); ok && decodeUNumber(v) > 0 {
+				hasVolume = true
+			}
+		}
+	}
+	if !hasVolume {
+		return nil
+	}
+
+	result := make([]netflow.DataField, 0, len(fields))
+	for _, field := range fields {
+		switch {
+		case field.PenProvided && field.Pen == reversePEN:
+			field.PenProvided = false
+			field.Pen = 0
+		case field.PenProvided:
+		default:
+			if swapped, ok := reverseSwappedFields[field.Type]; ok {
+				if reversed[swapped] {
+					continue
+				}
+				field.Type = swapped
+			} else if reversed[field.Type] || reverseDroppedFields[field.Type] {
+				continue
+			}
+		}
+		result = append(result, field)
+	}
+	return result
+}
@@ -5,49 +5,125 @@
 package netflow
 
 import (
+	"bytes"
 	"encoding/binary"
 	"net/netip"
 
 	"akvorado/common/helpers"
 	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
 
 	"github.com/netsampler/goflow2/decoders/netflow"
+	"github.com/netsampler/goflow2/decoders/netflowlegacy"
 	"github.com/netsampler/goflow2/producer"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
-func (nd *Decoder) decode(msgDec interface{}, samplingRateSys producer.SamplingRateSystem) []*schema.FlowMessage {
+// nselFieldFirewallEvent is the legacy NSEL field for firewall events
+// (NF_F_FW_EVENT), used by older ASA releases instead of firewallEvent.
+const nselFieldFirewallEvent = 40005
+
+// IPFIX elements for the SRv6 segment routing header (RFC 9487). They are
+// not known to goflow2.
+const (
+	ipfixFieldSRHActiveSegmentIPv6      = 495
+	ipfixFieldSRHSegmentIPv6BasicList   = 496
+	ipfixFieldSRHSegmentIPv6ListSection = 497
+	ipfixFieldSRHSegmentsIPv6Left       = 498
+)
+
+// decode decodes the flows contained in a NetFlow/IPFIX packet. received is
+// the time the packet was received, in seconds.
+func (nd *Decoder) decode(msgDec interface{}, samplingRateSys *samplingRateSystem, templates *templateSystem, received uint64) []*schema.FlowMessage {
 	flowMessageSet := []*schema.FlowMessage{}
+	var version uint16
 	var obsDomainID uint32
+	var et exportTime
+	var offset int64
 	var dataFlowSet []netflow.DataFlowSet
 	var optionsDataFlowSet []netflow.OptionsDataFlowSet
 	switch msgDecConv := msgDec.(type) {
 	case netflow.NFv9Packet:
 		dataFlowSet, _, _, optionsDataFlowSet = producer.SplitNetFlowSets(msgDecConv)
 		obsDomainID = msgDecConv.SourceId
+		et = exportTime{sysUptime: msgDecConv.SystemUptime, unixSecs: msgDecConv.UnixSeconds}
+		offset = nd.clockOffset(msgDecConv.UnixSeconds, received)
+		version = 9
 	case netflow.IPFIXPacket:
 		dataFlowSet, _, _, optionsDataFlowSet = producer.SplitIPFIXSets(msgDecConv)
 		obsDomainID = msgDecConv.ObservationDomainId
+		offset = nd.clockOffset(msgDecConv.ExportTime, received)
+		version = 10
+	case netflowlegacy.PacketNetFlowV5:
+		return nd.decodeV5(msgDecConv, received)
 	default:
 		return nil
 	}
 
-	// Get sampling rate
-	samplingRate, found := producer.SearchNetFlowOptionDataSets(optionsDataFlowSet)
+	// Learn sampling rates from options data records
 	if samplingRateSys != nil {
-		if found {
-			samplingRateSys.AddSamplingRate(10, obsDomainID, samplingRate)
-		} else {
-			samplingRate, _ = samplingRateSys.GetSamplingRate(10, obsDomainID)
+		for _, optionsDataFlowSetItem := range optionsDataFlowSet {
+			for _, record := range optionsDataFlowSetItem.Records {
+				samplingRate, found := findSamplingRate(record.OptionsValues)
+				if !found {
+					continue
+				}
+				samplerID := findSamplerID(record.ScopesValues)
+				if samplerID == 0 {
+					samplerID = findSamplerID(record.OptionsValues)
+				}
+				samplingRateSys.SetSamplingRate(version, obsDomainID, samplerID, samplingRate)
+			}
 		}
 	}
 
 	// Parse fields
 	for _, dataFlowSetItem := range dataFlowSet {
+		fields := templates.variableLengthTemplate(version, obsDomainID, dataFlowSetItem.Id)
 		for _, record := range dataFlowSetItem.Records {
-			flow := nd.decodeRecord(record.Values)
-			if flow != nil {
-				flow.SamplingRate = samplingRate
-				flowMessageSet = append(flowMessageSet, flow)
+			values := record.Values
+			if fields != nil {
+				var reason string
+				values, reason = nd.applyVariableLengthPolicies(fields, values)
+				if reason != "" {
+					nd.metrics.errors.WithLabelValues(templates.key, reason).Inc()
+					continue
+				}
+			}
+			if version == 10 && templates != nil && nd.config.StructuredDataPolicy == decoder.StructuredDataPolicyFirst {
+				values = nd.flattenStructuredData(obsDomainID, templates, values)
+			}
+			directions := [][]netflow.DataField{values}
+			if version == 10 && nd.config.BiflowPolicy == decoder.BiflowPolicySplit {
+				if reverse := reverseFields(values); reverse != nil {
+					directions = append(directions, reverse)
+				}
+			}
+			for _, values := range directions {
+				var start, end uint64
+				var ok bool
+				if nd.config.TimestampSource != decoder.TimestampSourceReceived {
+					start, end, ok = flowTimes(values, et)
+					start, end = shift(start, offset), shift(end, offset)
+				}
+				slices := nd.timeSlices(start, end, ok, received)
+				var previous uint64
+				for _, slice := range slices {
+					sliceValues := values
+					if len(slices) > 1 {
+						sliceValues = spreadFields(values, slice, previous, end-start)
+						previous = slice.end
+					}
+					flow := nd.decodeRecord(sliceValues)
+					if flow == nil {
+						continue
+					}
+					flow.TimeReceived = slice.timestamp
+					if samplingRateSys != nil {
+						flow.SamplingRate = samplingRateSys.GetSamplingRate(version, obsDomainID, findSamplerID(values))
+					}
+					flowMessageSet = append(flowMessageSet, flow)
+				}
 			}
 		}
 	}
@@ -55,15 +131,48 @@ func (nd *Decoder) decode(msgDec interface{}, samplingRateSys producer.SamplingR
 	return flowMessageSet
 }
 
+// applyVariableLengthPolicies checks a record decoded with a template
+// containing variable-length fields. Skipped fields are removed. When the
+// record should be dropped, the reason is returned.
+func (nd *Decoder) applyVariableLengthPolicies(fields []netflow.Field, values []netflow.DataField) ([]netflow.DataField, string) {
+	if len(values) != len(fields) {
+		return nil, "truncated record"
+	}
+	result := make([]netflow.DataField, 0, len(values))
+	for idx, field := range fields {
+		v, _ := values[idx].Value.([]byte)
+		if field.Length != variableLength {
+			if len(v) != int(field.Length) {
+				return nil, "truncated record"
+			}
+			result = append(result, values[idx])
+			continue
+		}
+		ie := decoder.InformationElement{ID: field.Type}
+		if field.PenProvided {
+			ie.Enterprise = field.Pen
+		}
+		if nd.config.VariableLengthPolicies[ie] == decoder.VariableLengthPolicySkip {
+			continue
+		}
+		if nd.config.VariableLengthMaxSize > 0 && uint(len(v)) > nd.config.VariableLengthMaxSize {
+			return nil, "variable-length field too large"
+		}
+		result = append(result, values[idx])
+	}
+	return result, ""
+}
+
 func (nd *Decoder) decodeRecord(fields []netflow.DataField) *schema.FlowMessage {
 	var etype uint16
-	bf := &schema.FlowMessage{}
+	bf := schema.NewFlowMessage()
 	for _, field := range fields {
 		v, ok := field.Value.([]byte)
 		if !ok {
 			continue
 		}
 		if field.PenProvided {
+			nd.decodeEnterpriseField(bf, field.Pen, field.Type, v)
 			continue
 		}
 
@@ -113,10 +222,22 @@ func (nd *Decoder) decodeRecord(fields []netflow.DataField) *schema.FlowMessage
 			bf.InIf = uint32(decodeUNumber(v))
 		case netflow.NFV9_FIELD_OUTPUT_SNMP:
 			bf.OutIf = uint32(decodeUNumber(v))
+		case netflow.IPFIX_FIELD_ingressVRFID:
+			bf.SrcVRFID = uint32(decodeUNumber(v))
+		case netflow.IPFIX_FIELD_egressVRFID:
+			bf.DstVRFID = uint32(decodeUNumber(v))
 
 		// Remaining
 		case netflow.NFV9_FIELD_FORWARDING_STATUS:
 			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnForwardingStatus, decodeUNumber(v))
+		case netflow.NFV9_FIELD_DIRECTION:
+			// Same as flowDirection for IPFIX
+			switch decodeUNumber(v) {
+			case 0:
+				bf.ExportDirection = schema.FlowExportDirectionIngress
+			case 1:
+				bf.ExportDirection = schema.FlowExportDirectionEgress
+			}
 		default:
 
 			if !nd.d.Schema.IsDisabled(schema.ColumnGroupNAT) {
@@ -130,6 +251,23 @@ func (nd *Decoder) decodeRecord(fields []netflow.DataField) *schema.FlowMessage
 					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPortNAT, decodeUNumber(v))
 				case netflow.IPFIX_FIELD_postNAPTDestinationTransportPort:
 					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPortNAT, decodeUNumber(v))
+				case netflow.IPFIX_FIELD_postNATSourceIPv6Address:
+					nd.d.Schema.ProtobufAppendIP(bf, schema.ColumnSrcAddrNAT, decodeIP(v))
+				case netflow.IPFIX_FIELD_postNATDestinationIPv6Address:
+					nd.d.Schema.ProtobufAppendIP(bf, schema.ColumnDstAddrNAT, decodeIP(v))
+				}
+			}
+
+			if nd.config.NSEL {
+				// NSEL: ASA/FTD only provide volumes for the initiator
+				// and responder directions, we keep the initiator ones.
+				switch field.Type {
+				case netflow.IPFIX_FIELD_initiatorOctets:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnBytes, decodeUNumber(v))
+				case netflow.IPFIX_FIELD_initiatorPackets:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnPackets, decodeUNumber(v))
+				case netflow.IPFIX_FIELD_firewallEvent, nselFieldFirewallEvent:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnFirewallEvent, decodeUNumber(v))
 				}
 			}
 
@@ -140,6 +278,10 @@ func (nd *Decoder) decodeRecord(fields []netflow.DataField) *schema.FlowMessage
 					bf.SrcVlan = uint16(decodeUNumber(v))
 				case netflow.NFV9_FIELD_DST_VLAN:
 					bf.DstVlan = uint16(decodeUNumber(v))
+				case netflow.IPFIX_FIELD_dot1qVlanId:
+					bf.SrcVlan = uint16(decodeUNumber(v) & 0xfff)
+				case netflow.IPFIX_FIELD_postDot1qVlanId:
+					bf.DstVlan = uint16(decodeUNumber(v) & 0xfff)
 				case netflow.NFV9_FIELD_IN_SRC_MAC:
 					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcMAC, decodeUNumber(v))
 				case netflow.NFV9_FIELD_IN_DST_MAC:
@@ -150,12 +292,139 @@ func (nd *Decoder) decodeRecord(fields []netflow.DataField) *schema.FlowMessage
 					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstMAC, decodeUNumber(v))
 				}
 			}
+
+			if !nd.d.Schema.IsDisabled(schema.ColumnGroupMPLS) {
+				// MPLS: label (20 bits), traffic class (3 bits), bottom of stack (1 bit)
+				switch field.Type {
+				case netflow.NFV9_FIELD_MPLS_LABEL_1:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnMPLSLabel1, decodeUNumber(v)>>4)
+				case netflow.NFV9_FIELD_MPLS_LABEL_2:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnMPLSLabel2, decodeUNumber(v)>>4)
+				case netflow.NFV9_FIELD_MPLS_LABEL_3:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnMPLSLabel3, decodeUNumber(v)>>4)
+				}
+			}
+
+			if !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
+				// L3/L4
+				switch field.Type {
+				case netflow.NFV9_FIELD_SRC_TOS:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPTos, decodeUNumber(v))
+				case netflow.NFV9_FIELD_IPV6_FLOW_LABEL:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPv6FlowLabel, decodeUNumber(v)&0xfffff)
+				case netflow.NFV9_FIELD_TCP_FLAGS:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnTCPFlags, decodeUNumber(v))
+				case netflow.IPFIX_FIELD_icmpTypeCodeIPv4, netflow.IPFIX_FIELD_icmpTypeCodeIPv6:
+					typeCode := decodeUNumber(v)
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPType, typeCode>>8)
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPCode, typeCode&0xff)
+				case netflow.IPFIX_FIELD_icmpTypeIPv4, netflow.IPFIX_FIELD_icmpTypeIPv6:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPType, decodeUNumber(v))
+				case netflow.IPFIX_FIELD_icmpCodeIPv4, netflow.IPFIX_FIELD_icmpCodeIPv6:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPCode, decodeUNumber(v))
+				}
+			}
+
+			if !nd.d.Schema.IsDisabled(schema.ColumnGroupSRv6) {
+				// SRv6: segment routing header
+				switch field.Type {
+				case ipfixFieldSRHActiveSegmentIPv6:
+					nd.d.Schema.ProtobufAppendIP(bf, schema.ColumnSRv6ActiveSegment, decodeIP(v))
+				case ipfixFieldSRHSegmentsIPv6Left:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSRv6SegmentsLeft, decodeUNumber(v))
+				case ipfixFieldSRHSegmentIPv6ListSection:
+					nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSRv6SegmentListLength, uint64(len(v)/16))
+				case ipfixFieldSRHSegmentIPv6BasicList:
+					if count, ok := decodeBasicListLength(v); ok {
+						nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSRv6SegmentListLength, count)
+					}
+				}
+			}
+
+			if field.Type == netflow.IPFIX_FIELD_ipHeaderPacketSection {
+				// Tunnels: inner header from the sampled IP header
+				proto, payload := decoder.DecodeOuterIPHeader(v)
+				decoder.DecodeTunnel(nd.d.Schema, bf, proto, payload)
+			}
 		}
 	}
 	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, uint64(etype))
 	return bf
 }
 
+// decodeV5 decodes the records of a NetFlow v5 packet. They have a fixed
+// format and the sampling rate is in the header.
+func (nd *Decoder) decodeV5(packet netflowlegacy.PacketNetFlowV5, received uint64) []*schema.FlowMessage {
+	flowMessageSet := []*schema.FlowMessage{}
+	// The two first bits are the sampling mode
+	samplingRate := uint32(packet.SamplingInterval & 0x3fff)
+	et := exportTime{sysUptime: packet.SysUptime, unixSecs: packet.UnixSecs}
+	offset := nd.clockOffset(packet.UnixSecs, received)
+	for _, record := range packet.Records {
+		start := shift(et.absolute(record.First), offset)
+		end := shift(et.absolute(record.Last), offset)
+		slices := nd.timeSlices(start, end, end >= start, received)
+		var previous uint64
+		for _, slice := range slices {
+			octets, packets := uint64(record.DOctets), uint64(record.DPkts)
+			if len(slices) > 1 {
+				octets = slice.share(octets, previous, end-start)
+				packets = slice.share(packets, previous, end-start)
+				previous = slice.end
+			}
+			flowMessageSet = append(flowMessageSet,
+				nd.decodeV5Record(record, samplingRate, slice.timestamp, octets, packets))
+		}
+	}
+	return flowMessageSet
+}
+
+// decodeV5Record decodes a record of a NetFlow v5 packet.
+func (nd *Decoder) decodeV5Record(record netflowlegacy.RecordsNetFlowV5, samplingRate uint32, timestamp uint64, octets uint64, packets uint64) *schema.FlowMessage {
+	bf := schema.NewFlowMessage()
+	bf.TimeReceived = timestamp
+	bf.SamplingRate = samplingRate
+	bf.InIf = uint32(record.Input)
+	bf.OutIf = uint32(record.Output)
+	bf.SrcAddr = decodeIPv4(record.SrcAddr)
+	bf.DstAddr = decodeIPv4(record.DstAddr)
+	bf.NextHop = decodeIPv4(record.NextHop)
+	bf.SrcAS = uint32(record.SrcAS)
+	bf.DstAS = uint32(record.DstAS)
+	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnBytes, octets)
+	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnPackets, packets)
+	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv4)
+	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(record.Proto))
+	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(record.SrcPort))
+	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(record.DstPort))
+	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcNetMask, uint64(record.SrcMask))
+	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstNetMask, uint64(record.DstMask))
+	if !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnTCPFlags, uint64(record.TCPFlags))
+		if record.Proto == 1 {
+			// ICMP type and code are encoded in the destination port
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPType, uint64(record.DstPort>>8))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPCode, uint64(record.DstPort&0xff))
+		}
+	}
+	return bf
+}
+
+// decodeEnterpriseField decodes an enterprise-specific field into the custom
+// dimension configured for it, if any.
+func (nd *Decoder) decodeEnterpriseField(bf *schema.FlowMessage, pen uint32, id uint16, v []byte) {
+	column, ok := nd.informationElements[decoder.InformationElement{Enterprise: pen, ID: id}]
+	if !ok {
+		return
+	}
+	switch column.ProtobufType {
+	case protoreflect.StringKind:
+		column.ProtobufAppendBytes(bf, bytes.TrimRight(v, "\x00"))
+	case protoreflect.Uint64Kind:
+		column.ProtobufAppendVarint(bf, decodeUNumber(v))
+	}
+}
+
 func decodeUNumber(b []byte) uint64 {
 	var o uint64
 	l := len(b)
@@ -188,3 +457,27 @@ func decodeIP(b []byte) netip.Addr {
 	}
 	return netip.Addr{}
 }
+
+func decodeIPv4(ip uint32) netip.Addr {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], ip)
+	return netip.AddrFrom16(netip.AddrFrom4(b).As16())
+}
+
+// decodeBasicListLength returns the number of elements in an IPFIX basicList
+// (RFC 6313). It is made of a semantic, an element identifier (with an
+// optional enterprise number), the element length and the elements.
+func decodeBasicListLength(b []byte) (uint64, bool) {
+	if len(b) < 5 {
+		return 0, false
+	}
+	header := 5
+	if b[1]&0x80 != 0 {
+		header += 4
+	}
+	length := int(binary.BigEndian.Uint16(b[3:5]))
+	if length == 0 || length == variableLength || len(b) < header {
+		return 0, false
+	}
+	return uint64((len(b) - header) / length), true
+}
@@ -0,0 +1,170 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/netsampler/goflow2/decoders/netflow"
+
+	"akvorado/inlet/flow/decoder"
+)
+
+// templateUsage contains statistics about the use of a template.
+type templateUsage struct {
+	records  uint64
+	lastSeen time.Time
+	lastUsed time.Time
+}
+
+// templateSeen records the reception of a template.
+func (s *templateSystem) templateSeen(version uint16, obsDomainID uint32, templateID uint16, now time.Time) {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+	usage := s.usageFor(templateKey{version, obsDomainID, templateID})
+	usage.lastSeen = now
+}
+
+// templateUsed records the reception of records using a template.
+func (s *templateSystem) templateUsed(version uint16, obsDomainID uint32, templateID uint16, records int, now time.Time) {
+	s.usageLock.Lock()
+	defer s.usageLock.Unlock()
+	usage := s.usageFor(templateKey{version, obsDomainID, templateID})
+	usage.records += uint64(records)
+	usage.lastUsed = now
+}
+
+// usageFor returns the usage statistics for the provided template. The lock
+// should be held.
+func (s *templateSystem) usageFor(key templateKey) *templateUsage {
+	if s.usage == nil {
+		s.usage = map[templateKey]*templateUsage{}
+	}
+	usage, ok := s.usage[key]
+	if !ok {
+		usage = &templateUsage{}
+		s.usage[key] = usage
+	}
+	return usage
+}
+
+// Templates returns the templates known for each exporter, with statistics
+// about their use.
+func (nd *Decoder) Templates() []decoder.Template {
+	result := []decoder.Template{}
+	nd.systemsLock.RLock()
+	defer nd.systemsLock.RUnlock()
+	for key, templates := range nd.templates {
+		templates.usageLock.Lock()
+		for version, obsDomains := range templates.templates.GetTemplates() {
+			for obsDomainID, records := range obsDomains {
+				for templateID, template := range records {
+					t := decoder.Template{
+						Exporter:            key,
+						Version:             version,
+						ObservationDomainID: obsDomainID,
+						TemplateID:          templateID,
+					}
+					switch record := template.(type) {
+					case netflow.TemplateRecord:
+						t.Type = "template"
+						t.Fields = templateFields(version, record.Fields)
+					case netflow.IPFIXOptionsTemplateRecord:
+						t.Type = "options-template"
+						t.Scopes = templateFields(version, record.Scopes)
+						t.Fields = templateFields(version, record.Options)
+					case netflow.NFv9OptionsTemplateRecord:
+						t.Type = "options-template"
+						t.Scopes = nfv9ScopeFields(record.Scopes)
+						t.Fields = templateFields(version, record.Options)
+					}
+					if usage, ok := templates.usage[templateKey{version, obsDomainID, templateID}]; ok {
+						t.Records = usage.records
+						if !usage.lastSeen.IsZero() {
+							lastSeen := usage.lastSeen
+							t.LastSeen = &lastSeen
+						}
+						if !usage.lastUsed.IsZero() {
+							lastUsed := usage.lastUsed
+							t.LastUsed = &lastUsed
+						}
+					}
+					result = append(result, t)
+				}
+			}
+		}
+		templates.usageLock.Unlock()
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := result[i], result[j]
+		if a.Exporter != b.Exporter {
+			return a.Exporter < b.Exporter
+		}
+		if a.Version != b.Version {
+			return a.Version < b.Version
+		}
+		if a.ObservationDomainID != b.ObservationDomainID {
+			return a.ObservationDomainID < b.ObservationDomainID
+		}
+		return a.TemplateID < b.TemplateID
+	})
+	return result
+}
+
+// templateFields describes the fields of a template.
+func templateFields(version uint16, fields []netflow.Field) []decoder.TemplateField {
+	result := make([]decoder.TemplateField, 0, len(fields))
+	for _, field := range fields {
+		f := decoder.TemplateField{
+			ID:     field.Type,
+			Length: field.Length,
+		}
+		if field.PenProvided {
+			f.Enterprise = field.Pen
+		}
+		f.Name = fieldName(version, f.Enterprise, f.ID)
+		result = append(result, f)
+	}
+	return result
+}
+
+// nfv9ScopeFields describes the scope fields of a NetFlow v9 options
+// template. Unlike IPFIX, they use their own types.
+func nfv9ScopeFields(fields []netflow.Field) []decoder.TemplateField {
+	result := make([]decoder.TemplateField, 0, len(fields))
+	for _, field := range fields {
+		f := decoder.TemplateField{
+			ID:     field.Type,
+			Length: field.Length,
+		}
+		if name := netflow.NFv9ScopeToString(field.Type); name != "Unassigned" {
+			f.Name = name
+		}
+		result = append(result, f)
+	}
+	return result
+}
+
+// fieldName returns the name of a field, or an empty string if it is unknown.
+// Reverse information elements from RFC 5103 use the name of the forward
+// information element with a "reverse" prefix.
+func fieldName(version uint16, enterprise uint32, id uint16) string {
+	var name string
+	switch {
+	case version == 9:
+		name = netflow.NFv9TypeToString(id)
+	case enterprise == 0, enterprise == reversePEN:
+		name = netflow.IPFIXTypeToString(id)
+	}
+	switch name {
+	case "", "Unassigned", "Reserved", "Assigned for NetFlow v9 compatibility":
+		return ""
+	}
+	if enterprise == reversePEN {
+		name = "reverse" + strings.ToUpper(name[:1]) + name[1:]
+	}
+	return name
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/netsampler/goflow2/decoders/netflow"
+)
+
+// persistVersion should be increased each time we change the way we encode
+// the persisted state.
+const persistVersion = 1
+
+// errPersistVersion is returned when the persisted state uses another version.
+var errPersistVersion = errors.New("persisted state version mismatch")
+
+type persistedState struct {
+	Version   int
+	Exporters map[string]persistedExporter
+}
+
+type persistedExporter struct {
+	Templates     map[uint16]map[uint32]map[uint16]interface{}
+	SamplingRates []persistedSamplingRate
+}
+
+type persistedSamplingRate struct {
+	Version      uint16
+	ObsDomainID  uint32
+	SamplerID    uint64
+	SamplingRate uint32
+}
+
+func init() {
+	gob.Register(netflow.TemplateRecord{})
+	gob.Register(netflow.IPFIXOptionsTemplateRecord{})
+	gob.Register(netflow.NFv9OptionsTemplateRecord{})
+}
+
+// Save persists the templates and the sampling rates of all exporters to the
+// provided file.
+func (nd *Decoder) Save(file string) error {
+	state := persistedState{
+		Version:   persistVersion,
+		Exporters: map[string]persistedExporter{},
+	}
+	nd.systemsLock.RLock()
+	for key, templates := range nd.templates {
+		exporter := state.Exporters[key]
+		exporter.Templates = templates.templates.GetTemplates()
+		state.Exporters[key] = exporter
+	}
+	for key, sampling := range nd.sampling {
+		exporter := state.Exporters[key]
+		sampling.lock.RLock()
+		for k, rate := range sampling.rates {
+			exporter.SamplingRates = append(exporter.SamplingRates, persistedSamplingRate{
+				Version:      k.version,
+				ObsDomainID:  k.obsDomainID,
+				SamplerID:    k.samplerID,
+				SamplingRate: rate,
+			})
+		}
+		sampling.lock.RUnlock()
+		state.Exporters[key] = exporter
+	}
+	nd.systemsLock.RUnlock()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(file), fmt.Sprintf("%s-*", filepath.Base(file)))
+	if err != nil {
+		return fmt.Errorf("unable to create templates file %q: %w", file, err)
+	}
+	defer func() {
+		tmpFile.Close()           // ignore errors
+		os.Remove(tmpFile.Name()) // ignore errors
+	}()
+	if err := gob.NewEncoder(tmpFile).Encode(&state); err != nil {
+		return fmt.Errorf("unable to encode templates: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), file); err != nil {
+		return fmt.Errorf("unable to write templates file %q: %w", file, err)
+	}
+	return nil
+}
+
+// Load restores the templates and the sampling rates of all exporters from
+// the provided file.
+func (nd *Decoder) Load(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return fmt.Errorf("unable to load templates %q: %w", file, err)
+	}
+	defer f.Close()
+	var state persistedState
+	if err := gob.NewDecoder(f).Decode(&state); err != nil {
+		return fmt.Errorf("unable to decode templates: %w", err)
+	}
+	if state.Version != persistVersion {
+		return errPersistVersion
+	}
+
+	nd.systemsLock.Lock()
+	defer nd.systemsLock.Unlock()
+	for key, exporter := range state.Exporters {
+		templates := &templateSystem{
+			nd:        nd,
+			templates: netflow.CreateTemplateSystem(),
+			key:       key,
+		}
+		for version, obsDomains := range exporter.Templates {
+			for obsDomainID, records := range obsDomains {
+				for _, template := range records {
+					templates.templates.AddTemplate(version, obsDomainID, template)
+				}
+			}
+		}
+		nd.templates[key] = templates
+		sampling := newSamplingRateSystem()
+		for _, rate := range exporter.SamplingRates {
+			sampling.rates[samplingRateKey{rate.Version, rate.ObsDomainID, rate.SamplerID}] = rate.SamplingRate
+		}
+		nd.sampling[key] = sampling
+	}
+	return nil
+}
@@ -1,32 +1,40 @@
 // SPDX-FileCopyrightText: 2022 Free Mobile
 // SPDX-License-Identifier: AGPL-3.0-only
 
-// Package netflow handles NetFlow v9 and IPFIX decoding.
+// Package netflow handles NetFlow v5, NetFlow v9 and IPFIX decoding.
 package netflow
 
 import (
 	"bytes"
+	"encoding/binary"
 	"net/netip"
 	"strconv"
 	"sync"
 
 	"github.com/netsampler/goflow2/decoders/netflow"
-	"github.com/netsampler/goflow2/producer"
+	"github.com/netsampler/goflow2/decoders/netflowlegacy"
 
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
 )
 
-// Decoder contains the state for the Netflow v9 decoder.
+// Decoder contains the state for the Netflow v5, v9 and IPFIX decoder.
 type Decoder struct {
-	r *reporter.Reporter
-	d decoder.Dependencies
+	r      *reporter.Reporter
+	d      decoder.Dependencies
+	config decoder.Configuration
 
 	// Templates and sampling systems
 	systemsLock sync.RWMutex
 	templates   map[string]*templateSystem
-	sampling    map[string]producer.SamplingRateSystem
+	sampling    map[string]*samplingRateSystem
+
+	// Enterprise-specific information elements to custom dimensions
+	informationElements map[decoder.InformationElement]*schema.Column
+
+	// Templates shared with other inlets (may be nil)
+	shared *sharedTemplates
 
 	metrics struct {
 		errors             *reporter.CounterVec
@@ -34,16 +42,35 @@ type Decoder struct {
 		setRecordsStatsSum *reporter.CounterVec
 		setStatsSum        *reporter.CounterVec
 		templatesStats     *reporter.CounterVec
+		clockSkew          *reporter.GaugeVec
+		templateMisses     *reporter.CounterVec
+		sharedTemplates    *reporter.CounterVec
 	}
 }
 
+func init() {
+	decoder.Register(decoder.Registration{
+		Name:       "netflow",
+		APIVersion: decoder.APIVersion,
+		New:        New,
+	})
+}
+
 // New instantiates a new netflow decoder.
-func New(r *reporter.Reporter, dependencies decoder.Dependencies) decoder.Decoder {
+func New(r *reporter.Reporter, configuration decoder.Configuration, dependencies decoder.Dependencies) decoder.Decoder {
 	nd := &Decoder{
 		r:         r,
 		d:         dependencies,
+		config:    configuration,
 		templates: map[string]*templateSystem{},
-		sampling:  map[string]producer.SamplingRateSystem{},
+		sampling:  map[string]*samplingRateSystem{},
+
+		informationElements: map[decoder.InformationElement]*schema.Column{},
+	}
+	for ie, iec := range configuration.InformationElements {
+		if column, ok := dependencies.Schema.LookupColumnByName(iec.Column); ok && !column.Disabled {
+			nd.informationElements[ie] = column
+		}
 	}
 
 	nd.metrics.errors = nd.r.CounterVec(
@@ -81,17 +108,60 @@ func New(r *reporter.Reporter, dependencies decoder.Dependencies) decoder.Decode
 		},
 		[]string{"exporter", "version", "obs_domain_id", "template_id", "type"},
 	)
+	nd.metrics.clockSkew = nd.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "clock_skew_seconds",
+			Help: "Difference between reception time and export time of the last packet.",
+		},
+		[]string{"exporter"},
+	)
+	nd.metrics.templateMisses = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "template_misses_count",
+			Help: "Netflows data sets received before their template.",
+		},
+		[]string{"exporter", "version", "obs_domain_id", "template_id"},
+	)
+	nd.metrics.sharedTemplates = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "shared_templates_count",
+			Help: "Netflows templates stored or looked up in the shared template store.",
+		},
+		[]string{"exporter", "result"},
+	)
+	if configuration.SharedTemplates.Enabled {
+		nd.shared = newSharedTemplates(nd, configuration.SharedTemplates)
+	}
 
 	return nd
 }
 
+// variableLength is the length of a variable-length field in a template.
+const variableLength = 0xffff
+
 type templateSystem struct {
 	nd        *Decoder
 	key       string
 	templates *netflow.BasicTemplateSystem
+
+	// Data sets waiting for their template
+	pendingLock  sync.Mutex
+	pending      map[templateKey][]pendingSet
+	pendingCount int
+
+	// Statistics about the use of each template
+	usageLock sync.Mutex
+	usage     map[templateKey]*templateUsage
 }
 
 func (s *templateSystem) AddTemplate(version uint16, obsDomainID uint32, template interface{}) {
+	if record, ok := template.(netflow.TemplateRecord); ok && len(record.Fields) > 0 &&
+		netflow.GetTemplateSize(version, record.Fields) == 0 {
+		// Data sets using a template with only variable-length fields
+		// cannot be decoded as their end cannot be detected.
+		s.nd.metrics.errors.WithLabelValues(s.key, "template with only variable-length fields").Inc()
+		return
+	}
 	s.templates.AddTemplate(version, obsDomainID, template)
 
 	var (
@@ -117,10 +187,42 @@ func (s *templateSystem) AddTemplate(version uint16, obsDomainID uint32, templat
 		strconv.Itoa(int(templateID)),
 		typeStr,
 	).Inc()
+	if s.nd.shared != nil {
+		s.nd.shared.store(s.key, version, obsDomainID, templateID, template)
+	}
 }
 
 func (s *templateSystem) GetTemplate(version uint16, obsDomainID uint32, templateID uint16) (interface{}, error) {
-	return s.templates.GetTemplate(version, obsDomainID, templateID)
+	template, err := s.templates.GetTemplate(version, obsDomainID, templateID)
+	if err != nil && s.nd.shared != nil {
+		if shared, ok := s.nd.shared.lookup(s.key, version, obsDomainID, templateID); ok {
+			s.templates.AddTemplate(version, obsDomainID, shared)
+			return shared, nil
+		}
+	}
+	return template, err
+}
+
+// variableLengthTemplate returns the fields of the provided data template if
+// it contains at least one variable-length field. Otherwise, it returns nil.
+func (s *templateSystem) variableLengthTemplate(version uint16, obsDomainID uint32, templateID uint16) []netflow.Field {
+	if s == nil {
+		return nil
+	}
+	template, err := s.templates.GetTemplate(version, obsDomainID, templateID)
+	if err != nil {
+		return nil
+	}
+	record, ok := template.(netflow.TemplateRecord)
+	if !ok {
+		return nil
+	}
+	for _, field := range record.Fields {
+		if field.Length == variableLength {
+			return record.Fields
+		}
+	}
+	return nil
 }
 
 // Decode decodes a Netflow payload.
@@ -141,7 +243,7 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 		nd.systemsLock.Unlock()
 	}
 	if !sok {
-		sampling = producer.CreateSamplingSystem()
+		sampling = newSamplingRateSystem()
 		nd.systemsLock.Lock()
 		nd.sampling[key] = sampling
 		nd.systemsLock.Unlock()
@@ -149,36 +251,65 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 
 	ts := uint64(in.TimeReceived.UTC().Unix())
 	buf := bytes.NewBuffer(in.Payload)
-	msgDec, err := netflow.DecodeMessage(buf, templates)
-	if err != nil {
-		switch err.(type) {
-		case *netflow.ErrorTemplateNotFound:
-			nd.metrics.errors.WithLabelValues(key, "template not found").Inc()
-		default:
-			nd.metrics.errors.WithLabelValues(key, "error decoding").Inc()
+	var msgDec interface{}
+	var err error
+	if len(in.Payload) >= 2 && binary.BigEndian.Uint16(in.Payload[:2]) == 5 {
+		msgDec, err = netflowlegacy.DecodeMessage(buf)
+	} else {
+		msgDec, err = netflow.DecodeMessage(buf, templates)
+	}
+	if _, ok := err.(*netflow.ErrorTemplateNotFound); ok {
+		nd.metrics.errors.WithLabelValues(key, "template not found").Inc()
+		payload := nd.handleTemplateMiss(in.Payload, templates, ts)
+		if nd.config.TemplateMissPolicy == decoder.TemplateMissPolicyDrop {
+			return nil
+		}
+		if payload == nil {
+			return []*schema.FlowMessage{}
 		}
+		msgDec, err = netflow.DecodeMessage(bytes.NewBuffer(payload), templates)
+	}
+	if err != nil {
+		nd.metrics.errors.WithLabelValues(key, "error decoding").Inc()
 		return nil
 	}
 
 	var (
-		version  string
-		flowSets []interface{}
+		version       string
+		versionNumber uint16
+		flowSets      []interface{}
+		exportSecs    uint32
+		obsDomainID   uint32
 	)
 
 	// Update some stats
 	switch msgDecConv := msgDec.(type) {
 	case netflow.IPFIXPacket:
 		version = "10"
+		versionNumber = 10
 		flowSets = msgDecConv.FlowSets
+		exportSecs = msgDecConv.ExportTime
+		obsDomainID = msgDecConv.ObservationDomainId
 	case netflow.NFv9Packet:
 		version = "9"
+		versionNumber = 9
 		flowSets = msgDecConv.FlowSets
+		exportSecs = msgDecConv.UnixSeconds
+		obsDomainID = msgDecConv.SourceId
+	case netflowlegacy.PacketNetFlowV5:
+		version = "5"
+		exportSecs = msgDecConv.UnixSecs
+		nd.metrics.setRecordsStatsSum.WithLabelValues(key, version, "PDU").
+			Add(float64(len(msgDecConv.Records)))
 	default:
 		nd.metrics.stats.WithLabelValues(key, "unknown").
 			Inc()
 		return nil
 	}
 	nd.metrics.stats.WithLabelValues(key, version).Inc()
+	if exportSecs != 0 {
+		nd.metrics.clockSkew.WithLabelValues(key).Set(float64(int64(ts) - int64(exportSecs)))
+	}
 	for _, fs := range flowSets {
 		switch fsConv := fs.(type) {
 		case netflow.TemplateFlowSet:
@@ -186,33 +317,44 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 				Inc()
 			nd.metrics.setRecordsStatsSum.WithLabelValues(key, version, "TemplateFlowSet").
 				Add(float64(len(fsConv.Records)))
+			for _, record := range fsConv.Records {
+				templates.templateSeen(versionNumber, obsDomainID, record.TemplateId, in.TimeReceived)
+			}
 		case netflow.IPFIXOptionsTemplateFlowSet:
 			nd.metrics.setStatsSum.WithLabelValues(key, version, "OptionsTemplateFlowSet").
 				Inc()
 			nd.metrics.setRecordsStatsSum.WithLabelValues(key, version, "OptionsTemplateFlowSet").
 				Add(float64(len(fsConv.Records)))
+			for _, record := range fsConv.Records {
+				templates.templateSeen(versionNumber, obsDomainID, record.TemplateId, in.TimeReceived)
+			}
 		case netflow.NFv9OptionsTemplateFlowSet:
 			nd.metrics.setStatsSum.WithLabelValues(key, version, "OptionsTemplateFlowSet").
 				Inc()
 			nd.metrics.setRecordsStatsSum.WithLabelValues(key, version, "OptionsTemplateFlowSet").
 				Add(float64(len(fsConv.Records)))
+			for _, record := range fsConv.Records {
+				templates.templateSeen(versionNumber, obsDomainID, record.TemplateId, in.TimeReceived)
+			}
 		case netflow.OptionsDataFlowSet:
 			nd.metrics.setStatsSum.WithLabelValues(key, version, "OptionsDataFlowSet").
 				Inc()
 			nd.metrics.setRecordsStatsSum.WithLabelValues(key, version, "OptionsDataFlowSet").
 				Add(float64(len(fsConv.Records)))
+			templates.templateUsed(versionNumber, obsDomainID, fsConv.Id, len(fsConv.Records), in.TimeReceived)
 		case netflow.DataFlowSet:
 			nd.metrics.setStatsSum.WithLabelValues(key, version, "DataFlowSet").
 				Inc()
 			nd.metrics.setRecordsStatsSum.WithLabelValues(key, version, "DataFlowSet").
 				Add(float64(len(fsConv.Records)))
+			templates.templateUsed(versionNumber, obsDomainID, fsConv.Id, len(fsConv.Records), in.TimeReceived)
 		}
 	}
 
-	flowMessageSet := nd.decode(msgDec, sampling)
+	flowMessageSet := nd.decode(msgDec, sampling, templates, ts)
+	flowMessageSet = append(flowMessageSet, nd.decodePending(templates, sampling, ts)...)
 	exporterAddress, _ := netip.AddrFromSlice(in.Source.To16())
 	for _, fmsg := range flowMessageSet {
-		fmsg.TimeReceived = ts
 		fmsg.ExporterAddress = exporterAddress
 	}
 
@@ -4,20 +4,28 @@
 package netflow
 
 import (
+	"bytes"
+	"context"
+	"encoding/binary"
 	"net"
 	"net/netip"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"akvorado/common/helpers"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/netsampler/goflow2/decoders/netflow"
+	"github.com/netsampler/goflow2/decoders/netflowlegacy"
 )
 
 func TestDecode(t *testing.T) {
 	r := reporter.NewMock(t)
-	nfdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()})
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()})
 
 	// Send an option template
 	template := helpers.ReadPcapPayload(t, filepath.Join("testdata", "options-template-257.pcap"))
@@ -31,6 +39,8 @@ func TestDecode(t *testing.T) {
 
 	// Check metrics
 	gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_")
+	// Reception time is not set, clock skew is meaningless
+	delete(gotMetrics, `clock_skew_seconds{exporter="127.0.0.1"}`)
 	expectedMetrics := map[string]string{
 		`count{exporter="127.0.0.1",version="9"}`:                                                                       "1",
 		`flowset_records_sum{exporter="127.0.0.1",type="OptionsTemplateFlowSet",version="9"}`:                           "1",
@@ -53,6 +63,7 @@ func TestDecode(t *testing.T) {
 
 	// Check metrics
 	gotMetrics = r.GetMetrics("akvorado_inlet_flow_decoder_netflow_")
+	delete(gotMetrics, `clock_skew_seconds{exporter="127.0.0.1"}`)
 	expectedMetrics = map[string]string{
 		`count{exporter="127.0.0.1",version="9"}`:                                                                       "2",
 		`flowset_records_sum{exporter="127.0.0.1",type="OptionsTemplateFlowSet",version="9"}`:                           "1",
@@ -77,6 +88,7 @@ func TestDecode(t *testing.T) {
 
 	// Check metrics
 	gotMetrics = r.GetMetrics("akvorado_inlet_flow_decoder_netflow_")
+	delete(gotMetrics, `clock_skew_seconds{exporter="127.0.0.1"}`)
 	expectedMetrics = map[string]string{
 		`count{exporter="127.0.0.1",version="9"}`:                                                                       "3",
 		`flowset_records_sum{exporter="127.0.0.1",type="OptionsTemplateFlowSet",version="9"}`:                           "1",
@@ -105,8 +117,11 @@ func TestDecode(t *testing.T) {
 			SrcAddr:         netip.MustParseAddr("::ffff:198.38.121.178"),
 			DstAddr:         netip.MustParseAddr("::ffff:91.170.143.87"),
 			NextHop:         netip.MustParseAddr("::ffff:194.149.174.63"),
+			ExportDirection: schema.FlowExportDirectionIngress,
 			InIf:            335,
 			OutIf:           450,
+			SrcVRFID:        0x60000002,
+			DstVRFID:        0x60000002,
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:            1500,
 				schema.ColumnPackets:          1,
@@ -117,6 +132,7 @@ func TestDecode(t *testing.T) {
 				schema.ColumnSrcPort:          443,
 				schema.ColumnDstPort:          19624,
 				schema.ColumnForwardingStatus: 64,
+				schema.ColumnTCPFlags:         16,
 			},
 		}, {
 			SamplingRate:    30000,
@@ -125,7 +141,10 @@ func TestDecode(t *testing.T) {
 			DstAddr:         netip.MustParseAddr("::ffff:88.122.57.97"),
 			InIf:            335,
 			OutIf:           452,
+			SrcVRFID:        0x60000002,
+			DstVRFID:        0x60000002,
 			NextHop:         netip.MustParseAddr("::ffff:194.149.174.71"),
+			ExportDirection: schema.FlowExportDirectionIngress,
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:            1500,
 				schema.ColumnPackets:          1,
@@ -136,6 +155,7 @@ func TestDecode(t *testing.T) {
 				schema.ColumnSrcPort:          443,
 				schema.ColumnDstPort:          2444,
 				schema.ColumnForwardingStatus: 64,
+				schema.ColumnTCPFlags:         16,
 			},
 		}, {
 			SamplingRate:    30000,
@@ -144,7 +164,10 @@ func TestDecode(t *testing.T) {
 			DstAddr:         netip.MustParseAddr("::ffff:37.165.129.20"),
 			InIf:            461,
 			OutIf:           306,
+			SrcVRFID:        0x60000002,
+			DstVRFID:        0x60000000,
 			NextHop:         netip.MustParseAddr("::ffff:252.223.0.0"),
+			ExportDirection: schema.FlowExportDirectionIngress,
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:            1400,
 				schema.ColumnPackets:          1,
@@ -155,6 +178,7 @@ func TestDecode(t *testing.T) {
 				schema.ColumnSrcPort:          443,
 				schema.ColumnDstPort:          53697,
 				schema.ColumnForwardingStatus: 64,
+				schema.ColumnTCPFlags:         16,
 			},
 		}, {
 			SamplingRate:    30000,
@@ -162,8 +186,11 @@ func TestDecode(t *testing.T) {
 			SrcAddr:         netip.MustParseAddr("::ffff:74.125.100.234"),
 			DstAddr:         netip.MustParseAddr("::ffff:88.120.219.117"),
 			NextHop:         netip.MustParseAddr("::ffff:194.149.174.61"),
+			ExportDirection: schema.FlowExportDirectionIngress,
 			InIf:            461,
 			OutIf:           451,
+			SrcVRFID:        0x60000002,
+			DstVRFID:        0x60000002,
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:            1448,
 				schema.ColumnPackets:          1,
@@ -174,6 +201,7 @@ func TestDecode(t *testing.T) {
 				schema.ColumnSrcPort:          443,
 				schema.ColumnDstPort:          52300,
 				schema.ColumnForwardingStatus: 64,
+				schema.ColumnTCPFlags:         16,
 			},
 		},
 	}
@@ -207,3 +235,1502 @@ func TestDecode(t *testing.T) {
 		t.Fatalf("Metrics after data (-got, +want):\n%s", diff)
 	}
 }
+
+func TestPersistTemplates(t *testing.T) {
+	source := net.ParseIP("127.0.0.1")
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)})
+	for _, pcap := range []string{"options-template-257.pcap", "options-data-257.pcap", "template-260.pcap"} {
+		payload := helpers.ReadPcapPayload(t, filepath.Join("testdata", pcap))
+		nfdecoder.Decode(decoder.RawFlow{Payload: payload, Source: source})
+	}
+	data := helpers.ReadPcapPayload(t, filepath.Join("testdata", "data-260.pcap"))
+	expected := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: source})
+	if len(expected) == 0 {
+		t.Fatal("Decode() did not return any flow")
+	}
+
+	file := filepath.Join(t.TempDir(), "templates")
+	if err := nfdecoder.(decoder.Persistent).Save(file); err != nil {
+		t.Fatalf("Save() error:\n%+v", err)
+	}
+
+	// New decoder after a restart
+	r = reporter.NewMock(t)
+	nfdecoder = New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)})
+	if err := nfdecoder.(decoder.Persistent).Load(file); err != nil {
+		t.Fatalf("Load() error:\n%+v", err)
+	}
+	got := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: source})
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Decode() after Load() (-got, +want):\n%s", diff)
+	}
+
+	if err := nfdecoder.(decoder.Persistent).Load(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Fatal("Load() on missing file did not error")
+	}
+}
+
+func TestSharedTemplates(t *testing.T) {
+	server := helpers.CheckExternalService(t, "Redis", []string{"redis", "localhost"}, "6379")
+	client := redis.NewClient(&redis.Options{
+		Addr: server,
+		DB:   10,
+	})
+	defer client.Close()
+	if err := client.FlushDB(context.Background()).Err(); err != nil {
+		t.Fatalf("FlushDB() error:\n%+v", err)
+	}
+
+	source := net.ParseIP("127.0.0.1")
+	config := decoder.DefaultConfiguration()
+	config.SharedTemplates.Enabled = true
+	config.SharedTemplates.Server = server
+	config.SharedTemplates.DB = 10
+	r1 := reporter.NewMock(t)
+	nfdecoder1 := New(r1, config, decoder.Dependencies{Schema: schema.NewMock(t)})
+	r2 := reporter.NewMock(t)
+	nfdecoder2 := New(r2, config, decoder.Dependencies{Schema: schema.NewMock(t)})
+
+	// Template received by the first inlet, data by the second one
+	template := helpers.ReadPcapPayload(t, filepath.Join("testdata", "template-260.pcap"))
+	data := helpers.ReadPcapPayload(t, filepath.Join("testdata", "data-260.pcap"))
+	nfdecoder1.Decode(decoder.RawFlow{Payload: template, Source: source})
+	expected := nfdecoder1.Decode(decoder.RawFlow{Payload: data, Source: source})
+	if len(expected) == 0 {
+		t.Fatal("Decode() did not return any flow")
+	}
+	key := sharedTemplateKey("127.0.0.1", 9, 0, 260)
+	for i := 0; ; i++ {
+		if n, _ := client.Exists(context.Background(), key).Result(); n == 1 {
+			break
+		}
+		if i == 50 {
+			t.Fatalf("template %q not stored in Redis", key)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := nfdecoder2.Decode(decoder.RawFlow{Payload: data, Source: source})
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Decode() with shared template (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r2.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "shared_templates_count")
+	expectedMetrics := map[string]string{
+		`shared_templates_count{exporter="127.0.0.1",result="found"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeExportDirection(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)}).(*Decoder)
+
+	// Same flow observed by the same exporter on ingress and on egress
+	record := func(direction byte) netflow.DataRecord {
+		return netflow.DataRecord{
+			Values: []netflow.DataField{
+				{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x05, 0xdc}},
+				{Type: netflow.NFV9_FIELD_DIRECTION, Value: []byte{direction}},
+			},
+		}
+	}
+	packet := netflow.NFv9Packet{
+		Version: 9,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{record(0), record(1), {
+					Values: []netflow.DataField{
+						{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x05, 0xdc}},
+					},
+				}},
+			},
+		},
+	}
+	got := nfdecoder.decode(packet, nil, nil, 0)
+	expected := []*schema.FlowMessage{
+		{
+			ExportDirection: schema.FlowExportDirectionIngress,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes: 1500,
+			},
+		}, {
+			ExportDirection: schema.FlowExportDirectionEgress,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes: 1500,
+			},
+		}, {
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes: 1500,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeMPLS(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+
+	packet := netflow.NFv9Packet{
+		Version: 9,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{{
+					Values: []netflow.DataField{
+						{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x05, 0xdc}},
+						// Label 16004, traffic class 2
+						{Type: netflow.NFV9_FIELD_MPLS_LABEL_1, Value: []byte{0x03, 0xe8, 0x44}},
+						// Label 24001, bottom of stack
+						{Type: netflow.NFV9_FIELD_MPLS_LABEL_2, Value: []byte{0x05, 0xdc, 0x11}},
+					},
+				}},
+			},
+		},
+	}
+	got := nfdecoder.decode(packet, nil, nil, 0)
+	expected := []*schema.FlowMessage{
+		{
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:      1500,
+				schema.ColumnMPLSLabel1: 16004,
+				schema.ColumnMPLSLabel2: 24001,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeIPv6FlowLabel(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+
+	packet := netflow.NFv9Packet{
+		Version: 9,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{{
+					Values: []netflow.DataField{
+						{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x05, 0xdc}},
+						{Type: netflow.NFV9_FIELD_IPV6_SRC_ADDR, Value: []byte{0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}},
+						{Type: netflow.NFV9_FIELD_SRC_TOS, Value: []byte{0xb8}},
+						{Type: netflow.NFV9_FIELD_IPV6_FLOW_LABEL, Value: []byte{0x00, 0x01, 0x23, 0x45}},
+					},
+				}},
+			},
+		},
+	}
+	got := nfdecoder.decode(packet, nil, nil, 0)
+	expected := []*schema.FlowMessage{
+		{
+			SrcAddr: netip.MustParseAddr("2001:db8::1"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:         1500,
+				schema.ColumnEType:         helpers.ETypeIPv6,
+				schema.ColumnIPTos:         0xb8,
+				schema.ColumnIPv6FlowLabel: 0x12345,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeNSEL(t *testing.T) {
+	packet := netflow.NFv9Packet{
+		Version: 9,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{{
+					Values: []netflow.DataField{
+						{Type: netflow.NFV9_FIELD_IPV4_SRC_ADDR, Value: []byte{10, 0, 0, 1}},
+						{Type: netflow.NFV9_FIELD_IPV4_DST_ADDR, Value: []byte{198, 51, 100, 1}},
+						{Type: netflow.NFV9_FIELD_L4_SRC_PORT, Value: []byte{0xc3, 0x50}},
+						{Type: netflow.NFV9_FIELD_L4_DST_PORT, Value: []byte{0x01, 0xbb}},
+						{Type: netflow.IPFIX_FIELD_postNATSourceIPv4Address, Value: []byte{192, 0, 2, 1}},
+						{Type: netflow.IPFIX_FIELD_postNATDestinationIPv4Address, Value: []byte{198, 51, 100, 1}},
+						{Type: netflow.IPFIX_FIELD_postNAPTSourceTransportPort, Value: []byte{0x9c, 0x40}},
+						{Type: netflow.IPFIX_FIELD_postNAPTDestinationTransportPort, Value: []byte{0x01, 0xbb}},
+						{Type: netflow.IPFIX_FIELD_firewallEvent, Value: []byte{2}},
+						{Type: netflow.IPFIX_FIELD_initiatorOctets, Value: []byte{0x00, 0x00, 0x05, 0xdc}},
+						{Type: netflow.IPFIX_FIELD_responderOctets, Value: []byte{0x00, 0x00, 0x0b, 0xb8}},
+						{Type: netflow.IPFIX_FIELD_initiatorPackets, Value: []byte{0x00, 0x00, 0x00, 0x0a}},
+					},
+				}},
+			},
+		},
+	}
+	base := schema.FlowMessage{
+		SrcAddr: netip.MustParseAddr("::ffff:10.0.0.1"),
+		DstAddr: netip.MustParseAddr("::ffff:198.51.100.1"),
+	}
+	baseDebug := map[schema.ColumnKey]interface{}{
+		schema.ColumnEType:      helpers.ETypeIPv4,
+		schema.ColumnSrcPort:    50000,
+		schema.ColumnDstPort:    443,
+		schema.ColumnSrcAddrNAT: netip.MustParseAddr("::ffff:192.0.2.1"),
+		schema.ColumnDstAddrNAT: netip.MustParseAddr("::ffff:198.51.100.1"),
+		schema.ColumnSrcPortNAT: 40000,
+		schema.ColumnDstPortNAT: 443,
+	}
+
+	cases := []struct {
+		Description string
+		NSEL        bool
+		Expected    map[schema.ColumnKey]interface{}
+	}{
+		{
+			Description: "without NSEL",
+			NSEL:        false,
+			Expected:    map[schema.ColumnKey]interface{}{},
+		}, {
+			Description: "with NSEL",
+			NSEL:        true,
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:         1500,
+				schema.ColumnPackets:       10,
+				schema.ColumnFirewallEvent: 2,
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			config := decoder.DefaultConfiguration()
+			config.NSEL = tc.NSEL
+			nfdecoder := New(r, config, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+			got := nfdecoder.decode(packet, nil, nil, 0)
+			expected := base
+			expected.ProtobufDebug = map[schema.ColumnKey]interface{}{}
+			for k, v := range baseDebug {
+				expected.ProtobufDebug[k] = v
+			}
+			for k, v := range tc.Expected {
+				expected.ProtobufDebug[k] = v
+			}
+			if diff := helpers.Diff(got, []*schema.FlowMessage{&expected}); diff != "" {
+				t.Fatalf("decode() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeICMP(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+
+	record := func(values ...netflow.DataField) netflow.DataRecord {
+		return netflow.DataRecord{
+			Values: append([]netflow.DataField{
+				{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x00, 0x54}},
+			}, values...),
+		}
+	}
+	packet := netflow.NFv9Packet{
+		Version: 10,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{
+					record(
+						// Destination unreachable, port unreachable
+						netflow.DataField{Type: netflow.NFV9_FIELD_PROTOCOL, Value: []byte{1}},
+						netflow.DataField{Type: netflow.IPFIX_FIELD_icmpTypeCodeIPv4, Value: []byte{0x03, 0x03}},
+					),
+					record(
+						// Echo request
+						netflow.DataField{Type: netflow.NFV9_FIELD_PROTOCOL, Value: []byte{58}},
+						netflow.DataField{Type: netflow.IPFIX_FIELD_icmpTypeIPv6, Value: []byte{128}},
+						netflow.DataField{Type: netflow.IPFIX_FIELD_icmpCodeIPv6, Value: []byte{0}},
+					),
+				},
+			},
+		},
+	}
+	got := nfdecoder.decode(packet, nil, nil, 0)
+	expected := []*schema.FlowMessage{
+		{
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:    84,
+				schema.ColumnProto:    1,
+				schema.ColumnICMPType: 3,
+				schema.ColumnICMPCode: 3,
+			},
+		}, {
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:    84,
+				schema.ColumnProto:    58,
+				schema.ColumnICMPType: 128,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeVlan(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+
+	record := func(values ...netflow.DataField) netflow.DataRecord {
+		return netflow.DataRecord{
+			Values: append([]netflow.DataField{
+				{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x05, 0xdc}},
+			}, values...),
+		}
+	}
+	packet := netflow.NFv9Packet{
+		Version: 10,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{
+					record(
+						netflow.DataField{Type: netflow.NFV9_FIELD_SRC_VLAN, Value: []byte{0x00, 0x64}},
+						netflow.DataField{Type: netflow.NFV9_FIELD_DST_VLAN, Value: []byte{0x00, 0xc8}},
+					),
+					record(
+						netflow.DataField{Type: netflow.IPFIX_FIELD_dot1qVlanId, Value: []byte{0x01, 0x2c}},
+						netflow.DataField{Type: netflow.IPFIX_FIELD_postDot1qVlanId, Value: []byte{0x01, 0x90}},
+					),
+				},
+			},
+		},
+	}
+	got := nfdecoder.decode(packet, nil, nil, 0)
+	expected := []*schema.FlowMessage{
+		{
+			SrcVlan: 100,
+			DstVlan: 200,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes: 1500,
+			},
+		}, {
+			SrcVlan: 300,
+			DstVlan: 400,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes: 1500,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeMAC(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+
+	record := func(values ...netflow.DataField) netflow.DataRecord {
+		return netflow.DataRecord{
+			Values: append([]netflow.DataField{
+				{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x05, 0xdc}},
+			}, values...),
+		}
+	}
+	packet := netflow.NFv9Packet{
+		Version: 10,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{
+					record(
+						netflow.DataField{Type: netflow.NFV9_FIELD_IN_SRC_MAC, Value: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x66}},
+						netflow.DataField{Type: netflow.NFV9_FIELD_IN_DST_MAC, Value: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55}},
+					),
+					record(
+						netflow.DataField{Type: netflow.NFV9_FIELD_OUT_SRC_MAC, Value: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x77}},
+						netflow.DataField{Type: netflow.NFV9_FIELD_OUT_DST_MAC, Value: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x88}},
+					),
+				},
+			},
+		},
+	}
+	got := nfdecoder.decode(packet, nil, nil, 0)
+	expected := []*schema.FlowMessage{
+		{
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:  1500,
+				schema.ColumnSrcMAC: 0x1122334466,
+				schema.ColumnDstMAC: 0x1122334455,
+			},
+		}, {
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:  1500,
+				schema.ColumnSrcMAC: 0x1122334477,
+				schema.ColumnDstMAC: 0x1122334488,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeVRF(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+
+	packet := netflow.NFv9Packet{
+		Version: 10,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{
+					{
+						Values: []netflow.DataField{
+							{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x05, 0xdc}},
+							{Type: netflow.IPFIX_FIELD_ingressVRFID, Value: []byte{0x60, 0x00, 0x00, 0x02}},
+							{Type: netflow.IPFIX_FIELD_egressVRFID, Value: []byte{0x00, 0x00, 0x00, 0x00}},
+						},
+					},
+				},
+			},
+		},
+	}
+	got := nfdecoder.decode(packet, nil, nil, 0)
+	expected := []*schema.FlowMessage{
+		{
+			SrcVRFID: 0x60000002,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes: 1500,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeSRv6(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+
+	segment1 := netip.MustParseAddr("2001:db8:100::1").AsSlice()
+	segment2 := netip.MustParseAddr("2001:db8:200::1").AsSlice()
+	segment3 := netip.MustParseAddr("2001:db8:300::1").AsSlice()
+	basicList := append([]byte{0x03, 0x01, 0xee, 0x00, 0x10}, segment1...)
+	basicList = append(basicList, segment2...)
+	packet := netflow.NFv9Packet{
+		Version: 10,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{
+					{
+						Values: []netflow.DataField{
+							{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x05, 0xdc}},
+							{Type: ipfixFieldSRHActiveSegmentIPv6, Value: segment2},
+							{Type: ipfixFieldSRHSegmentsIPv6Left, Value: []byte{1}},
+							{Type: ipfixFieldSRHSegmentIPv6ListSection, Value: append(append(segment1, segment2...), segment3...)},
+						},
+					}, {
+						Values: []netflow.DataField{
+							{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x05, 0xdc}},
+							{Type: ipfixFieldSRHSegmentIPv6BasicList, Value: basicList},
+						},
+					},
+				},
+			},
+		},
+	}
+	got := nfdecoder.decode(packet, nil, nil, 0)
+	expected := []*schema.FlowMessage{
+		{
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:                 1500,
+				schema.ColumnSRv6ActiveSegment:     netip.MustParseAddr("2001:db8:200::1"),
+				schema.ColumnSRv6SegmentsLeft:      1,
+				schema.ColumnSRv6SegmentListLength: 3,
+			},
+		}, {
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:                 1500,
+				schema.ColumnSRv6SegmentListLength: 2,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeSamplerID(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)}).(*Decoder)
+	sampling := newSamplingRateSystem()
+
+	record := func(samplerID byte, bytes byte) netflow.DataRecord {
+		values := []netflow.DataField{
+			{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{bytes}},
+		}
+		if samplerID != 0 {
+			values = append(values, netflow.DataField{
+				Type:  netflow.NFV9_FIELD_FLOW_SAMPLER_ID,
+				Value: []byte{samplerID},
+			})
+		}
+		return netflow.DataRecord{Values: values}
+	}
+	options := netflow.NFv9Packet{
+		Version: 9,
+		FlowSets: []interface{}{
+			netflow.OptionsDataFlowSet{
+				Records: []netflow.OptionsDataRecord{
+					{
+						OptionsValues: []netflow.DataField{
+							{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_ID, Value: []byte{1}},
+							{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_RANDOM_INTERVAL, Value: []byte{0, 100}},
+						},
+					}, {
+						OptionsValues: []netflow.DataField{
+							{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_ID, Value: []byte{2}},
+							{Type: netflow.NFV9_FIELD_FLOW_SAMPLER_RANDOM_INTERVAL, Value: []byte{0x3, 0xe8}},
+						},
+					},
+				},
+			},
+		},
+	}
+	if got := nfdecoder.decode(options, sampling, nil, 0); len(got) != 0 {
+		t.Fatalf("decode() on options data got flows")
+	}
+
+	// Data records in a separate packet
+	data := netflow.NFv9Packet{
+		Version: 9,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{record(1, 10), record(2, 20), record(3, 30), record(0, 40)},
+			},
+		},
+	}
+	got := nfdecoder.decode(data, sampling, nil, 0)
+	expected := []*schema.FlowMessage{}
+	for _, expectedRecord := range []struct {
+		samplingRate uint32
+		bytes        int
+	}{
+		{100, 10},
+		{1000, 20},
+		{1000, 30}, // unknown sampler: last learnt rate
+		{1000, 40}, // no sampler: last learnt rate
+	} {
+		expected = append(expected, &schema.FlowMessage{
+			SamplingRate: expectedRecord.samplingRate,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes: expectedRecord.bytes,
+			},
+		})
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+
+	// Observation domain without any known sampler
+	data.SourceId = 10
+	got = nfdecoder.decode(data, sampling, nil, 0)
+	for _, flow := range got {
+		if flow.SamplingRate != 0 {
+			t.Fatalf("decode() for unknown observation domain got sampling rate %d", flow.SamplingRate)
+		}
+	}
+}
+
+func TestDecodeV5(t *testing.T) {
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()})
+
+	// Build a NetFlow v5 packet with two records, sampling 1 out of 1000
+	var payload bytes.Buffer
+	for _, field := range []interface{}{
+		uint16(5),          // version
+		uint16(2),          // count
+		uint32(8580),       // sysuptime
+		uint32(1680000000), // unix secs
+		uint32(0),          // unix nsecs
+		uint32(1000),       // flow sequence
+		uint8(0), uint8(0), // engine type and ID
+		uint16(1<<14 | 1000), // sampling mode and interval
+	} {
+		binary.Write(&payload, binary.BigEndian, field)
+	}
+	records := []netflowlegacy.RecordsNetFlowV5{
+		{
+			SrcAddr: 0xc0000201, DstAddr: 0xcb007101, NextHop: 0xc6336401,
+			Input: 10, Output: 20,
+			DPkts: 4, DOctets: 1500,
+			SrcPort: 443, DstPort: 51000, Proto: 6,
+			SrcAS: 64500, DstAS: 64501,
+			SrcMask: 24, DstMask: 23,
+		}, {
+			SrcAddr: 0xcb007101, DstAddr: 0xc0000201,
+			Input: 20, Output: 10,
+			DPkts: 1, DOctets: 60,
+			SrcPort: 51000, DstPort: 53, Proto: 17,
+		},
+	}
+	for _, record := range records {
+		binary.Write(&payload, binary.BigEndian, record)
+	}
+
+	got := nfdecoder.Decode(decoder.RawFlow{
+		TimeReceived: time.Unix(1680000002, 0),
+		Payload:      payload.Bytes(),
+		Source:       net.ParseIP("127.0.0.1"),
+	})
+	if got == nil {
+		t.Fatalf("Decode() error on NetFlow v5 data")
+	}
+	expected := []*schema.FlowMessage{
+		{
+			SamplingRate:    1000,
+			InIf:            10,
+			OutIf:           20,
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.1"),
+			NextHop:         netip.MustParseAddr("::ffff:198.51.100.1"),
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAS:           64500,
+			DstAS:           64501,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:      1500,
+				schema.ColumnPackets:    4,
+				schema.ColumnEType:      helpers.ETypeIPv4,
+				schema.ColumnProto:      6,
+				schema.ColumnSrcPort:    443,
+				schema.ColumnDstPort:    51000,
+				schema.ColumnSrcNetMask: 24,
+				schema.ColumnDstNetMask: 23,
+			},
+		}, {
+			SamplingRate:    1000,
+			InIf:            20,
+			OutIf:           10,
+			SrcAddr:         netip.MustParseAddr("::ffff:203.0.113.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			NextHop:         netip.MustParseAddr("::ffff:0.0.0.0"),
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   60,
+				schema.ColumnPackets: 1,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+				schema.ColumnProto:   17,
+				schema.ColumnSrcPort: 51000,
+				schema.ColumnDstPort: 53,
+			},
+		},
+	}
+	for _, f := range got {
+		f.TimeReceived = 0
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_")
+	expectedMetrics := map[string]string{
+		`clock_skew_seconds{exporter="127.0.0.1"}`:                         "2",
+		`count{exporter="127.0.0.1",version="5"}`:                          "1",
+		`flowset_records_sum{exporter="127.0.0.1",type="PDU",version="5"}`: "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+// ipfixMessage builds an IPFIX message with the provided sets.
+func ipfixMessage(sets ...[]byte) []byte {
+	var payload bytes.Buffer
+	length := 16
+	for _, set := range sets {
+		length += len(set)
+	}
+	for _, field := range []interface{}{
+		uint16(10), uint16(length), // version and length
+		uint32(1680000000), // export time
+		uint32(1),          // sequence number
+		uint32(0),          // observation domain
+	} {
+		binary.Write(&payload, binary.BigEndian, field)
+	}
+	for _, set := range sets {
+		payload.Write(set)
+	}
+	return payload.Bytes()
+}
+
+// ipfixSet builds an IPFIX set with the provided ID and content.
+func ipfixSet(id uint16, content ...interface{}) []byte {
+	var buf bytes.Buffer
+	for _, field := range content {
+		binary.Write(&buf, binary.BigEndian, field)
+	}
+	var set bytes.Buffer
+	binary.Write(&set, binary.BigEndian, id)
+	binary.Write(&set, binary.BigEndian, uint16(buf.Len()+4))
+	set.Write(buf.Bytes())
+	return set.Bytes()
+}
+
+func TestDecodeVariableLength(t *testing.T) {
+	template := ipfixSet(2,
+		uint16(256), uint16(4), // template ID and field count
+		uint16(netflow.IPFIX_FIELD_octetDeltaCount), uint16(4),
+		uint16(netflow.IPFIX_FIELD_sourceIPv4Address), uint16(4),
+		uint16(netflow.IPFIX_FIELD_interfaceName), uint16(0xffff),
+		uint16(0x8000|2), uint16(0xffff), uint32(25461), // enterprise-specific URL
+	)
+	longURL := bytes.Repeat([]byte("a"), 300)
+	data := ipfixSet(256,
+		// First record: short fields
+		uint32(1500), []byte{192, 0, 2, 1},
+		uint8(3), []byte("et0"),
+		uint8(4), []byte("/foo"),
+		// Second record: long URL
+		uint32(1000), []byte{192, 0, 2, 2},
+		uint8(0),
+		uint8(0xff), uint16(len(longURL)), longURL,
+	)
+	onlyVariable := ipfixSet(2,
+		uint16(257), uint16(1),
+		uint16(netflow.IPFIX_FIELD_interfaceName), uint16(0xffff),
+	)
+	expected := []*schema.FlowMessage{
+		{
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes: 1500,
+				schema.ColumnEType: helpers.ETypeIPv4,
+			},
+		}, {
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes: 1000,
+				schema.ColumnEType: helpers.ETypeIPv4,
+			},
+		},
+	}
+
+	cases := []struct {
+		Description     string
+		Configuration   func(decoder.Configuration) decoder.Configuration
+		ExpectedFlows   []*schema.FlowMessage
+		ExpectedMetrics map[string]string
+	}{
+		{
+			Description:   "default configuration",
+			Configuration: func(c decoder.Configuration) decoder.Configuration { return c },
+			ExpectedFlows: expected,
+			ExpectedMetrics: map[string]string{
+				`errors_count{error="template with only variable-length fields",exporter="127.0.0.1"}`: "1",
+			},
+		}, {
+			Description: "maximum size",
+			Configuration: func(c decoder.Configuration) decoder.Configuration {
+				c.VariableLengthMaxSize = 100
+				return c
+			},
+			ExpectedFlows: expected[:1],
+			ExpectedMetrics: map[string]string{
+				`errors_count{error="template with only variable-length fields",exporter="127.0.0.1"}`: "1",
+				`errors_count{error="variable-length field too large",exporter="127.0.0.1"}`:           "1",
+			},
+		}, {
+			Description: "maximum size with skipped field",
+			Configuration: func(c decoder.Configuration) decoder.Configuration {
+				c.VariableLengthMaxSize = 100
+				c.VariableLengthPolicies = map[decoder.InformationElement]decoder.VariableLengthPolicy{
+					{Enterprise: 25461, ID: 2}: decoder.VariableLengthPolicySkip,
+				}
+				return c
+			},
+			ExpectedFlows: expected,
+			ExpectedMetrics: map[string]string{
+				`errors_count{error="template with only variable-length fields",exporter="127.0.0.1"}`: "1",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			nfdecoder := New(r, tc.Configuration(decoder.DefaultConfiguration()),
+				decoder.Dependencies{Schema: schema.NewMock(t)})
+			nfdecoder.Decode(decoder.RawFlow{
+				Payload: ipfixMessage(template, onlyVariable),
+				Source:  net.ParseIP("127.0.0.1"),
+			})
+			got := nfdecoder.Decode(decoder.RawFlow{
+				Payload: ipfixMessage(data),
+				Source:  net.ParseIP("127.0.0.1"),
+			})
+			for _, f := range got {
+				f.TimeReceived = 0
+			}
+			if diff := helpers.Diff(got, tc.ExpectedFlows); diff != "" {
+				t.Fatalf("Decode() (-got, +want):\n%s", diff)
+			}
+			gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "errors_count")
+			if diff := helpers.Diff(gotMetrics, tc.ExpectedMetrics); diff != "" {
+				t.Fatalf("Metrics (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeStructuredData(t *testing.T) {
+	template := ipfixSet(2,
+		uint16(256), uint16(3), // template ID and field count
+		uint16(netflow.IPFIX_FIELD_octetDeltaCount), uint16(4),
+		uint16(netflow.IPFIX_FIELD_basicList), uint16(0xffff),
+		uint16(netflow.IPFIX_FIELD_subTemplateList), uint16(0xffff),
+		uint16(257), uint16(2), // sub-template
+		uint16(netflow.IPFIX_FIELD_sourceIPv4Address), uint16(4),
+		uint16(netflow.IPFIX_FIELD_sourceTransportPort), uint16(2),
+	)
+	data := ipfixSet(256,
+		// First record: lists with two elements
+		uint32(1500),
+		uint8(13), uint8(3), uint16(netflow.IPFIX_FIELD_egressInterface), uint16(4), uint32(10), uint32(20),
+		uint8(15), uint8(3), uint16(257), []byte{192, 0, 2, 1}, uint16(443), []byte{192, 0, 2, 2}, uint16(80),
+		// Second record: empty basic list, unknown sub-template
+		uint32(1000),
+		uint8(5), uint8(3), uint16(netflow.IPFIX_FIELD_egressInterface), uint16(4),
+		uint8(9), uint8(3), uint16(300), []byte{192, 0, 2, 3}, uint16(22),
+	)
+
+	cases := []struct {
+		Description     string
+		Policy          decoder.StructuredDataPolicy
+		ExpectedFlows   []*schema.FlowMessage
+		ExpectedMetrics map[string]string
+	}{
+		{
+			Description: "skip",
+			Policy:      decoder.StructuredDataPolicySkip,
+			ExpectedFlows: []*schema.FlowMessage{
+				{
+					ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+					ProtobufDebug: map[schema.ColumnKey]interface{}{
+						schema.ColumnBytes: 1500,
+					},
+				}, {
+					ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+					ProtobufDebug: map[schema.ColumnKey]interface{}{
+						schema.ColumnBytes: 1000,
+					},
+				},
+			},
+			ExpectedMetrics: map[string]string{},
+		}, {
+			Description: "first",
+			Policy:      decoder.StructuredDataPolicyFirst,
+			ExpectedFlows: []*schema.FlowMessage{
+				{
+					ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+					SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+					OutIf:           10,
+					ProtobufDebug: map[schema.ColumnKey]interface{}{
+						schema.ColumnBytes:   1500,
+						schema.ColumnSrcPort: 443,
+						schema.ColumnEType:   helpers.ETypeIPv4,
+					},
+				}, {
+					ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+					ProtobufDebug: map[schema.ColumnKey]interface{}{
+						schema.ColumnBytes: 1000,
+					},
+				},
+			},
+			ExpectedMetrics: map[string]string{
+				`errors_count{error="structured data template not found",exporter="127.0.0.1"}`: "1",
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			config := decoder.DefaultConfiguration()
+			config.StructuredDataPolicy = tc.Policy
+			nfdecoder := New(r, config, decoder.Dependencies{Schema: schema.NewMock(t)})
+			nfdecoder.Decode(decoder.RawFlow{
+				Payload: ipfixMessage(template),
+				Source:  net.ParseIP("127.0.0.1"),
+			})
+			got := nfdecoder.Decode(decoder.RawFlow{
+				Payload: ipfixMessage(data),
+				Source:  net.ParseIP("127.0.0.1"),
+			})
+			for _, f := range got {
+				f.TimeReceived = 0
+			}
+			if diff := helpers.Diff(got, tc.ExpectedFlows); diff != "" {
+				t.Fatalf("Decode() (-got, +want):\n%s", diff)
+			}
+			gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "errors_count")
+			if diff := helpers.Diff(gotMetrics, tc.ExpectedMetrics); diff != "" {
+				t.Fatalf("Metrics (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeBiflow(t *testing.T) {
+	template := ipfixSet(2,
+		uint16(256), uint16(9), // template ID and field count
+		uint16(netflow.IPFIX_FIELD_octetDeltaCount), uint16(4),
+		uint16(netflow.IPFIX_FIELD_packetDeltaCount), uint16(4),
+		uint16(netflow.IPFIX_FIELD_sourceIPv4Address), uint16(4),
+		uint16(netflow.IPFIX_FIELD_destinationIPv4Address), uint16(4),
+		uint16(netflow.IPFIX_FIELD_sourceTransportPort), uint16(2),
+		uint16(netflow.IPFIX_FIELD_destinationTransportPort), uint16(2),
+		uint16(netflow.IPFIX_FIELD_ingressInterface), uint16(4),
+		uint16(0x8000|netflow.IPFIX_FIELD_octetDeltaCount), uint16(4), uint32(29305),
+		uint16(0x8000|netflow.IPFIX_FIELD_packetDeltaCount), uint16(4), uint32(29305),
+	)
+	data := ipfixSet(256,
+		// First record: with reverse volume
+		uint32(1500), uint32(10), []byte{192, 0, 2, 1}, []byte{192, 0, 2, 2},
+		uint16(34567), uint16(443), uint32(10), uint32(30000), uint32(20),
+		// Second record: without reverse volume
+		uint32(1000), uint32(5), []byte{192, 0, 2, 3}, []byte{192, 0, 2, 4},
+		uint16(34568), uint16(53), uint32(10), uint32(0), uint32(0),
+	)
+	forward := []*schema.FlowMessage{
+		{
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+			InIf:            10,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   1500,
+				schema.ColumnPackets: 10,
+				schema.ColumnSrcPort: 34567,
+				schema.ColumnDstPort: 443,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+			},
+		}, {
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.3"),
+			DstAddr:         netip.MustParseAddr("::ffff:192.0.2.4"),
+			InIf:            10,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   1000,
+				schema.ColumnPackets: 5,
+				schema.ColumnSrcPort: 34568,
+				schema.ColumnDstPort: 53,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+			},
+		},
+	}
+	reverse := &schema.FlowMessage{
+		ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+		SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+		DstAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+		OutIf:           10,
+		ProtobufDebug: map[schema.ColumnKey]interface{}{
+			schema.ColumnBytes:   30000,
+			schema.ColumnPackets: 20,
+			schema.ColumnSrcPort: 443,
+			schema.ColumnDstPort: 34567,
+			schema.ColumnEType:   helpers.ETypeIPv4,
+		},
+	}
+
+	cases := []struct {
+		Description   string
+		Policy        decoder.BiflowPolicy
+		ExpectedFlows []*schema.FlowMessage
+	}{
+		{
+			Description:   "ignore",
+			Policy:        decoder.BiflowPolicyIgnore,
+			ExpectedFlows: forward,
+		}, {
+			Description:   "split",
+			Policy:        decoder.BiflowPolicySplit,
+			ExpectedFlows: []*schema.FlowMessage{forward[0], reverse, forward[1]},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			config := decoder.DefaultConfiguration()
+			config.BiflowPolicy = tc.Policy
+			nfdecoder := New(r, config, decoder.Dependencies{Schema: schema.NewMock(t)})
+			nfdecoder.Decode(decoder.RawFlow{
+				Payload: ipfixMessage(template),
+				Source:  net.ParseIP("127.0.0.1"),
+			})
+			got := nfdecoder.Decode(decoder.RawFlow{
+				Payload: ipfixMessage(data),
+				Source:  net.ParseIP("127.0.0.1"),
+			})
+			for _, f := range got {
+				f.TimeReceived = 0
+			}
+			if diff := helpers.Diff(got, tc.ExpectedFlows); diff != "" {
+				t.Fatalf("Decode() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	template := ipfixSet(2,
+		uint16(256), uint16(3), // template ID and field count
+		uint16(netflow.IPFIX_FIELD_octetDeltaCount), uint16(4),
+		uint16(netflow.IPFIX_FIELD_sourceIPv4Address), uint16(4),
+		uint16(0x8000|netflow.IPFIX_FIELD_octetDeltaCount), uint16(4), uint32(29305),
+	)
+	optionsTemplate := ipfixSet(3,
+		uint16(257), uint16(2), uint16(1), // template ID, field count and scope field count
+		uint16(netflow.IPFIX_FIELD_samplerId), uint16(4),
+		uint16(netflow.IPFIX_FIELD_samplingInterval), uint16(4),
+	)
+	data := ipfixSet(256,
+		uint32(1500), []byte{192, 0, 2, 1}, uint32(0),
+		uint32(1000), []byte{192, 0, 2, 2}, uint32(0),
+	)
+	templateReceived := time.Unix(1680000000, 0)
+	dataReceived := time.Unix(1680000010, 0)
+
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)})
+	nfdecoder.Decode(decoder.RawFlow{
+		Payload:      ipfixMessage(template, optionsTemplate),
+		Source:       net.ParseIP("127.0.0.1"),
+		TimeReceived: templateReceived,
+	})
+	nfdecoder.Decode(decoder.RawFlow{
+		Payload:      ipfixMessage(data),
+		Source:       net.ParseIP("127.0.0.1"),
+		TimeReceived: dataReceived,
+	})
+
+	got := nfdecoder.(decoder.TemplateInspector).Templates()
+	expected := []decoder.Template{
+		{
+			Exporter:   "127.0.0.1",
+			Version:    10,
+			TemplateID: 256,
+			Type:       "template",
+			Fields: []decoder.TemplateField{
+				{ID: netflow.IPFIX_FIELD_octetDeltaCount, Name: "octetDeltaCount", Length: 4},
+				{ID: netflow.IPFIX_FIELD_sourceIPv4Address, Name: "sourceIPv4Address", Length: 4},
+				{Enterprise: 29305, ID: netflow.IPFIX_FIELD_octetDeltaCount, Name: "reverseOctetDeltaCount", Length: 4},
+			},
+			Records:  2,
+			LastSeen: &templateReceived,
+			LastUsed: &dataReceived,
+		}, {
+			Exporter:   "127.0.0.1",
+			Version:    10,
+			TemplateID: 257,
+			Type:       "options-template",
+			Scopes: []decoder.TemplateField{
+				{ID: netflow.IPFIX_FIELD_samplerId, Name: "samplerId", Length: 4},
+			},
+			Fields: []decoder.TemplateField{
+				{ID: netflow.IPFIX_FIELD_samplingInterval, Name: "samplingInterval", Length: 4},
+			},
+			LastSeen: &templateReceived,
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Templates() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeEnterpriseFields(t *testing.T) {
+	sch, err := schema.New(schema.Configuration{
+		CustomDimensions: []schema.CustomDimension{
+			{Name: "AppID", Type: schema.CustomDimensionTypeString},
+			{Name: "UserID", Type: schema.CustomDimensionTypeUint},
+		},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	appID, _ := sch.LookupColumnByName("AppID")
+	userID, _ := sch.LookupColumnByName("UserID")
+
+	template := ipfixSet(2,
+		uint16(256), uint16(4), // template ID and field count
+		uint16(netflow.IPFIX_FIELD_octetDeltaCount), uint16(4),
+		uint16(0x8000|56), uint16(0xffff), uint32(25461), // app-id
+		uint16(0x8000|57), uint16(4), uint32(25461), // user-id
+		uint16(0x8000|58), uint16(4), uint32(25461), // not mapped
+	)
+	data := ipfixSet(256,
+		uint32(1500),
+		uint8(4), []byte("ssh\x00"),
+		uint32(1234),
+		uint32(5678),
+	)
+
+	r := reporter.NewMock(t)
+	config := decoder.DefaultConfiguration()
+	config.InformationElements = map[decoder.InformationElement]decoder.InformationElementConfiguration{
+		{Enterprise: 25461, ID: 56}: {Name: "app-id", Column: "AppID"},
+		{Enterprise: 25461, ID: 57}: {Name: "user-id", Column: "UserID"},
+	}
+	nfdecoder := New(r, config, decoder.Dependencies{Schema: sch})
+	nfdecoder.Decode(decoder.RawFlow{
+		Payload: ipfixMessage(template),
+		Source:  net.ParseIP("127.0.0.1"),
+	})
+	got := nfdecoder.Decode(decoder.RawFlow{
+		Payload: ipfixMessage(data),
+		Source:  net.ParseIP("127.0.0.1"),
+	})
+	if len(got) != 1 {
+		t.Fatalf("Decode() returned %d flows instead of 1", len(got))
+	}
+	// Custom dimensions have no name in ProtobufDebug, compare them one by one
+	expected := map[schema.ColumnKey]interface{}{
+		schema.ColumnBytes: 1500,
+		appID.Key:          []byte("ssh"),
+		userID.Key:         1234,
+	}
+	for key, value := range expected {
+		if diff := helpers.Diff(got[0].ProtobufDebug[key], value); diff != "" {
+			t.Errorf("Decode() column %d (-got, +want):\n%s", key, diff)
+		}
+	}
+	if len(got[0].ProtobufDebug) != len(expected) {
+		t.Errorf("Decode() returned %d columns instead of %d", len(got[0].ProtobufDebug), len(expected))
+	}
+}
+
+func TestDecodeTimestamps(t *testing.T) {
+	ipfix := netflow.IPFIXPacket{
+		Version:    10,
+		ExportTime: 1700000099,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{{
+					Values: []netflow.DataField{
+						{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x17, 0x70}},
+						{Type: netflow.NFV9_FIELD_IN_PKTS, Value: []byte{0x3c}},
+						{Type: netflow.IPFIX_FIELD_flowStartMilliseconds, Value: []byte{0x00, 0x00, 0x01, 0x8b, 0xcf, 0xe5, 0x8f, 0x10}}, // 1700000010000
+						{Type: netflow.IPFIX_FIELD_flowEndMilliseconds, Value: []byte{0x00, 0x00, 0x01, 0x8b, 0xcf, 0xe6, 0x7b, 0x64}},   // 1700000070500
+					},
+				}},
+			},
+		},
+	}
+	nfv9 := netflow.NFv9Packet{
+		Version:      9,
+		SystemUptime: 100000,
+		UnixSeconds:  1700000100,
+		FlowSets: []interface{}{
+			netflow.DataFlowSet{
+				Records: []netflow.DataRecord{{
+					Values: []netflow.DataField{
+						{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x17, 0x70}},
+						{Type: netflow.NFV9_FIELD_IN_PKTS, Value: []byte{0x3c}},
+						{Type: netflow.NFV9_FIELD_FIRST_SWITCHED, Value: []byte{0x00, 0x00, 0x9c, 0x40}}, // 40000
+						{Type: netflow.NFV9_FIELD_LAST_SWITCHED, Value: []byte{0x00, 0x01, 0x5f, 0x90}},  // 90000
+					},
+				}},
+			},
+		},
+	}
+	v5 := netflowlegacy.PacketNetFlowV5{
+		Version:   5,
+		SysUptime: 100000,
+		UnixSecs:  1700000100,
+		Records: []netflowlegacy.RecordsNetFlowV5{{
+			DOctets: 6000,
+			DPkts:   60,
+			First:   40000,
+			Last:    90000,
+		}},
+	}
+	// Same packets from exporters with a clock one hour ahead
+	ipfixSkewed := ipfix
+	ipfixSkewed.ExportTime = 1700003700
+	ipfixSkewed.FlowSets = []interface{}{
+		netflow.DataFlowSet{
+			Records: []netflow.DataRecord{{
+				Values: []netflow.DataField{
+					{Type: netflow.NFV9_FIELD_IN_BYTES, Value: []byte{0x17, 0x70}},
+					{Type: netflow.NFV9_FIELD_IN_PKTS, Value: []byte{0x3c}},
+					{Type: netflow.IPFIX_FIELD_flowStartSeconds, Value: []byte{0x65, 0x53, 0xff, 0x1a}}, // 1700003610
+				},
+			}},
+		},
+	}
+	nfv9Skewed := nfv9
+	nfv9Skewed.UnixSeconds += 3600
+	v5Skewed := v5
+	v5Skewed.UnixSecs += 3600
+	type slice struct {
+		TimeReceived uint64
+		Bytes        interface{}
+		Packets      interface{}
+	}
+	cases := []struct {
+		Description string
+		Packet      interface{}
+		Source      decoder.TimestampSource
+		MaxSkew     time.Duration
+		Spread      time.Duration
+		Correct     bool
+		Expected    []slice
+	}{
+		{
+			Description: "IPFIX, received",
+			Packet:      ipfix,
+			Source:      decoder.TimestampSourceReceived,
+			Expected:    []slice{{1700000100, 6000, 60}},
+		}, {
+			Description: "IPFIX, flow start",
+			Packet:      ipfix,
+			Source:      decoder.TimestampSourceFlowStart,
+			Expected:    []slice{{1700000010, 6000, 60}},
+		}, {
+			Description: "IPFIX, flow end",
+			Packet:      ipfix,
+			Source:      decoder.TimestampSourceFlowEnd,
+			Expected:    []slice{{1700000070, 6000, 60}},
+		}, {
+			Description: "IPFIX, flow start too old",
+			Packet:      ipfix,
+			Source:      decoder.TimestampSourceFlowStart,
+			MaxSkew:     30 * time.Second,
+			Expected:    []slice{{1700000100, 6000, 60}},
+		}, {
+			Description: "IPFIX, spread",
+			Packet:      ipfix,
+			Source:      decoder.TimestampSourceFlowStart,
+			Spread:      30 * time.Second,
+			Expected: []slice{
+				{1700000010, 2975, 29},
+				{1700000040, 2975, 30},
+				{1700000070, 50, 1},
+			},
+		}, {
+			Description: "NetFlow v9, flow start",
+			Packet:      nfv9,
+			Source:      decoder.TimestampSourceFlowStart,
+			Expected:    []slice{{1700000040, 6000, 60}},
+		}, {
+			Description: "NetFlow v9, spread",
+			Packet:      nfv9,
+			Source:      decoder.TimestampSourceFlowEnd,
+			Spread:      30 * time.Second,
+			Expected: []slice{
+				{1700000040, 3600, 36},
+				{1700000070, 2400, 24},
+			},
+		}, {
+			Description: "NetFlow v5, flow end",
+			Packet:      v5,
+			Source:      decoder.TimestampSourceFlowEnd,
+			Expected:    []slice{{1700000090, 6000, 60}},
+		}, {
+			Description: "NetFlow v5, spread",
+			Packet:      v5,
+			Source:      decoder.TimestampSourceFlowStart,
+			Spread:      30 * time.Second,
+			Expected: []slice{
+				{1700000040, 3600, 36},
+				{1700000070, 2400, 24},
+			},
+		}, {
+			Description: "IPFIX, skewed clock",
+			Packet:      ipfixSkewed,
+			Source:      decoder.TimestampSourceFlowStart,
+			MaxSkew:     30 * time.Minute,
+			Expected:    []slice{{1700000100, 6000, 60}},
+		}, {
+			Description: "IPFIX, skewed clock, corrected",
+			Packet:      ipfixSkewed,
+			Source:      decoder.TimestampSourceFlowStart,
+			MaxSkew:     30 * time.Minute,
+			Correct:     true,
+			Expected:    []slice{{1700000010, 6000, 60}},
+		}, {
+			Description: "IPFIX, small skew, not corrected",
+			Packet:      ipfix,
+			Source:      decoder.TimestampSourceFlowStart,
+			Correct:     true,
+			Expected:    []slice{{1700000010, 6000, 60}},
+		}, {
+			Description: "NetFlow v9, skewed clock, corrected",
+			Packet:      nfv9Skewed,
+			Source:      decoder.TimestampSourceFlowStart,
+			MaxSkew:     30 * time.Minute,
+			Correct:     true,
+			Expected:    []slice{{1700000040, 6000, 60}},
+		}, {
+			Description: "NetFlow v5, skewed clock, corrected",
+			Packet:      v5Skewed,
+			Source:      decoder.TimestampSourceFlowEnd,
+			MaxSkew:     30 * time.Minute,
+			Correct:     true,
+			Expected:    []slice{{1700000090, 6000, 60}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			config := decoder.DefaultConfiguration()
+			config.TimestampSource = tc.Source
+			if tc.MaxSkew != 0 {
+				config.TimestampMaxSkew = tc.MaxSkew
+			}
+			config.SpreadInterval = tc.Spread
+			config.ClockSkewCorrection = tc.Correct
+			nfdecoder := New(r, config, decoder.Dependencies{Schema: schema.NewMock(t)}).(*Decoder)
+			got := []slice{}
+			for _, flow := range nfdecoder.decode(tc.Packet, nil, nil, 1700000100) {
+				got = append(got, slice{
+					TimeReceived: flow.TimeReceived,
+					Bytes:        flow.ProtobufDebug[schema.ColumnBytes],
+					Packets:      flow.ProtobufDebug[schema.ColumnPackets],
+				})
+			}
+			if diff := helpers.Diff(got, tc.Expected); diff != "" {
+				t.Fatalf("decode() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+
+	t.Run("spread over too many slices", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		config := decoder.DefaultConfiguration()
+		config.TimestampSource = decoder.TimestampSourceFlowStart
+		config.SpreadInterval = time.Second
+		nfdecoder := New(r, config, decoder.Dependencies{Schema: schema.NewMock(t)}).(*Decoder)
+		got := nfdecoder.decode(ipfix, nil, nil, 1700000100)
+		if len(got) != maxSpreadSlices {
+			t.Fatalf("decode() returned %d flows, expected %d", len(got), maxSpreadSlices)
+		}
+		var bytes, packets uint64
+		for _, flow := range got {
+			counters := flow.Counters()
+			bytes += counters.Bytes
+			packets += counters.Packets
+		}
+		if bytes != 6000 || packets != 60 {
+			t.Fatalf("decode() returned %d bytes and %d packets, expected 6000 and 60", bytes, packets)
+		}
+	})
+}
+
+func TestTemplateMiss(t *testing.T) {
+	template := helpers.ReadPcapPayload(t, filepath.Join("testdata", "template-260.pcap"))
+	data := helpers.ReadPcapPayload(t, filepath.Join("testdata", "data-260.pcap"))
+	source := net.ParseIP("127.0.0.1")
+	received := time.Unix(1680000000, 0)
+
+	// Reference flows, with the template received first
+	r := reporter.NewMock(t)
+	nfdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)})
+	nfdecoder.Decode(decoder.RawFlow{Payload: template, Source: source, TimeReceived: received})
+	expected := nfdecoder.Decode(decoder.RawFlow{Payload: data, Source: source, TimeReceived: received})
+	if len(expected) == 0 {
+		t.Fatal("Decode() did not return any flow")
+	}
+
+	// Data packet with an additional data set using an unknown template
+	header, sets, ok := packetSets(data)
+	if !ok {
+		t.Fatal("packetSets() cannot parse data packet")
+	}
+	unknown := append([]byte{}, sets[len(sets)-1]...)
+	binary.BigEndian.PutUint16(unknown[:2], 999)
+	mixed := buildPacket(header, append(sets, unknown))
+
+	cases := []struct {
+		Description string
+		Policy      decoder.TemplateMissPolicy
+		Delay       time.Duration // before receiving the template
+		// Number of flows returned for each packet (-1 for an error)
+		ExpectedData     int
+		ExpectedTemplate int
+		ExpectedMixed    int
+	}{
+		{
+			Description:      "drop",
+			Policy:           decoder.TemplateMissPolicyDrop,
+			ExpectedData:     -1,
+			ExpectedTemplate: 0,
+			ExpectedMixed:    -1,
+		}, {
+			Description:      "partial",
+			Policy:           decoder.TemplateMissPolicyPartial,
+			ExpectedData:     0,
+			ExpectedTemplate: 0,
+			ExpectedMixed:    len(expected),
+		}, {
+			Description:      "buffer",
+			Policy:           decoder.TemplateMissPolicyBuffer,
+			ExpectedData:     0,
+			ExpectedTemplate: len(expected),
+			ExpectedMixed:    len(expected),
+		}, {
+			Description:      "buffer, template too late",
+			Policy:           decoder.TemplateMissPolicyBuffer,
+			Delay:            time.Minute,
+			ExpectedData:     0,
+			ExpectedTemplate: 0,
+			ExpectedMixed:    len(expected),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			config := decoder.DefaultConfiguration()
+			config.TemplateMissPolicy = tc.Policy
+			nfdecoder := New(r, config, decoder.Dependencies{Schema: schema.NewMock(t)})
+			decode := func(payload []byte, received time.Time) []*schema.FlowMessage {
+				return nfdecoder.Decode(decoder.RawFlow{
+					Payload:      payload,
+					Source:       source,
+					TimeReceived: received,
+				})
+			}
+			count := func(flows []*schema.FlowMessage) int {
+				if flows == nil {
+					return -1
+				}
+				return len(flows)
+			}
+
+			if got := decode(data, received); count(got) != tc.ExpectedData {
+				t.Errorf("Decode() on data returned %d flows, expected %d", count(got), tc.ExpectedData)
+			}
+			got := decode(template, received.Add(tc.Delay))
+			if count(got) != tc.ExpectedTemplate {
+				t.Errorf("Decode() on template returned %d flows, expected %d", count(got), tc.ExpectedTemplate)
+			}
+			for _, flow := range got {
+				if flow.TimeReceived != uint64(received.Unix()) {
+					t.Errorf("Decode() on template returned a flow received at %d", flow.TimeReceived)
+				}
+			}
+			if got := decode(mixed, received.Add(tc.Delay)); count(got) != tc.ExpectedMixed {
+				t.Errorf("Decode() on mixed data returned %d flows, expected %d", count(got), tc.ExpectedMixed)
+			}
+
+			gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_netflow_", "template_misses_count")
+			expectedMetrics := map[string]string{
+				`template_misses_count{exporter="127.0.0.1",obs_domain_id="0",template_id="260",version="9"}`: "1",
+				`template_misses_count{exporter="127.0.0.1",obs_domain_id="0",template_id="999",version="9"}`: "1",
+			}
+			if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+				t.Fatalf("Metrics (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"sync"
+
+	"github.com/netsampler/goflow2/decoders/netflow"
+)
+
+type samplingRateKey struct {
+	version     uint16
+	obsDomainID uint32
+	samplerID   uint64
+}
+
+// samplingRateSystem keeps the sampling rates learnt from options data
+// records for one exporter. Rates are indexed by sampler ID. The sampler ID 0
+// is used for rates without a sampler ID and for the last learnt rate of an
+// observation domain.
+type samplingRateSystem struct {
+	lock  sync.RWMutex
+	rates map[samplingRateKey]uint32
+}
+
+func newSamplingRateSystem() *samplingRateSystem {
+	return &samplingRateSystem{
+		rates: map[samplingRateKey]uint32{},
+	}
+}
+
+// SetSamplingRate records the sampling rate of a sampler.
+func (s *samplingRateSystem) SetSamplingRate(version uint16, obsDomainID uint32, samplerID uint64, samplingRate uint32) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.rates[samplingRateKey{version, obsDomainID, samplerID}] = samplingRate
+	if samplerID != 0 {
+		s.rates[samplingRateKey{version, obsDomainID, 0}] = samplingRate
+	}
+}
+
+// GetSamplingRate returns the sampling rate of a sampler. When the sampler is
+// unknown, the last learnt rate for the observation domain is returned. 0 is
+// returned if no rate is known.
+func (s *samplingRateSystem) GetSamplingRate(version uint16, obsDomainID uint32, samplerID uint64) uint32 {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	if rate, ok := s.rates[samplingRateKey{version, obsDomainID, samplerID}]; ok {
+		return rate
+	}
+	return s.rates[samplingRateKey{version, obsDomainID, 0}]
+}
+
+// findSamplerID returns the sampler ID among the provided fields, or 0 if
+// none. The field numbers are the same for NetFlow v9 and IPFIX.
+func findSamplerID(fields []netflow.DataField) uint64 {
+	for _, field := range fields {
+		if field.PenProvided {
+			continue
+		}
+		if field.Type == netflow.IPFIX_FIELD_samplerId || field.Type == netflow.IPFIX_FIELD_selectorId {
+			if v, ok := field.Value.([]byte); ok {
+				return decodeUNumber(v)
+			}
+		}
+	}
+	return 0
+}
+
+// findSamplingRate returns the sampling rate among the provided fields.
+func findSamplingRate(fields []netflow.DataField) (uint32, bool) {
+	for _, fieldType := range []uint16{
+		netflow.IPFIX_FIELD_samplingPacketInterval,
+		netflow.IPFIX_FIELD_samplerRandomInterval,
+		netflow.IPFIX_FIELD_samplingInterval,
+	} {
+		for _, field := range fields {
+			if field.PenProvided || field.Type != fieldType {
+				continue
+			}
+			if v, ok := field.Value.([]byte); ok {
+				return uint32(decodeUNumber(v)), true
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,121 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"akvorado/inlet/flow/decoder"
+)
+
+const (
+	// sharedTemplatesTimeout is the timeout for each request to Redis.
+	sharedTemplatesTimeout = 100 * time.Millisecond
+	// sharedTemplatesRetry is the delay before looking again for a template
+	// missing from Redis.
+	sharedTemplatesRetry = time.Second
+	// sharedTemplatesMaxMisses is the maximum number of missing templates
+	// remembered before forgetting all of them.
+	sharedTemplatesMaxMisses = 10000
+)
+
+// sharedTemplates stores templates in Redis to share them between inlets.
+// Templates are published when received and looked up when missing.
+type sharedTemplates struct {
+	nd     *Decoder
+	client *redis.Client
+	ttl    time.Duration
+
+	// Negative cache for templates missing from Redis
+	missesLock sync.Mutex
+	misses     map[string]time.Time
+}
+
+// sharedTemplate is the encoded value of a template in Redis.
+type sharedTemplate struct {
+	Template interface{}
+}
+
+func newSharedTemplates(nd *Decoder, config decoder.SharedTemplatesConfiguration) *sharedTemplates {
+	return &sharedTemplates{
+		nd: nd,
+		client: redis.NewClient(&redis.Options{
+			Network:  config.Protocol,
+			Addr:     config.Server,
+			Username: config.Username,
+			Password: config.Password,
+			DB:       config.DB,
+		}),
+		ttl:    config.TTL,
+		misses: map[string]time.Time{},
+	}
+}
+
+// sharedTemplateKey returns the Redis key for a template of an exporter.
+func sharedTemplateKey(exporter string, version uint16, obsDomainID uint32, templateID uint16) string {
+	return fmt.Sprintf("akvorado:netflow:templates:%s:%d:%d:%d", exporter, version, obsDomainID, templateID)
+}
+
+// store publishes a template in the background.
+func (st *sharedTemplates) store(exporter string, version uint16, obsDomainID uint32, templateID uint16, template interface{}) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(sharedTemplate{template}); err != nil {
+		st.nd.metrics.errors.WithLabelValues(exporter, "cannot encode shared template").Inc()
+		return
+	}
+	key := sharedTemplateKey(exporter, version, obsDomainID, templateID)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), sharedTemplatesTimeout)
+		defer cancel()
+		if err := st.client.Set(ctx, key, buf.Bytes(), st.ttl).Err(); err != nil {
+			st.nd.metrics.errors.WithLabelValues(exporter, "cannot store shared template").Inc()
+			return
+		}
+		st.nd.metrics.sharedTemplates.WithLabelValues(exporter, "stored").Inc()
+	}()
+}
+
+// lookup retrieves a template from Redis. Templates missing from Redis are
+// not looked up again before some delay.
+func (st *sharedTemplates) lookup(exporter string, version uint16, obsDomainID uint32, templateID uint16) (interface{}, bool) {
+	key := sharedTemplateKey(exporter, version, obsDomainID, templateID)
+	now := time.Now()
+	st.missesLock.Lock()
+	if missed, ok := st.misses[key]; ok && now.Sub(missed) < sharedTemplatesRetry {
+		st.missesLock.Unlock()
+		return nil, false
+	}
+	st.missesLock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), sharedTemplatesTimeout)
+	defer cancel()
+	value, err := st.client.Get(ctx, key).Bytes()
+	var template sharedTemplate
+	if err == nil {
+		err = gob.NewDecoder(bytes.NewReader(value)).Decode(&template)
+	}
+	if err != nil {
+		if err == redis.Nil {
+			st.nd.metrics.sharedTemplates.WithLabelValues(exporter, "missing").Inc()
+		} else {
+			st.nd.metrics.errors.WithLabelValues(exporter, "cannot retrieve shared template").Inc()
+		}
+		st.missesLock.Lock()
+		if len(st.misses) >= sharedTemplatesMaxMisses {
+			st.misses = map[string]time.Time{}
+		}
+		st.misses[key] = now
+		st.missesLock.Unlock()
+		return nil, false
+	}
+	st.nd.metrics.sharedTemplates.WithLabelValues(exporter, "found").Inc()
+	return template.Template, true
+}
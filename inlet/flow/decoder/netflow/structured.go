@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+
+	"github.com/netsampler/goflow2/decoders/netflow"
+)
+
+var (
+	errInvalidStructuredData          = errors.New("invalid structured data")
+	errStructuredDataTemplateNotFound = errors.New("structured data template not found")
+)
+
+// isStructuredData tells if a field contains IPFIX structured data (RFC 6313).
+func isStructuredData(value netflow.DataField) bool {
+	if value.PenProvided {
+		return false
+	}
+	switch value.Type {
+	case netflow.IPFIX_FIELD_basicList, netflow.IPFIX_FIELD_subTemplateList, netflow.IPFIX_FIELD_subTemplateMultiList:
+		return true
+	}
+	return false
+}
+
+// flattenStructuredData replaces the structured data fields of a record by
+// the fields of the first element of each list. Fields already present in
+// the record are not overridden. Invalid lists are ignored.
+func (nd *Decoder) flattenStructuredData(obsDomainID uint32, templates *templateSystem, values []netflow.DataField) []netflow.DataField {
+	found := false
+	for _, value := range values {
+		if isStructuredData(value) {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return values
+	}
+
+	result := make([]netflow.DataField, 0, len(values))
+	flattened := []netflow.DataField{}
+	for _, value := range values {
+		if !isStructuredData(value) {
+			result = append(result, value)
+			continue
+		}
+		v, _ := value.Value.([]byte)
+		var (
+			fields []netflow.DataField
+			err    error
+		)
+		switch value.Type {
+		case netflow.IPFIX_FIELD_basicList:
+			fields, err = decodeBasicListFirst(v)
+		case netflow.IPFIX_FIELD_subTemplateList:
+			fields, err = decodeSubTemplateListFirst(v, obsDomainID, templates)
+		case netflow.IPFIX_FIELD_subTemplateMultiList:
+			fields, err = decodeSubTemplateMultiListFirst(v, obsDomainID, templates)
+		}
+		if err != nil {
+			nd.metrics.errors.WithLabelValues(templates.key, err.Error()).Inc()
+			continue
+		}
+		flattened = append(flattened, fields...)
+	}
+	for _, field := range flattened {
+		if !hasField(result, field) {
+			result = append(result, field)
+		}
+	}
+	return result
+}
+
+// hasField tells if a record already contains the provided field.
+func hasField(values []netflow.DataField, field netflow.DataField) bool {
+	for _, value := range values {
+		if value.Type == field.Type && value.PenProvided == field.PenProvided && value.Pen == field.Pen {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeFirstRecord decodes the first record encoded with the provided
+// fields. It returns nil if there is no record.
+func decodeFirstRecord(data []byte, fields []netflow.Field) ([]netflow.DataField, error) {
+	if len(data) == 0 {
+		return nil, nil
+	}
+	values := netflow.DecodeDataSetUsingFields(10, bytes.NewBuffer(data), fields)
+	if len(values) != len(fields) {
+		return nil, errInvalidStructuredData
+	}
+	for idx, field := range fields {
+		v, _ := values[idx].Value.([]byte)
+		if field.Length != variableLength && len(v) != int(field.Length) {
+			return nil, errInvalidStructuredData
+		}
+	}
+	return values, nil
+}
+
+// lookupTemplateFields returns the fields of a data template.
+func lookupTemplateFields(obsDomainID uint32, templates *templateSystem, templateID uint16) ([]netflow.Field, error) {
+	template, err := templates.GetTemplate(10, obsDomainID, templateID)
+	if err != nil {
+		return nil, errStructuredDataTemplateNotFound
+	}
+	record, ok := template.(netflow.TemplateRecord)
+	if !ok {
+		return nil, errStructuredDataTemplateNotFound
+	}
+	return record.Fields, nil
+}
+
+// decodeBasicListFirst decodes the first element of a basicList.
+func decodeBasicListFirst(data []byte) ([]netflow.DataField, error) {
+	// Semantic (1 byte), field ID (2 bytes), element length (2 bytes) and
+	// enterprise number (4 bytes) when the enterprise bit is set.
+	if len(data) < 5 {
+		return nil, errInvalidStructuredData
+	}
+	fieldID := binary.BigEndian.Uint16(data[1:3])
+	field := netflow.Field{
+		Type:   fieldID & 0x7fff,
+		Length: binary.BigEndian.Uint16(data[3:5]),
+	}
+	data = data[5:]
+	if fieldID&0x8000 != 0 {
+		if len(data) < 4 {
+			return nil, errInvalidStructuredData
+		}
+		field.PenProvided = true
+		field.Pen = binary.BigEndian.Uint32(data[0:4])
+		data = data[4:]
+	}
+	return decodeFirstRecord(data, []netflow.Field{field})
+}
+
+// decodeSubTemplateListFirst decodes the first record of a subTemplateList.
+func decodeSubTemplateListFirst(data []byte, obsDomainID uint32, templates *templateSystem) ([]netflow.DataField, error) {
+	// Semantic (1 byte) and template ID (2 bytes)
+	if len(data) < 3 {
+		return nil, errInvalidStructuredData
+	}
+	fields, err := lookupTemplateFields(obsDomainID, templates, binary.BigEndian.Uint16(data[1:3]))
+	if err != nil {
+		return nil, err
+	}
+	return decodeFirstRecord(data[3:], fields)
+}
+
+// decodeSubTemplateMultiListFirst decodes the first record of the first
+// non-empty element of a subTemplateMultiList.
+func decodeSubTemplateMultiListFirst(data []byte, obsDomainID uint32, templates *templateSystem) ([]netflow.DataField, error) {
+	// Semantic (1 byte), then for each element, template ID (2 bytes) and
+	// length (2 bytes, including the header).
+	if len(data) < 1 {
+		return nil, errInvalidStructuredData
+	}
+	data = data[1:]
+	for len(data) > 0 {
+		if len(data) < 4 {
+			return nil, errInvalidStructuredData
+		}
+		length := int(binary.BigEndian.Uint16(data[2:4]))
+		if length < 4 || length > len(data) {
+			return nil, errInvalidStructuredData
+		}
+		if length > 4 {
+			fields, err := lookupTemplateFields(obsDomainID, templates, binary.BigEndian.Uint16(data[0:2]))
+			if err != nil {
+				return nil, err
+			}
+			return decodeFirstRecord(data[4:length], fields)
+		}
+		data = data[length:]
+	}
+	return nil, nil
+}
@@ -0,0 +1,202 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+
+	"github.com/netsampler/goflow2/decoders/netflow"
+
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// maxPendingSets is the maximum number of data sets kept for an exporter
+// while waiting for their template.
+const maxPendingSets = 1000
+
+// templateKey identifies a template of an exporter.
+type templateKey struct {
+	version     uint16
+	obsDomainID uint32
+	templateID  uint16
+}
+
+// pendingSet is a data set waiting for its template.
+type pendingSet struct {
+	received uint64 // in seconds
+	header   []byte // header of the original packet
+	set      []byte // data set, including its header
+}
+
+// packetSets splits a NetFlow v9 or IPFIX packet into its header and its
+// sets. ok is false if the packet cannot be parsed.
+func packetSets(payload []byte) (header []byte, sets [][]byte, ok bool) {
+	if len(payload) < 2 {
+		return nil, nil, false
+	}
+	headerLength := 0
+	switch binary.BigEndian.Uint16(payload[:2]) {
+	case 9:
+		headerLength = 20
+	case 10:
+		headerLength = 16
+	default:
+		return nil, nil, false
+	}
+	if len(payload) < headerLength {
+		return nil, nil, false
+	}
+	header = payload[:headerLength]
+	rest := payload[len(header):]
+	for len(rest) > 0 {
+		if len(rest) < 4 {
+			return nil, nil, false
+		}
+		length := int(binary.BigEndian.Uint16(rest[2:4]))
+		if length < 4 || length > len(rest) {
+			return nil, nil, false
+		}
+		sets = append(sets, rest[:length])
+		rest = rest[length:]
+	}
+	return header, sets, true
+}
+
+// buildPacket builds a NetFlow v9 or IPFIX packet from a header and sets.
+// The count (NetFlow v9) or the length (IPFIX) in the header is updated.
+func buildPacket(header []byte, sets [][]byte) []byte {
+	packet := append([]byte{}, header...)
+	for _, set := range sets {
+		packet = append(packet, set...)
+	}
+	if binary.BigEndian.Uint16(header[:2]) == 9 {
+		binary.BigEndian.PutUint16(packet[2:4], uint16(len(sets)))
+	} else {
+		binary.BigEndian.PutUint16(packet[2:4], uint16(len(packet)))
+	}
+	return packet
+}
+
+// handleTemplateMiss is called when a packet contains data sets whose
+// template is unknown. Each miss is counted. Depending on the policy, data
+// sets with an unknown template are buffered or dropped. It returns a packet
+// with the remaining data sets, or nil if there are none. Template sets are
+// removed as they were learnt during the first decoding attempt.
+func (nd *Decoder) handleTemplateMiss(payload []byte, templates *templateSystem, received uint64) []byte {
+	header, sets, ok := packetSets(payload)
+	if !ok {
+		return nil
+	}
+	version := binary.BigEndian.Uint16(header[:2])
+	obsDomainID := binary.BigEndian.Uint32(header[len(header)-4:])
+	kept := [][]byte{}
+	for _, set := range sets {
+		id := binary.BigEndian.Uint16(set[:2])
+		if id < 256 {
+			continue
+		}
+		if _, err := templates.templates.GetTemplate(version, obsDomainID, id); err == nil {
+			kept = append(kept, set)
+			continue
+		}
+		nd.metrics.templateMisses.WithLabelValues(
+			templates.key,
+			strconv.Itoa(int(version)),
+			strconv.Itoa(int(obsDomainID)),
+			strconv.Itoa(int(id)),
+		).Inc()
+		if nd.config.TemplateMissPolicy == decoder.TemplateMissPolicyBuffer {
+			templates.addPending(templateKey{version, obsDomainID, id}, pendingSet{
+				received: received,
+				header:   append([]byte{}, header...),
+				set:      append([]byte{}, set...),
+			}, received, uint64(nd.config.TemplateMissWindow.Seconds()))
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	return buildPacket(header, kept)
+}
+
+// addPending buffers a data set until its template is received. Data sets
+// older than the provided window are expired first.
+func (s *templateSystem) addPending(key templateKey, pending pendingSet, now uint64, window uint64) {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	s.expirePending(now, window)
+	if s.pendingCount >= maxPendingSets {
+		s.nd.metrics.errors.WithLabelValues(s.key, "template miss buffer full").Inc()
+		return
+	}
+	if s.pending == nil {
+		s.pending = map[templateKey][]pendingSet{}
+	}
+	s.pending[key] = append(s.pending[key], pending)
+	s.pendingCount++
+}
+
+// expirePending removes the data sets older than the provided window. The
+// pending lock should be held.
+func (s *templateSystem) expirePending(now uint64, window uint64) {
+	for key, sets := range s.pending {
+		kept := sets[:0]
+		for _, set := range sets {
+			if set.received+window < now {
+				s.nd.metrics.errors.WithLabelValues(s.key, "template not received in time").Inc()
+				s.pendingCount--
+				continue
+			}
+			kept = append(kept, set)
+		}
+		if len(kept) == 0 {
+			delete(s.pending, key)
+		} else {
+			s.pending[key] = kept
+		}
+	}
+}
+
+// takePending returns the buffered data sets whose template is now known.
+func (s *templateSystem) takePending(now uint64, window uint64) []pendingSet {
+	s.pendingLock.Lock()
+	defer s.pendingLock.Unlock()
+	if s.pendingCount == 0 {
+		return nil
+	}
+	s.expirePending(now, window)
+	var result []pendingSet
+	for key, sets := range s.pending {
+		if _, err := s.templates.GetTemplate(key.version, key.obsDomainID, key.templateID); err != nil {
+			continue
+		}
+		result = append(result, sets...)
+		s.pendingCount -= len(sets)
+		delete(s.pending, key)
+	}
+	return result
+}
+
+// decodePending decodes the buffered data sets whose template is now known.
+// They keep the time they were received.
+func (nd *Decoder) decodePending(templates *templateSystem, sampling *samplingRateSystem, received uint64) []*schema.FlowMessage {
+	if nd.config.TemplateMissPolicy != decoder.TemplateMissPolicyBuffer {
+		return nil
+	}
+	flowMessageSet := []*schema.FlowMessage{}
+	for _, pending := range templates.takePending(received, uint64(nd.config.TemplateMissWindow.Seconds())) {
+		packet := buildPacket(pending.header, [][]byte{pending.set})
+		msgDec, err := netflow.DecodeMessage(bytes.NewBuffer(packet), templates)
+		if err != nil {
+			nd.metrics.errors.WithLabelValues(templates.key, "error decoding").Inc()
+			continue
+		}
+		flowMessageSet = append(flowMessageSet,
+			nd.decode(msgDec, sampling, templates, pending.received)...)
+	}
+	return flowMessageSet
+}
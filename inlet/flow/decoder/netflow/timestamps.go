@@ -0,0 +1,193 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package netflow
+
+import (
+	"encoding/binary"
+	"math/bits"
+
+	"akvorado/inlet/flow/decoder"
+
+	"github.com/netsampler/goflow2/decoders/netflow"
+)
+
+// maxSpreadSlices is the maximum number of flows a flow is split into when
+// spreading its volume over its duration.
+const maxSpreadSlices = 60
+
+// exportTime is the time a NetFlow v5/v9 packet was exported, used to turn
+// timestamps relative to the system uptime into absolute timestamps. It is
+// empty for IPFIX.
+type exportTime struct {
+	sysUptime uint32 // in milliseconds
+	unixSecs  uint32
+}
+
+// absolute turns a timestamp relative to the system uptime (in milliseconds)
+// into a timestamp in milliseconds since the epoch.
+func (et exportTime) absolute(uptime uint32) uint64 {
+	// This also works when the uptime wraps.
+	return uint64(et.unixSecs)*1000 - uint64(et.sysUptime-uptime)
+}
+
+// clockSkewTolerance is the clock skew (in seconds) under which flow
+// timestamps are not corrected: export times have a one-second resolution
+// and include the transit delay.
+const clockSkewTolerance = 2
+
+// clockOffset returns the offset (in milliseconds) to apply to the flow
+// timestamps of a packet exported at the provided time and received at the
+// provided time (both in seconds since the epoch). It is 0 when clock skew
+// correction is disabled or when the skew is small.
+func (nd *Decoder) clockOffset(exportSecs uint32, received uint64) int64 {
+	if !nd.config.ClockSkewCorrection || exportSecs == 0 {
+		return 0
+	}
+	skew := int64(received) - int64(exportSecs)
+	if skew > -clockSkewTolerance && skew < clockSkewTolerance {
+		return 0
+	}
+	return skew * 1000
+}
+
+// shift applies an offset (in milliseconds) to a timestamp.
+func shift(timestamp uint64, offset int64) uint64 {
+	return uint64(int64(timestamp) + offset)
+}
+
+// timeSlice is a part of a flow. Its volume is the share of the flow
+// between the previous slice and its end (as an offset from the start of the
+// flow, in milliseconds).
+type timeSlice struct {
+	timestamp uint64 // in seconds
+	end       uint64
+}
+
+// flowTimes extracts the start and the end of a flow from its fields, in
+// milliseconds since the epoch.
+func flowTimes(fields []netflow.DataField, et exportTime) (start uint64, end uint64, ok bool) {
+	var gotStart, gotEnd bool
+	for _, field := range fields {
+		v, ok := field.Value.([]byte)
+		if !ok || field.PenProvided {
+			continue
+		}
+		switch field.Type {
+		case netflow.IPFIX_FIELD_flowStartSeconds:
+			start, gotStart = decodeUNumber(v)*1000, true
+		case netflow.IPFIX_FIELD_flowEndSeconds:
+			end, gotEnd = decodeUNumber(v)*1000, true
+		case netflow.IPFIX_FIELD_flowStartMilliseconds:
+			start, gotStart = decodeUNumber(v), true
+		case netflow.IPFIX_FIELD_flowEndMilliseconds:
+			end, gotEnd = decodeUNumber(v), true
+		case netflow.NFV9_FIELD_FIRST_SWITCHED:
+			if et.unixSecs != 0 {
+				start, gotStart = et.absolute(uint32(decodeUNumber(v))), true
+			}
+		case netflow.NFV9_FIELD_LAST_SWITCHED:
+			if et.unixSecs != 0 {
+				end, gotEnd = et.absolute(uint32(decodeUNumber(v))), true
+			}
+		}
+	}
+	switch {
+	case gotStart && !gotEnd:
+		end = start
+	case gotEnd && !gotStart:
+		start = end
+	case !gotStart && !gotEnd:
+		return 0, 0, false
+	}
+	return start, end, end >= start
+}
+
+// timeSlices returns the timestamps to use for a flow starting and ending
+// at the provided times (in milliseconds) and received at the provided time
+// (in seconds). When spreading is enabled, a flow may be split into several
+// slices.
+func (nd *Decoder) timeSlices(start, end uint64, ok bool, received uint64) []timeSlice {
+	if !ok || nd.config.TimestampSource == decoder.TimestampSourceReceived {
+		return []timeSlice{{timestamp: received}}
+	}
+	if maxSkew := uint64(nd.config.TimestampMaxSkew.Milliseconds()); maxSkew > 0 {
+		receivedMs := received * 1000
+		if start+maxSkew < receivedMs || start > receivedMs+maxSkew ||
+			end+maxSkew < receivedMs || end > receivedMs+maxSkew {
+			return []timeSlice{{timestamp: received}}
+		}
+	}
+	duration := end - start
+	interval := uint64(nd.config.SpreadInterval.Milliseconds())
+	if interval == 0 || duration <= interval {
+		if nd.config.TimestampSource == decoder.TimestampSourceFlowEnd {
+			return []timeSlice{{timestamp: end / 1000, end: duration}}
+		}
+		return []timeSlice{{timestamp: start / 1000, end: duration}}
+	}
+
+	// Slices are aligned on the interval. If there are too many of them,
+	// the duration is divided evenly.
+	slices := []timeSlice{}
+	if duration/interval+1 >= maxSpreadSlices {
+		for i := uint64(0); i < maxSpreadSlices; i++ {
+			sliceStart := duration * i / maxSpreadSlices
+			sliceEnd := duration * (i + 1) / maxSpreadSlices
+			slices = append(slices, timeSlice{
+				timestamp: (start + sliceStart) / 1000,
+				end:       sliceEnd,
+			})
+		}
+		return slices
+	}
+	sliceStart := start
+	for sliceStart < end {
+		sliceEnd := (sliceStart/interval + 1) * interval
+		if sliceEnd > end {
+			sliceEnd = end
+		}
+		slices = append(slices, timeSlice{
+			timestamp: sliceStart / 1000,
+			end:       sliceEnd - start,
+		})
+		sliceStart = sliceEnd
+	}
+	return slices
+}
+
+// share returns the part of the provided value for a slice. previous is the
+// end of the previous slice and duration the total duration of the flow.
+func (ts timeSlice) share(value, previous, duration uint64) uint64 {
+	if duration == 0 {
+		return value
+	}
+	part := func(offset uint64) uint64 {
+		hi, lo := bits.Mul64(value, offset)
+		quo, _ := bits.Div64(hi, lo, duration)
+		return quo
+	}
+	return part(ts.end) - part(previous)
+}
+
+// spreadFields returns a copy of the provided fields for a slice, with the
+// volumes replaced by their share.
+func spreadFields(fields []netflow.DataField, slice timeSlice, previous, duration uint64) []netflow.DataField {
+	result := make([]netflow.DataField, len(fields))
+	copy(result, fields)
+	for idx, field := range result {
+		v, ok := field.Value.([]byte)
+		if !ok || field.PenProvided {
+			continue
+		}
+		switch field.Type {
+		case netflow.NFV9_FIELD_IN_BYTES, netflow.NFV9_FIELD_OUT_BYTES,
+			netflow.NFV9_FIELD_IN_PKTS, netflow.NFV9_FIELD_OUT_PKTS,
+			netflow.IPFIX_FIELD_initiatorOctets, netflow.IPFIX_FIELD_initiatorPackets:
+			value := make([]byte, 8)
+			binary.BigEndian.PutUint64(value, slice.share(decodeUNumber(v), previous, duration))
+			result[idx].Value = value
+		}
+	}
+	return result
+}
@@ -0,0 +1,192 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package protobuf decodes flows encoded with the protobuf schema used by the
+// inlet to send flows to Kafka. It allows another inlet or a lightweight agent
+// to forward already decoded flows.
+package protobuf
+
+import (
+	"net/netip"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// Decoder contains the state for the protobuf decoder.
+type Decoder struct {
+	r *reporter.Reporter
+	d decoder.Dependencies
+
+	columns map[protowire.Number]*schema.Column // columns indexed by protobuf field number
+
+	metrics struct {
+		errors *reporter.CounterVec
+		stats  *reporter.CounterVec
+	}
+}
+
+func init() {
+	decoder.Register(decoder.Registration{
+		Name:       "protobuf",
+		APIVersion: decoder.APIVersion,
+		New:        New,
+	})
+}
+
+// New instantiates a new protobuf decoder.
+func New(r *reporter.Reporter, _ decoder.Configuration, dependencies decoder.Dependencies) decoder.Decoder {
+	nd := &Decoder{
+		r:       r,
+		d:       dependencies,
+		columns: map[protowire.Number]*schema.Column{},
+	}
+	for key := schema.ColumnTimeReceived; key < schema.ColumnLast; key++ {
+		column, ok := dependencies.Schema.LookupColumnByKey(key)
+		if ok && column.ProtobufIndex > 0 {
+			nd.columns[column.ProtobufIndex] = column
+		}
+	}
+
+	nd.metrics.errors = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors_count",
+			Help: "Protobuf flows processed errors.",
+		},
+		[]string{"exporter", "error"},
+	)
+	nd.metrics.stats = nd.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "count",
+			Help: "Protobuf flows processed.",
+		},
+		[]string{"exporter"},
+	)
+
+	return nd
+}
+
+// Decode decodes a protobuf payload. The payload contains exactly one flow,
+// without a length prefix.
+func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
+	key := in.Source.String()
+	flow := schema.NewFlowMessage()
+	payload := in.Payload
+	for len(payload) > 0 {
+		num, typ, n := protowire.ConsumeTag(payload)
+		if n < 0 {
+			nd.metrics.errors.WithLabelValues(key, "invalid tag").Inc()
+			schema.ReleaseFlowMessage(flow)
+			return nil
+		}
+		payload = payload[n:]
+		column, ok := nd.columns[num]
+		switch {
+		case !ok:
+			n = protowire.ConsumeFieldValue(num, typ, payload)
+		case typ == protowire.VarintType:
+			var value uint64
+			value, n = protowire.ConsumeVarint(payload)
+			if n >= 0 {
+				nd.decodeVarint(flow, column, value)
+			}
+		case typ == protowire.BytesType:
+			var value []byte
+			value, n = protowire.ConsumeBytes(payload)
+			if n >= 0 && !nd.decodeBytes(flow, column, value) {
+				n = -1
+			}
+		default:
+			nd.metrics.errors.WithLabelValues(key, "unexpected wire type").Inc()
+			schema.ReleaseFlowMessage(flow)
+			return nil
+		}
+		if n < 0 {
+			nd.metrics.errors.WithLabelValues(key, "invalid value").Inc()
+			schema.ReleaseFlowMessage(flow)
+			return nil
+		}
+		payload = payload[n:]
+	}
+	if flow.TimeReceived == 0 {
+		flow.TimeReceived = uint64(in.TimeReceived.UTC().Unix())
+	}
+	nd.metrics.stats.WithLabelValues(key).Inc()
+
+	return []*schema.FlowMessage{flow}
+}
+
+// decodeVarint stores an integer value into a flow. Values handled by the core
+// component are stored in the exported fields of the flow.
+func (nd *Decoder) decodeVarint(flow *schema.FlowMessage, column *schema.Column, value uint64) {
+	switch column.Key {
+	case schema.ColumnTimeReceived:
+		flow.TimeReceived = value
+	case schema.ColumnSamplingRate:
+		flow.SamplingRate = uint32(value)
+	case schema.ColumnSrcAS:
+		flow.SrcAS = uint32(value)
+	case schema.ColumnDstAS:
+		flow.DstAS = uint32(value)
+	case schema.ColumnSrcVlan:
+		flow.SrcVlan = uint16(value)
+	case schema.ColumnDstVlan:
+		flow.DstVlan = uint16(value)
+	case schema.ColumnDstASPath:
+		flow.GotASPath = true
+		column.ProtobufAppendVarintForce(flow, value)
+	default:
+		column.ProtobufAppendVarintForce(flow, value)
+	}
+}
+
+// decodeBytes stores a bytes value into a flow. Repeated integers may be
+// packed, they are unpacked. It returns false if the value is invalid.
+func (nd *Decoder) decodeBytes(flow *schema.FlowMessage, column *schema.Column, value []byte) bool {
+	switch column.Key {
+	case schema.ColumnExporterAddress, schema.ColumnSrcAddr, schema.ColumnDstAddr:
+		ip, ok := netip.AddrFromSlice(value)
+		if !ok {
+			return false
+		}
+		switch column.Key {
+		case schema.ColumnExporterAddress:
+			flow.ExporterAddress = ip
+		case schema.ColumnSrcAddr:
+			flow.SrcAddr = ip
+		case schema.ColumnDstAddr:
+			flow.DstAddr = ip
+		}
+		return true
+	case schema.ColumnFlowExportDirection:
+		switch string(value) {
+		case schema.FlowExportDirectionIngress.String():
+			flow.ExportDirection = schema.FlowExportDirectionIngress
+		case schema.FlowExportDirectionEgress.String():
+			flow.ExportDirection = schema.FlowExportDirectionEgress
+		}
+		return true
+	}
+	if column.ProtobufType == protoreflect.BytesKind || column.ProtobufType == protoreflect.StringKind {
+		column.ProtobufAppendBytesForce(flow, value)
+		return true
+	}
+	for len(value) > 0 {
+		v, n := protowire.ConsumeVarint(value)
+		if n < 0 {
+			return false
+		}
+		nd.decodeVarint(flow, column, v)
+		value = value[n:]
+	}
+	return true
+}
+
+// Name returns the name of the decoder.
+func (nd *Decoder) Name() string {
+	return "protobuf"
+}
@@ -0,0 +1,130 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package protobuf
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+func TestDecode(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch := schema.NewMock(t).EnableAllColumns()
+	pdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: sch})
+
+	appendVarint := func(b []byte, key schema.ColumnKey, value uint64) []byte {
+		column, _ := sch.LookupColumnByKey(key)
+		b = protowire.AppendTag(b, column.ProtobufIndex, protowire.VarintType)
+		return protowire.AppendVarint(b, value)
+	}
+	appendBytes := func(b []byte, key schema.ColumnKey, value []byte) []byte {
+		column, _ := sch.LookupColumnByKey(key)
+		b = protowire.AppendTag(b, column.ProtobufIndex, protowire.BytesType)
+		return protowire.AppendBytes(b, value)
+	}
+	appendIP := func(b []byte, key schema.ColumnKey, value string) []byte {
+		ip := netip.MustParseAddr(value).As16()
+		return appendBytes(b, key, ip[:])
+	}
+
+	var payload []byte
+	payload = appendVarint(payload, schema.ColumnTimeReceived, 1000)
+	payload = appendVarint(payload, schema.ColumnSamplingRate, 20000)
+	payload = appendIP(payload, schema.ColumnExporterAddress, "::ffff:203.0.113.14")
+	payload = appendIP(payload, schema.ColumnSrcAddr, "2001:db8::1")
+	payload = appendIP(payload, schema.ColumnDstAddr, "2001:db8::2")
+	payload = appendVarint(payload, schema.ColumnSrcAS, 64476)
+	payload = appendVarint(payload, schema.ColumnDstAS, 65000)
+	payload = appendVarint(payload, schema.ColumnSrcVlan, 100)
+	payload = appendBytes(payload, schema.ColumnFlowExportDirection, []byte("egress"))
+	payload = appendVarint(payload, schema.ColumnBytes, 1500)
+	payload = appendVarint(payload, schema.ColumnPackets, 2)
+	payload = appendBytes(payload, schema.ColumnExporterName, []byte("edge1"))
+	payload = appendBytes(payload, schema.ColumnInIfName, []byte("Gi0/0/1"))
+	payload = appendBytes(payload, schema.ColumnDstCountry, []byte("FR"))
+	// Packed repeated field
+	payload = appendBytes(payload, schema.ColumnDstASPath,
+		protowire.AppendVarint(protowire.AppendVarint(nil, 65000), 174))
+	// Unknown field
+	payload = protowire.AppendTag(payload, 10000, protowire.Fixed32Type)
+	payload = protowire.AppendFixed32(payload, 12)
+
+	got := pdecoder.Decode(decoder.RawFlow{
+		TimeReceived: time.Unix(2000, 0),
+		Payload:      payload,
+		Source:       net.ParseIP("127.0.0.1"),
+	})
+	expected := []*schema.FlowMessage{
+		{
+			TimeReceived:    1000,
+			SamplingRate:    20000,
+			ExporterAddress: netip.MustParseAddr("::ffff:203.0.113.14"),
+			SrcAddr:         netip.MustParseAddr("2001:db8::1"),
+			DstAddr:         netip.MustParseAddr("2001:db8::2"),
+			SrcAS:           64476,
+			DstAS:           65000,
+			SrcVlan:         100,
+			GotASPath:       true,
+			ExportDirection: schema.FlowExportDirectionEgress,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:        1500,
+				schema.ColumnPackets:      2,
+				schema.ColumnExporterName: []byte("edge1"),
+				schema.ColumnInIfName:     []byte("Gi0/0/1"),
+				schema.ColumnDstCountry:   []byte("FR"),
+				schema.ColumnDstASPath:    []interface{}{65000, 174},
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+	if diff := helpers.Diff(got[0].Counters(), schema.FlowCounters{Bytes: 1500, Packets: 2}); diff != "" {
+		t.Fatalf("Counters() (-got, +want):\n%s", diff)
+	}
+	if !sch.ProtobufIsSet(got[0], schema.ColumnInIfName) {
+		t.Error("ProtobufIsSet(InIfName) == false")
+	}
+	if sch.ProtobufIsSet(got[0], schema.ColumnOutIfName) {
+		t.Error("ProtobufIsSet(OutIfName) == true")
+	}
+
+	// Without TimeReceived, the reception time is used.
+	got = pdecoder.Decode(decoder.RawFlow{
+		TimeReceived: time.Unix(2000, 0),
+		Payload:      appendVarint(nil, schema.ColumnSamplingRate, 100),
+		Source:       net.ParseIP("127.0.0.1"),
+	})
+	expected = []*schema.FlowMessage{{TimeReceived: 2000, SamplingRate: 100}}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+
+	// Truncated payload
+	got = pdecoder.Decode(decoder.RawFlow{
+		Payload: payload[:len(payload)-2],
+		Source:  net.ParseIP("127.0.0.1"),
+	})
+	if got != nil {
+		t.Fatalf("Decode() did not error on truncated payload")
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_protobuf_")
+	expectedMetrics := map[string]string{
+		`count{exporter="127.0.0.1"}`:                              "2",
+		`errors_count{error="invalid value",exporter="127.0.0.1"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -0,0 +1,80 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package decoder
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// APIVersion is the version of the contract between the flow component and
+// the decoders. It covers the Decoder interface, RawFlow, Dependencies,
+// Configuration and the way flow messages are produced:
+//
+//   - a decoder returns nil when a raw flow cannot be decoded and an empty
+//     slice when it was decoded but contains no flow;
+//   - each flow message has TimeReceived, SamplingRate and ExporterAddress
+//     set (as an IPv6 or IPv4-mapped IPv6 address);
+//   - other columns are set with the ProtobufAppend* methods of the schema
+//     provided in the dependencies, skipping disabled columns is done by
+//     the schema.
+//
+// It is bumped each time one of these items changes in an incompatible way.
+const APIVersion = 1
+
+// Registration describes a decoder to register.
+type Registration struct {
+	// Name is the name of the decoder, as used in the configuration.
+	Name string
+	// APIVersion is the version of the contract the decoder was written
+	// against. It should be set to APIVersion.
+	APIVersion int
+	// New instantiates the decoder.
+	New NewDecoderFunc
+}
+
+var (
+	registryLock sync.RWMutex
+	registry     = map[string]NewDecoderFunc{}
+)
+
+// Register registers a new decoder. This should only be done during init.
+// It panics if the decoder is already registered or if it was written
+// against another version of the API.
+func Register(registration Registration) {
+	if registration.Name == "" || registration.New == nil {
+		panic("decoder registration requires a name and a constructor")
+	}
+	if registration.APIVersion != APIVersion {
+		panic(fmt.Sprintf("decoder %q uses API version %d, expected %d",
+			registration.Name, registration.APIVersion, APIVersion))
+	}
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if _, ok := registry[registration.Name]; ok {
+		panic(fmt.Sprintf("decoder %q already registered", registration.Name))
+	}
+	registry[registration.Name] = registration.New
+}
+
+// Lookup returns the constructor for the decoder with the provided name.
+func Lookup(name string) (NewDecoderFunc, bool) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	newFunc, ok := registry[name]
+	return newFunc, ok
+}
+
+// Registered returns the sorted names of the registered decoders.
+func Registered() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,57 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package decoder
+
+import (
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+func TestRegister(t *testing.T) {
+	newDummy := func(*reporter.Reporter, Configuration, Dependencies) Decoder {
+		return &DummyDecoder{}
+	}
+	Register(Registration{
+		Name:       "test-registry",
+		APIVersion: APIVersion,
+		New:        newDummy,
+	})
+	defer func() {
+		registryLock.Lock()
+		delete(registry, "test-registry")
+		registryLock.Unlock()
+	}()
+
+	if _, ok := Lookup("test-registry"); !ok {
+		t.Fatal("Lookup() did not find registered decoder")
+	}
+	if _, ok := Lookup("unknown"); ok {
+		t.Fatal("Lookup() found unknown decoder")
+	}
+	if diff := helpers.Diff(Registered(), []string{"test-registry"}); diff != "" {
+		t.Fatalf("Registered() (-got, +want):\n%s", diff)
+	}
+
+	cases := []struct {
+		Description  string
+		Registration Registration
+	}{
+		{"duplicate", Registration{Name: "test-registry", APIVersion: APIVersion, New: newDummy}},
+		{"wrong version", Registration{Name: "other", APIVersion: APIVersion + 1, New: newDummy}},
+		{"no name", Registration{APIVersion: APIVersion, New: newDummy}},
+		{"no constructor", Registration{Name: "other", APIVersion: APIVersion}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Fatal("Register() did not panic")
+				}
+			}()
+			Register(tc.Registration)
+		})
+	}
+}
@@ -24,12 +24,52 @@ type Decoder interface {
 	Name() string
 }
 
+// Persistent is implemented by decoders able to save their state to a
+// file and to restore it on start.
+type Persistent interface {
+	// Save persists the state of the decoder to the provided file.
+	Save(file string) error
+	// Load restores the state of the decoder from the provided file.
+	Load(file string) error
+}
+
+// TemplateInspector is implemented by decoders keeping the templates sent by
+// exporters. It is used to help diagnose decoding issues.
+type TemplateInspector interface {
+	// Templates returns the templates known for each exporter.
+	Templates() []Template
+}
+
+// Template describes a template sent by an exporter, with some statistics
+// about its use.
+type Template struct {
+	Exporter            string          `json:"exporter"`
+	Version             uint16          `json:"version"`
+	ObservationDomainID uint32          `json:"observation-domain-id"`
+	TemplateID          uint16          `json:"template-id"`
+	Type                string          `json:"type"`
+	Scopes              []TemplateField `json:"scopes,omitempty"`
+	Fields              []TemplateField `json:"fields"`
+	Records             uint64          `json:"records"`
+	LastSeen            *time.Time      `json:"last-seen,omitempty"` // last time the template was received
+	LastUsed            *time.Time      `json:"last-used,omitempty"` // last time a record was received
+}
+
+// TemplateField describes a field of a template.
+type TemplateField struct {
+	Enterprise uint32 `json:"enterprise,omitempty"`
+	ID         uint16 `json:"id"`
+	Name       string `json:"name,omitempty"`
+	Length     uint16 `json:"length"`
+}
+
 // Dependencies are the dependencies for the decoder
 type Dependencies struct {
 	Schema *schema.Component
 }
 
-// RawFlow is an undecoded flow.
+// RawFlow is an undecoded flow. The payload is only valid during the call to
+// Decode(): inputs may reuse its buffer for the next flows.
 type RawFlow struct {
 	TimeReceived time.Time
 	Payload      []byte
@@ -37,4 +77,4 @@ type RawFlow struct {
 }
 
 // NewDecoderFunc is the signature of a function to instantiate a decoder.
-type NewDecoderFunc func(*reporter.Reporter, Dependencies) Decoder
+type NewDecoderFunc func(*reporter.Reporter, Configuration, Dependencies) Decoder
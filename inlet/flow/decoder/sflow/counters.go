@@ -0,0 +1,71 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package sflow
+
+import (
+	"strconv"
+
+	"github.com/netsampler/goflow2/decoders/sflow"
+
+	"akvorado/common/reporter"
+)
+
+// interfaceMetrics contains the metrics built from sFlow interface counters.
+type interfaceMetrics struct {
+	speed       *reporter.GaugeVec
+	status      *reporter.GaugeVec
+	inOctets    *reporter.GaugeVec
+	outOctets   *reporter.GaugeVec
+	inPackets   *reporter.GaugeVec
+	outPackets  *reporter.GaugeVec
+	inErrors    *reporter.GaugeVec
+	outErrors   *reporter.GaugeVec
+	inDiscards  *reporter.GaugeVec
+	outDiscards *reporter.GaugeVec
+}
+
+// newInterfaceMetrics registers the metrics for interface counters.
+func newInterfaceMetrics(r *reporter.Reporter) interfaceMetrics {
+	labels := []string{"exporter", "agent", "ifindex"}
+	gauge := func(name, help string) *reporter.GaugeVec {
+		return r.GaugeVec(reporter.GaugeOpts{Name: name, Help: help}, labels)
+	}
+	return interfaceMetrics{
+		speed:       gauge("interface_speed_bps", "Interface speed in bits per second, as reported by sFlow counters."),
+		status:      gauge("interface_up", "Interface operational status (1 when up), as reported by sFlow counters."),
+		inOctets:    gauge("interface_in_octets", "Octets received on the interface, as reported by sFlow counters."),
+		outOctets:   gauge("interface_out_octets", "Octets sent on the interface, as reported by sFlow counters."),
+		inPackets:   gauge("interface_in_packets", "Packets received on the interface, as reported by sFlow counters."),
+		outPackets:  gauge("interface_out_packets", "Packets sent on the interface, as reported by sFlow counters."),
+		inErrors:    gauge("interface_in_errors", "Receive errors on the interface, as reported by sFlow counters."),
+		outErrors:   gauge("interface_out_errors", "Transmit errors on the interface, as reported by sFlow counters."),
+		inDiscards:  gauge("interface_in_discards", "Discarded received packets on the interface, as reported by sFlow counters."),
+		outDiscards: gauge("interface_out_discards", "Discarded packets to be sent on the interface, as reported by sFlow counters."),
+	}
+}
+
+// recordCounters updates interface metrics from a counter sample.
+func (nd *Decoder) recordCounters(exporter, agent string, sample sflow.CounterSample) {
+	for _, record := range sample.Records {
+		counters, ok := record.Data.(sflow.IfCounters)
+		if !ok {
+			continue
+		}
+		ifIndex := strconv.FormatUint(uint64(counters.IfIndex), 10)
+		m := nd.interfaceMetrics
+		m.speed.WithLabelValues(exporter, agent, ifIndex).Set(float64(counters.IfSpeed))
+		// Bit 1 of ifStatus is the operational status
+		m.status.WithLabelValues(exporter, agent, ifIndex).Set(float64(counters.IfStatus >> 1 & 1))
+		m.inOctets.WithLabelValues(exporter, agent, ifIndex).Set(float64(counters.IfInOctets))
+		m.outOctets.WithLabelValues(exporter, agent, ifIndex).Set(float64(counters.IfOutOctets))
+		m.inPackets.WithLabelValues(exporter, agent, ifIndex).Set(float64(
+			uint64(counters.IfInUcastPkts) + uint64(counters.IfInMulticastPkts) + uint64(counters.IfInBroadcastPkts)))
+		m.outPackets.WithLabelValues(exporter, agent, ifIndex).Set(float64(
+			uint64(counters.IfOutUcastPkts) + uint64(counters.IfOutMulticastPkts) + uint64(counters.IfOutBroadcastPkts)))
+		m.inErrors.WithLabelValues(exporter, agent, ifIndex).Set(float64(counters.IfInErrors))
+		m.outErrors.WithLabelValues(exporter, agent, ifIndex).Set(float64(counters.IfOutErrors))
+		m.inDiscards.WithLabelValues(exporter, agent, ifIndex).Set(float64(counters.IfInDiscards))
+		m.outDiscards.WithLabelValues(exporter, agent, ifIndex).Set(float64(counters.IfOutDiscards))
+	}
+}
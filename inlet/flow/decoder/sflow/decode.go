@@ -10,6 +10,7 @@ import (
 
 	"akvorado/common/helpers"
 	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
 
 	"github.com/netsampler/goflow2/decoders/sflow"
 )
@@ -24,8 +25,14 @@ func (nd *Decoder) decode(msgDec interface{}) []*schema.FlowMessage {
 	packet := msgDec.(sflow.Packet)
 
 	for _, flowSample := range packet.Samples {
+		switch flowSample.(type) {
+		case sflow.FlowSample, sflow.ExpandedFlowSample:
+		default:
+			// Counter samples or samples not decoded by goflow2
+			continue
+		}
 		var records []sflow.FlowRecord
-		bf := &schema.FlowMessage{}
+		bf := schema.NewFlowMessage()
 		forwardingStatus := 0
 		switch flowSample := flowSample.(type) {
 		case sflow.FlowSample:
@@ -44,6 +51,16 @@ func (nd *Decoder) decode(msgDec interface{}) []*schema.FlowMessage {
 			bf.SamplingRate = flowSample.SamplingRate
 			bf.InIf = flowSample.InputIfValue
 			bf.OutIf = flowSample.OutputIfValue
+			if flowSample.InputIfFormat != interfaceFormatIndex {
+				bf.InIf = 0
+			}
+			switch flowSample.OutputIfFormat {
+			case interfaceFormatDiscard:
+				bf.OutIf = 0
+				forwardingStatus = 128
+			case interfaceFormatMultiple:
+				bf.OutIf = 0
+			}
 		}
 
 		if bf.InIf == interfaceLocal {
@@ -56,101 +73,118 @@ func (nd *Decoder) decode(msgDec interface{}) []*schema.FlowMessage {
 		bf.ExporterAddress = decodeIP(packet.AgentIP)
 		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnPackets, 1)
 		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnForwardingStatus, uint64(forwardingStatus))
+		nd.decodeRecords(bf, records)
+		flowMessageSet = append(flowMessageSet, bf)
+	}
 
-		// Optimization: avoid parsing sampled header if we have everything already parsed
-		hasSampledIPv4 := false
-		hasSampledIPv6 := false
-		hasSampledEthernet := false
-		hasExtendedSwitch := false
-		for _, record := range records {
-			switch record.Data.(type) {
-			case sflow.SampledIPv4:
-				hasSampledIPv4 = true
-			case sflow.SampledIPv6:
-				hasSampledIPv6 = true
-			case sflow.SampledEthernet:
-				hasSampledEthernet = true
-			case sflow.ExtendedSwitch:
-				hasExtendedSwitch = true
-			}
+	return flowMessageSet
+}
+
+// decodeRecords decodes the flow records of a sample into a flow message.
+func (nd *Decoder) decodeRecords(bf *schema.FlowMessage, records []sflow.FlowRecord) {
+	// Optimization: avoid parsing sampled header if we have everything already parsed
+	hasSampledIPv4 := false
+	hasSampledIPv6 := false
+	hasSampledEthernet := false
+	hasExtendedSwitch := false
+	for _, record := range records {
+		switch record.Data.(type) {
+		case sflow.SampledIPv4:
+			hasSampledIPv4 = true
+		case sflow.SampledIPv6:
+			hasSampledIPv6 = true
+		case sflow.SampledEthernet:
+			hasSampledEthernet = true
+		case sflow.ExtendedSwitch:
+			hasExtendedSwitch = true
 		}
+	}
 
-		var l3length uint64
-		for _, record := range records {
-			switch recordData := record.Data.(type) {
-			case sflow.SampledHeader:
-				// Only process this header if:
-				//  - we don't have a sampled IPv4 header nor a sampled IPv4 header, or
-				//  - we need L2 data and we don't have sampled ethernet header or we don't have extended switch record
-				if !hasSampledIPv4 && !hasSampledIPv6 || !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) && (!hasSampledEthernet || !hasExtendedSwitch) {
-					if l := nd.parseSampledHeader(bf, &recordData); l > 0 {
-						l3length = l
-					}
-				}
-			case sflow.SampledIPv4:
-				bf.SrcAddr = decodeIP(recordData.Base.SrcIP)
-				bf.DstAddr = decodeIP(recordData.Base.DstIP)
-				l3length = uint64(recordData.Base.Length)
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(recordData.Base.Protocol))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(recordData.Base.SrcPort))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(recordData.Base.DstPort))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv4)
-			case sflow.SampledIPv6:
-				bf.SrcAddr = decodeIP(recordData.Base.SrcIP)
-				bf.DstAddr = decodeIP(recordData.Base.DstIP)
-				l3length = uint64(recordData.Base.Length)
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(recordData.Base.Protocol))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(recordData.Base.SrcPort))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(recordData.Base.DstPort))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv6)
-			case sflow.SampledEthernet:
-				if l3length == 0 {
-					// That's the best we can guess.
-					l3length = uint64(recordData.Length) - 16 // (MACs, ethertype, FCS)
-				}
-				if !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) {
-					nd.d.Schema.ProtobufAppendBytes(bf, schema.ColumnSrcMAC, recordData.SrcMac)
-					nd.d.Schema.ProtobufAppendBytes(bf, schema.ColumnDstMAC, recordData.DstMac)
-				}
-			case sflow.ExtendedSwitch:
-				if !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) {
-					if recordData.SrcVlan < 4096 {
-						bf.SrcVlan = uint16(recordData.SrcVlan)
-					}
-					if recordData.DstVlan < 4096 {
-						bf.DstVlan = uint16(recordData.DstVlan)
-					}
+	var l3length uint64
+	for _, record := range records {
+		switch recordData := record.Data.(type) {
+		case sflow.SampledHeader:
+			// Only process this header if:
+			//  - we don't have a sampled IPv4 header nor a sampled IPv4 header, or
+			//  - we need L2 data and we don't have sampled ethernet header or we don't have extended switch record, or
+			//  - we need MPLS labels or IPv6 flow labels
+			//  - we need the inner headers of tunneled packets
+			if !hasSampledIPv4 && !hasSampledIPv6 ||
+				!nd.d.Schema.IsDisabled(schema.ColumnGroupL2) && (!hasSampledEthernet || !hasExtendedSwitch) ||
+				!nd.d.Schema.IsDisabled(schema.ColumnGroupMPLS) ||
+				!nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) ||
+				!nd.d.Schema.IsDisabled(schema.ColumnGroupTunnel) {
+				if l := nd.parseSampledHeader(bf, &recordData); l > 0 {
+					l3length = l
 				}
-			case sflow.ExtendedRouter:
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcNetMask, uint64(recordData.SrcMaskLen))
-				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstNetMask, uint64(recordData.DstMaskLen))
-				bf.NextHop = decodeIP(recordData.NextHop)
-			case sflow.ExtendedGateway:
-				bf.NextHop = decodeIP(recordData.NextHop)
-				bf.DstAS = recordData.AS
-				bf.SrcAS = recordData.AS
-				if recordData.SrcAS > 0 {
-					bf.SrcAS = recordData.SrcAS
+			}
+		case sflow.SampledIPv4:
+			bf.SrcAddr = decodeIP(recordData.Base.SrcIP)
+			bf.DstAddr = decodeIP(recordData.Base.DstIP)
+			l3length = uint64(recordData.Base.Length)
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(recordData.Base.Protocol))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(recordData.Base.SrcPort))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(recordData.Base.DstPort))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv4)
+			if !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
+				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPTos, uint64(recordData.Tos))
+				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnTCPFlags, uint64(recordData.Base.TcpFlags))
+			}
+		case sflow.SampledIPv6:
+			bf.SrcAddr = decodeIP(recordData.Base.SrcIP)
+			bf.DstAddr = decodeIP(recordData.Base.DstIP)
+			l3length = uint64(recordData.Base.Length)
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(recordData.Base.Protocol))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, uint64(recordData.Base.SrcPort))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort, uint64(recordData.Base.DstPort))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnEType, helpers.ETypeIPv6)
+			if !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
+				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPTos, uint64(recordData.Priority))
+				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnTCPFlags, uint64(recordData.Base.TcpFlags))
+			}
+		case sflow.SampledEthernet:
+			if l3length == 0 {
+				// That's the best we can guess.
+				l3length = uint64(recordData.Length) - 16 // (MACs, ethertype, FCS)
+			}
+			if !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) {
+				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcMAC, decodeMAC(recordData.SrcMac))
+				nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstMAC, decodeMAC(recordData.DstMac))
+			}
+		case sflow.ExtendedSwitch:
+			if !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) {
+				if recordData.SrcVlan < 4096 {
+					bf.SrcVlan = uint16(recordData.SrcVlan)
 				}
-				if len(recordData.ASPath) > 0 {
-					bf.DstAS = recordData.ASPath[len(recordData.ASPath)-1]
-					if column, _ := nd.d.Schema.LookupColumnByKey(schema.ColumnDstASPath); !column.Disabled {
-						for _, asn := range recordData.ASPath {
-							column.ProtobufAppendVarint(bf, uint64(asn))
-						}
-					}
+				if recordData.DstVlan < 4096 {
+					bf.DstVlan = uint16(recordData.DstVlan)
 				}
-				bf.GotASPath = true
 			}
+		case sflow.ExtendedRouter:
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcNetMask, uint64(recordData.SrcMaskLen))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstNetMask, uint64(recordData.DstMaskLen))
+			bf.NextHop = decodeIP(recordData.NextHop)
+		case sflow.ExtendedGateway:
+			bf.NextHop = decodeIP(recordData.NextHop)
+			bf.DstAS = recordData.AS
+			bf.SrcAS = recordData.AS
+			if recordData.SrcAS > 0 {
+				bf.SrcAS = recordData.SrcAS
+			}
+			if len(recordData.ASPath) > 0 {
+				bf.DstAS = recordData.ASPath[len(recordData.ASPath)-1]
+				bf.DstASPath = recordData.ASPath
+			}
+			if len(recordData.Communities) > 0 {
+				bf.DstCommunities = recordData.Communities
+			}
+			bf.GotASPath = true
 		}
-
-		if l3length > 0 {
-			nd.d.Schema.ProtobufAppendVarintForce(bf, schema.ColumnBytes, l3length)
-		}
-		flowMessageSet = append(flowMessageSet, bf)
 	}
 
-	return flowMessageSet
+	if l3length > 0 {
+		nd.d.Schema.ProtobufAppendVarintForce(bf, schema.ColumnBytes, l3length)
+	}
 }
 
 func (nd *Decoder) parseSampledHeader(bf *schema.FlowMessage, header *sflow.SampledHeader) uint64 {
@@ -177,6 +211,9 @@ func (nd *Decoder) parseIPv4Header(bf *schema.FlowMessage, data []byte) uint64 {
 	bf.SrcAddr = decodeIP(data[12:16])
 	bf.DstAddr = decodeIP(data[16:20])
 	proto = data[9]
+	if !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPTos, uint64(data[1]))
+	}
 	ihl := int((data[0] & 0xf) * 4)
 	if len(data) >= ihl {
 		data = data[ihl:]
@@ -184,6 +221,7 @@ func (nd *Decoder) parseIPv4Header(bf *schema.FlowMessage, data []byte) uint64 {
 		data = data[:0]
 	}
 	nd.parseTCPUDPHeader(bf, data, proto)
+	decoder.DecodeTunnel(nd.d.Schema, bf, proto, data)
 	return l3length
 }
 
@@ -198,9 +236,17 @@ func (nd *Decoder) parseIPv6Header(bf *schema.FlowMessage, data []byte) uint64 {
 	bf.SrcAddr = decodeIP(data[8:24])
 	bf.DstAddr = decodeIP(data[24:40])
 	proto = data[6]
+	if !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
+		// Version (4 bits), traffic class (8 bits), flow label (20 bits)
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPTos,
+			uint64(binary.BigEndian.Uint16(data[0:2])>>4&0xff))
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnIPv6FlowLabel,
+			uint64(binary.BigEndian.Uint32(data[0:4])&0xfffff))
+	}
 	data = data[40:]
 	nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnProto, uint64(proto))
 	nd.parseTCPUDPHeader(bf, data, proto)
+	decoder.DecodeTunnel(nd.d.Schema, bf, proto, data)
 	return l3length
 }
 
@@ -213,7 +259,26 @@ func (nd *Decoder) parseTCPUDPHeader(bf *schema.FlowMessage, data []byte, proto
 			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstPort,
 				uint64(binary.BigEndian.Uint16(data[2:4])))
 		}
+		if proto == 6 && len(data) >= 14 && !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
+			// NS flag is the last bit of the byte before the other flags
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnTCPFlags,
+				uint64(binary.BigEndian.Uint16(data[12:14])&0x1ff))
+		}
+	} else if (proto == 1 || proto == 58) && !nd.d.Schema.IsDisabled(schema.ColumnGroupL3L4) {
+		if len(data) >= 2 {
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPType, uint64(data[0]))
+			nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnICMPCode, uint64(data[1]))
+		}
+	}
+}
+
+// decodeMAC turns a MAC address into an integer. It returns 0 when the
+// address is not 6-byte long.
+func decodeMAC(b []byte) uint64 {
+	if len(b) != 6 {
+		return 0
 	}
+	return binary.BigEndian.Uint64([]byte{0, 0, b[0], b[1], b[2], b[3], b[4], b[5]})
 }
 
 func (nd *Decoder) parseEthernetHeader(bf *schema.FlowMessage, data []byte) uint64 {
@@ -221,19 +286,18 @@ func (nd *Decoder) parseEthernetHeader(bf *schema.FlowMessage, data []byte) uint
 		return 0
 	}
 	if !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) {
-		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstMAC,
-			binary.BigEndian.Uint64([]byte{0, 0, data[0], data[1], data[2], data[3], data[4], data[5]}))
-		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcMAC,
-			binary.BigEndian.Uint64([]byte{0, 0, data[6], data[7], data[8], data[9], data[10], data[11]}))
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnDstMAC, decodeMAC(data[0:6]))
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcMAC, decodeMAC(data[6:12]))
 	}
 	etherType := data[12:14]
 	data = data[14:]
-	if etherType[0] == 0x81 && etherType[1] == 0x00 {
-		// 802.1q
+	for vlanTags := 0; etherType[0] == 0x81 && etherType[1] == 0x00 ||
+		etherType[0] == 0x88 && etherType[1] == 0xa8; vlanTags++ {
+		// 802.1q or 802.1ad, only keep the outer VLAN
 		if len(data) < 4 {
 			return 0
 		}
-		if !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) {
+		if vlanTags == 0 && !nd.d.Schema.IsDisabled(schema.ColumnGroupL2) {
 			bf.SrcVlan = (uint16(data[0]&0xf) << 8) + uint16(data[1])
 		}
 		etherType = data[2:4]
@@ -241,13 +305,19 @@ func (nd *Decoder) parseEthernetHeader(bf *schema.FlowMessage, data []byte) uint
 	}
 	if etherType[0] == 0x88 && etherType[1] == 0x47 {
 		// MPLS
-		for {
+		mplsLabels := []schema.ColumnKey{
+			schema.ColumnMPLSLabel1, schema.ColumnMPLSLabel2, schema.ColumnMPLSLabel3,
+		}
+		for idx := 0; ; idx++ {
 			if len(data) < 5 {
 				return 0
 			}
 			label := binary.BigEndian.Uint32(append([]byte{0}, data[:3]...)) >> 4
 			bottom := data[2] & 1
 			data = data[4:]
+			if idx < len(mplsLabels) && !nd.d.Schema.IsDisabled(schema.ColumnGroupMPLS) {
+				nd.d.Schema.ProtobufAppendVarint(bf, mplsLabels[idx], uint64(label))
+			}
 			if bottom == 1 || label <= 15 {
 				if data[0]&0xf0>>4 == 4 {
 					etherType = []byte{0x8, 0x0}
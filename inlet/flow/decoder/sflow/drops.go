@@ -0,0 +1,237 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package sflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net/netip"
+	"strconv"
+
+	"github.com/netsampler/goflow2/decoders/sflow"
+
+	"akvorado/common/schema"
+)
+
+// formatDiscardedPacket is the format of samples for discarded packets (sFlow
+// drop notifications, enterprise 0, format 5).
+const formatDiscardedPacket = 5
+
+// dropReasons maps the reasons of discarded packets to their names. Reasons
+// below 256 are ICMP unreachable codes.
+var dropReasons = map[uint32]string{
+	0:   "net_unreachable",
+	1:   "host_unreachable",
+	2:   "protocol_unreachable",
+	3:   "port_unreachable",
+	4:   "frag_needed",
+	5:   "src_route_failed",
+	6:   "dst_net_unknown",
+	7:   "dst_host_unknown",
+	8:   "src_host_isolated",
+	9:   "dst_net_prohibited",
+	10:  "dst_host_prohibited",
+	11:  "dst_net_tos_unreachable",
+	12:  "dst_host_tos_unreachable",
+	13:  "comm_prohibited",
+	14:  "host_precedence_violation",
+	15:  "precedence_cutoff",
+	256: "unknown",
+	257: "ttl_exceeded",
+	258: "acl",
+	259: "no_buffer_space",
+	260: "red",
+	261: "traffic_shaping",
+	262: "pkt_too_big",
+	263: "src_mac_is_multicast",
+	264: "vlan_tag_mismatch",
+	265: "ingress_vlan_filter",
+	266: "ingress_spanning_tree_filter",
+	267: "port_list_is_empty",
+	268: "port_loopback_filter",
+	269: "blackhole_route",
+	270: "non_ip",
+	271: "uc_dip_over_mc_dmac",
+	272: "dip_is_loopback_address",
+	273: "sip_is_mc",
+	274: "sip_is_loopback_address",
+	275: "ip_header_corrupted",
+	276: "ipv4_sip_is_limited_bc",
+	277: "ipv6_mc_dip_reserved_scope",
+	278: "ipv6_mc_dip_interface_local_scope",
+	279: "unresolved_neigh",
+	280: "mc_reverse_path_forwarding",
+	281: "non_routable_packet",
+	282: "decap_error",
+	283: "overlay_smac_is_mc",
+	284: "unknown_l2",
+	285: "unknown_l3",
+	286: "unknown_l3_exception",
+	287: "unknown_buffer",
+	288: "unknown_tunnel",
+	289: "unknown_l4",
+	290: "sip_is_unspecified",
+	291: "mlag_port_isolation",
+	292: "blackhole_arp_neigh",
+	293: "src_mac_is_dmac",
+	294: "dmac_is_reserved",
+	295: "sip_is_class_e",
+	296: "mc_dmac_mismatch",
+	297: "sip_is_dip",
+	298: "dip_is_local_network",
+	299: "dip_is_link_local",
+	300: "overlay_smac_is_dmac",
+	301: "egress_vlan_filter",
+	302: "uc_reverse_path_forwarding",
+	303: "split_horizon",
+}
+
+// dropReasonName returns the name of a drop reason.
+func dropReasonName(reason uint32) string {
+	if name, ok := dropReasons[reason]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(reason), 10)
+}
+
+// discardedPacket is a sample describing a discarded packet.
+type discardedPacket struct {
+	Drops   uint32 // discarded packets not reported due to rate limiting
+	Input   uint32
+	Output  uint32
+	Reason  uint32
+	Records []sflow.FlowRecord
+}
+
+var errTruncatedDatagram = errors.New("truncated sFlow datagram")
+
+// decodeDiscardedPackets extracts the discarded packet samples from an sFlow
+// datagram. They are not handled by the sFlow decoder from goflow2.
+func decodeDiscardedPackets(payload []byte) (netip.Addr, []discardedPacket, error) {
+	var agent netip.Addr
+	buf := bytes.NewBuffer(payload)
+	next := func() (uint32, error) {
+		b := buf.Next(4)
+		if len(b) < 4 {
+			return 0, errTruncatedDatagram
+		}
+		return binary.BigEndian.Uint32(b), nil
+	}
+	version, err := next()
+	if err != nil {
+		return agent, nil, err
+	}
+	if version != 5 {
+		return agent, nil, sflow.NewErrorVersion(version)
+	}
+	ipVersion, err := next()
+	if err != nil {
+		return agent, nil, err
+	}
+	var agentLength int
+	switch ipVersion {
+	case 1:
+		agentLength = 4
+	case 2:
+		agentLength = 16
+	default:
+		return agent, nil, sflow.NewErrorIPVersion(ipVersion)
+	}
+	agentIP := buf.Next(agentLength)
+	if len(agentIP) < agentLength {
+		return agent, nil, errTruncatedDatagram
+	}
+	agent = decodeIP(agentIP)
+	// Sub-agent ID, sequence number, uptime
+	if len(buf.Next(12)) < 12 {
+		return agent, nil, errTruncatedDatagram
+	}
+	samplesCount, err := next()
+	if err != nil {
+		return agent, nil, err
+	}
+
+	result := []discardedPacket{}
+	for i := uint32(0); i < samplesCount; i++ {
+		format, err := next()
+		if err != nil {
+			return agent, nil, err
+		}
+		length, err := next()
+		if err != nil {
+			return agent, nil, err
+		}
+		sample := buf.Next(int(length))
+		if len(sample) < int(length) {
+			return agent, nil, errTruncatedDatagram
+		}
+		if format != formatDiscardedPacket {
+			continue
+		}
+		dp, err := decodeDiscardedPacket(sample)
+		if err != nil {
+			return agent, nil, err
+		}
+		result = append(result, dp)
+	}
+	return agent, result, nil
+}
+
+// decodeDiscardedPacket decodes a discarded packet sample.
+func decodeDiscardedPacket(sample []byte) (discardedPacket, error) {
+	var dp discardedPacket
+	// Sequence number, source ID type and index, drops, input, output,
+	// reason, and number of records
+	if len(sample) < 32 {
+		return dp, errTruncatedDatagram
+	}
+	dp.Drops = binary.BigEndian.Uint32(sample[12:16])
+	dp.Input = binary.BigEndian.Uint32(sample[16:20])
+	dp.Output = binary.BigEndian.Uint32(sample[20:24])
+	dp.Reason = binary.BigEndian.Uint32(sample[24:28])
+	recordsCount := binary.BigEndian.Uint32(sample[28:32])
+	buf := bytes.NewBuffer(sample[32:])
+	for i := uint32(0); i < recordsCount && buf.Len() >= 8; i++ {
+		header := sflow.RecordHeader{
+			DataFormat: binary.BigEndian.Uint32(buf.Next(4)),
+			Length:     binary.BigEndian.Uint32(buf.Next(4)),
+		}
+		if int(header.Length) > buf.Len() {
+			return dp, errTruncatedDatagram
+		}
+		record, err := sflow.DecodeFlowRecord(&header, bytes.NewBuffer(buf.Next(int(header.Length))))
+		if err != nil {
+			// Unknown records are ignored
+			continue
+		}
+		dp.Records = append(dp.Records, record)
+	}
+	return dp, nil
+}
+
+// decodeDrops decodes the discarded packets into flows. They get a dropped
+// forwarding status and the reason of the drop.
+func (nd *Decoder) decodeDrops(agent netip.Addr, samples []discardedPacket) []*schema.FlowMessage {
+	flowMessageSet := make([]*schema.FlowMessage, 0, len(samples))
+	for _, sample := range samples {
+		bf := schema.NewFlowMessage()
+		bf.ExporterAddress = agent
+		bf.SamplingRate = 1
+		bf.InIf = sample.Input
+		bf.OutIf = sample.Output
+		if bf.InIf == interfaceLocal {
+			bf.InIf = 0
+		}
+		if bf.OutIf == interfaceLocal {
+			bf.OutIf = 0
+		}
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnPackets, 1)
+		nd.d.Schema.ProtobufAppendVarint(bf, schema.ColumnForwardingStatus, 128)
+		nd.d.Schema.ProtobufAppendBytes(bf, schema.ColumnDropReason, []byte(dropReasonName(sample.Reason)))
+		nd.decodeRecords(bf, sample.Records)
+		flowMessageSet = append(flowMessageSet, bf)
+	}
+	return flowMessageSet
+}
@@ -25,12 +25,22 @@ const (
 	interfaceOutDiscard = 0x40000000
 	// interfaceOutMultiple is used when there are multiple output interfaces
 	interfaceOutMultiple = 0x80000000
+	// interfaceFormatIndex is the format of an expanded interface when
+	// the value is an interface index
+	interfaceFormatIndex = 0
+	// interfaceFormatDiscard is the format of an expanded output interface
+	// when the traffic is discarded
+	interfaceFormatDiscard = 1
+	// interfaceFormatMultiple is the format of an expanded output
+	// interface when there are multiple output interfaces
+	interfaceFormatMultiple = 2
 )
 
 // Decoder contains the state for the sFlow v5 decoder.
 type Decoder struct {
-	r *reporter.Reporter
-	d decoder.Dependencies
+	r      *reporter.Reporter
+	d      decoder.Dependencies
+	config decoder.Configuration
 
 	metrics struct {
 		errors                *reporter.CounterVec
@@ -38,13 +48,23 @@ type Decoder struct {
 		sampleRecordsStatsSum *reporter.CounterVec
 		sampleStatsSum        *reporter.CounterVec
 	}
+	interfaceMetrics interfaceMetrics
+}
+
+func init() {
+	decoder.Register(decoder.Registration{
+		Name:       "sflow",
+		APIVersion: decoder.APIVersion,
+		New:        New,
+	})
 }
 
 // New instantiates a new sFlow decoder.
-func New(r *reporter.Reporter, dependencies decoder.Dependencies) decoder.Decoder {
+func New(r *reporter.Reporter, configuration decoder.Configuration, dependencies decoder.Dependencies) decoder.Decoder {
 	nd := &Decoder{
-		r: r,
-		d: dependencies,
+		r:      r,
+		d:      dependencies,
+		config: configuration,
 	}
 
 	nd.metrics.errors = nd.r.CounterVec(
@@ -75,6 +95,9 @@ func New(r *reporter.Reporter, dependencies decoder.Dependencies) decoder.Decode
 		},
 		[]string{"exporter", "agent", "version", "type"},
 	)
+	if configuration.InterfaceCounters {
+		nd.interfaceMetrics = newInterfaceMetrics(r)
+	}
 
 	return nd
 }
@@ -127,10 +150,27 @@ func (nd *Decoder) Decode(in decoder.RawFlow) []*schema.FlowMessage {
 				Inc()
 			nd.metrics.sampleRecordsStatsSum.WithLabelValues(key, agent, version, "CounterSample").
 				Add(float64(len(sConv.Records)))
+			if nd.config.InterfaceCounters {
+				nd.recordCounters(key, agent, sConv)
+			}
 		}
 	}
 
 	flowMessageSet := nd.decode(msgDec)
+	if nd.config.DropNotifications {
+		agentIP, samples, err := decodeDiscardedPackets(in.Payload)
+		if err != nil {
+			nd.metrics.errors.WithLabelValues(key, "error decoding discarded packets").Inc()
+		} else if len(samples) > 0 {
+			nd.metrics.sampleStatsSum.WithLabelValues(key, agent, version, "DiscardedPacket").
+				Add(float64(len(samples)))
+			for _, sample := range samples {
+				nd.metrics.sampleRecordsStatsSum.WithLabelValues(key, agent, version, "DiscardedPacket").
+					Add(float64(len(sample.Records)))
+			}
+			flowMessageSet = append(flowMessageSet, nd.decodeDrops(agentIP, samples)...)
+		}
+	}
 	for _, fmsg := range flowMessageSet {
 		fmsg.TimeReceived = ts
 	}
@@ -4,6 +4,8 @@
 package sflow
 
 import (
+	"bytes"
+	"encoding/binary"
 	"net"
 	"net/netip"
 	"path/filepath"
@@ -13,11 +15,13 @@ import (
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
+
+	"github.com/netsampler/goflow2/decoders/sflow"
 )
 
 func TestDecode(t *testing.T) {
 	r := reporter.NewMock(t)
-	sdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()})
+	sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()})
 
 	// Send data
 	data := helpers.ReadPcapPayload(t, filepath.Join("testdata", "data-1140.pcap"))
@@ -36,14 +40,17 @@ func TestDecode(t *testing.T) {
 			DstAddr:         netip.MustParseAddr("2a0c:8880:2:0:185:21:130:39"),
 			ExporterAddress: netip.MustParseAddr("::ffff:172.16.0.3"),
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
-				schema.ColumnBytes:   1500,
-				schema.ColumnPackets: 1,
-				schema.ColumnEType:   helpers.ETypeIPv6,
-				schema.ColumnProto:   6,
-				schema.ColumnSrcPort: 46026,
-				schema.ColumnDstPort: 22,
-				schema.ColumnSrcMAC:  40057391053392,
-				schema.ColumnDstMAC:  40057381862408,
+				schema.ColumnBytes:         1500,
+				schema.ColumnPackets:       1,
+				schema.ColumnEType:         helpers.ETypeIPv6,
+				schema.ColumnProto:         6,
+				schema.ColumnSrcPort:       46026,
+				schema.ColumnDstPort:       22,
+				schema.ColumnSrcMAC:        40057391053392,
+				schema.ColumnDstMAC:        40057381862408,
+				schema.ColumnIPTos:         8,
+				schema.ColumnIPv6FlowLabel: 426132,
+				schema.ColumnTCPFlags:      16,
 			},
 		}, {
 			SamplingRate:    1024,
@@ -68,6 +75,7 @@ func TestDecode(t *testing.T) {
 				schema.ColumnDstNetMask: 27,
 				schema.ColumnSrcMAC:     216372595274807,
 				schema.ColumnDstMAC:     191421060163210,
+				schema.ColumnTCPFlags:   24,
 			},
 		}, {
 			SamplingRate:    1024,
@@ -79,14 +87,17 @@ func TestDecode(t *testing.T) {
 			SrcVlan:         100,
 			DstVlan:         100,
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
-				schema.ColumnBytes:   1500,
-				schema.ColumnPackets: 1,
-				schema.ColumnEType:   helpers.ETypeIPv6,
-				schema.ColumnProto:   6,
-				schema.ColumnSrcPort: 46026,
-				schema.ColumnDstPort: 22,
-				schema.ColumnSrcMAC:  40057391053392,
-				schema.ColumnDstMAC:  40057381862408,
+				schema.ColumnBytes:         1500,
+				schema.ColumnPackets:       1,
+				schema.ColumnEType:         helpers.ETypeIPv6,
+				schema.ColumnProto:         6,
+				schema.ColumnSrcPort:       46026,
+				schema.ColumnDstPort:       22,
+				schema.ColumnSrcMAC:        40057391053392,
+				schema.ColumnDstMAC:        40057381862408,
+				schema.ColumnIPTos:         8,
+				schema.ColumnIPv6FlowLabel: 426132,
+				schema.ColumnTCPFlags:      16,
 			},
 		}, {
 			SamplingRate:    1024,
@@ -100,6 +111,8 @@ func TestDecode(t *testing.T) {
 			ExporterAddress: netip.MustParseAddr("::ffff:172.16.0.3"),
 			NextHop:         netip.MustParseAddr("::ffff:31.14.69.110"),
 			GotASPath:       true,
+			DstASPath:       []uint32{203698, 6762, 26615},
+			DstCommunities:  []uint32{2583495656, 2583495657, 4259880000, 4259880001, 4259900001},
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
 				schema.ColumnBytes:      40,
 				schema.ColumnPackets:    1,
@@ -111,7 +124,7 @@ func TestDecode(t *testing.T) {
 				schema.ColumnDstNetMask: 17,
 				schema.ColumnSrcMAC:     138617863011056,
 				schema.ColumnDstMAC:     216372595274807,
-				schema.ColumnDstASPath:  []uint32{203698, 6762, 26615},
+				schema.ColumnTCPFlags:   2,
 			},
 		}, {
 			SamplingRate:    1024,
@@ -123,14 +136,17 @@ func TestDecode(t *testing.T) {
 			SrcVlan:         100,
 			DstVlan:         100,
 			ProtobufDebug: map[schema.ColumnKey]interface{}{
-				schema.ColumnBytes:   1500,
-				schema.ColumnPackets: 1,
-				schema.ColumnEType:   helpers.ETypeIPv6,
-				schema.ColumnProto:   6,
-				schema.ColumnSrcPort: 46026,
-				schema.ColumnDstPort: 22,
-				schema.ColumnSrcMAC:  40057391053392,
-				schema.ColumnDstMAC:  40057381862408,
+				schema.ColumnBytes:         1500,
+				schema.ColumnPackets:       1,
+				schema.ColumnEType:         helpers.ETypeIPv6,
+				schema.ColumnProto:         6,
+				schema.ColumnSrcPort:       46026,
+				schema.ColumnDstPort:       22,
+				schema.ColumnSrcMAC:        40057391053392,
+				schema.ColumnDstMAC:        40057381862408,
+				schema.ColumnIPTos:         8,
+				schema.ColumnIPv6FlowLabel: 426132,
+				schema.ColumnTCPFlags:      16,
 			},
 		},
 	}
@@ -158,7 +174,7 @@ func TestDecode(t *testing.T) {
 
 func TestDecodeInterface(t *testing.T) {
 	r := reporter.NewMock(t)
-	sdecoder := New(r, decoder.Dependencies{Schema: schema.NewMock(t)})
+	sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)})
 
 	t.Run("local interface", func(t *testing.T) {
 		// Send data
@@ -282,6 +298,8 @@ func TestDecodeInterface(t *testing.T) {
 				SrcAS:           203476,
 				DstAS:           203361,
 				GotASPath:       true,
+				DstASPath:       []uint32{8218, 29605, 203361},
+				DstCommunities:  []uint32{538574949, 1911619684, 1911669584, 1911671290},
 				ProtobufDebug: map[schema.ColumnKey]interface{}{
 					schema.ColumnBytes:      104,
 					schema.ColumnPackets:    1,
@@ -291,7 +309,6 @@ func TestDecodeInterface(t *testing.T) {
 					schema.ColumnDstPort:    52237,
 					schema.ColumnSrcNetMask: 32,
 					schema.ColumnDstNetMask: 22,
-					schema.ColumnDstASPath:  []uint32{8218, 29605, 203361},
 				},
 			},
 		}
@@ -386,3 +403,488 @@ func TestDecodeInterface(t *testing.T) {
 
 	})
 }
+
+func TestDecodeExpandedInterfaceFormat(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)}).(*Decoder)
+	sample := func(inFormat, inValue, outFormat, outValue uint32) sflow.ExpandedFlowSample {
+		return sflow.ExpandedFlowSample{
+			SamplingRate:   1000,
+			InputIfFormat:  inFormat,
+			InputIfValue:   inValue,
+			OutputIfFormat: outFormat,
+			OutputIfValue:  outValue,
+		}
+	}
+	got := sdecoder.decode(sflow.Packet{
+		AgentIP: net.ParseIP("192.0.2.1").To4(),
+		Samples: []interface{}{
+			sample(0, 10, 0, 20), // regular interfaces
+			sample(0, 10, 1, 3),  // discarded, reason is 3
+			sample(0, 10, 2, 4),  // multiple output interfaces
+			sample(0, 10, 0, interfaceLocal),
+		},
+	})
+	expectedFlows := []*schema.FlowMessage{
+		{
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+			InIf:            10,
+			OutIf:           20,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnPackets: 1,
+			},
+		}, {
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+			InIf:            10,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnPackets:          1,
+				schema.ColumnForwardingStatus: 128,
+			},
+		}, {
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+			InIf:            10,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnPackets: 1,
+			},
+		}, {
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+			InIf:            10,
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnPackets: 1,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeMPLS(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+	header := []byte{
+		// Ethernet
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x11, 0x22, 0x33, 0x44, 0x66, 0x88, 0x47,
+		// MPLS: label 16004, then label 24001 with bottom of stack
+		0x03, 0xe8, 0x40, 0x40,
+		0x05, 0xdc, 0x11, 0x40,
+		// IPv4 (ICMP)
+		0x45, 0x00, 0x00, 0x54, 0x00, 0x00, 0x40, 0x00, 0x40, 0x01, 0x00, 0x00,
+		192, 0, 2, 1,
+		192, 0, 2, 2,
+	}
+	got := sdecoder.decode(sflow.Packet{
+		AgentIP: net.ParseIP("192.0.2.100").To4(),
+		Samples: []interface{}{
+			sflow.FlowSample{
+				SamplingRate: 1000,
+				Input:        10,
+				Output:       20,
+				Records: []sflow.FlowRecord{
+					{Data: sflow.SampledHeader{Protocol: 1, HeaderData: header}},
+				},
+			},
+		},
+	})
+	expectedFlows := []*schema.FlowMessage{
+		{
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			InIf:            10,
+			OutIf:           20,
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:      84,
+				schema.ColumnPackets:    1,
+				schema.ColumnEType:      helpers.ETypeIPv4,
+				schema.ColumnProto:      1,
+				schema.ColumnSrcMAC:     0x1122334466,
+				schema.ColumnDstMAC:     0x1122334455,
+				schema.ColumnMPLSLabel1: 16004,
+				schema.ColumnMPLSLabel2: 24001,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeQinQ(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+	header := []byte{
+		// Ethernet
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x11, 0x22, 0x33, 0x44, 0x66, 0x88, 0xa8,
+		// 802.1ad service tag (VLAN 300), then 802.1q customer tag (VLAN 10)
+		0x01, 0x2c, 0x81, 0x00,
+		0x00, 0x0a, 0x08, 0x00,
+		// IPv4 (ICMP)
+		0x45, 0x00, 0x00, 0x54, 0x00, 0x00, 0x40, 0x00, 0x40, 0x01, 0x00, 0x00,
+		192, 0, 2, 1,
+		192, 0, 2, 2,
+	}
+	got := sdecoder.decode(sflow.Packet{
+		AgentIP: net.ParseIP("192.0.2.100").To4(),
+		Samples: []interface{}{
+			sflow.FlowSample{
+				SamplingRate: 1000,
+				Records: []sflow.FlowRecord{
+					{Data: sflow.SampledHeader{Protocol: 1, HeaderData: header}},
+				},
+			},
+		},
+	})
+	expectedFlows := []*schema.FlowMessage{
+		{
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			SrcVlan:         300,
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   84,
+				schema.ColumnPackets: 1,
+				schema.ColumnEType:   helpers.ETypeIPv4,
+				schema.ColumnProto:   1,
+				schema.ColumnSrcMAC:  0x1122334466,
+				schema.ColumnDstMAC:  0x1122334455,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeSampledEthernet(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+	got := sdecoder.decode(sflow.Packet{
+		AgentIP: net.ParseIP("192.0.2.100").To4(),
+		Samples: []interface{}{
+			sflow.FlowSample{
+				SamplingRate: 1000,
+				Records: []sflow.FlowRecord{
+					{Data: sflow.SampledEthernet{
+						Length: 1516,
+						SrcMac: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x66},
+						DstMac: []byte{0x00, 0x11, 0x22, 0x33, 0x44, 0x55},
+					}},
+				},
+			},
+		},
+	})
+	expectedFlows := []*schema.FlowMessage{
+		{
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:   1500,
+				schema.ColumnPackets: 1,
+				schema.ColumnSrcMAC:  0x1122334466,
+				schema.ColumnDstMAC:  0x1122334455,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeIPv6TrafficClass(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+	header := []byte{
+		// Ethernet
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x11, 0x22, 0x33, 0x44, 0x66, 0x86, 0xdd,
+		// IPv6: traffic class 0xb8 (EF), flow label 0x12345, ICMPv6
+		0x6b, 0x81, 0x23, 0x45, 0x00, 0x08, 0x3a, 0x40,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1,
+		0x20, 0x01, 0x0d, 0xb8, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 2,
+	}
+	got := sdecoder.decode(sflow.Packet{
+		AgentIP: net.ParseIP("192.0.2.100").To4(),
+		Samples: []interface{}{
+			sflow.FlowSample{
+				SamplingRate: 1000,
+				Records: []sflow.FlowRecord{
+					{Data: sflow.SampledHeader{Protocol: 1, HeaderData: header}},
+				},
+			},
+		},
+	})
+	expectedFlows := []*schema.FlowMessage{
+		{
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			SrcAddr:         netip.MustParseAddr("2001:db8::1"),
+			DstAddr:         netip.MustParseAddr("2001:db8::2"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:         48,
+				schema.ColumnPackets:       1,
+				schema.ColumnEType:         helpers.ETypeIPv6,
+				schema.ColumnProto:         58,
+				schema.ColumnSrcMAC:        0x1122334466,
+				schema.ColumnDstMAC:        0x1122334455,
+				schema.ColumnIPTos:         0xb8,
+				schema.ColumnIPv6FlowLabel: 0x12345,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestInterfaceCounters(t *testing.T) {
+	sample := sflow.CounterSample{
+		Records: []sflow.CounterRecord{
+			{Data: sflow.IfCounters{
+				IfIndex:           10,
+				IfSpeed:           10_000_000_000,
+				IfStatus:          3,
+				IfInOctets:        1_000_000,
+				IfInUcastPkts:     900,
+				IfInMulticastPkts: 90,
+				IfInBroadcastPkts: 10,
+				IfInErrors:        2,
+				IfInDiscards:      1,
+				IfOutOctets:       2_000_000,
+				IfOutUcastPkts:    1800,
+				IfOutErrors:       4,
+				IfOutDiscards:     3,
+			}},
+			{Data: sflow.EthernetCounters{}},
+		},
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)}).(*Decoder)
+		if sdecoder.interfaceMetrics.speed != nil {
+			t.Fatal("New() registered interface metrics while disabled")
+		}
+		gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_sflow_", "interface_")
+		if diff := helpers.Diff(gotMetrics, map[string]string{}); diff != "" {
+			t.Fatalf("Metrics (-got, +want):\n%s", diff)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		config := decoder.DefaultConfiguration()
+		config.InterfaceCounters = true
+		sdecoder := New(r, config, decoder.Dependencies{Schema: schema.NewMock(t)}).(*Decoder)
+		sdecoder.recordCounters("127.0.0.1", "192.0.2.1", sample)
+		gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_sflow_", "interface_")
+		labels := `{agent="192.0.2.1",exporter="127.0.0.1",ifindex="10"}`
+		expectedMetrics := map[string]string{
+			`interface_speed_bps` + labels:    "1e+10",
+			`interface_up` + labels:           "1",
+			`interface_in_octets` + labels:    "1e+06",
+			`interface_out_octets` + labels:   "2e+06",
+			`interface_in_packets` + labels:   "1000",
+			`interface_out_packets` + labels:  "1800",
+			`interface_in_errors` + labels:    "2",
+			`interface_out_errors` + labels:   "4",
+			`interface_in_discards` + labels:  "1",
+			`interface_out_discards` + labels: "3",
+		}
+		if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+			t.Fatalf("Metrics (-got, +want):\n%s", diff)
+		}
+	})
+}
+
+func TestDecodeICMP(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+	header := []byte{
+		// Ethernet
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x11, 0x22, 0x33, 0x44, 0x66, 0x08, 0x00,
+		// IPv4 (ICMP)
+		0x45, 0x00, 0x00, 0x54, 0x00, 0x00, 0x40, 0x00, 0x40, 0x01, 0x00, 0x00,
+		192, 0, 2, 1,
+		192, 0, 2, 2,
+		// ICMP: time exceeded, fragment reassembly time exceeded
+		0x0b, 0x01, 0x00, 0x00,
+	}
+	got := sdecoder.decode(sflow.Packet{
+		AgentIP: net.ParseIP("192.0.2.100").To4(),
+		Samples: []interface{}{
+			sflow.FlowSample{
+				SamplingRate: 1000,
+				Records: []sflow.FlowRecord{
+					{Data: sflow.SampledHeader{Protocol: 1, HeaderData: header}},
+				},
+			},
+		},
+	})
+	expectedFlows := []*schema.FlowMessage{
+		{
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:    84,
+				schema.ColumnPackets:  1,
+				schema.ColumnEType:    helpers.ETypeIPv4,
+				schema.ColumnProto:    1,
+				schema.ColumnSrcMAC:   0x1122334466,
+				schema.ColumnDstMAC:   0x1122334455,
+				schema.ColumnICMPType: 11,
+				schema.ColumnICMPCode: 1,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeTunnel(t *testing.T) {
+	r := reporter.NewMock(t)
+	sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()}).(*Decoder)
+	header := []byte{
+		// IPv4 (GRE)
+		0x45, 0x00, 0x00, 0x3c, 0x00, 0x00, 0x40, 0x00, 0x40, 0x2f, 0x00, 0x00,
+		192, 0, 2, 1,
+		192, 0, 2, 2,
+		// GRE (IPv4)
+		0x00, 0x00, 0x08, 0x00,
+		// IPv4 (UDP)
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00,
+		10, 0, 0, 1,
+		10, 0, 0, 2,
+	}
+	got := sdecoder.decode(sflow.Packet{
+		AgentIP: net.ParseIP("192.0.2.100").To4(),
+		Samples: []interface{}{
+			sflow.FlowSample{
+				SamplingRate: 1000,
+				Records: []sflow.FlowRecord{
+					{Data: sflow.SampledHeader{Protocol: 11, HeaderData: header}},
+				},
+			},
+		},
+	})
+	expectedFlows := []*schema.FlowMessage{
+		{
+			SamplingRate:    1000,
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+			SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:        60,
+				schema.ColumnPackets:      1,
+				schema.ColumnEType:        helpers.ETypeIPv4,
+				schema.ColumnProto:        47,
+				schema.ColumnTunnelType:   []byte("gre"),
+				schema.ColumnSrcAddrInner: netip.MustParseAddr("::ffff:10.0.0.1"),
+				schema.ColumnDstAddrInner: netip.MustParseAddr("::ffff:10.0.0.2"),
+				schema.ColumnProtoInner:   17,
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expectedFlows); diff != "" {
+		t.Fatalf("decode() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDecodeDropNotifications(t *testing.T) {
+	header := []byte{
+		// IPv4 (UDP)
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x40, 0x00, 0x40, 0x11, 0x00, 0x00,
+		192, 0, 2, 1,
+		192, 0, 2, 2,
+		// UDP
+		0x30, 0x39, 0x00, 0x35, 0x00, 0x14, 0x00, 0x00,
+	}
+	record := new(bytes.Buffer)
+	binary.Write(record, binary.BigEndian, []uint32{
+		11, // protocol
+		40, // frame length
+		0,  // stripped
+		uint32(len(header)),
+	})
+	record.Write(header)
+	sample := new(bytes.Buffer)
+	binary.Write(sample, binary.BigEndian, []uint32{
+		1,     // sequence number
+		0, 10, // source ID
+		3,   // drops
+		10,  // input
+		0,   // output
+		258, // reason (ACL)
+		1,   // records
+		1, uint32(record.Len()),
+	})
+	sample.Write(record.Bytes())
+	datagram := new(bytes.Buffer)
+	binary.Write(datagram, binary.BigEndian, []uint32{
+		5,          // version
+		1,          // IPv4
+		0xc0000264, // agent (192.0.2.100)
+		0, 1, 0,    // sub-agent, sequence number, uptime
+		1, // samples
+		5, uint32(sample.Len()),
+	})
+	datagram.Write(sample.Bytes())
+
+	t.Run("disabled", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		sdecoder := New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: schema.NewMock(t)})
+		got := sdecoder.Decode(decoder.RawFlow{Payload: datagram.Bytes(), Source: net.ParseIP("127.0.0.1")})
+		if diff := helpers.Diff(got, []*schema.FlowMessage{}); diff != "" {
+			t.Fatalf("Decode() (-got, +want):\n%s", diff)
+		}
+	})
+
+	t.Run("enabled", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		config := decoder.DefaultConfiguration()
+		config.DropNotifications = true
+		sdecoder := New(r, config, decoder.Dependencies{Schema: schema.NewMock(t).EnableAllColumns()})
+		got := sdecoder.Decode(decoder.RawFlow{Payload: datagram.Bytes(), Source: net.ParseIP("127.0.0.1")})
+		for _, f := range got {
+			f.TimeReceived = 0
+		}
+		expectedFlows := []*schema.FlowMessage{
+			{
+				SamplingRate:    1,
+				ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.100"),
+				InIf:            10,
+				SrcAddr:         netip.MustParseAddr("::ffff:192.0.2.1"),
+				DstAddr:         netip.MustParseAddr("::ffff:192.0.2.2"),
+				ProtobufDebug: map[schema.ColumnKey]interface{}{
+					schema.ColumnBytes:            40,
+					schema.ColumnPackets:          1,
+					schema.ColumnEType:            helpers.ETypeIPv4,
+					schema.ColumnProto:            17,
+					schema.ColumnSrcPort:          12345,
+					schema.ColumnDstPort:          53,
+					schema.ColumnForwardingStatus: 128,
+					schema.ColumnDropReason:       []byte("acl"),
+				},
+			},
+		}
+		if diff := helpers.Diff(got, expectedFlows); diff != "" {
+			t.Fatalf("Decode() (-got, +want):\n%s", diff)
+		}
+		gotMetrics := r.GetMetrics("akvorado_inlet_flow_decoder_sflow_", "sample_")
+		expectedMetrics := map[string]string{
+			`sample_records_sum{agent="192.0.2.100",exporter="127.0.0.1",type="DiscardedPacket",version="5"}`: "1",
+			`sample_sum{agent="192.0.2.100",exporter="127.0.0.1",type="DiscardedPacket",version="5"}`:         "1",
+		}
+		if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+			t.Fatalf("Metrics (-got, +want):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,235 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package decoder
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"akvorado/common/schema"
+)
+
+// UDP ports used by UDP-based tunnels.
+const (
+	gtpUserPort = 2152
+	vxlanPort   = 4789
+	genevePort  = 6081
+)
+
+// DecodeTunnel decodes the inner IP header of a tunneled packet (GRE,
+// IP-in-IP, GTP-U, VXLAN, or Geneve). proto is the protocol of the outer IP header and data
+// is its payload. It returns true if a tunnel was detected.
+func DecodeTunnel(sch *schema.Component, bf *schema.FlowMessage, proto uint8, data []byte) bool {
+	if sch.IsDisabled(schema.ColumnGroupTunnel) {
+		return false
+	}
+	var (
+		tunnelType string
+		vni        uint32
+		hasVNI     bool
+	)
+	switch proto {
+	case 4, 41: // IPv4 or IPv6 encapsulation
+		tunnelType = "ipip"
+	case 47: // GRE
+		tunnelType = "gre"
+		data = decodeGREHeader(data)
+	case 17: // UDP
+		if len(data) < 8 {
+			return false
+		}
+		switch binary.BigEndian.Uint16(data[2:4]) {
+		case gtpUserPort:
+			tunnelType = "gtp"
+			data = decodeGTPUHeader(data[8:])
+		case vxlanPort:
+			tunnelType = "vxlan"
+			data, vni = decodeVXLANHeader(data[8:])
+			hasVNI = true
+		case genevePort:
+			tunnelType = "geneve"
+			data, vni = decodeGeneveHeader(data[8:])
+			hasVNI = true
+		default:
+			return false
+		}
+	default:
+		return false
+	}
+	if !decodeInnerIPHeader(sch, bf, data) {
+		return false
+	}
+	sch.ProtobufAppendBytes(bf, schema.ColumnTunnelType, []byte(tunnelType))
+	if hasVNI {
+		sch.ProtobufAppendVarint(bf, schema.ColumnTunnelVNI, uint64(vni))
+	}
+	return true
+}
+
+// DecodeOuterIPHeader returns the protocol and the payload of an IPv4 or
+// IPv6 packet. IPv6 extension headers are not skipped.
+func DecodeOuterIPHeader(data []byte) (uint8, []byte) {
+	if len(data) < 1 {
+		return 0, nil
+	}
+	switch data[0] >> 4 {
+	case 4:
+		ihl := int(data[0]&0xf) * 4
+		if len(data) < 20 || len(data) < ihl {
+			return 0, nil
+		}
+		return data[9], data[ihl:]
+	case 6:
+		if len(data) < 40 {
+			return 0, nil
+		}
+		return data[6], data[40:]
+	}
+	return 0, nil
+}
+
+// decodeGREHeader returns the payload of a GRE packet carrying IPv4 or
+// IPv6. It returns nil for other payloads.
+func decodeGREHeader(data []byte) []byte {
+	if len(data) < 4 {
+		return nil
+	}
+	flags := data[0]
+	etherType := binary.BigEndian.Uint16(data[2:4])
+	if etherType != 0x0800 && etherType != 0x86dd {
+		return nil
+	}
+	length := 4
+	if flags&0x80 != 0 { // checksum
+		length += 4
+	}
+	if flags&0x20 != 0 { // key
+		length += 4
+	}
+	if flags&0x10 != 0 { // sequence number
+		length += 4
+	}
+	if len(data) < length {
+		return nil
+	}
+	return data[length:]
+}
+
+// decodeGTPUHeader returns the payload of a GTP-U G-PDU. It returns nil for
+// other messages.
+func decodeGTPUHeader(data []byte) []byte {
+	if len(data) < 8 {
+		return nil
+	}
+	flags := data[0]
+	if flags>>5 != 1 || data[1] != 0xff { // version 1, G-PDU
+		return nil
+	}
+	length := 8
+	if flags&0x07 != 0 { // sequence number, N-PDU number, or extension header
+		length += 4
+		if len(data) < length {
+			return nil
+		}
+		nextHeader := data[length-1]
+		for flags&0x04 != 0 && nextHeader != 0 {
+			if len(data) < length+1 || data[length] == 0 {
+				return nil
+			}
+			extLength := int(data[length]) * 4
+			if len(data) < length+extLength {
+				return nil
+			}
+			nextHeader = data[length+extLength-1]
+			length += extLength
+		}
+	}
+	if len(data) < length {
+		return nil
+	}
+	return data[length:]
+}
+
+// decodeVXLANHeader returns the IP payload of a VXLAN packet and its network
+// identifier. It returns nil if the payload is not IPv4 or IPv6.
+func decodeVXLANHeader(data []byte) ([]byte, uint32) {
+	if len(data) < 8 || data[0]&0x08 == 0 { // VNI flag
+		return nil, 0
+	}
+	vni := binary.BigEndian.Uint32(data[4:8]) >> 8
+	return decodeInnerEthernetHeader(data[8:]), vni
+}
+
+// decodeGeneveHeader returns the IP payload of a Geneve packet and its
+// network identifier. It returns nil if the payload is not IPv4 or IPv6.
+func decodeGeneveHeader(data []byte) ([]byte, uint32) {
+	if len(data) < 8 || data[0]>>6 != 0 { // version 0
+		return nil, 0
+	}
+	length := 8 + int(data[0]&0x3f)*4 // options
+	if len(data) < length {
+		return nil, 0
+	}
+	vni := binary.BigEndian.Uint32(data[4:8]) >> 8
+	switch binary.BigEndian.Uint16(data[2:4]) {
+	case 0x6558: // Ethernet
+		return decodeInnerEthernetHeader(data[length:]), vni
+	case 0x0800, 0x86dd:
+		return data[length:], vni
+	}
+	return nil, 0
+}
+
+// decodeInnerEthernetHeader returns the payload of an Ethernet frame
+// carrying IPv4 or IPv6, skipping VLAN tags. It returns nil for other
+// payloads.
+func decodeInnerEthernetHeader(data []byte) []byte {
+	if len(data) < 14 {
+		return nil
+	}
+	etherType := binary.BigEndian.Uint16(data[12:14])
+	data = data[14:]
+	for etherType == 0x8100 || etherType == 0x88a8 {
+		if len(data) < 4 {
+			return nil
+		}
+		etherType = binary.BigEndian.Uint16(data[2:4])
+		data = data[4:]
+	}
+	if etherType != 0x0800 && etherType != 0x86dd {
+		return nil
+	}
+	return data
+}
+
+// decodeInnerIPHeader decodes the inner IPv4 or IPv6 header.
+func decodeInnerIPHeader(sch *schema.Component, bf *schema.FlowMessage, data []byte) bool {
+	var src, dst netip.Addr
+	var proto uint8
+	if len(data) < 1 {
+		return false
+	}
+	switch data[0] >> 4 {
+	case 4:
+		if len(data) < 20 {
+			return false
+		}
+		src, _ = netip.AddrFromSlice(data[12:16])
+		dst, _ = netip.AddrFromSlice(data[16:20])
+		proto = data[9]
+	case 6:
+		if len(data) < 40 {
+			return false
+		}
+		src, _ = netip.AddrFromSlice(data[8:24])
+		dst, _ = netip.AddrFromSlice(data[24:40])
+		proto = data[6]
+	default:
+		return false
+	}
+	sch.ProtobufAppendIP(bf, schema.ColumnSrcAddrInner, netip.AddrFrom16(src.As16()))
+	sch.ProtobufAppendIP(bf, schema.ColumnDstAddrInner, netip.AddrFrom16(dst.As16()))
+	sch.ProtobufAppendVarint(bf, schema.ColumnProtoInner, uint64(proto))
+	return true
+}
@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package decoder
+
+import (
+	"net/netip"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+)
+
+func TestDecodeTunnel(t *testing.T) {
+	innerIPv4 := []byte{
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		10, 0, 0, 1,
+		10, 0, 0, 2,
+	}
+	innerIPv6 := append([]byte{
+		0x60, 0x00, 0x00, 0x00, 0x00, 0x08, 0x11, 0x40,
+	}, append(
+		netip.MustParseAddr("2001:db8::1").AsSlice(),
+		netip.MustParseAddr("2001:db8::2").AsSlice()...)...)
+	cases := []struct {
+		Description string
+		Proto       uint8
+		Data        []byte
+		Expected    map[schema.ColumnKey]interface{}
+	}{
+		{
+			Description: "IPv4 in IPv4",
+			Proto:       4,
+			Data:        innerIPv4,
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnTunnelType:   []byte("ipip"),
+				schema.ColumnSrcAddrInner: netip.MustParseAddr("::ffff:10.0.0.1"),
+				schema.ColumnDstAddrInner: netip.MustParseAddr("::ffff:10.0.0.2"),
+				schema.ColumnProtoInner:   6,
+			},
+		}, {
+			Description: "IPv6 in GRE with key",
+			Proto:       47,
+			Data: append([]byte{
+				0x20, 0x00, 0x86, 0xdd, // key present, IPv6
+				0x00, 0x00, 0x00, 0x2a, // key
+			}, innerIPv6...),
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnTunnelType:   []byte("gre"),
+				schema.ColumnSrcAddrInner: netip.MustParseAddr("2001:db8::1"),
+				schema.ColumnDstAddrInner: netip.MustParseAddr("2001:db8::2"),
+				schema.ColumnProtoInner:   17,
+			},
+		}, {
+			Description: "GRE with Ethernet",
+			Proto:       47,
+			Data:        append([]byte{0x00, 0x00, 0x65, 0x58}, innerIPv4...),
+		}, {
+			Description: "IPv4 in GTP-U with extension header",
+			Proto:       17,
+			Data: append([]byte{
+				0x08, 0x68, 0x08, 0x68, 0x00, 0x00, 0x00, 0x00, // UDP
+				0x34, 0xff, 0x00, 0x30, 0x00, 0x00, 0x00, 0x01, // GTP-U, E flag
+				0x00, 0x00, 0x00, 0x85, // sequence, N-PDU, next extension
+				0x01, 0x10, 0x09, 0x00, // PDU session container
+			}, innerIPv4...),
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnTunnelType:   []byte("gtp"),
+				schema.ColumnSrcAddrInner: netip.MustParseAddr("::ffff:10.0.0.1"),
+				schema.ColumnDstAddrInner: netip.MustParseAddr("::ffff:10.0.0.2"),
+				schema.ColumnProtoInner:   6,
+			},
+		}, {
+			Description: "IPv4 in VXLAN",
+			Proto:       17,
+			Data: append([]byte{
+				0xc0, 0x00, 0x12, 0xb5, 0x00, 0x00, 0x00, 0x00, // UDP
+				0x08, 0x00, 0x00, 0x00, 0x00, 0x27, 0x10, 0x00, // VXLAN, VNI 10000
+				0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x11, 0x22, 0x33, 0x44, 0x66,
+				0x81, 0x00, 0x00, 0x0a, 0x08, 0x00, // 802.1Q, IPv4
+			}, innerIPv4...),
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnTunnelType:   []byte("vxlan"),
+				schema.ColumnTunnelVNI:    10000,
+				schema.ColumnSrcAddrInner: netip.MustParseAddr("::ffff:10.0.0.1"),
+				schema.ColumnDstAddrInner: netip.MustParseAddr("::ffff:10.0.0.2"),
+				schema.ColumnProtoInner:   6,
+			},
+		}, {
+			Description: "VXLAN without VNI",
+			Proto:       17,
+			Data: append([]byte{
+				0xc0, 0x00, 0x12, 0xb5, 0x00, 0x00, 0x00, 0x00, // UDP
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x27, 0x10, 0x00, // VXLAN
+				0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x11, 0x22, 0x33, 0x44, 0x66,
+				0x08, 0x00,
+			}, innerIPv4...),
+		}, {
+			Description: "IPv6 in Geneve with options",
+			Proto:       17,
+			Data: append([]byte{
+				0xc0, 0x00, 0x17, 0xc1, 0x00, 0x00, 0x00, 0x00, // UDP
+				0x01, 0x00, 0x65, 0x58, 0x00, 0x00, 0x2a, 0x00, // Geneve, VNI 42
+				0x01, 0x02, 0x03, 0x00, // option
+				0x00, 0x11, 0x22, 0x33, 0x44, 0x55, 0x00, 0x11, 0x22, 0x33, 0x44, 0x66,
+				0x86, 0xdd,
+			}, innerIPv6...),
+			Expected: map[schema.ColumnKey]interface{}{
+				schema.ColumnTunnelType:   []byte("geneve"),
+				schema.ColumnTunnelVNI:    42,
+				schema.ColumnSrcAddrInner: netip.MustParseAddr("2001:db8::1"),
+				schema.ColumnDstAddrInner: netip.MustParseAddr("2001:db8::2"),
+				schema.ColumnProtoInner:   17,
+			},
+		}, {
+			Description: "UDP not GTP-U",
+			Proto:       17,
+			Data: append([]byte{
+				0x08, 0x68, 0x00, 0x35, 0x00, 0x00, 0x00, 0x00,
+			}, innerIPv4...),
+		}, {
+			Description: "truncated",
+			Proto:       4,
+			Data:        innerIPv4[:10],
+		},
+	}
+	sch := schema.NewMock(t).EnableAllColumns()
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			bf := &schema.FlowMessage{}
+			got := DecodeTunnel(sch, bf, tc.Proto, tc.Data)
+			if got != (tc.Expected != nil) {
+				t.Fatalf("DecodeTunnel() == %v", got)
+			}
+			if diff := helpers.Diff(bf.ProtobufDebug, tc.Expected); diff != "" {
+				t.Fatalf("DecodeTunnel() (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestDecodeTunnelDisabled(t *testing.T) {
+	bf := &schema.FlowMessage{}
+	data := []byte{
+		0x45, 0x00, 0x00, 0x28, 0x00, 0x00, 0x40, 0x00, 0x40, 0x06, 0x00, 0x00,
+		10, 0, 0, 1,
+		10, 0, 0, 2,
+	}
+	if DecodeTunnel(schema.NewMock(t), bf, 4, data) {
+		t.Fatal("DecodeTunnel() should not decode when columns are disabled")
+	}
+}
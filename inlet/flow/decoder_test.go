@@ -5,10 +5,13 @@ package flow
 
 import (
 	"net"
+	"net/netip"
 	"path/filepath"
 	"testing"
 
+	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/http"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
@@ -22,7 +25,7 @@ func BenchmarkDecodeEncodeNetflow(b *testing.B) {
 	schema.DisableDebug(b)
 	r := reporter.NewMock(b)
 	sch := schema.NewMock(b)
-	nfdecoder := netflow.New(r, decoder.Dependencies{Schema: sch})
+	nfdecoder := netflow.New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: sch})
 
 	template := helpers.ReadPcapPayload(b, filepath.Join("decoder", "netflow", "testdata", "options-template-257.pcap"))
 	got := nfdecoder.Decode(decoder.RawFlow{Payload: template, Source: net.ParseIP("127.0.0.1")})
@@ -66,7 +69,7 @@ func BenchmarkDecodeEncodeSflow(b *testing.B) {
 	schema.DisableDebug(b)
 	r := reporter.NewMock(b)
 	sch := schema.NewMock(b)
-	sdecoder := sflow.New(r, decoder.Dependencies{Schema: sch})
+	sdecoder := sflow.New(r, decoder.DefaultConfiguration(), decoder.Dependencies{Schema: sch})
 	data := helpers.ReadPcapPayload(b, filepath.Join("decoder", "sflow", "testdata", "data-1140.pcap"))
 
 	for _, withEncoding := range []bool{true, false} {
@@ -90,3 +93,159 @@ func BenchmarkDecodeEncodeSflow(b *testing.B) {
 		})
 	}
 }
+
+type fakeDecoder struct {
+	flows []*schema.FlowMessage
+}
+
+func (fd *fakeDecoder) Decode(decoder.RawFlow) []*schema.FlowMessage {
+	return fd.flows
+}
+
+func (fd *fakeDecoder) Name() string {
+	return "fake"
+}
+
+func TestZeroVolumePolicy(t *testing.T) {
+	r := reporter.NewMock(t)
+	sch, err := schema.New(schema.Configuration{
+		Enabled: []schema.ColumnKey{schema.ColumnZeroVolume},
+	})
+	if err != nil {
+		t.Fatalf("schema.New() error:\n%+v", err)
+	}
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   http.NewMock(t, r),
+		Schema: sch,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	newFlow := func(exporter string, bytes, packets uint64) *schema.FlowMessage {
+		bf := &schema.FlowMessage{ExporterAddress: netip.MustParseAddr(exporter)}
+		sch.ProtobufAppendVarint(bf, schema.ColumnBytes, bytes)
+		sch.ProtobufAppendVarint(bf, schema.ColumnPackets, packets)
+		return bf
+	}
+	fd := &fakeDecoder{}
+	wd, err := c.wrapDecoder(fd, InputConfiguration{
+		Decoder: "netflow",
+		ZeroVolumePolicy: *helpers.MustNewSubnetMap(map[string]ZeroVolumePolicy{
+			"::ffff:192.0.2.2/128": ZeroVolumePolicyKeep,
+			"::ffff:192.0.2.3/128": ZeroVolumePolicyTag,
+		}),
+	})
+	if err != nil {
+		t.Fatalf("wrapDecoder() error:\n%+v", err)
+	}
+	fd.flows = []*schema.FlowMessage{
+		newFlow("::ffff:192.0.2.1", 1000, 10),
+		newFlow("::ffff:192.0.2.1", 0, 10),
+		newFlow("::ffff:192.0.2.2", 1000, 0),
+		newFlow("::ffff:192.0.2.3", 0, 0),
+		newFlow("::ffff:192.0.2.3", 1000, 10),
+	}
+	got := wd.Decode(decoder.RawFlow{Source: net.ParseIP("127.0.0.1")})
+	expected := []map[schema.ColumnKey]interface{}{
+		{schema.ColumnBytes: 1000, schema.ColumnPackets: 10},
+		{schema.ColumnBytes: 1000},
+		{schema.ColumnZeroVolume: 1},
+		{schema.ColumnBytes: 1000, schema.ColumnPackets: 10},
+	}
+	gotDebug := []map[schema.ColumnKey]interface{}{}
+	for _, f := range got {
+		gotDebug = append(gotDebug, f.ProtobufDebug)
+	}
+	if diff := helpers.Diff(gotDebug, expected); diff != "" {
+		t.Fatalf("Decode() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_", "zero_volume_flows_total")
+	expectedMetrics := map[string]string{
+		`zero_volume_flows_total{exporter="192.0.2.1",policy="drop"}`: "1",
+		`zero_volume_flows_total{exporter="192.0.2.2",policy="keep"}`: "1",
+		`zero_volume_flows_total{exporter="192.0.2.3",policy="tag"}`:  "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestZeroVolumePolicyTagWithoutColumn(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Inputs[0].ZeroVolumePolicy = *helpers.MustNewSubnetMap(map[string]ZeroVolumePolicy{
+		"::/0": ZeroVolumePolicyTag,
+	})
+	_, err := New(r, config, Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   http.NewMock(t, r),
+		Schema: schema.NewMock(t),
+	})
+	if err == nil {
+		t.Fatal("New() did not error")
+	}
+}
+
+func TestExporterACL(t *testing.T) {
+	r := reporter.NewMock(t)
+	c, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   http.NewMock(t, r),
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	fd := &fakeDecoder{}
+	wd, err := c.wrapDecoder(fd, InputConfiguration{
+		Decoder: "sflow",
+		AllowedExporters: []netip.Prefix{
+			netip.MustParsePrefix("192.0.2.0/24"),
+			netip.MustParsePrefix("2001:db8::/32"),
+			netip.MustParsePrefix("198.51.100.128/25"),
+		},
+		DeniedExporters: []netip.Prefix{
+			netip.MustParsePrefix("192.0.2.128/25"),
+			netip.MustParsePrefix("198.51.100.0/24"),
+		},
+	})
+	if err != nil {
+		t.Fatalf("wrapDecoder() error:\n%+v", err)
+	}
+
+	cases := []struct {
+		Source   string
+		Accepted bool
+	}{
+		{"192.0.2.1", true},
+		{"192.0.2.130", false},
+		{"198.51.100.1", false},
+		{"198.51.100.130", true},
+		{"203.0.113.1", false},
+		{"2001:db8::1", true},
+		{"2001:db9::1", false},
+	}
+	for _, tc := range cases {
+		fd.flows = []*schema.FlowMessage{{
+			ExporterAddress: netip.AddrFrom16(netip.MustParseAddr(tc.Source).As16()),
+		}}
+		got := wd.Decode(decoder.RawFlow{Source: net.ParseIP(tc.Source)})
+		if accepted := len(got) == 1; accepted != tc.Accepted {
+			t.Errorf("Decode(%s) accepted = %v, expected %v", tc.Source, accepted, tc.Accepted)
+		}
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_", "rejected_datagrams_total")
+	expectedMetrics := map[string]string{
+		`rejected_datagrams_total{exporter="192.0.2.130"}`:  "1",
+		`rejected_datagrams_total{exporter="198.51.100.1"}`: "1",
+		`rejected_datagrams_total{exporter="203.0.113.1"}`:  "1",
+		`rejected_datagrams_total{exporter="2001:db9::1"}`:  "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
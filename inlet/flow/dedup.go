@@ -0,0 +1,165 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"fmt"
+	"net/netip"
+	"sync"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+// deduplicator detects flows reported by several exporters of the same
+// group during the same time bucket. To reduce contention, flows are spread
+// over several shards depending on their key.
+type deduplicator struct {
+	sch       *schema.Component
+	window    uint64                     // in seconds
+	groups    *helpers.SubnetMap[string] // nil when all exporters are in the same group
+	maxFlows  int                        // per shard and per bucket, 0 when unlimited
+	shards    [dedupShards]dedupShard
+	untracked reporter.Counter
+}
+
+// dedupShards is the number of shards of the deduplicator.
+const dedupShards = 64
+
+// dedupShard is a shard of the deduplicator.
+type dedupShard struct {
+	lock     sync.Mutex
+	bucket   uint64
+	current  map[dedupKey]netip.Addr // exporter which reported the flow first
+	previous map[dedupKey]netip.Addr
+}
+
+// dedupKey identifies a flow in a time bucket.
+type dedupKey struct {
+	group   string
+	srcAddr netip.Addr
+	dstAddr netip.Addr
+	proto   uint64
+	srcPort uint64
+	dstPort uint64
+}
+
+// newDeduplicator creates a new deduplicator from the provided
+// configuration. It returns nil when deduplication is disabled.
+func newDeduplicator(r *reporter.Reporter, config DeduplicationConfiguration, sch *schema.Component) (*deduplicator, error) {
+	if config.Window == 0 {
+		return nil, nil
+	}
+	d := &deduplicator{
+		sch:    sch,
+		window: uint64(config.Window.Seconds()),
+		untracked: r.Counter(reporter.CounterOpts{
+			Name: "deduplication_untracked_flows_total",
+			Help: "Number of flows not checked for duplicates due to the limit of tracked flows.",
+		}),
+	}
+	if config.MaxFlows > 0 {
+		d.maxFlows = (config.MaxFlows + dedupShards - 1) / dedupShards
+	}
+	for idx := range d.shards {
+		d.shards[idx].current = map[dedupKey]netip.Addr{}
+		d.shards[idx].previous = map[dedupKey]netip.Addr{}
+	}
+	if len(config.ExporterGroups) > 0 {
+		groups := map[string]string{}
+		for name, prefixes := range config.ExporterGroups {
+			for _, prefix := range prefixes {
+				key := toIPv6Prefix(prefix).String()
+				if other, ok := groups[key]; ok && other != name {
+					return nil, fmt.Errorf("prefix %s is in both %q and %q exporter groups",
+						prefix, other, name)
+				}
+				groups[key] = name
+			}
+		}
+		sm, err := helpers.NewSubnetMap(groups)
+		if err != nil {
+			return nil, err
+		}
+		d.groups = sm
+	}
+	return d, nil
+}
+
+// duplicate tells if the provided flow was already reported by another
+// exporter of the same group during the same time bucket.
+func (d *deduplicator) duplicate(fmsg *schema.FlowMessage) bool {
+	key := dedupKey{
+		srcAddr: fmsg.SrcAddr,
+		dstAddr: fmsg.DstAddr,
+	}
+	if d.groups != nil {
+		group, ok := d.groups.Lookup(fmsg.ExporterAddress)
+		if !ok {
+			return false
+		}
+		key.group = group
+	}
+	key.proto, _ = d.sch.ProtobufLookupVarint(fmsg, schema.ColumnProto)
+	key.srcPort, _ = d.sch.ProtobufLookupVarint(fmsg, schema.ColumnSrcPort)
+	key.dstPort, _ = d.sch.ProtobufLookupVarint(fmsg, schema.ColumnDstPort)
+	bucket := fmsg.TimeReceived / d.window
+
+	shard := &d.shards[key.hash()%dedupShards]
+	shard.lock.Lock()
+	defer shard.lock.Unlock()
+	if bucket > shard.bucket {
+		// Rotate buckets, we only keep the previous one
+		if bucket == shard.bucket+1 {
+			shard.previous = shard.current
+		} else {
+			shard.previous = map[dedupKey]netip.Addr{}
+		}
+		shard.current = map[dedupKey]netip.Addr{}
+		shard.bucket = bucket
+	}
+	var seen map[dedupKey]netip.Addr
+	switch {
+	case bucket == shard.bucket:
+		seen = shard.current
+	case bucket+1 == shard.bucket:
+		seen = shard.previous
+	default:
+		// Too old
+		return false
+	}
+	if exporter, ok := seen[key]; ok {
+		return exporter != fmsg.ExporterAddress
+	}
+	if d.maxFlows > 0 && len(seen) >= d.maxFlows {
+		d.untracked.Inc()
+		return false
+	}
+	seen[key] = fmsg.ExporterAddress
+	return false
+}
+
+// hash returns a hash of the key to select a shard (FNV-1a).
+func (k dedupKey) hash() uint64 {
+	h := uint64(14695981039346656037)
+	mix := func(b byte) {
+		h ^= uint64(b)
+		h *= 1099511628211
+	}
+	for _, addr := range []netip.Addr{k.srcAddr, k.dstAddr} {
+		for _, b := range addr.As16() {
+			mix(b)
+		}
+	}
+	for _, v := range []uint64{k.proto, k.srcPort, k.dstPort} {
+		for i := 0; i < 64; i += 8 {
+			mix(byte(v >> i))
+		}
+	}
+	for i := 0; i < len(k.group); i++ {
+		mix(k.group[i])
+	}
+	return h
+}
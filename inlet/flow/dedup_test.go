@@ -0,0 +1,146 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestDeduplicator(t *testing.T) {
+	sch := schema.NewMock(t)
+	flow := func(exporter string, received uint64, srcPort uint64) *schema.FlowMessage {
+		fmsg := &schema.FlowMessage{
+			TimeReceived:    received,
+			ExporterAddress: netip.MustParseAddr(exporter),
+			SrcAddr:         netip.MustParseAddr("::ffff:198.51.100.1"),
+			DstAddr:         netip.MustParseAddr("::ffff:203.0.113.1"),
+		}
+		sch.ProtobufAppendVarint(fmsg, schema.ColumnProto, 6)
+		sch.ProtobufAppendVarint(fmsg, schema.ColumnSrcPort, srcPort)
+		sch.ProtobufAppendVarint(fmsg, schema.ColumnDstPort, 443)
+		return fmsg
+	}
+
+	t.Run("disabled", func(t *testing.T) {
+		d, err := newDeduplicator(reporter.NewMock(t), DeduplicationConfiguration{}, sch)
+		if err != nil {
+			t.Fatalf("newDeduplicator() error:\n%+v", err)
+		}
+		if d != nil {
+			t.Fatal("newDeduplicator() should return nil when disabled")
+		}
+	})
+
+	t.Run("all exporters", func(t *testing.T) {
+		d, err := newDeduplicator(reporter.NewMock(t), DeduplicationConfiguration{Window: 10 * time.Second}, sch)
+		if err != nil {
+			t.Fatalf("newDeduplicator() error:\n%+v", err)
+		}
+		cases := []struct {
+			Exporter  string
+			Received  uint64
+			SrcPort   uint64
+			Duplicate bool
+		}{
+			{"::ffff:192.0.2.1", 1000, 30000, false},
+			{"::ffff:192.0.2.1", 1001, 30000, false}, // same exporter
+			{"::ffff:192.0.2.2", 1002, 30000, true},
+			{"::ffff:192.0.2.2", 1002, 30001, false}, // another flow
+			{"::ffff:192.0.2.2", 1011, 30000, false}, // next bucket
+			{"::ffff:192.0.2.1", 1012, 30000, true},
+			{"::ffff:192.0.2.1", 1009, 30001, true},  // late, previous bucket
+			{"::ffff:192.0.2.3", 1030, 30000, false}, // buckets expired
+		}
+		for _, tc := range cases {
+			if got := d.duplicate(flow(tc.Exporter, tc.Received, tc.SrcPort)); got != tc.Duplicate {
+				t.Errorf("duplicate(%s, %d, %d) == %v but expected %v",
+					tc.Exporter, tc.Received, tc.SrcPort, got, tc.Duplicate)
+			}
+		}
+	})
+
+	t.Run("exporter groups", func(t *testing.T) {
+		d, err := newDeduplicator(reporter.NewMock(t), DeduplicationConfiguration{
+			Window: 10 * time.Second,
+			ExporterGroups: map[string][]netip.Prefix{
+				"paris":  {netip.MustParsePrefix("192.0.2.0/28")},
+				"berlin": {netip.MustParsePrefix("192.0.2.16/28")},
+			},
+		}, sch)
+		if err != nil {
+			t.Fatalf("newDeduplicator() error:\n%+v", err)
+		}
+		cases := []struct {
+			Exporter  string
+			Duplicate bool
+		}{
+			{"::ffff:192.0.2.1", false},
+			{"::ffff:192.0.2.17", false}, // another group
+			{"::ffff:192.0.2.2", true},
+			{"::ffff:192.0.2.18", true},
+			{"::ffff:192.0.2.33", false}, // no group
+			{"::ffff:192.0.2.34", false},
+		}
+		for _, tc := range cases {
+			if got := d.duplicate(flow(tc.Exporter, 1000, 30000)); got != tc.Duplicate {
+				t.Errorf("duplicate(%s) == %v but expected %v", tc.Exporter, got, tc.Duplicate)
+			}
+		}
+	})
+
+	t.Run("overlapping groups", func(t *testing.T) {
+		_, err := newDeduplicator(reporter.NewMock(t), DeduplicationConfiguration{
+			Window: 10 * time.Second,
+			ExporterGroups: map[string][]netip.Prefix{
+				"paris":  {netip.MustParsePrefix("192.0.2.0/28")},
+				"berlin": {netip.MustParsePrefix("192.0.2.0/28")},
+			},
+		}, sch)
+		if err == nil {
+			t.Fatal("newDeduplicator() did not error")
+		}
+	})
+	t.Run("limit", func(t *testing.T) {
+		r := reporter.NewMock(t)
+		d, err := newDeduplicator(r, DeduplicationConfiguration{
+			Window:   10 * time.Second,
+			MaxFlows: dedupShards,
+		}, sch)
+		if err != nil {
+			t.Fatalf("newDeduplicator() error:\n%+v", err)
+		}
+		// At most one flow per shard is tracked
+		untracked := 0
+		for port := uint64(30000); port < 30000+4*dedupShards; port++ {
+			d.duplicate(flow("::ffff:192.0.2.1", 1000, port))
+			if !d.duplicate(flow("::ffff:192.0.2.2", 1000, port)) {
+				untracked++
+			}
+		}
+		if untracked < 3*dedupShards {
+			t.Errorf("duplicate() detected too many duplicates (%d untracked)", untracked)
+		}
+		for idx := range d.shards {
+			if len(d.shards[idx].current) > 1 {
+				t.Errorf("shard %d tracks %d flows", idx, len(d.shards[idx].current))
+			}
+		}
+		gotMetrics := r.GetMetrics("akvorado_inlet_flow_deduplication_")
+		if gotMetrics["untracked_flows_total"] != fmt.Sprint(2*untracked) {
+			t.Errorf("untracked_flows_total = %s, expected %d", gotMetrics["untracked_flows_total"], 2*untracked)
+		}
+
+		// Next bucket, limits are reset
+		if d.duplicate(flow("::ffff:192.0.2.1", 1010, 40000)) ||
+			!d.duplicate(flow("::ffff:192.0.2.2", 1010, 40000)) {
+			t.Error("duplicate() did not detect duplicate in next bucket")
+		}
+	})
+}
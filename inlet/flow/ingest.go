@@ -0,0 +1,248 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ingestExpirationIntervals is the number of intervals, in addition to the
+// window, after which an exporter without any datagram is forgotten.
+const ingestExpirationIntervals = 3
+
+// ingestTracker tracks, for each exporter, the number of datagrams dropped
+// by the inlet, either because the queue of an input was full or because of
+// rate limiting. A datagram is counted as received once decoded and as
+// forwarded once accepted by the flow component. The difference between the
+// two is the number of dropped datagrams.
+type ingestTracker struct {
+	counters sync.Map // netip.Addr → *ingestCounters
+
+	lock   sync.Mutex
+	states map[netip.Addr]*ingestState
+}
+
+// ingestCounters are the cumulative counters for an exporter.
+type ingestCounters struct {
+	received  atomic.Uint64
+	forwarded atomic.Uint64
+}
+
+// ingestSample is a snapshot of the counters of an exporter.
+type ingestSample struct {
+	time      time.Time
+	received  uint64
+	forwarded uint64
+}
+
+// ingestState is the evaluated state of an exporter.
+type ingestState struct {
+	samples        []ingestSample // oldest first
+	lastSeen       time.Time      // last evaluation with new datagrams
+	degraded       bool
+	since          time.Time
+	droppedPercent float64
+}
+
+// ingestTransition is a change of state for an exporter.
+type ingestTransition struct {
+	Exporter       netip.Addr `json:"exporter"`
+	State          string     `json:"state"`
+	DroppedPercent float64    `json:"dropped-percent"`
+	Time           time.Time  `json:"time"`
+}
+
+func (s *ingestState) stateName() string {
+	if s.degraded {
+		return "degraded"
+	}
+	return "ok"
+}
+
+func (it *ingestTracker) get(exporter netip.Addr) *ingestCounters {
+	if counters, ok := it.counters.Load(exporter); ok {
+		return counters.(*ingestCounters)
+	}
+	counters, _ := it.counters.LoadOrStore(exporter, &ingestCounters{})
+	return counters.(*ingestCounters)
+}
+
+// received accounts a datagram decoded for the provided exporter.
+func (it *ingestTracker) received(exporter netip.Addr) {
+	it.get(exporter).received.Add(1)
+}
+
+// forwarded accounts a datagram accepted for the provided exporter.
+func (it *ingestTracker) forwarded(exporter netip.Addr) {
+	it.get(exporter).forwarded.Add(1)
+}
+
+// evaluateIngest takes a new sample of the counters of each exporter and
+// updates their states. The drop percentage is computed over the configured
+// window. An exporter becomes degraded when it reaches the configured
+// threshold and recovers when it goes below the recovery threshold. The
+// state transitions are returned. Exporters without datagrams during the
+// window and a few more intervals are forgotten.
+func (c *Component) evaluateIngest(now time.Time) []ingestTransition {
+	config := c.config.DegradedIngest
+	expiration := config.Window + ingestExpirationIntervals*config.Interval
+	transitions := []ingestTransition{}
+	c.ingest.lock.Lock()
+	defer c.ingest.lock.Unlock()
+	c.ingest.counters.Range(func(key, value interface{}) bool {
+		exporter := key.(netip.Addr)
+		counters := value.(*ingestCounters)
+		state, ok := c.ingest.states[exporter]
+		if !ok {
+			state = &ingestState{since: now}
+			c.ingest.states[exporter] = state
+		}
+		// Datagrams are forwarded after being received: load the forwarded
+		// counter first to not get more forwarded than received datagrams.
+		sample := ingestSample{time: now, forwarded: counters.forwarded.Load()}
+		sample.received = counters.received.Load()
+		if len(state.samples) == 0 || state.samples[len(state.samples)-1].received != sample.received {
+			state.lastSeen = now
+		} else if now.Sub(state.lastSeen) > expiration {
+			// The state has recovered as there is no datagram in the window
+			delete(c.ingest.states, exporter)
+			c.ingest.counters.Delete(exporter)
+			return true
+		}
+		state.samples = append(state.samples, sample)
+		for len(state.samples) > 2 && !state.samples[1].time.After(now.Add(-config.Window)) {
+			state.samples = state.samples[1:]
+		}
+
+		first := state.samples[0]
+		received := sample.received - first.received
+		forwarded := sample.forwarded - first.forwarded
+		state.droppedPercent = 0
+		if received > 0 && received > forwarded {
+			state.droppedPercent = float64(received-forwarded) / float64(received) * 100
+		}
+
+		switch {
+		case !state.degraded && state.droppedPercent >= config.Threshold:
+			state.degraded = true
+		case state.degraded && state.droppedPercent < config.RecoveryThreshold:
+			state.degraded = false
+		default:
+			return true
+		}
+		state.since = now
+		transitions = append(transitions, ingestTransition{
+			Exporter:       exporter,
+			State:          state.stateName(),
+			DroppedPercent: state.droppedPercent,
+			Time:           now,
+		})
+		return true
+	})
+	sort.Slice(transitions, func(i, j int) bool {
+		return transitions[i].Exporter.Less(transitions[j].Exporter)
+	})
+	return transitions
+}
+
+// notifyIngest logs the provided transitions and sends them to the webhook,
+// if any.
+func (c *Component) notifyIngest(transitions []ingestTransition) {
+	for _, transition := range transitions {
+		l := c.r.With().
+			Str("exporter", transition.Exporter.Unmap().String()).
+			Float64("dropped", transition.DroppedPercent).
+			Logger()
+		if transition.State == "degraded" {
+			l.Warn().Msg("exporter ingest degraded due to dropped datagrams")
+		} else {
+			l.Info().Msg("exporter ingest recovered")
+		}
+		if c.config.DegradedIngest.Webhook == "" {
+			continue
+		}
+		if err := c.sendIngestWebhook(transition); err != nil {
+			l.Err(err).Str("webhook", c.config.DegradedIngest.Webhook).
+				Msg("unable to notify webhook")
+		}
+	}
+}
+
+// sendIngestWebhook posts the provided transition to the configured webhook.
+func (c *Component) sendIngestWebhook(transition ingestTransition) error {
+	transition.Exporter = transition.Exporter.Unmap()
+	body, err := json.Marshal(transition)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c.t.Context(context.Background()), c.config.DegradedIngest.WebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.config.DegradedIngest.Webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// runIngestEvaluation periodically evaluates the state of each exporter.
+func (c *Component) runIngestEvaluation() error {
+	ticker := time.NewTicker(c.config.DegradedIngest.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.t.Dying():
+			return nil
+		case now := <-ticker.C:
+			c.notifyIngest(c.evaluateIngest(now))
+		}
+	}
+}
+
+// exportersHTTPHandler returns the ingest state of each exporter.
+func (c *Component) exportersHTTPHandler(gc *gin.Context) {
+	type exporter struct {
+		Exporter       string    `json:"exporter"`
+		State          string    `json:"state"`
+		DroppedPercent float64   `json:"dropped-percent"`
+		Since          time.Time `json:"since"`
+	}
+	c.ingest.lock.Lock()
+	addresses := make([]netip.Addr, 0, len(c.ingest.states))
+	for address := range c.ingest.states {
+		addresses = append(addresses, address)
+	}
+	sort.Slice(addresses, func(i, j int) bool { return addresses[i].Less(addresses[j]) })
+	exporters := make([]exporter, 0, len(addresses))
+	for _, address := range addresses {
+		state := c.ingest.states[address]
+		exporters = append(exporters, exporter{
+			Exporter:       address.Unmap().String(),
+			State:          state.stateName(),
+			DroppedPercent: state.droppedPercent,
+			Since:          state.since,
+		})
+	}
+	c.ingest.lock.Unlock()
+	gc.JSON(http.StatusOK, gin.H{"exporters": exporters})
+}
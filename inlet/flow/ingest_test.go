@@ -0,0 +1,206 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+func TestEvaluateIngest(t *testing.T) {
+	c := Component{
+		config: DefaultConfiguration(),
+		ingest: ingestTracker{states: make(map[netip.Addr]*ingestState)},
+	}
+	c.config.DegradedIngest.Window = 30 * time.Second
+	exporter1 := netip.MustParseAddr("::ffff:192.0.2.1")
+	exporter2 := netip.MustParseAddr("::ffff:192.0.2.2")
+	now := time.Date(2023, 3, 10, 10, 0, 0, 0, time.UTC)
+	// step simulates 100 datagrams for each exporter during an interval
+	// and evaluates the result.
+	step := func(forwarded1, forwarded2 int) []ingestTransition {
+		for i := 0; i < 100; i++ {
+			c.ingest.received(exporter1)
+			c.ingest.received(exporter2)
+			if i < forwarded1 {
+				c.ingest.forwarded(exporter1)
+			}
+			if i < forwarded2 {
+				c.ingest.forwarded(exporter2)
+			}
+		}
+		now = now.Add(c.config.DegradedIngest.Interval)
+		return c.evaluateIngest(now)
+	}
+
+	cases := []struct {
+		Description string
+		Forwarded1  int
+		Forwarded2  int
+		Expected    []ingestTransition
+	}{
+		{
+			Description: "first sample",
+			Forwarded1:  100,
+			Forwarded2:  100,
+			Expected:    []ingestTransition{},
+		}, {
+			Description: "no drop",
+			Forwarded1:  100,
+			Forwarded2:  100,
+			Expected:    []ingestTransition{},
+		}, {
+			Description: "drops over the threshold",
+			Forwarded1:  100,
+			Forwarded2:  0,
+			Expected: []ingestTransition{{
+				Exporter:       exporter2,
+				State:          "degraded",
+				DroppedPercent: 50,
+				Time:           time.Date(2023, 3, 10, 10, 0, 30, 0, time.UTC),
+			}},
+		}, {
+			Description: "drops under the threshold",
+			Forwarded1:  97,
+			Forwarded2:  100,
+			Expected:    []ingestTransition{},
+		}, {
+			Description: "still degraded",
+			Forwarded1:  100,
+			Forwarded2:  100,
+			Expected:    []ingestTransition{},
+		}, {
+			Description: "drops between thresholds while degraded",
+			Forwarded1:  100,
+			Forwarded2:  90,
+			Expected:    []ingestTransition{},
+		}, {
+			Description: "still degraded between thresholds",
+			Forwarded1:  100,
+			Forwarded2:  100,
+			Expected:    []ingestTransition{},
+		}, {
+			Description: "still degraded between thresholds",
+			Forwarded1:  100,
+			Forwarded2:  100,
+			Expected:    []ingestTransition{},
+		}, {
+			Description: "drops out of the window",
+			Forwarded1:  100,
+			Forwarded2:  100,
+			Expected: []ingestTransition{{
+				Exporter: exporter2,
+				State:    "ok",
+				Time:     time.Date(2023, 3, 10, 10, 1, 30, 0, time.UTC),
+			}},
+		},
+	}
+	for _, tc := range cases {
+		got := step(tc.Forwarded1, tc.Forwarded2)
+		if diff := helpers.Diff(got, tc.Expected); diff != "" {
+			t.Fatalf("evaluateIngest(%s) (-got, +want):\n%s", tc.Description, diff)
+		}
+	}
+}
+
+func TestIngestExpiration(t *testing.T) {
+	c := Component{
+		config: DefaultConfiguration(),
+		ingest: ingestTracker{states: make(map[netip.Addr]*ingestState)},
+	}
+	exporter := netip.MustParseAddr("::ffff:192.0.2.1")
+	now := time.Date(2023, 3, 10, 10, 0, 0, 0, time.UTC)
+	c.ingest.received(exporter)
+	c.evaluateIngest(now)
+
+	// 60-second window and 3 additional intervals of 10 seconds
+	for i := 0; i < 9; i++ {
+		now = now.Add(c.config.DegradedIngest.Interval)
+		c.evaluateIngest(now)
+		if _, ok := c.ingest.states[exporter]; !ok {
+			t.Fatalf("evaluateIngest() forgot exporter after %d intervals", i+1)
+		}
+	}
+	now = now.Add(c.config.DegradedIngest.Interval)
+	c.evaluateIngest(now)
+	if _, ok := c.ingest.states[exporter]; ok {
+		t.Fatal("evaluateIngest() did not forget inactive exporter")
+	}
+	if _, ok := c.ingest.counters.Load(exporter); ok {
+		t.Fatal("evaluateIngest() did not forget counters of inactive exporter")
+	}
+
+	// The exporter comes back
+	c.ingest.received(exporter)
+	c.evaluateIngest(now.Add(c.config.DegradedIngest.Interval))
+	if _, ok := c.ingest.states[exporter]; !ok {
+		t.Fatal("evaluateIngest() did not track back exporter")
+	}
+}
+
+func TestIngestNotifications(t *testing.T) {
+	notifications := make(chan ingestTransition, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var transition ingestTransition
+		if err := json.NewDecoder(r.Body).Decode(&transition); err != nil {
+			t.Errorf("Decode() error:\n%+v", err)
+		}
+		notifications <- transition
+	}))
+	defer server.Close()
+
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.DegradedIngest.Webhook = server.URL
+	c := NewMock(t, r, config)
+
+	exporter := netip.MustParseAddr("::ffff:192.0.2.1")
+	now := time.Date(2023, 3, 10, 10, 0, 0, 0, time.UTC)
+	c.ingest.received(exporter)
+	c.ingest.forwarded(exporter)
+	c.notifyIngest(c.evaluateIngest(now))
+	for i := 0; i < 10; i++ {
+		c.ingest.received(exporter)
+	}
+	now = now.Add(10 * time.Second)
+	c.notifyIngest(c.evaluateIngest(now))
+
+	select {
+	case got := <-notifications:
+		expected := ingestTransition{
+			Exporter:       netip.MustParseAddr("192.0.2.1"),
+			State:          "degraded",
+			DroppedPercent: 100,
+			Time:           now,
+		}
+		if diff := helpers.Diff(got, expected); diff != "" {
+			t.Errorf("webhook notification (-got, +want):\n%s", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no webhook notification received")
+	}
+
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/inlet/flow/exporters",
+			JSONOutput: gin.H{
+				"exporters": []gin.H{{
+					"exporter":        "192.0.2.1",
+					"state":           "degraded",
+					"dropped-percent": 100,
+					"since":           "2023-03-10T10:00:10Z",
+				}},
+			},
+		},
+	})
+}
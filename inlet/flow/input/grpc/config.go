@@ -0,0 +1,24 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package grpc
+
+import "akvorado/inlet/flow/input"
+
+// Configuration describes gRPC input configuration.
+type Configuration struct {
+	// Listen tells which port to listen to.
+	Listen string `validate:"required,listen"`
+	// QueueSize defines the size of the channel used to
+	// communicate incoming flows. 0 can be used to disable
+	// buffering.
+	QueueSize uint
+}
+
+// DefaultConfiguration is the default configuration for this input
+func DefaultConfiguration() input.Configuration {
+	return &Configuration{
+		Listen:    "0.0.0.0:0",
+		QueueSize: 100000,
+	}
+}
@@ -0,0 +1,214 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package grpc handles gRPC listeners. Flows are received already decoded,
+// using the protobuf schema used by the inlet to send flows to Kafka. This
+// allows another inlet or a lightweight agent to forward flows.
+package grpc
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/daemon"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+	"akvorado/inlet/flow/input"
+)
+
+// Input represents the state of a gRPC listener.
+type Input struct {
+	r      *reporter.Reporter
+	t      tomb.Tomb
+	config *Configuration
+
+	metrics struct {
+		bytes    *reporter.CounterVec
+		messages *reporter.CounterVec
+		errors   *reporter.CounterVec
+		outDrops *reporter.CounterVec
+		streams  *reporter.GaugeVec
+	}
+
+	address net.Addr                   // listening address, for testing purpoese
+	ch      chan []*schema.FlowMessage // channel to send flows to
+	decoder decoder.Decoder            // decoder to use
+	server  *grpc.Server               // gRPC server
+
+	streamsLock sync.Mutex
+	streams     sync.WaitGroup // active streams
+	closed      bool           // no new stream should be accepted
+}
+
+// New instantiate a new gRPC listener from the provided configuration.
+func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Component, dec decoder.Decoder) (input.Input, error) {
+	input := &Input{
+		r:       r,
+		config:  configuration,
+		ch:      make(chan []*schema.FlowMessage, configuration.QueueSize),
+		decoder: dec,
+		server:  grpc.NewServer(grpc.ForceServerCodec(rawCodec{})),
+	}
+	input.server.RegisterService(&serviceDesc, input)
+
+	input.metrics.bytes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "bytes",
+			Help: "Bytes received by the application.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.messages = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "messages",
+			Help: "Messages received by the application.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors",
+			Help: "Errors while receiving messages by the application.",
+		},
+		[]string{"listener", "exporter", "error"},
+	)
+	input.metrics.outDrops = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "out_drops",
+			Help: "Dropped messages due to internal queue full.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.streams = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "streams",
+			Help: "Number of active streams.",
+		},
+		[]string{"listener"},
+	)
+
+	daemon.Track(&input.t, "inlet/flow/input/grpc")
+	return input, nil
+}
+
+// Start starts listening to the provided TCP socket and producing flows.
+func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
+	in.r.Info().Str("listen", in.config.Listen).Msg("starting gRPC input")
+
+	listener, err := net.Listen("tcp", in.config.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("unable to listen to %v: %w", in.config.Listen, err)
+	}
+	in.address = listener.Addr()
+	in.r.Info().Str("listen", in.address.String()).Msg("gRPC input listening")
+
+	in.t.Go(func() error {
+		return in.server.Serve(listener)
+	})
+
+	// Watch for termination and stop the server on dying
+	in.t.Go(func() error {
+		<-in.t.Dying()
+		in.server.Stop()
+		in.streamsLock.Lock()
+		in.closed = true
+		in.streamsLock.Unlock()
+		in.streams.Wait()
+		return nil
+	})
+
+	return in.ch, nil
+}
+
+// handleStream receives flows from a stream until the client closes it or
+// until an error happens.
+func (in *Input) handleStream(stream grpc.ServerStream) error {
+	in.streamsLock.Lock()
+	if in.closed {
+		in.streamsLock.Unlock()
+		return status.Error(codes.Unavailable, "input stopped")
+	}
+	in.streams.Add(1)
+	in.streamsLock.Unlock()
+	defer in.streams.Done()
+
+	listen := in.config.Listen
+	source := remoteIP(stream)
+	exporter := source.String()
+	l := in.r.With().
+		Str("listen", listen).
+		Str("exporter", exporter).
+		Logger()
+	errLogger := l.Sample(reporter.BurstSampler(time.Minute, 1))
+	in.metrics.streams.WithLabelValues(listen).Inc()
+	defer func() {
+		in.metrics.streams.WithLabelValues(listen).Dec()
+		l.Debug().Msg("stream closed")
+	}()
+	l.Debug().Msg("new stream")
+
+	for {
+		var payload []byte
+		if err := stream.RecvMsg(&payload); err != nil {
+			if errors.Is(err, io.EOF) {
+				return stream.SendMsg(&[]byte{})
+			}
+			if status.Code(err) != codes.Canceled {
+				l.Err(err).Msg("unable to receive message")
+				in.metrics.errors.WithLabelValues(listen, exporter, "receive").Inc()
+			}
+			return err
+		}
+
+		in.metrics.bytes.WithLabelValues(listen, exporter).Add(float64(len(payload)))
+		in.metrics.messages.WithLabelValues(listen, exporter).Inc()
+		flows := in.decoder.Decode(decoder.RawFlow{
+			TimeReceived: time.Now(),
+			Payload:      payload,
+			Source:       source,
+		})
+		if len(flows) == 0 {
+			continue
+		}
+		select {
+		case <-in.t.Dying():
+			return status.Error(codes.Unavailable, "input stopped")
+		case in.ch <- flows:
+		default:
+			errLogger.Warn().Msgf("dropping flow due to queue full (size %d)", in.config.QueueSize)
+			in.metrics.outDrops.WithLabelValues(listen, exporter).Inc()
+		}
+	}
+}
+
+// remoteIP returns the IP address of the remote end of a stream.
+func remoteIP(stream grpc.ServerStream) net.IP {
+	if p, ok := peer.FromContext(stream.Context()); ok {
+		if addr, ok := p.Addr.(*net.TCPAddr); ok {
+			return addr.IP
+		}
+	}
+	return net.IPv6unspecified
+}
+
+// Stop stops the gRPC listener
+func (in *Input) Stop() error {
+	l := in.r.With().Str("listen", in.config.Listen).Logger()
+	defer func() {
+		close(in.ch)
+		l.Info().Msg("gRPC listener stopped")
+	}()
+	in.t.Kill(nil)
+	return in.t.Wait()
+}
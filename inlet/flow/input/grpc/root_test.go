@@ -0,0 +1,136 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package grpc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+func TestGRPCInput(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	// Connect
+	conn, err := grpc.Dial(in.(*Input).address.String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	stream, err := conn.NewStream(ctx, &serviceDesc.Streams[0], "/"+ServiceName+"/Send")
+	if err != nil {
+		t.Fatalf("NewStream() error:\n%+v", err)
+	}
+
+	// Send two messages
+	for _, message := range [][]byte{[]byte("hello world!"), []byte("goodbye!")} {
+		if err := stream.SendMsg(&message); err != nil {
+			t.Fatalf("SendMsg() error:\n%+v", err)
+		}
+		select {
+		case got := <-ch:
+			if len(got) != 1 {
+				t.Fatalf("%d decoded flows received, expected 1", len(got))
+			}
+			if diff := helpers.Diff(got[0].ProtobufDebug[schema.ColumnInIfDescription], message); diff != "" {
+				t.Fatalf("Input data (-got, +want):\n%s", diff)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no decoded flows received")
+		}
+	}
+
+	// Close the stream
+	if err := stream.CloseSend(); err != nil {
+		t.Fatalf("CloseSend() error:\n%+v", err)
+	}
+	var response []byte
+	if err := stream.RecvMsg(&response); err != nil {
+		t.Fatalf("RecvMsg() error:\n%+v", err)
+	}
+	if len(response) != 0 {
+		t.Fatalf("RecvMsg() got %v, expected an empty message", response)
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// Check metrics
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_grpc_")
+	listener := configuration.Listen
+	expectedMetrics := map[string]string{
+		`bytes{exporter="127.0.0.1",listener="` + listener + `"}`:    "20",
+		`messages{exporter="127.0.0.1",listener="` + listener + `"}`: "2",
+		`streams{listener="` + listener + `"}`:                       "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestGRPCInputStopWithActiveStream(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+
+	conn, err := grpc.Dial(in.(*Input).address.String(),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(rawCodec{})))
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	defer conn.Close()
+	stream, err := conn.NewStream(context.Background(), &serviceDesc.Streams[0], "/"+ServiceName+"/Send")
+	if err != nil {
+		t.Fatalf("NewStream() error:\n%+v", err)
+	}
+	message := []byte("hello world!")
+	if err := stream.SendMsg(&message); err != nil {
+		t.Fatalf("SendMsg() error:\n%+v", err)
+	}
+	<-ch
+
+	// Stopping the input ends the stream
+	if err := in.Stop(); err != nil {
+		t.Fatalf("Stop() error:\n%+v", err)
+	}
+	var response []byte
+	if err := stream.RecvMsg(&response); err == nil {
+		t.Fatal("RecvMsg() did not error after Stop()")
+	}
+}
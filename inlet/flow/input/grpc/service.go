@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package grpc
+
+import (
+	"fmt"
+
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the name of the gRPC service. It has a single
+// client-streaming method, Send, accepting flows encoded with the protobuf
+// schema of the inlet and returning an empty message once the client closes
+// the stream.
+const ServiceName = "akvorado.inlet.v0.FlowService"
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*interface{})(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName: "Send",
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(*Input).handleStream(stream)
+			},
+			ClientStreams: true,
+		},
+	},
+}
+
+// rawCodec is a codec keeping messages as bytes. Received flows are decoded
+// by the decoder of the input, while responses are empty messages. It is
+// named "proto" as it is compatible with clients using protobuf.
+type rawCodec struct{}
+
+// Marshal returns the provided bytes.
+func (rawCodec) Marshal(v interface{}) ([]byte, error) {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return nil, fmt.Errorf("unexpected message type %T", v)
+	}
+	return *b, nil
+}
+
+// Unmarshal stores the received bytes.
+func (rawCodec) Unmarshal(data []byte, v interface{}) error {
+	b, ok := v.(*[]byte)
+	if !ok {
+		return fmt.Errorf("unexpected message type %T", v)
+	}
+	*b = data
+	return nil
+}
+
+// Name returns the name of the codec.
+func (rawCodec) Name() string {
+	return "proto"
+}
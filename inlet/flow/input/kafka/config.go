@@ -0,0 +1,35 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"akvorado/common/kafka"
+	"akvorado/inlet/flow/input"
+)
+
+// Configuration describes Kafka input configuration.
+type Configuration struct {
+	kafka.Configuration `mapstructure:",squash" yaml:"-,inline"`
+	// ConsumerGroup is the name of the consumer group to use.
+	ConsumerGroup string `validate:"required"`
+	// StartFromOldest tells to start from the oldest message when the
+	// consumer group has no committed offset. This is useful to replay
+	// a topic. Otherwise, only new messages are consumed.
+	StartFromOldest bool
+	// QueueSize defines the size of the channel used to
+	// communicate incoming flows. 0 can be used to disable
+	// buffering.
+	QueueSize uint
+}
+
+// DefaultConfiguration is the default configuration for this input
+func DefaultConfiguration() input.Configuration {
+	configuration := kafka.DefaultConfiguration()
+	configuration.Topic = "raw-flows"
+	return &Configuration{
+		Configuration: configuration,
+		ConsumerGroup: "akvorado-inlet",
+		QueueSize:     1000,
+	}
+}
@@ -0,0 +1,229 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package kafka handles Kafka inputs. Each message is an exported datagram,
+// keyed by the IP address of the exporter. This enables a buffering tier in
+// front of the inlet and the replay of past datagrams.
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/daemon"
+	"akvorado/common/discovery"
+	"akvorado/common/kafka"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+	"akvorado/inlet/flow/input"
+)
+
+// Input represents the state of a Kafka consumer.
+type Input struct {
+	r      *reporter.Reporter
+	t      tomb.Tomb
+	config *Configuration
+
+	metrics struct {
+		bytes    *reporter.CounterVec
+		messages *reporter.CounterVec
+		errors   *reporter.CounterVec
+	}
+
+	kafkaConfig         *sarama.Config
+	kafkaDiscovery      *discovery.Resolver
+	createConsumerGroup func() (sarama.ConsumerGroup, error)
+
+	ch      chan []*schema.FlowMessage // channel to send flows to
+	decoder decoder.Decoder            // decoder to use
+}
+
+// New instantiate a new Kafka consumer from the provided configuration.
+func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Component, dec decoder.Decoder) (input.Input, error) {
+	kafkaConfig, err := kafka.NewConfig(configuration.Configuration)
+	if err != nil {
+		return nil, err
+	}
+	kafkaConfig.Consumer.Return.Errors = true
+	kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetNewest
+	if configuration.StartFromOldest {
+		kafkaConfig.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+	kafkaDiscovery, err := kafka.NewResolver(r, configuration.Configuration, kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
+	if err := kafkaConfig.Validate(); err != nil {
+		return nil, fmt.Errorf("cannot validate Kafka configuration: %w", err)
+	}
+
+	input := &Input{
+		r:              r,
+		config:         configuration,
+		kafkaConfig:    kafkaConfig,
+		kafkaDiscovery: kafkaDiscovery,
+		ch:             make(chan []*schema.FlowMessage, configuration.QueueSize),
+		decoder:        dec,
+	}
+	input.createConsumerGroup = func() (sarama.ConsumerGroup, error) {
+		return sarama.NewConsumerGroup(input.kafkaDiscovery.Addresses(),
+			input.config.ConsumerGroup, input.kafkaConfig)
+	}
+
+	input.metrics.bytes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "bytes",
+			Help: "Bytes received by the application.",
+		},
+		[]string{"topic", "exporter"},
+	)
+	input.metrics.messages = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "messages",
+			Help: "Messages received by the application.",
+		},
+		[]string{"topic", "exporter"},
+	)
+	input.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors",
+			Help: "Errors while receiving messages by the application.",
+		},
+		[]string{"topic", "error"},
+	)
+
+	daemon.Track(&input.t, "inlet/flow/input/kafka")
+	return input, nil
+}
+
+// Start starts consuming the Kafka topic and producing flows.
+func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
+	in.r.Info().Str("topic", in.config.Topic).Msg("starting Kafka input")
+	if err := in.kafkaDiscovery.Start(); err != nil {
+		return nil, err
+	}
+	consumerGroup, err := in.createConsumerGroup()
+	if err != nil {
+		in.kafkaDiscovery.Stop()
+		return nil, fmt.Errorf("unable to create Kafka consumer group: %w", err)
+	}
+
+	topic := in.config.Topic
+	errLogger := in.r.With().Str("topic", topic).Logger().
+		Sample(reporter.BurstSampler(time.Minute, 1))
+	ctx := in.t.Context(nil)
+	in.t.Go(func() error {
+		for {
+			// Consume returns on rebalance, it should be called again
+			err := consumerGroup.Consume(ctx, []string{topic}, in)
+			if ctx.Err() != nil || errors.Is(err, sarama.ErrClosedConsumerGroup) {
+				return nil
+			}
+			if err != nil {
+				errLogger.Err(err).Msg("unable to consume from Kafka")
+				in.metrics.errors.WithLabelValues(topic, "consume").Inc()
+				select {
+				case <-in.t.Dying():
+					return nil
+				case <-time.After(time.Second):
+				}
+			}
+		}
+	})
+	in.t.Go(func() error {
+		for {
+			select {
+			case <-in.t.Dying():
+				return nil
+			case err, ok := <-consumerGroup.Errors():
+				if !ok {
+					return nil
+				}
+				errLogger.Err(err).Msg("error while consuming from Kafka")
+				in.metrics.errors.WithLabelValues(topic, "consumer").Inc()
+			}
+		}
+	})
+
+	// Watch for termination and close on dying
+	in.t.Go(func() error {
+		<-in.t.Dying()
+		consumerGroup.Close()
+		in.kafkaDiscovery.Stop()
+		return nil
+	})
+
+	return in.ch, nil
+}
+
+// Setup is run at the beginning of a new session.
+func (in *Input) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is run at the end of a session.
+func (in *Input) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim decodes the messages of a claim. As flows are sent to the
+// channel without dropping them, Kafka buffers the messages when the inlet
+// is not fast enough.
+func (in *Input) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	topic := in.config.Topic
+	errLogger := in.r.With().Str("topic", topic).Logger().
+		Sample(reporter.BurstSampler(time.Minute, 1))
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			session.MarkMessage(msg, "")
+			source := net.ParseIP(string(msg.Key))
+			if source == nil {
+				errLogger.Error().Msgf("invalid exporter address %q", string(msg.Key))
+				in.metrics.errors.WithLabelValues(topic, "invalid exporter").Inc()
+				continue
+			}
+			exporter := source.String()
+			in.metrics.bytes.WithLabelValues(topic, exporter).Add(float64(len(msg.Value)))
+			in.metrics.messages.WithLabelValues(topic, exporter).Inc()
+			timeReceived := msg.Timestamp
+			if timeReceived.IsZero() {
+				timeReceived = time.Now()
+			}
+			flows := in.decoder.Decode(decoder.RawFlow{
+				TimeReceived: timeReceived,
+				Payload:      msg.Value,
+				Source:       source,
+			})
+			if len(flows) == 0 {
+				continue
+			}
+			select {
+			case <-session.Context().Done():
+				return nil
+			case in.ch <- flows:
+			}
+		}
+	}
+}
+
+// Stop stops the Kafka consumer
+func (in *Input) Stop() error {
+	defer func() {
+		close(in.ch)
+		in.r.Info().Str("topic", in.config.Topic).Msg("Kafka input stopped")
+	}()
+	in.t.Kill(nil)
+	return in.t.Wait()
+}
@@ -0,0 +1,131 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+type fakeConsumerGroup struct {
+	sarama.ConsumerGroup
+	messages chan *sarama.ConsumerMessage
+	errors   chan error
+}
+
+func (cg *fakeConsumerGroup) Consume(ctx context.Context, _ []string, handler sarama.ConsumerGroupHandler) error {
+	session := &fakeSession{ctx: ctx}
+	handler.Setup(session)
+	defer handler.Cleanup(session)
+	return handler.ConsumeClaim(session, &fakeClaim{messages: cg.messages})
+}
+
+func (cg *fakeConsumerGroup) Errors() <-chan error {
+	return cg.errors
+}
+
+func (cg *fakeConsumerGroup) Close() error {
+	return nil
+}
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked int
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) MarkMessage(*sarama.ConsumerMessage, string) {
+	s.marked++
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+func TestKafkaInput(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	consumerGroup := &fakeConsumerGroup{
+		messages: make(chan *sarama.ConsumerMessage),
+		errors:   make(chan error),
+	}
+	in.(*Input).createConsumerGroup = func() (sarama.ConsumerGroup, error) {
+		return consumerGroup, nil
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	now := time.Date(2023, time.May, 10, 10, 0, 0, 0, time.UTC)
+	consumerGroup.messages <- &sarama.ConsumerMessage{
+		Key:       []byte("not an IP"),
+		Value:     []byte("hello world!"),
+		Timestamp: now,
+	}
+	consumerGroup.messages <- &sarama.ConsumerMessage{
+		Key:       []byte("192.0.2.1"),
+		Value:     []byte("hello world!"),
+		Timestamp: now,
+	}
+
+	var got []*schema.FlowMessage
+	select {
+	case got = <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("no decoded flows received")
+	}
+	expected := []*schema.FlowMessage{
+		{
+			TimeReceived:    uint64(now.Unix()),
+			ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:           12,
+				schema.ColumnPackets:         1,
+				schema.ColumnInIfDescription: []byte("hello world!"),
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Input data (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_kafka_")
+	expectedMetrics := map[string]string{
+		`bytes{exporter="192.0.2.1",topic="raw-flows"}`:      "12",
+		`errors{error="invalid exporter",topic="raw-flows"}`: "1",
+		`messages{exporter="192.0.2.1",topic="raw-flows"}`:   "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
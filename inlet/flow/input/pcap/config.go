@@ -0,0 +1,26 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package pcap
+
+import "akvorado/inlet/flow/input"
+
+// Configuration describes pcap input configuration.
+type Configuration struct {
+	// Path is the pcap file to read from. "-" reads from the standard input.
+	Path string `validate:"required"`
+	// Speed is the replay speed factor. 1 replays packets at their original
+	// pace, 10 replays them ten times faster. 0 replays them as fast as
+	// possible.
+	Speed float64 `validate:"min=0"`
+	// Loop tells to replay the file again once its end is reached. This is
+	// not possible when reading from the standard input.
+	Loop bool
+}
+
+// DefaultConfiguration is the default configuration for this input
+func DefaultConfiguration() input.Configuration {
+	return &Configuration{
+		Speed: 1,
+	}
+}
@@ -0,0 +1,18 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package pcap
+
+import (
+	"testing"
+
+	"akvorado/common/helpers"
+)
+
+func TestDefaultConfiguration(t *testing.T) {
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Path = "/tmp/flows.pcap"
+	if err := helpers.Validate.Struct(configuration); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+}
@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package pcap replays UDP datagrams from a pcap file. This is useful to
+// debug decoding issues or for load testing.
+package pcap
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/daemon"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+	"akvorado/inlet/flow/input"
+)
+
+// pcapngMagic is the block type of the section header of a pcapng file.
+var pcapngMagic = []byte{0x0a, 0x0d, 0x0d, 0x0a}
+
+// Input represents the state of a pcap input.
+type Input struct {
+	r      *reporter.Reporter
+	t      tomb.Tomb
+	config *Configuration
+
+	metrics struct {
+		bytes    *reporter.CounterVec
+		messages *reporter.CounterVec
+		errors   *reporter.CounterVec
+	}
+
+	ch      chan []*schema.FlowMessage // channel to send flows to
+	decoder decoder.Decoder            // decoder to use
+	stdin   io.Reader                  // standard input, for testing purpose
+}
+
+// packetReader is the common interface of pcap and pcapng readers.
+type packetReader interface {
+	ReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+	LinkType() layers.LinkType
+}
+
+// New instantiate a new pcap input from the provided configuration.
+func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Component, dec decoder.Decoder) (input.Input, error) {
+	if configuration.Path == "" {
+		return nil, errors.New("no path provided for pcap input")
+	}
+	if configuration.Loop && configuration.Path == "-" {
+		return nil, errors.New("cannot loop over standard input for pcap input")
+	}
+	input := &Input{
+		r:       r,
+		config:  configuration,
+		ch:      make(chan []*schema.FlowMessage),
+		decoder: dec,
+		stdin:   os.Stdin,
+	}
+
+	input.metrics.bytes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "bytes",
+			Help: "Bytes replayed by the application.",
+		},
+		[]string{"path", "exporter"},
+	)
+	input.metrics.messages = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "messages",
+			Help: "Messages replayed by the application.",
+		},
+		[]string{"path", "exporter"},
+	)
+	input.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors",
+			Help: "Errors while replaying messages by the application.",
+		},
+		[]string{"path", "error"},
+	)
+
+	daemon.Track(&input.t, "inlet/flow/input/pcap")
+	return input, nil
+}
+
+// Start starts replaying the pcap file and producing flows.
+func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
+	in.r.Info().Str("path", in.config.Path).Msg("starting pcap input")
+	in.t.Go(func() error {
+		for {
+			if err := in.replay(); err != nil {
+				in.r.Err(err).Str("path", in.config.Path).Msg("unable to replay pcap file")
+				return err
+			}
+			if !in.config.Loop {
+				break
+			}
+		}
+		in.r.Info().Str("path", in.config.Path).Msg("pcap replay finished")
+		// Do not terminate the daemon
+		<-in.t.Dying()
+		return nil
+	})
+	return in.ch, nil
+}
+
+// replay replays the pcap file once.
+func (in *Input) replay() error {
+	path := in.config.Path
+	var source io.Reader
+	if path == "-" {
+		source = in.stdin
+	} else {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		source = f
+	}
+	reader, err := newPacketReader(source)
+	if err != nil {
+		return err
+	}
+
+	errLogger := in.r.With().Str("path", path).Logger().
+		Sample(reporter.BurstSampler(time.Minute, 1))
+	var firstPacket, start time.Time
+	for {
+		data, ci, err := reader.ReadPacketData()
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("unable to read packet: %w", err)
+		}
+
+		// Wait for the right time to send this packet
+		if in.config.Speed > 0 {
+			if firstPacket.IsZero() {
+				firstPacket = ci.Timestamp
+				start = time.Now()
+			}
+			elapsed := time.Duration(float64(ci.Timestamp.Sub(firstPacket)) / in.config.Speed)
+			if delay := elapsed - time.Since(start); delay > 0 {
+				select {
+				case <-in.t.Dying():
+					return nil
+				case <-time.After(delay):
+				}
+			}
+		}
+
+		packet := gopacket.NewPacket(data, reader.LinkType(), gopacket.DecodeOptions{
+			Lazy:   true,
+			NoCopy: true,
+		})
+		network := packet.NetworkLayer()
+		udp, ok := packet.Layer(layers.LayerTypeUDP).(*layers.UDP)
+		if network == nil || !ok {
+			errLogger.Warn().Msg("skipping non-UDP packet")
+			in.metrics.errors.WithLabelValues(path, "not UDP").Inc()
+			continue
+		}
+		exporter := net.IP(network.NetworkFlow().Src().Raw())
+		payload := udp.Payload
+		in.metrics.bytes.WithLabelValues(path, exporter.String()).Add(float64(len(payload)))
+		in.metrics.messages.WithLabelValues(path, exporter.String()).Inc()
+		flows := in.decoder.Decode(decoder.RawFlow{
+			TimeReceived: time.Now(),
+			Payload:      payload,
+			Source:       exporter,
+		})
+		if len(flows) == 0 {
+			continue
+		}
+		select {
+		case <-in.t.Dying():
+			return nil
+		case in.ch <- flows:
+		}
+	}
+}
+
+// newPacketReader returns a reader for a pcap or a pcapng file.
+func newPacketReader(source io.Reader) (packetReader, error) {
+	buffered := bufio.NewReader(source)
+	magic, err := buffered.Peek(len(pcapngMagic))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read pcap header: %w", err)
+	}
+	if bytes.Equal(magic, pcapngMagic) {
+		return pcapgo.NewNgReader(buffered, pcapgo.DefaultNgReaderOptions)
+	}
+	return pcapgo.NewReader(buffered)
+}
+
+// Stop stops the pcap input
+func (in *Input) Stop() error {
+	defer func() {
+		close(in.ch)
+		in.r.Info().Str("path", in.config.Path).Msg("pcap input stopped")
+	}()
+	in.t.Kill(nil)
+	return in.t.Wait()
+}
@@ -0,0 +1,210 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package pcap
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// writePcap writes a pcap file with two UDP datagrams spaced by 200 ms and
+// one TCP segment.
+func writePcap(t *testing.T) string {
+	t.Helper()
+	var buf bytes.Buffer
+	w := pcapgo.NewWriter(&buf)
+	if err := w.WriteFileHeader(65535, layers.LinkTypeEthernet); err != nil {
+		t.Fatalf("WriteFileHeader() error:\n%+v", err)
+	}
+	start := time.Date(2023, time.May, 10, 10, 0, 0, 0, time.UTC)
+	for idx, packet := range []struct {
+		offset  time.Duration
+		source  string
+		tcp     bool
+		payload string
+	}{
+		{0, "192.0.2.1", false, "hello world!"},
+		{100 * time.Millisecond, "192.0.2.3", true, "tcp"},
+		{200 * time.Millisecond, "2001:db8::1", false, "goodbye!"},
+	} {
+		eth := &layers.Ethernet{
+			SrcMAC: net.HardwareAddr{0, 1, 2, 3, 4, 5},
+			DstMAC: net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		}
+		var network gopacket.NetworkLayer
+		source := net.ParseIP(packet.source)
+		if ip4 := source.To4(); ip4 != nil {
+			eth.EthernetType = layers.EthernetTypeIPv4
+			network = &layers.IPv4{
+				Version:  4,
+				TTL:      64,
+				Protocol: layers.IPProtocolUDP,
+				SrcIP:    ip4,
+				DstIP:    net.ParseIP("192.0.2.2").To4(),
+			}
+		} else {
+			eth.EthernetType = layers.EthernetTypeIPv6
+			network = &layers.IPv6{
+				Version:    6,
+				HopLimit:   64,
+				NextHeader: layers.IPProtocolUDP,
+				SrcIP:      source,
+				DstIP:      net.ParseIP("2001:db8::2"),
+			}
+		}
+		var transport gopacket.SerializableLayer
+		if packet.tcp {
+			network.(*layers.IPv4).Protocol = layers.IPProtocolTCP
+			tcp := &layers.TCP{SrcPort: 10000, DstPort: 4739}
+			tcp.SetNetworkLayerForChecksum(network)
+			transport = tcp
+		} else {
+			udp := &layers.UDP{SrcPort: 10000, DstPort: 2055}
+			udp.SetNetworkLayerForChecksum(network)
+			transport = udp
+		}
+		out := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(out,
+			gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true},
+			eth, network.(gopacket.SerializableLayer), transport,
+			gopacket.Payload(packet.payload)); err != nil {
+			t.Fatalf("SerializeLayers(%d) error:\n%+v", idx, err)
+		}
+		if err := w.WritePacket(gopacket.CaptureInfo{
+			Timestamp:     start.Add(packet.offset),
+			CaptureLength: len(out.Bytes()),
+			Length:        len(out.Bytes()),
+		}, out.Bytes()); err != nil {
+			t.Fatalf("WritePacket(%d) error:\n%+v", idx, err)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "flows.pcap")
+	if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+	return path
+}
+
+func TestPcapInput(t *testing.T) {
+	path := writePcap(t)
+	cases := []struct {
+		Speed       float64
+		MinDuration time.Duration
+		MaxDuration time.Duration
+	}{
+		{0, 0, 100 * time.Millisecond},
+		{1, 200 * time.Millisecond, time.Second},
+		{4, 50 * time.Millisecond, 150 * time.Millisecond},
+	}
+	for _, tc := range cases {
+		t.Run(fmt.Sprintf("speed %.0f", tc.Speed), func(t *testing.T) {
+			r := reporter.NewMock(t)
+			configuration := DefaultConfiguration().(*Configuration)
+			configuration.Path = path
+			configuration.Speed = tc.Speed
+			in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+			if err != nil {
+				t.Fatalf("New() error:\n%+v", err)
+			}
+			start := time.Now()
+			ch, err := in.Start()
+			if err != nil {
+				t.Fatalf("Start() error:\n%+v", err)
+			}
+			defer func() {
+				if err := in.Stop(); err != nil {
+					t.Fatalf("Stop() error:\n%+v", err)
+				}
+			}()
+
+			got := []string{}
+			for i := 0; i < 2; i++ {
+				select {
+				case flows := <-ch:
+					for _, flow := range flows {
+						got = append(got, fmt.Sprintf("%s %s",
+							flow.ExporterAddress.Unmap(),
+							flow.ProtobufDebug[schema.ColumnInIfDescription]))
+					}
+				case <-time.After(time.Second):
+					t.Fatal("no decoded flows received")
+				}
+			}
+			elapsed := time.Since(start)
+			if elapsed < tc.MinDuration || elapsed > tc.MaxDuration {
+				t.Errorf("replay took %s, expected between %s and %s",
+					elapsed, tc.MinDuration, tc.MaxDuration)
+			}
+			expected := []string{"192.0.2.1 hello world!", "2001:db8::1 goodbye!"}
+			if diff := helpers.Diff(got, expected); diff != "" {
+				t.Fatalf("Input data (-got, +want):\n%s", diff)
+			}
+
+			gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_pcap_")
+			expectedMetrics := map[string]string{
+				`bytes{exporter="192.0.2.1",path="` + path + `"}`:      "12",
+				`bytes{exporter="2001:db8::1",path="` + path + `"}`:    "8",
+				`errors{error="not UDP",path="` + path + `"}`:          "1",
+				`messages{exporter="192.0.2.1",path="` + path + `"}`:   "1",
+				`messages{exporter="2001:db8::1",path="` + path + `"}`: "1",
+			}
+			if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+				t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPcapInputStdin(t *testing.T) {
+	content, err := os.ReadFile(writePcap(t))
+	if err != nil {
+		t.Fatalf("ReadFile() error:\n%+v", err)
+	}
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Path = "-"
+	configuration.Speed = 0
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	in.(*Input).stdin = bytes.NewReader(content)
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatal("no decoded flows received")
+		}
+	}
+
+	loopConfiguration := *configuration
+	loopConfiguration.Loop = true
+	if _, err := loopConfiguration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)}); err == nil {
+		t.Fatal("New() did not error when looping over stdin")
+	}
+}
@@ -0,0 +1,54 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package tcp
+
+import (
+	"time"
+
+	"akvorado/inlet/flow/input"
+)
+
+// Configuration describes TCP input configuration.
+type Configuration struct {
+	// Listen tells which port to listen to. It can also be "systemd:"
+	// followed by the name of a socket passed by systemd.
+	Listen string `validate:"required,listen|startswith=systemd:"`
+	// MaxConnections is the maximum number of simultaneous connections.
+	// Additional connections are closed immediately.
+	MaxConnections int `validate:"min=1"`
+	// IdleTimeout is the duration after which a connection without any
+	// message is closed.
+	IdleTimeout time.Duration `validate:"min=1s"`
+	// QueueSize defines the size of the channel used to
+	// communicate incoming flows. 0 can be used to disable
+	// buffering.
+	QueueSize uint
+	// TLS defines TLS configuration
+	TLS TLSConfiguration
+}
+
+// TLSConfiguration defines TLS configuration.
+type TLSConfiguration struct {
+	// Enable says if TLS should be used for incoming connections
+	Enable bool `validate:"required_with=CertFile KeyFile CAFile"`
+	// CertFile tells the location of the server certificate.
+	CertFile string `validate:"required_with=Enable KeyFile"`
+	// KeyFile tells the location of the server key. If empty, the key is
+	// read from the certificate file.
+	KeyFile string
+	// CAFile tells the location of the CA certificate to check client
+	// certificates. When set, exporters have to present a valid
+	// certificate.
+	CAFile string
+}
+
+// DefaultConfiguration is the default configuration for this input
+func DefaultConfiguration() input.Configuration {
+	return &Configuration{
+		Listen:         "0.0.0.0:0",
+		MaxConnections: 100,
+		IdleTimeout:    10 * time.Minute,
+		QueueSize:      100000,
+	}
+}
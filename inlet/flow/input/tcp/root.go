@@ -0,0 +1,319 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package tcp handles TCP listeners. Only IPFIX can be transported over TCP
+// as other protocols do not provide a way to delimit messages.
+package tcp
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers/systemd"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+	"akvorado/inlet/flow/input"
+)
+
+const (
+	// ipfixVersion is the version of IPFIX messages
+	ipfixVersion = 10
+	// ipfixHeaderLength is the length of the IPFIX message header
+	ipfixHeaderLength = 16
+)
+
+// Input represents the state of a TCP listener.
+type Input struct {
+	r      *reporter.Reporter
+	t      tomb.Tomb
+	config *Configuration
+
+	metrics struct {
+		bytes               *reporter.CounterVec
+		messages            *reporter.CounterVec
+		errors              *reporter.CounterVec
+		outDrops            *reporter.CounterVec
+		connections         *reporter.GaugeVec
+		acceptedConnections *reporter.CounterVec
+		rejectedConnections *reporter.CounterVec
+	}
+
+	address   net.Addr                   // listening address, for testing purpoese
+	ch        chan []*schema.FlowMessage // channel to send flows to
+	decoder   decoder.Decoder            // decoder to use
+	tlsConfig *tls.Config                // TLS configuration, nil without TLS
+
+	connsLock sync.Mutex
+	conns     map[net.Conn]struct{} // active connections
+	closed    bool                  // no new connection should be accepted
+}
+
+// New instantiate a new TCP listener from the provided configuration.
+func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Component, dec decoder.Decoder) (input.Input, error) {
+	tlsConfig, err := newTLSConfig(configuration.TLS)
+	if err != nil {
+		return nil, err
+	}
+	input := &Input{
+		r:         r,
+		config:    configuration,
+		ch:        make(chan []*schema.FlowMessage, configuration.QueueSize),
+		decoder:   dec,
+		tlsConfig: tlsConfig,
+		conns:     map[net.Conn]struct{}{},
+	}
+
+	input.metrics.bytes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "bytes",
+			Help: "Bytes received by the application.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.messages = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "messages",
+			Help: "Messages received by the application.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors",
+			Help: "Errors while receiving messages by the application.",
+		},
+		[]string{"listener", "exporter", "error"},
+	)
+	input.metrics.outDrops = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "out_drops",
+			Help: "Dropped messages due to internal queue full.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.connections = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "connections",
+			Help: "Number of active connections.",
+		},
+		[]string{"listener"},
+	)
+	input.metrics.acceptedConnections = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "accepted_connections_total",
+			Help: "Number of accepted connections.",
+		},
+		[]string{"listener", "exporter"},
+	)
+	input.metrics.rejectedConnections = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "rejected_connections_total",
+			Help: "Number of connections rejected because of the connection limit.",
+		},
+		[]string{"listener", "exporter"},
+	)
+
+	daemon.Track(&input.t, "inlet/flow/input/tcp")
+	return input, nil
+}
+
+// Start starts listening to the provided TCP socket and producing flows.
+func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
+	in.r.Info().Str("listen", in.config.Listen).Msg("starting TCP input")
+
+	var listener net.Listener
+	if strings.HasPrefix(in.config.Listen, systemd.Prefix) {
+		name := strings.TrimPrefix(in.config.Listen, systemd.Prefix)
+		files := systemd.Files(name)
+		if len(files) == 0 {
+			return nil, fmt.Errorf("no socket %q passed by systemd", name)
+		}
+		var err error
+		listener, err = net.FileListener(files[0])
+		if err != nil {
+			return nil, fmt.Errorf("unable to use socket %v: %w", in.config.Listen, err)
+		}
+	} else {
+		var err error
+		listener, err = net.Listen("tcp", in.config.Listen)
+		if err != nil {
+			return nil, fmt.Errorf("unable to listen to %v: %w", in.config.Listen, err)
+		}
+	}
+	in.address = listener.Addr()
+	in.r.Info().Str("listen", in.address.String()).Msg("TCP input listening")
+	if in.tlsConfig != nil {
+		listener = tls.NewListener(listener, in.tlsConfig)
+	}
+
+	listen := in.config.Listen
+	errLogger := in.r.With().Str("listen", listen).Logger().
+		Sample(reporter.BurstSampler(time.Minute, 1))
+	in.t.Go(func() error {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return nil
+				}
+				errLogger.Err(err).Msg("unable to accept connection")
+				in.metrics.errors.WithLabelValues(listen, "", "accept").Inc()
+				continue
+			}
+			exporter := remoteIP(conn).String()
+
+			in.connsLock.Lock()
+			if in.closed {
+				in.connsLock.Unlock()
+				conn.Close()
+				return nil
+			}
+			if len(in.conns) >= in.config.MaxConnections {
+				in.connsLock.Unlock()
+				errLogger.Warn().Str("exporter", exporter).
+					Msgf("rejecting connection due to connection limit (%d)", in.config.MaxConnections)
+				in.metrics.rejectedConnections.WithLabelValues(listen, exporter).Inc()
+				conn.Close()
+				continue
+			}
+			in.conns[conn] = struct{}{}
+			in.metrics.connections.WithLabelValues(listen).Set(float64(len(in.conns)))
+			in.connsLock.Unlock()
+
+			in.metrics.acceptedConnections.WithLabelValues(listen, exporter).Inc()
+			in.t.Go(func() error {
+				in.handleConnection(conn)
+				return nil
+			})
+		}
+	})
+
+	// Watch for termination and close on dying
+	in.t.Go(func() error {
+		<-in.t.Dying()
+		listener.Close()
+		in.connsLock.Lock()
+		in.closed = true
+		for conn := range in.conns {
+			conn.Close()
+		}
+		in.connsLock.Unlock()
+		return nil
+	})
+
+	return in.ch, nil
+}
+
+// handleConnection reads IPFIX messages from a connection until it is closed
+// or until an error happens.
+func (in *Input) handleConnection(conn net.Conn) {
+	listen := in.config.Listen
+	source := remoteIP(conn)
+	exporter := source.String()
+	l := in.r.With().
+		Str("listen", listen).
+		Str("exporter", exporter).
+		Logger()
+	errLogger := l.Sample(reporter.BurstSampler(time.Minute, 1))
+	defer func() {
+		conn.Close()
+		in.connsLock.Lock()
+		delete(in.conns, conn)
+		in.metrics.connections.WithLabelValues(listen).Set(float64(len(in.conns)))
+		in.connsLock.Unlock()
+		l.Debug().Msg("connection closed")
+	}()
+	l.Debug().Msg("new connection")
+
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		tlsConn.SetDeadline(time.Now().Add(in.config.IdleTimeout))
+		if err := tlsConn.Handshake(); err != nil {
+			l.Err(err).Msg("TLS handshake failed")
+			in.metrics.errors.WithLabelValues(listen, exporter, "handshake").Inc()
+			return
+		}
+		tlsConn.SetDeadline(time.Time{})
+	}
+
+	reader := bufio.NewReader(conn)
+	payload := make([]byte, 65535)
+	for {
+		conn.SetReadDeadline(time.Now().Add(in.config.IdleTimeout))
+
+		// Read the header to get the length of the message
+		if _, err := io.ReadFull(reader, payload[:4]); err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.Err(err).Msg("unable to read message header")
+			in.metrics.errors.WithLabelValues(listen, exporter, "read").Inc()
+			return
+		}
+		version := binary.BigEndian.Uint16(payload[0:2])
+		length := int(binary.BigEndian.Uint16(payload[2:4]))
+		if version != ipfixVersion || length < ipfixHeaderLength {
+			// We cannot find the next message, close the connection
+			l.Error().Msgf("invalid message (version %d, length %d)", version, length)
+			in.metrics.errors.WithLabelValues(listen, exporter, "framing").Inc()
+			return
+		}
+		if _, err := io.ReadFull(reader, payload[4:length]); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.Err(err).Msg("unable to read message")
+			in.metrics.errors.WithLabelValues(listen, exporter, "read").Inc()
+			return
+		}
+
+		in.metrics.bytes.WithLabelValues(listen, exporter).Add(float64(length))
+		in.metrics.messages.WithLabelValues(listen, exporter).Inc()
+		flows := in.decoder.Decode(decoder.RawFlow{
+			TimeReceived: time.Now(),
+			Payload:      payload[:length],
+			Source:       source,
+		})
+		if len(flows) == 0 {
+			continue
+		}
+		select {
+		case <-in.t.Dying():
+			return
+		case in.ch <- flows:
+		default:
+			errLogger.Warn().Msgf("dropping flow due to queue full (size %d)", in.config.QueueSize)
+			in.metrics.outDrops.WithLabelValues(listen, exporter).Inc()
+		}
+	}
+}
+
+// remoteIP returns the IP address of the remote end of a connection.
+func remoteIP(conn net.Conn) net.IP {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP
+	}
+	return net.IPv6unspecified
+}
+
+// Stop stops the TCP listener
+func (in *Input) Stop() error {
+	l := in.r.With().Str("listen", in.config.Listen).Logger()
+	defer func() {
+		close(in.ch)
+		l.Info().Msg("TCP listener stopped")
+	}()
+	in.t.Kill(nil)
+	return in.t.Wait()
+}
@@ -0,0 +1,163 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package tcp
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/helpers/systemd"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// ipfixMessage builds a fake IPFIX message with the provided content.
+func ipfixMessage(content string) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint16(10))
+	binary.Write(&buf, binary.BigEndian, uint16(ipfixHeaderLength+len(content)))
+	buf.Write(make([]byte, ipfixHeaderLength-4))
+	buf.WriteString(content)
+	return buf.Bytes()
+}
+
+func TestTCPInput(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	configuration.MaxConnections = 1
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	// Connect
+	conn, err := net.Dial("tcp", in.(*Input).address.String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	defer conn.Close()
+
+	// Send two messages, each in two parts
+	for _, message := range [][]byte{ipfixMessage("hello world!"), ipfixMessage("goodbye!")} {
+		if _, err := conn.Write(message[:3]); err != nil {
+			t.Fatalf("Write() error:\n%+v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+		if _, err := conn.Write(message[3:]); err != nil {
+			t.Fatalf("Write() error:\n%+v", err)
+		}
+		select {
+		case got := <-ch:
+			if len(got) != 1 {
+				t.Fatalf("%d decoded flows received, expected 1", len(got))
+			}
+			if diff := helpers.Diff(got[0].ProtobufDebug[schema.ColumnInIfDescription], message); diff != "" {
+				t.Fatalf("Input data (-got, +want):\n%s", diff)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no decoded flows received")
+		}
+	}
+
+	// A second connection is rejected
+	conn2, err := net.Dial("tcp", in.(*Input).address.String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	defer conn2.Close()
+	conn2.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn2.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Read() on rejected connection did not error")
+	}
+
+	// Invalid framing closes the connection
+	if _, err := conn.Write([]byte{0, 9, 0, 20}); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, err := conn.Read(make([]byte, 1)); err == nil {
+		t.Fatal("Read() after invalid message did not error")
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	// Check metrics
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_tcp_")
+	listener := configuration.Listen
+	expectedMetrics := map[string]string{
+		`accepted_connections_total{exporter="127.0.0.1",listener="` + listener + `"}`: "1",
+		`bytes{exporter="127.0.0.1",listener="` + listener + `"}`:                      "52",
+		`connections{listener="` + listener + `"}`:                                     "0",
+		`errors{error="framing",exporter="127.0.0.1",listener="` + listener + `"}`:     "1",
+		`messages{exporter="127.0.0.1",listener="` + listener + `"}`:                   "2",
+		`rejected_connections_total{exporter="127.0.0.1",listener="` + listener + `"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestSystemdSocket(t *testing.T) {
+	// Socket passed by systemd
+	listener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("ListenTCP() error:\n%+v", err)
+	}
+	defer listener.Close()
+	file, err := listener.File()
+	if err != nil {
+		t.Fatalf("File() error:\n%+v", err)
+	}
+	defer file.Close()
+	systemd.MockFiles(t, "ipfix", file)
+
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "systemd:ipfix"
+	if err := helpers.Validate.Struct(configuration); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write(ipfixMessage("hello world!")); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatal("no decoded flows received")
+	}
+}
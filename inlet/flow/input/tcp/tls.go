@@ -0,0 +1,46 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package tcp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// newTLSConfig returns the TLS configuration for the listener, or nil if TLS
+// is not enabled.
+func newTLSConfig(config TLSConfiguration) (*tls.Config, error) {
+	if !config.Enable {
+		return nil, nil
+	}
+	keyFile := config.KeyFile
+	if keyFile == "" {
+		keyFile = config.CertFile
+	}
+	cert, err := tls.LoadX509KeyPair(config.CertFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read server certificate: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	// Read CA certificate if provided to check client certificates
+	if config.CAFile != "" {
+		caCert, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA certificate: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, errors.New("cannot parse CA certificate")
+		}
+		tlsConfig.ClientCAs = caCertPool
+		tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConfig, nil
+}
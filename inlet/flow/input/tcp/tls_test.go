@@ -0,0 +1,178 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package tcp
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// generateCertificate creates a certificate signed by the provided parent (or
+// self-signed) and writes it with its key to the provided directory.
+func generateCertificate(t *testing.T, dir, name string, template *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error:\n%+v", err)
+	}
+	if parent == nil {
+		parent = template
+		parentKey = key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error:\n%+v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error:\n%+v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error:\n%+v", err)
+	}
+	file := filepath.Join(dir, name)
+	content := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...)
+	if err := os.WriteFile(file, content, 0o600); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+	return cert, key, file
+}
+
+func TestTCPInputTLS(t *testing.T) {
+	dir := t.TempDir()
+	notBefore := time.Now().Add(-time.Hour)
+	notAfter := time.Now().Add(time.Hour)
+	ca, caKey, caFile := generateCertificate(t, dir, "ca.pem", &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "CA"},
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, nil, nil)
+	_, _, serverFile := generateCertificate(t, dir, "server.pem", &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "inlet"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca, caKey)
+	_, _, clientFile := generateCertificate(t, dir, "client.pem", &x509.Certificate{
+		SerialNumber: big.NewInt(3),
+		Subject:      pkix.Name{CommonName: "exporter"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca, caKey)
+
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	configuration.TLS = TLSConfiguration{
+		Enable:   true,
+		CertFile: serverFile,
+		CAFile:   caFile,
+	}
+	if err := helpers.Validate.Struct(configuration); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	clientCert, err := tls.LoadX509KeyPair(clientFile, clientFile)
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair() error:\n%+v", err)
+	}
+
+	// Client with a valid certificate
+	conn, err := tls.Dial("tcp", in.(*Input).address.String(), &tls.Config{
+		RootCAs:      roots,
+		Certificates: []tls.Certificate{clientCert},
+	})
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	defer conn.Close()
+	message := ipfixMessage("hello world!")
+	if _, err := conn.Write(message); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	select {
+	case got := <-ch:
+		if diff := helpers.Diff(got[0].ProtobufDebug[schema.ColumnInIfDescription], message); diff != "" {
+			t.Fatalf("Input data (-got, +want):\n%s", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no decoded flows received")
+	}
+
+	// Client without certificate
+	conn2, err := tls.Dial("tcp", in.(*Input).address.String(), &tls.Config{
+		RootCAs: roots,
+	})
+	if err == nil {
+		// With TLS 1.3, the client may only notice on first read
+		defer conn2.Close()
+		conn2.SetReadDeadline(time.Now().Add(time.Second))
+		if _, err := conn2.Read(make([]byte, 1)); err == nil {
+			t.Fatal("Read() without client certificate did not error")
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_tcp_", "errors", "messages")
+	listener := configuration.Listen
+	expectedMetrics := map[string]string{
+		`errors{error="handshake",exporter="127.0.0.1",listener="` + listener + `"}`: "1",
+		`messages{exporter="127.0.0.1",listener="` + listener + `"}`:                 "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestTLSConfigurationValidation(t *testing.T) {
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.TLS.Enable = true
+	if err := helpers.Validate.Struct(configuration); err == nil {
+		t.Fatal("validate.Struct() did not error without certificate")
+	}
+}
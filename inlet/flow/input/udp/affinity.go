@@ -0,0 +1,76 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package udp
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// CPUSet is a set of CPUs. It is written using the same format as the Linux
+// kernel: a list of CPU numbers or ranges, like "0-3,8".
+type CPUSet []int
+
+// UnmarshalText parses a CPU set.
+func (cs *CPUSet) UnmarshalText(input []byte) error {
+	text := strings.TrimSpace(string(input))
+	if text == "" {
+		return errors.New("empty CPU set")
+	}
+	seen := map[int]bool{}
+	result := CPUSet{}
+	for _, elem := range strings.Split(text, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(elem), "-", 2)
+		first, err := strconv.ParseUint(bounds[0], 10, 16)
+		if err != nil {
+			return fmt.Errorf("cannot parse CPU %q", bounds[0])
+		}
+		last := first
+		if len(bounds) == 2 {
+			last, err = strconv.ParseUint(bounds[1], 10, 16)
+			if err != nil {
+				return fmt.Errorf("cannot parse CPU %q", bounds[1])
+			}
+			if last < first {
+				return fmt.Errorf("invalid CPU range %q", elem)
+			}
+		}
+		for cpu := int(first); cpu <= int(last); cpu++ {
+			if !seen[cpu] {
+				seen[cpu] = true
+				result = append(result, cpu)
+			}
+		}
+	}
+	sort.Ints(result)
+	*cs = result
+	return nil
+}
+
+// MarshalText turns a CPU set into text, using ranges when possible.
+func (cs CPUSet) MarshalText() ([]byte, error) {
+	elems := []string{}
+	for i := 0; i < len(cs); i++ {
+		j := i
+		for j+1 < len(cs) && cs[j+1] == cs[j]+1 {
+			j++
+		}
+		if j > i {
+			elems = append(elems, fmt.Sprintf("%d-%d", cs[i], cs[j]))
+		} else {
+			elems = append(elems, strconv.Itoa(cs[i]))
+		}
+		i = j
+	}
+	return []byte(strings.Join(elems, ",")), nil
+}
+
+// String turns a CPU set into a string.
+func (cs CPUSet) String() string {
+	text, _ := cs.MarshalText()
+	return string(text)
+}
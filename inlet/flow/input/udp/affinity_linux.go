@@ -0,0 +1,56 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build linux
+
+package udp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const affinitySupported = true
+
+// pinWorker locks the calling goroutine to its OS thread and restricts this
+// thread to the provided CPU set. The thread ID is returned. The goroutine is
+// never unlocked: the thread is destroyed when the goroutine exits.
+func pinWorker(cpus CPUSet) (int, error) {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Zero()
+	for _, cpu := range cpus {
+		set.Set(cpu)
+	}
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return 0, fmt.Errorf("unable to set CPU affinity to %s: %w", cpus, err)
+	}
+	return unix.Gettid(), nil
+}
+
+// cpuMigrations returns the number of times the provided thread has been
+// migrated to another CPU. This is only available when the kernel is compiled
+// with CONFIG_SCHED_DEBUG.
+func cpuMigrations(tid int) (uint64, error) {
+	content, err := os.ReadFile(fmt.Sprintf("/proc/self/task/%d/sched", tid))
+	if err != nil {
+		return 0, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(key) != "se.nr_migrations" {
+			continue
+		}
+		return strconv.ParseUint(strings.TrimSpace(value), 10, 64)
+	}
+	return 0, errors.New("no CPU migration counter")
+}
@@ -0,0 +1,148 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build linux
+
+package udp
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"akvorado/common/daemon"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// allowedCPUs returns the CPUs the current process is allowed to run on.
+func allowedCPUs(t testing.TB) []int {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		t.Fatalf("SchedGetaffinity() error:\n%+v", err)
+	}
+	cpus := []int{}
+	for cpu := 0; cpu < 1024; cpu++ {
+		if set.IsSet(cpu) {
+			cpus = append(cpus, cpu)
+		}
+	}
+	if len(cpus) == 0 {
+		t.Fatal("no allowed CPU")
+	}
+	return cpus
+}
+
+func TestPinnedUDPInput(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	configuration.Workers = 2
+	configuration.CPUAffinity = []CPUSet{{allowedCPUs(t)[0]}}
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	conn, err := net.Dial("udp", in.(*Input).address.String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	if _, err := conn.Write([]byte("hello world!")); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	select {
+	case got := <-ch:
+		if len(got) == 0 {
+			t.Fatalf("empty decoded flows received")
+		}
+	case <-time.After(20 * time.Millisecond):
+		t.Fatal("no decoded flows received")
+	}
+
+	// The migration counter is only available with CONFIG_SCHED_DEBUG.
+	if _, err := os.Stat("/proc/thread-self/sched"); err != nil {
+		return
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_cpu_")
+	if len(gotMetrics) != 1 {
+		t.Errorf("Input metrics: expected a CPU migration counter, got %v", gotMetrics)
+	}
+}
+
+// BenchmarkUDPInput compares the throughput of pinned and unpinned workers.
+// Packets are sent over the loopback interface by one goroutine for each
+// worker. The number of received packets per second is reported.
+func BenchmarkUDPInput(b *testing.B) {
+	cpus := allowedCPUs(b)
+	workers := len(cpus)
+	if workers > 4 {
+		workers = 4
+	}
+	for _, pinned := range []bool{false, true} {
+		b.Run(fmt.Sprintf("pinned=%v", pinned), func(b *testing.B) {
+			r := reporter.NewMock(b)
+			configuration := DefaultConfiguration().(*Configuration)
+			configuration.Listen = "127.0.0.1:0"
+			configuration.Workers = workers
+			configuration.ReceiveBuffer = 8 * 1024 * 1024
+			if pinned {
+				for _, cpu := range cpus[:workers] {
+					configuration.CPUAffinity = append(configuration.CPUAffinity, CPUSet{cpu})
+				}
+			}
+			in, err := configuration.New(r, daemon.NewMock(b), &decoder.DummyDecoder{Schema: schema.NewMock(b)})
+			if err != nil {
+				b.Fatalf("New() error:\n%+v", err)
+			}
+			ch, err := in.Start()
+			if err != nil {
+				b.Fatalf("Start() error:\n%+v", err)
+			}
+			defer in.Stop()
+
+			payload := []byte("hello world!")
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < workers*runtime.GOMAXPROCS(0); i++ {
+				conn, err := net.Dial("udp", in.(*Input).address.String())
+				if err != nil {
+					b.Fatalf("Dial() error:\n%+v", err)
+				}
+				defer conn.Close()
+				go func() {
+					for {
+						if _, err := conn.Write(payload); err != nil {
+							return
+						}
+					}
+				}()
+			}
+			received := 0
+			for received < b.N {
+				select {
+				case flows := <-ch:
+					received += len(flows)
+				case <-time.After(time.Second):
+					b.Fatalf("only %d flows received out of %d", received, b.N)
+				}
+			}
+			b.ReportMetric(float64(received)/time.Since(start).Seconds(), "flows/s")
+		})
+	}
+}
@@ -0,0 +1,20 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build !linux
+
+package udp
+
+import "errors"
+
+const affinitySupported = false
+
+// pinWorker does nothing.
+func pinWorker(_ CPUSet) (int, error) {
+	return 0, nil
+}
+
+// cpuMigrations is not supported.
+func cpuMigrations(_ int) (uint64, error) {
+	return 0, errors.New("no CPU migration counter")
+}
@@ -0,0 +1,51 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package udp
+
+import (
+	"testing"
+
+	"akvorado/common/helpers"
+)
+
+func TestCPUSet(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected CPUSet
+		Output   string
+		Error    bool
+	}{
+		{Input: "0", Expected: CPUSet{0}, Output: "0"},
+		{Input: "1,3", Expected: CPUSet{1, 3}, Output: "1,3"},
+		{Input: "0-3", Expected: CPUSet{0, 1, 2, 3}, Output: "0-3"},
+		{Input: "8, 0-3,2", Expected: CPUSet{0, 1, 2, 3, 8}, Output: "0-3,8"},
+		{Input: "4-5,0,6", Expected: CPUSet{0, 4, 5, 6}, Output: "0,4-6"},
+		{Input: "", Error: true},
+		{Input: "a", Error: true},
+		{Input: "1-", Error: true},
+		{Input: "3-1", Error: true},
+		{Input: "-1", Error: true},
+	}
+	for _, tc := range cases {
+		var got CPUSet
+		err := got.UnmarshalText([]byte(tc.Input))
+		if err != nil && !tc.Error {
+			t.Errorf("UnmarshalText(%q) error:\n%+v", tc.Input, err)
+			continue
+		}
+		if err == nil && tc.Error {
+			t.Errorf("UnmarshalText(%q) did not error", tc.Input)
+			continue
+		}
+		if tc.Error {
+			continue
+		}
+		if diff := helpers.Diff(got, tc.Expected); diff != "" {
+			t.Errorf("UnmarshalText(%q) (-got, +want):\n%s", tc.Input, diff)
+		}
+		if output := got.String(); output != tc.Output {
+			t.Errorf("String(%q) == %q, expected %q", tc.Input, output, tc.Output)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package udp
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"akvorado/common/reporter"
+	"akvorado/inlet/flow/decoder"
+)
+
+const (
+	// scaleUpPressure is the queue occupancy over which a decode worker
+	// is added.
+	scaleUpPressure = 0.5
+	// scaleDownPressure is the queue occupancy under which a decode
+	// worker is removed.
+	scaleDownPressure = 0.1
+)
+
+// datagram is a datagram waiting to be decoded.
+type datagram struct {
+	buf      []byte
+	n        int
+	received time.Time
+	source   net.IP
+	worker   string // receiving worker
+}
+
+var datagramPool = sync.Pool{
+	New: func() interface{} {
+		return &datagram{buf: make([]byte, 9000)}
+	},
+}
+
+// scaleWorkers returns the number of decode workers to use given the
+// current number of workers and the occupancy of the decode queue.
+func scaleWorkers(workers int, pressure float64, config AutoscalingConfiguration) int {
+	switch {
+	case pressure > scaleUpPressure && workers < config.MaxWorkers:
+		return workers + 1
+	case pressure < scaleDownPressure && workers > config.MinWorkers:
+		return workers - 1
+	}
+	return workers
+}
+
+// enqueue copies a datagram to the decode queue. It is dropped if the
+// queue is full.
+func (in *Input) enqueue(payload []byte, received time.Time, source net.IP, worker string, errLogger reporter.Logger) {
+	dg := datagramPool.Get().(*datagram)
+	dg.n = copy(dg.buf, payload)
+	dg.received = received
+	dg.source = source
+	dg.worker = worker
+	select {
+	case in.decodeQueue <- dg:
+	default:
+		errLogger.Warn().Msgf("dropping datagram due to decode queue full (size %d)",
+			in.config.Autoscaling.QueueSize)
+		in.metrics.outDrops.WithLabelValues(in.config.Listen, worker, source.String()).Inc()
+		datagramPool.Put(dg)
+	}
+}
+
+// startAutoscaling starts the minimum number of decode workers and
+// periodically adjusts their number.
+func (in *Input) startAutoscaling() {
+	config := in.config.Autoscaling
+	listen := in.config.Listen
+	stop := make(chan struct{})
+	errLogger := in.r.With().Str("listen", listen).Logger().
+		Sample(reporter.BurstSampler(time.Minute, 1))
+	decodeWorker := func() error {
+		for {
+			select {
+			case <-in.t.Dying():
+				return nil
+			case <-stop:
+				return nil
+			case dg := <-in.decodeQueue:
+				ok := in.decode(decoder.RawFlow{
+					TimeReceived: dg.received,
+					Payload:      dg.buf[:dg.n],
+					Source:       dg.source,
+				}, dg.worker, dg.source.String(), errLogger)
+				dg.source = nil
+				datagramPool.Put(dg)
+				if !ok {
+					return nil
+				}
+			}
+		}
+	}
+
+	workers := config.MinWorkers
+	for i := 0; i < workers; i++ {
+		in.t.Go(decodeWorker)
+	}
+	in.metrics.decodeWorkers.WithLabelValues(listen).Set(float64(workers))
+	in.t.Go(func() error {
+		ticker := time.NewTicker(config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-in.t.Dying():
+				return nil
+			case <-ticker.C:
+				pressure := float64(len(in.decodeQueue)) / float64(cap(in.decodeQueue))
+				in.metrics.decodePressure.WithLabelValues(listen).Set(pressure)
+				target := scaleWorkers(workers, pressure, config)
+				switch {
+				case target > workers:
+					in.t.Go(decodeWorker)
+				case target < workers:
+					select {
+					case <-in.t.Dying():
+						return nil
+					case stop <- struct{}{}:
+					}
+				default:
+					continue
+				}
+				in.r.Debug().
+					Str("listen", listen).
+					Msgf("scaling decode workers from %d to %d", workers, target)
+				workers = target
+				in.metrics.decodeWorkers.WithLabelValues(listen).Set(float64(workers))
+			}
+		}
+	})
+}
+
+// decode decodes a datagram and sends the flows to the flow component. It
+// returns false if the input is stopping.
+func (in *Input) decode(raw decoder.RawFlow, worker string, srcIP string, errLogger reporter.Logger) bool {
+	flows := in.decoder.Decode(raw)
+	if len(flows) == 0 {
+		return true
+	}
+	select {
+	case <-in.t.Dying():
+		return false
+	case in.ch <- flows:
+	default:
+		errLogger.Warn().Msgf("dropping flow due to queue full (size %d)",
+			in.config.QueueSize)
+		in.metrics.outDrops.WithLabelValues(in.config.Listen, worker, srcIP).
+			Inc()
+	}
+	return true
+}
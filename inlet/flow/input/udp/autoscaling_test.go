@@ -0,0 +1,92 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package udp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+func TestScaleWorkers(t *testing.T) {
+	config := AutoscalingConfiguration{MinWorkers: 2, MaxWorkers: 4}
+	cases := []struct {
+		Workers  int
+		Pressure float64
+		Expected int
+	}{
+		{2, 0, 2},
+		{2, 0.3, 2},
+		{2, 0.8, 3},
+		{4, 0.8, 4},
+		{4, 0.3, 4},
+		{4, 0.05, 3},
+		{3, 0.05, 2},
+	}
+	for _, tc := range cases {
+		got := scaleWorkers(tc.Workers, tc.Pressure, config)
+		if got != tc.Expected {
+			t.Errorf("scaleWorkers(%d, %f) == %d, expected %d",
+				tc.Workers, tc.Pressure, got, tc.Expected)
+		}
+	}
+}
+
+func TestAutoscaling(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	configuration.Autoscaling.MinWorkers = 2
+	configuration.Autoscaling.MaxWorkers = 4
+	configuration.Autoscaling.Interval = 100 * time.Millisecond
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	conn, err := net.Dial("udp", in.(*Input).address.String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := conn.Write([]byte("hello world!")); err != nil {
+			t.Fatalf("Write() error:\n%+v", err)
+		}
+	}
+	for i := 0; i < 10; i++ {
+		select {
+		case got := <-ch:
+			if len(got) == 0 {
+				t.Fatalf("empty decoded flows received")
+			}
+		case <-time.After(100 * time.Millisecond):
+			t.Fatalf("no decoded flows received (%d received)", i)
+		}
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_decode_")
+	expectedMetrics := map[string]string{
+		`workers{listener="127.0.0.1:0"}`:        "2",
+		`queue_pressure{listener="127.0.0.1:0"}`: "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
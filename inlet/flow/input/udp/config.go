@@ -3,14 +3,23 @@
 
 package udp
 
-import "akvorado/inlet/flow/input"
+import (
+	"time"
+
+	"akvorado/inlet/flow/input"
+)
 
 // Configuration describes UDP input configuration.
 type Configuration struct {
-	// Listen tells which port to listen to.
-	Listen string `validate:"required,listen"`
+	// Listen tells which port to listen to. It can also be "systemd:"
+	// followed by the name of a socket passed by systemd.
+	Listen string `validate:"required,listen|startswith=systemd:"`
 	// Workers define the number of workers to use for receiving flows.
 	Workers int `validate:"required,min=1"`
+	// BatchSize is the maximum number of datagrams a worker receives with a
+	// single system call. Batched reads are only supported on Linux (with
+	// recvmmsg()). On other platforms, datagrams are read one by one.
+	BatchSize int `validate:"min=1"`
 	// QueueSize defines the size of the channel used to
 	// communicate incoming flows. 0 can be used to disable
 	// buffering.
@@ -21,6 +30,55 @@ type Configuration struct {
 	// The value cannot exceed the kernel max value
 	// (net.core.wmem_max).
 	ReceiveBuffer uint
+	// CPUAffinity is a list of CPU sets (like "0-3,8"). When not empty, each
+	// worker is pinned to one of them, in a round-robin fashion. As flows are
+	// decoded by the worker receiving them, decoding happens on the same CPU
+	// set. This is only supported on Linux. It cannot be used with
+	// autoscaling, as decode workers are not pinned.
+	CPUAffinity []CPUSet `validate:"excluded_with=Autoscaling.MaxWorkers"`
+	// Forward is a list of collectors (as host:port) to mirror received
+	// datagrams to. Datagrams are forwarded unmodified, before decoding.
+	// As the source address is the one of the inlet, the collectors
+	// should use the exporter address from the payload.
+	Forward []string `validate:"dive,hostname_port"`
+	// Autoscaling configures a pool of decode workers whose size adapts to
+	// the number of datagrams waiting to be decoded. When disabled,
+	// datagrams are decoded by the workers receiving them.
+	Autoscaling AutoscalingConfiguration
+	// DTLS defines DTLS configuration. When enabled, each exporter
+	// establishes a session whose datagrams are received and decoded by
+	// a dedicated goroutine. Workers are not used.
+	DTLS DTLSConfiguration
+}
+
+// DTLSConfiguration defines DTLS configuration.
+type DTLSConfiguration struct {
+	// Enable says if DTLS should be used for incoming datagrams
+	Enable bool `validate:"required_with=CertFile KeyFile CAFile"`
+	// CertFile tells the location of the server certificate.
+	CertFile string `validate:"required_with=Enable KeyFile"`
+	// KeyFile tells the location of the server key. If empty, the key is
+	// read from the certificate file.
+	KeyFile string
+	// CAFile tells the location of the CA certificate to check client
+	// certificates. When set, exporters have to present a valid
+	// certificate.
+	CAFile string
+}
+
+// AutoscalingConfiguration describes the pool of decode workers.
+type AutoscalingConfiguration struct {
+	// MinWorkers is the minimum number of decode workers.
+	MinWorkers int `validate:"min=1"`
+	// MaxWorkers is the maximum number of decode workers. 0 disables
+	// autoscaling.
+	MaxWorkers int `validate:"isdefault|gtefield=MinWorkers"`
+	// QueueSize is the number of datagrams waiting to be decoded. When
+	// the queue is full, datagrams are dropped.
+	QueueSize uint `validate:"min=1"`
+	// Interval is the interval between two evaluations of the number of
+	// decode workers.
+	Interval time.Duration `validate:"min=100ms"`
 }
 
 // DefaultConfiguration is the default configuration for this input
@@ -28,6 +86,12 @@ func DefaultConfiguration() input.Configuration {
 	return &Configuration{
 		Listen:    "0.0.0.0:0",
 		Workers:   1,
+		BatchSize: 32,
 		QueueSize: 100000,
+		Autoscaling: AutoscalingConfiguration{
+			MinWorkers: 1,
+			QueueSize:  10000,
+			Interval:   time.Second,
+		},
 	}
 }
@@ -14,3 +14,19 @@ func TestDefaultConfiguration(t *testing.T) {
 		t.Fatalf("validate.Struct() error:\n%+v", err)
 	}
 }
+
+func TestCPUAffinityWithAutoscaling(t *testing.T) {
+	var cpus CPUSet
+	if err := cpus.UnmarshalText([]byte("0-1")); err != nil {
+		t.Fatalf("UnmarshalText() error:\n%+v", err)
+	}
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.CPUAffinity = []CPUSet{cpus}
+	if err := helpers.Validate.Struct(configuration); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+	configuration.Autoscaling.MaxWorkers = 4
+	if err := helpers.Validate.Struct(configuration); err == nil {
+		t.Fatal("validate.Struct() did not error with CPU affinity and autoscaling")
+	}
+}
@@ -0,0 +1,186 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package udp
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/dtls/v2/pkg/protocol"
+	"github.com/pion/dtls/v2/pkg/protocol/recordlayer"
+	"github.com/pion/transport/v2/udp"
+
+	"akvorado/common/helpers/systemd"
+	"akvorado/common/reporter"
+	"akvorado/inlet/flow/decoder"
+)
+
+const (
+	// dtlsHandshakeTimeout is the maximum duration of a DTLS handshake.
+	dtlsHandshakeTimeout = 30 * time.Second
+	// dtlsIdleTimeout is the duration after which a DTLS session without
+	// any datagram is closed.
+	dtlsIdleTimeout = 10 * time.Minute
+	// dtlsWorker is the worker label used in metrics for DTLS sessions.
+	dtlsWorker = "dtls"
+)
+
+// newDTLSConfig returns the DTLS configuration for the listener.
+func newDTLSConfig(config DTLSConfiguration) (*dtls.Config, error) {
+	keyFile := config.KeyFile
+	if keyFile == "" {
+		keyFile = config.CertFile
+	}
+	cert, err := tls.LoadX509KeyPair(config.CertFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read server certificate: %w", err)
+	}
+	dtlsConfig := &dtls.Config{
+		Certificates:         []tls.Certificate{cert},
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+	// Read CA certificate if provided to check client certificates
+	if config.CAFile != "" {
+		caCert, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read CA certificate: %w", err)
+		}
+		caCertPool := x509.NewCertPool()
+		if ok := caCertPool.AppendCertsFromPEM(caCert); !ok {
+			return nil, errors.New("cannot parse CA certificate")
+		}
+		dtlsConfig.ClientCAs = caCertPool
+		dtlsConfig.ClientAuth = dtls.RequireAndVerifyClientCert
+	}
+	return dtlsConfig, nil
+}
+
+// startDTLS listens to the configured UDP port and accepts DTLS sessions.
+// Each session is handled by its own goroutine.
+func (in *Input) startDTLS() error {
+	if strings.HasPrefix(in.config.Listen, systemd.Prefix) {
+		return errors.New("DTLS cannot be used with a socket passed by systemd")
+	}
+	dtlsConfig, err := newDTLSConfig(in.config.DTLS)
+	if err != nil {
+		return err
+	}
+	listenAddr, err := net.ResolveUDPAddr("udp", in.config.Listen)
+	if err != nil {
+		return fmt.Errorf("unable to resolve %v: %w", in.config.Listen, err)
+	}
+	// Only start a new session with a handshake record
+	lc := udp.ListenConfig{
+		AcceptFilter: func(packet []byte) bool {
+			pkts, err := recordlayer.UnpackDatagram(packet)
+			if err != nil || len(pkts) < 1 {
+				return false
+			}
+			h := &recordlayer.Header{}
+			if err := h.Unmarshal(pkts[0]); err != nil {
+				return false
+			}
+			return h.ContentType == protocol.ContentTypeHandshake
+		},
+	}
+	listener, err := lc.Listen("udp", listenAddr)
+	if err != nil {
+		return fmt.Errorf("unable to listen to %v: %w", listenAddr, err)
+	}
+	in.address = listener.Addr()
+	in.r.Info().Str("listen", in.address.String()).Msg("UDP input listening with DTLS")
+
+	in.t.Go(func() error {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				select {
+				case <-in.t.Dying():
+					return nil
+				default:
+				}
+				in.r.Err(err).Str("listen", in.config.Listen).Msg("unable to accept DTLS session")
+				in.metrics.errors.WithLabelValues(in.config.Listen, dtlsWorker).Inc()
+				continue
+			}
+			in.t.Go(func() error {
+				in.handleDTLSSession(conn, dtlsConfig)
+				return nil
+			})
+		}
+	})
+
+	// Watch for termination and close on dying. Sessions are closed by
+	// their own goroutine.
+	in.t.Go(func() error {
+		<-in.t.Dying()
+		listener.Close()
+		return nil
+	})
+	return nil
+}
+
+// handleDTLSSession decodes datagrams received in a DTLS session until it is
+// closed, idle, or until an error happens.
+func (in *Input) handleDTLSSession(conn net.Conn, dtlsConfig *dtls.Config) {
+	listen := in.config.Listen
+	source := conn.RemoteAddr().(*net.UDPAddr)
+	srcIP := source.IP.String()
+	l := in.r.With().
+		Str("listen", listen).
+		Str("exporter", srcIP).
+		Logger()
+	errLogger := l.Sample(reporter.BurstSampler(time.Minute, 1))
+
+	// Closing the listener does not close the accepted sessions
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-in.t.Dying():
+		case <-done:
+		}
+		conn.Close()
+	}()
+
+	ctx, cancel := context.WithTimeout(in.t.Context(context.Background()), dtlsHandshakeTimeout)
+	defer cancel()
+	dtlsConn, err := dtls.ServerWithContext(ctx, conn, dtlsConfig)
+	if err != nil {
+		errLogger.Err(err).Msg("DTLS handshake failed")
+		in.metrics.errors.WithLabelValues(listen, dtlsWorker).Inc()
+		return
+	}
+	defer dtlsConn.Close()
+	l.Debug().Msg("new DTLS session")
+
+	payload := make([]byte, 9000)
+	for {
+		dtlsConn.SetReadDeadline(time.Now().Add(dtlsIdleTimeout))
+		n, err := dtlsConn.Read(payload)
+		if err != nil {
+			l.Debug().Err(err).Msg("DTLS session closed")
+			return
+		}
+		in.metrics.reads.WithLabelValues(listen, dtlsWorker).Inc()
+		in.metrics.bytes.WithLabelValues(listen, dtlsWorker, srcIP).Add(float64(n))
+		in.metrics.packets.WithLabelValues(listen, dtlsWorker, srcIP).Inc()
+		in.metrics.packetSizeSum.WithLabelValues(listen, dtlsWorker, srcIP).Observe(float64(n))
+		if !in.decode(decoder.RawFlow{
+			TimeReceived: time.Now(),
+			Payload:      payload[:n],
+			Source:       source.IP,
+		}, dtlsWorker, srcIP, errLogger) {
+			return
+		}
+	}
+}
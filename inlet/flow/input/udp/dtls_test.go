@@ -0,0 +1,151 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package udp
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/dtls/v2"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+// generateCertificate creates a self-signed certificate and writes it with its
+// key to the provided directory.
+func generateCertificate(t *testing.T, dir string) (*x509.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey() error:\n%+v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "inlet"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate() error:\n%+v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate() error:\n%+v", err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("MarshalECPrivateKey() error:\n%+v", err)
+	}
+	file := filepath.Join(dir, "server.pem")
+	content := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})...)
+	if err := os.WriteFile(file, content, 0o600); err != nil {
+		t.Fatalf("WriteFile() error:\n%+v", err)
+	}
+	return cert, file
+}
+
+func TestUDPInputDTLS(t *testing.T) {
+	cert, certFile := generateCertificate(t, t.TempDir())
+
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	configuration.DTLS = DTLSConfiguration{
+		Enable:   true,
+		CertFile: certFile,
+	}
+	if err := helpers.Validate.Struct(configuration); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	// Establish a session and send data
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := dtls.DialWithContext(ctx, "udp", in.(*Input).address.(*net.UDPAddr), &dtls.Config{
+		RootCAs:              roots,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	})
+	if err != nil {
+		t.Fatalf("DialWithContext() error:\n%+v", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("hello world!")); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	select {
+	case got := <-ch:
+		if diff := helpers.Diff(got[0].ProtobufDebug[schema.ColumnInIfDescription], []byte("hello world!")); diff != "" {
+			t.Fatalf("Input data (-got, +want):\n%s", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no decoded flows received")
+	}
+
+	// Plain datagrams are ignored
+	plain, err := net.Dial("udp", in.(*Input).address.String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	defer plain.Close()
+	if _, err := plain.Write([]byte("hello world!")); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	select {
+	case <-ch:
+		t.Fatal("plain datagram decoded")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_", "packets")
+	expectedMetrics := map[string]string{
+		`packets{exporter="127.0.0.1",listener="127.0.0.1:0",worker="dtls"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDTLSConfigurationValidation(t *testing.T) {
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.DTLS.Enable = true
+	if err := helpers.Validate.Struct(configuration); err == nil {
+		t.Fatal("validate.Struct() did not error without certificate")
+	}
+}
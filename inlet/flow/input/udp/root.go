@@ -9,12 +9,16 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"os"
 	"strconv"
+	"strings"
 	"time"
 
+	"golang.org/x/net/ipv4"
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/daemon"
+	"akvorado/common/helpers/systemd"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
@@ -28,17 +32,26 @@ type Input struct {
 	config *Configuration
 
 	metrics struct {
-		bytes         *reporter.CounterVec
-		packets       *reporter.CounterVec
-		packetSizeSum *reporter.SummaryVec
-		errors        *reporter.CounterVec
-		outDrops      *reporter.CounterVec
-		inDrops       *reporter.GaugeVec
+		bytes          *reporter.CounterVec
+		packets        *reporter.CounterVec
+		packetSizeSum  *reporter.SummaryVec
+		errors         *reporter.CounterVec
+		reads          *reporter.CounterVec
+		outDrops       *reporter.CounterVec
+		inDrops        *reporter.GaugeVec
+		queueLength    *reporter.GaugeVec
+		receiveBuffer  *reporter.GaugeVec
+		migrations     *reporter.GaugeVec
+		forwarded      *reporter.CounterVec
+		forwardErrors  *reporter.CounterVec
+		decodeWorkers  *reporter.GaugeVec
+		decodePressure *reporter.GaugeVec
 	}
 
-	address net.Addr                   // listening address, for testing purpoese
-	ch      chan []*schema.FlowMessage // channel to send flows to
-	decoder decoder.Decoder            // decoder to use
+	address     net.Addr                   // listening address, for testing purpoese
+	ch          chan []*schema.FlowMessage // channel to send flows to
+	decodeQueue chan *datagram             // datagrams to decode, when autoscaling
+	decoder     decoder.Decoder            // decoder to use
 }
 
 // New instantiate a new UDP listener from the provided configuration.
@@ -79,6 +92,13 @@ func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Comp
 		},
 		[]string{"listener", "worker"},
 	)
+	input.metrics.reads = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "reads",
+			Help: "System calls used to receive packets by the application.",
+		},
+		[]string{"listener", "worker"},
+	)
 	input.metrics.outDrops = r.CounterVec(
 		reporter.CounterOpts{
 			Name: "out_drops",
@@ -93,6 +113,56 @@ func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Comp
 		},
 		[]string{"listener", "worker"},
 	)
+	input.metrics.queueLength = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "queue_length",
+			Help: "Number of flows waiting in the internal queue shared by workers.",
+		},
+		[]string{"listener"},
+	)
+	input.metrics.receiveBuffer = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "receive_buffer_bytes",
+			Help: "Size of the receive buffer of the listening socket, as reported by the kernel.",
+		},
+		[]string{"listener", "worker"},
+	)
+	input.metrics.migrations = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "cpu_migrations",
+			Help: "Number of CPU migrations of pinned workers.",
+		},
+		[]string{"listener", "worker"},
+	)
+
+	input.metrics.forwarded = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "forwarded_packets",
+			Help: "Packets forwarded to downstream collectors.",
+		},
+		[]string{"listener", "target"},
+	)
+	input.metrics.forwardErrors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "forward_errors",
+			Help: "Errors while forwarding packets to downstream collectors.",
+		},
+		[]string{"listener", "target"},
+	)
+	input.metrics.decodeWorkers = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "decode_workers",
+			Help: "Number of decode workers, when autoscaling.",
+		},
+		[]string{"listener"},
+	)
+	input.metrics.decodePressure = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "decode_queue_pressure",
+			Help: "Occupancy of the queue of datagrams to decode, between 0 and 1, when autoscaling.",
+		},
+		[]string{"listener"},
+	)
 
 	daemon.Track(&input.t, "inlet/flow/input/udp")
 	return input, nil
@@ -101,27 +171,57 @@ func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Comp
 // Start starts listening to the provided UDP socket and producing flows.
 func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
 	in.r.Info().Str("listen", in.config.Listen).Msg("starting UDP input")
+	if len(in.config.CPUAffinity) > 0 && !affinitySupported {
+		in.r.Warn().Str("listen", in.config.Listen).Msg("CPU affinity not supported on this platform")
+	}
+	if in.config.DTLS.Enable {
+		if err := in.startDTLS(); err != nil {
+			return nil, err
+		}
+		return in.ch, nil
+	}
 
-	// Listen to UDP port
+	// Sockets passed by systemd are shared by workers when there are not
+	// enough of them.
+	var systemdFiles []*os.File
+	if strings.HasPrefix(in.config.Listen, systemd.Prefix) {
+		name := strings.TrimPrefix(in.config.Listen, systemd.Prefix)
+		systemdFiles = systemd.Files(name)
+		if len(systemdFiles) == 0 {
+			return nil, fmt.Errorf("no socket %q passed by systemd", name)
+		}
+	}
+
+	// Listen to UDP port. Each worker gets its own socket. With
+	// SO_REUSEPORT, the kernel spreads incoming packets between them.
 	conns := []*net.UDPConn{}
 	for i := 0; i < in.config.Workers; i++ {
-		var listenAddr net.Addr
-		if in.address != nil {
-			// We already are listening on one address, let's
-			// listen to the same (useful when using :0).
-			listenAddr = in.address
-		} else {
+		var udpConn *net.UDPConn
+		if systemdFiles != nil {
 			var err error
-			listenAddr, err = net.ResolveUDPAddr("udp", in.config.Listen)
+			udpConn, err = systemdConn(systemdFiles[i%len(systemdFiles)])
 			if err != nil {
-				return nil, fmt.Errorf("unable to resolve %v: %w", in.config.Listen, err)
+				return nil, fmt.Errorf("unable to use socket %v: %w", in.config.Listen, err)
 			}
+		} else {
+			var listenAddr net.Addr
+			if in.address != nil {
+				// We already are listening on one address, let's
+				// listen to the same (useful when using :0).
+				listenAddr = in.address
+			} else {
+				var err error
+				listenAddr, err = net.ResolveUDPAddr("udp", in.config.Listen)
+				if err != nil {
+					return nil, fmt.Errorf("unable to resolve %v: %w", in.config.Listen, err)
+				}
+			}
+			pconn, err := listenConfig.ListenPacket(in.t.Context(context.Background()), "udp", listenAddr.String())
+			if err != nil {
+				return nil, fmt.Errorf("unable to listen to %v: %w", listenAddr, err)
+			}
+			udpConn = pconn.(*net.UDPConn)
 		}
-		pconn, err := listenConfig.ListenPacket(in.t.Context(context.Background()), "udp", listenAddr.String())
-		if err != nil {
-			return nil, fmt.Errorf("unable to listen to %v: %w", listenAddr, err)
-		}
-		udpConn := pconn.(*net.UDPConn)
 		in.address = udpConn.LocalAddr()
 		if i == 0 {
 			in.r.Info().Str("listen", in.address.String()).Msg("UDP input listening")
@@ -134,24 +234,72 @@ func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
 					Msgf("unable to set requested buffer size (%d bytes)", in.config.ReceiveBuffer)
 			}
 		}
+		if size, err := receiveBufferSize(udpConn); err == nil {
+			in.metrics.receiveBuffer.WithLabelValues(in.config.Listen, strconv.Itoa(i)).Set(float64(size))
+			if size < int(in.config.ReceiveBuffer) && i == 0 {
+				// The kernel silently caps the requested size
+				in.r.Warn().
+					Str("listen", in.config.Listen).
+					Msgf("receive buffer size capped to %d bytes instead of %d bytes, increase net.core.rmem_max",
+						size, in.config.ReceiveBuffer)
+			}
+		}
 
 		conns = append(conns, udpConn)
 	}
 
+	// Connect to downstream collectors. Sockets are shared by workers.
+	forwarders := []*net.UDPConn{}
+	for _, target := range in.config.Forward {
+		targetAddr, err := net.ResolveUDPAddr("udp", target)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve %v: %w", target, err)
+		}
+		conn, err := net.DialUDP("udp", nil, targetAddr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to %v: %w", target, err)
+		}
+		forwarders = append(forwarders, conn)
+	}
+
+	// With autoscaling, datagrams are decoded by a separate pool of workers.
+	if in.config.Autoscaling.MaxWorkers > 0 {
+		in.decodeQueue = make(chan *datagram, in.config.Autoscaling.QueueSize)
+		in.startAutoscaling()
+	}
+
 	for i := 0; i < in.config.Workers; i++ {
 		workerID := i
 		worker := strconv.Itoa(i)
 		in.t.Go(func() error {
-			payload := make([]byte, 9000)
-			oob := make([]byte, oobLength)
+			// Datagrams are received in batches. The buffers are reused
+			// for each batch: decoders should not keep a reference to
+			// the payload.
+			conn := ipv4.NewPacketConn(conns[workerID])
+			msgs := make([]ipv4.Message, in.config.BatchSize)
+			for idx := range msgs {
+				msgs[idx].Buffers = [][]byte{make([]byte, 9000)}
+				msgs[idx].OOB = make([]byte, oobLength)
+			}
 			listen := in.config.Listen
 			l := in.r.With().
 				Str("worker", worker).
 				Str("listen", listen).
 				Logger()
 			errLogger := l.Sample(reporter.BurstSampler(time.Minute, 1))
-			for count := 0; ; count++ {
-				n, oobn, _, source, err := conns[workerID].ReadMsgUDP(payload, oob)
+			tid := 0
+			if len(in.config.CPUAffinity) > 0 {
+				cpus := in.config.CPUAffinity[workerID%len(in.config.CPUAffinity)]
+				var err error
+				if tid, err = pinWorker(cpus); err != nil {
+					l.Err(err).Msg("unable to pin worker")
+				} else if tid != 0 {
+					l.Debug().Str("cpus", cpus.String()).Msg("worker pinned")
+				}
+			}
+			count := 0
+			for {
+				received, err := conn.ReadBatch(msgs, 0)
 				if err != nil {
 					if errors.Is(err, net.ErrClosed) {
 						return nil
@@ -160,44 +308,69 @@ func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
 					in.metrics.errors.WithLabelValues(listen, worker).Inc()
 					continue
 				}
+				in.metrics.reads.WithLabelValues(listen, worker).Inc()
 
-				oobMsg, err := parseSocketControlMessage(oob[:oobn])
-				if err != nil {
-					errLogger.Err(err).Msg("unable to decode UDP control message")
-				} else {
+				for _, msg := range msgs[:received] {
+					n := msg.N
+					payload := msg.Buffers[0]
+					source, ok := msg.Addr.(*net.UDPAddr)
+					if !ok {
+						continue
+					}
+					oobMsg, err := parseSocketControlMessage(msg.OOB[:msg.NN])
+					if err != nil {
+						errLogger.Err(err).Msg("unable to decode UDP control message")
+					} else {
+						if count < 100 || count%100 == 0 {
+							in.metrics.inDrops.WithLabelValues(listen, worker).Set(
+								float64(oobMsg.Drops))
+						}
+					}
 					if count < 100 || count%100 == 0 {
-						in.metrics.inDrops.WithLabelValues(listen, worker).Set(
-							float64(oobMsg.Drops))
+						in.metrics.queueLength.WithLabelValues(listen).Set(
+							float64(len(in.ch)))
+					}
+					if tid != 0 && count%10000 == 0 {
+						if migrations, err := cpuMigrations(tid); err == nil {
+							in.metrics.migrations.WithLabelValues(listen, worker).Set(
+								float64(migrations))
+						} else {
+							// Not available, do not try again
+							tid = 0
+						}
+					}
+					count++
+					if oobMsg.Received.IsZero() {
+						oobMsg.Received = time.Now()
 					}
-				}
-				if oobMsg.Received.IsZero() {
-					oobMsg.Received = time.Now()
-				}
 
-				srcIP := source.IP.String()
-				in.metrics.bytes.WithLabelValues(listen, worker, srcIP).
-					Add(float64(n))
-				in.metrics.packets.WithLabelValues(listen, worker, srcIP).
-					Inc()
-				in.metrics.packetSizeSum.WithLabelValues(listen, worker, srcIP).
-					Observe(float64(n))
-				flows := in.decoder.Decode(decoder.RawFlow{
-					TimeReceived: oobMsg.Received,
-					Payload:      payload[:n],
-					Source:       source.IP,
-				})
-				if len(flows) == 0 {
-					continue
-				}
-				select {
-				case <-in.t.Dying():
-					return nil
-				case in.ch <- flows:
-				default:
-					errLogger.Warn().Msgf("dropping flow due to queue full (size %d)",
-						in.config.QueueSize)
-					in.metrics.outDrops.WithLabelValues(listen, worker, srcIP).
+					srcIP := source.IP.String()
+					in.metrics.bytes.WithLabelValues(listen, worker, srcIP).
+						Add(float64(n))
+					in.metrics.packets.WithLabelValues(listen, worker, srcIP).
 						Inc()
+					in.metrics.packetSizeSum.WithLabelValues(listen, worker, srcIP).
+						Observe(float64(n))
+					for idx, conn := range forwarders {
+						target := in.config.Forward[idx]
+						if _, err := conn.Write(payload[:n]); err != nil {
+							errLogger.Err(err).Str("target", target).Msg("unable to forward UDP packet")
+							in.metrics.forwardErrors.WithLabelValues(listen, target).Inc()
+							continue
+						}
+						in.metrics.forwarded.WithLabelValues(listen, target).Inc()
+					}
+					if in.decodeQueue != nil {
+						in.enqueue(payload[:n], oobMsg.Received, source.IP, worker, errLogger)
+						continue
+					}
+					if !in.decode(decoder.RawFlow{
+						TimeReceived: oobMsg.Received,
+						Payload:      payload[:n],
+						Source:       source.IP,
+					}, worker, srcIP, errLogger) {
+						return nil
+					}
 				}
 			}
 		})
@@ -210,12 +383,38 @@ func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
 		for _, conn := range conns {
 			conn.Close()
 		}
+		for _, conn := range forwarders {
+			conn.Close()
+		}
 		return nil
 	})
 
 	return in.ch, nil
 }
 
+// systemdConn returns an UDP socket from a file passed by systemd. The
+// socket options needed to get drops and timestamps are set.
+func systemdConn(file *os.File) (*net.UDPConn, error) {
+	pconn, err := net.FilePacketConn(file)
+	if err != nil {
+		return nil, err
+	}
+	udpConn, ok := pconn.(*net.UDPConn)
+	if !ok {
+		pconn.Close()
+		return nil, errors.New("not an UDP socket")
+	}
+	rawConn, err := udpConn.SyscallConn()
+	if err == nil {
+		err = listenConfig.Control("udp", udpConn.LocalAddr().String(), rawConn)
+	}
+	if err != nil {
+		udpConn.Close()
+		return nil, err
+	}
+	return udpConn, nil
+}
+
 // Stop stops the UDP listeners
 func (in *Input) Stop() error {
 	l := in.r.With().Str("listen", in.config.Listen).Logger()
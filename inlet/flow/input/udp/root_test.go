@@ -4,13 +4,17 @@
 package udp
 
 import (
+	"fmt"
 	"net"
 	"net/netip"
+	"runtime"
+	"strconv"
 	"testing"
 	"time"
 
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/helpers/systemd"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 	"akvorado/inlet/flow/decoder"
@@ -77,10 +81,13 @@ func TestUDPInput(t *testing.T) {
 
 	// Check metrics
 	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_")
+	delete(gotMetrics, `receive_buffer_bytes{listener="127.0.0.1:0",worker="0"}`)
 	expectedMetrics := map[string]string{
 		`bytes{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                              "12",
 		`packets{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                            "1",
 		`in_drops{listener="127.0.0.1:0",worker="0"}`:                                                "0",
+		`queue_length{listener="127.0.0.1:0"}`:                                                       "0",
+		`reads{listener="127.0.0.1:0",worker="0"}`:                                                   "1",
 		`summary_size_bytes_count{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:           "1",
 		`summary_size_bytes_sum{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:             "12",
 		`summary_size_bytes{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0",quantile="0.5"}`:  "12",
@@ -129,11 +136,14 @@ func TestOverflow(t *testing.T) {
 
 	// Check metrics
 	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_")
+	delete(gotMetrics, `receive_buffer_bytes{listener="127.0.0.1:0",worker="0"}`)
+	delete(gotMetrics, `reads{listener="127.0.0.1:0",worker="0"}`)
 	expectedMetrics := map[string]string{
 		`bytes{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                              "120",
 		`in_drops{listener="127.0.0.1:0",worker="0"}`:                                                "0",
 		`out_drops{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                          "9",
 		`packets{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:                            "10",
+		`queue_length{listener="127.0.0.1:0"}`:                                                       "1",
 		`summary_size_bytes_count{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:           "10",
 		`summary_size_bytes_sum{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`:             "120",
 		`summary_size_bytes{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0",quantile="0.5"}`:  "12",
@@ -144,3 +154,278 @@ func TestOverflow(t *testing.T) {
 		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
 	}
 }
+
+func TestMultipleWorkers(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	configuration.Workers = 4
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	// Send from several source ports to spread packets over workers
+	const senders = 32
+	for i := 0; i < senders; i++ {
+		conn, err := net.Dial("udp", in.(*Input).address.String())
+		if err != nil {
+			t.Fatalf("Dial() error:\n%+v", err)
+		}
+		defer conn.Close()
+		if _, err := conn.Write([]byte("hello world!")); err != nil {
+			t.Fatalf("Write() error:\n%+v", err)
+		}
+	}
+	for i := 0; i < senders; i++ {
+		select {
+		case <-ch:
+		case <-time.After(time.Second):
+			t.Fatalf("only %d decoded flows received", i)
+		}
+	}
+
+	// Each worker has its own metrics
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_packets")
+	total := 0
+	for _, value := range gotMetrics {
+		count, err := strconv.Atoi(value)
+		if err != nil {
+			t.Fatalf("Atoi(%q) error:\n%+v", value, err)
+		}
+		total += count
+	}
+	if total != senders {
+		t.Errorf("Input metrics: %d packets received, expected %d (%v)", total, senders, gotMetrics)
+	}
+	if runtime.GOOS == "linux" && len(gotMetrics) < 2 {
+		t.Errorf("Input metrics: packets received by only one worker (%v)", gotMetrics)
+	}
+}
+
+func TestReceiveBuffer(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	configuration.ReceiveBuffer = 65536
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	if _, err := in.Start(); err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_receive_buffer_bytes")
+	got, err := strconv.Atoi(gotMetrics[`{listener="127.0.0.1:0",worker="0"}`])
+	if err != nil {
+		t.Fatalf("Atoi() error:\n%+v", err)
+	}
+	if got < int(configuration.ReceiveBuffer) {
+		t.Errorf("receive_buffer_bytes is %d, expected at least %d", got, configuration.ReceiveBuffer)
+	}
+}
+
+func TestForward(t *testing.T) {
+	// Downstream collector
+	collector, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("ListenUDP() error:\n%+v", err)
+	}
+	defer collector.Close()
+
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "127.0.0.1:0"
+	configuration.Forward = []string{collector.LocalAddr().String()}
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	conn, err := net.Dial("udp", in.(*Input).address.String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	if _, err := conn.Write([]byte("hello world!")); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+
+	// The datagram is still decoded
+	select {
+	case <-ch:
+	case <-time.After(20 * time.Millisecond):
+		t.Fatal("no decoded flows received")
+	}
+
+	// And it is received by the downstream collector
+	payload := make([]byte, 100)
+	collector.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := collector.Read(payload)
+	if err != nil {
+		t.Fatalf("Read() error:\n%+v", err)
+	}
+	if diff := helpers.Diff(string(payload[:n]), "hello world!"); diff != "" {
+		t.Fatalf("Forwarded payload (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_", "forward")
+	expectedMetrics := map[string]string{
+		fmt.Sprintf(`forwarded_packets{listener="127.0.0.1:0",target="%s"}`, collector.LocalAddr()): "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
+
+// BenchmarkBatchSize compares the number of system calls needed to receive
+// packets depending on the batch size. Packets are sent over the loopback
+// interface by several goroutines. The number of packets received per system
+// call is reported.
+func BenchmarkBatchSize(b *testing.B) {
+	for _, batchSize := range []int{1, 8, 32, 64} {
+		b.Run(fmt.Sprintf("batch=%d", batchSize), func(b *testing.B) {
+			r := reporter.NewMock(b)
+			configuration := DefaultConfiguration().(*Configuration)
+			configuration.Listen = "127.0.0.1:0"
+			configuration.BatchSize = batchSize
+			configuration.ReceiveBuffer = 8 * 1024 * 1024
+			in, err := configuration.New(r, daemon.NewMock(b), &decoder.DummyDecoder{Schema: schema.NewMock(b)})
+			if err != nil {
+				b.Fatalf("New() error:\n%+v", err)
+			}
+			ch, err := in.Start()
+			if err != nil {
+				b.Fatalf("Start() error:\n%+v", err)
+			}
+			defer in.Stop()
+
+			payload := []byte("hello world!")
+			b.ResetTimer()
+			start := time.Now()
+			for i := 0; i < runtime.GOMAXPROCS(0); i++ {
+				conn, err := net.Dial("udp", in.(*Input).address.String())
+				if err != nil {
+					b.Fatalf("Dial() error:\n%+v", err)
+				}
+				defer conn.Close()
+				go func() {
+					for {
+						if _, err := conn.Write(payload); err != nil {
+							return
+						}
+					}
+				}()
+			}
+			received := 0
+			for received < b.N {
+				select {
+				case flows := <-ch:
+					received += len(flows)
+				case <-time.After(time.Second):
+					b.Fatalf("only %d flows received out of %d", received, b.N)
+				}
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(received)/time.Since(start).Seconds(), "flows/s")
+
+			gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_udp_", "reads", "packets")
+			packets, _ := strconv.Atoi(gotMetrics[`packets{exporter="127.0.0.1",listener="127.0.0.1:0",worker="0"}`])
+			reads, _ := strconv.Atoi(gotMetrics[`reads{listener="127.0.0.1:0",worker="0"}`])
+			if reads > 0 {
+				b.ReportMetric(float64(packets)/float64(reads), "packets/read")
+			}
+		})
+	}
+}
+
+func TestSystemdSocket(t *testing.T) {
+	// Socket passed by systemd
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+	if err != nil {
+		t.Fatalf("ListenUDP() error:\n%+v", err)
+	}
+	defer conn.Close()
+	file, err := conn.File()
+	if err != nil {
+		t.Fatalf("File() error:\n%+v", err)
+	}
+	defer file.Close()
+	systemd.MockFiles(t, "netflow", file)
+
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Listen = "systemd:netflow"
+	configuration.Workers = 2
+	if err := helpers.Validate.Struct(configuration); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+	if in.(*Input).address.String() != conn.LocalAddr().String() {
+		t.Fatalf("Start() listening to %s instead of %s", in.(*Input).address, conn.LocalAddr())
+	}
+
+	// Send data
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	defer client.Close()
+	if _, err := client.Write([]byte("hello world!")); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+	select {
+	case got := <-ch:
+		if diff := helpers.Diff(got[0].ProtobufDebug[schema.ColumnInIfDescription], []byte("hello world!")); diff != "" {
+			t.Fatalf("Input data (-got, +want):\n%s", diff)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no decoded flows received")
+	}
+
+	// Unknown socket
+	configuration2 := DefaultConfiguration().(*Configuration)
+	configuration2.Listen = "systemd:sflow"
+	in2, err := configuration2.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	if _, err := in2.Start(); err == nil {
+		t.Fatal("Start() did not error")
+	}
+}
@@ -32,3 +32,22 @@ var listenConfig = net.ListenConfig{
 		return err
 	},
 }
+
+// receiveBufferSize returns the size of the receive buffer of a socket, as
+// reported by the kernel. On Linux, this is twice the requested size to
+// account for bookkeeping overhead.
+func receiveBufferSize(conn *net.UDPConn) (int, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return 0, err
+	}
+	var size int
+	var sockErr error
+	err = rawConn.Control(func(fd uintptr) {
+		size, sockErr = unix.GetsockoptInt(int(fd), unix.SOL_SOCKET, unix.SO_RCVBUF)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return size, sockErr
+}
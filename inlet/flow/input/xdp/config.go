@@ -0,0 +1,43 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package xdp
+
+import (
+	"akvorado/inlet/flow/input"
+	"akvorado/inlet/flow/input/udp"
+)
+
+// Configuration describes AF_XDP input configuration.
+type Configuration struct {
+	// Interface is the network interface receiving flows.
+	Interface string `validate:"required"`
+	// Queues is the list of receive queues of the interface to attach to.
+	// Each queue gets its own socket and worker.
+	Queues []uint32 `validate:"min=1"`
+	// XSKMap is the path to the XSK map pinned by the XDP program
+	// redirecting flow packets to the sockets (usually in /sys/fs/bpf). The
+	// keys of this map are the queue numbers.
+	XSKMap string `validate:"required"`
+	// Frames is the number of frames of the memory area shared with the
+	// kernel for each socket. Each frame holds one packet. It should be a
+	// power of 2.
+	Frames uint32 `validate:"min=64,max=65536"`
+	// QueueSize defines the size of the channel used to communicate
+	// incoming flows. 0 can be used to disable buffering.
+	QueueSize uint
+	// Fallback is the configuration of the UDP input used when AF_XDP is
+	// not available. The port of its listening address is also used to
+	// select packets to decode among the ones received through AF_XDP.
+	Fallback udp.Configuration
+}
+
+// DefaultConfiguration is the default configuration for this input
+func DefaultConfiguration() input.Configuration {
+	return &Configuration{
+		Queues:    []uint32{0},
+		Frames:    4096,
+		QueueSize: 100000,
+		Fallback:  *udp.DefaultConfiguration().(*udp.Configuration),
+	}
+}
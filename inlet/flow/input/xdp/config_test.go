@@ -0,0 +1,19 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package xdp
+
+import (
+	"testing"
+
+	"akvorado/common/helpers"
+)
+
+func TestDefaultConfiguration(t *testing.T) {
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Interface = "eth0"
+	configuration.XSKMap = "/sys/fs/bpf/xsks_map"
+	if err := helpers.Validate.Struct(configuration); err != nil {
+		t.Fatalf("validate.Struct() error:\n%+v", err)
+	}
+}
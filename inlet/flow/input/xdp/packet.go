@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package xdp
+
+import (
+	"net"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// frameParser extracts UDP datagrams from Ethernet frames. It does not
+// allocate and is not safe for concurrent use: each worker has its own.
+type frameParser struct {
+	eth     layers.Ethernet
+	dot1q   layers.Dot1Q
+	ip4     layers.IPv4
+	ip6     layers.IPv6
+	udp     layers.UDP
+	parser  *gopacket.DecodingLayerParser
+	decoded []gopacket.LayerType
+}
+
+func newFrameParser() *frameParser {
+	p := &frameParser{}
+	p.parser = gopacket.NewDecodingLayerParser(layers.LayerTypeEthernet,
+		&p.eth, &p.dot1q, &p.ip4, &p.ip6, &p.udp)
+	p.parser.IgnoreUnsupported = true
+	p.decoded = make([]gopacket.LayerType, 0, 8)
+	return p
+}
+
+// parse returns the source address, the destination port and the payload
+// of the UDP datagram contained in the provided frame. ok is false if the
+// frame does not contain an UDP datagram. Fragmented datagrams are not
+// reassembled. The returned values are only valid until the next call.
+func (p *frameParser) parse(frame []byte) (source net.IP, port uint16, payload []byte, ok bool) {
+	if err := p.parser.DecodeLayers(frame, &p.decoded); err != nil {
+		return nil, 0, nil, false
+	}
+	for _, layer := range p.decoded {
+		switch layer {
+		case layers.LayerTypeIPv4:
+			if p.ip4.Flags&layers.IPv4MoreFragments != 0 || p.ip4.FragOffset != 0 {
+				return nil, 0, nil, false
+			}
+			source = p.ip4.SrcIP
+		case layers.LayerTypeIPv6:
+			source = p.ip6.SrcIP
+		case layers.LayerTypeUDP:
+			if source == nil {
+				return nil, 0, nil, false
+			}
+			return source, uint16(p.udp.DstPort), p.udp.Payload, true
+		}
+	}
+	return nil, 0, nil, false
+}
@@ -0,0 +1,118 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package xdp
+
+import (
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"akvorado/common/helpers"
+)
+
+func serializeFrame(t *testing.T, l ...gopacket.SerializableLayer) []byte {
+	t.Helper()
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, l...); err != nil {
+		t.Fatalf("SerializeLayers() error:\n%+v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestFrameParser(t *testing.T) {
+	mac1 := net.HardwareAddr{0x02, 0, 0, 0, 0, 1}
+	mac2 := net.HardwareAddr{0x02, 0, 0, 0, 0, 2}
+	payload := gopacket.Payload("hello world!")
+
+	ip4 := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP("192.0.2.1").To4(),
+		DstIP:    net.ParseIP("192.0.2.2").To4(),
+	}
+	udp4 := &layers.UDP{SrcPort: 30000, DstPort: 2055}
+	udp4.SetNetworkLayerForChecksum(ip4)
+
+	ip6 := &layers.IPv6{
+		Version:    6,
+		HopLimit:   64,
+		NextHeader: layers.IPProtocolUDP,
+		SrcIP:      net.ParseIP("2001:db8::1"),
+		DstIP:      net.ParseIP("2001:db8::2"),
+	}
+	udp6 := &layers.UDP{SrcPort: 30000, DstPort: 6343}
+	udp6.SetNetworkLayerForChecksum(ip6)
+
+	ip4Fragment := *ip4
+	ip4Fragment.Flags = layers.IPv4MoreFragments
+	tcp := &layers.TCP{SrcPort: 30000, DstPort: 2055}
+	ip4TCP := *ip4
+	ip4TCP.Protocol = layers.IPProtocolTCP
+	tcp.SetNetworkLayerForChecksum(&ip4TCP)
+
+	cases := []struct {
+		Description string
+		Frame       []byte
+		OK          bool
+		Source      string
+		Port        uint16
+	}{
+		{
+			Description: "IPv4",
+			Frame: serializeFrame(t,
+				&layers.Ethernet{SrcMAC: mac1, DstMAC: mac2, EthernetType: layers.EthernetTypeIPv4},
+				ip4, udp4, payload),
+			OK:     true,
+			Source: "192.0.2.1",
+			Port:   2055,
+		}, {
+			Description: "IPv6 with VLAN",
+			Frame: serializeFrame(t,
+				&layers.Ethernet{SrcMAC: mac1, DstMAC: mac2, EthernetType: layers.EthernetTypeDot1Q},
+				&layers.Dot1Q{VLANIdentifier: 100, Type: layers.EthernetTypeIPv6},
+				ip6, udp6, payload),
+			OK:     true,
+			Source: "2001:db8::1",
+			Port:   6343,
+		}, {
+			Description: "IPv4 fragment",
+			Frame: serializeFrame(t,
+				&layers.Ethernet{SrcMAC: mac1, DstMAC: mac2, EthernetType: layers.EthernetTypeIPv4},
+				&ip4Fragment, udp4, payload),
+		}, {
+			Description: "TCP",
+			Frame: serializeFrame(t,
+				&layers.Ethernet{SrcMAC: mac1, DstMAC: mac2, EthernetType: layers.EthernetTypeIPv4},
+				&ip4TCP, tcp, payload),
+		}, {
+			Description: "truncated",
+			Frame:       []byte{0x02, 0, 0, 0},
+		},
+	}
+	parser := newFrameParser()
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			source, port, got, ok := parser.parse(tc.Frame)
+			if ok != tc.OK {
+				t.Fatalf("parse() ok == %v, expected %v", ok, tc.OK)
+			}
+			if !ok {
+				return
+			}
+			if source.String() != tc.Source {
+				t.Errorf("parse() source == %s, expected %s", source, tc.Source)
+			}
+			if port != tc.Port {
+				t.Errorf("parse() port == %d, expected %d", port, tc.Port)
+			}
+			if diff := helpers.Diff(string(got), "hello world!"); diff != "" {
+				t.Errorf("parse() payload (-got, +want):\n%s", diff)
+			}
+		})
+	}
+}
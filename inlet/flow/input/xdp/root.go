@@ -0,0 +1,243 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+// Package xdp receives flows with AF_XDP sockets, bypassing the network
+// stack of the kernel. An XDP program, loaded separately, should redirect
+// flow packets to these sockets. When AF_XDP is not available, it falls
+// back to the UDP input.
+package xdp
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"gopkg.in/tomb.v2"
+
+	"akvorado/common/daemon"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+	"akvorado/inlet/flow/input"
+)
+
+// pollTimeout is the maximum time a worker waits for packets before
+// checking if it should stop.
+const pollTimeout = 100 * time.Millisecond
+
+// Input represents the state of an AF_XDP input.
+type Input struct {
+	r      *reporter.Reporter
+	t      tomb.Tomb
+	config *Configuration
+
+	metrics struct {
+		bytes       *reporter.CounterVec
+		packets     *reporter.CounterVec
+		errors      *reporter.CounterVec
+		skipped     *reporter.CounterVec
+		outDrops    *reporter.CounterVec
+		inDrops     *reporter.GaugeVec
+		queueLength *reporter.GaugeVec
+		fallback    reporter.Gauge
+	}
+
+	port          uint16                     // destination port of flow packets (0 for any)
+	fallback      input.Input                // UDP input to use when AF_XDP is not available
+	usingFallback bool                       // true when the UDP input is used
+	sockets       []*socket                  // AF_XDP sockets, one per queue
+	ch            chan []*schema.FlowMessage // channel to send flows to
+	decoder       decoder.Decoder            // decoder to use
+}
+
+// New instantiate a new AF_XDP input from the provided configuration.
+func (configuration *Configuration) New(r *reporter.Reporter, daemon daemon.Component, dec decoder.Decoder) (input.Input, error) {
+	if configuration.Frames&(configuration.Frames-1) != 0 {
+		return nil, fmt.Errorf("number of frames (%d) should be a power of 2", configuration.Frames)
+	}
+	fallback, err := configuration.Fallback.New(r, daemon, dec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create fallback UDP input: %w", err)
+	}
+	input := &Input{
+		r:        r,
+		config:   configuration,
+		fallback: fallback,
+		ch:       make(chan []*schema.FlowMessage, configuration.QueueSize),
+		decoder:  dec,
+	}
+	if _, port, err := net.SplitHostPort(configuration.Fallback.Listen); err == nil {
+		if port, err := strconv.ParseUint(port, 10, 16); err == nil {
+			input.port = uint16(port)
+		}
+	}
+
+	input.metrics.bytes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "bytes",
+			Help: "Bytes received by the application.",
+		},
+		[]string{"interface", "queue", "exporter"},
+	)
+	input.metrics.packets = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "packets",
+			Help: "Packets received by the application.",
+		},
+		[]string{"interface", "queue", "exporter"},
+	)
+	input.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "errors",
+			Help: "Errors while receiving packets by the application.",
+		},
+		[]string{"interface", "queue"},
+	)
+	input.metrics.skipped = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "skipped_packets",
+			Help: "Packets received by the application which are not flows.",
+		},
+		[]string{"interface", "queue"},
+	)
+	input.metrics.outDrops = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "out_drops",
+			Help: "Dropped packets due to internal queue full.",
+		},
+		[]string{"interface", "queue", "exporter"},
+	)
+	input.metrics.inDrops = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "in_drops",
+			Help: "Dropped packets due to receive ring full.",
+		},
+		[]string{"interface", "queue"},
+	)
+	input.metrics.queueLength = r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "queue_length",
+			Help: "Number of flows waiting in the internal queue shared by workers.",
+		},
+		[]string{"interface"},
+	)
+	input.metrics.fallback = r.Gauge(
+		reporter.GaugeOpts{
+			Name: "fallback",
+			Help: "1 if the UDP input is used instead of AF_XDP.",
+		},
+	)
+
+	daemon.Track(&input.t, "inlet/flow/input/xdp")
+	return input, nil
+}
+
+// Start starts listening to the AF_XDP sockets and producing flows. If
+// they cannot be created, the UDP input is started instead.
+func (in *Input) Start() (<-chan []*schema.FlowMessage, error) {
+	in.r.Info().Str("interface", in.config.Interface).Msg("starting AF_XDP input")
+	for _, queue := range in.config.Queues {
+		s, err := newSocket(in.config.Interface, queue, in.config.Frames, in.config.XSKMap)
+		if err != nil {
+			in.r.Warn().Err(err).
+				Str("interface", in.config.Interface).
+				Msg("AF_XDP not available, falling back to UDP input")
+			for _, s := range in.sockets {
+				s.close()
+			}
+			in.sockets = nil
+			in.usingFallback = true
+			in.metrics.fallback.Set(1)
+			// Do not terminate the daemon
+			in.t.Go(func() error {
+				<-in.t.Dying()
+				return nil
+			})
+			return in.fallback.Start()
+		}
+		in.sockets = append(in.sockets, s)
+	}
+
+	for idx := range in.sockets {
+		s := in.sockets[idx]
+		queue := strconv.Itoa(int(in.config.Queues[idx]))
+		in.t.Go(func() error {
+			defer s.close()
+			iface := in.config.Interface
+			l := in.r.With().
+				Str("interface", iface).
+				Str("queue", queue).
+				Logger()
+			errLogger := l.Sample(reporter.BurstSampler(time.Minute, 1))
+			parser := newFrameParser()
+			count := 0
+			for {
+				select {
+				case <-in.t.Dying():
+					return nil
+				default:
+				}
+				_, err := s.receive(pollTimeout, func(frame []byte) {
+					source, port, payload, ok := parser.parse(frame)
+					if !ok || (in.port != 0 && port != in.port) {
+						in.metrics.skipped.WithLabelValues(iface, queue).Inc()
+						return
+					}
+					if count%1000 == 0 {
+						if drops, err := s.drops(); err == nil {
+							in.metrics.inDrops.WithLabelValues(iface, queue).Set(float64(drops))
+						}
+						in.metrics.queueLength.WithLabelValues(iface).Set(float64(len(in.ch)))
+					}
+					count++
+
+					srcIP := source.String()
+					in.metrics.bytes.WithLabelValues(iface, queue, srcIP).
+						Add(float64(len(payload)))
+					in.metrics.packets.WithLabelValues(iface, queue, srcIP).
+						Inc()
+					flows := in.decoder.Decode(decoder.RawFlow{
+						TimeReceived: time.Now(),
+						Payload:      payload,
+						Source:       source,
+					})
+					if len(flows) == 0 {
+						return
+					}
+					select {
+					case in.ch <- flows:
+					default:
+						errLogger.Warn().Msgf("dropping flow due to queue full (size %d)",
+							in.config.QueueSize)
+						in.metrics.outDrops.WithLabelValues(iface, queue, srcIP).
+							Inc()
+					}
+				})
+				if err != nil {
+					errLogger.Err(err).Msg("unable to receive packets")
+					in.metrics.errors.WithLabelValues(iface, queue).Inc()
+					continue
+				}
+			}
+		})
+	}
+	return in.ch, nil
+}
+
+// Stop stops the AF_XDP input
+func (in *Input) Stop() error {
+	l := in.r.With().Str("interface", in.config.Interface).Logger()
+	defer func() {
+		close(in.ch)
+		l.Info().Msg("AF_XDP input stopped")
+	}()
+	in.t.Kill(nil)
+	if err := in.t.Wait(); err != nil {
+		return err
+	}
+	if in.usingFallback {
+		return in.fallback.Stop()
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package xdp
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+	"akvorado/inlet/flow/decoder"
+)
+
+func TestFallback(t *testing.T) {
+	// Find a free port for the UDP input
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error:\n%+v", err)
+	}
+	listen := conn.LocalAddr().String()
+	conn.Close()
+
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration().(*Configuration)
+	configuration.Interface = "does-not-exist"
+	configuration.XSKMap = "/sys/fs/bpf/does-not-exist"
+	configuration.Fallback.Listen = listen
+	in, err := configuration.New(r, daemon.NewMock(t), &decoder.DummyDecoder{Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	ch, err := in.Start()
+	if err != nil {
+		t.Fatalf("Start() error:\n%+v", err)
+	}
+	defer func() {
+		if err := in.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}()
+
+	// Send data to the UDP input
+	client, err := net.Dial("udp", listen)
+	if err != nil {
+		t.Fatalf("Dial() error:\n%+v", err)
+	}
+	if _, err := client.Write([]byte("hello world!")); err != nil {
+		t.Fatalf("Write() error:\n%+v", err)
+	}
+
+	// Get it back
+	var got []*schema.FlowMessage
+	select {
+	case got = <-ch:
+		if len(got) == 0 {
+			t.Fatalf("empty decoded flows received")
+		}
+	case <-time.After(20 * time.Millisecond):
+		t.Fatal("no decoded flows received")
+	}
+	expected := []*schema.FlowMessage{
+		{
+			TimeReceived:    got[0].TimeReceived,
+			ExporterAddress: netip.MustParseAddr("::ffff:127.0.0.1"),
+			ProtobufDebug: map[schema.ColumnKey]interface{}{
+				schema.ColumnBytes:           12,
+				schema.ColumnPackets:         1,
+				schema.ColumnInIfDescription: []byte("hello world!"),
+			},
+		},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Input data (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_input_xdp_")
+	expectedMetrics := map[string]string{
+		`fallback`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Input metrics (-got, +want):\n%s", diff)
+	}
+}
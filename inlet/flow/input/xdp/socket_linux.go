@@ -0,0 +1,238 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build linux && (amd64 || arm64)
+
+package xdp
+
+import (
+	"fmt"
+	"net"
+	"runtime"
+	"sync/atomic"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// frameSize is the size of each frame of the memory area shared with the
+// kernel. This is the smallest size accepted by the kernel.
+const frameSize = 2048
+
+// ring is a ring shared with the kernel. The producer and the consumer are
+// free-running indexes.
+type ring struct {
+	mem      []byte
+	producer *uint32
+	consumer *uint32
+	descs    unsafe.Pointer
+	mask     uint32
+}
+
+// socket is an AF_XDP socket only used to receive packets.
+type socket struct {
+	fd   int
+	umem []byte
+	fill ring // descriptors are addresses in umem (uint64)
+	rx   ring // descriptors are unix.XDPDesc
+}
+
+// newSocket creates an AF_XDP socket attached to the provided queue of the
+// interface and registers it into the provided XSK map.
+func newSocket(ifname string, queue uint32, frames uint32, xskMap string) (*socket, error) {
+	iface, err := net.InterfaceByName(ifname)
+	if err != nil {
+		return nil, fmt.Errorf("unable to find interface %q: %w", ifname, err)
+	}
+	fd, err := unix.Socket(unix.AF_XDP, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create AF_XDP socket: %w", err)
+	}
+	s := &socket{fd: fd}
+	success := false
+	defer func() {
+		if !success {
+			s.close()
+		}
+	}()
+
+	// Memory area shared with the kernel
+	s.umem, err = unix.Mmap(-1, 0, int(frames*frameSize),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_PRIVATE|unix.MAP_ANONYMOUS|unix.MAP_POPULATE)
+	if err != nil {
+		return nil, fmt.Errorf("unable to allocate shared memory: %w", err)
+	}
+	reg := unix.XDPUmemReg{
+		Addr: uint64(uintptr(unsafe.Pointer(&s.umem[0]))),
+		Len:  uint64(len(s.umem)),
+		Size: frameSize,
+	}
+	if err := setsockopt(fd, unix.XDP_UMEM_REG, unsafe.Pointer(&reg), unsafe.Sizeof(reg)); err != nil {
+		return nil, fmt.Errorf("unable to register shared memory: %w", err)
+	}
+
+	// Rings. The completion ring is mandatory, even if we do not transmit.
+	for _, opt := range []int{unix.XDP_UMEM_FILL_RING, unix.XDP_UMEM_COMPLETION_RING, unix.XDP_RX_RING} {
+		if err := unix.SetsockoptInt(fd, unix.SOL_XDP, opt, int(frames)); err != nil {
+			return nil, fmt.Errorf("unable to set ring size: %w", err)
+		}
+	}
+	var offsets unix.XDPMmapOffsets
+	if err := getsockopt(fd, unix.XDP_MMAP_OFFSETS, unsafe.Pointer(&offsets), unsafe.Sizeof(offsets)); err != nil {
+		return nil, fmt.Errorf("unable to get ring offsets: %w", err)
+	}
+	if s.fill, err = mapRing(fd, offsets.Fr, frames, 8, unix.XDP_UMEM_PGOFF_FILL_RING); err != nil {
+		return nil, fmt.Errorf("unable to map fill ring: %w", err)
+	}
+	if s.rx, err = mapRing(fd, offsets.Rx, frames, uint32(unsafe.Sizeof(unix.XDPDesc{})), unix.XDP_PGOFF_RX_RING); err != nil {
+		return nil, fmt.Errorf("unable to map RX ring: %w", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrXDP{
+		Ifindex: uint32(iface.Index),
+		QueueID: queue,
+	}); err != nil {
+		return nil, fmt.Errorf("unable to bind AF_XDP socket to %s/%d: %w", ifname, queue, err)
+	}
+	if err := registerSocket(xskMap, queue, fd); err != nil {
+		return nil, fmt.Errorf("unable to register AF_XDP socket into %s: %w", xskMap, err)
+	}
+
+	// Give all frames to the kernel
+	for i := uint32(0); i < frames; i++ {
+		*(*uint64)(unsafe.Add(s.fill.descs, uintptr(i)*8)) = uint64(i) * frameSize
+	}
+	atomic.StoreUint32(s.fill.producer, frames)
+
+	success = true
+	return s, nil
+}
+
+// mapRing maps a ring of the socket into memory.
+func mapRing(fd int, offsets unix.XDPRingOffset, size uint32, descSize uint32, pgoff int64) (ring, error) {
+	mem, err := unix.Mmap(fd, pgoff, int(offsets.Desc)+int(size*descSize),
+		unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED|unix.MAP_POPULATE)
+	if err != nil {
+		return ring{}, err
+	}
+	return ring{
+		mem:      mem,
+		producer: (*uint32)(unsafe.Pointer(&mem[offsets.Producer])),
+		consumer: (*uint32)(unsafe.Pointer(&mem[offsets.Consumer])),
+		descs:    unsafe.Pointer(&mem[offsets.Desc]),
+		mask:     size - 1,
+	}, nil
+}
+
+// receive waits up to the provided timeout for packets and calls fn with
+// each of them. Frames are given back to the kernel once fn returns. It
+// returns the number of received packets.
+func (s *socket) receive(timeout time.Duration, fn func(frame []byte)) (int, error) {
+	fds := []unix.PollFd{{Fd: int32(s.fd), Events: unix.POLLIN}}
+	if _, err := unix.Poll(fds, int(timeout.Milliseconds())); err != nil {
+		if err == unix.EINTR {
+			return 0, nil
+		}
+		return 0, err
+	}
+	consumer := atomic.LoadUint32(s.rx.consumer)
+	count := atomic.LoadUint32(s.rx.producer) - consumer
+	if count == 0 {
+		return 0, nil
+	}
+	// The fill ring cannot be full: frames we receive were taken from it.
+	producer := atomic.LoadUint32(s.fill.producer)
+	descSize := unsafe.Sizeof(unix.XDPDesc{})
+	for i := uint32(0); i < count; i++ {
+		desc := (*unix.XDPDesc)(unsafe.Add(s.rx.descs, uintptr((consumer+i)&s.rx.mask)*descSize))
+		fn(s.umem[desc.Addr : desc.Addr+uint64(desc.Len)])
+		*(*uint64)(unsafe.Add(s.fill.descs, uintptr((producer+i)&s.fill.mask)*8)) = desc.Addr &^ (frameSize - 1)
+	}
+	atomic.StoreUint32(s.rx.consumer, consumer+count)
+	atomic.StoreUint32(s.fill.producer, producer+count)
+	return int(count), nil
+}
+
+// drops returns the number of packets dropped by the kernel for this socket.
+func (s *socket) drops() (uint64, error) {
+	var stats unix.XDPStatistics
+	if err := getsockopt(s.fd, unix.XDP_STATISTICS, unsafe.Pointer(&stats), unsafe.Sizeof(stats)); err != nil {
+		return 0, err
+	}
+	return stats.Rx_dropped + stats.Rx_ring_full, nil
+}
+
+// close closes the socket. It is removed from the XSK map by the kernel.
+func (s *socket) close() {
+	for _, r := range []ring{s.rx, s.fill} {
+		if r.mem != nil {
+			unix.Munmap(r.mem)
+		}
+	}
+	unix.Close(s.fd)
+	if s.umem != nil {
+		unix.Munmap(s.umem)
+	}
+}
+
+func setsockopt(fd int, opt int, value unsafe.Pointer, length uintptr) error {
+	_, _, errno := unix.Syscall6(unix.SYS_SETSOCKOPT,
+		uintptr(fd), unix.SOL_XDP, uintptr(opt), uintptr(value), length, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+func getsockopt(fd int, opt int, value unsafe.Pointer, length uintptr) error {
+	l := uint32(length)
+	_, _, errno := unix.Syscall6(unix.SYS_GETSOCKOPT,
+		uintptr(fd), unix.SOL_XDP, uintptr(opt), uintptr(value), uintptr(unsafe.Pointer(&l)), 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// registerSocket adds the socket into the XSK map pinned at the provided
+// path, using the queue as a key.
+func registerSocket(path string, queue uint32, fd int) error {
+	pathname, err := unix.BytePtrFromString(path)
+	if err != nil {
+		return err
+	}
+	getAttr := struct {
+		pathname  uint64
+		bpfFD     uint32
+		fileFlags uint32
+	}{pathname: uint64(uintptr(unsafe.Pointer(pathname)))}
+	mapFD, _, errno := unix.Syscall(unix.SYS_BPF, unix.BPF_OBJ_GET,
+		uintptr(unsafe.Pointer(&getAttr)), unsafe.Sizeof(getAttr))
+	runtime.KeepAlive(pathname)
+	if errno != 0 {
+		return errno
+	}
+	defer unix.Close(int(mapFD))
+
+	value := uint32(fd)
+	updateAttr := struct {
+		mapFD uint32
+		_     uint32
+		key   uint64
+		value uint64
+		flags uint64
+	}{
+		mapFD: uint32(mapFD),
+		key:   uint64(uintptr(unsafe.Pointer(&queue))),
+		value: uint64(uintptr(unsafe.Pointer(&value))),
+	}
+	_, _, errno = unix.Syscall(unix.SYS_BPF, unix.BPF_MAP_UPDATE_ELEM,
+		uintptr(unsafe.Pointer(&updateAttr)), unsafe.Sizeof(updateAttr))
+	runtime.KeepAlive(&queue)
+	runtime.KeepAlive(&value)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
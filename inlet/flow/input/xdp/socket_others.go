@@ -0,0 +1,29 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build !linux || !(amd64 || arm64)
+
+package xdp
+
+import (
+	"errors"
+	"time"
+)
+
+// socket is an AF_XDP socket. Not supported on this platform.
+type socket struct{}
+
+// newSocket always returns an error.
+func newSocket(_ string, _ uint32, _ uint32, _ string) (*socket, error) {
+	return nil, errors.New("AF_XDP not supported on this platform")
+}
+
+func (s *socket) receive(_ time.Duration, _ func(frame []byte)) (int, error) {
+	return 0, nil
+}
+
+func (s *socket) drops() (uint64, error) {
+	return 0, nil
+}
+
+func (s *socket) close() {}
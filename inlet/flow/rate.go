@@ -12,29 +12,48 @@ import (
 )
 
 type limiter struct {
-	l           *rate.Limiter
-	dropped     uint64  // dropped during the current second
-	total       uint64  // total during the current second
-	dropRate    float64 // drop rate during the last second
+	config      RateLimitConfiguration
+	flows       *rate.Limiter // nil when flows are not limited
+	packets     *rate.Limiter // nil when packets are not limited
+	dropped     uint64        // dropped during the current second
+	total       uint64        // total during the current second
+	dropRate    float64       // drop rate during the last second
 	currentTick time.Time
 }
 
 // allowMessages tell if we can transmit the provided messages,
 // depending on the rate limiter configuration. If yes, their sampling
-// rate may be modified to match current drop rate.
+// rate may be modified to match current drop rate. The provided
+// messages come from the same datagram.
 func (c *Component) allowMessages(fmsgs []*schema.FlowMessage) bool {
 	count := len(fmsgs)
-	if c.config.RateLimit == 0 || count == 0 {
+	if count == 0 || (c.config.RateLimit == 0 && c.config.RateLimits == nil) {
 		return true
 	}
 	exporter := fmsgs[0].ExporterAddress
+	c.limitersLock.Lock()
+	defer c.limitersLock.Unlock()
 	exporterLimiter, ok := c.limiters[exporter]
 	if !ok {
-		exporterLimiter = &limiter{
-			l: rate.NewLimiter(rate.Limit(c.config.RateLimit), int(c.config.RateLimit/10)),
+		config := c.config.RateLimits.LookupOrDefault(exporter, RateLimitConfiguration{
+			Flows: c.config.RateLimit,
+		})
+		exporterLimiter = &limiter{config: config}
+		if config.Flows > 0 {
+			exporterLimiter.flows = rate.NewLimiter(config.Flows, int(config.Flows/10))
+		}
+		if config.Packets > 0 {
+			burst := int(config.Packets / 10)
+			if burst < 1 {
+				burst = 1
+			}
+			exporterLimiter.packets = rate.NewLimiter(config.Packets, burst)
 		}
 		c.limiters[exporter] = exporterLimiter
 	}
+	if exporterLimiter.flows == nil && exporterLimiter.packets == nil {
+		return true
+	}
 	now := time.Now()
 	tick := now.Truncate(200 * time.Millisecond) // we use a 200-millisecond resolution
 	if exporterLimiter.currentTick.UnixMilli() != tick.UnixMilli() {
@@ -44,11 +63,26 @@ func (c *Component) allowMessages(fmsgs []*schema.FlowMessage) bool {
 		exporterLimiter.currentTick = tick
 	}
 	exporterLimiter.total += uint64(count)
-	if !exporterLimiter.l.AllowN(now, count) {
+	var limit string
+	if exporterLimiter.packets != nil && !exporterLimiter.packets.AllowN(now, 1) {
+		limit = "packets"
+	} else if exporterLimiter.flows != nil && !exporterLimiter.flows.AllowN(now, count) {
+		limit = "flows"
+	}
+	if limit != "" {
 		exporterLimiter.dropped += uint64(count)
+		policy := exporterLimiter.config.Policy
+		c.metrics.rateLimitedFlows.WithLabelValues(exporter.Unmap().String(), limit, policy.String()).
+			Add(float64(count))
+		if policy == RateLimitPolicyDrop {
+			c.errLogger.Warn().
+				Str("exporter", exporter.Unmap().String()).
+				Str("limit", limit).
+				Msg("dropping flows due to rate limit")
+		}
 		return false
 	}
-	if exporterLimiter.dropRate > 0 {
+	if exporterLimiter.dropRate > 0 && exporterLimiter.config.Policy == RateLimitPolicySample {
 		for _, flow := range fmsgs {
 			flow.SamplingRate *= uint32(1 / (1 - exporterLimiter.dropRate))
 		}
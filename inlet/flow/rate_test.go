@@ -0,0 +1,65 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"net/netip"
+	"testing"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestRateLimits(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Inputs = nil
+	config.RateLimits = helpers.MustNewSubnetMap(map[string]RateLimitConfiguration{
+		"::ffff:192.0.2.1/128": {Packets: 10},
+		"::ffff:192.0.2.2/128": {Flows: 100, Policy: RateLimitPolicyDrop},
+	})
+	c := NewMock(t, r, config)
+
+	datagram := func(exporter string, count int) []*schema.FlowMessage {
+		fmsgs := make([]*schema.FlowMessage, count)
+		for i := range fmsgs {
+			fmsgs[i] = &schema.FlowMessage{
+				ExporterAddress: netip.MustParseAddr(exporter),
+				SamplingRate:    1000,
+			}
+		}
+		return fmsgs
+	}
+	allowed := func(exporter string, datagrams, flows int) int {
+		count := 0
+		for i := 0; i < datagrams; i++ {
+			if c.allowMessages(datagram(exporter, flows)) {
+				count++
+			}
+		}
+		return count
+	}
+
+	// The burst is a tenth of the limit
+	if got := allowed("::ffff:192.0.2.1", 10, 1); got != 1 {
+		t.Errorf("allowMessages() allowed %d datagrams for 192.0.2.1, expected 1", got)
+	}
+	if got := allowed("::ffff:192.0.2.2", 10, 5); got != 2 {
+		t.Errorf("allowMessages() allowed %d datagrams for 192.0.2.2, expected 2", got)
+	}
+	// Exporters without configuration are not limited
+	if got := allowed("::ffff:192.0.2.3", 100, 10); got != 100 {
+		t.Errorf("allowMessages() allowed %d datagrams for 192.0.2.3, expected 100", got)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_flow_rate_limited_")
+	expectedMetrics := map[string]string{
+		`flows_total{exporter="192.0.2.1",limit="packets",policy="sample"}`: "9",
+		`flows_total{exporter="192.0.2.2",limit="flows",policy="drop"}`:     "40",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
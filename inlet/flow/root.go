@@ -9,7 +9,12 @@ import (
 	"fmt"
 	netHTTP "net/http"
 	"net/netip"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/gin-gonic/gin"
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/daemon"
@@ -28,18 +33,40 @@ type Component struct {
 	config Configuration
 
 	metrics struct {
-		decoderStats  *reporter.CounterVec
-		decoderErrors *reporter.CounterVec
+		decoderStats      *reporter.CounterVec
+		decoderErrors     *reporter.CounterVec
+		zeroVolumeFlows   *reporter.CounterVec
+		rateLimitedFlows  *reporter.CounterVec
+		rejectedDatagrams *reporter.CounterVec
+		duplicateFlows    *reporter.CounterVec
 	}
+	errLogger reporter.Logger
 
 	// Channel for sending flows out of the package.
 	outgoingFlows chan *schema.FlowMessage
 
 	// Per-exporter rate-limiters
-	limiters map[netip.Addr]*limiter
+	limiters     map[netip.Addr]*limiter
+	limitersLock sync.Mutex
 
-	// Inputs
-	inputs []input.Input
+	// Subscribers to decoded flows
+	tail tail
+
+	// Subscribers to raw datagrams
+	capture capture
+
+	// Non-zero when tail and capture are disabled
+	rawAccessDisabled uint32
+
+	// Per-exporter ingest state
+	ingest ingestTracker
+
+	// Flow deduplication (nil when disabled)
+	dedup *deduplicator
+
+	// Inputs and decoders
+	inputs   []input.Input
+	decoders []decoder.Decoder
 }
 
 // Dependencies are the dependencies of the flow component.
@@ -59,27 +86,57 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		r:             r,
 		d:             &dependencies,
 		config:        configuration,
+		errLogger:     r.Sample(reporter.BurstSampler(10*time.Second, 3)),
 		outgoingFlows: make(chan *schema.FlowMessage),
 		limiters:      make(map[netip.Addr]*limiter),
+		ingest:        ingestTracker{states: make(map[netip.Addr]*ingestState)},
 		inputs:        make([]input.Input, len(configuration.Inputs)),
 	}
 
+	for ie, iec := range c.config.Decoders.InformationElements {
+		column, ok := c.d.Schema.LookupColumnByName(iec.Column)
+		if !ok || column.Key < schema.ColumnLast {
+			return nil, fmt.Errorf("information element %s: unknown custom dimension %q", ie, iec.Column)
+		}
+	}
+
+	if c.config.Decoders.SpreadInterval > 0 && c.config.Decoders.TimestampSource == decoder.TimestampSourceReceived {
+		return nil, errors.New("spreading flows requires a timestamp source other than \"received\"")
+	}
+
+	dedup, err := newDeduplicator(c.r, c.config.Deduplication, c.d.Schema)
+	if err != nil {
+		return nil, err
+	}
+	c.dedup = dedup
+
 	// Initialize decoders (at most once each)
 	alreadyInitialized := map[string]decoder.Decoder{}
 	decs := make([]decoder.Decoder, len(configuration.Inputs))
 	for idx, input := range c.config.Inputs {
-		dec, ok := alreadyInitialized[input.Decoder]
-		if ok {
-			decs[idx] = dec
-			continue
+		if column, _ := c.d.Schema.LookupColumnByKey(schema.ColumnZeroVolume); column.Disabled {
+			for _, policy := range input.ZeroVolumePolicy.ToMap() {
+				if policy == ZeroVolumePolicyTag {
+					return nil, errors.New("zero volume policy \"tag\" requires the ZeroVolume column")
+				}
+			}
 		}
-		decoderfunc, ok := decoders[input.Decoder]
+		dec, ok := alreadyInitialized[input.Decoder]
 		if !ok {
-			return nil, fmt.Errorf("unknown decoder %q", input.Decoder)
+			decoderfunc, ok := decoder.Lookup(input.Decoder)
+			if !ok {
+				return nil, fmt.Errorf("unknown decoder %q (registered: %s)",
+					input.Decoder, strings.Join(decoder.Registered(), ", "))
+			}
+			dec = decoderfunc(r, c.config.Decoders, decoder.Dependencies{Schema: c.d.Schema})
+			alreadyInitialized[input.Decoder] = dec
+			c.decoders = append(c.decoders, dec)
+		}
+		wrapped, err := c.wrapDecoder(dec, input)
+		if err != nil {
+			return nil, err
 		}
-		dec = decoderfunc(r, decoder.Dependencies{Schema: c.d.Schema})
-		alreadyInitialized[input.Decoder] = dec
-		decs[idx] = c.wrapDecoder(dec, input.UseSrcAddrForExporterAddr)
+		decs[idx] = wrapped
 	}
 
 	// Initialize inputs
@@ -106,6 +163,34 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		},
 		[]string{"name"},
 	)
+	c.metrics.zeroVolumeFlows = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "zero_volume_flows_total",
+			Help: "Number of flows without bytes or packets.",
+		},
+		[]string{"exporter", "policy"},
+	)
+	c.metrics.rateLimitedFlows = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "rate_limited_flows_total",
+			Help: "Number of flows dropped due to rate limits.",
+		},
+		[]string{"exporter", "limit", "policy"},
+	)
+	c.metrics.rejectedDatagrams = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "rejected_datagrams_total",
+			Help: "Number of datagrams rejected due to exporter prefix filtering.",
+		},
+		[]string{"exporter"},
+	)
+	c.metrics.duplicateFlows = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "duplicate_flows_total",
+			Help: "Number of flows already reported by another exporter.",
+		},
+		[]string{"exporter", "strategy"},
+	)
 
 	c.d.Daemon.Track(&c.t, "inlet/flow")
 
@@ -114,6 +199,10 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 			w.Header().Set("Content-Type", "text/plain")
 			w.Write([]byte(c.d.Schema.ProtobufDefinition()))
 		}))
+	c.d.HTTP.GinRouter.GET("/api/v0/console/flows", c.tailHTTPHandler)
+	c.d.HTTP.GinRouter.GET("/api/v0/inlet/flow/capture", c.captureHTTPHandler)
+	c.d.HTTP.GinRouter.GET("/api/v0/inlet/flow/exporters", c.exportersHTTPHandler)
+	c.d.HTTP.GinRouter.GET("/api/v0/inlet/flow/templates", c.templatesHTTPHandler)
 
 	return &c, nil
 }
@@ -123,8 +212,36 @@ func (c *Component) Flows() <-chan *schema.FlowMessage {
 	return c.outgoingFlows
 }
 
+// DisableRawAccess disables the tail and capture endpoints. They expose flows
+// and datagrams before they are processed by the core component, notably
+// before addresses are anonymized.
+func (c *Component) DisableRawAccess() {
+	atomic.StoreUint32(&c.rawAccessDisabled, 1)
+}
+
+// rawAccessAllowed tells if the tail and capture endpoints can be used. When
+// they cannot, an error is sent to the client.
+func (c *Component) rawAccessAllowed(gc *gin.Context) bool {
+	if atomic.LoadUint32(&c.rawAccessDisabled) != 0 {
+		gc.JSON(netHTTP.StatusForbidden, gin.H{"message": "Not available when addresses are anonymized."})
+		return false
+	}
+	return true
+}
+
 // Start starts the flow component.
 func (c *Component) Start() error {
+	// Restore decoders state
+	if c.config.Decoders.TemplatesPersistFile != "" {
+		for _, dec := range c.decoders {
+			if dec, ok := dec.(decoder.Persistent); ok {
+				if err := dec.Load(c.config.Decoders.TemplatesPersistFile); err != nil {
+					c.r.Err(err).Msg("cannot load templates, ignoring")
+				}
+			}
+		}
+	}
+
 	for _, input := range c.inputs {
 		ch, err := input.Start()
 		stopper := input.Stop
@@ -139,18 +256,38 @@ func (c *Component) Start() error {
 					return nil
 				case fmsgs := <-ch:
 					if c.allowMessages(fmsgs) {
+						if len(fmsgs) > 0 {
+							c.ingest.forwarded(fmsgs[0].ExporterAddress)
+						}
 						for _, fmsg := range fmsgs {
+							if c.dedup != nil && c.dedup.duplicate(fmsg) {
+								strategy := c.config.Deduplication.Strategy
+								c.metrics.duplicateFlows.WithLabelValues(
+									fmsg.ExporterAddress.Unmap().String(), strategy.String()).Inc()
+								if strategy == DeduplicationStrategyDrop {
+									schema.ReleaseFlowMessage(fmsg)
+									continue
+								}
+							}
+							if c.tail.active() {
+								c.tail.publish(fmsg)
+							}
 							select {
 							case <-c.t.Dying():
 								return nil
 							case c.outgoingFlows <- fmsg:
 							}
 						}
+					} else {
+						for _, fmsg := range fmsgs {
+							schema.ReleaseFlowMessage(fmsg)
+						}
 					}
 				}
 			}
 		})
 	}
+	c.t.Go(c.runIngestEvaluation)
 	return nil
 }
 
@@ -158,6 +295,15 @@ func (c *Component) Start() error {
 func (c *Component) Stop() error {
 	defer func() {
 		close(c.outgoingFlows)
+		if c.config.Decoders.TemplatesPersistFile != "" {
+			for _, dec := range c.decoders {
+				if dec, ok := dec.(decoder.Persistent); ok {
+					if err := dec.Save(c.config.Decoders.TemplatesPersistFile); err != nil {
+						c.r.Err(err).Msg("cannot save templates")
+					}
+				}
+			}
+		}
 		c.r.Info().Msg("flow component stopped")
 	}()
 	c.r.Info().Msg("stopping flow component")
@@ -11,8 +11,11 @@ import (
 	"testing"
 	"time"
 
+	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/http"
 	"akvorado/common/reporter"
+	"akvorado/common/schema"
 	"akvorado/inlet/flow/input/file"
 )
 
@@ -123,3 +126,77 @@ func TestFlow(t *testing.T) {
 		}
 	}
 }
+
+func TestFlowTemplatesPersist(t *testing.T) {
+	_, src, _, _ := runtime.Caller(0)
+	base := path.Join(path.Dir(src), "decoder", "netflow", "testdata")
+	outDir := t.TempDir()
+	writeFiles := func(files ...string) []string {
+		outFiles := []string{}
+		for _, f := range files {
+			outFile := path.Join(outDir, f)
+			err := os.WriteFile(outFile, helpers.ReadPcapPayload(t, path.Join(base, f)), 0o666)
+			if err != nil {
+				t.Fatalf("WriteFile(%q) error:\n%+v", outFile, err)
+			}
+			outFiles = append(outFiles, outFile)
+		}
+		return outFiles
+	}
+	templatesFile := path.Join(t.TempDir(), "templates")
+
+	// First run, receiving templates
+	{
+		r := reporter.NewMock(t)
+		config := DefaultConfiguration()
+		config.Decoders.TemplatesPersistFile = templatesFile
+		config.Inputs = []InputConfiguration{{
+			Decoder: "netflow",
+			Config: &file.Configuration{
+				Paths: writeFiles("options-template-257.pcap", "options-data-257.pcap",
+					"template-260.pcap", "data-260.pcap"),
+			},
+		}}
+		c, err := New(r, config, Dependencies{
+			Daemon: daemon.NewMock(t),
+			HTTP:   http.NewMock(t, r),
+			Schema: schema.NewMock(t),
+		})
+		if err != nil {
+			t.Fatalf("New() error:\n%+v", err)
+		}
+		if err := c.Start(); err != nil {
+			t.Fatalf("Start() error:\n%+v", err)
+		}
+		select {
+		case <-c.Flows():
+		case <-time.After(time.Second):
+			t.Fatal("no flow received")
+		}
+		if err := c.Stop(); err != nil {
+			t.Fatalf("Stop() error:\n%+v", err)
+		}
+	}
+
+	// Second run, receiving only data
+	{
+		r := reporter.NewMock(t)
+		config := DefaultConfiguration()
+		config.Decoders.TemplatesPersistFile = templatesFile
+		config.Inputs = []InputConfiguration{{
+			Decoder: "netflow",
+			Config: &file.Configuration{
+				Paths: writeFiles("data-260.pcap"),
+			},
+		}}
+		c := NewMock(t, r, config)
+		select {
+		case fmsg := <-c.Flows():
+			if fmsg.SamplingRate != 30000 {
+				t.Fatalf("SamplingRate after restart is %d, expected 30000", fmsg.SamplingRate)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no flow received after restart")
+		}
+	}
+}
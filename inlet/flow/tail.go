@@ -0,0 +1,184 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+
+	"akvorado/common/helpers"
+	"akvorado/common/schema"
+)
+
+// tailClient is a subscriber to decoded flows.
+type tailClient struct {
+	exporter netip.Addr // unmapped, invalid when not filtering
+	limiter  *rate.Limiter
+	flows    chan *schema.FlowMessage
+	dropped  chan struct{}
+	drop     sync.Once
+}
+
+// tail dispatches a copy of decoded flows to subscribers. When there is no
+// subscriber, publishing is free as long as the caller checks active() first.
+type tail struct {
+	count   uint32 // number of subscribers, for a lockless check
+	lock    sync.RWMutex
+	clients map[*tailClient]struct{}
+}
+
+// active tells if there is at least one subscriber.
+func (t *tail) active() bool {
+	return atomic.LoadUint32(&t.count) > 0
+}
+
+// subscribe registers a new subscriber. Flows are filtered by exporter
+// address (when valid) and limited to the provided rate.
+func (t *tail) subscribe(exporter netip.Addr, limit rate.Limit) *tailClient {
+	burst := int(limit)
+	if burst < 1 {
+		burst = 1
+	}
+	client := &tailClient{
+		exporter: exporter.Unmap(),
+		limiter:  rate.NewLimiter(limit, burst),
+		flows:    make(chan *schema.FlowMessage, burst),
+		dropped:  make(chan struct{}),
+	}
+	t.lock.Lock()
+	if t.clients == nil {
+		t.clients = map[*tailClient]struct{}{}
+	}
+	t.clients[client] = struct{}{}
+	atomic.AddUint32(&t.count, 1)
+	t.lock.Unlock()
+	return client
+}
+
+// unsubscribe removes a subscriber.
+func (t *tail) unsubscribe(client *tailClient) {
+	t.lock.Lock()
+	if _, ok := t.clients[client]; ok {
+		delete(t.clients, client)
+		atomic.AddUint32(&t.count, ^uint32(0))
+	}
+	t.lock.Unlock()
+}
+
+// publish sends a copy of the provided flow to each matching subscriber.
+// Flows above the rate limit of a subscriber are skipped. Subscribers not
+// keeping up are dropped instead of slowing down the caller.
+func (t *tail) publish(flow *schema.FlowMessage) {
+	var flowCopy *schema.FlowMessage
+	exporter := flow.ExporterAddress.Unmap()
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	for client := range t.clients {
+		if client.exporter.IsValid() && client.exporter != exporter {
+			continue
+		}
+		if !client.limiter.Allow() {
+			continue
+		}
+		if flowCopy == nil {
+			// The original flow will be modified by the core component.
+			flowCopy = flow.Copy()
+		}
+		select {
+		case client.flows <- flowCopy:
+		default:
+			client.drop.Do(func() { close(client.dropped) })
+		}
+	}
+}
+
+// tailParameters are the query parameters for the tail endpoint.
+type tailParameters struct {
+	Exporter string `form:"exporter"`
+}
+
+// tailHTTPHandler streams decoded flows as server-sent events. Each client
+// gets its own filter and rate limit. The stream ends after a configured
+// duration or when the client is too slow. This is intended for debug only.
+func (c *Component) tailHTTPHandler(gc *gin.Context) {
+	if !c.rawAccessAllowed(gc) {
+		return
+	}
+	var params tailParameters
+	if err := gc.ShouldBindQuery(&params); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	var exporter netip.Addr
+	if params.Exporter != "" {
+		var err error
+		exporter, err = netip.ParseAddr(params.Exporter)
+		if err != nil {
+			gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+			return
+		}
+	}
+
+	client := c.tail.subscribe(exporter, c.config.TailRateLimit)
+	defer c.tail.unsubscribe(client)
+	timer := time.NewTimer(c.config.TailMaxDuration)
+	defer timer.Stop()
+
+	gc.Header("Content-Type", "text/event-stream")
+	gc.Header("Cache-Control", "no-cache")
+	gc.Status(http.StatusOK)
+	gc.Writer.Flush()
+	for {
+		select {
+		case <-c.t.Dying():
+			return
+		case <-gc.Request.Context().Done():
+			return
+		case <-timer.C:
+			gc.SSEvent("end", "maximum duration reached")
+			return
+		case <-client.dropped:
+			gc.SSEvent("end", "client too slow")
+			return
+		case flow := <-client.flows:
+			event, err := c.tailEvent(flow)
+			if err != nil {
+				c.r.Err(err).Msg("cannot decode flow for tail endpoint")
+				continue
+			}
+			gc.SSEvent("flow", event)
+			gc.Writer.Flush()
+		}
+	}
+}
+
+// tailEvent turns a flow into a map from column names to values. Columns
+// already encoded by the decoder are decoded from the protobuf
+// representation, while the remaining fields come from the flow itself.
+func (c *Component) tailEvent(flow *schema.FlowMessage) (map[string]interface{}, error) {
+	event := map[string]interface{}{}
+	fields, err := json.Marshal(flow)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(fields, &event); err != nil {
+		return nil, err
+	}
+	columns, err := c.d.Schema.ProtobufUnmarshal(c.d.Schema.ProtobufMarshal(flow))
+	if err != nil {
+		return nil, err
+	}
+	for key, value := range columns {
+		column, _ := c.d.Schema.LookupColumnByKey(key)
+		event[column.Name] = value
+	}
+	return event, nil
+}
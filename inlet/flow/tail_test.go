@@ -0,0 +1,174 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"bufio"
+	"fmt"
+	netHTTP "net/http"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestTailPublish(t *testing.T) {
+	var tl tail
+	if tl.active() {
+		t.Fatal("active() == true without subscribers")
+	}
+	exporter1 := netip.MustParseAddr("::ffff:192.0.2.1")
+	exporter2 := netip.MustParseAddr("::ffff:192.0.2.2")
+
+	all := tl.subscribe(netip.Addr{}, 1000)
+	filtered := tl.subscribe(netip.MustParseAddr("192.0.2.1"), 1000)
+	slow := tl.subscribe(netip.Addr{}, 1)
+	if !tl.active() {
+		t.Fatal("active() == false with subscribers")
+	}
+
+	flow1 := &schema.FlowMessage{ExporterAddress: exporter1, SamplingRate: 1, DstASPath: []uint32{65000}}
+	flow2 := &schema.FlowMessage{ExporterAddress: exporter2, SamplingRate: 2}
+	tl.publish(flow1)
+	tl.publish(flow2)
+
+	// The original flow is not shared with subscribers
+	flow1.SamplingRate = 10
+	flow1.DstASPath[0] = 65001
+
+	got := []uint32{}
+	for len(all.flows) > 0 {
+		got = append(got, (<-all.flows).SamplingRate)
+	}
+	if diff := helpers.Diff(got, []uint32{1, 2}); diff != "" {
+		t.Errorf("publish() to unfiltered client (-got, +want):\n%s", diff)
+	}
+	got = []uint32{}
+	for len(filtered.flows) > 0 {
+		flow := <-filtered.flows
+		got = append(got, flow.SamplingRate)
+		got = append(got, flow.DstASPath...)
+	}
+	if diff := helpers.Diff(got, []uint32{1, 65000}); diff != "" {
+		t.Errorf("publish() to filtered client (-got, +want):\n%s", diff)
+	}
+
+	// The slow client only got one flow due to rate limiting and was not dropped.
+	if len(slow.flows) != 1 {
+		t.Errorf("publish() to rate-limited client got %d flows, expected 1", len(slow.flows))
+	}
+	select {
+	case <-slow.dropped:
+		t.Error("publish() dropped rate-limited client")
+	default:
+	}
+
+	// Not consuming flows get the client dropped
+	for i := 0; i < 1000; i++ {
+		tl.publish(flow2)
+	}
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 10; i++ {
+		tl.publish(flow2)
+	}
+	select {
+	case <-all.dropped:
+	default:
+		t.Error("publish() did not drop slow client")
+	}
+
+	tl.unsubscribe(all)
+	tl.unsubscribe(filtered)
+	tl.unsubscribe(slow)
+	tl.unsubscribe(slow)
+	if tl.active() {
+		t.Fatal("active() == true after unsubscribing everything")
+	}
+}
+
+func TestTailHTTP(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Inputs = nil
+	config.TailMaxDuration = time.Second
+	c := NewMock(t, r, config)
+
+	resp, err := netHTTP.Get(fmt.Sprintf("http://%s/api/v0/console/flows?exporter=192.0.2.1",
+		c.d.HTTP.LocalAddr()))
+	if err != nil {
+		t.Fatalf("GET /api/v0/console/flows:\n%+v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("GET /api/v0/console/flows status code %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "text/event-stream" {
+		t.Fatalf("GET /api/v0/console/flows content type %q", contentType)
+	}
+	if !c.tail.active() {
+		t.Fatal("active() == false with a connected client")
+	}
+	c.tail.publish(&schema.FlowMessage{ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.2")})
+	bf := &schema.FlowMessage{
+		ExporterAddress: netip.MustParseAddr("::ffff:192.0.2.1"),
+		SamplingRate:    1000,
+	}
+	c.d.Schema.ProtobufAppendVarint(bf, schema.ColumnBytes, 1500)
+	c.d.Schema.ProtobufAppendVarint(bf, schema.ColumnPackets, 2)
+	c.d.Schema.ProtobufAppendVarint(bf, schema.ColumnSrcPort, 443)
+	c.tail.publish(bf)
+
+	reader := bufio.NewReader(resp.Body)
+	lines := []string{}
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			break
+		}
+		if line = strings.TrimSpace(line); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	expected := []string{
+		"event:flow",
+		`data:{"Bytes":1500,"DstAS":0,"DstASPath":null,"DstAddr":"","DstCommunities":null,"DstVRFID":0,"DstVlan":0,"ExportDirection":0,"ExporterAddress":"::ffff:192.0.2.1","GotASPath":false,"InIf":0,"NextHop":"","OutIf":0,"Packets":2,"SamplingRate":1000,"SrcAS":0,"SrcAddr":"","SrcPort":443,"SrcVRFID":0,"SrcVlan":0,"TimeReceived":0}`,
+		"event:end",
+		"data:maximum duration reached",
+	}
+	if diff := helpers.Diff(lines, expected); diff != "" {
+		t.Fatalf("GET /api/v0/console/flows (-got, +want):\n%s", diff)
+	}
+	if c.tail.active() {
+		t.Fatal("active() == true after client disconnection")
+	}
+}
+
+func TestRawAccessDisabled(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Inputs = nil
+	c := NewMock(t, r, config)
+	c.DisableRawAccess()
+
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL:        "/api/v0/console/flows",
+			StatusCode: 403,
+			JSONOutput: gin.H{"message": "Not available when addresses are anonymized."},
+		}, {
+			URL:        "/api/v0/inlet/flow/capture?exporter=192.0.2.1",
+			StatusCode: 403,
+			JSONOutput: gin.H{"message": "Not available when addresses are anonymized."},
+		},
+	})
+	if c.tail.active() {
+		t.Fatal("active() == true while tail is disabled")
+	}
+}
@@ -0,0 +1,33 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/inlet/flow/decoder"
+)
+
+// templatesHTTPHandler lists the templates known by the decoders, with
+// their layout and some statistics. The list can be restricted to an
+// exporter.
+func (c *Component) templatesHTTPHandler(gc *gin.Context) {
+	exporter := gc.Query("exporter")
+	templates := []decoder.Template{}
+	for _, dec := range c.decoders {
+		dec, ok := dec.(decoder.TemplateInspector)
+		if !ok {
+			continue
+		}
+		for _, template := range dec.Templates() {
+			if exporter != "" && template.Exporter != exporter {
+				continue
+			}
+			templates = append(templates, template)
+		}
+	}
+	gc.JSON(http.StatusOK, gin.H{"templates": templates})
+}
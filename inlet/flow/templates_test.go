@@ -0,0 +1,59 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package flow
+
+import (
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+	"akvorado/inlet/flow/decoder"
+)
+
+func TestTemplatesHTTPHandler(t *testing.T) {
+	r := reporter.NewMock(t)
+	c := NewMock(t, r, DefaultConfiguration())
+	c.decoders[0].Decode(decoder.RawFlow{
+		Payload:      helpers.ReadPcapPayload(t, filepath.Join("decoder", "netflow", "testdata", "options-template-257.pcap")),
+		Source:       net.ParseIP("127.0.0.1"),
+		TimeReceived: time.Date(2023, 3, 10, 10, 0, 0, 0, time.UTC),
+	})
+
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "all exporters",
+			URL:         "/api/v0/inlet/flow/templates",
+			JSONOutput: gin.H{
+				"templates": []gin.H{{
+					"exporter":              "127.0.0.1",
+					"version":               9,
+					"observation-domain-id": 0,
+					"template-id":           257,
+					"type":                  "options-template",
+					"scopes": []gin.H{
+						{"id": 1, "name": "System", "length": 4},
+					},
+					"fields": []gin.H{
+						{"id": 48, "name": "FLOW_SAMPLER_ID", "length": 2},
+						{"id": 50, "name": "FLOW_SAMPLER_RANDOM_INTERVAL", "length": 4},
+						{"id": 49, "name": "FLOW_SAMPLER_MODE", "length": 1},
+						{"id": 84, "name": "SAMPLER_NAME", "length": 32},
+						{"id": 34, "name": "SAMPLING_INTERVAL", "length": 4},
+					},
+					"records":   0,
+					"last-seen": "2023-03-10T10:00:00Z",
+				}},
+			},
+		}, {
+			Description: "unknown exporter",
+			URL:         "/api/v0/inlet/flow/templates?exporter=192.0.2.1",
+			JSONOutput:  gin.H{"templates": []gin.H{}},
+		},
+	})
+}
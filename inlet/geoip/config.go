@@ -14,12 +14,14 @@ import (
 
 // Configuration describes the configuration for the GeoIP component.
 type Configuration struct {
-	// ASNDatabase defines the path to the ASN database.
-	ASNDatabase string
-	// GeoDatabase defines the path to the geo database.
-	GeoDatabase string
+	// ASNDatabase defines the paths to the ASN databases. When several
+	// databases are provided, the first one knowing an IP address wins.
+	ASNDatabase []string `doc:"Paths to the ASN databases, by order of precedence"`
+	// GeoDatabase defines the paths to the geo databases. When several
+	// databases are provided, the first one knowing an IP address wins.
+	GeoDatabase []string `doc:"Paths to the geo databases, by order of precedence"`
 	// Optional tells if we need to error if not present on start.
-	Optional bool
+	Optional bool `doc:"Do not fail when the databases are not present on start"`
 }
 
 // DefaultConfiguration represents the default configuration for the
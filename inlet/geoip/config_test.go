@@ -33,7 +33,7 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				ASNDatabase: "something",
+				ASNDatabase: []string{"something"},
 				Optional:    true,
 			},
 		}, {
@@ -46,8 +46,8 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				ASNDatabase: "something",
-				GeoDatabase: "something else",
+				ASNDatabase: []string{"something"},
+				GeoDatabase: []string{"something else"},
 			},
 		}, {
 			Description: "no country-database, geoip-database",
@@ -59,8 +59,21 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 				}
 			},
 			Expected: Configuration{
-				ASNDatabase: "something",
-				GeoDatabase: "something else",
+				ASNDatabase: []string{"something"},
+				GeoDatabase: []string{"something else"},
+			},
+		}, {
+			Description: "several databases",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"asn-database": []string{"internal", "something"},
+					"geo-database": []string{"internal", "something else"},
+				}
+			},
+			Expected: Configuration{
+				ASNDatabase: []string{"internal", "something"},
+				GeoDatabase: []string{"internal", "something else"},
 			},
 		}, {
 			Description: "both country-database, geoip-database",
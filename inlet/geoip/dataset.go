@@ -0,0 +1,167 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package geoip
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// dataset is the set of active GeoIP databases. A new dataset is built on
+// each reload and swapped with the active one. Each database is loaded
+// independently: when one of them cannot be opened, the previous version is
+// kept, if any. Databases of the same kind are sorted by precedence.
+type dataset struct {
+	generation uint64
+	loaded     time.Time
+	geo        []*maxminddb.Reader
+	asn        []*maxminddb.Reader
+	databases  []databaseInfo
+	readers    map[string]*maxminddb.Reader // indexed by path
+}
+
+// databaseInfo describes a database from a dataset.
+type databaseInfo struct {
+	Database   string    `json:"database"`
+	Path       string    `json:"path"`
+	Type       string    `json:"type"`
+	BuildEpoch time.Time `json:"build-epoch"`
+	SHA256     string    `json:"sha256"`
+}
+
+// close closes the databases of a dataset not used by the provided one
+// (which may be nil).
+func (ds *dataset) close(next *dataset) {
+	for path, db := range ds.readers {
+		if next != nil && next.readers[path] == db {
+			continue
+		}
+		db.Close()
+	}
+}
+
+// openDatabase opens the provided database and returns its description.
+func openDatabase(which string, path string) (*maxminddb.Reader, databaseInfo, error) {
+	info := databaseInfo{Database: which, Path: path}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, info, fmt.Errorf("cannot open %s database: %w", which, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, info, fmt.Errorf("cannot read %s database: %w", which, err)
+	}
+	info.SHA256 = hex.EncodeToString(h.Sum(nil))
+	db, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, info, fmt.Errorf("cannot open %s database: %w", which, err)
+	}
+	info.Type = db.Metadata.DatabaseType
+	info.BuildEpoch = time.Unix(int64(db.Metadata.BuildEpoch), 0).UTC()
+	return db, info, nil
+}
+
+// reload builds a new dataset and makes it active. The database at the
+// provided path is reopened, others are kept from the current dataset. When
+// the path is empty, all databases are (re)opened. If a database cannot be
+// opened, its previous version is kept, if any, and an error is returned. The
+// current dataset stays active when no database was opened.
+func (c *Component) reload(changed string) error {
+	current := c.db.Load()
+	next := &dataset{
+		loaded:  time.Now(),
+		readers: map[string]*maxminddb.Reader{},
+	}
+	if current != nil {
+		next.generation = current.generation
+	}
+	next.generation++
+	var lastErr error
+	updated := current == nil
+	for _, databases := range []struct {
+		which   string
+		paths   []string
+		readers *[]*maxminddb.Reader
+	}{
+		{"geo", c.config.GeoDatabase, &next.geo},
+		{"asn", c.config.ASNDatabase, &next.asn},
+	} {
+		for _, path := range databases.paths {
+			if current != nil && changed != "" && path != changed {
+				if db, ok := current.readers[path]; ok {
+					next.readers[path] = db
+					*databases.readers = append(*databases.readers, db)
+					next.databases = append(next.databases, databaseInfoFor(current, path))
+				}
+				continue
+			}
+			c.r.Debug().Str("database", path).Msgf("opening %s database", databases.which)
+			db, info, err := openDatabase(databases.which, path)
+			if err != nil {
+				c.metrics.reloadErrors.Inc()
+				lastErr = err
+				if current == nil || current.readers[path] == nil {
+					c.r.Err(err).Str("database", path).Msg("cannot open GeoIP database, skipping it")
+					continue
+				}
+				c.r.Err(err).Str("database", path).Msg("cannot reload GeoIP database, keeping the current one")
+				db = current.readers[path]
+				info = databaseInfoFor(current, path)
+			} else {
+				updated = true
+				c.metrics.databaseRefresh.WithLabelValues(info.Database, info.Path).Inc()
+				c.metrics.databaseBuildEpoch.WithLabelValues(info.Database, info.Path).Set(float64(info.BuildEpoch.Unix()))
+			}
+			next.readers[path] = db
+			*databases.readers = append(*databases.readers, db)
+			next.databases = append(next.databases, info)
+		}
+	}
+
+	if !updated {
+		return lastErr
+	}
+	old := c.db.Swap(next)
+	c.metrics.generation.Set(float64(next.generation))
+	c.r.Info().Uint64("generation", next.generation).Msg("GeoIP databases loaded")
+	if old != nil {
+		c.r.Debug().Uint64("generation", old.generation).Msg("closing previous GeoIP databases")
+		old.close(next)
+	}
+	return lastErr
+}
+
+// databaseInfoFor returns the description of the database at the provided
+// path in a dataset.
+func databaseInfoFor(ds *dataset, path string) databaseInfo {
+	for _, info := range ds.databases {
+		if info.Path == path {
+			return info
+		}
+	}
+	return databaseInfo{}
+}
+
+// databasesHTTPHandler returns the description of the active dataset.
+func (c *Component) databasesHTTPHandler(gc *gin.Context) {
+	current := c.db.Load()
+	if current == nil {
+		gc.JSON(http.StatusOK, gin.H{"generation": 0, "databases": []databaseInfo{}})
+		return
+	}
+	gc.JSON(http.StatusOK, gin.H{
+		"generation": current.generation,
+		"loaded":     current.loaded,
+		"databases":  current.databases,
+	})
+}
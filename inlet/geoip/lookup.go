@@ -6,6 +6,8 @@ package geoip
 import (
 	"net"
 	"net/netip"
+
+	"github.com/oschwald/maxminddb-golang"
 )
 
 type asn struct {
@@ -18,34 +20,112 @@ type country struct {
 	} `maxminddb:"country"`
 }
 
+type location struct {
+	City struct {
+		Names struct {
+			English string `maxminddb:"en"`
+		} `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		IsoCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  float64 `maxminddb:"latitude"`
+		Longitude float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+	Subdivisions []struct {
+		Names struct {
+			English string `maxminddb:"en"`
+		} `maxminddb:"names"`
+	} `maxminddb:"subdivisions"`
+}
+
+// Location is the location of an IP address. City, region and coordinates
+// are only available with a city database.
+type Location struct {
+	Country   string
+	City      string
+	Region    string
+	Latitude  float32
+	Longitude float32
+}
+
+// lookup tries the provided databases in order until one of them knows about
+// the provided IP address. It returns true on success.
+func (c *Component) lookup(which string, databases []*maxminddb.Reader, ip netip.Addr, try func(db *maxminddb.Reader, ip net.IP) bool) bool {
+	if len(databases) == 0 {
+		return false
+	}
+	ip16 := ip.As16()
+	for _, db := range databases {
+		if try(db, net.IP(ip16[:])) {
+			c.metrics.databaseHit.WithLabelValues(which).Inc()
+			return true
+		}
+	}
+	c.metrics.databaseMiss.WithLabelValues(which).Inc()
+	return false
+}
+
 // LookupASN returns the result of a lookup for an AS number.
 func (c *Component) LookupASN(ip netip.Addr) uint32 {
-	asnDB := c.db.asn.Load()
-	if asnDB != nil {
+	current := c.db.Load()
+	if current == nil {
+		return 0
+	}
+	var result uint32
+	c.lookup("asn", current.asn, ip, func(db *maxminddb.Reader, ip net.IP) bool {
 		var asn asn
-		ip := ip.As16()
-		err := asnDB.Lookup(net.IP(ip[:]), &asn)
-		if err == nil && asn.AutonomousSystemNumber != 0 {
-			c.metrics.databaseHit.WithLabelValues("asn").Inc()
-			return uint32(asn.AutonomousSystemNumber)
+		if err := db.Lookup(ip, &asn); err != nil || asn.AutonomousSystemNumber == 0 {
+			return false
 		}
-		c.metrics.databaseMiss.WithLabelValues("asn").Inc()
-	}
-	return 0
+		result = uint32(asn.AutonomousSystemNumber)
+		return true
+	})
+	return result
 }
 
 // LookupCountry returns the result of a lookup for country.
 func (c *Component) LookupCountry(ip netip.Addr) string {
-	geoDB := c.db.geo.Load()
-	if geoDB != nil {
+	current := c.db.Load()
+	if current == nil {
+		return ""
+	}
+	var result string
+	c.lookup("geo", current.geo, ip, func(db *maxminddb.Reader, ip net.IP) bool {
 		var country country
-		ip := ip.As16()
-		err := geoDB.Lookup(net.IP(ip[:]), &country)
-		if err == nil && country.Country.IsoCode != "" {
-			c.metrics.databaseHit.WithLabelValues("geo").Inc()
-			return country.Country.IsoCode
+		if err := db.Lookup(ip, &country); err != nil || country.Country.IsoCode == "" {
+			return false
 		}
-		c.metrics.databaseMiss.WithLabelValues("geo").Inc()
+		result = country.Country.IsoCode
+		return true
+	})
+	return result
+}
+
+// LookupLocation returns the result of a lookup for country, city, region,
+// and coordinates.
+func (c *Component) LookupLocation(ip netip.Addr) Location {
+	current := c.db.Load()
+	if current == nil {
+		return Location{}
 	}
-	return ""
+	var result Location
+	c.lookup("geo", current.geo, ip, func(db *maxminddb.Reader, ip net.IP) bool {
+		var location location
+		if err := db.Lookup(ip, &location); err != nil || location.Country.IsoCode == "" {
+			return false
+		}
+		result = Location{
+			Country:   location.Country.IsoCode,
+			City:      location.City.Names.English,
+			Latitude:  float32(location.Location.Latitude),
+			Longitude: float32(location.Location.Longitude),
+		}
+		if len(location.Subdivisions) > 0 {
+			result.Region = location.Subdivisions[0].Names.English
+		}
+		return true
+	})
+	return result
 }
@@ -4,10 +4,14 @@
 package geoip
 
 import (
+	"fmt"
 	"net/netip"
+	"path/filepath"
 	"testing"
 
+	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/http"
 	"akvorado/common/reporter"
 )
 
@@ -50,12 +54,142 @@ func TestLookup(t *testing.T) {
 	}
 	gotMetrics := r.GetMetrics("akvorado_inlet_geoip_")
 	expectedMetrics := map[string]string{
-		`db_hits_total{database="asn"}`:    "3",
-		`db_hits_total{database="geo"}`:    "3",
-		`db_misses_total{database="asn"}`:  "2",
-		`db_misses_total{database="geo"}`:  "2",
-		`db_refresh_total{database="asn"}`: "1",
-		`db_refresh_total{database="geo"}`: "1",
+		fmt.Sprintf(`db_build_epoch_seconds{database="asn",path="%s"}`, c.config.ASNDatabase[0]): "1.63710205e+09",
+		fmt.Sprintf(`db_build_epoch_seconds{database="geo",path="%s"}`, c.config.GeoDatabase[0]): "1.63710205e+09",
+		`db_hits_total{database="asn"}`:   "3",
+		`db_hits_total{database="geo"}`:   "3",
+		`db_misses_total{database="asn"}`: "2",
+		`db_misses_total{database="geo"}`: "2",
+		fmt.Sprintf(`db_refresh_total{database="asn",path="%s"}`, c.config.ASNDatabase[0]): "1",
+		fmt.Sprintf(`db_refresh_total{database="geo",path="%s"}`, c.config.GeoDatabase[0]): "1",
+		`generation`:          "1",
+		`reload_errors_total`: "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestLookupLocation(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.GeoDatabase = []string{filepath.Join("testdata", "GeoLite2-City-Test.mmdb")}
+	c, err := New(r, config, Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   http.NewMock(t, r),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	cases := []struct {
+		IP       string
+		Expected Location
+	}{
+		{
+			IP: "81.2.69.142",
+			Expected: Location{
+				Country:   "GB",
+				City:      "London",
+				Region:    "England",
+				Latitude:  51.5142,
+				Longitude: -0.0931,
+			},
+		}, {
+			IP: "::ffff:2.125.160.217",
+			Expected: Location{
+				Country:   "GB",
+				City:      "Boxford",
+				Region:    "England",
+				Latitude:  51.75,
+				Longitude: -1.25,
+			},
+		}, {
+			IP: "2a02:ff00::1:1",
+			Expected: Location{
+				Country:   "IT",
+				Latitude:  42.8333,
+				Longitude: 12.8333,
+			},
+		}, {
+			IP: "1.0.0.0",
+		},
+	}
+	for _, tc := range cases {
+		got := c.LookupLocation(netip.MustParseAddr(tc.IP))
+		if diff := helpers.Diff(got, tc.Expected); diff != "" {
+			t.Errorf("LookupLocation(%q) (-got, +want):\n%s", tc.IP, diff)
+		}
+	}
+	// A city database can also be used for countries
+	if got := c.LookupCountry(netip.MustParseAddr("81.2.69.142")); got != "GB" {
+		t.Errorf("LookupCountry(%q) == %q but expected %q", "81.2.69.142", got, "GB")
+	}
+}
+
+func TestLookupPrecedence(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.ASNDatabase = []string{
+		filepath.Join("testdata", "Internal-Test.mmdb"),
+		filepath.Join("testdata", "GeoLite2-ASN-Test.mmdb"),
+	}
+	config.GeoDatabase = []string{
+		filepath.Join("testdata", "Internal-Test.mmdb"),
+		filepath.Join("testdata", "GeoLite2-Country-Test.mmdb"),
+	}
+	c, err := New(r, config, Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   http.NewMock(t, r),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	cases := []struct {
+		IP              string
+		ExpectedASN     uint32
+		ExpectedCountry string
+	}{
+		{
+			// Only in the internal database
+			IP:              "10.1.1.1",
+			ExpectedASN:     64512,
+			ExpectedCountry: "FR",
+		}, {
+			// In both databases, the internal one wins
+			IP:          "1.0.0.1",
+			ExpectedASN: 64513,
+		}, {
+			// Only in the public databases
+			IP:              "::ffff:2.125.160.216",
+			ExpectedCountry: "GB",
+		}, {
+			IP:              "67.43.156.77",
+			ExpectedASN:     35908,
+			ExpectedCountry: "BT",
+		}, {
+			IP: "192.0.2.1",
+		},
+	}
+	for _, tc := range cases {
+		gotCountry := c.LookupCountry(netip.MustParseAddr(tc.IP))
+		if diff := helpers.Diff(gotCountry, tc.ExpectedCountry); diff != "" {
+			t.Errorf("LookupCountry(%q) (-got, +want):\n%s", tc.IP, diff)
+		}
+		gotASN := c.LookupASN(netip.MustParseAddr(tc.IP))
+		if diff := helpers.Diff(gotASN, tc.ExpectedASN); diff != "" {
+			t.Errorf("LookupASN(%q) (-got, +want):\n%s", tc.IP, diff)
+		}
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_geoip_", "db_hits_", "db_misses_")
+	expectedMetrics := map[string]string{
+		`db_hits_total{database="asn"}`:   "3",
+		`db_hits_total{database="geo"}`:   "3",
+		`db_misses_total{database="asn"}`: "2",
+		`db_misses_total{database="geo"}`: "2",
 	}
 	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
 		t.Fatalf("Metrics (-got, +want):\n%s", diff)
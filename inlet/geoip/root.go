@@ -12,10 +12,10 @@ import (
 	"time"
 
 	"github.com/fsnotify/fsnotify"
-	"github.com/oschwald/maxminddb-golang"
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/daemon"
+	"akvorado/common/http"
 	"akvorado/common/reporter"
 )
 
@@ -26,20 +26,21 @@ type Component struct {
 	t      tomb.Tomb
 	config Configuration
 
-	db struct {
-		geo atomic.Pointer[maxminddb.Reader]
-		asn atomic.Pointer[maxminddb.Reader]
-	}
+	db      atomic.Pointer[dataset]
 	metrics struct {
-		databaseRefresh *reporter.CounterVec
-		databaseHit     *reporter.CounterVec
-		databaseMiss    *reporter.CounterVec
+		databaseRefresh    *reporter.CounterVec
+		databaseHit        *reporter.CounterVec
+		databaseMiss       *reporter.CounterVec
+		databaseBuildEpoch *reporter.GaugeVec
+		generation         reporter.Gauge
+		reloadErrors       reporter.Counter
 	}
 }
 
 // Dependencies define the dependencies of the GeoIP component.
 type Dependencies struct {
 	Daemon daemon.Component
+	HTTP   *http.Component
 }
 
 // New creates a new GeoIP component.
@@ -49,19 +50,15 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		d:      &dependencies,
 		config: configuration,
 	}
-	if c.config.GeoDatabase != "" {
-		c.config.GeoDatabase = filepath.Clean(c.config.GeoDatabase)
-	}
-	if c.config.ASNDatabase != "" {
-		c.config.ASNDatabase = filepath.Clean(c.config.ASNDatabase)
-	}
+	c.config.GeoDatabase = cleanPaths(c.config.GeoDatabase)
+	c.config.ASNDatabase = cleanPaths(c.config.ASNDatabase)
 	c.d.Daemon.Track(&c.t, "inlet/geoip")
 	c.metrics.databaseRefresh = c.r.CounterVec(
 		reporter.CounterOpts{
 			Name: "db_refresh_total",
 			Help: "Refresh event for a GeoIP database.",
 		},
-		[]string{"database"},
+		[]string{"database", "path"},
 	)
 	c.metrics.databaseHit = c.r.CounterVec(
 		reporter.CounterOpts{
@@ -77,46 +74,41 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		},
 		[]string{"database"},
 	)
-	return &c, nil
-}
+	c.metrics.databaseBuildEpoch = c.r.GaugeVec(
+		reporter.GaugeOpts{
+			Name: "db_build_epoch_seconds",
+			Help: "Build time of the active GeoIP database.",
+		},
+		[]string{"database", "path"},
+	)
+	c.metrics.generation = c.r.Gauge(
+		reporter.GaugeOpts{
+			Name: "generation",
+			Help: "Generation of the active set of GeoIP databases.",
+		},
+	)
+	c.metrics.reloadErrors = c.r.Counter(
+		reporter.CounterOpts{
+			Name: "reload_errors_total",
+			Help: "Number of failed reloads of the GeoIP databases.",
+		},
+	)
 
-// openDatabase opens the provided database and closes the current
-// one. Do nothing if the path is empty.
-func (c *Component) openDatabase(which string, path string, container *atomic.Pointer[maxminddb.Reader]) error {
-	if path == "" {
-		return nil
-	}
-	c.r.Debug().Str("database", path).Msgf("opening %s database", which)
-	db, err := maxminddb.Open(path)
-	if err != nil {
-		c.r.Err(err).
-			Str("database", path).
-			Msgf("cannot open %s database", which)
-		return fmt.Errorf("cannot open %s database: %w", which, err)
-	}
-	old := container.Swap(db)
-	c.metrics.databaseRefresh.WithLabelValues(which).Inc()
-	if old != nil {
-		c.r.Debug().
-			Str("database", path).
-			Msgf("closing previous %s database", which)
-		old.Close()
-	}
-	return nil
+	c.d.HTTP.GinRouter.GET("/api/v0/inlet/geoip/databases", c.databasesHTTPHandler)
+
+	return &c, nil
 }
 
 // Start starts the GeoIP component.
 func (c *Component) Start() error {
-	if err := c.openDatabase("geo", c.config.GeoDatabase, &c.db.geo); err != nil && !c.config.Optional {
-		return err
-	}
-	if err := c.openDatabase("asn", c.config.ASNDatabase, &c.db.asn); err != nil && !c.config.Optional {
-		return err
-	}
-	if c.db.geo.Load() == nil && c.db.asn.Load() == nil {
+	if len(c.config.GeoDatabase) == 0 && len(c.config.ASNDatabase) == 0 {
 		c.r.Warn().Msg("skipping GeoIP component: no database specified")
 		return nil
 	}
+	if err := c.reload(""); err != nil && !c.config.Optional {
+		c.db.Swap(nil).close(nil)
+		return err
+	}
 
 	c.r.Info().Msg("starting GeoIP component")
 
@@ -127,11 +119,10 @@ func (c *Component) Start() error {
 		return fmt.Errorf("cannot setup watcher: %w", err)
 	}
 	dirs := map[string]struct{}{}
-	if c.config.GeoDatabase != "" {
-		dirs[filepath.Dir(c.config.GeoDatabase)] = struct{}{}
-	}
-	if c.config.ASNDatabase != "" {
-		dirs[filepath.Dir(c.config.ASNDatabase)] = struct{}{}
+	paths := map[string]bool{}
+	for _, path := range append(append([]string{}, c.config.GeoDatabase...), c.config.ASNDatabase...) {
+		dirs[filepath.Dir(path)] = struct{}{}
+		paths[path] = true
 	}
 	for k := range dirs {
 		if err := watcher.Add(k); err != nil {
@@ -162,11 +153,8 @@ func (c *Component) Start() error {
 				if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) {
 					continue
 				}
-				if filepath.Clean(event.Name) == c.config.GeoDatabase {
-					c.openDatabase("geo", c.config.GeoDatabase, &c.db.geo)
-				}
-				if filepath.Clean(event.Name) == c.config.ASNDatabase {
-					c.openDatabase("asn", c.config.ASNDatabase, &c.db.asn)
+				if path := filepath.Clean(event.Name); paths[path] {
+					c.reload(path)
 				}
 			}
 		}
@@ -176,7 +164,7 @@ func (c *Component) Start() error {
 
 // Stop stops the GeoIP component.
 func (c *Component) Stop() error {
-	if c.db.geo.Load() == nil && c.db.asn.Load() == nil {
+	if len(c.config.GeoDatabase) == 0 && len(c.config.ASNDatabase) == 0 {
 		return nil
 	}
 	c.r.Info().Msg("stopping GeoIP component")
@@ -184,3 +172,14 @@ func (c *Component) Stop() error {
 	c.t.Kill(nil)
 	return c.t.Wait()
 }
+
+// cleanPaths cleans the provided paths, dropping empty ones.
+func cleanPaths(paths []string) []string {
+	result := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if path != "" {
+			result = append(result, filepath.Clean(path))
+		}
+	}
+	return result
+}
@@ -4,14 +4,19 @@
 package geoip
 
 import (
+	"fmt"
 	"io"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/gin-gonic/gin"
+
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/http"
 	"akvorado/common/reporter"
 )
 
@@ -36,49 +41,97 @@ func copyFile(src string, dst string) {
 func TestDatabaseRefresh(t *testing.T) {
 	dir := t.TempDir()
 	config := DefaultConfiguration()
-	config.GeoDatabase = filepath.Join(dir, "country.mmdb")
-	config.ASNDatabase = filepath.Join(dir, "asn.mmdb")
+	geoDatabase := filepath.Join(dir, "country.mmdb")
+	asnDatabase := filepath.Join(dir, "asn.mmdb")
+	config.GeoDatabase = []string{geoDatabase}
+	config.ASNDatabase = []string{asnDatabase}
 
-	copyFile(filepath.Join("testdata", "GeoLite2-Country-Test.mmdb"),
-		config.GeoDatabase)
-	copyFile(filepath.Join("testdata", "GeoLite2-ASN-Test.mmdb"),
-		config.ASNDatabase)
+	copyFile(filepath.Join("testdata", "GeoLite2-Country-Test.mmdb"), geoDatabase)
+	copyFile(filepath.Join("testdata", "GeoLite2-ASN-Test.mmdb"), asnDatabase)
 
 	r := reporter.NewMock(t)
-	c, err := New(r, config, Dependencies{Daemon: daemon.NewMock(t)})
+	h := http.NewMock(t, r)
+	c, err := New(r, config, Dependencies{Daemon: daemon.NewMock(t), HTTP: h})
 	if err != nil {
 		t.Fatalf("New() error:\n%+v", err)
 	}
 	helpers.StartStop(t, c)
 
 	// Check we did load both databases
-	gotMetrics := r.GetMetrics("akvorado_inlet_geoip_db_")
+	gotMetrics := r.GetMetrics("akvorado_inlet_geoip_", "db_refresh_", "generation", "reload_")
 	expectedMetrics := map[string]string{
-		`refresh_total{database="asn"}`: "1",
-		`refresh_total{database="geo"}`: "1",
+		fmt.Sprintf(`db_refresh_total{database="asn",path="%s"}`, asnDatabase): "1",
+		fmt.Sprintf(`db_refresh_total{database="geo",path="%s"}`, geoDatabase): "1",
+		`generation`:          "1",
+		`reload_errors_total`: "0",
 	}
 	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
 		t.Fatalf("Metrics (-got, +want):\n%s", diff)
 	}
 
-	// Check we can reload the database
+	// Check we can reload the database. Only the modified one is reloaded.
 	copyFile(filepath.Join("testdata", "GeoLite2-Country-Test.mmdb"),
 		filepath.Join(dir, "tmp.mmdb"))
-	os.Rename(filepath.Join(dir, "tmp.mmdb"), config.GeoDatabase)
+	os.Rename(filepath.Join(dir, "tmp.mmdb"), geoDatabase)
+	time.Sleep(20 * time.Millisecond)
+	gotMetrics = r.GetMetrics("akvorado_inlet_geoip_", "db_refresh_", "generation", "reload_")
+	expectedMetrics = map[string]string{
+		fmt.Sprintf(`db_refresh_total{database="asn",path="%s"}`, asnDatabase): "1",
+		fmt.Sprintf(`db_refresh_total{database="geo",path="%s"}`, geoDatabase): "2",
+		`generation`:          "2",
+		`reload_errors_total`: "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+
+	// An invalid database is not loaded and the current one is kept.
+	os.WriteFile(filepath.Join(dir, "tmp.mmdb"), []byte("garbage"), 0o644)
+	os.Rename(filepath.Join(dir, "tmp.mmdb"), asnDatabase)
 	time.Sleep(20 * time.Millisecond)
-	gotMetrics = r.GetMetrics("akvorado_inlet_geoip_db_")
+	gotMetrics = r.GetMetrics("akvorado_inlet_geoip_", "db_refresh_", "generation", "reload_")
 	expectedMetrics = map[string]string{
-		`refresh_total{database="asn"}`: "1",
-		`refresh_total{database="geo"}`: "2",
+		fmt.Sprintf(`db_refresh_total{database="asn",path="%s"}`, asnDatabase): "1",
+		fmt.Sprintf(`db_refresh_total{database="geo",path="%s"}`, geoDatabase): "2",
+		`generation`:          "2",
+		`reload_errors_total`: "1",
 	}
 	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
 		t.Fatalf("Metrics (-got, +want):\n%s", diff)
 	}
+	if got := c.LookupASN(netip.MustParseAddr("::ffff:1.0.0.1")); got != 15169 {
+		t.Errorf("LookupASN() == %d, expected 15169", got)
+	}
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/inlet/geoip/databases",
+			JSONOutput: gin.H{
+				"generation": 2,
+				"loaded":     c.db.Load().loaded.Format(time.RFC3339Nano),
+				"databases": []gin.H{
+					{
+						"database":    "geo",
+						"path":        geoDatabase,
+						"type":        "GeoLite2-Country",
+						"build-epoch": "2021-11-16T22:34:10Z",
+						"sha256":      "d9de6ac4c181c7ea5b02efcfec18e690657d60ad5132d3e5db782710166b719d",
+					}, {
+						"database":    "asn",
+						"path":        asnDatabase,
+						"type":        "GeoLite2-ASN",
+						"build-epoch": "2021-11-16T22:34:10Z",
+						"sha256":      "8ed7a5251d9118626ba94a57f98ca2d3837d7857dd751330c873331cd57a453f",
+					},
+				},
+			},
+		},
+	})
 }
 
 func TestStartWithoutDatabase(t *testing.T) {
 	r := reporter.NewMock(t)
-	c, err := New(r, DefaultConfiguration(), Dependencies{Daemon: daemon.NewMock(t)})
+	c, err := New(r, DefaultConfiguration(), Dependencies{Daemon: daemon.NewMock(t), HTTP: http.NewMock(t, r)})
 	if err != nil {
 		t.Fatalf("New() error:\n%+v", err)
 	}
@@ -87,9 +140,9 @@ func TestStartWithoutDatabase(t *testing.T) {
 
 func TestStartWithMissingDatabase(t *testing.T) {
 	geoConfiguration := DefaultConfiguration()
-	geoConfiguration.GeoDatabase = "/i/do/not/exist"
+	geoConfiguration.GeoDatabase = []string{"/i/do/not/exist"}
 	asnConfiguration := DefaultConfiguration()
-	asnConfiguration.ASNDatabase = "/i/do/not/exist"
+	asnConfiguration.ASNDatabase = []string{"/i/do/not/exist"}
 	cases := []struct {
 		Name   string
 		Config Configuration
@@ -100,7 +153,7 @@ func TestStartWithMissingDatabase(t *testing.T) {
 	for _, tc := range cases {
 		t.Run(tc.Name, func(t *testing.T) {
 			r := reporter.NewMock(t)
-			c, err := New(r, tc.Config, Dependencies{Daemon: daemon.NewMock(t)})
+			c, err := New(r, tc.Config, Dependencies{Daemon: daemon.NewMock(t), HTTP: http.NewMock(t, r)})
 			if err != nil {
 				t.Fatalf("New() error:\n%+v", err)
 			}
@@ -110,3 +163,50 @@ func TestStartWithMissingDatabase(t *testing.T) {
 		})
 	}
 }
+
+func TestStartWithOptionalMissingDatabase(t *testing.T) {
+	dir := t.TempDir()
+	config := DefaultConfiguration()
+	geoDatabase := filepath.Join(dir, "country.mmdb")
+	asnDatabase := filepath.Join(dir, "asn.mmdb")
+	config.GeoDatabase = []string{geoDatabase}
+	config.ASNDatabase = []string{asnDatabase}
+	config.Optional = true
+	copyFile(filepath.Join("testdata", "GeoLite2-Country-Test.mmdb"), geoDatabase)
+
+	r := reporter.NewMock(t)
+	c, err := New(r, config, Dependencies{Daemon: daemon.NewMock(t), HTTP: http.NewMock(t, r)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+
+	// The geo database is loaded, but not the ASN one
+	if got := c.LookupCountry(netip.MustParseAddr("::ffff:2.125.160.216")); got != "GB" {
+		t.Errorf("LookupCountry() == %q, expected GB", got)
+	}
+	if got := c.LookupASN(netip.MustParseAddr("::ffff:1.0.0.1")); got != 0 {
+		t.Errorf("LookupASN() == %d, expected 0", got)
+	}
+
+	// The ASN database appears
+	copyFile(filepath.Join("testdata", "GeoLite2-ASN-Test.mmdb"), filepath.Join(dir, "tmp.mmdb"))
+	os.Rename(filepath.Join(dir, "tmp.mmdb"), asnDatabase)
+	time.Sleep(20 * time.Millisecond)
+	if got := c.LookupASN(netip.MustParseAddr("::ffff:1.0.0.1")); got != 15169 {
+		t.Errorf("LookupASN() == %d, expected 15169", got)
+	}
+	if got := c.LookupCountry(netip.MustParseAddr("::ffff:2.125.160.216")); got != "GB" {
+		t.Errorf("LookupCountry() == %q, expected GB", got)
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_geoip_", "db_refresh_", "generation", "reload_")
+	expectedMetrics := map[string]string{
+		fmt.Sprintf(`db_refresh_total{database="asn",path="%s"}`, asnDatabase): "1",
+		fmt.Sprintf(`db_refresh_total{database="geo",path="%s"}`, geoDatabase): "1",
+		`generation`:          "2",
+		`reload_errors_total`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
@@ -13,6 +13,7 @@ import (
 
 	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/http"
 	"akvorado/common/reporter"
 )
 
@@ -25,9 +26,12 @@ func NewMock(t *testing.T, r *reporter.Reporter) *Component {
 	t.Helper()
 	config := DefaultConfiguration()
 	_, src, _, _ := runtime.Caller(0)
-	config.GeoDatabase = filepath.Join(path.Dir(src), "testdata", "GeoLite2-Country-Test.mmdb")
-	config.ASNDatabase = filepath.Join(path.Dir(src), "testdata", "GeoLite2-ASN-Test.mmdb")
-	c, err := New(r, config, Dependencies{Daemon: daemon.NewMock(t)})
+	config.GeoDatabase = []string{filepath.Join(path.Dir(src), "testdata", "GeoLite2-Country-Test.mmdb")}
+	config.ASNDatabase = []string{filepath.Join(path.Dir(src), "testdata", "GeoLite2-ASN-Test.mmdb")}
+	c, err := New(r, config, Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   http.NewMock(t, r),
+	})
 	if err != nil {
 		t.Fatalf("New() error:\n%+s", err)
 	}
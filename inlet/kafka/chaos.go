@@ -0,0 +1,14 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+//go:build !release
+
+package kafka
+
+// ChaosReconnect forcibly drops the current Kafka producer and creates a new
+// one, as if the connection to the brokers was lost. Messages buffered in the
+// previous producer are flushed or counted as failed. This is only available
+// in non-release builds to test failover behavior.
+func (c *Component) ChaosReconnect() error {
+	return c.startProducer()
+}
@@ -15,17 +15,32 @@ import (
 type Configuration struct {
 	kafka.Configuration `mapstructure:",squash" yaml:"-,inline"`
 	// FlushInterval tells how often to flush pending data to Kafka.
-	FlushInterval time.Duration `validate:"min=1s"`
+	FlushInterval time.Duration `validate:"min=1s" doc:"How often to flush pending data to Kafka"`
 	// FlushBytes tells to flush when there are many bytes to write
-	FlushBytes int `validate:"min=1000"`
+	FlushBytes int `validate:"min=1000" doc:"Number of pending bytes triggering a flush"`
 	// MaxMessageBytes is the maximum permitted size of a message.
 	// Should be set equal or smaller than broker's
 	// `message.max.bytes`.
-	MaxMessageBytes int
+	MaxMessageBytes int `doc:"Maximum size of a message (should not exceed the broker message.max.bytes)"`
 	// CompressionCodec defines the compression to use.
-	CompressionCodec CompressionCodec
+	CompressionCodec CompressionCodec `doc:"Compression codec (none, gzip, snappy, lz4, or zstd)"`
 	// QueueSize defines the size of the channel used to send to Kafka.
-	QueueSize int `validate:"min=0"`
+	QueueSize int `validate:"min=0" doc:"Size of the queue of messages to send to Kafka"`
+	// Spool defines a disk spool to keep messages while Kafka is unavailable.
+	Spool SpoolConfiguration `doc:"Disk spool for messages while Kafka is unavailable"`
+}
+
+// SpoolConfiguration describes the disk spool keeping messages which cannot
+// be sent to Kafka.
+type SpoolConfiguration struct {
+	// Directory is where messages are spooled. The spool is disabled when
+	// empty.
+	Directory string `doc:"Directory to spool messages while Kafka is unavailable (disabled when empty)"`
+	// MaxBytes is the maximum size of the spool.
+	MaxBytes int64 `validate:"min=1000" doc:"Maximum size of the spool in bytes"`
+	// DrainInterval tells how often to check if spooled messages can be
+	// sent again.
+	DrainInterval time.Duration `validate:"min=100ms" doc:"How often to try sending spooled messages again"`
 }
 
 // DefaultConfiguration represents the default configuration for the Kafka exporter.
@@ -37,6 +52,10 @@ func DefaultConfiguration() Configuration {
 		MaxMessageBytes:  1000000,
 		CompressionCodec: CompressionCodec(sarama.CompressionNone),
 		QueueSize:        32,
+		Spool: SpoolConfiguration{
+			MaxBytes:      1 << 30,
+			DrainInterval: 5 * time.Second,
+		},
 	}
 }
 
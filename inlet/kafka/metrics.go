@@ -15,9 +15,14 @@ import (
 type metrics struct {
 	c *Component
 
-	messagesSent *reporter.CounterVec
-	bytesSent    *reporter.CounterVec
-	errors       *reporter.CounterVec
+	messagesSent   *reporter.CounterVec
+	bytesSent      *reporter.CounterVec
+	messagesFailed *reporter.CounterVec
+	errors         *reporter.CounterVec
+
+	spoolWritten reporter.Counter
+	spoolRead    reporter.Counter
+	spoolDropped reporter.Counter
 
 	kafkaIncomingByteRate  *reporter.MetricDesc
 	kafkaOutgoingByteRate  *reporter.MetricDesc
@@ -50,6 +55,13 @@ func (c *Component) initMetrics() {
 		},
 		[]string{"exporter"},
 	)
+	c.metrics.messagesFailed = c.r.CounterVec(
+		reporter.CounterOpts{
+			Name: "failed_messages_total",
+			Help: "Number of messages from a given exporter that could not be sent.",
+		},
+		[]string{"exporter"},
+	)
 	c.metrics.errors = c.r.CounterVec(
 		reporter.CounterOpts{
 			Name: "errors_total",
@@ -58,6 +70,47 @@ func (c *Component) initMetrics() {
 		[]string{"error"},
 	)
 
+	if c.spool != nil {
+		c.metrics.spoolWritten = c.r.Counter(
+			reporter.CounterOpts{
+				Name: "spool_written_messages_total",
+				Help: "Number of messages written to the spool.",
+			},
+		)
+		c.metrics.spoolRead = c.r.Counter(
+			reporter.CounterOpts{
+				Name: "spool_read_messages_total",
+				Help: "Number of messages read from the spool and sent to Kafka.",
+			},
+		)
+		c.metrics.spoolDropped = c.r.Counter(
+			reporter.CounterOpts{
+				Name: "spool_dropped_messages_total",
+				Help: "Number of messages which could not be written to the spool.",
+			},
+		)
+		c.r.GaugeFunc(
+			reporter.GaugeOpts{
+				Name: "spool_messages",
+				Help: "Number of messages in the spool.",
+			},
+			func() float64 {
+				messages, _ := c.spool.depth()
+				return float64(messages)
+			},
+		)
+		c.r.GaugeFunc(
+			reporter.GaugeOpts{
+				Name: "spool_bytes",
+				Help: "Size of the spool in bytes.",
+			},
+			func() float64 {
+				_, bytes := c.spool.depth()
+				return float64(bytes)
+			},
+		)
+	}
+
 	c.metrics.kafkaIncomingByteRate = c.r.MetricDesc(
 		"brokers_incoming_byte_rate",
 		"Bytes/second read off a given broker.",
@@ -9,17 +9,24 @@ import (
 	"fmt"
 	"math/rand"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
 	"gopkg.in/tomb.v2"
 
 	"akvorado/common/daemon"
+	"akvorado/common/discovery"
 	"akvorado/common/kafka"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
 )
 
+// SchemaHeader is the name of the Kafka header carrying the version of
+// the protobuf schema used to encode a message.
+const SchemaHeader = "akvorado-schema"
+
 // Component represents the Kafka exporter.
 type Component struct {
 	r      *reporter.Reporter
@@ -28,10 +35,19 @@ type Component struct {
 	config Configuration
 
 	kafkaTopic          string
+	kafkaHeaders        []sarama.RecordHeader
 	kafkaConfig         *sarama.Config
+	kafkaDiscovery      *discovery.Resolver
 	kafkaProducer       sarama.AsyncProducer
+	kafkaSenders        *sync.WaitGroup // goroutines sending to kafkaProducer
+	kafkaProducerLock   sync.RWMutex
 	createKafkaProducer func() (sarama.AsyncProducer, error)
+	producerDrained     func(sarama.AsyncProducer) // for tests, may be nil
 	metrics             metrics
+
+	// Disk spool for messages which cannot be sent (may be nil)
+	spool       *spool
+	lastFailure atomic.Int64 // in nanoseconds since epoch
 }
 
 // Dependencies define the dependencies of the Kafka exporter.
@@ -50,12 +66,16 @@ func New(reporter *reporter.Reporter, configuration Configuration, dependencies
 	kafkaConfig.Metadata.AllowAutoTopicCreation = true
 	kafkaConfig.Producer.MaxMessageBytes = configuration.MaxMessageBytes
 	kafkaConfig.Producer.Compression = sarama.CompressionCodec(configuration.CompressionCodec)
-	kafkaConfig.Producer.Return.Successes = false
+	kafkaConfig.Producer.Return.Successes = true
 	kafkaConfig.Producer.Return.Errors = true
 	kafkaConfig.Producer.Flush.Bytes = configuration.FlushBytes
 	kafkaConfig.Producer.Flush.Frequency = configuration.FlushInterval
 	kafkaConfig.Producer.Partitioner = sarama.NewHashPartitioner
 	kafkaConfig.ChannelBufferSize = configuration.QueueSize / 2
+	kafkaDiscovery, err := kafka.NewResolver(reporter, configuration.Configuration, kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
 	if err := kafkaConfig.Validate(); err != nil {
 		return nil, fmt.Errorf("cannot validate Kafka configuration: %w", err)
 	}
@@ -65,12 +85,23 @@ func New(reporter *reporter.Reporter, configuration Configuration, dependencies
 		d:      &dependencies,
 		config: configuration,
 
-		kafkaConfig: kafkaConfig,
-		kafkaTopic:  fmt.Sprintf("%s-%s", configuration.Topic, dependencies.Schema.ProtobufMessageHash()),
+		kafkaConfig:    kafkaConfig,
+		kafkaDiscovery: kafkaDiscovery,
+		kafkaTopic:     fmt.Sprintf("%s-%s", configuration.Topic, dependencies.Schema.ProtobufMessageHash()),
+		kafkaHeaders: []sarama.RecordHeader{{
+			Key:   []byte(SchemaHeader),
+			Value: []byte(dependencies.Schema.ProtobufMessageHash()),
+		}},
+	}
+	if configuration.Spool.Directory != "" {
+		c.spool, err = newSpool(configuration.Spool.Directory, configuration.Spool.MaxBytes)
+		if err != nil {
+			return nil, err
+		}
 	}
 	c.initMetrics()
 	c.createKafkaProducer = func() (sarama.AsyncProducer, error) {
-		return sarama.NewAsyncProducer(c.config.Brokers, c.kafkaConfig)
+		return sarama.NewAsyncProducer(c.kafkaDiscovery.Addresses(), c.kafkaConfig)
 	}
 	c.d.Daemon.Track(&c.t, "inlet/kafka")
 	return &c, nil
@@ -80,8 +111,23 @@ func New(reporter *reporter.Reporter, configuration Configuration, dependencies
 func (c *Component) Start() error {
 	c.r.Info().Msg("starting Kafka component")
 	kafka.GlobalKafkaLogger.Register(c.r)
+	if err := c.kafkaDiscovery.Start(); err != nil {
+		return err
+	}
+	if err := c.startProducer(); err != nil {
+		c.kafkaDiscovery.Stop()
+		return err
+	}
+	if c.spool != nil {
+		c.t.Go(c.drainSpool)
+	}
+	return nil
+}
 
-	// Create producer
+// startProducer creates a new Kafka producer and replaces the current one, if
+// any. Messages still buffered in the previous producer are either flushed or
+// reported as failed.
+func (c *Component) startProducer() error {
 	kafkaProducer, err := c.createKafkaProducer()
 	if err != nil {
 		c.r.Err(err).
@@ -89,19 +135,59 @@ func (c *Component) Start() error {
 			Msg("unable to create async producer")
 		return fmt.Errorf("unable to create Kafka async producer: %w", err)
 	}
+	c.kafkaProducerLock.Lock()
+	previousProducer := c.kafkaProducer
+	previousSenders := c.kafkaSenders
 	c.kafkaProducer = kafkaProducer
+	c.kafkaSenders = &sync.WaitGroup{}
+	c.kafkaProducerLock.Unlock()
+	if previousProducer != nil {
+		// Messages cannot be sent once the producer is closed. Wait for
+		// the pending ones without blocking the new producer.
+		go func() {
+			previousSenders.Wait()
+			previousProducer.AsyncClose()
+		}()
+	}
 
-	// Main loop
+	// Completion handling loop. Once a message is sent or failed, its
+	// buffer is recycled.
 	c.t.Go(func() error {
-		defer kafkaProducer.Close()
-		defer c.kafkaConfig.MetricRegistry.UnregisterAll()
 		errLogger := c.r.Sample(reporter.BurstSampler(10*time.Second, 3))
-		for {
+		successes := kafkaProducer.Successes()
+		errors := kafkaProducer.Errors()
+		for successes != nil || errors != nil {
 			select {
 			case <-c.t.Dying():
 				c.r.Debug().Msg("stop error logger")
+				if successes != nil {
+					go func() {
+						for msg := range successes {
+							releaseMessage(msg)
+						}
+					}()
+				}
+				// A replaced producer was already closed
+				c.kafkaProducerLock.RLock()
+				current := c.kafkaProducer == kafkaProducer
+				c.kafkaProducerLock.RUnlock()
+				if current {
+					kafkaProducer.Close()
+				}
 				return nil
-			case msg := <-kafkaProducer.Errors():
+			case msg, ok := <-successes:
+				if !ok {
+					// Producer was replaced
+					successes = nil
+					continue
+				}
+				releaseMessage(msg)
+			case msg, ok := <-errors:
+				if !ok {
+					// Producer was replaced
+					errors = nil
+					continue
+				}
 				if msg != nil {
 					c.metrics.errors.WithLabelValues(msg.Error()).Inc()
 					errLogger.Err(msg.Err).
@@ -109,21 +195,65 @@ func (c *Component) Start() error {
 						Int64("offset", msg.Msg.Offset).
 						Int32("partition", msg.Msg.Partition).
 						Msg("Kafka producer error")
+					if c.spool != nil && spoolable(msg.Err) {
+						c.lastFailure.Store(time.Now().UnixNano())
+						c.spoolMessage(msg.Msg)
+						continue
+					}
+					if exporter, ok := msg.Msg.Metadata.(string); ok {
+						c.metrics.messagesFailed.WithLabelValues(exporter).Inc()
+					}
+					releaseMessage(msg.Msg)
 				}
 			}
 		}
+		// The producer was replaced and all its messages were handled
+		if c.producerDrained != nil {
+			c.producerDrained(kafkaProducer)
+		}
+		return nil
 	})
 	return nil
 }
 
+// acquireProducer returns the current Kafka producer and a function to call
+// once done sending to it. The lock is not held while sending, so a stalled
+// producer does not prevent its replacement.
+func (c *Component) acquireProducer() (sarama.AsyncProducer, func()) {
+	c.kafkaProducerLock.RLock()
+	defer c.kafkaProducerLock.RUnlock()
+	senders := c.kafkaSenders
+	senders.Add(1)
+	return c.kafkaProducer, senders.Done
+}
+
+// releaseMessage recycles the payload of a message sent to Kafka.
+func releaseMessage(msg *sarama.ProducerMessage) {
+	if msg == nil {
+		return
+	}
+	if payload, ok := msg.Value.(sarama.ByteEncoder); ok {
+		schema.ReleaseProtobuf(payload)
+	}
+}
+
 // Stop stops the Kafka component
 func (c *Component) Stop() error {
 	defer func() {
+		c.kafkaConfig.MetricRegistry.UnregisterAll()
 		kafka.GlobalKafkaLogger.Unregister()
 		c.r.Info().Msg("Kafka component stopped")
 	}()
 	c.r.Info().Msg("stopping Kafka component")
 	c.t.Kill(nil)
+	defer c.kafkaDiscovery.Stop()
+	if c.spool != nil {
+		defer func() {
+			if err := c.spool.close(); err != nil {
+				c.r.Err(err).Msg("cannot close spool")
+			}
+		}()
+	}
 	return c.t.Wait()
 }
 
@@ -133,9 +263,23 @@ func (c *Component) Send(exporter string, payload []byte) {
 	c.metrics.messagesSent.WithLabelValues(exporter).Inc()
 	key := make([]byte, 4)
 	binary.BigEndian.PutUint32(key, rand.Uint32())
-	c.kafkaProducer.Input() <- &sarama.ProducerMessage{
-		Topic: c.kafkaTopic,
-		Key:   sarama.ByteEncoder(key),
-		Value: sarama.ByteEncoder(payload),
+	msg := &sarama.ProducerMessage{
+		Topic:    c.kafkaTopic,
+		Key:      sarama.ByteEncoder(key),
+		Value:    sarama.ByteEncoder(payload),
+		Headers:  c.kafkaHeaders,
+		Metadata: exporter,
+	}
+	producer, release := c.acquireProducer()
+	defer release()
+	if c.spool == nil {
+		producer.Input() <- msg
+		return
+	}
+	// With a spool, do not wait for the producer when its queue is full.
+	select {
+	case producer.Input() <- msg:
+	default:
+		c.spoolMessage(msg)
 	}
 }
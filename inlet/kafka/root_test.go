@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
 	gometrics "github.com/rcrowley/go-metrics"
 
 	"akvorado/common/daemon"
@@ -27,10 +28,15 @@ func TestKafka(t *testing.T) {
 	mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(got *sarama.ProducerMessage) error {
 		defer close(received)
 		expected := sarama.ProducerMessage{
-			Topic:     fmt.Sprintf("flows-%s", c.d.Schema.ProtobufMessageHash()),
-			Key:       got.Key,
-			Value:     sarama.ByteEncoder("hello world!"),
+			Topic: fmt.Sprintf("flows-%s", c.d.Schema.ProtobufMessageHash()),
+			Key:   got.Key,
+			Value: sarama.ByteEncoder("hello world!"),
+			Headers: []sarama.RecordHeader{{
+				Key:   []byte("akvorado-schema"),
+				Value: []byte(c.d.Schema.ProtobufMessageHash()),
+			}},
 			Partition: got.Partition,
+			Metadata:  "127.0.0.1",
 		}
 		if diff := helpers.Diff(got, expected); diff != "" {
 			t.Fatalf("Send() (-got, +want):\n%s", diff)
@@ -53,13 +59,168 @@ func TestKafka(t *testing.T) {
 	expectedMetrics := map[string]string{
 		`sent_bytes_total{exporter="127.0.0.1"}`: "26",
 		fmt.Sprintf(`errors_total{error="kafka: Failed to produce message to topic flows-%s: noooo"}`, c.d.Schema.ProtobufMessageHash()): "1",
-		`sent_messages_total{exporter="127.0.0.1"}`: "2",
+		`sent_messages_total{exporter="127.0.0.1"}`:   "2",
+		`failed_messages_total{exporter="127.0.0.1"}`: "1",
 	}
 	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
 		t.Fatalf("Metrics (-got, +want):\n%s", diff)
 	}
 }
 
+func TestKafkaReconnect(t *testing.T) {
+	r := reporter.NewMock(t)
+	c, mockProducer := NewMock(t, r, DefaultConfiguration())
+
+	// Continuously send flows: the first ones are delivered, then the broker
+	// goes away, then we reconnect and flows are delivered again.
+	for i := 0; i < 10; i++ {
+		mockProducer.ExpectInputAndSucceed()
+	}
+	for i := 0; i < 5; i++ {
+		mockProducer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	}
+	for i := 0; i < 15; i++ {
+		c.Send("127.0.0.1", []byte("hello world!"))
+	}
+
+	drained := make(chan sarama.AsyncProducer, 1)
+	c.producerDrained = func(p sarama.AsyncProducer) {
+		drained <- p
+	}
+	var newProducer *mocks.AsyncProducer
+	c.createKafkaProducer = func() (sarama.AsyncProducer, error) {
+		newProducer = mocks.NewAsyncProducer(t, c.kafkaConfig)
+		for i := 0; i < 10; i++ {
+			newProducer.ExpectInputAndSucceed()
+		}
+		return newProducer, nil
+	}
+	if err := c.ChaosReconnect(); err != nil {
+		t.Fatalf("ChaosReconnect() error:\n%+v", err)
+	}
+	for i := 0; i < 10; i++ {
+		c.Send("127.0.0.2", []byte("hello world!"))
+	}
+
+	select {
+	case p := <-drained:
+		if p != sarama.AsyncProducer(mockProducer) {
+			t.Fatal("drained producer is not the previous one")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("previous producer not drained")
+	}
+	gotMetrics := r.GetMetrics("akvorado_inlet_kafka_", "sent_messages_", "failed_messages_")
+	expectedMetrics := map[string]string{
+		`sent_messages_total{exporter="127.0.0.1"}`:   "15",
+		`sent_messages_total{exporter="127.0.0.2"}`:   "10",
+		`failed_messages_total{exporter="127.0.0.1"}`: "5",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+// stalledProducer is a Kafka producer whose input is not read until
+// released.
+type stalledProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+	closed    chan struct{}
+}
+
+func newStalledProducer() *stalledProducer {
+	return &stalledProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+		closed:    make(chan struct{}),
+	}
+}
+
+func (p *stalledProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *stalledProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *stalledProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+func (p *stalledProducer) Close() error {
+	p.AsyncClose()
+	return nil
+}
+
+func (p *stalledProducer) AsyncClose() {
+	close(p.closed)
+	close(p.successes)
+	close(p.errors)
+}
+
+func TestKafkaReconnectWhileStalled(t *testing.T) {
+	r := reporter.NewMock(t)
+	c, err := New(r, DefaultConfiguration(), Dependencies{Daemon: daemon.NewMock(t), Schema: schema.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	stalled := newStalledProducer()
+	c.createKafkaProducer = func() (sarama.AsyncProducer, error) {
+		return stalled, nil
+	}
+	helpers.StartStop(t, c)
+
+	// This one blocks as the producer does not read its input
+	sent := make(chan bool)
+	go func() {
+		c.Send("127.0.0.1", []byte("hello world!"))
+		close(sent)
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// Reconnecting should not wait for the stalled producer
+	var newProducer *mocks.AsyncProducer
+	c.createKafkaProducer = func() (sarama.AsyncProducer, error) {
+		newProducer = mocks.NewAsyncProducer(t, c.kafkaConfig)
+		newProducer.ExpectInputAndSucceed()
+		return newProducer, nil
+	}
+	reconnected := make(chan error)
+	go func() {
+		reconnected <- c.ChaosReconnect()
+	}()
+	select {
+	case err := <-reconnected:
+		if err != nil {
+			t.Fatalf("ChaosReconnect() error:\n%+v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("ChaosReconnect() blocked by stalled producer")
+	}
+
+	// New messages go to the new producer
+	delivered := make(chan bool)
+	go func() {
+		c.Send("127.0.0.2", []byte("hello world!"))
+		close(delivered)
+	}()
+	select {
+	case <-delivered:
+	case <-time.After(time.Second):
+		t.Fatal("Send() blocked by stalled producer")
+	}
+
+	// The stalled producer is only closed once the pending message is accepted
+	select {
+	case <-stalled.closed:
+		t.Fatal("stalled producer closed with a pending message")
+	case <-time.After(10 * time.Millisecond):
+	}
+	<-stalled.input
+	<-sent
+	select {
+	case <-stalled.closed:
+	case <-time.After(time.Second):
+		t.Fatal("stalled producer not closed")
+	}
+}
+
 func TestKafkaMetrics(t *testing.T) {
 	r := reporter.NewMock(t)
 	c, err := New(r, DefaultConfiguration(), Dependencies{Daemon: daemon.NewMock(t), Schema: schema.NewMock(t)})
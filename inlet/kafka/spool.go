@@ -0,0 +1,339 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// spoolSegmentBytes is the size after which a new segment of the spool is
+// started. Segments are read back in one piece.
+const spoolSegmentBytes = 4 << 20
+
+// spoolSuffix is the suffix of the segments of the spool.
+const spoolSuffix = ".spool"
+
+// errSpoolFull is returned when the spool cannot accept more messages.
+var errSpoolFull = errors.New("spool is full")
+
+// spool is a bounded disk-backed queue of messages. Messages are appended to
+// the current segment. Complete segments are read back in the order they were
+// written and removed once all their messages were handed back to Kafka.
+type spool struct {
+	dir      string
+	maxBytes int64
+
+	lock        sync.Mutex
+	segments    []spoolSegment // complete segments, oldest first
+	writer      *os.File
+	writerSeq   uint64
+	writerBytes int64
+	writerCount int64
+	nextSeq     uint64
+	bytes       int64
+	messages    int64
+}
+
+type spoolSegment struct {
+	seq      uint64
+	bytes    int64
+	messages int64
+}
+
+// spooledMessage is a message read back from the spool.
+type spooledMessage struct {
+	exporter string
+	payload  []byte
+}
+
+// newSpool opens the spool stored in the provided directory. Segments left by
+// a previous run are kept to be read back.
+func newSpool(dir string, maxBytes int64) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create spool directory %q: %w", dir, err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read spool directory %q: %w", dir, err)
+	}
+	s := &spool{dir: dir, maxBytes: maxBytes}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("unable to read spool segment %q: %w", name, err)
+		}
+		segment := spoolSegment{
+			seq:      seq,
+			bytes:    int64(len(content)),
+			messages: int64(len(decodeSpoolSegment(content))),
+		}
+		s.segments = append(s.segments, segment)
+		s.bytes += segment.bytes
+		s.messages += segment.messages
+		if seq >= s.nextSeq {
+			s.nextSeq = seq + 1
+		}
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i].seq < s.segments[j].seq })
+	return s, nil
+}
+
+func (s *spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolSuffix))
+}
+
+// push appends a message to the spool.
+func (s *spool) push(exporter string, payload []byte) error {
+	record := make([]byte, 0, 6+len(exporter)+len(payload))
+	record = binary.BigEndian.AppendUint16(record, uint16(len(exporter)))
+	record = append(record, exporter...)
+	record = binary.BigEndian.AppendUint32(record, uint32(len(payload)))
+	record = append(record, payload...)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.bytes+int64(len(record)) > s.maxBytes {
+		return errSpoolFull
+	}
+	if s.writer == nil {
+		writer, err := os.OpenFile(s.segmentPath(s.nextSeq), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+		if err != nil {
+			return fmt.Errorf("unable to create spool segment: %w", err)
+		}
+		s.writer = writer
+		s.writerSeq = s.nextSeq
+		s.nextSeq++
+	}
+	if _, err := s.writer.Write(record); err != nil {
+		return fmt.Errorf("unable to write to spool segment: %w", err)
+	}
+	s.writerBytes += int64(len(record))
+	s.writerCount++
+	s.bytes += int64(len(record))
+	s.messages++
+	if s.writerBytes >= spoolSegmentBytes {
+		return s.rotate()
+	}
+	return nil
+}
+
+// rotate closes the current segment and makes it available for reading. The
+// lock should be held.
+func (s *spool) rotate() error {
+	if s.writer == nil {
+		return nil
+	}
+	err := s.writer.Close()
+	s.segments = append(s.segments, spoolSegment{
+		seq:      s.writerSeq,
+		bytes:    s.writerBytes,
+		messages: s.writerCount,
+	})
+	s.writer = nil
+	s.writerBytes = 0
+	s.writerCount = 0
+	if err != nil {
+		return fmt.Errorf("unable to close spool segment: %w", err)
+	}
+	return nil
+}
+
+// next returns the sequence number and the messages of the oldest segment of
+// the spool. The segment is kept until removed with remove(). It returns nil
+// messages when the spool is empty.
+func (s *spool) next() (uint64, []spooledMessage, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.segments) == 0 {
+		if err := s.rotate(); err != nil {
+			return 0, nil, err
+		}
+	}
+	if len(s.segments) == 0 {
+		return 0, nil, nil
+	}
+	seq := s.segments[0].seq
+	content, err := os.ReadFile(s.segmentPath(seq))
+	if err != nil {
+		return 0, nil, fmt.Errorf("unable to read spool segment: %w", err)
+	}
+	return seq, decodeSpoolSegment(content), nil
+}
+
+// remove removes the oldest segment from the spool. The provided sequence
+// number should be the one returned by next().
+func (s *spool) remove(seq uint64) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.segments) == 0 || s.segments[0].seq != seq {
+		return fmt.Errorf("spool segment %d is not the oldest one", seq)
+	}
+	segment := s.segments[0]
+	s.segments = s.segments[1:]
+	s.bytes -= segment.bytes
+	s.messages -= segment.messages
+	if err := os.Remove(s.segmentPath(seq)); err != nil {
+		return fmt.Errorf("unable to remove spool segment: %w", err)
+	}
+	return nil
+}
+
+// depth returns the number of messages and the number of bytes in the spool.
+func (s *spool) depth() (int64, int64) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.messages, s.bytes
+}
+
+// close closes the current segment. Messages are kept on disk to be read back
+// on next start.
+func (s *spool) close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.rotate()
+}
+
+// decodeSpoolSegment decodes the messages of a segment. A truncated message
+// at the end of the segment is ignored.
+func decodeSpoolSegment(content []byte) []spooledMessage {
+	messages := []spooledMessage{}
+	for len(content) >= 2 {
+		exporterLength := int(binary.BigEndian.Uint16(content))
+		if len(content) < 2+exporterLength+4 {
+			break
+		}
+		exporter := string(content[2 : 2+exporterLength])
+		content = content[2+exporterLength:]
+		payloadLength := int(binary.BigEndian.Uint32(content))
+		if len(content) < 4+payloadLength {
+			break
+		}
+		// Payloads are copied as they are recycled once sent.
+		messages = append(messages, spooledMessage{
+			exporter: exporter,
+			payload:  append([]byte{}, content[4:4+payloadLength]...),
+		})
+		content = content[4+payloadLength:]
+	}
+	return messages
+}
+
+// spoolable tells if a message failing with the provided error should be
+// spooled. Messages rejected by Kafka for their content would fail again.
+func spoolable(err error) bool {
+	var configErr sarama.ConfigurationError
+	switch {
+	case errors.Is(err, sarama.ErrMessageSizeTooLarge),
+		errors.Is(err, sarama.ErrInvalidMessage),
+		errors.As(err, &configErr):
+		return false
+	}
+	return true
+}
+
+// spoolMessage writes a message which cannot be sent to Kafka to the spool.
+// The message is released.
+func (c *Component) spoolMessage(msg *sarama.ProducerMessage) {
+	defer releaseMessage(msg)
+	exporter, _ := msg.Metadata.(string)
+	payload, _ := msg.Value.(sarama.ByteEncoder)
+	if err := c.spool.push(exporter, payload); err != nil {
+		c.metrics.spoolDropped.Inc()
+		c.metrics.messagesFailed.WithLabelValues(exporter).Inc()
+		if !errors.Is(err, errSpoolFull) {
+			c.r.Err(err).Msg("cannot spool message")
+		}
+		return
+	}
+	c.metrics.spoolWritten.Inc()
+}
+
+// drainSpool sends spooled messages to Kafka again. It waits for Kafka to
+// accept messages during a whole drain interval before doing so. A segment is
+// only removed once all its messages were handed to the producer: on crash,
+// messages may be sent twice but are not lost.
+func (c *Component) drainSpool() error {
+	ticker := time.NewTicker(c.config.Spool.DrainInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.t.Dying():
+			return nil
+		case <-ticker.C:
+		}
+		for time.Since(time.Unix(0, c.lastFailure.Load())) >= c.config.Spool.DrainInterval {
+			seq, messages, err := c.spool.next()
+			if err != nil {
+				c.r.Err(err).Msg("cannot read spool")
+				break
+			}
+			if messages == nil {
+				break
+			}
+			c.r.Debug().Int("messages", len(messages)).Msg("drain spooled messages")
+			for idx, message := range messages {
+				if !c.unspoolMessage(message) {
+					// Keep the remaining messages for later
+					for _, message := range messages[idx:] {
+						if err := c.spool.push(message.exporter, message.payload); err != nil {
+							c.metrics.spoolDropped.Inc()
+							c.metrics.messagesFailed.WithLabelValues(message.exporter).Inc()
+						}
+					}
+					if err := c.spool.remove(seq); err != nil {
+						c.r.Err(err).Msg("cannot remove spool segment")
+					}
+					return nil
+				}
+			}
+			if err := c.spool.remove(seq); err != nil {
+				c.r.Err(err).Msg("cannot remove spool segment")
+				break
+			}
+		}
+	}
+}
+
+// unspoolMessage sends a spooled message to Kafka. It returns false if the
+// component is stopping.
+func (c *Component) unspoolMessage(message spooledMessage) bool {
+	key := make([]byte, 4)
+	binary.BigEndian.PutUint32(key, rand.Uint32())
+	msg := &sarama.ProducerMessage{
+		Topic:    c.kafkaTopic,
+		Key:      sarama.ByteEncoder(key),
+		Value:    sarama.ByteEncoder(message.payload),
+		Headers:  c.kafkaHeaders,
+		Metadata: message.exporter,
+	}
+	producer, release := c.acquireProducer()
+	defer release()
+	select {
+	case producer.Input() <- msg:
+		c.metrics.spoolRead.Inc()
+		return true
+	case <-c.t.Dying():
+		return false
+	}
+}
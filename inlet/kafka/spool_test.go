@@ -0,0 +1,150 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package kafka
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	s, err := newSpool(dir, 130)
+	if err != nil {
+		t.Fatalf("newSpool() error:\n%+v", err)
+	}
+	if _, got, err := s.next(); err != nil || got != nil {
+		t.Fatalf("next() on empty spool got %v, %v", got, err)
+	}
+
+	// Each record uses 6 bytes + the exporter and the payload
+	for i := 0; i < 5; i++ {
+		if err := s.push("127.0.0.1", []byte(fmt.Sprintf("hello %d", i))); err != nil {
+			t.Fatalf("push() error:\n%+v", err)
+		}
+	}
+	if err := s.push("127.0.0.1", []byte("hello 5")); err != errSpoolFull {
+		t.Fatalf("push() on full spool error:\n%+v", err)
+	}
+	if messages, bytes := s.depth(); messages != 5 || bytes != 110 {
+		t.Fatalf("depth() got %d, %d", messages, bytes)
+	}
+
+	// Reopen the spool, messages are still here
+	if err := s.close(); err != nil {
+		t.Fatalf("close() error:\n%+v", err)
+	}
+	s, err = newSpool(dir, 130)
+	if err != nil {
+		t.Fatalf("newSpool() error:\n%+v", err)
+	}
+	if err := s.push("127.0.0.2", []byte("bye")); err != nil {
+		t.Fatalf("push() error:\n%+v", err)
+	}
+	if messages, _ := s.depth(); messages != 6 {
+		t.Fatalf("depth() got %d messages", messages)
+	}
+
+	// Segments are kept until removed
+	if _, messages, err := s.next(); err != nil || len(messages) != 5 {
+		t.Fatalf("next() got %v, %v", messages, err)
+	}
+	if err := s.close(); err != nil {
+		t.Fatalf("close() error:\n%+v", err)
+	}
+	s, err = newSpool(dir, 130)
+	if err != nil {
+		t.Fatalf("newSpool() error:\n%+v", err)
+	}
+	if messages, _ := s.depth(); messages != 6 {
+		t.Fatalf("depth() got %d messages", messages)
+	}
+
+	got := []spooledMessage{}
+	for {
+		seq, messages, err := s.next()
+		if err != nil {
+			t.Fatalf("next() error:\n%+v", err)
+		}
+		if messages == nil {
+			break
+		}
+		got = append(got, messages...)
+		if err := s.remove(seq); err != nil {
+			t.Fatalf("remove() error:\n%+v", err)
+		}
+	}
+	expected := []spooledMessage{
+		{"127.0.0.1", []byte("hello 0")},
+		{"127.0.0.1", []byte("hello 1")},
+		{"127.0.0.1", []byte("hello 2")},
+		{"127.0.0.1", []byte("hello 3")},
+		{"127.0.0.1", []byte("hello 4")},
+		{"127.0.0.2", []byte("bye")},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("next() (-got, +want):\n%s", diff)
+	}
+	if messages, bytes := s.depth(); messages != 0 || bytes != 0 {
+		t.Fatalf("depth() got %d, %d", messages, bytes)
+	}
+}
+
+func TestKafkaSpool(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration()
+	configuration.Spool.Directory = t.TempDir()
+	configuration.Spool.DrainInterval = 100 * time.Millisecond
+	c, mockProducer := NewMock(t, r, configuration)
+
+	// Kafka is unavailable, messages are spooled
+	for i := 0; i < 3; i++ {
+		mockProducer.ExpectInputAndFail(sarama.ErrOutOfBrokers)
+	}
+	// Kafka is back, spooled messages are sent
+	received := make(chan string, 3)
+	for i := 0; i < 3; i++ {
+		mockProducer.ExpectInputWithMessageCheckerFunctionAndSucceed(func(got *sarama.ProducerMessage) error {
+			payload, _ := got.Value.Encode()
+			received <- string(payload)
+			return nil
+		})
+	}
+	for i := 0; i < 3; i++ {
+		c.Send("127.0.0.1", []byte(fmt.Sprintf("hello %d", i)))
+	}
+
+	got := []string{}
+	for i := 0; i < 3; i++ {
+		select {
+		case payload := <-received:
+			got = append(got, payload)
+		case <-time.After(time.Second):
+			t.Fatal("spooled message not received")
+		}
+	}
+	if diff := helpers.Diff(got, []string{"hello 0", "hello 1", "hello 2"}); diff != "" {
+		t.Fatalf("spooled messages (-got, +want):\n%s", diff)
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	gotMetrics := r.GetMetrics("akvorado_inlet_kafka_", "spool_", "sent_messages_", "failed_messages_")
+	expectedMetrics := map[string]string{
+		`sent_messages_total{exporter="127.0.0.1"}`: "3",
+		`spool_written_messages_total`:              "3",
+		`spool_read_messages_total`:                 "3",
+		`spool_dropped_messages_total`:              "0",
+		`spool_messages`:                            "0",
+		`spool_bytes`:                               "0",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
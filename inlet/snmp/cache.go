@@ -29,6 +29,12 @@ type Interface struct {
 	Name        string
 	Description string
 	Speed       uint
+	Tags        []string
+
+	// ExporterTenant and ExporterTags describe the exporter owning the
+	// interface. Like Tags, they are only provided by NetBox.
+	ExporterTenant string
+	ExporterTags   []string
 }
 
 type key struct {
@@ -90,6 +96,11 @@ func (sc *snmpCache) Put(t time.Time, ip netip.Addr, exporterName string, index
 	})
 }
 
+// Items returns all the entries in the cache.
+func (sc *snmpCache) Items() map[key]value {
+	return sc.cache.Items()
+}
+
 // Expire expire entries whose last access is before the provided time
 func (sc *snmpCache) Expire(before time.Time) int {
 	expired := sc.cache.DeleteLastAccessedBefore(before)
@@ -97,6 +108,14 @@ func (sc *snmpCache) Expire(before time.Time) int {
 	return expired
 }
 
+// Flush removes the entries of the provided exporter, or all the entries
+// when the exporter address is not valid.
+func (sc *snmpCache) Flush(ip netip.Addr) int {
+	return sc.cache.DeleteFunc(func(k key) bool {
+		return !ip.IsValid() || k.IP == ip
+	})
+}
+
 // NeedUpdates returns a map of interface entries that would need to
 // be updated. It relies on last update.
 func (sc *snmpCache) NeedUpdates(before time.Time) map[netip.Addr]map[uint]Interface {
@@ -222,6 +222,28 @@ func TestNeedUpdates(t *testing.T) {
 	}
 }
 
+func TestFlush(t *testing.T) {
+	_, sc := setupTestCache(t)
+	now := time.Now()
+	sc.Put(now, netip.MustParseAddr("::ffff:127.0.0.1"), "localhost", 676, Interface{Name: "Gi0/0/0/1", Description: "Transit"})
+	sc.Put(now, netip.MustParseAddr("::ffff:127.0.0.1"), "localhost", 678, Interface{Name: "Gi0/0/0/2", Description: "Peering"})
+	sc.Put(now, netip.MustParseAddr("::ffff:127.0.0.2"), "localhost2", 678, Interface{Name: "Gi0/0/0/1", Description: "IX"})
+
+	if count := sc.Flush(netip.MustParseAddr("::ffff:127.0.0.1")); count != 2 {
+		t.Errorf("Flush(127.0.0.1): got %d, expected %d", count, 2)
+	}
+	expectCacheLookup(t, sc, "127.0.0.1", 676, answer{NOk: true})
+	expectCacheLookup(t, sc, "127.0.0.2", 678, answer{
+		ExporterName: "localhost2",
+		Interface:    Interface{Name: "Gi0/0/0/1", Description: "IX"},
+	})
+
+	if count := sc.Flush(netip.Addr{}); count != 1 {
+		t.Errorf("Flush(): got %d, expected %d", count, 1)
+	}
+	expectCacheLookup(t, sc, "127.0.0.2", 678, answer{NOk: true})
+}
+
 func TestLoadNotExist(t *testing.T) {
 	_, sc := setupTestCache(t)
 	err := sc.Load("/i/do/not/exist")
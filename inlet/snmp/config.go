@@ -12,37 +12,114 @@ import (
 
 	"github.com/gosnmp/gosnmp"
 	"github.com/mitchellh/mapstructure"
+	"golang.org/x/time/rate"
 
 	"akvorado/common/helpers"
+	"akvorado/common/helpers/bimap"
 )
 
 // Configuration describes the configuration for the SNMP client
 type Configuration struct {
 	// CacheDuration defines how long to keep cached entries without access
-	CacheDuration time.Duration `validate:"min=1m"`
+	CacheDuration time.Duration `validate:"min=1m" doc:"How long to keep cached entries without access"`
 	// CacheRefresh defines how soon to refresh an existing cached entry
-	CacheRefresh time.Duration `validate:"eq=0|min=1m,eq=0|gtefield=CacheDuration"`
+	CacheRefresh time.Duration `validate:"eq=0|min=1m,eq=0|gtefield=CacheDuration" doc:"How soon to refresh an existing cached entry"`
 	// CacheRefreshInterval defines the interval to check for expiration/refresh
-	CacheCheckInterval time.Duration `validate:"ltefield=CacheRefresh,min=1s"`
+	CacheCheckInterval time.Duration `validate:"ltefield=CacheRefresh,min=1s" doc:"Interval to check for expiration or refresh"`
 	// CachePersist defines a file to store cache and survive restarts
-	CachePersistFile string
+	CachePersistFile string `doc:"File to persist the cache across restarts"`
 	// PollerRetries tell how many time a poller should retry before giving up
-	PollerRetries int `validate:"min=0"`
+	PollerRetries int `validate:"min=0" doc:"Number of retries before giving up"`
 	// PollerTimeout tell how much time a poller should wait for an answer
-	PollerTimeout time.Duration `validate:"min=100ms"`
+	PollerTimeout time.Duration `validate:"min=100ms" doc:"Time to wait for an answer"`
 	// PollerCoalesce tells how many requests can be contained inside a single SNMP PDU
-	PollerCoalesce int `validate:"min=0"`
+	PollerCoalesce int `validate:"min=0" doc:"Maximum number of requests in a single SNMP PDU"`
 	// Workers define the number of workers used to poll SNMP
-	Workers int `validate:"min=1"`
+	Workers int `validate:"min=1" doc:"Number of pollers"`
 
 	// Communities is a mapping from exporter IPs to SNMPv2 communities
-	Communities *helpers.SubnetMap[string]
+	Communities *helpers.SubnetMap[string] `doc:"SNMPv2 communities, as a value or a mapping from subnets"`
 	// SecurityParameters is a mapping from exporter IPs to SNMPv3 security parameters
-	SecurityParameters *helpers.SubnetMap[SecurityParameters] `validate:"omitempty,dive"`
+	SecurityParameters *helpers.SubnetMap[SecurityParameters] `validate:"omitempty,dive" doc:"SNMPv3 security parameters, as a mapping from subnets"`
 	// Agents is a mapping from exporter IPs to SNMP agent IP
-	Agents map[netip.Addr]netip.Addr
+	Agents map[netip.Addr]netip.Addr `doc:"Mapping from exporter IPs to SNMP agent IPs"`
 	// Ports is a mapping from agent IPs to SNMP port
-	Ports *helpers.SubnetMap[uint16]
+	Ports *helpers.SubnetMap[uint16] `doc:"SNMP port, as a value or a mapping from subnets"`
+
+	// NetBox describes how to fetch interface metadata from NetBox
+	NetBox NetBoxConfiguration `doc:"NetBox settings to fetch interface metadata instead of or in addition to SNMP"`
+	// DNS describes how to name exporters with reverse DNS
+	DNS DNSConfiguration `doc:"Reverse DNS settings to name exporters when other providers fail"`
+}
+
+// NetBoxConfiguration describes how to fetch interface metadata from NetBox.
+type NetBoxConfiguration struct {
+	// URL is the base URL of NetBox. NetBox is not used when empty.
+	URL string `validate:"omitempty,url" doc:"Base URL of NetBox (empty to disable)"`
+	// Token is the API token to use with NetBox.
+	Token string `doc:"API token for NetBox"`
+	// Fallback tells to only query NetBox when SNMP polling fails.
+	Fallback bool `doc:"Only query NetBox when SNMP polling fails"`
+	// IfIndexField is the custom field of NetBox interfaces containing the
+	// interface index.
+	IfIndexField string `validate:"required" doc:"Custom field of NetBox interfaces containing the interface index"`
+	// Timeout tells the maximum time a request to NetBox should take
+	Timeout time.Duration `validate:"min=100ms" doc:"Maximum duration of a request to NetBox"`
+	// RateLimit is the maximum number of requests per second to NetBox.
+	RateLimit rate.Limit `validate:"min=0" doc:"Maximum number of requests per second to NetBox (0 to disable)"`
+}
+
+// DNSConfiguration describes how to name exporters with reverse DNS.
+type DNSConfiguration struct {
+	// Policy tells, for each exporter, when to resolve its name with reverse
+	// DNS.
+	Policy *helpers.SubnetMap[DNSPolicy] `doc:"When to name exporters with reverse DNS (disabled, fallback, only), as a value or a mapping from subnets"`
+	// Timeout tells the maximum time a reverse DNS lookup should take
+	Timeout time.Duration `validate:"min=100ms" doc:"Maximum duration of a reverse DNS lookup"`
+}
+
+// DNSPolicy tells when to name an exporter with reverse DNS.
+type DNSPolicy int
+
+const (
+	// DNSPolicyDisabled never uses reverse DNS.
+	DNSPolicyDisabled DNSPolicy = iota
+	// DNSPolicyFallback uses reverse DNS when the other providers fail.
+	DNSPolicyFallback
+	// DNSPolicyOnly only uses reverse DNS, without querying the other
+	// providers.
+	DNSPolicyOnly
+)
+
+var dnsPolicyMap = bimap.New(map[DNSPolicy]string{
+	DNSPolicyDisabled: "disabled",
+	DNSPolicyFallback: "fallback",
+	DNSPolicyOnly:     "only",
+})
+
+// MarshalText turns a DNS policy to text.
+func (dp DNSPolicy) MarshalText() ([]byte, error) {
+	got, ok := dnsPolicyMap.LoadValue(dp)
+	if ok {
+		return []byte(got), nil
+	}
+	return nil, errors.New("unknown DNS policy")
+}
+
+// String turns a DNS policy to string.
+func (dp DNSPolicy) String() string {
+	got, _ := dnsPolicyMap.LoadValue(dp)
+	return got
+}
+
+// UnmarshalText provides a DNS policy from a string.
+func (dp *DNSPolicy) UnmarshalText(input []byte) error {
+	got, ok := dnsPolicyMap.LoadKey(string(input))
+	if ok {
+		*dp = got
+		return nil
+	}
+	return errors.New("unknown DNS policy")
 }
 
 // SecurityParameters describes SNMPv3 USM security parameters.
@@ -74,6 +151,18 @@ func DefaultConfiguration() Configuration {
 		Ports: helpers.MustNewSubnetMap(map[string]uint16{
 			"::/0": 161,
 		}),
+
+		NetBox: NetBoxConfiguration{
+			IfIndexField: "ifindex",
+			Timeout:      5 * time.Second,
+			RateLimit:    10,
+		},
+		DNS: DNSConfiguration{
+			Policy: helpers.MustNewSubnetMap(map[string]DNSPolicy{
+				"::/0": DNSPolicyDisabled,
+			}),
+			Timeout: time.Second,
+		},
 	}
 }
 
@@ -198,6 +287,7 @@ func init() {
 	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[string]())
 	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[SecurityParameters]())
 	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[uint16]())
+	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[DNSPolicy]())
 	helpers.RegisterSubnetMapValidation[SecurityParameters]()
 	helpers.RegisterSubnetMapValidation[uint16]()
 }
@@ -171,6 +171,41 @@ func TestConfigurationUnmarshallerHook(t *testing.T) {
 					},
 				}),
 			},
+		}, {
+			Description: "DNS policy",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"dns": gin.H{
+						"policy": gin.H{
+							"::/0":           "fallback",
+							"203.0.113.0/24": "only",
+						},
+					},
+				}
+			},
+			Expected: Configuration{
+				Communities: helpers.MustNewSubnetMap(map[string]string{
+					"::/0": "public",
+				}),
+				DNS: DNSConfiguration{
+					Policy: helpers.MustNewSubnetMap(map[string]DNSPolicy{
+						"::/0":                   DNSPolicyFallback,
+						"::ffff:203.0.113.0/120": DNSPolicyOnly,
+					}),
+				},
+			},
+		}, {
+			Description: "invalid DNS policy",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"dns": gin.H{
+						"policy": "sometimes",
+					},
+				}
+			},
+			Error: true,
 		},
 	})
 }
@@ -0,0 +1,109 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package snmp
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/netip"
+	"strings"
+	"time"
+
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+// dnsPoller names exporters using their reverse DNS record. It does not know
+// anything about interfaces and is only useful to keep flows with an exporter
+// name when other pollers fail or cannot be used.
+type dnsPoller struct {
+	r          *reporter.Reporter
+	config     DNSConfiguration
+	lookupAddr func(ctx context.Context, addr string) ([]string, error)
+	errLogger  reporter.Logger
+	put        func(exporterIP netip.Addr, exporterName string, ifIndex uint, iface Interface)
+
+	metrics struct {
+		successes *reporter.CounterVec
+		errors    *reporter.CounterVec
+	}
+}
+
+// newDNSPoller creates a new DNS poller.
+func newDNSPoller(r *reporter.Reporter, config DNSConfiguration, put func(netip.Addr, string, uint, Interface)) *dnsPoller {
+	p := &dnsPoller{
+		r:          r,
+		config:     config,
+		lookupAddr: net.DefaultResolver.LookupAddr,
+		errLogger:  r.Sample(reporter.BurstSampler(10*time.Second, 3)),
+		put:        put,
+	}
+	p.metrics.successes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "dns_success_requests",
+			Help: "Number of exporters named with reverse DNS.",
+		}, []string{"exporter"})
+	p.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "dns_error_requests",
+			Help: "Number of failed reverse DNS lookups.",
+		}, []string{"exporter"})
+	return p
+}
+
+func (p *dnsPoller) Poll(ctx context.Context, exporter, _ netip.Addr, _ uint16, ifIndexes []uint) error {
+	exporterStr := exporter.Unmap().String()
+	ctx, cancel := context.WithTimeout(ctx, p.config.Timeout)
+	defer cancel()
+	names, err := p.lookupAddr(ctx, exporterStr)
+	if err == nil && len(names) == 0 {
+		err = errors.New("no PTR record")
+	}
+	if err != nil {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		p.metrics.errors.WithLabelValues(exporterStr).Inc()
+		p.errLogger.Err(err).Str("exporter", exporterStr).Msg("unable to resolve exporter name")
+		return err
+	}
+	exporterName := strings.TrimSuffix(names[0], ".")
+	for _, ifIndex := range ifIndexes {
+		// Interfaces are put in the cache as negative entries. They are
+		// only polled again with the other pollers on cache refresh.
+		p.put(exporter, exporterName, ifIndex, Interface{})
+	}
+	p.metrics.successes.WithLabelValues(exporterStr).Inc()
+	return nil
+}
+
+// dnsEnabled tells if the reverse DNS is used for at least one exporter.
+func dnsEnabled(policy *helpers.SubnetMap[DNSPolicy]) bool {
+	for _, p := range policy.ToMap() {
+		if p != DNSPolicyDisabled {
+			return true
+		}
+	}
+	return false
+}
+
+// dnsPolicyPoller chooses between the DNS poller and the other pollers
+// depending on the policy configured for the exporter.
+type dnsPolicyPoller struct {
+	policy *helpers.SubnetMap[DNSPolicy]
+	other  poller
+	dns    poller
+}
+
+func (p dnsPolicyPoller) Poll(ctx context.Context, exporter, agent netip.Addr, port uint16, ifIndexes []uint) error {
+	switch p.policy.LookupOrDefault(exporter, DNSPolicyDisabled) {
+	case DNSPolicyOnly:
+		return p.dns.Poll(ctx, exporter, agent, port, ifIndexes)
+	case DNSPolicyFallback:
+		return fallbackPoller{primary: p.other, secondary: p.dns}.Poll(ctx, exporter, agent, port, ifIndexes)
+	default:
+		return p.other.Poll(ctx, exporter, agent, port, ifIndexes)
+	}
+}
@@ -0,0 +1,168 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package snmp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+func fakeLookupAddr(_ context.Context, addr string) ([]string, error) {
+	switch addr {
+	case "192.0.2.1":
+		return []string{"edge1.example.com."}, nil
+	default:
+		return nil, &net.DNSError{Err: "no such host", Name: addr, IsNotFound: true}
+	}
+}
+
+func TestDNSPoller(t *testing.T) {
+	r := reporter.NewMock(t)
+	got := []string{}
+	p := newDNSPoller(r, DefaultConfiguration().DNS, func(exporterIP netip.Addr, exporterName string, ifIndex uint, iface Interface) {
+		got = append(got, fmt.Sprintf("%s %s %d %s",
+			exporterIP.Unmap().String(), exporterName, ifIndex, iface.Name))
+	})
+	p.lookupAddr = fakeLookupAddr
+
+	exporter := netip.MustParseAddr("::ffff:192.0.2.1")
+	if err := p.Poll(context.Background(), exporter, exporter, 161, []uint{641, 642}); err != nil {
+		t.Fatalf("Poll() error:\n%+v", err)
+	}
+	other := netip.MustParseAddr("::ffff:192.0.2.2")
+	if err := p.Poll(context.Background(), other, other, 161, []uint{641}); err == nil {
+		t.Fatal("Poll() no error")
+	}
+
+	expected := []string{
+		`192.0.2.1 edge1.example.com 641 `,
+		`192.0.2.1 edge1.example.com 642 `,
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Poll() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_snmp_dns_")
+	expectedMetrics := map[string]string{
+		`error_requests{exporter="192.0.2.2"}`:   "1",
+		`success_requests{exporter="192.0.2.1"}`: "1",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+func TestDNSFallback(t *testing.T) {
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration()
+	configuration.DNS.Policy = helpers.MustNewSubnetMap(map[string]DNSPolicy{
+		"::/0": DNSPolicyFallback,
+	})
+	c, err := New(r, configuration, Dependencies{Daemon: daemon.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	primary := &failingPoller{}
+	policy, ok := c.poller.(dnsPolicyPoller)
+	if !ok {
+		t.Fatalf("poller is %T, not a DNS policy poller", c.poller)
+	}
+	policy.other = primary
+	policy.dns.(*dnsPoller).lookupAddr = fakeLookupAddr
+	c.poller = policy
+	helpers.StartStop(t, c)
+
+	exporter := netip.MustParseAddr("::ffff:192.0.2.1")
+	var exporterName string
+	var iface Interface
+	for i := 0; ; i++ {
+		exporterName, iface, ok = c.Lookup(time.Now(), exporter, 641)
+		if ok {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Lookup() not found")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exporterName != "edge1.example.com" || iface.Name != "" {
+		t.Errorf("Lookup() = %q, %+v", exporterName, iface)
+	}
+	if primary.calls.Load() == 0 {
+		t.Error("primary poller not called")
+	}
+}
+
+type countingPoller struct {
+	calls atomic.Int32
+}
+
+func (p *countingPoller) Poll(context.Context, netip.Addr, netip.Addr, uint16, []uint) error {
+	p.calls.Add(1)
+	return nil
+}
+
+func TestDNSPolicy(t *testing.T) {
+	cases := []struct {
+		Exporter      string
+		Other         poller
+		ExpectedOther int32
+		ExpectedDNS   int32
+	}{
+		{"::ffff:192.0.2.1", &countingPoller{}, 1, 0},
+		{"::ffff:198.51.100.1", &countingPoller{}, 1, 0},
+		{"::ffff:198.51.100.1", &failingPoller{}, 1, 1},
+		{"::ffff:203.0.113.1", &countingPoller{}, 0, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Exporter, func(t *testing.T) {
+			dns := &countingPoller{}
+			p := dnsPolicyPoller{
+				policy: helpers.MustNewSubnetMap(map[string]DNSPolicy{
+					"::/0":                    DNSPolicyDisabled,
+					"::ffff:198.51.100.0/120": DNSPolicyFallback,
+					"::ffff:203.0.113.0/120":  DNSPolicyOnly,
+				}),
+				other: tc.Other,
+				dns:   dns,
+			}
+			exporter := netip.MustParseAddr(tc.Exporter)
+			p.Poll(context.Background(), exporter, exporter, 161, []uint{641})
+			var otherCalls int32
+			switch other := tc.Other.(type) {
+			case *countingPoller:
+				otherCalls = other.calls.Load()
+			case *failingPoller:
+				otherCalls = other.calls.Load()
+			}
+			if otherCalls != tc.ExpectedOther {
+				t.Errorf("Poll() called other poller %d times, expected %d", otherCalls, tc.ExpectedOther)
+			}
+			if got := dns.calls.Load(); got != tc.ExpectedDNS {
+				t.Errorf("Poll() called DNS poller %d times, expected %d", got, tc.ExpectedDNS)
+			}
+		})
+	}
+}
+
+func TestDNSEnabled(t *testing.T) {
+	if dnsEnabled(DefaultConfiguration().DNS.Policy) {
+		t.Error("dnsEnabled() == true with default configuration")
+	}
+	if !dnsEnabled(helpers.MustNewSubnetMap(map[string]DNSPolicy{
+		"::/0":                   DNSPolicyDisabled,
+		"::ffff:203.0.113.0/120": DNSPolicyOnly,
+	})) {
+		t.Error("dnsEnabled() == false with a subnet using DNS")
+	}
+}
@@ -0,0 +1,256 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package snmp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/time/rate"
+
+	"akvorado/common/reporter"
+)
+
+// netboxPoller fetches interface metadata from NetBox. The exporter is
+// matched using its IP address and interfaces using a custom field
+// containing their index.
+type netboxPoller struct {
+	r         *reporter.Reporter
+	config    NetBoxConfiguration
+	client    *http.Client
+	limiter   *rate.Limiter // nil when requests are not limited
+	errLogger reporter.Logger
+	put       func(exporterIP netip.Addr, exporterName string, ifIndex uint, iface Interface)
+
+	metrics struct {
+		successes *reporter.CounterVec
+		errors    *reporter.CounterVec
+	}
+}
+
+// newNetBoxPoller creates a new NetBox poller.
+func newNetBoxPoller(r *reporter.Reporter, config NetBoxConfiguration, put func(netip.Addr, string, uint, Interface)) *netboxPoller {
+	p := &netboxPoller{
+		r:      r,
+		config: config,
+		client: &http.Client{
+			Timeout: config.Timeout,
+			Transport: &http.Transport{
+				Proxy: http.ProxyFromEnvironment,
+			},
+		},
+		errLogger: r.Sample(reporter.BurstSampler(10*time.Second, 3)),
+		put:       put,
+	}
+	if config.RateLimit > 0 {
+		p.limiter = rate.NewLimiter(config.RateLimit, 1)
+	}
+	p.metrics.successes = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "netbox_success_requests",
+			Help: "Number of successful requests to NetBox.",
+		}, []string{"exporter"})
+	p.metrics.errors = r.CounterVec(
+		reporter.CounterOpts{
+			Name: "netbox_error_requests",
+			Help: "Number of failed requests to NetBox.",
+		}, []string{"exporter", "error"})
+	return p
+}
+
+type netboxIPAddresses struct {
+	Results []struct {
+		AssignedObject *struct {
+			Device *struct {
+				ID   int    `json:"id"`
+				Name string `json:"name"`
+			} `json:"device"`
+		} `json:"assigned_object"`
+	} `json:"results"`
+}
+
+type netboxTags []struct {
+	Slug string `json:"slug"`
+}
+
+type netboxDevice struct {
+	Tenant *struct {
+		Name string `json:"name"`
+	} `json:"tenant"`
+	Tags netboxTags `json:"tags"`
+}
+
+type netboxInterfaces struct {
+	Next    string `json:"next"`
+	Results []struct {
+		Name         string         `json:"name"`
+		Description  string         `json:"description"`
+		Speed        uint           `json:"speed"` // kbps
+		Tags         netboxTags     `json:"tags"`
+		CustomFields map[string]any `json:"custom_fields"`
+	} `json:"results"`
+}
+
+// slugs returns the slugs of the tags, or nil if there is no tag.
+func (tags netboxTags) slugs() []string {
+	if len(tags) == 0 {
+		return nil
+	}
+	result := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		result = append(result, tag.Slug)
+	}
+	return result
+}
+
+// get queries NetBox and decodes the JSON answer.
+func (p *netboxPoller) get(ctx context.Context, u string, result any) error {
+	if p.limiter != nil {
+		if err := p.limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return fmt.Errorf("unable to build new request: %w", err)
+	}
+	req.Header.Set("Accept", "application/json")
+	if p.config.Token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("Token %s", p.config.Token))
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to query NetBox: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d: %s", resp.StatusCode, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("cannot decode JSON output: %w", err)
+	}
+	return nil
+}
+
+func (p *netboxPoller) Poll(ctx context.Context, exporter, _ netip.Addr, _ uint16, ifIndexes []uint) error {
+	exporterStr := exporter.Unmap().String()
+	baseURL := strings.TrimRight(p.config.URL, "/")
+	fail := func(what string, err error) error {
+		if errors.Is(err, context.Canceled) {
+			return nil
+		}
+		p.metrics.errors.WithLabelValues(exporterStr, what).Inc()
+		p.errLogger.Err(err).Str("exporter", exporterStr).Msgf("unable to fetch %s from NetBox", what)
+		return err
+	}
+
+	// Find the device owning the exporter IP address
+	var addresses netboxIPAddresses
+	u := fmt.Sprintf("%s/api/ipam/ip-addresses/?address=%s", baseURL, url.QueryEscape(exporterStr))
+	if err := p.get(ctx, u, &addresses); err != nil {
+		return fail("device", err)
+	}
+	var deviceID int
+	var deviceName string
+	for _, address := range addresses.Results {
+		if address.AssignedObject != nil && address.AssignedObject.Device != nil {
+			deviceID = address.AssignedObject.Device.ID
+			deviceName = address.AssignedObject.Device.Name
+			break
+		}
+	}
+	if deviceName == "" {
+		return fail("device", errors.New("no device found"))
+	}
+
+	// Fetch tenant and tags of the device
+	var device netboxDevice
+	u = fmt.Sprintf("%s/api/dcim/devices/%d/", baseURL, deviceID)
+	if err := p.get(ctx, u, &device); err != nil {
+		return fail("device", err)
+	}
+	var deviceTenant string
+	if device.Tenant != nil {
+		deviceTenant = device.Tenant.Name
+	}
+	deviceTags := device.Tags.slugs()
+
+	// Fetch interfaces of the device
+	wanted := map[uint]bool{}
+	for _, ifIndex := range ifIndexes {
+		wanted[ifIndex] = true
+	}
+	found := map[uint]Interface{}
+	u = fmt.Sprintf("%s/api/dcim/interfaces/?device_id=%d&limit=1000", baseURL, deviceID)
+	for u != "" {
+		var interfaces netboxInterfaces
+		if err := p.get(ctx, u, &interfaces); err != nil {
+			return fail("interfaces", err)
+		}
+		for _, iface := range interfaces.Results {
+			ifIndex, ok := netboxIfIndex(iface.CustomFields[p.config.IfIndexField])
+			if !ok || !wanted[ifIndex] {
+				continue
+			}
+			found[ifIndex] = Interface{
+				Name:        iface.Name,
+				Description: iface.Description,
+				Speed:       iface.Speed / 1000,
+				Tags:        iface.Tags.slugs(),
+			}
+		}
+		u = interfaces.Next
+	}
+
+	for _, ifIndex := range ifIndexes {
+		iface, ok := found[ifIndex]
+		if !ok && ifIndex > 0 {
+			p.metrics.errors.WithLabelValues(exporterStr, "interface missing").Inc()
+		}
+		// Missing interfaces are put in the cache as a negative entry
+		iface.ExporterTenant = deviceTenant
+		iface.ExporterTags = deviceTags
+		p.put(exporter, deviceName, ifIndex, iface)
+		if ok {
+			p.metrics.successes.WithLabelValues(exporterStr).Inc()
+		}
+	}
+	return nil
+}
+
+// netboxIfIndex converts the value of a custom field to an interface index.
+func netboxIfIndex(value any) (uint, bool) {
+	switch v := value.(type) {
+	case float64:
+		if v > 0 {
+			return uint(v), true
+		}
+	case string:
+		if ifIndex, err := strconv.ParseUint(v, 10, 32); err == nil && ifIndex > 0 {
+			return uint(ifIndex), true
+		}
+	}
+	return 0, false
+}
+
+// fallbackPoller uses a secondary poller when the primary one fails.
+type fallbackPoller struct {
+	primary   poller
+	secondary poller
+}
+
+func (p fallbackPoller) Poll(ctx context.Context, exporter, agent netip.Addr, port uint16, ifIndexes []uint) error {
+	if err := p.primary.Poll(ctx, exporter, agent, port, ifIndexes); err == nil {
+		return nil
+	}
+	return p.secondary.Poll(ctx, exporter, agent, port, ifIndexes)
+}
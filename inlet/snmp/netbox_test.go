@@ -0,0 +1,152 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package snmp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/reporter"
+)
+
+func newNetBoxServer(t *testing.T) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/ipam/ip-addresses/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Token secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Query().Get("address") {
+		case "192.0.2.1":
+			fmt.Fprint(w, `{"count": 1, "results": [{"address": "192.0.2.1/32", "assigned_object_type": "dcim.interface", "assigned_object": {"id": 100, "device": {"id": 12, "name": "edge1.example.com"}}}]}`)
+		default:
+			fmt.Fprint(w, `{"count": 0, "results": []}`)
+		}
+	})
+	mux.HandleFunc("/api/dcim/devices/12/", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprint(w, `{"id": 12, "name": "edge1.example.com", "tenant": {"id": 3, "name": "Team A", "slug": "team-a"}, "tags": [{"id": 1, "name": "Edge", "slug": "edge"}, {"id": 2, "name": "Paris", "slug": "paris"}]}`)
+	})
+	mux.HandleFunc("/api/dcim/interfaces/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("device_id") != "12" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Query().Get("offset") == "" {
+			fmt.Fprintf(w, `{"count": 3, "next": "http://%s/api/dcim/interfaces/?device_id=12&limit=2&offset=2", "results": [
+{"name": "Gi0/0/0", "description": "Transit: Cogent", "speed": 10000000, "tags": [{"id": 4, "name": "Transit", "slug": "transit"}], "custom_fields": {"ifindex": 641}},
+{"name": "Gi0/0/1", "description": "PNI: Netflix", "speed": null, "custom_fields": {"ifindex": "642"}}]}`, r.Host)
+			return
+		}
+		fmt.Fprint(w, `{"count": 3, "next": null, "results": [
+{"name": "Gi0/0/2", "description": "", "speed": 1000000, "custom_fields": {"ifindex": null}}]}`)
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestNetBoxPoller(t *testing.T) {
+	server := newNetBoxServer(t)
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration().NetBox
+	config.URL = server.URL
+	config.Token = "secret"
+	got := []string{}
+	p := newNetBoxPoller(r, config, func(exporterIP netip.Addr, exporterName string, ifIndex uint, iface Interface) {
+		got = append(got, fmt.Sprintf("%s %s %d %s %s %d %v %s %v",
+			exporterIP.Unmap().String(), exporterName, ifIndex, iface.Name, iface.Description, iface.Speed,
+			iface.Tags, iface.ExporterTenant, iface.ExporterTags))
+	})
+
+	exporter := netip.MustParseAddr("::ffff:192.0.2.1")
+	if err := p.Poll(context.Background(), exporter, exporter, 161, []uint{641, 642, 643}); err != nil {
+		t.Fatalf("Poll() error:\n%+v", err)
+	}
+	other := netip.MustParseAddr("::ffff:192.0.2.2")
+	if err := p.Poll(context.Background(), other, other, 161, []uint{641}); err == nil {
+		t.Fatal("Poll() no error")
+	}
+
+	expected := []string{
+		`192.0.2.1 edge1.example.com 641 Gi0/0/0 Transit: Cogent 10000 [transit] Team A [edge paris]`,
+		`192.0.2.1 edge1.example.com 642 Gi0/0/1 PNI: Netflix 0 [] Team A [edge paris]`,
+		`192.0.2.1 edge1.example.com 643   0 [] Team A [edge paris]`,
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("Poll() (-got, +want):\n%s", diff)
+	}
+
+	gotMetrics := r.GetMetrics("akvorado_inlet_snmp_netbox_")
+	expectedMetrics := map[string]string{
+		`error_requests{error="device",exporter="192.0.2.2"}`:            "1",
+		`error_requests{error="interface missing",exporter="192.0.2.1"}`: "1",
+		`success_requests{exporter="192.0.2.1"}`:                         "2",
+	}
+	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
+		t.Fatalf("Metrics (-got, +want):\n%s", diff)
+	}
+}
+
+type failingPoller struct {
+	calls atomic.Int32
+}
+
+func (p *failingPoller) Poll(context.Context, netip.Addr, netip.Addr, uint16, []uint) error {
+	p.calls.Add(1)
+	return errors.New("failure")
+}
+
+func TestNetBoxFallback(t *testing.T) {
+	server := newNetBoxServer(t)
+	r := reporter.NewMock(t)
+	configuration := DefaultConfiguration()
+	configuration.NetBox.URL = server.URL
+	configuration.NetBox.Token = "secret"
+	configuration.NetBox.Fallback = true
+	c, err := New(r, configuration, Dependencies{Daemon: daemon.NewMock(t)})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	primary := &failingPoller{}
+	fallback, ok := c.poller.(fallbackPoller)
+	if !ok {
+		t.Fatalf("poller is %T, not a fallback poller", c.poller)
+	}
+	fallback.primary = primary
+	c.poller = fallback
+	helpers.StartStop(t, c)
+
+	exporter := netip.MustParseAddr("::ffff:192.0.2.1")
+	var exporterName string
+	var iface Interface
+	for i := 0; ; i++ {
+		exporterName, iface, ok = c.Lookup(time.Now(), exporter, 641)
+		if ok {
+			break
+		}
+		if i == 100 {
+			t.Fatal("Lookup() not found")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if exporterName != "edge1.example.com" || iface.Name != "Gi0/0/0" || iface.Speed != 10000 {
+		t.Errorf("Lookup() = %q, %+v", exporterName, iface)
+	}
+	if primary.calls.Load() == 0 {
+		t.Error("primary poller not called")
+	}
+}
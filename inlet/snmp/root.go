@@ -10,6 +10,7 @@ import (
 	"errors"
 	"fmt"
 	"net/netip"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -96,6 +97,28 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 			sc.Put(dependencies.Clock.Now(), ip, exporterName, index, iface)
 		}),
 	}
+	if configuration.NetBox.URL != "" {
+		netboxPoller := newNetBoxPoller(r, configuration.NetBox,
+			func(ip netip.Addr, exporterName string, index uint, iface Interface) {
+				sc.Put(dependencies.Clock.Now(), ip, exporterName, index, iface)
+			})
+		if configuration.NetBox.Fallback {
+			c.poller = fallbackPoller{primary: c.poller, secondary: netboxPoller}
+		} else {
+			c.poller = netboxPoller
+		}
+	}
+	if dnsEnabled(configuration.DNS.Policy) {
+		dnsPoller := newDNSPoller(r, configuration.DNS,
+			func(ip netip.Addr, exporterName string, index uint, iface Interface) {
+				sc.Put(dependencies.Clock.Now(), ip, exporterName, index, iface)
+			})
+		c.poller = dnsPolicyPoller{
+			policy: configuration.DNS.Policy,
+			other:  c.poller,
+			dns:    dnsPoller,
+		}
+	}
 	c.d.Daemon.Track(&c.t, "inlet/snmp")
 
 	c.metrics.cacheRefreshRuns = r.Counter(
@@ -252,6 +275,43 @@ func (c *Component) Lookup(t time.Time, exporterIP netip.Addr, ifIndex uint) (st
 	return exporterName, iface, ok
 }
 
+// Flush removes the cached interfaces of the provided exporter, or of all
+// exporters when the address is not valid. They are polled again on the next
+// lookup. It returns the number of removed interfaces.
+func (c *Component) Flush(exporterIP netip.Addr) int {
+	return c.sc.Flush(exporterIP)
+}
+
+// CachedInterface is an interface whose information is in cache.
+type CachedInterface struct {
+	ExporterIP   netip.Addr
+	ExporterName string
+	Index        uint
+	Interface
+}
+
+// Interfaces returns the interfaces currently in cache, sorted by exporter
+// and index. It does not trigger any polling.
+func (c *Component) Interfaces() []CachedInterface {
+	items := c.sc.Items()
+	result := make([]CachedInterface, 0, len(items))
+	for k, v := range items {
+		result = append(result, CachedInterface{
+			ExporterIP:   k.IP,
+			ExporterName: v.ExporterName,
+			Index:        k.Index,
+			Interface:    v.Interface,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].ExporterIP == result[j].ExporterIP {
+			return result[i].Index < result[j].Index
+		}
+		return result[i].ExporterIP.Less(result[j].ExporterIP)
+	})
+	return result
+}
+
 // Dispatch an incoming request to workers. May handle more than the
 // provided request if it can.
 func (c *Component) dispatchIncomingRequest(request lookupRequest) {
@@ -43,6 +43,23 @@ func TestLookup(t *testing.T) {
 	expectSNMPLookup(t, c, "127.0.0.1", 999, answer{
 		ExporterName: "127_0_0_1",
 	})
+
+	ip := netip.AddrFrom16(netip.MustParseAddr("127.0.0.1").As16())
+	expectedInterfaces := []CachedInterface{
+		{
+			ExporterIP:   ip,
+			ExporterName: "127_0_0_1",
+			Index:        765,
+			Interface:    Interface{Name: "Gi0/0/765", Description: "Interface 765", Speed: 1000},
+		}, {
+			ExporterIP:   ip,
+			ExporterName: "127_0_0_1",
+			Index:        999,
+		},
+	}
+	if diff := helpers.Diff(c.Interfaces(), expectedInterfaces); diff != "" {
+		t.Fatalf("Interfaces() (-got, +want):\n%s", diff)
+	}
 }
 
 func TestSNMPCommunities(t *testing.T) {
@@ -0,0 +1,228 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/kylelemons/godebug/diff"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/helpers"
+	"akvorado/common/helpers/yaml"
+)
+
+const (
+	// ChangelogKindRules is the kind for changes of the classification
+	// and sampling rules of an inlet configuration.
+	ChangelogKindRules = "rules"
+	// ChangelogKindSchema is the kind for changes of the schema.
+	ChangelogKindSchema = "schema"
+	// ChangelogKindMigration is the kind for applied database migrations.
+	ChangelogKindMigration = "migration"
+	// ChangelogKindInlet is the kind for rule sets picked up by inlets.
+	ChangelogKindInlet = "inlet"
+	// ChangelogKindSchemaVersion is the kind for versions of the protobuf
+	// schema provisioned in ClickHouse.
+	ChangelogKindSchemaVersion = "schema-version"
+)
+
+// changelogSubject is a value whose changes are recorded in the changelog.
+type changelogSubject struct {
+	kind    string
+	subject string
+	value   interface{}
+}
+
+// RegisterChangelogSubject registers a value whose changes should be
+// recorded in the changelog. It is compared with the last recorded version
+// once the migrations are done. It should be called before starting the
+// component.
+func (c *Component) RegisterChangelogSubject(kind, subject string, value interface{}) {
+	c.changelogSubjects = append(c.changelogSubjects, changelogSubject{
+		kind:    kind,
+		subject: subject,
+		value:   value,
+	})
+}
+
+// changelogHash returns the hash of the provided content. This is the same
+// scheme as the one used by inlets to report their active rule set.
+func changelogHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// changelogAuthor returns the author for changes recorded by this
+// orchestrator.
+func changelogAuthor() string {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+	return fmt.Sprintf("orchestrator@%s", hostname)
+}
+
+// lastChangelogEntry returns the last entry recorded for the provided kind and
+// subject. It returns nil if there is none.
+func (c *Component) lastChangelogEntry(ctx context.Context, kind, subject string) (*clickhousedb.ChangelogEntry, error) {
+	entries, err := c.d.ClickHouse.Changelog(ctx, clickhousedb.ChangelogQuery{
+		Kinds:       []string{kind},
+		Subject:     subject,
+		Limit:       1,
+		WithContent: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	return &entries[0], nil
+}
+
+// recordChangelog records the changes of the registered subjects since the
+// last run, as well as the applied migrations.
+func (c *Component) recordChangelog(ctx context.Context, appliedSteps int) error {
+	author := changelogAuthor()
+	now := c.d.Clock.Now()
+	if appliedSteps > 0 {
+		if err := c.d.ClickHouse.AddChangelogEntry(ctx, clickhousedb.ChangelogEntry{
+			Time:        now,
+			Kind:        ChangelogKindMigration,
+			Subject:     c.config.Database,
+			Author:      author,
+			Hash:        c.d.Schema.ProtobufMessageHash(),
+			Description: fmt.Sprintf("%d migration steps applied", appliedSteps),
+		}); err != nil {
+			return err
+		}
+	}
+	for _, subject := range c.changelogSubjects {
+		content, err := yaml.Marshal(subject.value)
+		if err != nil {
+			return fmt.Errorf("cannot serialize %s/%s: %w", subject.kind, subject.subject, err)
+		}
+		hash := changelogHash(content)
+		last, err := c.lastChangelogEntry(ctx, subject.kind, subject.subject)
+		if err != nil {
+			return err
+		}
+		entry := clickhousedb.ChangelogEntry{
+			Time:    now,
+			Kind:    subject.kind,
+			Subject: subject.subject,
+			Author:  author,
+			Hash:    hash,
+			Content: string(content),
+		}
+		if last == nil {
+			entry.Description = "initial version"
+		} else if last.Hash == hash {
+			continue
+		} else {
+			entry.Description = fmt.Sprintf("%s changed", subject.kind)
+			entry.Diff = diff.Diff(last.Content, entry.Content)
+		}
+		c.r.Info().Str("kind", subject.kind).Str("subject", subject.subject).Msg("record change in changelog")
+		if err := c.d.ClickHouse.AddChangelogEntry(ctx, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type changelogHandlerInput struct {
+	Start time.Time `form:"start"`
+	End   time.Time `form:"end"`
+	Kind  []string  `form:"kind"`
+	Limit int       `form:"limit" binding:"min=0,max=1000"`
+}
+
+// changelogHandlerFunc returns the entries of the changelog.
+func (c *Component) changelogHandlerFunc(gc *gin.Context) {
+	input := changelogHandlerInput{Limit: 100}
+	if err := gc.ShouldBindQuery(&input); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	entries, err := c.d.ClickHouse.Changelog(c.t.Context(gc.Request.Context()), clickhousedb.ChangelogQuery{
+		Start: input.Start,
+		End:   input.End,
+		Kinds: input.Kind,
+		Limit: input.Limit,
+	})
+	if err != nil {
+		c.r.Err(err).Msg("cannot get changelog")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to get changelog."})
+		return
+	}
+	gc.JSON(http.StatusOK, gin.H{"entries": entries})
+}
+
+type changelogInletHandlerInput struct {
+	Instance string `json:"instance" binding:"required"`
+	Hash     string `json:"hash" binding:"required"`
+}
+
+// changelogInletHandlerFunc records the rule set picked up by an inlet. An
+// entry is only added when the hash is different from the previous one for
+// the same instance.
+func (c *Component) changelogInletHandlerFunc(gc *gin.Context) {
+	var input changelogInletHandlerInput
+	if err := gc.ShouldBindJSON(&input); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	ctx := c.t.Context(gc.Request.Context())
+	last, err := c.lastChangelogEntry(ctx, ChangelogKindInlet, input.Instance)
+	if err != nil {
+		c.r.Err(err).Msg("cannot get changelog")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to get changelog."})
+		return
+	}
+	if last != nil && last.Hash == input.Hash {
+		gc.JSON(http.StatusOK, gin.H{"message": "ok"})
+		return
+	}
+
+	// Find the matching rule sets
+	matching := []string{}
+	for _, subject := range c.changelogSubjects {
+		if subject.kind != ChangelogKindRules {
+			continue
+		}
+		content, err := yaml.Marshal(subject.value)
+		if err == nil && changelogHash(content) == input.Hash {
+			matching = append(matching, subject.subject)
+		}
+	}
+	description := "rule set picked up, not matching any known configuration"
+	if len(matching) > 0 {
+		description = fmt.Sprintf("rule set picked up, matching %s", strings.Join(matching, ", "))
+	}
+
+	if err := c.d.ClickHouse.AddChangelogEntry(ctx, clickhousedb.ChangelogEntry{
+		Time:        c.d.Clock.Now(),
+		Kind:        ChangelogKindInlet,
+		Subject:     input.Instance,
+		Author:      fmt.Sprintf("inlet@%s", input.Instance),
+		Hash:        input.Hash,
+		Description: description,
+	}); err != nil {
+		c.r.Err(err).Msg("cannot add changelog entry")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to record rule set."})
+		return
+	}
+	c.r.Info().Str("instance", input.Instance).Str("hash", input.Hash).Msg("inlet picked up a new rule set")
+	gc.JSON(http.StatusOK, gin.H{"message": "ok"})
+}
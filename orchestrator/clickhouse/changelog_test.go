@@ -0,0 +1,217 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestRecordChangelog(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.SkipMigrations = true
+	mockClock := clock.NewMock()
+	c, err := New(r, config, Dependencies{
+		Daemon:     daemon.NewMock(t),
+		HTTP:       http.NewMock(t, r),
+		Schema:     schema.NewMock(t),
+		ClickHouse: chComponent,
+		Clock:      mockClock,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	rules := gin.H{"default-sampling-rate": 1000}
+	c.RegisterChangelogSubject(ChangelogKindRules, "inlet/0", rules)
+	c.RegisterChangelogSubject(ChangelogKindSchema, "schema", gin.H{"disabled": []string{"SrcAS"}})
+	helpers.StartStop(t, c)
+	ctx := context.Background()
+	now := time.Date(2023, time.May, 10, 10, 0, 0, 0, time.UTC)
+	mockClock.Set(now)
+
+	schemaContent := "disabled:\n    - SrcAS\n"
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				now, "migration", "default", gomock.Any(), c.d.Schema.ProtobufMessageHash(),
+				"3 migration steps applied", "", "").
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"rules"}, "inlet/0").
+			SetArg(1, []clickhousedb.ChangelogEntry{}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				now, "rules", "inlet/0", gomock.Any(), changelogHash([]byte("default-sampling-rate: 1000\n")),
+				"initial version", "", "default-sampling-rate: 1000\n").
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"schema"}, "schema").
+			SetArg(1, []clickhousedb.ChangelogEntry{{
+				Hash:    changelogHash([]byte(schemaContent)),
+				Content: schemaContent,
+			}}).
+			Return(nil),
+	)
+	if err := c.recordChangelog(ctx, 3); err != nil {
+		t.Fatalf("recordChangelog() error:\n%+v", err)
+	}
+
+	// Update rules
+	rules["default-sampling-rate"] = 100
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"rules"}, "inlet/0").
+			SetArg(1, []clickhousedb.ChangelogEntry{{
+				Hash:    changelogHash([]byte("default-sampling-rate: 1000\n")),
+				Content: "default-sampling-rate: 1000\n",
+			}}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				now, "rules", "inlet/0", gomock.Any(), changelogHash([]byte("default-sampling-rate: 100\n")),
+				"rules changed", "-default-sampling-rate: 1000\n+default-sampling-rate: 100\n ",
+				"default-sampling-rate: 100\n").
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"schema"}, "schema").
+			SetArg(1, []clickhousedb.ChangelogEntry{{
+				Hash:    changelogHash([]byte(schemaContent)),
+				Content: schemaContent,
+			}}).
+			Return(nil),
+	)
+	if err := c.recordChangelog(ctx, 0); err != nil {
+		t.Fatalf("recordChangelog() error:\n%+v", err)
+	}
+}
+
+func TestChangelogHandlers(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.SkipMigrations = true
+	h := http.NewMock(t, r)
+	mockClock := clock.NewMock()
+	c, err := New(r, config, Dependencies{
+		Daemon:     daemon.NewMock(t),
+		HTTP:       h,
+		Schema:     schema.NewMock(t),
+		ClickHouse: chComponent,
+		Clock:      mockClock,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	rules := gin.H{"default-sampling-rate": 1000}
+	rulesHash := changelogHash([]byte("default-sampling-rate: 1000\n"))
+	c.RegisterChangelogSubject(ChangelogKindRules, "inlet/0", rules)
+	helpers.StartStop(t, c)
+	now := time.Date(2023, time.May, 10, 10, 0, 0, 0, time.UTC)
+	mockClock.Set(now)
+
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"rules"}).
+			SetArg(1, []clickhousedb.ChangelogEntry{{
+				Time:        now,
+				Kind:        "rules",
+				Subject:     "inlet/0",
+				Author:      "orchestrator@localhost",
+				Hash:        rulesHash,
+				Description: "initial version",
+			}}).
+			Return(nil),
+		// Inlet reporting a new hash
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"inlet"}, "inlet1").
+			SetArg(1, []clickhousedb.ChangelogEntry{}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				now, "inlet", "inlet1", "inlet@inlet1", rulesHash,
+				"rule set picked up, matching inlet/0", "", "").
+			Return(nil),
+		// Inlet reporting the same hash
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"inlet"}, "inlet1").
+			SetArg(1, []clickhousedb.ChangelogEntry{{Hash: rulesHash}}).
+			Return(nil),
+		// Inlet reporting an unknown hash
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"inlet"}, "inlet1").
+			SetArg(1, []clickhousedb.ChangelogEntry{{Hash: rulesHash}}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				now, "inlet", "inlet1", "inlet@inlet1", "1234",
+				"rule set picked up, not matching any known configuration", "", "").
+			Return(nil),
+	)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "get changelog",
+			URL:         "/api/v0/orchestrator/changelog?kind=rules",
+			JSONOutput: gin.H{
+				"entries": []gin.H{{
+					"time":        "2023-05-10T10:00:00Z",
+					"kind":        "rules",
+					"subject":     "inlet/0",
+					"author":      "orchestrator@localhost",
+					"hash":        rulesHash,
+					"description": "initial version",
+				}},
+			},
+		}, {
+			Description: "get changelog with invalid limit",
+			URL:         "/api/v0/orchestrator/changelog?limit=10000",
+			StatusCode:  400,
+			JSONOutput: gin.H{
+				"message": "Key: 'changelogHandlerInput.Limit' Error:Field validation for 'Limit' failed on the 'max' tag",
+			},
+		}, {
+			Description: "inlet reports a new rule set",
+			Method:      "POST",
+			URL:         "/api/v0/orchestrator/changelog/inlet",
+			JSONInput:   gin.H{"instance": "inlet1", "hash": rulesHash},
+			JSONOutput:  gin.H{"message": "ok"},
+		}, {
+			Description: "inlet reports the same rule set",
+			Method:      "POST",
+			URL:         "/api/v0/orchestrator/changelog/inlet",
+			JSONInput:   gin.H{"instance": "inlet1", "hash": rulesHash},
+			JSONOutput:  gin.H{"message": "ok"},
+		}, {
+			Description: "inlet reports an unknown rule set",
+			Method:      "POST",
+			URL:         "/api/v0/orchestrator/changelog/inlet",
+			JSONInput:   gin.H{"instance": "inlet1", "hash": "1234"},
+			JSONOutput:  gin.H{"message": "ok"},
+		}, {
+			Description: "inlet reports without hash",
+			Method:      "POST",
+			URL:         "/api/v0/orchestrator/changelog/inlet",
+			JSONInput:   gin.H{"instance": "inlet1"},
+			StatusCode:  400,
+			JSONOutput: gin.H{
+				"message": "Key: 'changelogInletHandlerInput.Hash' Error:Field validation for 'Hash' failed on the 'required' tag",
+			},
+		},
+	})
+}
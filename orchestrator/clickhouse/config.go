@@ -4,12 +4,17 @@
 package clickhouse
 
 import (
+	"fmt"
 	"reflect"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
 	"akvorado/common/clickhousedb"
 	"akvorado/common/helpers"
 	"akvorado/common/kafka"
+	"akvorado/common/schema"
 
 	"github.com/itchyny/gojq"
 	"github.com/mitchellh/mapstructure"
@@ -19,34 +24,66 @@ import (
 type Configuration struct {
 	clickhousedb.Configuration `mapstructure:",squash" yaml:"-,inline"`
 	// SkipMigrations tell if we should skip migrations.
-	SkipMigrations bool
+	SkipMigrations bool `doc:"Do not run database migrations"`
 	// Kafka describes Kafka-specific configuration
-	Kafka KafkaConfiguration
+	Kafka KafkaConfiguration `doc:"Kafka settings for ClickHouse"`
 	// Resolutions describe the various resolutions to use to
 	// store data and the associated TTLs.
-	Resolutions []ResolutionConfiguration `validate:"dive"`
+	Resolutions []ResolutionConfiguration `validate:"dive" doc:"Resolutions used to store flows and their TTLs"`
 	// MaxPartitions define the number of partitions to have for a
 	// consolidated flow tables when full.
-	MaxPartitions int `validate:"isdefault|min=1"`
+	MaxPartitions int `validate:"isdefault|min=1" doc:"Number of partitions for consolidated flow tables when full"`
 	// SystemLogTTL is the TTL to set for system log tables.
-	SystemLogTTL time.Duration `validate:"isdefault|min=1m"`
+	SystemLogTTL time.Duration `validate:"isdefault|min=1m" doc:"TTL for system log tables (0 to disable)"`
 	// ASNs is a mapping from AS numbers to names. It replaces or
-	// extends the builtin list of AS numbers.
-	ASNs map[uint32]string
+	// extends the builtin list of AS numbers. Ranges of AS numbers
+	// (64512-65534) are expanded when decoding the configuration.
+	ASNs map[uint32]string `doc:"Mapping from AS numbers or ranges of AS numbers to names"`
+	// Applications is a list of mappings from protocols and ports to
+	// application names. It replaces or extends the builtin list.
+	Applications []ApplicationConfiguration `validate:"dive" doc:"Mapping from protocols and ports to application names"`
 	// Networks is a mapping from IP networks to attributes. It is used
 	// to instantiate the SrcNet* and DstNet* columns.
-	Networks *helpers.SubnetMap[NetworkAttributes] `validate:"omitempty,dive"`
+	Networks *helpers.SubnetMap[NetworkAttributes] `validate:"omitempty,dive" doc:"Mapping from IP networks to attributes"`
 	// NetworkSources defines a set of remote network
 	// definitions to map IP networks to attributes. It is used to
 	// instantiate the SrcNet* and DstNet* columns. The results
 	// are overridden by the content of Networks.
-	NetworkSources map[string]NetworkSource `validate:"dive"`
+	NetworkSources map[string]NetworkSource `validate:"dive" doc:"Remote sources of network attributes"`
 	// NetworkSourceTimeout tells how long to wait for network
 	// sources to be ready. 503 is returned when not.
-	NetworkSourcesTimeout time.Duration `validate:"min=0"`
+	NetworkSourcesTimeout time.Duration `validate:"min=0" doc:"How long to wait for network sources to be ready"`
 	// OrchestratorURL allows one to override URL to reach
 	// orchestrator from ClickHouse
-	OrchestratorURL string `validate:"isdefault|url"`
+	OrchestratorURL string `validate:"isdefault|url" doc:"URL to reach the orchestrator from ClickHouse"`
+	// FirstSeen defines the dimension tuples for which the first and last
+	// times they were seen are tracked, indexed by name.
+	FirstSeen map[string]FirstSeenConfiguration `validate:"dive" doc:"Dimension tuples whose first and last seen times are tracked, indexed by name"`
+}
+
+// FirstSeenConfiguration describes a dimension tuple whose first and last
+// seen times are tracked. Each distinct tuple is stored as a row, so the
+// cardinality of the dimensions should be kept under control.
+type FirstSeenConfiguration struct {
+	// Dimensions are the columns composing the tuple.
+	Dimensions []schema.ColumnKey `validate:"min=1" doc:"Columns composing the tuple"`
+}
+
+// ApplicationConfiguration maps a protocol and a port to an application name.
+type ApplicationConfiguration struct {
+	// Protocol is the transport protocol (tcp, udp, or sctp).
+	Protocol string `validate:"oneof=tcp udp sctp" doc:"Transport protocol (tcp, udp, sctp)"`
+	// Port is the port number.
+	Port uint16 `validate:"min=1" doc:"Port number"`
+	// Name is the name of the application.
+	Name string `validate:"required" doc:"Name of the application"`
+}
+
+// applicationProtocols maps transport protocol names to their numbers.
+var applicationProtocols = map[string]uint8{
+	"tcp":  6,
+	"udp":  17,
+	"sctp": 132,
 }
 
 // ResolutionConfiguration describes a consolidation interval.
@@ -54,20 +91,20 @@ type ResolutionConfiguration struct {
 	// Interval is the consolidation interval for this
 	// resolution. An interval of 0 means no consolidation
 	// takes place (it is used for the `flows' table).
-	Interval time.Duration `validate:"isdefault|min=5s"`
+	Interval time.Duration `validate:"isdefault|min=5s" doc:"Consolidation interval (0 for no consolidation)"`
 	// TTL is how long to keep data for this resolution. A
 	// value of 0 means to never expire.
-	TTL time.Duration `validate:"isdefault|min=1h"`
+	TTL time.Duration `validate:"isdefault|min=1h" doc:"How long to keep data for this resolution (0 to never expire)"`
 }
 
 // KafkaConfiguration describes Kafka-specific configuration
 type KafkaConfiguration struct {
 	kafka.Configuration `mapstructure:",squash" yaml:"-,inline"`
 	// Consumers tell how many consumers to use to poll data from Kafka
-	Consumers int `validate:"min=1"`
+	Consumers int `validate:"min=1" doc:"Number of consumers polling Kafka in ClickHouse"`
 	// EngineSettings allows one to set arbitrary settings for Kafka engine in
 	// ClickHouse.
-	EngineSettings []string
+	EngineSettings []string `doc:"Additional settings for the Kafka engine in ClickHouse"`
 }
 
 // DefaultConfiguration represents the default configuration for the ClickHouse configurator.
@@ -92,15 +129,15 @@ func DefaultConfiguration() Configuration {
 // NetworkAttributes is a set of attributes attached to a network
 type NetworkAttributes struct {
 	// Name is a name attached to the network. May be unique or not.
-	Name string
+	Name string `doc:"Name attached to the network"`
 	// Role is a role attached to the network (server, customer).
-	Role string
+	Role string `doc:"Role attached to the network"`
 	// Site is the site of the network (paris, berlin).
-	Site string
+	Site string `doc:"Site of the network"`
 	// Region is the region of the network (france, italy).
-	Region string
+	Region string `doc:"Region of the network"`
 	// Tenant is a tenant for the network.
-	Tenant string
+	Tenant string `doc:"Tenant of the network"`
 }
 
 // NetworkAttributesUnmarshallerHook decodes network attributes. It
@@ -120,20 +157,95 @@ func NetworkAttributesUnmarshallerHook() mapstructure.DecodeHookFunc {
 	}
 }
 
+// maxASNRangeSize is the maximum number of AS numbers in a range.
+const maxASNRangeSize = 65536
+
+// ASNsUnmarshallerHook expands ranges of AS numbers (64512-65534) into
+// individual AS numbers. Individual AS numbers have precedence over ranges
+// and smaller ranges over larger ones.
+func ASNsUnmarshallerHook() mapstructure.DecodeHookFunc {
+	return func(from, to reflect.Value) (interface{}, error) {
+		from = helpers.ElemOrIdentity(from)
+		to = helpers.ElemOrIdentity(to)
+		if to.Type() != reflect.TypeOf(map[uint32]string{}) || from.Kind() != reflect.Map {
+			return from.Interface(), nil
+		}
+		type asnRange struct {
+			first, last uint32
+			name        string
+		}
+		ranges := []asnRange{}
+		singles := []asnRange{}
+		iter := from.MapRange()
+		for iter.Next() {
+			k := helpers.ElemOrIdentity(iter.Key())
+			v := helpers.ElemOrIdentity(iter.Value())
+			if v.Kind() != reflect.String {
+				return nil, fmt.Errorf("name for AS %v should be a string", k.Interface())
+			}
+			key := strings.TrimSpace(fmt.Sprint(k.Interface()))
+			first, last, isRange := strings.Cut(key, "-")
+			if !isRange {
+				last = first
+			}
+			firstASN, err := strconv.ParseUint(strings.TrimSpace(first), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid AS number %q", key)
+			}
+			lastASN, err := strconv.ParseUint(strings.TrimSpace(last), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid AS number %q", key)
+			}
+			if lastASN < firstASN {
+				return nil, fmt.Errorf("invalid AS range %q", key)
+			}
+			if lastASN-firstASN >= maxASNRangeSize {
+				return nil, fmt.Errorf("AS range %q is too large (more than %d AS numbers)",
+					key, maxASNRangeSize)
+			}
+			entry := asnRange{uint32(firstASN), uint32(lastASN), v.String()}
+			if isRange {
+				ranges = append(ranges, entry)
+			} else {
+				singles = append(singles, entry)
+			}
+		}
+		sort.SliceStable(ranges, func(i, j int) bool {
+			sizeI := ranges[i].last - ranges[i].first
+			sizeJ := ranges[j].last - ranges[j].first
+			if sizeI != sizeJ {
+				return sizeI > sizeJ
+			}
+			return ranges[i].first < ranges[j].first
+		})
+		result := map[uint32]string{}
+		for _, entry := range append(ranges, singles...) {
+			for asn := uint64(entry.first); asn <= uint64(entry.last); asn++ {
+				result[uint32(asn)] = entry.name
+			}
+		}
+		return result, nil
+	}
+}
+
 // NetworkSource defines a remote network definition.
 type NetworkSource struct {
 	// URL is the URL to fetch to get remote network definition.
-	// It should provide a JSON file.
-	URL string `validate:"url"`
+	// It should provide a JSON or a CSV file.
+	URL string `validate:"url" doc:"URL to fetch the remote network definitions from"`
+	// Format is the format of the remote network definition (json or
+	// csv). When empty, JSON is assumed.
+	Format string `validate:"omitempty,oneof=json csv" doc:"Format of the remote network definitions (json or csv)"`
 	// Proxy is set to true if a proxy should be used.
-	Proxy bool
+	Proxy bool `doc:"Use a proxy to fetch the URL"`
 	// Timeout tells the maximum time the remote request should take
-	Timeout time.Duration `validate:"isdefault|min=1s"`
+	Timeout time.Duration `validate:"isdefault|min=1s" doc:"Maximum duration of the request"`
 	// Transform is a jq string to transform the received JSON
-	// data into a list of network attributes.
-	Transform TransformQuery
+	// data into a list of network attributes. It is ignored for
+	// CSV sources.
+	Transform TransformQuery `doc:"jq expression to transform the received data into network attributes"`
 	// Interval tells how much time to wait before updating the source.
-	Interval time.Duration `validate:"min=1m"`
+	Interval time.Duration `validate:"min=1m" doc:"Interval between two updates"`
 }
 
 // TransformQuery represents a jq query to transform data.
@@ -167,5 +279,6 @@ func (jq TransformQuery) MarshalText() ([]byte, error) {
 func init() {
 	helpers.RegisterMapstructureUnmarshallerHook(helpers.SubnetMapUnmarshallerHook[NetworkAttributes]())
 	helpers.RegisterMapstructureUnmarshallerHook(NetworkAttributesUnmarshallerHook())
+	helpers.RegisterMapstructureUnmarshallerHook(ASNsUnmarshallerHook())
 	helpers.RegisterSubnetMapValidation[NetworkAttributes]()
 }
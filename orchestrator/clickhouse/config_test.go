@@ -11,7 +11,11 @@ import (
 
 	"github.com/gin-gonic/gin"
 
+	"akvorado/common/daemon"
 	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
 )
 
 func TestNetworkNamesUnmarshalHook(t *testing.T) {
@@ -151,10 +155,110 @@ func TestNetworkSourceDecode(t *testing.T) {
 	}, helpers.DiffFormatter(reflect.TypeOf(TransformQuery{}), fmt.Sprint), helpers.DiffZero)
 }
 
+func TestASNsDecode(t *testing.T) {
+	helpers.TestConfigurationDecode(t, helpers.ConfigurationDecodeCases{
+		{
+			Description: "Single AS numbers",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"asns": gin.H{
+						"64500": "Customer 1",
+						"64501": "Customer 2",
+					},
+				}
+			},
+			Expected: Configuration{
+				ASNs: map[uint32]string{
+					64500: "Customer 1",
+					64501: "Customer 2",
+				},
+			},
+		}, {
+			Description: "Ranges of AS numbers",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"asns": gin.H{
+						"64510-64514":           "Internal",
+						"64512 - 64513":         "Datacenter",
+						"64513":                 "Lab",
+						"4200000000-4200000001": "Internal",
+					},
+				}
+			},
+			Expected: Configuration{
+				ASNs: map[uint32]string{
+					64510:      "Internal",
+					64511:      "Internal",
+					64512:      "Datacenter",
+					64513:      "Lab",
+					64514:      "Internal",
+					4200000000: "Internal",
+					4200000001: "Internal",
+				},
+			},
+		}, {
+			Description: "Reversed range",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"asns": gin.H{"64514-64510": "Internal"},
+				}
+			},
+			Error: true,
+		}, {
+			Description: "Too large range",
+			Initial:     func() interface{} { return Configuration{} },
+			Configuration: func() interface{} {
+				return gin.H{
+					"asns": gin.H{"4200000000-4294967294": "Internal"},
+				}
+			},
+			Error: true,
+		},
+	}, helpers.DiffFormatter(reflect.TypeOf(TransformQuery{}), fmt.Sprint), helpers.DiffZero)
+}
+
 func TestDefaultConfiguration(t *testing.T) {
 	config := DefaultConfiguration()
 	config.Kafka.Topic = "flow"
+	config.Kafka.Brokers = []string{"127.0.0.1:9092"}
 	if err := helpers.Validate.Struct(config); err != nil {
 		t.Fatalf("validate.Struct() error:\n%+v", err)
 	}
 }
+
+func TestFirstSeenConfiguration(t *testing.T) {
+	cases := []struct {
+		Description string
+		Name        string
+		Dimensions  []schema.ColumnKey
+		Error       bool
+	}{
+		{"valid", "as_addr", []schema.ColumnKey{schema.ColumnSrcAS, schema.ColumnDstAddr}, false},
+		{"invalid name", "as-addr", []schema.ColumnKey{schema.ColumnSrcAS}, true},
+		{"disabled column", "vlan", []schema.ColumnKey{schema.ColumnSrcVlan}, true},
+		{"not a dimension", "bytes", []schema.ColumnKey{schema.ColumnBytes}, true},
+		{"time", "time", []schema.ColumnKey{schema.ColumnTimeReceived}, true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.Description, func(t *testing.T) {
+			r := reporter.NewMock(t)
+			configuration := DefaultConfiguration()
+			configuration.FirstSeen = map[string]FirstSeenConfiguration{
+				tc.Name: {Dimensions: tc.Dimensions},
+			}
+			_, err := New(r, configuration, Dependencies{
+				Daemon: daemon.NewMock(t),
+				HTTP:   http.NewMock(t, r),
+				Schema: schema.NewMock(t),
+			})
+			if err != nil && !tc.Error {
+				t.Fatalf("New() error:\n%+v", err)
+			} else if err == nil && tc.Error {
+				t.Fatal("New() did not error")
+			}
+		})
+	}
+}
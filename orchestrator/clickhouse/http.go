@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"text/template"
 	"time"
@@ -18,13 +19,16 @@ import (
 var (
 	//go:embed data/protocols.csv
 	//go:embed data/asns.csv
+	//go:embed data/applications.csv
 	data           embed.FS
 	initShTemplate = template.Must(template.New("initsh").Parse(`#!/bin/sh
 
-# Install Protobuf schema
-cat > /var/lib/clickhouse/format_schemas/flow-{{ .FlowSchemaHash }}.proto <<'EOPROTO'
-{{ .FlowSchema }}
+# Install Protobuf schemas
+{{- range $schema := .FlowSchemas }}
+cat > /var/lib/clickhouse/format_schemas/flow-{{ $schema.Hash }}.proto <<'EOPROTO'
+{{ $schema.Definition }}
 EOPROTO
+{{- end }}
 
 # Alter ClickHouse configuration
 cat > /etc/clickhouse-server/config.d/akvorado.xml <<'EOCONFIG'
@@ -42,8 +46,7 @@ EOCONFIG
 )
 
 type initShVariables struct {
-	FlowSchemaHash  string
-	FlowSchema      string
+	FlowSchemas     []schemaVersion
 	SystemLogTTL    int
 	SystemLogTables []string
 }
@@ -69,9 +72,8 @@ func (c *Component) registerHTTPHandlers() error {
 		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			var result bytes.Buffer
 			if err := initShTemplate.Execute(&result, initShVariables{
-				FlowSchemaHash: c.d.Schema.ProtobufMessageHash(),
-				FlowSchema:     c.d.Schema.ProtobufDefinition(),
-				SystemLogTTL:   int(c.config.SystemLogTTL.Seconds()),
+				FlowSchemas:  c.knownSchemaVersions(),
+				SystemLogTTL: int(c.config.SystemLogTTL.Seconds()),
 				SystemLogTables: []string{
 					"asynchronous_metric_log",
 					"metric_log",
@@ -104,8 +106,13 @@ func (c *Component) registerHTTPHandlers() error {
 			wr.Write([]string{"network", "name", "role", "site", "region", "tenant"})
 			c.networkSourcesLock.RLock()
 			defer c.networkSourcesLock.RUnlock()
-			for _, ss := range c.networkSources {
-				for _, v := range ss {
+			names := make([]string, 0, len(c.networkSources))
+			for name := range c.networkSources {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				for _, v := range c.networkSources[name] {
 					wr.Write([]string{
 						v.Prefix.String(),
 						v.Name, v.Role, v.Site, v.Region, v.Tenant,
@@ -168,6 +175,72 @@ func (c *Component) registerHTTPHandlers() error {
 			}))
 	}
 
+	// applications.csv (when there are some custom-defined applications)
+	if len(c.config.Applications) != 0 {
+		// When an application is defined several times, the first one wins
+		custom := map[[2]string]bool{}
+		customRecords := [][]string{}
+		for _, application := range c.config.Applications {
+			key := [2]string{
+				strconv.Itoa(int(applicationProtocols[application.Protocol])),
+				strconv.Itoa(int(application.Port)),
+			}
+			if !custom[key] {
+				custom[key] = true
+				customRecords = append(customRecords, []string{key[0], key[1], application.Name})
+			}
+		}
+		c.d.HTTP.AddHandler("/api/v0/orchestrator/clickhouse/applications.csv",
+			http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				f, err := data.Open("data/applications.csv")
+				if err != nil {
+					c.r.Err(err).Msg("unable to open data/applications.csv")
+					http.Error(w, "Unable to open application file.",
+						http.StatusInternalServerError)
+					return
+				}
+				rd := csv.NewReader(f)
+				rd.ReuseRecord = true
+				rd.FieldsPerRecord = 3
+				w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+				w.WriteHeader(http.StatusOK)
+				wr := csv.NewWriter(w)
+				wr.Write([]string{"proto", "port", "name"})
+				// Custom applications
+				wr.WriteAll(customRecords)
+				// Other applications
+				for count := 0; ; count++ {
+					record, err := rd.Read()
+					if err == io.EOF {
+						break
+					}
+					if err != nil {
+						c.r.Err(err).Msgf("unable to parse data/applications.csv (line %d)", count)
+						continue
+					}
+					if count == 0 {
+						continue
+					}
+					if !custom[[2]string{record[0], record[1]}] {
+						wr.Write(record)
+					}
+				}
+				wr.Flush()
+			}))
+	}
+
+	// Maintenance mode
+	c.d.HTTP.GinRouter.GET("/api/v0/orchestrator/clickhouse/maintenance", c.maintenanceGetHandlerFunc)
+	c.d.HTTP.GinRouter.PUT("/api/v0/orchestrator/clickhouse/maintenance", c.maintenancePutHandlerFunc)
+	c.d.HTTP.GinRouter.DELETE("/api/v0/orchestrator/clickhouse/maintenance", c.maintenanceDeleteHandlerFunc)
+
+	// Changelog
+	c.d.HTTP.GinRouter.GET("/api/v0/orchestrator/changelog", c.changelogHandlerFunc)
+	c.d.HTTP.GinRouter.POST("/api/v0/orchestrator/changelog/inlet", c.changelogInletHandlerFunc)
+
+	// Schema versions
+	c.d.HTTP.GinRouter.POST("/api/v0/orchestrator/clickhouse/schema-version", c.schemaVersionHandlerFunc)
+
 	// Static CSV files
 	entries, err := data.ReadDir("data")
 	if err != nil {
@@ -180,6 +253,9 @@ func (c *Component) registerHTTPHandlers() error {
 		if entry.Name() == "asns.csv" && len(c.config.ASNs) != 0 {
 			continue
 		}
+		if entry.Name() == "applications.csv" && len(c.config.Applications) != 0 {
+			continue
+		}
 		url := fmt.Sprintf("/api/v0/orchestrator/clickhouse/%s", entry.Name())
 		path := fmt.Sprintf("data/%s", entry.Name())
 		c.addHandlerEmbedded(url, path)
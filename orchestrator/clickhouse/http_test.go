@@ -51,6 +51,13 @@ func TestHTTPEndpoints(t *testing.T) {
 				`"asn","name"`,
 				`1,"Level 3 Communications"`,
 			},
+		}, {
+			URL:         "/api/v0/orchestrator/clickhouse/applications.csv",
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				`proto,port,name`,
+				`6,20,ftp-data`,
+			},
 		}, {
 			URL:         "/api/v0/orchestrator/clickhouse/networks.csv",
 			ContentType: "text/csv; charset=utf-8",
@@ -64,7 +71,7 @@ func TestHTTPEndpoints(t *testing.T) {
 			FirstLines: []string{
 				`#!/bin/sh`,
 				``,
-				`# Install Protobuf schema`,
+				`# Install Protobuf schemas`,
 				fmt.Sprintf(`cat > /var/lib/clickhouse/format_schemas/flow-%s.proto <<'EOPROTO'`,
 					c.d.Schema.ProtobufMessageHash()),
 				"",
@@ -106,6 +113,40 @@ func TestAdditionalASNs(t *testing.T) {
 	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), cases)
 }
 
+func TestAdditionalApplications(t *testing.T) {
+	r := reporter.NewMock(t)
+	config := DefaultConfiguration()
+	config.Applications = []ApplicationConfiguration{
+		{Protocol: "tcp", Port: 21, Name: "file-transfer"},
+		{Protocol: "udp", Port: 8472, Name: "vxlan-linux"},
+		{Protocol: "tcp", Port: 21, Name: "ignored"},
+	}
+	c, err := New(r, config, Dependencies{
+		Daemon: daemon.NewMock(t),
+		HTTP:   http.NewMock(t, r),
+		Schema: schema.NewMock(t),
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+
+	cases := helpers.HTTPEndpointCases{
+		{
+			URL:         "/api/v0/orchestrator/clickhouse/applications.csv",
+			ContentType: "text/csv; charset=utf-8",
+			FirstLines: []string{
+				`proto,port,name`,
+				`6,21,file-transfer`,
+				`17,8472,vxlan-linux`,
+				`6,20,ftp-data`,
+				`6,22,ssh`,
+			},
+		},
+	}
+
+	helpers.TestHTTPEndpoints(t, c.d.HTTP.LocalAddr(), cases)
+}
+
 func TestNetworkSources(t *testing.T) {
 	// Mux to answer requests
 	ready := make(chan bool)
@@ -146,10 +187,25 @@ func TestNetworkSources(t *testing.T) {
     }
   ]
 }
+`))
+	}))
+	mux.Handle("/customers.csv", netHTTP.HandlerFunc(func(w netHTTP.ResponseWriter, r *netHTTP.Request) {
+		select {
+		case <-ready:
+		default:
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Add("Content-Type", "text/csv")
+		w.WriteHeader(200)
+		w.Write([]byte(`network,tenant,name,comment
+# Customers
+198.51.100.0/24,customer1,Customer 1,VIP
+2001:db8:100::/48,customer2,Customer 2,
 `))
 	}))
 
-	// Setup an HTTP server to serve the JSON
+	// Setup an HTTP server to serve the JSON and CSV
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatalf("Listen() error:\n%+v", err)
@@ -175,6 +231,11 @@ func TestNetworkSources(t *testing.T) {
 { prefix: (.ip_prefix // .ipv6_prefix), tenant: "amazon", region: .region, role: .service|ascii_downcase }
 `),
 		},
+		"customers": {
+			URL:      fmt.Sprintf("http://%s/customers.csv", address),
+			Format:   "csv",
+			Interval: 100 * time.Millisecond,
+		},
 	}
 	c, err := New(r, config, Dependencies{
 		Daemon: daemon.NewMock(t),
@@ -206,13 +267,16 @@ func TestNetworkSources(t *testing.T) {
 				`3.2.34.0/26,,amazon,,af-south-1,amazon`,
 				`2600:1ff2:4000::/40,,amazon,,us-west-2,amazon`,
 				`2600:1f14:fff:f800::/56,,route53_healthchecks,,us-west-2,amazon`,
+				`198.51.100.0/24,Customer 1,,,,customer1`,
+				`2001:db8:100::/48,Customer 2,,,,customer2`,
 			},
 		},
 	})
 
 	gotMetrics := r.GetMetrics("akvorado_orchestrator_clickhouse_network_source_networks_")
 	expectedMetrics := map[string]string{
-		`total{source="amazon"}`: "3",
+		`total{source="amazon"}`:    "3",
+		`total{source="customers"}`: "2",
 	}
 	if diff := helpers.Diff(gotMetrics, expectedMetrics); diff != "" {
 		t.Fatalf("Metrics (-got, +want):\n%s", diff)
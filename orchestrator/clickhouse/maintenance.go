@@ -0,0 +1,161 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/helpers"
+)
+
+// maintenanceState is the maintenance status as known by the orchestrator.
+// The status is stored in ClickHouse for the consoles to pick it up.
+type maintenanceState struct {
+	lock   sync.Mutex
+	status clickhousedb.MaintenanceStatus
+	// fromMigrations tells if the maintenance was started by the migrations
+	// and should therefore be stopped once they are done.
+	fromMigrations bool
+	stepsDone      int
+	stepsTotal     int
+}
+
+// enterMaintenance puts the database in maintenance mode because a migration
+// step is going to rewrite data. This is a no-op if the database is already
+// in maintenance mode.
+func (c *Component) enterMaintenance(ctx context.Context, reason string) error {
+	c.maintenance.lock.Lock()
+	defer c.maintenance.lock.Unlock()
+	if c.maintenance.status.Active {
+		return nil
+	}
+	current, err := c.d.ClickHouse.Maintenance(ctx)
+	if err != nil {
+		c.r.Err(err).Msg("cannot get maintenance status")
+	} else if current.Active {
+		// Maintenance was requested by an operator
+		c.maintenance.status = current
+		return nil
+	}
+	c.r.Info().Str("reason", reason).Msg("entering maintenance mode")
+	if err := c.setMaintenance(ctx, clickhousedb.MaintenanceStatus{
+		Active: true,
+		Reason: reason,
+		Since:  c.d.Clock.Now(),
+	}); err != nil {
+		return err
+	}
+	c.maintenance.fromMigrations = true
+	return nil
+}
+
+// leaveMaintenance removes the maintenance mode once the migrations are done,
+// unless it was requested by an operator.
+func (c *Component) leaveMaintenance(ctx context.Context) error {
+	c.maintenance.lock.Lock()
+	defer c.maintenance.lock.Unlock()
+	if !c.maintenance.fromMigrations {
+		return nil
+	}
+	c.r.Info().Msg("leaving maintenance mode")
+	if err := c.setMaintenance(ctx, clickhousedb.MaintenanceStatus{Since: c.d.Clock.Now()}); err != nil {
+		return err
+	}
+	c.maintenance.fromMigrations = false
+	return nil
+}
+
+// migrationProgress records the progress of the migrations. It is reported
+// as part of the maintenance status when the database is in maintenance mode.
+func (c *Component) migrationProgress(ctx context.Context, done, total int) error {
+	c.maintenance.lock.Lock()
+	defer c.maintenance.lock.Unlock()
+	c.maintenance.stepsDone = done
+	c.maintenance.stepsTotal = total
+	if c.maintenance.status.Active {
+		return c.setMaintenance(ctx, c.maintenance.status)
+	}
+	return nil
+}
+
+// setMaintenance stores the provided maintenance status, with the current
+// progress of the migrations. The lock should be held.
+func (c *Component) setMaintenance(ctx context.Context, status clickhousedb.MaintenanceStatus) error {
+	if status.Active && c.maintenance.stepsTotal > 0 && c.maintenance.stepsDone < c.maintenance.stepsTotal {
+		status.StepsDone = uint32(c.maintenance.stepsDone)
+		status.StepsTotal = uint32(c.maintenance.stepsTotal)
+	} else {
+		status.StepsDone = 0
+		status.StepsTotal = 0
+	}
+	if err := c.d.ClickHouse.SetMaintenance(ctx, status); err != nil {
+		c.r.Err(err).Msg("cannot update maintenance status")
+		return err
+	}
+	c.maintenance.status = status
+	return nil
+}
+
+type maintenanceHandlerInput struct {
+	Reason      string     `json:"reason"`
+	ExpectedEnd *time.Time `json:"expected-end"`
+}
+
+// maintenanceGetHandlerFunc returns the current maintenance status.
+func (c *Component) maintenanceGetHandlerFunc(gc *gin.Context) {
+	status, err := c.d.ClickHouse.Maintenance(c.t.Context(gc.Request.Context()))
+	if err != nil {
+		c.r.Err(err).Msg("cannot get maintenance status")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to get maintenance status."})
+		return
+	}
+	gc.JSON(http.StatusOK, status)
+}
+
+// maintenancePutHandlerFunc puts the database in maintenance mode.
+func (c *Component) maintenancePutHandlerFunc(gc *gin.Context) {
+	var input maintenanceHandlerInput
+	if err := gc.ShouldBindJSON(&input); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	if input.Reason == "" {
+		input.Reason = "maintenance requested by an operator"
+	}
+	c.maintenance.lock.Lock()
+	defer c.maintenance.lock.Unlock()
+	// The migrations should not remove the maintenance mode anymore.
+	c.maintenance.fromMigrations = false
+	status := clickhousedb.MaintenanceStatus{
+		Active:      true,
+		Reason:      input.Reason,
+		Since:       c.d.Clock.Now(),
+		ExpectedEnd: input.ExpectedEnd,
+	}
+	if err := c.setMaintenance(c.t.Context(gc.Request.Context()), status); err != nil {
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to set maintenance status."})
+		return
+	}
+	c.r.Info().Str("reason", input.Reason).Msg("entering maintenance mode")
+	gc.JSON(http.StatusOK, c.maintenance.status)
+}
+
+// maintenanceDeleteHandlerFunc removes the maintenance mode.
+func (c *Component) maintenanceDeleteHandlerFunc(gc *gin.Context) {
+	c.maintenance.lock.Lock()
+	defer c.maintenance.lock.Unlock()
+	c.maintenance.fromMigrations = false
+	if err := c.setMaintenance(c.t.Context(gc.Request.Context()), clickhousedb.MaintenanceStatus{Since: c.d.Clock.Now()}); err != nil {
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to set maintenance status."})
+		return
+	}
+	c.r.Info().Msg("leaving maintenance mode")
+	gc.JSON(http.StatusOK, c.maintenance.status)
+}
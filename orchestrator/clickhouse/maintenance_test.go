@@ -0,0 +1,232 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestMaintenanceFromMigrations(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.SkipMigrations = true
+	c, err := New(r, config, Dependencies{
+		Daemon:     daemon.NewMock(t),
+		HTTP:       http.NewMock(t, r),
+		Schema:     schema.NewMock(t),
+		ClickHouse: chComponent,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+	ctx := context.Background()
+
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []clickhousedb.MaintenanceStatus{}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				true, "updating table flows", gomock.Any(), gomock.Nil(), uint32(2), uint32(10)).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				true, "updating table flows", gomock.Any(), gomock.Nil(), uint32(3), uint32(10)).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				false, "", gomock.Any(), gomock.Nil(), uint32(0), uint32(0)).
+			Return(nil),
+	)
+
+	// Not in maintenance: nothing is written
+	c.migrationProgress(ctx, 1, 10)
+	c.migrationProgress(ctx, 2, 10)
+	// A table is rewritten
+	c.enterMaintenance(ctx, "updating table flows")
+	c.migrationProgress(ctx, 3, 10)
+	// Another table is rewritten, still the same maintenance
+	c.enterMaintenance(ctx, "updating table flows_1m0s")
+	c.leaveMaintenance(ctx)
+	// Nothing more to do
+	c.leaveMaintenance(ctx)
+}
+
+func TestMaintenanceErrors(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.SkipMigrations = true
+	c, err := New(r, config, Dependencies{
+		Daemon:     daemon.NewMock(t),
+		HTTP:       http.NewMock(t, r),
+		Schema:     schema.NewMock(t),
+		ClickHouse: chComponent,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+	ctx := context.Background()
+	errFailed := errors.New("failed")
+
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []clickhousedb.MaintenanceStatus{}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				true, "updating table flows", gomock.Any(), gomock.Nil(), uint32(0), uint32(0)).
+			Return(errFailed),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []clickhousedb.MaintenanceStatus{}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				true, "updating table flows", gomock.Any(), gomock.Nil(), uint32(0), uint32(0)).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				true, "updating table flows", gomock.Any(), gomock.Nil(), uint32(1), uint32(10)).
+			Return(errFailed),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				false, "", gomock.Any(), gomock.Nil(), uint32(0), uint32(0)).
+			Return(errFailed),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				false, "", gomock.Any(), gomock.Nil(), uint32(0), uint32(0)).
+			Return(nil),
+	)
+
+	// Failing to enter maintenance mode does not require to leave it
+	if err := c.enterMaintenance(ctx, "updating table flows"); !errors.Is(err, errFailed) {
+		t.Fatalf("enterMaintenance() error:\n%+v", err)
+	}
+	if err := c.leaveMaintenance(ctx); err != nil {
+		t.Fatalf("leaveMaintenance() error:\n%+v", err)
+	}
+	if err := c.enterMaintenance(ctx, "updating table flows"); err != nil {
+		t.Fatalf("enterMaintenance() error:\n%+v", err)
+	}
+	if err := c.migrationProgress(ctx, 1, 10); !errors.Is(err, errFailed) {
+		t.Fatalf("migrationProgress() error:\n%+v", err)
+	}
+	// Failing to leave maintenance mode can be retried
+	if err := c.leaveMaintenance(ctx); !errors.Is(err, errFailed) {
+		t.Fatalf("leaveMaintenance() error:\n%+v", err)
+	}
+	if err := c.leaveMaintenance(ctx); err != nil {
+		t.Fatalf("leaveMaintenance() error:\n%+v", err)
+	}
+}
+
+func TestMaintenanceFromOperator(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.SkipMigrations = true
+	h := http.NewMock(t, r)
+	mockClock := clock.NewMock()
+	c, err := New(r, config, Dependencies{
+		Daemon:     daemon.NewMock(t),
+		HTTP:       h,
+		Schema:     schema.NewMock(t),
+		ClickHouse: chComponent,
+		Clock:      mockClock,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+	ctx := context.Background()
+	since := time.Date(2023, time.April, 10, 10, 0, 0, 0, time.UTC)
+	expectedEnd := time.Date(2023, time.April, 10, 12, 0, 0, 0, time.UTC)
+	mockClock.Set(since)
+
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				true, "upgrade", gomock.Any(), &expectedEnd, uint32(0), uint32(0)).
+			Return(nil),
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any()).
+			SetArg(1, []clickhousedb.MaintenanceStatus{
+				{Active: true, Reason: "upgrade", Since: since, ExpectedEnd: &expectedEnd},
+			}).
+			Return(nil),
+		// Migration progress is reported, but the maintenance mode is kept
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				true, "upgrade", gomock.Any(), &expectedEnd, uint32(4), uint32(10)).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				false, "", gomock.Any(), gomock.Nil(), uint32(0), uint32(0)).
+			Return(nil),
+	)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "put in maintenance",
+			Method:      "PUT",
+			URL:         "/api/v0/orchestrator/clickhouse/maintenance",
+			JSONInput: gin.H{
+				"reason":       "upgrade",
+				"expected-end": expectedEnd,
+			},
+			JSONOutput: gin.H{
+				"active":       true,
+				"reason":       "upgrade",
+				"since":        "2023-04-10T10:00:00Z",
+				"expected-end": "2023-04-10T12:00:00Z",
+			},
+		}, {
+			Description: "get maintenance status",
+			URL:         "/api/v0/orchestrator/clickhouse/maintenance",
+			JSONOutput: gin.H{
+				"active":       true,
+				"reason":       "upgrade",
+				"since":        "2023-04-10T10:00:00Z",
+				"expected-end": "2023-04-10T12:00:00Z",
+			},
+		},
+	})
+
+	c.enterMaintenance(ctx, "updating table flows")
+	c.migrationProgress(ctx, 4, 10)
+	c.leaveMaintenance(ctx)
+	mockClock.Add(2 * time.Hour)
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "remove maintenance",
+			Method:      "DELETE",
+			URL:         "/api/v0/orchestrator/clickhouse/maintenance",
+			JSONOutput: gin.H{
+				"active": false,
+				"since":  "2023-04-10T12:00:00Z",
+			},
+		},
+	})
+}
@@ -7,9 +7,11 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"sort"
 
 	"github.com/ClickHouse/clickhouse-go/v2"
 
+	"akvorado/common/clickhousedb"
 	"akvorado/common/reporter"
 )
 
@@ -58,51 +60,98 @@ func (c *Component) migrateDatabase() error {
 		c.config.Kafka.Consumers = int(threads)
 	}
 
+	// Maintenance table first, to be able to report progress
+	steps := []func() error{
+		func() error {
+			return c.createMaintenanceTable(ctx)
+		}, func() error {
+			return c.createChangelogTable(ctx)
+		},
+	}
+
 	// Create dictionaries
-	err := c.wrapMigrations(
+	steps = append(steps,
 		func() error {
 			return c.createDictionary(ctx, "asns", "hashed",
 				"`asn` UInt32 INJECTIVE, `name` String", "asn")
 		}, func() error {
 			return c.createDictionary(ctx, "protocols", "hashed",
 				"`proto` UInt8 INJECTIVE, `name` String, `description` String", "proto")
+		}, func() error {
+			return c.createDictionary(ctx, "applications", "complex_key_hashed",
+				"`proto` UInt8, `port` UInt16, `name` String", "proto, port")
 		}, func() error {
 			return c.createDictionary(ctx, "networks", "ip_trie",
 				"`network` String, `name` String, `role` String, `site` String, `region` String, `tenant` String",
 				"network")
 		})
-	if err != nil {
-		return err
-	}
 
 	// Create the various non-raw flow tables
 	for _, resolution := range c.config.Resolutions {
-		err := c.wrapMigrations(
+		resolution := resolution
+		steps = append(steps,
 			func() error {
 				return c.createOrUpdateFlowsTable(ctx, resolution)
 			}, func() error {
 				return c.createFlowsConsumerView(ctx, resolution)
 			})
-		if err != nil {
-			return err
-		}
 	}
 
 	// Remaining tables
-	err = c.wrapMigrations(
+	steps = append(steps,
 		func() error {
 			return c.createExportersView(ctx)
-		}, func() error {
-			return c.createRawFlowsTable(ctx)
-		}, func() error {
-			return c.createRawFlowsConsumerView(ctx)
-		}, func() error {
-			return c.createRawFlowsErrorsView(ctx)
-		},
-	)
+		})
+
+	// Raw tables for each known version of the protobuf schema, to consume
+	// flows from inlets not upgraded yet.
+	if ok, err := c.tableAlreadyExists(ctx, clickhousedb.ChangelogTable, "name", clickhousedb.ChangelogTable); err != nil {
+		return err
+	} else if ok {
+		if _, err := c.loadSchemaVersions(ctx); err != nil {
+			c.r.Err(err).Msg("unable to load schema versions from changelog")
+		}
+	}
+	for _, version := range c.knownSchemaVersions() {
+		hash := version.Hash
+		steps = append(steps,
+			func() error {
+				return c.createRawFlowsTable(ctx, hash)
+			}, func() error {
+				return c.createRawFlowsConsumerView(ctx, hash)
+			}, func() error {
+				return c.createRawFlowsErrorsView(ctx, hash)
+			})
+	}
+
+	// First seen tables
+	names := make([]string, 0, len(c.config.FirstSeen))
+	for name := range c.config.FirstSeen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		name := name
+		steps = append(steps, func() error {
+			return c.createOrUpdateFirstSeenTable(ctx, name, c.config.FirstSeen[name])
+		})
+	}
+	steps = append(steps, func() error {
+		return c.dropStaleFirstSeenTables(ctx)
+	})
+	applied, err := c.wrapMigrations(ctx, steps...)
 	if err != nil {
 		return err
 	}
+	if err := c.leaveMaintenance(ctx); err != nil {
+		return err
+	}
+	if err := c.recordChangelog(ctx, applied); err != nil {
+		c.r.Err(err).Msg("unable to record changes in changelog")
+	}
+	if err := c.recordSchemaVersions(ctx); err != nil {
+		c.r.Err(err).Msg("unable to record schema version in changelog")
+	}
 
 	close(c.migrationsDone)
 	c.metrics.migrationsRunning.Set(0)
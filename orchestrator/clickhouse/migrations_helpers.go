@@ -16,25 +16,36 @@ import (
 	"github.com/gin-gonic/gin"
 	"golang.org/x/exp/slices"
 
+	"akvorado/common/clickhousedb"
+	"akvorado/common/discovery"
 	"akvorado/common/schema"
 )
 
 var errSkipStep = errors.New("migration: skip this step")
 
 // wrapMigrations can be used to wrap migration functions. It will keep the
-// metrics up-to-date as long as the migration function returns `errSkipStep`
-// when a step is skipped.
-func (c *Component) wrapMigrations(fns ...func() error) error {
-	for _, fn := range fns {
+// metrics and the maintenance progress up-to-date as long as the migration
+// function returns `errSkipStep` when a step is skipped. It returns the number
+// of applied steps.
+func (c *Component) wrapMigrations(ctx context.Context, fns ...func() error) (int, error) {
+	applied := 0
+	for idx, fn := range fns {
+		if err := c.migrationProgress(ctx, idx, len(fns)); err != nil {
+			return applied, err
+		}
 		if err := fn(); err == nil {
 			c.metrics.migrationsApplied.Inc()
+			applied++
 		} else if err == errSkipStep {
 			c.metrics.migrationsNotApplied.Inc()
 		} else {
-			return err
+			return applied, err
 		}
 	}
-	return nil
+	if err := c.migrationProgress(ctx, len(fns), len(fns)); err != nil {
+		return applied, err
+	}
+	return applied, nil
 }
 
 // stemplate is a simple wrapper around text/template.
@@ -80,6 +91,59 @@ func (c *Component) tableAlreadyExists(ctx context.Context, table, column, targe
 	return false, nil
 }
 
+// createMaintenanceTable creates the table holding the maintenance status.
+func (c *Component) createMaintenanceTable(ctx context.Context) error {
+	if ok, err := c.tableAlreadyExists(ctx, clickhousedb.MaintenanceTable, "name", clickhousedb.MaintenanceTable); err != nil {
+		return err
+	} else if ok {
+		c.r.Info().Msg("maintenance table already exists, skip migration")
+		return errSkipStep
+	}
+	c.r.Info().Msg("create maintenance table")
+	if err := c.d.ClickHouse.Exec(ctx, fmt.Sprintf(`
+CREATE TABLE %s (
+ updated DateTime64(9) DEFAULT now64(9),
+ active Bool,
+ reason String,
+ since DateTime,
+ expected_end Nullable(DateTime),
+ steps_done UInt32,
+ steps_total UInt32
+)
+ENGINE = ReplacingMergeTree(updated)
+ORDER BY tuple()`, clickhousedb.MaintenanceTable)); err != nil {
+		return fmt.Errorf("cannot create maintenance table: %w", err)
+	}
+	return nil
+}
+
+// createChangelogTable creates the table holding the changelog.
+func (c *Component) createChangelogTable(ctx context.Context) error {
+	if ok, err := c.tableAlreadyExists(ctx, clickhousedb.ChangelogTable, "name", clickhousedb.ChangelogTable); err != nil {
+		return err
+	} else if ok {
+		c.r.Info().Msg("changelog table already exists, skip migration")
+		return errSkipStep
+	}
+	c.r.Info().Msg("create changelog table")
+	if err := c.d.ClickHouse.Exec(ctx, fmt.Sprintf(`
+CREATE TABLE %s (
+ time DateTime64(3),
+ kind LowCardinality(String),
+ subject String,
+ author String,
+ hash String,
+ description String,
+ diff String,
+ content String
+)
+ENGINE = MergeTree
+ORDER BY (kind, subject, time)`, clickhousedb.ChangelogTable)); err != nil {
+		return fmt.Errorf("cannot create changelog table: %w", err)
+	}
+	return nil
+}
+
 // createDictionary creates the provided dictionary.
 func (c *Component) createDictionary(ctx context.Context, name, layout, schema, primary string) error {
 	url := fmt.Sprintf("%s/api/v0/orchestrator/clickhouse/%s.csv", c.config.OrchestratorURL, name)
@@ -175,13 +239,19 @@ AS %s
 	return nil
 }
 
-// createRawFlowsTable creates the raw flow table
-func (c *Component) createRawFlowsTable(ctx context.Context) error {
-	hash := c.d.Schema.ProtobufMessageHash()
+// createRawFlowsTable creates the raw flow table for the provided version of
+// the protobuf schema. The columns are always the current ones.
+func (c *Component) createRawFlowsTable(ctx context.Context, hash string) error {
 	tableName := fmt.Sprintf("flows_%s_raw", hash)
+	// ClickHouse resolves brokers by itself, but it does not know about SRV
+	// records.
+	brokers, err := discovery.Expand(ctx, c.config.Kafka.Brokers)
+	if err != nil {
+		return fmt.Errorf("cannot resolve Kafka brokers: %w", err)
+	}
 	kafkaSettings := []string{
 		fmt.Sprintf(`kafka_broker_list = '%s'`,
-			strings.Join(c.config.Kafka.Brokers, ",")),
+			strings.Join(brokers, ",")),
 		fmt.Sprintf(`kafka_topic_list = '%s-%s'`,
 			c.config.Kafka.Topic, hash),
 		`kafka_group_name = 'clickhouse'`,
@@ -216,12 +286,12 @@ func (c *Component) createRawFlowsTable(ctx context.Context) error {
 	if ok, err := c.tableAlreadyExists(ctx, tableName, "create_table_query", createQuery); err != nil {
 		return err
 	} else if ok {
-		c.r.Info().Msg("raw flows table already exists, skip migration")
+		c.r.Info().Str("hash", hash).Msg("raw flows table already exists, skip migration")
 		return errSkipStep
 	}
 
 	// Drop table if it exists as well as all the dependents and recreate the raw table
-	c.r.Info().Msg("create raw flows table")
+	c.r.Info().Str("hash", hash).Msg("create raw flows table")
 	for _, table := range []string{
 		fmt.Sprintf("%s_consumer", tableName),
 		fmt.Sprintf("%s_errors", tableName),
@@ -238,8 +308,8 @@ func (c *Component) createRawFlowsTable(ctx context.Context) error {
 	return nil
 }
 
-func (c *Component) createRawFlowsConsumerView(ctx context.Context) error {
-	tableName := fmt.Sprintf("flows_%s_raw", c.d.Schema.ProtobufMessageHash())
+func (c *Component) createRawFlowsConsumerView(ctx context.Context, hash string) error {
+	tableName := fmt.Sprintf("flows_%s_raw", hash)
 	viewName := fmt.Sprintf("%s_consumer", tableName)
 
 	// Build SELECT query
@@ -267,12 +337,12 @@ func (c *Component) createRawFlowsConsumerView(ctx context.Context) error {
 	if ok, err := c.tableAlreadyExists(ctx, viewName, "as_select", selectQuery); err != nil {
 		return err
 	} else if ok {
-		c.r.Info().Msg("raw flows consumer view already exists, skip migration")
+		c.r.Info().Str("hash", hash).Msg("raw flows consumer view already exists, skip migration")
 		return errSkipStep
 	}
 
 	// Drop and create
-	c.r.Info().Msg("create raw flows consumer view")
+	c.r.Info().Str("hash", hash).Msg("create raw flows consumer view")
 	if err := c.d.ClickHouse.Exec(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
 		return fmt.Errorf("cannot drop table %s: %w", viewName, err)
 	}
@@ -285,8 +355,8 @@ func (c *Component) createRawFlowsConsumerView(ctx context.Context) error {
 	return nil
 }
 
-func (c *Component) createRawFlowsErrorsView(ctx context.Context) error {
-	tableName := fmt.Sprintf("flows_%s_raw", c.d.Schema.ProtobufMessageHash())
+func (c *Component) createRawFlowsErrorsView(ctx context.Context, hash string) error {
+	tableName := fmt.Sprintf("flows_%s_raw", hash)
 	viewName := fmt.Sprintf("%s_errors", tableName)
 
 	// Build SELECT query
@@ -311,12 +381,12 @@ WHERE length(_error) > 0`, gin.H{
 	if ok, err := c.tableAlreadyExists(ctx, viewName, "as_select", selectQuery); err != nil {
 		return err
 	} else if ok {
-		c.r.Info().Msg("raw flows errors view already exists, skip migration")
+		c.r.Info().Str("hash", hash).Msg("raw flows errors view already exists, skip migration")
 		return errSkipStep
 	}
 
 	// Drop and create
-	c.r.Info().Msg("create raw flows errors view")
+	c.r.Info().Str("hash", hash).Msg("create raw flows errors view")
 	if err := c.d.ClickHouse.Exec(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, viewName)); err != nil {
 		return fmt.Errorf("cannot drop table %s: %w", viewName, err)
 	}
@@ -489,6 +559,9 @@ outer:
 				fmt.Sprintf("MODIFY ORDER BY (%s)", strings.Join(c.d.Schema.ClickHouseSortingKeys(), ", ")))
 		}
 		c.r.Info().Msgf("apply %d modifications to %s", len(modifications), tableName)
+		if err := c.enterMaintenance(ctx, fmt.Sprintf("updating table %s", tableName)); err != nil {
+			return err
+		}
 		if resolution.Interval > 0 {
 			// Drop the view
 			viewName := fmt.Sprintf("%s_consumer", tableName)
@@ -510,6 +583,9 @@ outer:
 	} else if !ok {
 		c.r.Warn().
 			Msgf("updating TTL of %s with interval %s, this can take a long time", tableName, resolution.Interval)
+		if err := c.enterMaintenance(ctx, fmt.Sprintf("updating TTL of table %s", tableName)); err != nil {
+			return err
+		}
 		if err := c.d.ClickHouse.Exec(ctx, fmt.Sprintf("ALTER TABLE %s MODIFY %s", tableName, ttlClause)); err != nil {
 			return fmt.Errorf("cannot modify TTL for table %s: %w", tableName, err)
 		}
@@ -564,3 +640,116 @@ FROM {{ .Database }}.flows`, gin.H{
 	}
 	return nil
 }
+
+// firstSeenTableName returns the name of the table tracking the provided
+// first seen tuple.
+func firstSeenTableName(name string) string {
+	return fmt.Sprintf("first_seen_%s", name)
+}
+
+// createOrUpdateFirstSeenTable creates the table tracking the first and last
+// seen times of a dimension tuple, as well as the materialized view feeding
+// it. When the dimensions change, both are recreated and the previous data
+// is lost.
+func (c *Component) createOrUpdateFirstSeenTable(ctx context.Context, name string, firstSeen FirstSeenConfiguration) error {
+	tableName := firstSeenTableName(name)
+	viewName := fmt.Sprintf("%s_consumer", tableName)
+	dimensions := []string{}
+	columns := []string{}
+	for _, key := range firstSeen.Dimensions {
+		column, _ := c.d.Schema.LookupColumnByKey(key)
+		dimensions = append(dimensions, column.Name)
+		columns = append(columns, fmt.Sprintf("%s %s", column.Name, column.ClickHouseType))
+	}
+
+	// Build SELECT query
+	selectQuery, err := stemplate(`
+SELECT
+ {{ .Dimensions }},
+ min(TimeReceived) AS FirstSeen,
+ max(TimeReceived) AS LastSeen
+FROM {{ .Database }}.flows
+GROUP BY {{ .Dimensions }}`, gin.H{
+		"Database":   c.config.Database,
+		"Dimensions": strings.Join(dimensions, ", "),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot build select statement for consumer %s: %w", viewName, err)
+	}
+
+	// Check the existing one
+	if ok, err := c.tableAlreadyExists(ctx, viewName, "as_select", selectQuery); err != nil {
+		return err
+	} else if ok {
+		c.r.Info().Msgf("%s already exists, skip migration", viewName)
+		return errSkipStep
+	}
+
+	// Drop and create
+	c.r.Info().Msgf("create %s", tableName)
+	for _, table := range []string{viewName, tableName} {
+		if err := c.d.ClickHouse.Exec(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, table)); err != nil {
+			return fmt.Errorf("cannot drop table %s: %w", table, err)
+		}
+	}
+	createQuery, err := stemplate(`
+CREATE TABLE {{ .Table }} (
+ {{ .Columns }},
+ FirstSeen SimpleAggregateFunction(min, DateTime),
+ LastSeen SimpleAggregateFunction(max, DateTime)
+)
+ENGINE = AggregatingMergeTree
+ORDER BY ({{ .Dimensions }})`, gin.H{
+		"Table":      tableName,
+		"Columns":    strings.Join(columns, ",\n "),
+		"Dimensions": strings.Join(dimensions, ", "),
+	})
+	if err != nil {
+		return fmt.Errorf("cannot build create table statement for %s: %w", tableName, err)
+	}
+	if err := c.d.ClickHouse.Exec(ctx, createQuery); err != nil {
+		return fmt.Errorf("cannot create %s: %w", tableName, err)
+	}
+	if err := c.d.ClickHouse.Exec(ctx,
+		fmt.Sprintf(`CREATE MATERIALIZED VIEW %s TO %s AS %s`, viewName, tableName, selectQuery)); err != nil {
+		return fmt.Errorf("cannot create %s: %w", viewName, err)
+	}
+	return nil
+}
+
+// dropStaleFirstSeenTables drops the first seen tables (and their
+// materialized views) which are not configured anymore.
+func (c *Component) dropStaleFirstSeenTables(ctx context.Context) error {
+	var existing []struct {
+		Name string `ch:"name"`
+	}
+	if err := c.d.ClickHouse.Select(ctx, &existing, `
+SELECT name
+FROM system.tables
+WHERE database = $1
+AND startsWith(name, 'first_seen_')
+ORDER BY engine = 'MaterializedView' DESC
+`, c.config.Database); err != nil {
+		return fmt.Errorf("cannot query tables: %w", err)
+	}
+	expected := map[string]bool{}
+	for name := range c.config.FirstSeen {
+		expected[firstSeenTableName(name)] = true
+		expected[fmt.Sprintf("%s_consumer", firstSeenTableName(name))] = true
+	}
+	dropped := false
+	for _, table := range existing {
+		if expected[table.Name] {
+			continue
+		}
+		c.r.Info().Msgf("drop stale %s", table.Name)
+		if err := c.d.ClickHouse.Exec(ctx, fmt.Sprintf(`DROP TABLE IF EXISTS %s SYNC`, table.Name)); err != nil {
+			return fmt.Errorf("cannot drop table %s: %w", table.Name, err)
+		}
+		dropped = true
+	}
+	if !dropped {
+		return errSkipStep
+	}
+	return nil
+}
@@ -237,7 +237,9 @@ WHERE database=currentDatabase() AND table NOT LIKE '.%'`)
 				}
 			}
 			expected := []string{
+				"applications",
 				"asns",
+				"changelog",
 				"exporters",
 				"flows",
 				"flows_1h0m0s",
@@ -249,6 +251,7 @@ WHERE database=currentDatabase() AND table NOT LIKE '.%'`)
 				fmt.Sprintf("flows_%s_raw", hash),
 				fmt.Sprintf("flows_%s_raw_consumer", hash),
 				fmt.Sprintf("flows_%s_raw_errors", hash),
+				"maintenance",
 				"networks",
 				"protocols",
 			}
@@ -346,6 +349,20 @@ LIMIT 1`)
 			if gotMetrics["applied_steps"] == "0" {
 				t.Fatal("No migration applied when enabling all columns")
 			}
+
+			// The previous version of the schema is still consumed
+			previousHash := schema.NewMock(t).ProtobufMessageHash()
+			for _, table := range []string{
+				fmt.Sprintf("flows_%s_raw", previousHash),
+				fmt.Sprintf("flows_%s_raw_consumer", previousHash),
+				fmt.Sprintf("flows_%s_raw_errors", previousHash),
+			} {
+				if ok, err := ch.tableAlreadyExists(context.Background(), table, "name", table); err != nil {
+					t.Fatalf("tableAlreadyExists(%q) error:\n%+v", table, err)
+				} else if !ok {
+					t.Fatalf("table %q for previous schema version does not exist", table)
+				}
+			}
 		})
 	}
 
@@ -439,4 +456,64 @@ AND name LIKE $3`, "flows", ch.config.Database, "%NetPrefix")
 			}
 		})
 	}
+
+	// Track first seen tuples, then stop tracking them
+	if !t.Failed() {
+		t.Run("first seen", func(t *testing.T) {
+			firstSeenTables := func(ch *Component) []string {
+				t.Helper()
+				var tables []struct {
+					Name string `ch:"name"`
+				}
+				if err := ch.d.ClickHouse.Select(context.Background(), &tables, `
+SELECT name
+FROM system.tables
+WHERE database = $1
+AND startsWith(name, 'first_seen_')
+ORDER BY name`, ch.config.Database); err != nil {
+					t.Fatalf("Select() error:\n%+v", err)
+				}
+				got := []string{}
+				for _, table := range tables {
+					got = append(got, table.Name)
+				}
+				return got
+			}
+			for _, tc := range []struct {
+				FirstSeen map[string]FirstSeenConfiguration
+				Expected  []string
+			}{
+				{
+					FirstSeen: map[string]FirstSeenConfiguration{
+						"as_addr": {Dimensions: []schema.ColumnKey{schema.ColumnSrcAS, schema.ColumnDstAddr}},
+					},
+					Expected: []string{"first_seen_as_addr", "first_seen_as_addr_consumer"},
+				}, {
+					FirstSeen: map[string]FirstSeenConfiguration{},
+					Expected:  []string{},
+				},
+			} {
+				r := reporter.NewMock(t)
+				configuration := DefaultConfiguration()
+				configuration.OrchestratorURL = "http://something"
+				configuration.Kafka.Configuration = kafka.DefaultConfiguration()
+				configuration.FirstSeen = tc.FirstSeen
+				ch, err := New(r, configuration, Dependencies{
+					Daemon:     daemon.NewMock(t),
+					HTTP:       http.NewMock(t, r),
+					Schema:     schema.NewMock(t),
+					ClickHouse: chComponent,
+				})
+				if err != nil {
+					t.Fatalf("New() error:\n%+v", err)
+				}
+				helpers.StartStop(t, ch)
+				waitMigrations(t, ch)
+
+				if diff := helpers.Diff(firstSeenTables(ch), tc.Expected); diff != "" {
+					t.Fatalf("first seen tables (-got, +want):\n%s", diff)
+				}
+			}
+		})
+	}
 }
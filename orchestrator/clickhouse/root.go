@@ -6,10 +6,13 @@ package clickhouse
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 	"sort"
 	"sync"
 	"time"
 
+	"github.com/benbjohnson/clock"
 	"github.com/cenkalti/backoff/v4"
 	"github.com/itchyny/gojq"
 	"gopkg.in/tomb.v2"
@@ -34,6 +37,10 @@ type Component struct {
 	networkSourcesReady chan bool // closed when all network sources are ready
 	networkSourcesLock  sync.RWMutex
 	networkSources      map[string][]externalNetworkAttributes
+	maintenance         maintenanceState
+	changelogSubjects   []changelogSubject
+	schemaVersions      map[string]string // hash to protobuf definition
+	schemaVersionsLock  sync.RWMutex
 }
 
 // Dependencies define the dependencies of the ClickHouse configurator.
@@ -42,10 +49,18 @@ type Dependencies struct {
 	HTTP       *http.Component
 	ClickHouse *clickhousedb.Component
 	Schema     *schema.Component
+	Clock      clock.Clock
 }
 
+// firstSeenNameRegexp matches valid names for first seen tuples. They are
+// used to build table names.
+var firstSeenNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
 // New creates a new ClickHouse component.
 func New(r *reporter.Reporter, configuration Configuration, dependencies Dependencies) (*Component, error) {
+	if dependencies.Clock == nil {
+		dependencies.Clock = clock.New()
+	}
 	c := Component{
 		r:                   r,
 		d:                   &dependencies,
@@ -54,6 +69,21 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		migrationsOnce:      make(chan bool),
 		networkSourcesReady: make(chan bool),
 		networkSources:      make(map[string][]externalNetworkAttributes),
+		schemaVersions:      make(map[string]string),
+	}
+	for name, firstSeen := range c.config.FirstSeen {
+		if !firstSeenNameRegexp.MatchString(name) {
+			return nil, fmt.Errorf("first seen tuple %q: invalid name", name)
+		}
+		for _, key := range firstSeen.Dimensions {
+			column, ok := c.d.Schema.LookupColumnByKey(key)
+			if !ok || column.Disabled {
+				return nil, fmt.Errorf("first seen tuple %q: column %s is disabled", name, key)
+			}
+			if column.Key == schema.ColumnTimeReceived || column.ConsoleNotDimension {
+				return nil, fmt.Errorf("first seen tuple %q: column %s is not a dimension", name, key)
+			}
+		}
 	}
 	c.initMetrics()
 	if err := c.registerHTTPHandlers(); err != nil {
@@ -0,0 +1,137 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/helpers"
+)
+
+// schemaVersion is a version of the protobuf schema used to encode flows.
+type schemaVersion struct {
+	Hash       string
+	Definition string
+}
+
+// loadSchemaVersions loads the known versions of the protobuf schema from the
+// changelog. A raw table is created for each of them and their proto files
+// are installed by init.sh to let ClickHouse consume flows from inlets that
+// are not upgraded yet.
+func (c *Component) loadSchemaVersions(ctx context.Context) ([]clickhousedb.ChangelogEntry, error) {
+	entries, err := c.d.ClickHouse.Changelog(ctx, clickhousedb.ChangelogQuery{
+		Kinds:       []string{ChangelogKindSchemaVersion},
+		WithContent: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.schemaVersionsLock.Lock()
+	defer c.schemaVersionsLock.Unlock()
+	for _, entry := range entries {
+		if entry.Content != "" {
+			c.schemaVersions[entry.Subject] = entry.Content
+		}
+	}
+	return entries, nil
+}
+
+// recordSchemaVersions loads the known versions of the protobuf schema from
+// the changelog and records the current one if it is not known yet.
+func (c *Component) recordSchemaVersions(ctx context.Context) error {
+	entries, err := c.loadSchemaVersions(ctx)
+	if err != nil {
+		return err
+	}
+
+	hash := c.d.Schema.ProtobufMessageHash()
+	for _, entry := range entries {
+		if entry.Subject == hash {
+			return nil
+		}
+	}
+	c.r.Info().Str("hash", hash).Msg("record new schema version in changelog")
+	return c.d.ClickHouse.AddChangelogEntry(ctx, clickhousedb.ChangelogEntry{
+		Time:        c.d.Clock.Now(),
+		Kind:        ChangelogKindSchemaVersion,
+		Subject:     hash,
+		Author:      changelogAuthor(),
+		Hash:        hash,
+		Description: "schema version provisioned",
+		Content:     c.d.Schema.ProtobufDefinition(),
+	})
+}
+
+// knownSchemaVersions returns the known versions of the protobuf schema,
+// the current one first.
+func (c *Component) knownSchemaVersions() []schemaVersion {
+	current := c.d.Schema.ProtobufMessageHash()
+	versions := []schemaVersion{{
+		Hash:       current,
+		Definition: c.d.Schema.ProtobufDefinition(),
+	}}
+	c.schemaVersionsLock.RLock()
+	defer c.schemaVersionsLock.RUnlock()
+	hashes := make([]string, 0, len(c.schemaVersions))
+	for hash := range c.schemaVersions {
+		if hash != current {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Strings(hashes)
+	for _, hash := range hashes {
+		versions = append(versions, schemaVersion{
+			Hash:       hash,
+			Definition: c.schemaVersions[hash],
+		})
+	}
+	return versions
+}
+
+type schemaVersionHandlerInput struct {
+	Instance string `json:"instance" binding:"required"`
+	Hash     string `json:"hash" binding:"required"`
+}
+
+// schemaVersionHandlerFunc is used by inlets to check if the schema version
+// they use is provisioned in ClickHouse: the associated raw table should
+// exist. Otherwise, the flows they send are not consumed until the
+// orchestrator is upgraded.
+func (c *Component) schemaVersionHandlerFunc(gc *gin.Context) {
+	var input schemaVersionHandlerInput
+	if err := gc.ShouldBindJSON(&input); err != nil {
+		gc.JSON(http.StatusBadRequest, gin.H{"message": helpers.Capitalize(err.Error())})
+		return
+	}
+	select {
+	case <-c.migrationsDone:
+	default:
+		gc.JSON(http.StatusServiceUnavailable, gin.H{"message": "Database migration in progress."})
+		return
+	}
+	ctx := c.t.Context(gc.Request.Context())
+	tableName := fmt.Sprintf("flows_%s_raw", input.Hash)
+	provisioned, err := c.tableAlreadyExists(ctx, tableName, "name", tableName)
+	if err != nil {
+		c.r.Err(err).Msg("cannot check raw table")
+		gc.JSON(http.StatusInternalServerError, gin.H{"message": "Unable to check schema version."})
+		return
+	}
+	if !provisioned {
+		c.r.Warn().
+			Str("instance", input.Instance).
+			Str("hash", input.Hash).
+			Msg("inlet uses a schema version not provisioned in ClickHouse")
+	}
+	gc.JSON(http.StatusOK, gin.H{
+		"current":     input.Hash == c.d.Schema.ProtobufMessageHash(),
+		"provisioned": provisioned,
+	})
+}
@@ -0,0 +1,117 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package clickhouse
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/benbjohnson/clock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang/mock/gomock"
+
+	"akvorado/common/clickhousedb"
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+	"akvorado/common/schema"
+)
+
+func TestSchemaVersions(t *testing.T) {
+	r := reporter.NewMock(t)
+	chComponent, mockConn := clickhousedb.NewMock(t, r)
+	config := DefaultConfiguration()
+	config.SkipMigrations = true
+	h := http.NewMock(t, r)
+	mockClock := clock.NewMock()
+	c, err := New(r, config, Dependencies{
+		Daemon:     daemon.NewMock(t),
+		HTTP:       h,
+		Schema:     schema.NewMock(t),
+		ClickHouse: chComponent,
+		Clock:      mockClock,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	helpers.StartStop(t, c)
+	ctx := context.Background()
+	now := time.Date(2023, time.May, 10, 10, 0, 0, 0, time.UTC)
+	mockClock.Set(now)
+	hash := c.d.Schema.ProtobufMessageHash()
+
+	// An old version is known, the current one is recorded
+	gomock.InOrder(
+		mockConn.EXPECT().
+			Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"schema-version"}).
+			SetArg(1, []clickhousedb.ChangelogEntry{{
+				Subject: "1234",
+				Hash:    "1234",
+				Content: `syntax = "proto3";`,
+			}}).
+			Return(nil),
+		mockConn.EXPECT().
+			Exec(gomock.Any(), gomock.Any(),
+				now, "schema-version", hash, gomock.Any(), hash,
+				"schema version provisioned", "", c.d.Schema.ProtobufDefinition()).
+			Return(nil),
+	)
+	if err := c.recordSchemaVersions(ctx); err != nil {
+		t.Fatalf("recordSchemaVersions() error:\n%+v", err)
+	}
+
+	// Both versions are known, nothing is recorded
+	mockConn.EXPECT().
+		Select(gomock.Any(), gomock.Any(), gomock.Any(), []string{"schema-version"}).
+		SetArg(1, []clickhousedb.ChangelogEntry{
+			{Subject: "1234", Hash: "1234", Content: `syntax = "proto3";`},
+			{Subject: hash, Hash: hash, Content: c.d.Schema.ProtobufDefinition()},
+		}).
+		Return(nil)
+	if err := c.recordSchemaVersions(ctx); err != nil {
+		t.Fatalf("recordSchemaVersions() error:\n%+v", err)
+	}
+
+	got := c.knownSchemaVersions()
+	expected := []schemaVersion{
+		{Hash: hash, Definition: c.d.Schema.ProtobufDefinition()},
+		{Hash: "1234", Definition: `syntax = "proto3";`},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("knownSchemaVersions() (-got, +want):\n%s", diff)
+	}
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			Description: "init.sh with old schema versions",
+			URL:         "/api/v0/orchestrator/clickhouse/init.sh",
+			ContentType: "text/x-shellscript",
+			FirstLines: []string{
+				`#!/bin/sh`,
+				``,
+				`# Install Protobuf schemas`,
+				fmt.Sprintf(`cat > /var/lib/clickhouse/format_schemas/flow-%s.proto <<'EOPROTO'`, hash),
+			},
+		}, {
+			Description: "inlet reports schema version without hash",
+			Method:      "POST",
+			URL:         "/api/v0/orchestrator/clickhouse/schema-version",
+			JSONInput:   gin.H{"instance": "inlet1"},
+			StatusCode:  400,
+			JSONOutput: gin.H{
+				"message": "Key: 'schemaVersionHandlerInput.Hash' Error:Field validation for 'Hash' failed on the 'required' tag",
+			},
+		}, {
+			Description: "inlet reports schema version during migrations",
+			Method:      "POST",
+			URL:         "/api/v0/orchestrator/clickhouse/schema-version",
+			JSONInput:   gin.H{"instance": "inlet1", "hash": hash},
+			StatusCode:  503,
+			JSONOutput:  gin.H{"message": "Database migration in progress."},
+		},
+	})
+}
@@ -6,11 +6,14 @@ package clickhouse
 import (
 	"bufio"
 	"context"
+	"encoding/csv"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/netip"
+	"strings"
 
 	"github.com/mitchellh/mapstructure"
 )
@@ -30,7 +33,11 @@ func (c *Component) updateNetworkSource(ctx context.Context, name string, source
 		Proxy: http.ProxyFromEnvironment,
 	}}
 	req, err := http.NewRequestWithContext(ctx, "GET", source.URL, nil)
-	req.Header.Set("accept", "application/json")
+	if source.Format == "csv" {
+		req.Header.Set("accept", "text/csv")
+	} else {
+		req.Header.Set("accept", "application/json")
+	}
 	if err != nil {
 		l.Err(err).Msg("unable to build new request")
 		return 0, fmt.Errorf("unable to build new request: %w", err)
@@ -47,22 +54,48 @@ func (c *Component) updateNetworkSource(ctx context.Context, name string, source
 		return 0, err
 	}
 	reader := bufio.NewReader(resp.Body)
+	var results []externalNetworkAttributes
+	if source.Format == "csv" {
+		results, err = parseNetworkSourceCSV(reader)
+		if err != nil {
+			l.Err(err).Msg("cannot decode CSV output")
+			return 0, fmt.Errorf("cannot decode CSV output: %w", err)
+		}
+	} else {
+		results, err = parseNetworkSourceJSON(ctx, reader, source.Transform)
+		if err != nil {
+			l.Err(err).Msg("cannot parse JSON output")
+			return 0, err
+		}
+	}
+	if len(results) == 0 {
+		err := errors.New("empty results")
+		l.Error().Msg(err.Error())
+		return 0, err
+	}
+	c.networkSourcesLock.Lock()
+	c.networkSources[name] = results
+	c.networkSourcesLock.Unlock()
+	return len(results), nil
+}
+
+// parseNetworkSourceJSON decodes a JSON network source and transforms it
+// into network attributes using the provided jq query.
+func parseNetworkSourceJSON(ctx context.Context, reader io.Reader, transform TransformQuery) ([]externalNetworkAttributes, error) {
 	decoder := json.NewDecoder(reader)
 	var got interface{}
 	if err := decoder.Decode(&got); err != nil {
-		l.Err(err).Msg("cannot decode JSON output")
-		return 0, fmt.Errorf("cannot decode JSON output: %w", err)
+		return nil, fmt.Errorf("cannot decode JSON output: %w", err)
 	}
 	results := []externalNetworkAttributes{}
-	iter := source.Transform.Query.RunWithContext(ctx, got)
+	iter := transform.Query.RunWithContext(ctx, got)
 	for {
 		v, ok := iter.Next()
 		if !ok {
 			break
 		}
 		if err, ok := v.(error); ok {
-			l.Err(err).Msg("cannot execute jq filter")
-			return 0, fmt.Errorf("cannot execute jq filter: %w", err)
+			return nil, fmt.Errorf("cannot execute jq filter: %w", err)
 		}
 		var result externalNetworkAttributes
 		config := &mapstructure.DecoderConfig{
@@ -75,18 +108,70 @@ func (c *Component) updateNetworkSource(ctx context.Context, name string, source
 			panic(err)
 		}
 		if err := decoder.Decode(v); err != nil {
-			l.Err(err).Msgf("cannot map returned value for %#v", v)
-			return 0, fmt.Errorf("cannot map returned value: %w", err)
+			return nil, fmt.Errorf("cannot map returned value %#v: %w", v, err)
 		}
 		results = append(results, result)
 	}
-	if len(results) == 0 {
-		err := errors.New("empty results")
-		l.Error().Msg(err.Error())
-		return 0, err
+	return results, nil
+}
+
+// parseNetworkSourceCSV decodes a CSV network source. The first line is a
+// header naming the columns. The prefix column is named either "prefix" or
+// "network". Other recognized columns are "name", "role", "site", "region",
+// and "tenant". Unknown columns are ignored.
+func parseNetworkSourceCSV(reader io.Reader) ([]externalNetworkAttributes, error) {
+	r := csv.NewReader(reader)
+	r.FieldsPerRecord = -1
+	r.TrimLeadingSpace = true
+	r.Comment = '#'
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("cannot read CSV header: %w", err)
 	}
-	c.networkSourcesLock.Lock()
-	c.networkSources[name] = results
-	c.networkSourcesLock.Unlock()
-	return len(results), nil
+	columns := map[string]int{}
+	for idx, column := range header {
+		column = strings.ToLower(strings.TrimSpace(column))
+		if column == "network" {
+			column = "prefix"
+		}
+		if _, ok := columns[column]; !ok {
+			columns[column] = idx
+		}
+	}
+	if _, ok := columns["prefix"]; !ok {
+		return nil, errors.New("no prefix column in CSV header")
+	}
+	results := []externalNetworkAttributes{}
+	for {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		get := func(column string) string {
+			idx, ok := columns[column]
+			if !ok || idx >= len(record) {
+				return ""
+			}
+			return strings.TrimSpace(record[idx])
+		}
+		line, _ := r.FieldPos(0)
+		prefix, err := netip.ParsePrefix(get("prefix"))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		results = append(results, externalNetworkAttributes{
+			Prefix: prefix,
+			NetworkAttributes: NetworkAttributes{
+				Name:   get("name"),
+				Role:   get("role"),
+				Site:   get("site"),
+				Region: get("region"),
+				Tenant: get("tenant"),
+			},
+		})
+	}
+	return results, nil
 }
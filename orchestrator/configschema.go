@@ -0,0 +1,205 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package orchestrator
+
+import (
+	"encoding"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ConfigurationKey describes a configuration key.
+type ConfigurationKey struct {
+	// Key is the dotted path to the key. "[]" is used for list items and "*"
+	// for map keys.
+	Key     string      `json:"key"`
+	Type    string      `json:"type"`
+	Default interface{} `json:"default,omitempty"`
+	Doc     string      `json:"doc"`
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// DescribeConfiguration walks the provided default configuration and
+// returns the description of each key, using the `doc` struct tag.
+func DescribeConfiguration(defaultConfiguration interface{}) []ConfigurationKey {
+	keys := []ConfigurationKey{}
+	describeStruct(&keys, "", reflect.ValueOf(defaultConfiguration))
+	return keys
+}
+
+func describeStruct(keys *[]ConfigurationKey, prefix string, value reflect.Value) {
+	for value.Kind() == reflect.Pointer || value.Kind() == reflect.Interface {
+		if value.IsNil() {
+			return
+		}
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return
+	}
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		yamlTag := field.Tag.Get("yaml")
+		if yamlTag == "-" {
+			continue
+		}
+		if strings.Contains(field.Tag.Get("mapstructure"), ",squash") ||
+			strings.Contains(yamlTag, ",inline") {
+			describeStruct(keys, prefix, value.Field(i))
+			continue
+		}
+		key := configurationKeyName(field.Name)
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		describeValue(keys, key, field.Tag.Get("doc"), field.Type, value.Field(i))
+	}
+}
+
+// describeValue describes a key. value may be invalid when there is no
+// default value (for list items or map values).
+func describeValue(keys *[]ConfigurationKey, key string, doc string, t reflect.Type, value reflect.Value) {
+	description := ConfigurationKey{Key: key, Doc: doc}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		if value.IsValid() && !value.IsNil() {
+			value = value.Elem()
+		} else {
+			value = reflect.Value{}
+		}
+	}
+	switch {
+	case t == durationType:
+		description.Type = "duration"
+		if value.IsValid() && !value.IsZero() {
+			description.Default = value.Interface().(time.Duration).String()
+		}
+	case isText(t):
+		description.Type = "string"
+		if value.IsValid() && !value.IsZero() && t.Implements(textMarshalerType) {
+			if text, err := value.Interface().(encoding.TextMarshaler).MarshalText(); err == nil {
+				description.Default = string(text)
+			}
+		}
+	case t.Kind() == reflect.Struct:
+		description.Type = "object"
+		*keys = append(*keys, description)
+		describeStruct(keys, key, valueOrZero(t, value))
+		return
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Map) && isStruct(t.Elem()):
+		// Items are described using the first default item, if any.
+		item := reflect.Value{}
+		suffix := ".*"
+		description.Type = "map"
+		if t.Kind() == reflect.Slice {
+			suffix = "[]"
+			description.Type = "list"
+			if value.IsValid() && value.Len() > 0 {
+				item = value.Index(0)
+			}
+		}
+		*keys = append(*keys, description)
+		describeStruct(keys, key+suffix, valueOrZero(t.Elem(), item))
+		return
+	case t.Kind() == reflect.Slice || t.Kind() == reflect.Array:
+		description.Type = "list"
+	case t.Kind() == reflect.Map:
+		description.Type = "map"
+	case t.Kind() == reflect.Interface:
+		description.Type = "object"
+	case t.Kind() == reflect.Bool:
+		description.Type = "boolean"
+	case t.Kind() == reflect.String:
+		description.Type = "string"
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uintptr:
+		description.Type = "integer"
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		description.Type = "number"
+	default:
+		description.Type = t.Kind().String()
+	}
+	if description.Default == nil && description.Type != "object" &&
+		value.IsValid() && !value.IsZero() {
+		if value.Kind() != reflect.Slice && value.Kind() != reflect.Map || value.Len() > 0 {
+			description.Default = value.Interface()
+		}
+	}
+	*keys = append(*keys, description)
+}
+
+// isText tells if the provided type is configured from a string.
+func isText(t reflect.Type) bool {
+	return t.Implements(textUnmarshalerType) || reflect.PointerTo(t).Implements(textUnmarshalerType)
+}
+
+// isStruct tells if the provided type is a struct with keys to describe.
+func isStruct(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct && !isText(t)
+}
+
+// valueOrZero returns the provided value or the zero value for the provided
+// type if it is not valid.
+func valueOrZero(t reflect.Type, value reflect.Value) reflect.Value {
+	if value.IsValid() {
+		return value
+	}
+	return reflect.Zero(t)
+}
+
+// configurationKeyName turns a field name into a configuration key:
+// "TailRateLimit" becomes "tail-rate-limit". Acronyms are kept together.
+func configurationKeyName(name string) string {
+	for _, word := range []string{"ClickHouse", "GeoIP", "IPv4", "IPv6"} {
+		name = strings.ReplaceAll(name, word, strings.ToUpper(word[:1])+strings.ToLower(word[1:]))
+	}
+	runes := []rune(name)
+	var b strings.Builder
+	for i, r := range runes {
+		// Plural acronyms ("ASNs") are kept together.
+		plural := i+1 < len(runes) && runes[i+1] == 's' &&
+			(i+2 == len(runes) || unicode.IsUpper(runes[i+2]))
+		if i > 0 && unicode.IsUpper(r) &&
+			(!unicode.IsUpper(runes[i-1]) || i+1 < len(runes) && unicode.IsLower(runes[i+1]) && !plural) {
+			b.WriteRune('-')
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// configurationSchemaHandlerFunc returns the description of the configuration
+// of each registered service.
+func (c *Component) configurationSchemaHandlerFunc(gc *gin.Context) {
+	configurations := c.d.Daemon.Configurations()
+	names := make([]string, 0, len(configurations))
+	for name := range configurations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	services := make([]gin.H, 0, len(names))
+	for _, name := range names {
+		services = append(services, gin.H{
+			"service": name,
+			"keys":    DescribeConfiguration(configurations[name]),
+		})
+	}
+	gc.JSON(http.StatusOK, gin.H{"services": services})
+}
@@ -0,0 +1,139 @@
+// SPDX-FileCopyrightText: 2023 Free Mobile
+// SPDX-License-Identifier: AGPL-3.0-only
+
+package orchestrator
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"akvorado/common/daemon"
+	"akvorado/common/helpers"
+	"akvorado/common/http"
+	"akvorado/common/reporter"
+)
+
+type testInnerConfiguration struct {
+	Name    string `doc:"Name of the item"`
+	Enabled bool   `doc:"Enable the item"`
+}
+
+type EmbeddedTestConfiguration struct {
+	Listen string `doc:"Address to listen on"`
+}
+
+type testConfiguration struct {
+	EmbeddedTestConfiguration `mapstructure:",squash" yaml:",inline"`
+	Timeout                   time.Duration                     `doc:"Timeout for requests"`
+	Workers                   int                               `doc:"Number of workers"`
+	Ratio                     float64                           `doc:"Some ratio"`
+	Prefix                    netip.Prefix                      `doc:"Some prefix"`
+	Servers                   []string                          `doc:"List of servers"`
+	Items                     []testInnerConfiguration          `doc:"List of items"`
+	Named                     map[string]testInnerConfiguration `doc:"Named items"`
+	Inner                     testInnerConfiguration            `doc:"Inner configuration"`
+	Hidden                    string                            `yaml:"-"`
+	unexported                string
+}
+
+func TestDescribeConfiguration(t *testing.T) {
+	config := testConfiguration{
+		EmbeddedTestConfiguration: EmbeddedTestConfiguration{
+			Listen: "127.0.0.1:8080",
+		},
+		Timeout: 10 * time.Second,
+		Prefix:  netip.MustParsePrefix("192.0.2.0/24"),
+		Servers: []string{"127.0.0.1:9000"},
+		Items: []testInnerConfiguration{
+			{Name: "first", Enabled: true},
+		},
+		Inner:      testInnerConfiguration{Name: "inner"},
+		Hidden:     "hidden",
+		unexported: "unexported",
+	}
+	got := DescribeConfiguration(config)
+	expected := []ConfigurationKey{
+		{Key: "listen", Type: "string", Default: "127.0.0.1:8080", Doc: "Address to listen on"},
+		{Key: "timeout", Type: "duration", Default: "10s", Doc: "Timeout for requests"},
+		{Key: "workers", Type: "integer", Doc: "Number of workers"},
+		{Key: "ratio", Type: "number", Doc: "Some ratio"},
+		{Key: "prefix", Type: "string", Default: "192.0.2.0/24", Doc: "Some prefix"},
+		{Key: "servers", Type: "list", Default: []string{"127.0.0.1:9000"}, Doc: "List of servers"},
+		{Key: "items", Type: "list", Doc: "List of items"},
+		{Key: "items[].name", Type: "string", Default: "first", Doc: "Name of the item"},
+		{Key: "items[].enabled", Type: "boolean", Default: true, Doc: "Enable the item"},
+		{Key: "named", Type: "map", Doc: "Named items"},
+		{Key: "named.*.name", Type: "string", Doc: "Name of the item"},
+		{Key: "named.*.enabled", Type: "boolean", Doc: "Enable the item"},
+		{Key: "inner", Type: "object", Doc: "Inner configuration"},
+		{Key: "inner.name", Type: "string", Default: "inner", Doc: "Name of the item"},
+		{Key: "inner.enabled", Type: "boolean", Doc: "Enable the item"},
+	}
+	if diff := helpers.Diff(got, expected); diff != "" {
+		t.Fatalf("DescribeConfiguration() (-got, +want):\n%s", diff)
+	}
+}
+
+func TestConfigurationKeyName(t *testing.T) {
+	cases := []struct {
+		Input    string
+		Expected string
+	}{
+		{"Listen", "listen"},
+		{"TailRateLimit", "tail-rate-limit"},
+		{"SystemLogTTL", "system-log-ttl"},
+		{"OrchestratorURL", "orchestrator-url"},
+		{"ASNs", "asns"},
+		{"CollectASNs", "collect-asns"},
+		{"ASNProviders", "asn-providers"},
+		{"ClickHouse", "clickhouse"},
+		{"GeoIP", "geoip"},
+		{"DefaultSamplingRate", "default-sampling-rate"},
+		{"UseSrcAddrForExporterAddr", "use-src-addr-for-exporter-addr"},
+	}
+	for _, tc := range cases {
+		if got := configurationKeyName(tc.Input); got != tc.Expected {
+			t.Errorf("configurationKeyName(%q) == %q, expected %q", tc.Input, got, tc.Expected)
+		}
+	}
+}
+
+func TestConfigurationSchemaEndpoint(t *testing.T) {
+	r := reporter.NewMock(t)
+	h := http.NewMock(t, r)
+	d := daemon.NewMock(t)
+	_, err := New(r, DefaultConfiguration(), Dependencies{
+		Daemon: d,
+		HTTP:   h,
+	})
+	if err != nil {
+		t.Fatalf("New() error:\n%+v", err)
+	}
+	d.RegisterConfiguration("second", testInnerConfiguration{Name: "default"})
+	d.RegisterConfiguration("first", EmbeddedTestConfiguration{})
+
+	helpers.TestHTTPEndpoints(t, h.LocalAddr(), helpers.HTTPEndpointCases{
+		{
+			URL: "/api/v0/orchestrator/configuration/schema",
+			JSONOutput: gin.H{
+				"services": []gin.H{
+					{
+						"service": "first",
+						"keys": []gin.H{
+							{"key": "listen", "type": "string", "doc": "Address to listen on"},
+						},
+					}, {
+						"service": "second",
+						"keys": []gin.H{
+							{"key": "name", "type": "string", "default": "default", "doc": "Name of the item"},
+							{"key": "enabled", "type": "boolean", "doc": "Enable the item"},
+						},
+					},
+				},
+			},
+		},
+	})
+}
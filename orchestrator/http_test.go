@@ -6,6 +6,7 @@ package orchestrator
 import (
 	"testing"
 
+	"akvorado/common/daemon"
 	"akvorado/common/helpers"
 	"akvorado/common/http"
 	"akvorado/common/reporter"
@@ -15,7 +16,8 @@ func TestConfigurationEndpoint(t *testing.T) {
 	r := reporter.NewMock(t)
 	h := http.NewMock(t, r)
 	c, err := New(r, DefaultConfiguration(), Dependencies{
-		HTTP: h,
+		Daemon: daemon.NewMock(t),
+		HTTP:   h,
 	})
 	if err != nil {
 		t.Fatalf("New() error:\n%+v", err)
@@ -9,17 +9,17 @@ import "akvorado/common/kafka"
 type Configuration struct {
 	kafka.Configuration `mapstructure:",squash" yaml:",inline"`
 	// TopicConfiguration describes the topic configuration.
-	TopicConfiguration TopicConfiguration
+	TopicConfiguration TopicConfiguration `doc:"Configuration of the Kafka topic"`
 }
 
 // TopicConfiguration describes the configuration for a topic
 type TopicConfiguration struct {
 	// NumPartitions tells how many partitions should be used for the topic.
-	NumPartitions int32 `validate:"min=1"`
+	NumPartitions int32 `validate:"min=1" doc:"Number of partitions for the topic"`
 	// ReplicationFactor tells the replication factor for the topic.
-	ReplicationFactor int16 `validate:"min=1"`
+	ReplicationFactor int16 `validate:"min=1" doc:"Replication factor for the topic"`
 	// ConfigEntries is a map to specify the topic overrides. Non-listed overrides will be removed
-	ConfigEntries map[string]*string
+	ConfigEntries map[string]*string `doc:"Topic configuration overrides"`
 }
 
 // DefaultConfiguration represents the default configuration for the Kafka configurator.
@@ -10,6 +10,7 @@ import (
 
 	"github.com/Shopify/sarama"
 
+	"akvorado/common/discovery"
 	"akvorado/common/kafka"
 	"akvorado/common/reporter"
 	"akvorado/common/schema"
@@ -21,8 +22,9 @@ type Component struct {
 	d      Dependencies
 	config Configuration
 
-	kafkaConfig *sarama.Config
-	kafkaTopic  string
+	kafkaConfig    *sarama.Config
+	kafkaDiscovery *discovery.Resolver
+	kafkaTopic     string
 }
 
 // Dependencies are the dependencies for the Kafka component
@@ -36,6 +38,10 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 	if err != nil {
 		return nil, err
 	}
+	kafkaDiscovery, err := kafka.NewResolver(r, config.Configuration, kafkaConfig)
+	if err != nil {
+		return nil, err
+	}
 	if err := kafkaConfig.Validate(); err != nil {
 		return nil, fmt.Errorf("cannot validate Kafka configuration: %w", err)
 	}
@@ -45,8 +51,9 @@ func New(r *reporter.Reporter, config Configuration, dependencies Dependencies)
 		d:      dependencies,
 		config: config,
 
-		kafkaConfig: kafkaConfig,
-		kafkaTopic:  fmt.Sprintf("%s-%s", config.Topic, dependencies.Schema.ProtobufMessageHash()),
+		kafkaConfig:    kafkaConfig,
+		kafkaDiscovery: kafkaDiscovery,
+		kafkaTopic:     fmt.Sprintf("%s-%s", config.Topic, dependencies.Schema.ProtobufMessageHash()),
 	}, nil
 }
 
@@ -59,8 +66,14 @@ func (c *Component) Start() error {
 		c.r.Info().Msg("Kafka component stopped")
 	}()
 
+	// Resolve brokers once
+	if err := c.kafkaDiscovery.Start(); err != nil {
+		return err
+	}
+	defer c.kafkaDiscovery.Stop()
+
 	// Create topic
-	admin, err := sarama.NewClusterAdmin(c.config.Brokers, c.kafkaConfig)
+	admin, err := sarama.NewClusterAdmin(c.kafkaDiscovery.Addresses(), c.kafkaConfig)
 	if err != nil {
 		c.r.Err(err).
 			Str("brokers", strings.Join(c.config.Brokers, ",")).
@@ -7,6 +7,7 @@ package orchestrator
 import (
 	"sync"
 
+	"akvorado/common/daemon"
 	"akvorado/common/http"
 	"akvorado/common/reporter"
 )
@@ -23,7 +24,8 @@ type Component struct {
 
 // Dependencies define the dependencies of the broker.
 type Dependencies struct {
-	HTTP *http.Component
+	Daemon daemon.Component
+	HTTP   *http.Component
 }
 
 // ServiceType describes the different internal services
@@ -50,6 +52,7 @@ func New(r *reporter.Reporter, configuration Configuration, dependencies Depende
 		serviceConfigurations: map[ServiceType][]interface{}{},
 	}
 
+	c.d.HTTP.GinRouter.GET("/api/v0/orchestrator/configuration/schema", c.configurationSchemaHandlerFunc)
 	c.d.HTTP.GinRouter.GET("/api/v0/orchestrator/configuration/:service", c.configurationHandlerFunc)
 	c.d.HTTP.GinRouter.GET("/api/v0/orchestrator/configuration/:service/:index", c.configurationHandlerFunc)
 